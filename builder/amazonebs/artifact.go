@@ -2,9 +2,11 @@ package amazonebs
 
 import (
 	"fmt"
+	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/packer/packer"
 	"log"
+	"sort"
 	"strings"
 )
 
@@ -12,8 +14,8 @@ type artifact struct {
 	// A map of regions to AMI IDs.
 	amis map[string]string
 
-	// EC2 connection for performing API stuff.
-	conn *ec2.EC2
+	// The credentials to connect to EC2 in the regions with.
+	auth aws.Auth
 }
 
 func (*artifact) BuilderId() string {
@@ -27,29 +29,48 @@ func (*artifact) Files() []string {
 
 func (a *artifact) Id() string {
 	parts := make([]string, 0, len(a.amis))
-	for region, amiId := range a.amis {
-		parts = append(parts, fmt.Sprintf("%s:%s", region, amiId))
+	for _, region := range a.regions() {
+		parts = append(parts, fmt.Sprintf("%s:%s", region, a.amis[region]))
 	}
 
 	return strings.Join(parts, ",")
 }
 
 func (a *artifact) String() string {
+	return fmt.Sprintf("AMIs were created:\n\n%s", a.regionList())
+}
+
+// regionList returns the AMIs as lines of "region: id", sorted by region.
+func (a *artifact) regionList() string {
 	amiStrings := make([]string, 0, len(a.amis))
-	for region, id := range a.amis {
-		single := fmt.Sprintf("%s: %s", region, id)
+	for _, region := range a.regions() {
+		single := fmt.Sprintf("%s: %s", region, a.amis[region])
 		amiStrings = append(amiStrings, single)
 	}
 
-	return fmt.Sprintf("AMIs were created:\n\n%s", strings.Join(amiStrings, "\n"))
+	return strings.Join(amiStrings, "\n")
+}
+
+// regions returns the regions of the AMIs, sorted, so the AMIs are always
+// listed in the same order.
+func (a *artifact) regions() []string {
+	result := make([]string, 0, len(a.amis))
+	for region := range a.amis {
+		result = append(result, region)
+	}
+
+	sort.Strings(result)
+	return result
 }
 
 func (a *artifact) Destroy() error {
 	errors := make([]error, 0)
 
-	for _, imageId := range a.amis {
-		log.Printf("Deregistering image ID: %s", imageId)
-		if _, err := a.conn.DeregisterImage(imageId); err != nil {
+	for _, region := range a.regions() {
+		imageId := a.amis[region]
+		log.Printf("Deregistering image ID (%s): %s", region, imageId)
+		conn := ec2.New(a.auth, aws.Regions[region])
+		if _, err := conn.DeregisterImage(imageId); err != nil {
 			errors = append(errors, err)
 		}
 
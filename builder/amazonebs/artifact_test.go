@@ -22,7 +22,7 @@ func TestArtifactId(t *testing.T) {
 	amis["east"] = "foo"
 	amis["west"] = "bar"
 
-	a := &artifact{amis, nil}
+	a := &artifact{amis: amis}
 	result := a.Id()
 	assert.Equal(result, expected, "should match output")
 }
@@ -39,7 +39,7 @@ west: bar`
 	amis["east"] = "foo"
 	amis["west"] = "bar"
 
-	a := &artifact{amis, nil}
+	a := &artifact{amis: amis}
 	result := a.String()
 	assert.Equal(result, expected, "should match output")
 }
@@ -10,13 +10,15 @@ import (
 	"fmt"
 	"github.com/mitchellh/goamz/aws"
 	"github.com/mitchellh/goamz/ec2"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
 	"github.com/mitchellh/packer/packer"
 	"log"
+	"net"
+	"net/http"
 	"os"
-	"text/template"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,6 +29,14 @@ type config struct {
 	// Access information
 	AccessKey string `mapstructure:"access_key"`
 	SecretKey string `mapstructure:"secret_key"`
+	Token     string `mapstructure:"token"`
+
+	// The role that the builder assumes with the credentials above, whose
+	// temporary credentials it builds with instead.
+	AssumeRoleARN         string `mapstructure:"assume_role_arn"`
+	AssumeRoleExternalID  string `mapstructure:"assume_role_external_id"`
+	AssumeRoleSessionName string `mapstructure:"assume_role_session_name"`
+	AssumeRoleDuration    time.Duration
 
 	// Information for the source instance
 	Region       string
@@ -36,11 +46,74 @@ type config struct {
 	SSHPort      int    `mapstructure:"ssh_port"`
 	SSHTimeout   time.Duration
 
+	// The IAM instance profile that the source instance is launched with,
+	// so the provisioners can use the role of the profile.
+	IamInstanceProfile string `mapstructure:"iam_instance_profile"`
+
+	// The subnet of a VPC to launch the source instance in, instead of
+	// EC2-Classic, and the VPC that the temporary security group is
+	// created in. With security group IDs, those groups are used instead
+	// of a temporary one.
+	VpcId                    string   `mapstructure:"vpc_id"`
+	SubnetId                 string   `mapstructure:"subnet_id"`
+	SecurityGroupIds         []string `mapstructure:"security_group_ids"`
+	AssociatePublicIpAddress bool     `mapstructure:"associate_public_ip_address"`
+
+	// An existing key pair to launch the source instance with, and its
+	// private key, instead of a temporary key pair.
+	SSHKeyPairName    string `mapstructure:"ssh_keypair_name"`
+	SSHPrivateKeyFile string `mapstructure:"ssh_private_key_file"`
+
+	// The addresses that the temporary security group allows access to the
+	// communicator from, as a CIDR block. By default it is only the
+	// address that Packer connects from.
+	TemporarySGSourceCidr string `mapstructure:"temporary_security_group_source_cidr"`
+
+	// Whether to connect to the private IP address of the instance rather
+	// than its public address, such as from within the VPC.
+	SSHPrivateIp bool `mapstructure:"ssh_private_ip"`
+
+	// The most to pay an hour for the source instance, which makes it a
+	// spot instance, or "auto" to pay the current spot price of the
+	// product, such as "Linux/UNIX". By default it is an on-demand one.
+	SpotPrice            string `mapstructure:"spot_price"`
+	SpotPriceAutoProduct string `mapstructure:"spot_price_auto_product"`
+
 	// Configuration of the resulting AMI
 	AMIName string `mapstructure:"ami_name"`
 
-	PackerDebug   bool   `mapstructure:"packer_debug"`
-	RawSSHTimeout string `mapstructure:"ssh_timeout"`
+	// The other regions to copy the AMI to, and whether to copy it to all
+	// of them at once rather than one after another.
+	AMIRegions      []string `mapstructure:"ami_regions"`
+	AMICopyParallel bool     `mapstructure:"ami_copy_parallel"`
+
+	// Whether to encrypt the volumes of the AMI, and the KMS key to
+	// encrypt them with instead of the default key of EBS.
+	EncryptBoot bool   `mapstructure:"encrypt_boot"`
+	KmsKeyId    string `mapstructure:"kms_key_id"`
+
+	// The tags of the AMI, in addition to the metadata of the build, and
+	// of the snapshots that back it.
+	AMITags      map[string]string `mapstructure:"tags"`
+	SnapshotTags map[string]string `mapstructure:"snapshot_tags"`
+
+	common.SSHConfig          `mapstructure:",squash"`
+	common.WinRMConfig        `mapstructure:",squash"`
+	common.CommunicatorConfig `mapstructure:",squash"`
+
+	PackerBuildName       string              `mapstructure:"packer_build_name"`
+	PackerDebug           bool                `mapstructure:"packer_debug"`
+	PackerForce           bool                `mapstructure:"packer_force"`
+	PackerOnError         string              `mapstructure:"packer_on_error"`
+	PackerResume          bool                `mapstructure:"packer_resume"`
+	PackerBuildVars       map[string]string   `mapstructure:"packer_build_variables"`
+	PackerUserVars        map[string]string   `mapstructure:"packer_user_variables"`
+	PackerProxy           *packer.ProxyConfig `mapstructure:"packer_proxy"`
+	PackerMetadata        map[string]string   `mapstructure:"packer_metadata"`
+	RawSSHTimeout         string              `mapstructure:"ssh_timeout"`
+	RawAssumeRoleDuration string              `mapstructure:"assume_role_duration"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type Builder struct {
@@ -51,11 +124,87 @@ type Builder struct {
 func (b *Builder) Prepare(raws ...interface{}) error {
 	var err error
 
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &b.config)
+	if err := packer.DecodeConfig(&b.config, raws...); err != nil {
+		return err
+	}
+
+	// Accumulate any errors
+	errs := make([]error, 0)
+
+	// Process the templates before the defaults, so that the values of
+	// environment variables are used as they are.
+	b.config.tpl = &packer.ConfigTemplate{
+		UserVars:  b.config.PackerUserVars,
+		BuildVars: b.config.PackerBuildVars,
+	}
+	templates := map[string]*string{
+		"access_key":               &b.config.AccessKey,
+		"secret_key":               &b.config.SecretKey,
+		"token":                    &b.config.Token,
+		"assume_role_arn":          &b.config.AssumeRoleARN,
+		"assume_role_external_id":  &b.config.AssumeRoleExternalID,
+		"assume_role_session_name": &b.config.AssumeRoleSessionName,
+		"assume_role_duration":     &b.config.RawAssumeRoleDuration,
+		"iam_instance_profile":     &b.config.IamInstanceProfile,
+		"vpc_id":                   &b.config.VpcId,
+		"subnet_id":                &b.config.SubnetId,
+		"kms_key_id":               &b.config.KmsKeyId,
+		"ssh_keypair_name":         &b.config.SSHKeyPairName,
+		"ssh_private_key_file":     &b.config.SSHPrivateKeyFile,
+		"region":                   &b.config.Region,
+		"source_ami":               &b.config.SourceAmi,
+		"instance_type":            &b.config.InstanceType,
+		"ssh_username":             &b.config.SSHUsername,
+		"ssh_timeout":              &b.config.RawSSHTimeout,
+		"spot_price":               &b.config.SpotPrice,
+		"spot_price_auto_product":  &b.config.SpotPriceAutoProduct,
+
+		"temporary_security_group_source_cidr": &b.config.TemporarySGSourceCidr,
+	}
+
+	for n, ptr := range templates {
+		*ptr, err = b.config.tpl.Process(*ptr, nil)
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	for i, region := range b.config.AMIRegions {
+		b.config.AMIRegions[i], err = b.config.tpl.Process(region, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing ami_regions[%d]: %s", i, err))
+		}
+	}
+
+	for i, id := range b.config.SecurityGroupIds {
+		b.config.SecurityGroupIds[i], err = b.config.tpl.Process(id, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing security_group_ids[%d]: %s", i, err))
+		}
+	}
+
+	// The keys of tags are templates as well as their values.
+	tagMaps := map[string]*map[string]string{
+		"tags":          &b.config.AMITags,
+		"snapshot_tags": &b.config.SnapshotTags,
+	}
+
+	for n, tags := range tagMaps {
+		processed := make(map[string]string)
+		for k, v := range *tags {
+			key, err := b.config.tpl.Process(k, nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Error processing %s key %s: %s", n, k, err))
+				continue
+			}
+
+			processed[key], err = b.config.tpl.Process(v, nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Error processing %s.%s: %s", n, k, err))
+			}
 		}
+
+		*tags = processed
 	}
 
 	if b.config.AccessKey == "" {
@@ -74,6 +223,22 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		b.config.SecretKey = os.Getenv("AWS_SECRET_KEY")
 	}
 
+	if b.config.Token == "" {
+		b.config.Token = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	if b.config.Token == "" {
+		b.config.Token = os.Getenv("AWS_SECURITY_TOKEN")
+	}
+
+	if b.config.AssumeRoleSessionName == "" {
+		b.config.AssumeRoleSessionName = "packer"
+	}
+
+	if b.config.RawAssumeRoleDuration == "" {
+		b.config.RawAssumeRoleDuration = "1h"
+	}
+
 	if b.config.SSHPort == 0 {
 		b.config.SSHPort = 22
 	}
@@ -82,8 +247,9 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		b.config.RawSSHTimeout = "1m"
 	}
 
-	// Accumulate any errors
-	errs := make([]error, 0)
+	errs = append(errs, b.config.SSHConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.WinRMConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.CommunicatorConfig.Prepare(b.config.tpl, "ssh", "winrm", "none")...)
 
 	if b.config.AccessKey == "" {
 		errs = append(errs, errors.New("An access_key must be specified"))
@@ -93,6 +259,23 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		errs = append(errs, errors.New("A secret_key must be specified"))
 	}
 
+	if b.config.AssumeRoleARN != "" {
+		b.config.AssumeRoleDuration, err = time.ParseDuration(b.config.RawAssumeRoleDuration)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed parsing assume_role_duration: %s", err))
+		} else if b.config.AssumeRoleDuration < assumeRoleMinDuration ||
+			b.config.AssumeRoleDuration > assumeRoleMaxDuration {
+			errs = append(errs, fmt.Errorf(
+				"assume_role_duration must be between %s and %s",
+				assumeRoleMinDuration, assumeRoleMaxDuration))
+		}
+
+		if !assumeRoleSessionNameRe.MatchString(b.config.AssumeRoleSessionName) {
+			errs = append(errs, errors.New(
+				"assume_role_session_name must be 2 to 64 letters, digits or any of _+=,.@-"))
+		}
+	}
+
 	if b.config.SourceAmi == "" {
 		errs = append(errs, errors.New("A source_ami must be specified"))
 	}
@@ -107,19 +290,98 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		errs = append(errs, fmt.Errorf("Unknown region: %s", b.config.Region))
 	}
 
-	if b.config.SSHUsername == "" {
+	for i, id := range b.config.SecurityGroupIds {
+		if id == "" {
+			errs = append(errs, fmt.Errorf("security_group_ids[%d] must not be empty", i))
+		}
+	}
+
+	// The temporary security group must be in the VPC of the subnet
+	if b.config.SubnetId != "" && b.config.VpcId == "" && len(b.config.SecurityGroupIds) == 0 {
+		errs = append(errs, errors.New(
+			"vpc_id must be specified with subnet_id, unless security_group_ids are"))
+	}
+
+	if b.config.SSHKeyPairName != "" && b.config.SSHPrivateKeyFile == "" {
+		errs = append(errs, errors.New("ssh_private_key_file must be specified with ssh_keypair_name"))
+	}
+
+	if b.config.SSHPrivateKeyFile != "" {
+		if b.config.SSHKeyPairName == "" {
+			errs = append(errs, errors.New("ssh_keypair_name must be specified with ssh_private_key_file"))
+		}
+
+		if _, err := os.Stat(b.config.SSHPrivateKeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_private_key_file is invalid: %s", err))
+		}
+	}
+
+	if b.config.TemporarySGSourceCidr != "" {
+		if len(b.config.SecurityGroupIds) > 0 {
+			errs = append(errs, errors.New(
+				"temporary_security_group_source_cidr can't be used with security_group_ids"))
+		}
+
+		if _, _, err := net.ParseCIDR(b.config.TemporarySGSourceCidr); err != nil {
+			errs = append(errs, fmt.Errorf("temporary_security_group_source_cidr is invalid: %s", err))
+		}
+	}
+
+	if b.config.AssociatePublicIpAddress && b.config.SubnetId == "" {
+		errs = append(errs, errors.New(
+			"associate_public_ip_address requires subnet_id"))
+	}
+
+	seen := map[string]bool{b.config.Region: true}
+	for _, region := range b.config.AMIRegions {
+		if _, ok := aws.Regions[region]; !ok {
+			errs = append(errs, fmt.Errorf("Unknown region in ami_regions: %s", region))
+		} else if seen[region] {
+			errs = append(errs, fmt.Errorf(
+				"ami_regions must not repeat a region or contain the build region: %s", region))
+		}
+
+		seen[region] = true
+	}
+
+	if b.config.KmsKeyId != "" && !b.config.EncryptBoot {
+		errs = append(errs, errors.New("kms_key_id requires encrypt_boot"))
+	}
+
+	if b.config.Communicator == "ssh" && b.config.SSHUsername == "" {
 		errs = append(errs, errors.New("An ssh_username must be specified"))
 	}
 
+	if b.config.Communicator == "winrm" && b.config.WinRMUsername == "" {
+		errs = append(errs, errors.New("A winrm_username must be specified"))
+	}
+
 	b.config.SSHTimeout, err = time.ParseDuration(b.config.RawSSHTimeout)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("Failed parsing ssh_timeout: %s", err))
 	}
 
+	if b.config.SpotPrice == spotPriceAuto {
+		valid := false
+		for _, product := range spotPriceProducts {
+			valid = valid || b.config.SpotPriceAutoProduct == product
+		}
+
+		if !valid {
+			errs = append(errs, fmt.Errorf(
+				"spot_price_auto_product must be one of: %s",
+				strings.Join(spotPriceProducts, ", ")))
+		}
+	} else if b.config.SpotPrice != "" {
+		if price, err := strconv.ParseFloat(b.config.SpotPrice, 64); err != nil || price <= 0 {
+			errs = append(errs, errors.New("spot_price must be a price greater than zero, or \"auto\""))
+		}
+	}
+
 	if b.config.AMIName == "" {
 		errs = append(errs, errors.New("ami_name must be specified"))
 	} else {
-		_, err = template.New("ami").Parse(b.config.AMIName)
+		err = b.config.tpl.Validate(b.config.AMIName)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("Failed parsing ami_name: %s", err))
 		}
@@ -139,53 +401,102 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		panic("region not found")
 	}
 
-	auth := aws.Auth{b.config.AccessKey, b.config.SecretKey}
+	// goamz calls EC2 with the default HTTP client. The builder runs in
+	// its own plugin process, so it can send those calls through the proxy
+	// of the template.
+	http.DefaultTransport = b.config.PackerProxy.Transport()
+
+	auth := aws.Auth{
+		AccessKey: b.config.AccessKey,
+		SecretKey: b.config.SecretKey,
+		Token:     b.config.Token,
+	}
+
+	var role *assumedRole
+	if b.config.AssumeRoleARN != "" {
+		ui.Say(fmt.Sprintf("Assuming role: %s", b.config.AssumeRoleARN))
+
+		role = &assumedRole{auth: auth, config: b.config, renewed: time.Now()}
+
+		var expiration time.Time
+		var err error
+		auth, expiration, err = assumeRole(role.auth, b.config)
+		if err != nil {
+			return nil, fmt.Errorf("Error assuming role: %s", err)
+		}
+
+		ui.Message(fmt.Sprintf(
+			"The credentials of the role expire at %s, and are renewed before each step.",
+			expiration.Local().Format(time.RFC1123)))
+	}
+
 	ec2conn := ec2.New(auth, region)
 
+	// Load what an earlier build that didn't finish left running, if we
+	// resume it
+	checkpoint, err := common.LoadCheckpoint(
+		common.CheckpointPath(b.config.PackerBuildName), b.config.PackerResume)
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup the state bag and initial state for the steps
 	state := make(map[string]interface{})
-	state["config"] = b.config
-	state["ec2"] = ec2conn
-	state["hook"] = hook
-	state["ui"] = ui
+	bag := newStepState(state)
+	bag.SetCheckpoint(checkpoint)
+	bag.setConfig(b.config)
+	bag.setEC2Conn(ec2conn)
+	bag.setAuth(auth)
+	bag.SetHook(hook)
+	bag.SetUi(ui)
 
 	// Build the steps
 	steps := []multistep.Step{
-		&stepKeyPair{},
+		&stepKeyPair{
+			Debug:        b.config.PackerDebug,
+			DebugKeyPath: fmt.Sprintf("ec2_%s.pem", b.config.PackerBuildName),
+		},
 		&stepSecurityGroup{},
 		&stepRunSourceInstance{},
 		&stepConnectSSH{},
-		&stepProvision{},
+		&common.StepProvision{BuildName: b.config.PackerBuildName},
 		&stepStopInstance{},
 		&stepCreateAMI{},
+		&stepEncryptBoot{},
+		&stepCopyAMI{},
+		&stepTagAMI{},
 	}
 
-	// Run!
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
+	if role != nil {
+		for i, step := range steps {
+			steps[i] = &renewRoleStep{Step: step, role: role}
 		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
 	}
 
+	// Run!
+	b.runner = common.NewRunner(steps, b.config.PackerDebug, b.config.PackerOnError, ui)
+
 	b.runner.Run(state)
 
 	// If there was an error, return that
-	if rawErr, ok := state["error"]; ok {
-		return nil, rawErr.(error)
+	if err := bag.Error(); err != nil {
+		return nil, err
+	}
+
+	// The build finished, so there is nothing left to resume
+	if err := checkpoint.Remove(); err != nil {
+		log.Printf("[WARN] Error removing checkpoint: %s", err)
 	}
 
 	// If there are no AMIs, then just return
-	if _, ok := state["amis"]; !ok {
+	if !bag.Has("amis") {
 		return nil, nil
 	}
 
 	// Build the artifact and return it
 	artifact := &artifact{
-		amis: state["amis"].(map[string]string),
-		conn: ec2conn,
+		amis: bag.amis(),
+		auth: bag.auth(),
 	}
 
 	return artifact, nil
@@ -1,9 +1,13 @@
 package amazonebs
 
 import (
+	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -13,6 +17,8 @@ func init() {
 	os.Setenv("AWS_ACCESS_KEY", "")
 	os.Setenv("AWS_SECRET_ACCESS_KEY", "")
 	os.Setenv("AWS_SECRET_KEY", "")
+	os.Setenv("AWS_SESSION_TOKEN", "")
+	os.Setenv("AWS_SECURITY_TOKEN", "")
 }
 
 func testConfig() map[string]interface{} {
@@ -124,6 +130,114 @@ func TestBuilderPrepare_AMIName(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_AMIRegions(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test good
+	config["ami_regions"] = []string{"us-west-1", "eu-west-1"}
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if !reflect.DeepEqual(b.config.AMIRegions, []string{"us-west-1", "eu-west-1"}) {
+		t.Errorf("invalid: %#v", b.config.AMIRegions)
+	}
+
+	// Test bad regions
+	bad := [][]string{
+		[]string{"us-west-1", "not-a-region"},
+		[]string{"us-west-1", "us-west-1"},
+		[]string{"us-east-1"},
+	}
+
+	for _, regions := range bad {
+		config["ami_regions"] = regions
+		b = Builder{}
+		err = b.Prepare(config)
+		if err == nil {
+			t.Fatalf("should have error: %#v", regions)
+		}
+	}
+}
+
+func TestBuilderPrepare_AssumeRole(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test the defaults
+	config["assume_role_arn"] = "arn:aws:iam::123456789012:role/packer"
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.AssumeRoleSessionName != "packer" {
+		t.Errorf("invalid: %s", b.config.AssumeRoleSessionName)
+	}
+
+	if b.config.AssumeRoleDuration != time.Hour {
+		t.Errorf("invalid: %s", b.config.AssumeRoleDuration)
+	}
+
+	// Test a good duration
+	config["assume_role_duration"] = "2h"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.AssumeRoleDuration != 2*time.Hour {
+		t.Errorf("invalid: %s", b.config.AssumeRoleDuration)
+	}
+
+	// Test bad durations
+	for _, duration := range []string{"bad", "5m", "24h"} {
+		config["assume_role_duration"] = duration
+		b = Builder{}
+		err = b.Prepare(config)
+		if err == nil {
+			t.Fatalf("should have error: %s", duration)
+		}
+	}
+
+	// Test a bad session name
+	delete(config, "assume_role_duration")
+	config["assume_role_session_name"] = "no spaces"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_EncryptBoot(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test good
+	config["encrypt_boot"] = true
+	config["kms_key_id"] = "alias/packer"
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if !b.config.EncryptBoot || b.config.KmsKeyId != "alias/packer" {
+		t.Errorf("invalid: %#v %s", b.config.EncryptBoot, b.config.KmsKeyId)
+	}
+
+	// Test a key without encryption
+	delete(config, "encrypt_boot")
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestBuilderPrepare_InstanceType(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -229,6 +343,30 @@ func TestBuilderPrepare_SecretKey(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_SecurityGroupIds(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test good
+	config["security_group_ids"] = []string{"sg-1", "sg-2"}
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if !reflect.DeepEqual(b.config.SecurityGroupIds, []string{"sg-1", "sg-2"}) {
+		t.Errorf("invalid: %#v", b.config.SecurityGroupIds)
+	}
+
+	// Test bad
+	config["security_group_ids"] = []string{"sg-1", ""}
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestBuilderPrepare_SourceAmi(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -253,6 +391,127 @@ func TestBuilderPrepare_SourceAmi(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_SpotPrice(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test good
+	for _, price := range []string{"", "0.05", "auto"} {
+		config["spot_price"] = price
+		config["spot_price_auto_product"] = "Linux/UNIX"
+		b = Builder{}
+		err := b.Prepare(config)
+		if err != nil {
+			t.Fatalf("should not have error for '%s': %s", price, err)
+		}
+
+		if b.config.SpotPrice != price {
+			t.Errorf("invalid: %s", b.config.SpotPrice)
+		}
+	}
+
+	// Test bad
+	for _, price := range []string{"cheap", "0", "-1"} {
+		config["spot_price"] = price
+		b = Builder{}
+		err := b.Prepare(config)
+		if err == nil {
+			t.Fatalf("should have error for '%s'", price)
+		}
+	}
+
+	// The product must be known to find the price
+	config["spot_price"] = "auto"
+	for _, product := range []string{"", "Linux"} {
+		config["spot_price_auto_product"] = product
+		b = Builder{}
+		err := b.Prepare(config)
+		if err == nil {
+			t.Fatalf("should have error for '%s'", product)
+		}
+	}
+}
+
+func TestBuilderPrepare_SSHFileTransferMethod(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test default
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.SSHFileTransferMethod != "scp" {
+		t.Errorf("invalid: %s", b.config.SSHFileTransferMethod)
+	}
+
+	// Test set
+	config["ssh_file_transfer_method"] = "sftp"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.SSHFileTransferMethod != "sftp" {
+		t.Errorf("invalid: %s", b.config.SSHFileTransferMethod)
+	}
+
+	// Test bad
+	config["ssh_file_transfer_method"] = "ftp"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_SSHKeyPairName(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Close()
+
+	// Test good
+	config["ssh_keypair_name"] = "packer"
+	config["ssh_private_key_file"] = tf.Name()
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test a missing private key file
+	config["ssh_private_key_file"] = tf.Name() + ".missing"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test a key pair without a private key
+	delete(config, "ssh_private_key_file")
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test a private key without a key pair
+	delete(config, "ssh_keypair_name")
+	config["ssh_private_key_file"] = tf.Name()
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestBuilderPrepare_SSHPort(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -321,4 +580,199 @@ func TestBuilderPrepare_SSHUsername(t *testing.T) {
 	if err == nil {
 		t.Fatal("should have error")
 	}
+
+	// Test not needed with WinRM
+	config["communicator"] = "winrm"
+	config["winrm_username"] = "Administrator"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test that WinRM needs a username of its own
+	delete(config, "winrm_username")
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_SubnetId(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test without a VPC for the temporary security group
+	config["subnet_id"] = "subnet-1"
+	err := b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test with a VPC
+	config["vpc_id"] = "vpc-1"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test with security groups instead
+	delete(config, "vpc_id")
+	config["security_group_ids"] = []string{"sg-1"}
+	config["associate_public_ip_address"] = true
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test a public IP address without a subnet
+	delete(config, "subnet_id")
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_UserVariables(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["source_ami"] = `{{user "ami"}}`
+	config["packer_user_variables"] = map[string]string{"ami": "ami-12345"}
+
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.SourceAmi != "ami-12345" {
+		t.Errorf("invalid: %s", b.config.SourceAmi)
+	}
+
+	// Test an unknown variable
+	config["source_ami"] = `{{user "bar"}}`
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_Metadata(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["packer_metadata"] = map[string]interface{}{"version": "1.2", "owner": "ops"}
+
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	tags := amiTags(b.config)
+	expected := []ec2.Tag{{Key: "owner", Value: "ops"}, {Key: "version", Value: "1.2"}}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("bad: %#v", tags)
+	}
+}
+
+func TestBuilderPrepare_Tags(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["packer_metadata"] = map[string]interface{}{"version": "1.2", "owner": "ops"}
+	config["packer_user_variables"] = map[string]string{"team": "web"}
+	config["tags"] = map[string]interface{}{
+		"owner":           `{{user "team"}}`,
+		`{{user "team"}}`: "yes",
+	}
+	config["snapshot_tags"] = map[string]interface{}{"built-by": "packer"}
+
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// The tags of the configuration override the metadata
+	tags := amiTags(b.config)
+	expected := []ec2.Tag{
+		{Key: "owner", Value: "web"},
+		{Key: "version", Value: "1.2"},
+		{Key: "web", Value: "yes"},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("bad: %#v", tags)
+	}
+
+	if !reflect.DeepEqual(b.config.SnapshotTags, map[string]string{"built-by": "packer"}) {
+		t.Fatalf("bad: %#v", b.config.SnapshotTags)
+	}
+
+	// Test an unknown variable
+	config["snapshot_tags"] = map[string]interface{}{"owner": `{{user "bar"}}`}
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_TemporarySecurityGroupSourceCidr(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test good
+	config["temporary_security_group_source_cidr"] = "10.0.0.0/16"
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test bad
+	config["temporary_security_group_source_cidr"] = "10.0.0.1"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test with security groups
+	config["temporary_security_group_source_cidr"] = "10.0.0.0/16"
+	config["security_group_ids"] = []string{"sg-1"}
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestBuilderPrepare_Token(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test good
+	config["token"] = "foo"
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.Token != "foo" {
+		t.Errorf("invalid: %s", b.config.Token)
+	}
+
+	// Test from the environment
+	delete(config, "token")
+	os.Setenv("AWS_SESSION_TOKEN", "bar")
+	defer os.Setenv("AWS_SESSION_TOKEN", "")
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.Token != "bar" {
+		t.Errorf("invalid: %s", b.config.Token)
+	}
 }
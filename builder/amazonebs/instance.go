@@ -25,8 +25,12 @@ func waitForState(ec2conn *ec2.EC2, originalInstance *ec2.Instance, pending []st
 			return
 		}
 
+		// A new instance isn't found for a moment
 		var resp *ec2.InstancesResp
-		resp, err = ec2conn.Instances([]string{i.InstanceId}, ec2.NewFilter())
+		err = retryNotFound(func() (err error) {
+			resp, err = ec2conn.Instances([]string{i.InstanceId}, ec2.NewFilter())
+			return
+		})
 		if err != nil {
 			return
 		}
@@ -37,3 +41,30 @@ func waitForState(ec2conn *ec2.EC2, originalInstance *ec2.Instance, pending []st
 
 	return
 }
+
+// instanceAddress returns the address to connect to the instance at: its
+// private IP address with ssh_private_ip, or else its public DNS name, or
+// its public IP address if it has no DNS name, as instances in VPCs often
+// don't.
+func instanceAddress(config config, instance *ec2.Instance) string {
+	if config.SSHPrivateIp {
+		return instance.PrivateIPAddress
+	}
+
+	if instance.DNSName != "" {
+		return instance.DNSName
+	}
+
+	return instance.IPAddress
+}
+
+// securityGroups returns the security groups with the IDs, which is how
+// groups are given to EC2 for instances in VPCs as well as in EC2-Classic.
+func securityGroups(ids []string) []ec2.SecurityGroup {
+	result := make([]ec2.SecurityGroup, len(ids))
+	for i, id := range ids {
+		result[i] = ec2.SecurityGroup{Id: id}
+	}
+
+	return result
+}
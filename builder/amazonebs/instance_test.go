@@ -0,0 +1,28 @@
+package amazonebs
+
+import (
+	"github.com/mitchellh/goamz/ec2"
+	"testing"
+)
+
+func TestInstanceAddress(t *testing.T) {
+	instance := &ec2.Instance{
+		DNSName:          "ec2-1-2-3-4.compute-1.amazonaws.com",
+		IPAddress:        "1.2.3.4",
+		PrivateIPAddress: "10.0.0.4",
+	}
+
+	if address := instanceAddress(config{}, instance); address != instance.DNSName {
+		t.Fatalf("bad: %s", address)
+	}
+
+	if address := instanceAddress(config{SSHPrivateIp: true}, instance); address != "10.0.0.4" {
+		t.Fatalf("bad: %s", address)
+	}
+
+	// Instances in VPCs may have no DNS name
+	instance.DNSName = ""
+	if address := instanceAddress(config{}, instance); address != "1.2.3.4" {
+		t.Fatalf("bad: %s", address)
+	}
+}
@@ -0,0 +1,81 @@
+package amazonebs
+
+import (
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/packer/builder/common"
+	"strings"
+)
+
+// The codes of EC2 errors for requests that were throttled, or that EC2
+// couldn't serve right then, which succeed when tried again later. EC2
+// rejects these requests before it does anything.
+var ec2ThrottledCodes = map[string]bool{
+	"RequestLimitExceeded": true,
+	"ServiceUnavailable":   true,
+	"Throttling":           true,
+	"Unavailable":          true,
+}
+
+// isThrottled says whether the error is from a request that EC2 didn't
+// serve because there were too many, or it was busy, or that failed
+// inside EC2.
+func isThrottled(err error) bool {
+	ec2err, ok := err.(*ec2.Error)
+	return ok && (ec2ThrottledCodes[ec2err.Code] || ec2err.Code == "InternalError")
+}
+
+// isThrottledCreate is isThrottled for requests that create something,
+// such as an instance or an AMI. EC2 can fail with an internal error after
+// it already created it, and trying again would create another one that
+// nothing cleans up, so those requests aren't tried again.
+func isThrottledCreate(err error) bool {
+	ec2err, ok := err.(*ec2.Error)
+	return ok && ec2ThrottledCodes[ec2err.Code]
+}
+
+// isNotFoundYet says whether the error is for something that EC2 doesn't
+// know about yet. EC2 is eventually consistent, so something that was
+// just created, such as an instance or a security group, isn't found for
+// a few seconds.
+func isNotFoundYet(err error) bool {
+	return isThrottled(err) || isNotFound(err)
+}
+
+// isNotFoundYetCreate is isNotFoundYet for requests that create something.
+func isNotFoundYetCreate(err error) bool {
+	return isThrottledCreate(err) || isNotFound(err)
+}
+
+func isNotFound(err error) bool {
+	ec2err, ok := err.(*ec2.Error)
+	return ok && strings.HasSuffix(ec2err.Code, ".NotFound")
+}
+
+// retryThrottled calls the EC2 API with the function, trying again if the
+// request is throttled.
+func retryThrottled(f func() error) error {
+	r := &common.Retry{ShouldRetry: isThrottled}
+	return r.Run(f)
+}
+
+// retryNotFound calls the EC2 API with the function, trying again if the
+// request is throttled, or refers to something EC2 doesn't know about yet.
+func retryNotFound(f func() error) error {
+	r := &common.Retry{ShouldRetry: isNotFoundYet}
+	return r.Run(f)
+}
+
+// retryCreate calls the EC2 API with the function, which creates
+// something, trying again if the request is throttled.
+func retryCreate(f func() error) error {
+	r := &common.Retry{ShouldRetry: isThrottledCreate}
+	return r.Run(f)
+}
+
+// retryCreateNotFound calls the EC2 API with the function, which creates
+// something, trying again if the request is throttled, or refers to
+// something EC2 doesn't know about yet.
+func retryCreateNotFound(f func() error) error {
+	r := &common.Retry{ShouldRetry: isNotFoundYetCreate}
+	return r.Run(f)
+}
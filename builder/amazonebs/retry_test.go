@@ -0,0 +1,42 @@
+package amazonebs
+
+import (
+	"errors"
+	"github.com/mitchellh/goamz/ec2"
+	"testing"
+)
+
+func TestIsThrottled(t *testing.T) {
+	cases := []struct {
+		err       error
+		throttled bool
+		notFound  bool
+		create    bool
+	}{
+		{&ec2.Error{Code: "RequestLimitExceeded"}, true, true, true},
+		{&ec2.Error{Code: "Throttling"}, true, true, true},
+		{&ec2.Error{Code: "InternalError"}, true, true, false},
+		{&ec2.Error{Code: "InvalidInstanceID.NotFound"}, false, true, false},
+		{&ec2.Error{Code: "InvalidGroup.NotFound"}, false, true, false},
+		{&ec2.Error{Code: "InvalidParameterValue"}, false, false, false},
+		{errors.New("RequestLimitExceeded"), false, false, false},
+	}
+
+	for _, tc := range cases {
+		if isThrottled(tc.err) != tc.throttled {
+			t.Fatalf("bad: %#v", tc.err)
+		}
+
+		if isNotFoundYet(tc.err) != tc.notFound {
+			t.Fatalf("bad: %#v", tc.err)
+		}
+
+		if isThrottledCreate(tc.err) != tc.create {
+			t.Fatalf("bad: %#v", tc.err)
+		}
+
+		if isNotFoundYetCreate(tc.err) != (tc.create || isNotFound(tc.err)) {
+			t.Fatalf("bad: %#v", tc.err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package amazonebs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The URL that responds with the public IP address that it is requested
+// from.
+var checkIPURL = "https://checkip.amazonaws.com/"
+
+// sourceCIDR returns the CIDR block of the address that Packer connects to
+// the instance from, which is all that the temporary security group allows
+// access from. This is the public address of the machine Packer runs on,
+// or its private address with ssh_private_ip, since it is in the VPC then.
+func sourceCIDR(config config) (string, error) {
+	var ip net.IP
+	var err error
+	if config.SSHPrivateIp {
+		ip, err = localIP(fmt.Sprintf("ec2.%s.amazonaws.com:443", config.Region))
+	} else {
+		ip, err = publicIP()
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if ip.To4() == nil {
+		return "", fmt.Errorf("%s isn't an IPv4 address", ip)
+	}
+
+	return ip.String() + "/32", nil
+}
+
+// The client that checkIPURL is requested with. It connects directly,
+// like SSH does, rather than through the HTTP proxy that the calls to EC2
+// go through, which would have checkIPURL see the address of the proxy.
+var checkIPClient = &http.Client{Transport: &http.Transport{}}
+
+// publicIP returns the public IP address of this machine, as checkIPURL
+// sees it.
+func publicIP() (net.IP, error) {
+	resp, err := checkIPClient.Get(checkIPURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s responded with %s", checkIPURL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("%s responded with no IP address", checkIPURL)
+	}
+
+	return ip, nil
+}
+
+// localIP returns the local IP address that this machine reaches the
+// address from. Nothing is sent to the address, since UDP doesn't connect.
+func localIP(address string) (net.IP, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,86 @@
+package amazonebs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func testCheckIPServer(body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+
+	checkIPURL = server.URL
+	return server
+}
+
+func TestSourceCIDR(t *testing.T) {
+	server := testCheckIPServer("203.0.113.7\n")
+	defer server.Close()
+	defer func() { checkIPURL = "https://checkip.amazonaws.com/" }()
+
+	cidr, err := sourceCIDR(config{Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if cidr != "203.0.113.7/32" {
+		t.Fatalf("bad: %s", cidr)
+	}
+}
+
+func TestSourceCIDR_Proxy(t *testing.T) {
+	server := testCheckIPServer("203.0.113.7\n")
+	defer server.Close()
+	defer func() { checkIPURL = "https://checkip.amazonaws.com/" }()
+
+	// The proxy of the template would see the request as its own
+	proxy := testCheckIPServer("198.51.100.1\n")
+	defer proxy.Close()
+	checkIPURL = server.URL
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	cidr, err := sourceCIDR(config{Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if cidr != "203.0.113.7/32" {
+		t.Fatalf("bad: %s", cidr)
+	}
+}
+
+func TestSourceCIDR_Bad(t *testing.T) {
+	defer func() { checkIPURL = "https://checkip.amazonaws.com/" }()
+
+	for _, body := range []string{"not an address", "2001:db8::7"} {
+		server := testCheckIPServer(body)
+		_, err := sourceCIDR(config{Region: "us-east-1"})
+		server.Close()
+
+		if err == nil {
+			t.Fatalf("should have error: %s", body)
+		}
+	}
+}
+
+func TestLocalIP(t *testing.T) {
+	ip, err := localIP("127.0.0.1:443")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !ip.IsLoopback() {
+		t.Fatalf("bad: %s", ip)
+	}
+}
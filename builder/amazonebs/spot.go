@@ -0,0 +1,191 @@
+package amazonebs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/packer/builder/common"
+	"log"
+	"strconv"
+	"time"
+)
+
+// The spot_price that has the builder bid the current spot price.
+const spotPriceAuto = "auto"
+
+// The products that spot prices are for, which spot_price_auto_product
+// must be one of.
+var spotPriceProducts = []string{
+	"Linux/UNIX",
+	"SUSE Linux",
+	"Windows",
+	"Linux/UNIX (Amazon VPC)",
+	"SUSE Linux (Amazon VPC)",
+	"Windows (Amazon VPC)",
+}
+
+// How long until a spot request that isn't fulfilled is looked at again.
+var spotRequestPollInterval = 5 * time.Second
+
+// autoSpotPrice returns the spot price to bid for the instance type and
+// product, and the availability zone that it is for. This is the lowest
+// current price of any availability zone of the region, so the instance
+// is requested in that zone. An instance in a subnet can only launch in
+// the zone of the subnet, so for one the highest current price of any zone
+// is bid instead, and no zone is returned. Spot instances are charged the
+// current price of their zone rather than the bid, so this costs nothing
+// more.
+func autoSpotPrice(ec2conn *ec2.EC2, instanceType string, product string, inSubnet bool) (string, string, error) {
+	var resp *ec2.DescribeSpotPriceHistoryResp
+	err := retryThrottled(func() (err error) {
+		resp, err = ec2conn.DescribeSpotPriceHistory(&ec2.DescribeSpotPriceHistory{
+			InstanceType:       []string{instanceType},
+			ProductDescription: []string{product},
+			StartTime:          time.Now().Add(-time.Hour),
+			EndTime:            time.Now(),
+		})
+		return
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	if inSubnet {
+		price, err := highestSpotPrice(resp.History)
+		return price, "", err
+	}
+
+	return lowestSpotPrice(resp.History)
+}
+
+// lowestSpotPrice returns the lowest of the latest prices of the
+// availability zones in the history, and its zone.
+func lowestSpotPrice(history []ec2.SpotPriceHistory) (string, string, error) {
+	var price float64
+	var result ec2.SpotPriceHistory
+	for _, h := range latestSpotPrices(history) {
+		current, err := strconv.ParseFloat(h.SpotPrice, 64)
+		if err != nil {
+			log.Printf("[WARN] Bad spot price in history: %s", h.SpotPrice)
+			continue
+		}
+
+		// Ties go to the first zone by name, so the choice is the same
+		// for the same prices.
+		if result.SpotPrice == "" || current < price ||
+			(current == price && h.AvailabilityZone < result.AvailabilityZone) {
+			price = current
+			result = h
+		}
+	}
+
+	if result.SpotPrice == "" {
+		return "", "", errors.New("no spot prices are known for the instance type and product")
+	}
+
+	return result.SpotPrice, result.AvailabilityZone, nil
+}
+
+// highestSpotPrice returns the highest of the latest prices of the
+// availability zones in the history.
+func highestSpotPrice(history []ec2.SpotPriceHistory) (string, error) {
+	var price float64
+	result := ""
+	for _, h := range latestSpotPrices(history) {
+		current, err := strconv.ParseFloat(h.SpotPrice, 64)
+		if err != nil {
+			log.Printf("[WARN] Bad spot price in history: %s", h.SpotPrice)
+			continue
+		}
+
+		if result == "" || current > price {
+			price = current
+			result = h.SpotPrice
+		}
+	}
+
+	if result == "" {
+		return "", errors.New("no spot prices are known for the instance type and product")
+	}
+
+	return result, nil
+}
+
+// latestSpotPrices returns the latest price of each availability zone in
+// the history, which has every change of the prices, by zone.
+func latestSpotPrices(history []ec2.SpotPriceHistory) map[string]ec2.SpotPriceHistory {
+	latest := make(map[string]ec2.SpotPriceHistory)
+	for _, h := range history {
+		if current, ok := latest[h.AvailabilityZone]; !ok || h.Timestamp.After(current.Timestamp) {
+			latest[h.AvailabilityZone] = h
+		}
+	}
+
+	return latest
+}
+
+// waitForSpotRequest waits for the spot request to be fulfilled, and
+// returns the ID of the instance that it launched. It fails if the request
+// is closed without being fulfilled, and gives up once the build is
+// cancelled, since a request whose price is too low can wait forever.
+func waitForSpotRequest(ec2conn *ec2.EC2, id string, bag *common.StateBag) (string, error) {
+	log.Printf("Waiting for spot request to be fulfilled: %s", id)
+
+	for {
+		// A new request isn't found for a moment
+		var resp *ec2.SpotRequestsResp
+		err := retryNotFound(func() (err error) {
+			resp, err = ec2conn.DescribeSpotRequests([]string{id}, nil)
+			return
+		})
+		if err != nil {
+			return "", err
+		}
+
+		if len(resp.SpotRequestResults) == 0 {
+			return "", fmt.Errorf("spot request %s wasn't found", id)
+		}
+
+		request := resp.SpotRequestResults[0]
+		log.Printf("Spot request %s is %s: %s", id, request.State, request.Status.Code)
+		switch request.State {
+		case "active":
+			if request.InstanceId != "" {
+				return request.InstanceId, nil
+			}
+		case "open":
+		default:
+			return "", fmt.Errorf("spot request %s is %s: %s",
+				id, request.State, request.Status.Message)
+		}
+
+		if bag.Cancelled() {
+			return "", errors.New("the build was cancelled")
+		}
+
+		time.Sleep(spotRequestPollInterval)
+	}
+}
+
+// spotRequestInstance returns the instance that the spot request launched,
+// or nil if it launched none or it can't be found out.
+func spotRequestInstance(ec2conn *ec2.EC2, id string) *ec2.Instance {
+	var resp *ec2.SpotRequestsResp
+	err := retryThrottled(func() (err error) {
+		resp, err = ec2conn.DescribeSpotRequests([]string{id}, nil)
+		return
+	})
+	if err != nil {
+		log.Printf("[WARN] Error describing spot request %s: %s", id, err)
+		return nil
+	}
+
+	if len(resp.SpotRequestResults) == 0 || resp.SpotRequestResults[0].InstanceId == "" {
+		return nil
+	}
+
+	return &ec2.Instance{
+		InstanceId: resp.SpotRequestResults[0].InstanceId,
+		State:      ec2.InstanceState{Name: "pending"},
+	}
+}
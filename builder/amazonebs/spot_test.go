@@ -0,0 +1,57 @@
+package amazonebs
+
+import (
+	"github.com/mitchellh/goamz/ec2"
+	"testing"
+	"time"
+)
+
+func TestLowestSpotPrice(t *testing.T) {
+	now := time.Now()
+	history := []ec2.SpotPriceHistory{
+		{SpotPrice: "0.0300", AvailabilityZone: "us-east-1a", Timestamp: now},
+		{SpotPrice: "0.0100", AvailabilityZone: "us-east-1a", Timestamp: now.Add(-time.Hour)},
+		{SpotPrice: "0.0250", AvailabilityZone: "us-east-1c", Timestamp: now},
+		{SpotPrice: "0.0250", AvailabilityZone: "us-east-1b", Timestamp: now},
+		{SpotPrice: "bad", AvailabilityZone: "us-east-1d", Timestamp: now},
+	}
+
+	price, zone, err := lowestSpotPrice(history)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Only the latest price of a zone counts, and ties go to the first
+	// zone by name.
+	if price != "0.0250" || zone != "us-east-1b" {
+		t.Fatalf("bad: %s %s", price, zone)
+	}
+
+	if _, _, err := lowestSpotPrice(nil); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestHighestSpotPrice(t *testing.T) {
+	now := time.Now()
+	history := []ec2.SpotPriceHistory{
+		{SpotPrice: "0.0100", AvailabilityZone: "us-east-1a", Timestamp: now},
+		{SpotPrice: "0.0900", AvailabilityZone: "us-east-1a", Timestamp: now.Add(-time.Hour)},
+		{SpotPrice: "0.0250", AvailabilityZone: "us-east-1b", Timestamp: now},
+		{SpotPrice: "bad", AvailabilityZone: "us-east-1c", Timestamp: now},
+	}
+
+	// Only the latest price of a zone counts
+	price, err := highestSpotPrice(history)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if price != "0.0250" {
+		t.Fatalf("bad: %s", price)
+	}
+
+	if _, err := highestSpotPrice(nil); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -0,0 +1,103 @@
+package amazonebs
+
+import (
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/packer/builder/common"
+)
+
+// The namespace of the values of this builder in the state of its steps.
+const stateNamespace = "amazon-ebs"
+
+// stepState is the state of the steps of the builder, with typed
+// accessors for the values they share, on top of those of common.StateBag
+// that every builder has.
+type stepState struct {
+	*common.StateBag
+}
+
+func newStepState(state map[string]interface{}) stepState {
+	return stepState{common.NewStateBag(state).Namespace(stateNamespace)}
+}
+
+// config returns the configuration of the builder.
+func (s stepState) config() (result config) {
+	s.Value("config", &result)
+	return
+}
+
+func (s stepState) setConfig(value config) {
+	s.Put("config", value)
+}
+
+// ec2Conn returns the connection to EC2 in the region of the build.
+func (s stepState) ec2Conn() (result *ec2.EC2) {
+	s.Value("ec2", &result)
+	return
+}
+
+func (s stepState) setEC2Conn(value *ec2.EC2) {
+	s.Put("ec2", value)
+}
+
+// auth returns the credentials that the connections to EC2 are made
+// with, for connecting to other regions.
+func (s stepState) auth() (result aws.Auth) {
+	s.Value("auth", &result)
+	return
+}
+
+func (s stepState) setAuth(value aws.Auth) {
+	s.Put("auth", value)
+}
+
+// keyPair returns the name of the temporary key pair.
+func (s stepState) keyPair() (result string) {
+	s.Value("keyPair", &result)
+	return
+}
+
+func (s stepState) setKeyPair(value string) {
+	s.Put("keyPair", value)
+}
+
+// securityGroupIds returns the IDs of the security groups of the
+// instance.
+func (s stepState) securityGroupIds() (result []string) {
+	s.Value("securityGroupIds", &result)
+	return
+}
+
+func (s stepState) setSecurityGroupIds(value []string) {
+	s.Put("securityGroupIds", value)
+}
+
+// instance returns the source instance once it is running.
+func (s stepState) instance() (result *ec2.Instance) {
+	s.Value("instance", &result)
+	return
+}
+
+func (s stepState) setInstance(value *ec2.Instance) {
+	s.Put("instance", value)
+}
+
+// amiName returns the name of the AMI, which its copies have too.
+func (s stepState) amiName() (result string) {
+	s.Value("amiName", &result)
+	return
+}
+
+func (s stepState) setAmiName(value string) {
+	s.Put("amiName", value)
+}
+
+// amis returns the AMIs that were created, by region.
+func (s stepState) amis() (result map[string]string) {
+	s.Value("amis", &result)
+	return
+}
+
+func (s stepState) setAmis(value map[string]string) {
+	s.Put("amis", value)
+}
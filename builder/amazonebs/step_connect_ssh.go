@@ -2,124 +2,85 @@ package amazonebs
 
 import (
 	gossh "code.google.com/p/go.crypto/ssh"
-	"errors"
 	"fmt"
-	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/communicator/ssh"
-	"github.com/mitchellh/packer/packer"
-	"log"
-	"net"
-	"time"
+	"github.com/mitchellh/packer/builder/common"
+	"github.com/mitchellh/packer/communicator/none"
+	"io"
+	"strconv"
 )
 
 type stepConnectSSH struct {
-	conn net.Conn
+	ssh    *common.StepConnectSSH
+	plugin *common.StepConnectPlugin
 }
 
 func (s *stepConnectSSH) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(config)
-	instance := state["instance"].(*ec2.Instance)
-	privateKey := state["privateKey"].(string)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+
+	switch config.Communicator {
+	case "winrm":
+		return s.connectWinRM(state)
+	case "none":
+		ui.Say("Communicator is none, not connecting to the instance.")
+		bag.SetCommunicator(none.New())
+		return multistep.ActionContinue
+	case "ssh":
+	default:
+		s.plugin = &common.StepConnectPlugin{
+			Info:    pluginInfo,
+			Config:  &config.CommunicatorConfig,
+			Timeout: config.SSHTimeout,
+		}
 
-	// Build the keyring for authentication. This stores the private key
-	// we'll use to authenticate.
-	keyring := &ssh.SimpleKeychain{}
-	err := keyring.AddPEMKey(privateKey)
-	if err != nil {
-		err := fmt.Errorf("Error setting up SSH config: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return s.plugin.Run(state)
 	}
 
-	// Build the actual SSH client configuration
-	sshConfig := &gossh.ClientConfig{
-		User: config.SSHUsername,
-		Auth: []gossh.ClientAuth{
-			gossh.ClientAuthKeyring(keyring),
-		},
+	s.ssh = &common.StepConnectSSH{
+		Address:      sshAddress,
+		ClientConfig: sshConfig,
+		Config:       &config.SSHConfig,
+		Timeout:      config.SSHTimeout,
 	}
 
-	// Start trying to connect to SSH
-	connected := make(chan bool, 1)
-	connectQuit := make(chan bool, 1)
-	defer func() {
-		connectQuit <- true
-	}()
-
-	go func() {
-		var err error
-
-		ui.Say("Connecting to the instance via SSH...")
-		attempts := 0
-		for {
-			select {
-			case <-connectQuit:
-				return
-			default:
-			}
-
-			attempts += 1
-			log.Printf(
-				"Opening TCP conn for SSH to %s:%d (attempt %d)",
-				instance.DNSName, config.SSHPort, attempts)
-			s.conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", instance.DNSName, config.SSHPort))
-			if err == nil {
-				break
-			}
-
-			// A brief sleep so we're not being overly zealous attempting
-			// to connect to the instance.
-			time.Sleep(500 * time.Millisecond)
-		}
-
-		connected <- true
-	}()
-
-	log.Printf("Waiting up to %s for SSH connection", config.SSHTimeout)
-	timeout := time.After(config.SSHTimeout)
-
-ConnectWaitLoop:
-	for {
-		select {
-		case <-connected:
-			// We connected. Just break the loop.
-			break ConnectWaitLoop
-		case <-timeout:
-			err := errors.New("Timeout waiting for SSH to become available.")
-			state["error"] = err
-			ui.Error(err.Error())
-			return multistep.ActionHalt
-		case <-time.After(1 * time.Second):
-			if _, ok := state[multistep.StateCancelled]; ok {
-				log.Println("Interrupt detected, quitting waiting for SSH.")
-				return multistep.ActionHalt
-			}
-		}
-	}
+	return s.ssh.Run(state)
+}
 
-	var comm packer.Communicator
-	if err == nil {
-		comm, err = ssh.New(s.conn, sshConfig)
+func (s *stepConnectSSH) Cleanup(state map[string]interface{}) {
+	if s.ssh != nil {
+		s.ssh.Cleanup(state)
 	}
 
-	if err != nil {
-		err := fmt.Errorf("Error connecting to SSH: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	if s.plugin != nil {
+		s.plugin.Cleanup(state)
 	}
+}
 
-	// Set the communicator on the state bag so it can be used later
-	state["communicator"] = comm
-
-	return multistep.ActionContinue
+func sshAddress(state map[string]interface{}) (string, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	instance := bag.instance()
+	return fmt.Sprintf("%s:%d", instanceAddress(config, instance), config.SSHPort), nil
 }
 
-func (s *stepConnectSSH) Cleanup(map[string]interface{}) {
-	if s.conn != nil {
-		s.conn.Close()
+// pluginInfo describes the instance to a communicator plugin, which
+// connects to ssh_port.
+func pluginInfo(state map[string]interface{}) map[string]string {
+	bag := newStepState(state)
+	config := bag.config()
+	instance := bag.instance()
+	return map[string]string{
+		"host": instanceAddress(config, instance),
+		"port": strconv.Itoa(config.SSHPort),
+		"id":   instance.InstanceId,
 	}
 }
+
+func sshConfig(state map[string]interface{}) (*gossh.ClientConfig, io.Closer, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	privateKey := bag.PrivateKey()
+	return config.SSHConfig.ClientConfig(config.SSHUsername, privateKey)
+}
@@ -0,0 +1,80 @@
+package amazonebs
+
+import (
+	"errors"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/winrm"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"time"
+)
+
+// connectWinRM connects to the instance with WinRM rather than SSH, for
+// Windows instances.
+func (s *stepConnectSSH) connectWinRM(state map[string]interface{}) multistep.StepAction {
+	bag := newStepState(state)
+	config := bag.config()
+	instance := bag.instance()
+	ui := bag.Ui()
+
+	commConfig := config.WinRMConfig.CommConfig(instanceAddress(config, instance), config.WinRMPort)
+
+	// Start trying to connect to WinRM. Windows takes a while to boot, so
+	// the endpoint usually isn't up for some time.
+	var comm packer.Communicator
+	connected := make(chan bool, 1)
+	connectQuit := make(chan bool, 1)
+	defer func() {
+		connectQuit <- true
+	}()
+
+	go func() {
+		ui.Say("Connecting to the instance via WinRM...")
+		attempts := 0
+		for {
+			select {
+			case <-connectQuit:
+				return
+			default:
+			}
+
+			attempts += 1
+			log.Printf("Connecting to WinRM at %s:%d (attempt %d)", commConfig.Host, commConfig.Port, attempts)
+			result, err := winrm.New(commConfig)
+			if err == nil {
+				comm = result
+				break
+			}
+
+			log.Printf("WinRM connection failed: %s", err)
+			time.Sleep(5 * time.Second)
+		}
+
+		connected <- true
+	}()
+
+	log.Printf("Waiting up to %s for WinRM connection", config.SSHTimeout)
+	timeout := time.After(config.SSHTimeout)
+
+ConnectWaitLoop:
+	for {
+		select {
+		case <-connected:
+			// We connected. Just break the loop.
+			break ConnectWaitLoop
+		case <-timeout:
+			err := errors.New("Timeout waiting for WinRM to become available.")
+			return bag.Halt(err)
+		case <-time.After(1 * time.Second):
+			if bag.Cancelled() {
+				log.Println("Interrupt detected, quitting waiting for WinRM.")
+				return multistep.ActionHalt
+			}
+		}
+	}
+
+	// Set the communicator on the state bag so it can be used later
+	bag.SetCommunicator(comm)
+
+	return multistep.ActionContinue
+}
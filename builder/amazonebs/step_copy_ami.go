@@ -0,0 +1,132 @@
+package amazonebs
+
+import (
+	"fmt"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"sync"
+)
+
+// stepCopyAMI copies the AMI to the other regions of the configuration,
+// and waits for the copies to become available. The copies are added to
+// the AMIs of the state, so they are tagged and are part of the artifact.
+type stepCopyAMI struct{}
+
+func (s *stepCopyAMI) Run(state map[string]interface{}) multistep.StepAction {
+	bag := newStepState(state)
+	config := bag.config()
+	if len(config.AMIRegions) == 0 {
+		return multistep.ActionContinue
+	}
+
+	amis := bag.amis()
+	auth := bag.auth()
+	name := bag.amiName()
+	ui := bag.Ui()
+
+	sourceId := amis[config.Region]
+	copyRegion := func(region string) (string, error) {
+		return copyAMI(ec2.New(auth, aws.Regions[region]), config, region, sourceId, name, ui)
+	}
+
+	// Each region has its own slot for the copy and its error, so the
+	// copies that run at once don't need to coordinate.
+	ids := make([]string, len(config.AMIRegions))
+	errs := make([]error, len(config.AMIRegions))
+	if config.AMICopyParallel {
+		var wg sync.WaitGroup
+		for i, region := range config.AMIRegions {
+			wg.Add(1)
+			go func(i int, region string) {
+				defer wg.Done()
+				ids[i], errs[i] = copyRegion(region)
+			}(i, region)
+		}
+
+		wg.Wait()
+	} else {
+		for i, region := range config.AMIRegions {
+			if bag.Cancelled() {
+				break
+			}
+
+			ids[i], errs[i] = copyRegion(region)
+			if errs[i] != nil {
+				break
+			}
+		}
+	}
+
+	errors := make([]error, 0)
+	for i, region := range config.AMIRegions {
+		if ids[i] != "" {
+			amis[region] = ids[i]
+		}
+
+		if errs[i] != nil {
+			errors = append(errors, fmt.Errorf("Error copying AMI to %s: %s", region, errs[i]))
+		}
+	}
+
+	bag.setAmis(amis)
+
+	// The build has no artifact if it fails, so the AMIs that were made
+	// are shown for them to be deregistered.
+	if len(errors) > 0 {
+		created := &artifact{amis: amis}
+		ui.Error(fmt.Sprintf(
+			"These AMIs were created, and must be deregistered manually if unwanted:\n%s",
+			created.regionList()))
+		return bag.Halt(&packer.MultiError{errors})
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepCopyAMI) Cleanup(map[string]interface{}) {
+	// No cleanup...
+}
+
+// copyAMI copies the AMI to the region of the connection, with the name of
+// the AMI, and returns the ID of the copy once it is available. The ID is
+// returned with the error if the copy was started but failed.
+func copyAMI(ec2conn *ec2.EC2, config config, region string, sourceId string, name string, ui packer.Ui) (string, error) {
+	// AMI names are only unique within a region, so a forced build
+	// replaces the AMIs of earlier builds in every region.
+	if config.PackerForce {
+		if err := deregisterAMIs(ec2conn, name, ui); err != nil {
+			return "", fmt.Errorf("Error deregistering existing AMI: %s", err)
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Copying the AMI to %s...", region))
+	copyOpts := &ec2.CopyImage{
+		SourceRegion:  config.Region,
+		SourceImageId: sourceId,
+		Name:          name,
+		Description:   fmt.Sprintf("Copy of %s from %s", sourceId, config.Region),
+
+		// KMS keys are per region, so copies of an encrypted AMI are
+		// encrypted with the default key of their region.
+		Encrypted: config.EncryptBoot,
+	}
+
+	var copyResp *ec2.CopyImageResp
+	err := retryCreate(func() (err error) {
+		copyResp, err = ec2conn.CopyImage(copyOpts)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ui.Message(fmt.Sprintf("AMI in %s: %s", region, copyResp.ImageId))
+	if err := waitForImage(ec2conn, copyResp.ImageId); err != nil {
+		return copyResp.ImageId, err
+	}
+
+	ui.Message(fmt.Sprintf("The AMI in %s is ready.", region))
+	return copyResp.ImageId, nil
+}
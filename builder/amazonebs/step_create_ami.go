@@ -1,14 +1,14 @@
 package amazonebs
 
 import (
-	"bytes"
+	"cgl.tideland.biz/identifier"
+	"encoding/hex"
 	"fmt"
 	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"strconv"
-	"text/template"
 	"time"
 )
 
@@ -19,66 +19,128 @@ type amiNameData struct {
 }
 
 func (s *stepCreateAMI) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(config)
-	ec2conn := state["ec2"].(*ec2.EC2)
-	instance := state["instance"].(*ec2.Instance)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	ec2conn := bag.ec2Conn()
+	instance := bag.instance()
+	ui := bag.Ui()
 
 	// Parse the name of the AMI
-	amiNameBuf := new(bytes.Buffer)
 	tData := amiNameData{
 		strconv.FormatInt(time.Now().UTC().Unix(), 10),
 	}
 
-	t := template.Must(template.New("ami").Parse(config.AMIName))
-	t.Execute(amiNameBuf, tData)
-	amiName := amiNameBuf.String()
+	amiName, err := config.tpl.Process(config.AMIName, tData)
+	if err != nil {
+		err := fmt.Errorf("Error preparing AMI name: %s", err)
+		return bag.Halt(err)
+	}
+
+	// When the build is forced, the AMIs of earlier builds with the same
+	// name are replaced, since names must be unique.
+	if config.PackerForce {
+		if err := deregisterAMIs(ec2conn, amiName, ui); err != nil {
+			err := fmt.Errorf("Error deregistering existing AMI: %s", err)
+			return bag.Halt(err)
+		}
+	}
+
+	// An AMI that is encrypted afterwards is only an intermediate one, so
+	// its copy can have the name.
+	imageName := amiName
+	if config.EncryptBoot {
+		imageName = fmt.Sprintf("packer-intermediate-%s", hex.EncodeToString(identifier.NewUUID().Raw()))
+	}
 
 	// Create the image
-	ui.Say(fmt.Sprintf("Creating the AMI: %s", amiName))
+	ui.Say(fmt.Sprintf("Creating the AMI: %s", imageName))
 	createOpts := &ec2.CreateImage{
 		InstanceId: instance.InstanceId,
-		Name:       amiName,
+		Name:       imageName,
 	}
 
-	createResp, err := ec2conn.CreateImage(createOpts)
+	var createResp *ec2.CreateImageResp
+	err = retryCreate(func() (err error) {
+		createResp, err = ec2conn.CreateImage(createOpts)
+		return
+	})
 	if err != nil {
 		err := fmt.Errorf("Error creating AMI: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Set the AMI ID in the state
 	ui.Say(fmt.Sprintf("AMI: %s", createResp.ImageId))
 	amis := make(map[string]string)
 	amis[config.Region] = createResp.ImageId
-	state["amis"] = amis
+	bag.setAmis(amis)
+	bag.setAmiName(amiName)
 
 	// Wait for the image to become ready
 	ui.Say("Waiting for AMI to become ready...")
+	if err := waitForImage(ec2conn, createResp.ImageId); err != nil {
+		err := fmt.Errorf("Error querying images: %s", err)
+		return bag.Halt(err)
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepCreateAMI) Cleanup(map[string]interface{}) {
+	// No cleanup...
+}
+
+// waitForImage waits for the image to become available.
+func waitForImage(ec2conn *ec2.EC2, id string) error {
 	for {
-		imageResp, err := ec2conn.Images([]string{createResp.ImageId}, ec2.NewFilter())
+		// A new image isn't found for a moment
+		var imageResp *ec2.ImagesResp
+		err := retryNotFound(func() (err error) {
+			imageResp, err = ec2conn.Images([]string{id}, ec2.NewFilter())
+			return
+		})
 		if err != nil {
-			err := fmt.Errorf("Error querying images: %s", err)
-			state["error"] = err
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			return err
 		}
 
-		if imageResp.Images[0].State == "available" {
-			break
+		switch imageResp.Images[0].State {
+		case "available":
+			return nil
+		case "failed":
+			return fmt.Errorf("image %s failed", id)
 		}
 
-		log.Printf("Image in state %s, sleeping 2s before checking again",
-			imageResp.Images[0].State)
+		log.Printf("Image %s in state %s, sleeping 2s before checking again",
+			id, imageResp.Images[0].State)
 
 		time.Sleep(2 * time.Second)
 	}
-
-	return multistep.ActionContinue
 }
 
-func (s *stepCreateAMI) Cleanup(map[string]interface{}) {
-	// No cleanup...
+// deregisterAMIs deregisters the AMIs with the given name.
+func deregisterAMIs(ec2conn *ec2.EC2, name string, ui packer.Ui) error {
+	filter := ec2.NewFilter()
+	filter.Add("name", name)
+
+	var imagesResp *ec2.ImagesResp
+	err := retryThrottled(func() (err error) {
+		imagesResp, err = ec2conn.Images(nil, filter)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, image := range imagesResp.Images {
+		ui.Say(fmt.Sprintf("Deregistering existing AMI: %s", image.Id))
+		err := retryThrottled(func() error {
+			_, err := ec2conn.DeregisterImage(image.Id)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
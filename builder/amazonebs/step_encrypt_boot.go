@@ -0,0 +1,113 @@
+package amazonebs
+
+import (
+	"fmt"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"log"
+)
+
+// stepEncryptBoot replaces the AMI with an encrypted copy of it, since the
+// volumes of an AMI can only be encrypted by copying it. The AMI that the
+// instance was turned into is an intermediate one then, which is
+// deregistered, with its snapshots, once the encrypted copy is made or the
+// build fails.
+type stepEncryptBoot struct {
+	intermediateId string
+	resource       *packer.TempResource
+}
+
+func (s *stepEncryptBoot) Run(state map[string]interface{}) multistep.StepAction {
+	bag := newStepState(state)
+	config := bag.config()
+	if !config.EncryptBoot {
+		return multistep.ActionContinue
+	}
+
+	ec2conn := bag.ec2Conn()
+	amis := bag.amis()
+	ui := bag.Ui()
+
+	s.intermediateId = amis[config.Region]
+	s.resource = &packer.TempResource{
+		Kind: "intermediate AMI",
+		ID:   s.intermediateId,
+		Hint: fmt.Sprintf("deregister it and delete its snapshots in the %s region", config.Region),
+	}
+	packer.TrackTempResource(s.resource)
+
+	ui.Say("Copying the AMI to encrypt its volumes...")
+	copyOpts := &ec2.CopyImage{
+		SourceRegion:  config.Region,
+		SourceImageId: s.intermediateId,
+		Name:          bag.amiName(),
+		Encrypted:     true,
+		KmsKeyId:      config.KmsKeyId,
+	}
+
+	var copyResp *ec2.CopyImageResp
+	err := retryCreate(func() (err error) {
+		copyResp, err = ec2conn.CopyImage(copyOpts)
+		return
+	})
+	if err != nil {
+		err := fmt.Errorf("Error copying AMI to encrypt it: %s", err)
+		return bag.Halt(err)
+	}
+
+	// The encrypted AMI is the AMI of the build from now on
+	ui.Say(fmt.Sprintf("Encrypted AMI: %s", copyResp.ImageId))
+	amis[config.Region] = copyResp.ImageId
+	bag.setAmis(amis)
+
+	ui.Say("Waiting for the encrypted AMI to become ready...")
+	if err := waitForImage(ec2conn, copyResp.ImageId); err != nil {
+		err := fmt.Errorf("Error waiting for the encrypted AMI: %s", err)
+		return bag.Halt(err)
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepEncryptBoot) Cleanup(state map[string]interface{}) {
+	if s.intermediateId == "" {
+		return
+	}
+
+	bag := newStepState(state)
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
+
+	ui.Say("Deregistering the intermediate AMI...")
+	if err := deregisterAMI(ec2conn, s.intermediateId); err != nil {
+		log.Printf("[ERROR] Error deregistering intermediate AMI: %s", err)
+		ui.Error(fmt.Sprintf(
+			"Error cleaning up intermediate AMI. Please deregister it and delete its snapshots manually: %s",
+			s.intermediateId))
+	} else {
+		packer.UntrackTempResource(s.resource)
+	}
+}
+
+// deregisterAMI deregisters the AMI, and deletes the snapshots that back
+// it, which deregistering an AMI leaves behind.
+func deregisterAMI(ec2conn *ec2.EC2, id string) error {
+	snapshotIds, err := amiSnapshots(ec2conn, []string{id})
+	if err != nil {
+		return err
+	}
+
+	err = retryThrottled(func() error {
+		_, err := ec2conn.DeregisterImage(id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return retryThrottled(func() error {
+		_, err := ec2conn.DeleteSnapshots(snapshotIds)
+		return err
+	})
+}
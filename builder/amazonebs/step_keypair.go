@@ -7,51 +7,148 @@ import (
 	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
 	"log"
+	"os"
 )
 
+// The name that the temporary key pair is saved in the checkpoint under.
+const checkpointKeyPair = "key_pair"
+
 type stepKeyPair struct {
-	keyName string
+	// In debug mode, the private key is saved to DebugKeyPath so that
+	// the instance can be connected to while the build is paused.
+	Debug        bool
+	DebugKeyPath string
+
+	keyName  string
+	resource *packer.TempResource
 }
 
 func (s *stepKeyPair) Run(state map[string]interface{}) multistep.StepAction {
-	ec2conn := state["ec2"].(*ec2.EC2)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
 
-	ui.Say("Creating temporary keypair for this instance...")
-	keyName := fmt.Sprintf("packer %s", hex.EncodeToString(identifier.NewUUID().Raw()))
-	log.Printf("temporary keypair name: %s", keyName)
-	keyResp, err := ec2conn.CreateKeyPair(keyName)
-	if err != nil {
-		err := fmt.Errorf("Error creating temporary keypair: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	// The key pair of the configuration is used as it is, with its
+	// private key.
+	if config.SSHKeyPairName != "" {
+		privateKey, err := ioutil.ReadFile(config.SSHPrivateKeyFile)
+		if err != nil {
+			err := fmt.Errorf("Error reading ssh_private_key_file: %s", err)
+			return bag.Halt(err)
+		}
+
+		log.Printf("Using key pair: %s", config.SSHKeyPairName)
+		bag.setKeyPair(config.SSHKeyPairName)
+		bag.SetPrivateKey(string(privateKey))
+		return multistep.ActionContinue
+	}
+
+	checkpoint := bag.Checkpoint()
+	keyName, privateKey := s.resumeKeyPair(bag)
+	if keyName == "" {
+		ui.Say("Creating temporary keypair for this instance...")
+		keyName = fmt.Sprintf("packer %s", hex.EncodeToString(identifier.NewUUID().Raw()))
+		log.Printf("temporary keypair name: %s", keyName)
+		var keyResp *ec2.CreateKeyPairResp
+		err := retryCreate(func() (err error) {
+			keyResp, err = ec2conn.CreateKeyPair(keyName)
+			return
+		})
+		if err != nil {
+			err := fmt.Errorf("Error creating temporary keypair: %s", err)
+			return bag.Halt(err)
+		}
+
+		privateKey = keyResp.KeyMaterial
+		err = checkpoint.Set(checkpointKeyPair, map[string]string{
+			"name":        keyName,
+			"private_key": privateKey,
+		})
+		if err != nil {
+			log.Printf("[WARN] Error saving checkpoint: %s", err)
+		}
 	}
 
 	// Set the keyname so we know to delete it later
 	s.keyName = keyName
+	s.resource = &packer.TempResource{
+		Kind: "key pair",
+		ID:   keyName,
+		Hint: fmt.Sprintf("delete it in the %s region", config.Region),
+	}
+	packer.TrackTempResource(s.resource)
 
 	// Set some state data for use in future steps
-	state["keyPair"] = keyName
-	state["privateKey"] = keyResp.KeyMaterial
+	bag.setKeyPair(keyName)
+	bag.SetPrivateKey(privateKey)
+
+	if s.Debug {
+		ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", s.DebugKeyPath))
+		err := ioutil.WriteFile(s.DebugKeyPath, []byte(privateKey), 0600)
+		if err != nil {
+			err := fmt.Errorf("Error saving debug key: %s", err)
+			return bag.Halt(err)
+		}
+	}
 
 	return multistep.ActionContinue
 }
 
+// resumeKeyPair returns the name and private key of the temporary key pair
+// that the build that is resumed created, if it still exists.
+func (s *stepKeyPair) resumeKeyPair(bag stepState) (string, string) {
+	values, ok := bag.Checkpoint().Get(checkpointKeyPair)
+	if !ok {
+		return "", ""
+	}
+
+	keyName := values["name"]
+	var resp *ec2.KeyPairsResp
+	err := retryThrottled(func() (err error) {
+		resp, err = bag.ec2Conn().KeyPairs([]string{keyName}, nil)
+		return
+	})
+	if err != nil || len(resp.Keys) == 0 {
+		log.Printf("[INFO] The key pair of the earlier build is gone: %s", keyName)
+		return "", ""
+	}
+
+	bag.Ui().Say(fmt.Sprintf("Using the temporary keypair of the earlier build: %s", keyName))
+	return keyName, values["private_key"]
+}
+
 func (s *stepKeyPair) Cleanup(state map[string]interface{}) {
 	// If no key name is set, then we never created it, so just return
 	if s.keyName == "" {
 		return
 	}
 
-	ec2conn := state["ec2"].(*ec2.EC2)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
 
 	ui.Say("Deleting temporary keypair...")
-	_, err := ec2conn.DeleteKeyPair(s.keyName)
+	err := retryThrottled(func() error {
+		_, err := ec2conn.DeleteKeyPair(s.keyName)
+		return err
+	})
 	if err != nil {
 		ui.Error(fmt.Sprintf(
 			"Error cleaning up keypair. Please delete the key manually: %s", s.keyName))
+	} else {
+		packer.UntrackTempResource(s.resource)
+		if err := bag.Checkpoint().Delete(checkpointKeyPair); err != nil {
+			log.Printf("[WARN] Error saving checkpoint: %s", err)
+		}
+	}
+
+	if s.Debug {
+		if err := os.Remove(s.DebugKeyPath); err != nil && !os.IsNotExist(err) {
+			ui.Error(fmt.Sprintf(
+				"Error removing debug key '%s': %s", s.DebugKeyPath, err))
+		}
 	}
 }
@@ -8,66 +8,302 @@ import (
 	"log"
 )
 
+// The name that the source instance is saved in the checkpoint under.
+const checkpointSourceInstance = "source_instance"
+
 type stepRunSourceInstance struct {
 	instance *ec2.Instance
+	resource *packer.TempResource
+
+	// The spot request that launched the instance, if it is a spot
+	// instance.
+	spotRequestId       string
+	spotRequestResource *packer.TempResource
 }
 
 func (s *stepRunSourceInstance) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(config)
-	ec2conn := state["ec2"].(*ec2.EC2)
-	keyName := state["keyPair"].(string)
-	securityGroupId := state["securityGroupId"].(string)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
 
-	runOpts := &ec2.RunInstances{
-		KeyName:        keyName,
-		ImageId:        config.SourceAmi,
-		InstanceType:   config.InstanceType,
-		MinCount:       0,
-		MaxCount:       0,
-		SecurityGroups: []ec2.SecurityGroup{ec2.SecurityGroup{Id: securityGroupId}},
-	}
+	var err error
+	s.instance = s.resumeInstance(bag)
+	if s.instance == nil {
+		if config.SpotPrice == "" {
+			s.instance, err = s.runInstance(bag)
+		} else {
+			s.instance, err = s.runSpotInstance(bag)
+		}
 
-	ui.Say("Launching a source AWS instance...")
-	runResp, err := ec2conn.RunInstances(runOpts)
-	if err != nil {
-		err := fmt.Errorf("Error launching source instance: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		if err != nil {
+			return bag.Halt(err)
+		}
+
+		err = bag.Checkpoint().Set(checkpointSourceInstance, map[string]string{
+			"id":              s.instance.InstanceId,
+			"spot_request_id": s.spotRequestId,
+		})
+		if err != nil {
+			log.Printf("[WARN] Error saving checkpoint: %s", err)
+		}
 	}
 
-	s.instance = &runResp.Instances[0]
 	log.Printf("instance id: %s", s.instance.InstanceId)
 
+	s.resource = &packer.TempResource{
+		Kind: "instance",
+		ID:   s.instance.InstanceId,
+		Hint: fmt.Sprintf("terminate it in the %s region", config.Region),
+	}
+	packer.TrackTempResource(s.resource)
+
 	ui.Say("Waiting for instance to become ready...")
 	s.instance, err = waitForState(ec2conn, s.instance, []string{"pending"}, "running")
 	if err != nil {
 		err := fmt.Errorf("Error waiting for instance to become ready: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
-	state["instance"] = s.instance
+	bag.setInstance(s.instance)
+
+	hook := bag.Hook()
+	hookData := map[string]string{
+		"build_name": config.PackerBuildName,
+		"id":         s.instance.InstanceId,
+		"address":    instanceAddress(config, s.instance),
+	}
+
+	if err := hook.Run(packer.HookInstanceLaunched, ui, nil, hookData); err != nil {
+		return bag.Halt(err)
+	}
+
+	if config.PackerDebug {
+		ui.Message(fmt.Sprintf("Address: %s", instanceAddress(config, s.instance)))
+	}
 
 	return multistep.ActionContinue
 }
 
+// runInstance launches the source instance.
+func (s *stepRunSourceInstance) runInstance(bag stepState) (*ec2.Instance, error) {
+	config := bag.config()
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
+
+	runOpts := &ec2.RunInstances{
+		KeyName:            bag.keyPair(),
+		ImageId:            config.SourceAmi,
+		InstanceType:       config.InstanceType,
+		MinCount:           0,
+		MaxCount:           0,
+		SecurityGroups:     securityGroups(bag.securityGroupIds()),
+		IamInstanceProfile: config.IamInstanceProfile,
+		SubnetId:           config.SubnetId,
+
+		AssociatePublicIpAddress: config.AssociatePublicIpAddress,
+	}
+
+	ui.Say("Launching a source AWS instance...")
+	// The key pair and security group were just created, so EC2 may not
+	// know about them yet.
+	var runResp *ec2.RunInstancesResp
+	err := retryCreateNotFound(func() (err error) {
+		runResp, err = ec2conn.RunInstances(runOpts)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error launching source instance: %s", err)
+	}
+
+	return &runResp.Instances[0], nil
+}
+
+// resumeInstance returns the source instance that the build that is
+// resumed launched, if it is still running and was launched the same way.
+// An instance with another key pair can't be connected to, since only the
+// private key of the key pair of this build is known.
+func (s *stepRunSourceInstance) resumeInstance(bag stepState) *ec2.Instance {
+	values, ok := bag.Checkpoint().Get(checkpointSourceInstance)
+	if !ok {
+		return nil
+	}
+
+	config := bag.config()
+	ui := bag.Ui()
+
+	id := values["id"]
+	var resp *ec2.InstancesResp
+	err := retryThrottled(func() (err error) {
+		resp, err = bag.ec2Conn().Instances([]string{id}, ec2.NewFilter())
+		return
+	})
+	if err != nil || len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
+		log.Printf("[INFO] The source instance of the earlier build is gone: %s", id)
+		return nil
+	}
+
+	instance := &resp.Reservations[0].Instances[0]
+	if instance.State.Name != "pending" && instance.State.Name != "running" {
+		ui.Message(fmt.Sprintf(
+			"The source instance of the earlier build, %s, is %s, so a new one is launched. "+
+				"Terminate it if it isn't needed.", id, instance.State.Name))
+		return nil
+	}
+
+	if instance.ImageId != config.SourceAmi || instance.InstanceType != config.InstanceType ||
+		instance.KeyName != bag.keyPair() {
+		ui.Message(fmt.Sprintf(
+			"The source instance of the earlier build, %s, was launched differently, so a "+
+				"new one is launched. Terminate it if it isn't needed.", id))
+		return nil
+	}
+
+	ui.Say(fmt.Sprintf("Using the source instance of the earlier build: %s", id))
+
+	// The spot request of the instance is still cancelled once the build
+	// is done.
+	s.spotRequestId = values["spot_request_id"]
+	if s.spotRequestId != "" {
+		s.spotRequestResource = &packer.TempResource{
+			Kind: "spot request",
+			ID:   s.spotRequestId,
+			Hint: fmt.Sprintf("cancel it in the %s region", config.Region),
+		}
+		packer.TrackTempResource(s.spotRequestResource)
+	}
+
+	return instance
+}
+
+// runSpotInstance requests a spot instance at the spot price of the
+// configuration, or at the current price if it is "auto", and returns the
+// instance once the request is fulfilled.
+func (s *stepRunSourceInstance) runSpotInstance(bag stepState) (*ec2.Instance, error) {
+	config := bag.config()
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
+
+	price := config.SpotPrice
+	availZone := ""
+	if price == spotPriceAuto {
+		ui.Say("Finding the current spot price...")
+
+		var err error
+		price, availZone, err = autoSpotPrice(ec2conn, config.InstanceType,
+			config.SpotPriceAutoProduct, config.SubnetId != "")
+		if err != nil {
+			return nil, fmt.Errorf("Error finding the spot price: %s", err)
+		}
+
+		if availZone == "" {
+			ui.Message(fmt.Sprintf("The highest spot price of the region is $%s an hour.", price))
+		} else {
+			ui.Message(fmt.Sprintf("The spot price is $%s an hour in %s.", price, availZone))
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Requesting a source AWS spot instance for at most $%s an hour...", price))
+	requestOpts := &ec2.RequestSpotInstances{
+		SpotPrice:          price,
+		InstanceCount:      1,
+		Type:               "one-time",
+		KeyName:            bag.keyPair(),
+		ImageId:            config.SourceAmi,
+		InstanceType:       config.InstanceType,
+		SecurityGroups:     securityGroups(bag.securityGroupIds()),
+		AvailZone:          availZone,
+		IamInstanceProfile: config.IamInstanceProfile,
+		SubnetId:           config.SubnetId,
+
+		AssociatePublicIpAddress: config.AssociatePublicIpAddress,
+	}
+
+	var requestResp *ec2.RequestSpotInstancesResp
+	err := retryCreateNotFound(func() (err error) {
+		requestResp, err = ec2conn.RequestSpotInstances(requestOpts)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error requesting spot instance: %s", err)
+	}
+
+	s.spotRequestId = requestResp.SpotRequestResults[0].SpotRequestId
+	log.Printf("spot request id: %s", s.spotRequestId)
+
+	s.spotRequestResource = &packer.TempResource{
+		Kind: "spot request",
+		ID:   s.spotRequestId,
+		Hint: fmt.Sprintf("cancel it in the %s region", config.Region),
+	}
+	packer.TrackTempResource(s.spotRequestResource)
+
+	ui.Say("Waiting for the spot request to be fulfilled...")
+	instanceId, err := waitForSpotRequest(ec2conn, s.spotRequestId, bag.StateBag)
+	if err != nil {
+		return nil, fmt.Errorf("Error waiting for spot request: %s", err)
+	}
+
+	var resp *ec2.InstancesResp
+	err = retryNotFound(func() (err error) {
+		resp, err = ec2conn.Instances([]string{instanceId}, ec2.NewFilter())
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error finding spot instance: %s", err)
+	}
+
+	return &resp.Reservations[0].Instances[0], nil
+}
+
 func (s *stepRunSourceInstance) Cleanup(state map[string]interface{}) {
+	bag := newStepState(state)
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
+
+	// The spot request is cancelled first, though it is for one instance
+	// only, so that it can't launch an instance once it is terminated.
+	if s.spotRequestId != "" {
+		ui.Say("Cancelling the spot request...")
+		err := retryThrottled(func() error {
+			_, err := ec2conn.CancelSpotRequests([]string{s.spotRequestId})
+			return err
+		})
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error cancelling spot request, may still be around: %s", err))
+		} else {
+			packer.UntrackTempResource(s.spotRequestResource)
+		}
+	}
+
+	// A spot request that was fulfilled as it was cancelled still
+	// launched its instance.
+	if s.instance == nil && s.spotRequestId != "" {
+		s.instance = spotRequestInstance(ec2conn, s.spotRequestId)
+	}
+
 	if s.instance == nil {
 		return
 	}
 
-	ec2conn := state["ec2"].(*ec2.EC2)
-	ui := state["ui"].(packer.Ui)
-
 	ui.Say("Terminating the source AWS instance...")
-	if _, err := ec2conn.TerminateInstances([]string{s.instance.InstanceId}); err != nil {
+	err := retryThrottled(func() error {
+		_, err := ec2conn.TerminateInstances([]string{s.instance.InstanceId})
+		return err
+	})
+	if err != nil {
 		ui.Error(fmt.Sprintf("Error terminating instance, may still be around: %s", err))
 		return
 	}
 
+	if s.resource != nil {
+		packer.UntrackTempResource(s.resource)
+	}
+
+	if err := bag.Checkpoint().Delete(checkpointSourceInstance); err != nil {
+		log.Printf("[WARN] Error saving checkpoint: %s", err)
+	}
+
 	pending := []string{"pending", "running", "shutting-down", "stopped", "stopping"}
 	waitForState(ec2conn, s.instance, pending, "terminated")
 }
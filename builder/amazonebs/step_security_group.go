@@ -6,52 +6,123 @@ import (
 	"fmt"
 	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/builder/common"
 	"github.com/mitchellh/packer/packer"
 	"log"
 )
 
+// The name that the temporary security group is saved in the checkpoint
+// under.
+const checkpointSecurityGroup = "security_group"
+
 type stepSecurityGroup struct {
-	groupId string
+	groupId  string
+	resource *packer.TempResource
 }
 
 func (s *stepSecurityGroup) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(config)
-	ec2conn := state["ec2"].(*ec2.EC2)
-	ui := state["ui"].(packer.Ui)
-
-	// Create the group
-	ui.Say("Creating temporary security group for this instance...")
-	groupName := fmt.Sprintf("packer %s", hex.EncodeToString(identifier.NewUUID().Raw()))
-	log.Printf("Temporary group name: %s", groupName)
-	groupResp, err := ec2conn.CreateSecurityGroup(groupName, "Temporary group for Packer")
-	if err != nil {
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+	bag := newStepState(state)
+	config := bag.config()
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
+
+	// The groups of the configuration are used as they are, so they must
+	// allow access to the communicator already.
+	if len(config.SecurityGroupIds) > 0 {
+		log.Printf("Using security groups: %v", config.SecurityGroupIds)
+		bag.setSecurityGroupIds(config.SecurityGroupIds)
+		return multistep.ActionContinue
+	}
+
+	// Find who to allow access from before creating anything, so that
+	// nothing is left to clean up if it can't be found out.
+	cidr := config.TemporarySGSourceCidr
+	if cidr == "" && config.Communicator == "ssh" &&
+		(config.SSHBastionHost != "" || config.SSHProxyHost != "") {
+		// Connections come from the bastion host or the proxy, whose
+		// address isn't known to Packer.
+		ui.Message("The temporary security group allows access from anywhere, since " +
+			"Packer connects through a bastion host or proxy.")
+		cidr = "0.0.0.0/0"
+	} else if cidr == "" {
+		var err error
+		cidr, err = sourceCIDR(config)
+		if err != nil {
+			err := fmt.Errorf(
+				"Error finding the address that Packer connects from. Set "+
+					"temporary_security_group_source_cidr to the addresses to allow: %s", err)
+			return bag.Halt(err)
+		}
+	}
+
+	// Create the group, unless the build that is resumed created one
+	checkpoint := bag.Checkpoint()
+	groupId := s.resumeGroup(bag)
+	if groupId == "" {
+		ui.Say("Creating temporary security group for this instance...")
+		groupName := fmt.Sprintf("packer %s", hex.EncodeToString(identifier.NewUUID().Raw()))
+		log.Printf("Temporary group name: %s", groupName)
+		var groupResp *ec2.CreateSecurityGroupResp
+		err := retryCreate(func() (err error) {
+			groupResp, err = ec2conn.CreateSecurityGroup(ec2.SecurityGroup{
+				Name:        groupName,
+				Description: "Temporary group for Packer",
+				VpcId:       config.VpcId,
+			})
+			return
+		})
+		if err != nil {
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		groupId = groupResp.Id
+		if err := checkpoint.Set(checkpointSecurityGroup, map[string]string{"id": groupId}); err != nil {
+			log.Printf("[WARN] Error saving checkpoint: %s", err)
+		}
 	}
 
 	// Set the group ID so we can delete it later
-	s.groupId = groupResp.Id
+	s.groupId = groupId
+	s.resource = &packer.TempResource{
+		Kind: "security group",
+		ID:   s.groupId,
+		Hint: fmt.Sprintf("delete it in the %s region", config.Region),
+	}
+	packer.TrackTempResource(s.resource)
+
+	// Authorize access to the communicator
+	name, port := "SSH", config.SSHPort
+	if config.Communicator == "winrm" {
+		name, port = "WinRM", config.WinRMPort
+	}
 
-	// Authorize the SSH access
 	perms := []ec2.IPPerm{
 		ec2.IPPerm{
 			Protocol:  "tcp",
-			FromPort:  config.SSHPort,
-			ToPort:    config.SSHPort,
-			SourceIPs: []string{"0.0.0.0/0"},
+			FromPort:  port,
+			ToPort:    port,
+			SourceIPs: []string{cidr},
 		},
 	}
 
-	ui.Say("Authorizing SSH access on the temporary security group...")
-	if _, err := ec2conn.AuthorizeSecurityGroup(groupResp.SecurityGroup, perms); err != nil {
+	ui.Say(fmt.Sprintf("Authorizing %s access from %s on the temporary security group...", name, cidr))
+	// The group of a resumed build may allow the access already
+	err := retryNotFound(func() error {
+		_, err := ec2conn.AuthorizeSecurityGroup(ec2.SecurityGroup{Id: s.groupId}, perms)
+		return err
+	})
+	if ec2err, ok := err.(*ec2.Error); ok && ec2err.Code == "InvalidPermission.Duplicate" {
+		err = nil
+	}
+
+	if err != nil {
 		err := fmt.Errorf("Error creating temporary security group: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Set some state data for use in future steps
-	state["securityGroupId"] = s.groupId
+	bag.setSecurityGroupIds([]string{s.groupId})
 
 	return multistep.ActionContinue
 }
@@ -61,14 +132,56 @@ func (s *stepSecurityGroup) Cleanup(state map[string]interface{}) {
 		return
 	}
 
-	ec2conn := state["ec2"].(*ec2.EC2)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	ec2conn := bag.ec2Conn()
+	ui := bag.Ui()
 
 	ui.Say("Deleting temporary security group...")
-	_, err := ec2conn.DeleteSecurityGroup(ec2.SecurityGroup{Id: s.groupId})
+	// The group can't be deleted until EC2 is done with the terminated
+	// instance that used it, which can take minutes.
+	r := &common.Retry{
+		Attempts: 12,
+		ShouldRetry: func(err error) bool {
+			ec2err, ok := err.(*ec2.Error)
+			return isThrottled(err) || (ok && ec2err.Code == "DependencyViolation")
+		},
+	}
+
+	err := r.Run(func() error {
+		_, err := ec2conn.DeleteSecurityGroup(ec2.SecurityGroup{Id: s.groupId})
+		return err
+	})
 	if err != nil {
-		log.Printf("Error deleting security group: %s", err)
+		log.Printf("[ERROR] Error deleting security group: %s", err)
 		ui.Error(fmt.Sprintf(
 			"Error cleaning up security group. Please delete the group manually: %s", s.groupId))
+	} else {
+		packer.UntrackTempResource(s.resource)
+		if err := bag.Checkpoint().Delete(checkpointSecurityGroup); err != nil {
+			log.Printf("[WARN] Error saving checkpoint: %s", err)
+		}
+	}
+}
+
+// resumeGroup returns the ID of the temporary security group that the
+// build that is resumed created, if it still exists.
+func (s *stepSecurityGroup) resumeGroup(bag stepState) string {
+	values, ok := bag.Checkpoint().Get(checkpointSecurityGroup)
+	if !ok {
+		return ""
+	}
+
+	groupId := values["id"]
+	var resp *ec2.SecurityGroupsResp
+	err := retryThrottled(func() (err error) {
+		resp, err = bag.ec2Conn().SecurityGroups([]ec2.SecurityGroup{ec2.SecurityGroup{Id: groupId}}, nil)
+		return
+	})
+	if err != nil || len(resp.Groups) == 0 {
+		log.Printf("[INFO] The security group of the earlier build is gone: %s", groupId)
+		return ""
 	}
+
+	bag.Ui().Say(fmt.Sprintf("Using the temporary security group of the earlier build: %s", groupId))
+	return groupId
 }
@@ -2,26 +2,26 @@ package amazonebs
 
 import (
 	"fmt"
-	"github.com/mitchellh/goamz/ec2"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 )
 
 type stepStopInstance struct{}
 
 func (s *stepStopInstance) Run(state map[string]interface{}) multistep.StepAction {
-	ec2conn := state["ec2"].(*ec2.EC2)
-	instance := state["instance"].(*ec2.Instance)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	ec2conn := bag.ec2Conn()
+	instance := bag.instance()
+	ui := bag.Ui()
 
 	// Stop the instance so we can create an AMI from it
 	ui.Say("Stopping the source instance...")
-	_, err := ec2conn.StopInstances(instance.InstanceId)
+	err := retryThrottled(func() error {
+		_, err := ec2conn.StopInstances(instance.InstanceId)
+		return err
+	})
 	if err != nil {
 		err := fmt.Errorf("Error stopping instance: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Wait for the instance to actual stop
@@ -29,9 +29,7 @@ func (s *stepStopInstance) Run(state map[string]interface{}) multistep.StepActio
 	instance, err = waitForState(ec2conn, instance, []string{"running", "stopping"}, "stopped")
 	if err != nil {
 		err := fmt.Errorf("Error waiting for instance to stop: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	return multistep.ActionContinue
@@ -0,0 +1,136 @@
+package amazonebs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/multistep"
+	"sort"
+)
+
+// stepTagAMI tags the AMIs, in every region, with the metadata of the build, so that where
+// an image came from can be told from EC2, and with the tags of the
+// configuration. The snapshots that back the AMIs are tagged with the
+// snapshot tags of the configuration.
+type stepTagAMI struct{}
+
+func (s *stepTagAMI) Run(state map[string]interface{}) multistep.StepAction {
+	bag := newStepState(state)
+	config := bag.config()
+	amis := bag.amis()
+	auth := bag.auth()
+	ui := bag.Ui()
+
+	regions := make([]string, 0, len(amis))
+	for region := range amis {
+		regions = append(regions, region)
+	}
+
+	sort.Strings(regions)
+
+	// Tags are per region, so the AMI and its copies are tagged in the
+	// regions they are in.
+	for _, region := range regions {
+		ec2conn := bag.ec2Conn()
+		if region != config.Region {
+			ec2conn = ec2.New(auth, aws.Regions[region])
+		}
+
+		ids := []string{amis[region]}
+		if tags := amiTags(config); len(tags) > 0 {
+			ui.Say(fmt.Sprintf("Tagging the AMI in %s...", region))
+			err := retryNotFound(func() error {
+				_, err := ec2conn.CreateTags(ids, tags)
+				return err
+			})
+			if err != nil {
+				err := fmt.Errorf("Error tagging AMI: %s", err)
+				return bag.Halt(err)
+			}
+		}
+
+		if len(config.SnapshotTags) > 0 {
+			snapshotIds, err := amiSnapshots(ec2conn, ids)
+			if err != nil {
+				err := fmt.Errorf("Error finding the snapshots of the AMI: %s", err)
+				return bag.Halt(err)
+			}
+
+			ui.Say(fmt.Sprintf("Tagging the snapshots of the AMI in %s...", region))
+			err = retryNotFound(func() error {
+				_, err := ec2conn.CreateTags(snapshotIds, ec2Tags(config.SnapshotTags))
+				return err
+			})
+			if err != nil {
+				err := fmt.Errorf("Error tagging snapshots: %s", err)
+				return bag.Halt(err)
+			}
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepTagAMI) Cleanup(map[string]interface{}) {
+	// No cleanup...
+}
+
+// amiTags returns the tags of the AMI: the metadata of the build, and the
+// tags of the configuration, which take precedence over the metadata.
+func amiTags(config config) []ec2.Tag {
+	tags := make(map[string]string)
+	for k, v := range config.PackerMetadata {
+		tags[k] = v
+	}
+
+	for k, v := range config.AMITags {
+		tags[k] = v
+	}
+
+	return ec2Tags(tags)
+}
+
+// amiSnapshots returns the IDs of the snapshots that back the AMIs.
+func amiSnapshots(ec2conn *ec2.EC2, ids []string) ([]string, error) {
+	var resp *ec2.ImagesResp
+	err := retryNotFound(func() (err error) {
+		resp, err = ec2conn.Images(ids, ec2.NewFilter())
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, image := range resp.Images {
+		for _, device := range image.BlockDevices {
+			if device.SnapshotId != "" {
+				result = append(result, device.SnapshotId)
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no snapshots back the AMI")
+	}
+
+	return result, nil
+}
+
+// ec2Tags turns the keys and values into EC2 tags, sorted by their keys.
+func ec2Tags(values map[string]string) []ec2.Tag {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	tags := make([]ec2.Tag, len(keys))
+	for i, k := range keys {
+		tags[i] = ec2.Tag{Key: k, Value: values[k]}
+	}
+
+	return tags
+}
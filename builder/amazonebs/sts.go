@@ -0,0 +1,245 @@
+package amazonebs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/multistep"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The endpoint of STS, which is the same for every region, and the region
+// that requests to it are signed for.
+var stsEndpoint = "https://sts.amazonaws.com/"
+
+const stsRegion = "us-east-1"
+
+// The shortest and longest that STS gives the credentials of a role for.
+const (
+	assumeRoleMinDuration = 15 * time.Minute
+	assumeRoleMaxDuration = 12 * time.Hour
+)
+
+// The names that STS allows for the session of an assumed role.
+var assumeRoleSessionNameRe = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+type assumeRoleResponse struct {
+	AccessKeyId     string    `xml:"AssumeRoleResult>Credentials>AccessKeyId"`
+	SecretAccessKey string    `xml:"AssumeRoleResult>Credentials>SecretAccessKey"`
+	SessionToken    string    `xml:"AssumeRoleResult>Credentials>SessionToken"`
+	Expiration      time.Time `xml:"AssumeRoleResult>Credentials>Expiration"`
+}
+
+type stsErrorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// assumeRole assumes the role of the configuration with the credentials in
+// auth, and returns the temporary credentials of the role and when they
+// expire. Those are what the builder calls EC2 with, so the credentials
+// that Packer is given only need to be allowed to assume the role.
+func assumeRole(auth aws.Auth, config config) (aws.Auth, time.Time, error) {
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {config.AssumeRoleARN},
+		"RoleSessionName": {config.AssumeRoleSessionName},
+		"DurationSeconds": {strconv.Itoa(int(config.AssumeRoleDuration.Seconds()))},
+	}
+
+	if config.AssumeRoleExternalID != "" {
+		params.Set("ExternalId", config.AssumeRoleExternalID)
+	}
+
+	body := params.Encode()
+	req, err := http.NewRequest("POST", stsEndpoint, strings.NewReader(body))
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signSTS(req, body, auth, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+
+	if resp.StatusCode != 200 {
+		var stsErr stsErrorResponse
+		if err := xml.Unmarshal(data, &stsErr); err != nil || stsErr.Code == "" {
+			return aws.Auth{}, time.Time{}, fmt.Errorf("STS responded with %s", resp.Status)
+		}
+
+		return aws.Auth{}, time.Time{}, fmt.Errorf("%s: %s", stsErr.Code, stsErr.Message)
+	}
+
+	var result assumeRoleResponse
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("Error parsing the response of STS: %s", err)
+	}
+
+	if result.AccessKeyId == "" || result.SecretAccessKey == "" {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("STS responded without credentials")
+	}
+
+	role := aws.Auth{
+		AccessKey: result.AccessKeyId,
+		SecretKey: result.SecretAccessKey,
+		Token:     result.SessionToken,
+	}
+
+	return role, result.Expiration, nil
+}
+
+// How recently the credentials of an assumed role must have been gotten
+// for them not to be renewed before a step.
+const assumeRoleRenewAfter = 5 * time.Minute
+
+// assumedRole is the role that the builder builds with. Its credentials
+// expire after assume_role_duration, so they are renewed during the
+// build.
+type assumedRole struct {
+	// The credentials that the role is assumed with.
+	auth aws.Auth
+
+	config config
+
+	// When the current credentials of the role were gotten.
+	renewed time.Time
+}
+
+// renew assumes the role again, unless its credentials were just gotten,
+// and puts the new credentials in the connection to EC2 and the state.
+// The connection is changed in place, so the steps that have it use the
+// new credentials too.
+func (r *assumedRole) renew(bag stepState) error {
+	if time.Since(r.renewed) < assumeRoleRenewAfter {
+		return nil
+	}
+
+	auth, expiration, err := assumeRole(r.auth, r.config)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Renewed the credentials of the role, which expire at %s", expiration)
+	r.renewed = time.Now()
+	*bag.ec2Conn() = *ec2.New(auth, aws.Regions[r.config.Region])
+	bag.setAuth(auth)
+	return nil
+}
+
+// renewRoleStep renews the credentials of the assumed role before the
+// step it wraps runs and cleans up. A step starts with the whole duration
+// of the credentials then, and the build can clean up after itself even if
+// it took longer than the credentials last.
+type renewRoleStep struct {
+	multistep.Step
+
+	role *assumedRole
+}
+
+func (s *renewRoleStep) Unwrap() multistep.Step {
+	return s.Step
+}
+
+func (s *renewRoleStep) Run(state map[string]interface{}) multistep.StepAction {
+	bag := newStepState(state)
+	if err := s.role.renew(bag); err != nil {
+		err := fmt.Errorf("Error renewing the credentials of the role: %s", err)
+		return bag.Halt(err)
+	}
+
+	return s.Step.Run(state)
+}
+
+func (s *renewRoleStep) Cleanup(state map[string]interface{}) {
+	// Cleaning up is still tried with the credentials there are, which
+	// may not have expired yet.
+	bag := newStepState(state)
+	if err := s.role.renew(bag); err != nil {
+		log.Printf("[ERROR] Error renewing the credentials of the role: %s", err)
+		bag.Ui().Error(fmt.Sprintf(
+			"Error renewing the credentials of the role, cleaning up may fail: %s", err))
+	}
+
+	s.Step.Cleanup(state)
+}
+
+// signSTS signs the request to STS with version 4 of the AWS signature,
+// which signs the host, the content type, the headers of AWS and the body.
+func signSTS(req *http.Request, body string, auth aws.Auth, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/sts/aws4_request", amzDate[:8], stsRegion)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if auth.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", auth.Token)
+	}
+
+	// The headers, which are sorted by name.
+	headers := []string{"content-type", "host", "x-amz-date"}
+	values := []string{req.Header.Get("Content-Type"), req.URL.Host, amzDate}
+	if auth.Token != "" {
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, auth.Token)
+	}
+
+	canonicalHeaders := ""
+	for i, name := range headers {
+		canonicalHeaders += name + ":" + values[i] + "\n"
+	}
+
+	signedHeaders := strings.Join(headers, ";")
+	bodyHash := sha256.Sum256([]byte(body))
+
+	// The parameters are in the body, so the query is empty
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + auth.SecretKey)
+	for _, part := range []string{amzDate[:8], stsRegion, "sts", "aws4_request"} {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(part))
+		key = h.Sum(nil)
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		auth.AccessKey, scope, signedHeaders, hex.EncodeToString(h.Sum(nil))))
+}
@@ -0,0 +1,242 @@
+package amazonebs
+
+import (
+	"bytes"
+	"github.com/mitchellh/goamz/aws"
+	"github.com/mitchellh/goamz/ec2"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAssumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <SessionToken>token</SessionToken>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <Expiration>2013-07-08T17:44:04Z</Expiration>
+      <AccessKeyId>ASIAKEY</AccessKeyId>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+const testSTSErrorResponse = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>Not authorized to assume the role</Message>
+  </Error>
+</ErrorResponse>`
+
+func testSTSServer(t *testing.T, status int, body string) (*httptest.Server, *http.Request) {
+	var req http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		req = *r
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	stsEndpoint = server.URL + "/"
+	return server, &req
+}
+
+func testAssumeRoleConfig() config {
+	return config{
+		AssumeRoleARN:         "arn:aws:iam::123456789012:role/packer",
+		AssumeRoleExternalID:  "external",
+		AssumeRoleSessionName: "packer",
+		AssumeRoleDuration:    time.Hour,
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	server, req := testSTSServer(t, 200, testAssumeRoleResponse)
+	defer server.Close()
+	defer func() { stsEndpoint = "https://sts.amazonaws.com/" }()
+
+	auth := aws.Auth{AccessKey: "AKID", SecretKey: "secret", Token: "session"}
+	role, expiration, err := assumeRole(auth, testAssumeRoleConfig())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := aws.Auth{AccessKey: "ASIAKEY", SecretKey: "secret", Token: "token"}
+	if role != expected {
+		t.Fatalf("bad: %#v", role)
+	}
+
+	if !expiration.Equal(time.Date(2013, 7, 8, 17, 44, 4, 0, time.UTC)) {
+		t.Fatalf("bad: %s", expiration)
+	}
+
+	params := map[string]string{
+		"Action":          "AssumeRole",
+		"RoleArn":         "arn:aws:iam::123456789012:role/packer",
+		"RoleSessionName": "packer",
+		"ExternalId":      "external",
+		"DurationSeconds": "3600",
+	}
+
+	for name, value := range params {
+		if req.PostForm.Get(name) != value {
+			t.Fatalf("bad %s: %#v", name, req.PostForm)
+		}
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		!strings.Contains(authorization, "/us-east-1/sts/aws4_request") ||
+		!strings.Contains(authorization, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Fatalf("bad: %s", authorization)
+	}
+
+	if req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Fatalf("bad: %#v", req.Header)
+	}
+}
+
+func TestAssumeRole_Error(t *testing.T) {
+	server, _ := testSTSServer(t, 403, testSTSErrorResponse)
+	defer server.Close()
+	defer func() { stsEndpoint = "https://sts.amazonaws.com/" }()
+
+	auth := aws.Auth{AccessKey: "AKID", SecretKey: "secret"}
+	_, _, err := assumeRole(auth, testAssumeRoleConfig())
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if err.Error() != "AccessDenied: Not authorized to assume the role" {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestSignSTS(t *testing.T) {
+	now := time.Date(2013, 7, 8, 16, 44, 4, 0, time.UTC)
+	sign := func(secret string) string {
+		req, err := http.NewRequest("POST", "https://sts.amazonaws.com/", nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		signSTS(req, "Action=AssumeRole", aws.Auth{AccessKey: "AKID", SecretKey: secret}, now)
+
+		if req.Header.Get("X-Amz-Date") != "20130708T164404Z" {
+			t.Fatalf("bad: %#v", req.Header)
+		}
+
+		if req.Header.Get("X-Amz-Security-Token") != "" {
+			t.Fatalf("bad: %#v", req.Header)
+		}
+
+		return req.Header.Get("Authorization")
+	}
+
+	authorization := sign("secret")
+	prefix := "AWS4-HMAC-SHA256 Credential=AKID/20130708/us-east-1/sts/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, Signature="
+	if !strings.HasPrefix(authorization, prefix) || len(authorization) != len(prefix)+64 {
+		t.Fatalf("bad: %s", authorization)
+	}
+
+	// The signature is the same for the same request, and depends on the
+	// secret key.
+	if sign("secret") != authorization || sign("other") == authorization {
+		t.Fatal("bad signature")
+	}
+}
+
+func testRenewRoleState() (map[string]interface{}, *ec2.EC2) {
+	state := make(map[string]interface{})
+	bag := newStepState(state)
+	bag.SetUi(&packer.ReaderWriterUi{Reader: new(bytes.Buffer), Writer: new(bytes.Buffer)})
+
+	conn := ec2.New(aws.Auth{AccessKey: "OLD"}, aws.Regions["us-east-1"])
+	bag.setEC2Conn(conn)
+	bag.setAuth(aws.Auth{AccessKey: "OLD"})
+	return state, conn
+}
+
+func TestAssumedRoleRenew(t *testing.T) {
+	server, _ := testSTSServer(t, 200, testAssumeRoleResponse)
+	defer server.Close()
+	defer func() { stsEndpoint = "https://sts.amazonaws.com/" }()
+
+	config := testAssumeRoleConfig()
+	config.Region = "us-east-1"
+	role := &assumedRole{auth: aws.Auth{AccessKey: "AKID"}, config: config}
+
+	// Credentials that were just gotten aren't renewed
+	state, conn := testRenewRoleState()
+	bag := newStepState(state)
+	role.renewed = time.Now()
+	if err := role.renew(bag); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if conn.Auth.AccessKey != "OLD" || bag.auth().AccessKey != "OLD" {
+		t.Fatalf("bad: %#v", conn.Auth)
+	}
+
+	// Older ones are, in the connection that the steps already have
+	role.renewed = time.Now().Add(-assumeRoleRenewAfter)
+	if err := role.renew(bag); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if conn.Auth.AccessKey != "ASIAKEY" || bag.auth().AccessKey != "ASIAKEY" {
+		t.Fatalf("bad: %#v", conn.Auth)
+	}
+
+	if time.Since(role.renewed) > time.Minute {
+		t.Fatalf("bad: %s", role.renewed)
+	}
+}
+
+func TestRenewRoleStep_Error(t *testing.T) {
+	server, _ := testSTSServer(t, 403, testSTSErrorResponse)
+	defer server.Close()
+	defer func() { stsEndpoint = "https://sts.amazonaws.com/" }()
+
+	role := &assumedRole{config: testAssumeRoleConfig()}
+	inner := &testRenewStep{}
+	step := &renewRoleStep{Step: inner, role: role}
+
+	state, _ := testRenewRoleState()
+	if action := step.Run(state); action != multistep.ActionHalt {
+		t.Fatalf("bad: %#v", action)
+	}
+
+	if inner.ran || newStepState(state).Error() == nil {
+		t.Fatal("should halt before the step runs")
+	}
+
+	// The step still cleans up with the credentials there are
+	step.Cleanup(state)
+	if !inner.cleaned {
+		t.Fatal("should clean up")
+	}
+}
+
+type testRenewStep struct {
+	ran     bool
+	cleaned bool
+}
+
+func (s *testRenewStep) Run(map[string]interface{}) multistep.StepAction {
+	s.ran = true
+	return multistep.ActionContinue
+}
+
+func (s *testRenewStep) Cleanup(map[string]interface{}) {
+	s.cleaned = true
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Checkpoint records what the steps of a build have done in a file, so
+// that a build that is interrupted or fails can be resumed with
+// "packer build -resume" without doing it again. Each step saves the
+// values it needs to pick up where it left off under its own name.
+//
+// Steps get the checkpoint from the state with StateBag. Only steps whose
+// work outlives a build that doesn't finish should use it, such as the
+// instance of a build that was killed or that failed with
+// "-on-error=abort". A step deletes its values once it cleans up what it
+// saved them for, since there is nothing to pick up then. Downloads don't
+// need it, since the download cache resumes and verifies them already.
+type Checkpoint struct {
+	path  string
+	steps map[string]map[string]string
+	l     sync.Mutex
+}
+
+// The characters of a build name that are kept in the name of its
+// checkpoint file. The others could make it a path.
+var checkpointNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// CheckpointPath returns the path of the checkpoint file of the build,
+// which is in the current directory. The characters of the build name that
+// don't belong in a file name are replaced, and a hash of the build name is
+// added then, so that builds whose names only differ in those characters
+// don't share a file.
+func CheckpointPath(buildName string) string {
+	name := checkpointNameRe.ReplaceAllString(buildName, "_")
+	if name != buildName {
+		hash := sha1.Sum([]byte(buildName))
+		name = fmt.Sprintf("%s-%s", name, hex.EncodeToString(hash[:4]))
+	}
+
+	return fmt.Sprintf(".packer-checkpoint-%s", name)
+}
+
+// LoadCheckpoint returns the checkpoint in the file at the path. If the
+// build isn't resumed, the earlier checkpoint is thrown away and the
+// build starts from scratch.
+func LoadCheckpoint(path string, resume bool) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path:  path,
+		steps: make(map[string]map[string]string),
+	}
+
+	if !resume {
+		if err := c.Remove(); err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("[INFO] No checkpoint to resume from: %s", path)
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.steps); err != nil {
+		return nil, fmt.Errorf("Error reading checkpoint %s: %s", path, err)
+	}
+
+	log.Printf("[INFO] Resuming from checkpoint: %s", path)
+	return c, nil
+}
+
+// Get returns the values that the step saved, and whether it saved any.
+func (c *Checkpoint) Get(step string) (map[string]string, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	values, ok := c.steps[step]
+	return values, ok
+}
+
+// Set saves the values of the step, writing the checkpoint file. The
+// file is only readable by the user, since the values can be secret, such
+// as a private key.
+func (c *Checkpoint) Set(step string, values map[string]string) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.steps[step] = values
+	return c.write()
+}
+
+// Delete deletes the values of the step, once the step cleaned up what it
+// saved them for. The checkpoint file is removed once no step has values.
+func (c *Checkpoint) Delete(step string) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if _, ok := c.steps[step]; !ok {
+		return nil
+	}
+
+	delete(c.steps, step)
+	if len(c.steps) == 0 {
+		return c.remove()
+	}
+
+	return c.write()
+}
+
+// Remove removes the checkpoint file, which builds do when they finish,
+// since there is nothing to resume.
+func (c *Checkpoint) Remove() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.steps = make(map[string]map[string]string)
+	return c.remove()
+}
+
+func (c *Checkpoint) remove() error {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Checkpoint) write() error {
+	data, err := json.MarshalIndent(c.steps, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] Saving checkpoint: %s", c.path)
+	return ioutil.WriteFile(c.path, data, 0600)
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, CheckpointPath("foo"))
+	c, err := LoadCheckpoint(path, true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, ok := c.Get("step"); ok {
+		t.Fatal("should not have values")
+	}
+
+	if err := c.Set("step", map[string]string{"foo": "bar"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Resuming reads the values back
+	c, err = LoadCheckpoint(path, true)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	values, ok := c.Get("step")
+	if !ok || values["foo"] != "bar" {
+		t.Fatalf("bad: %#v", values)
+	}
+
+	// The file is removed once no step has values
+	if err := c.Delete("step"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("checkpoint should be removed")
+	}
+
+	if err := c.Set("step", map[string]string{"foo": "bar"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Not resuming starts from scratch
+	c, err = LoadCheckpoint(path, false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, ok := c.Get("step"); ok {
+		t.Fatal("should not have values")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("checkpoint should be removed")
+	}
+}
+
+func TestCheckpointPath(t *testing.T) {
+	if path := CheckpointPath("amazon-ebs"); path != ".packer-checkpoint-amazon-ebs" {
+		t.Fatalf("bad: %s", path)
+	}
+
+	// Build names aren't paths
+	for _, name := range []string{"../foo", "foo/bar", "/etc/passwd"} {
+		path := CheckpointPath(name)
+		if strings.Contains(path, "/") || !strings.HasPrefix(path, ".packer-checkpoint-") {
+			t.Fatalf("bad: %s", path)
+		}
+	}
+
+	if CheckpointPath("foo/bar") == CheckpointPath("foo_bar") {
+		t.Fatal("should not share a file")
+	}
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// The prefix of checksums that are read from a checksums file, such as
+// "file:SHA256SUMS", instead of given in hex.
+const checksumFilePrefix = "file:"
+
+// ChecksumTypes are the types of checksums that downloads are verified
+// with.
+var ChecksumTypes = []string{"md5", "sha1", "sha256", "sha512"}
+
+// ChecksumHash returns the hash implementation of the checksum type, which
+// is one of ChecksumTypes.
+func ChecksumHash(checksumType string) (hash.Hash, error) {
+	switch strings.ToLower(checksumType) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+
+	return nil, fmt.Errorf(
+		"Unknown checksum type '%s', must be one of: %s",
+		checksumType, strings.Join(ChecksumTypes, ", "))
+}
+
+// ValidateChecksum checks the checksum settings of a download when the
+// configuration is prepared, where name is the setting of the checksum,
+// and the setting of its type is name + "_type". The checksum itself is
+// only read when the file is downloaded, since it may be in a checksums
+// file.
+func ValidateChecksum(name string, checksumType string, checksum string) []error {
+	errs := make([]error, 0)
+
+	if _, err := ChecksumHash(checksumType); err != nil {
+		errs = append(errs, fmt.Errorf(
+			"%s_type must be one of: %s", name, strings.Join(ChecksumTypes, ", ")))
+	}
+
+	if strings.HasPrefix(checksum, checksumFilePrefix) {
+		if _, err := url.Parse(checksum[len(checksumFilePrefix):]); err != nil {
+			errs = append(errs, fmt.Errorf("%s has an invalid checksums file URL: %s", name, err))
+		}
+	}
+
+	return errs
+}
+
+// Checksum returns the checksum that the file at the URL must have. The
+// checksum is either given in hex, or is "file:" followed by the URL of a
+// checksums file, such as a SHA256SUMS file, that lists the checksum of
+// the file by its name. The URL of the checksums file can be relative to
+// the URL of the file, such as "file:SHA256SUMS" for the checksums file
+// next to it.
+func Checksum(checksum string, fileUrl string) ([]byte, error) {
+	if !strings.HasPrefix(checksum, checksumFilePrefix) {
+		return hex.DecodeString(strings.ToLower(checksum))
+	}
+
+	base, err := url.Parse(fileUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := url.Parse(checksum[len(checksumFilePrefix):])
+	if err != nil {
+		return nil, err
+	}
+
+	checksumsUrl := base.ResolveReference(ref)
+	log.Printf("Reading checksum from checksums file: %s", checksumsUrl)
+	checksums, err := readChecksums(checksumsUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading checksums file: %s", err)
+	}
+
+	name := path.Base(base.Path)
+	value, err := ParseChecksums(bytes.NewReader(checksums), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(strings.ToLower(value))
+}
+
+// ParseChecksums returns the checksum of the file with the name in a
+// checksums file, in the format of the output of tools such as sha256sum.
+func ParseChecksums(r io.Reader, name string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			// Bogus line
+			continue
+		}
+
+		// Files checksummed in binary mode are marked with a "*"
+		file := strings.TrimPrefix(parts[1], "*")
+		if path.Base(file) == name {
+			return parts[0], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("The checksum for the file '%s' could not be found.", name)
+}
+
+// readChecksums reads the checksums file at the URL, downloading it if
+// it isn't a local file.
+func readChecksums(u *url.URL) ([]byte, error) {
+	if u.Scheme == "" || u.Scheme == "file" {
+		return ioutil.ReadFile(u.Path)
+	}
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		return nil, err
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	download := NewDownloadClient(&DownloadConfig{
+		Url:        u.String(),
+		TargetPath: tf.Name(),
+		CopyFile:   true,
+	})
+
+	if _, err := download.Get(); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(tf.Name())
+}
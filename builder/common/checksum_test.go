@@ -0,0 +1,113 @@
+package common
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testChecksums = `
+2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  foo.iso
+fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9 *bar.iso
+`
+
+func TestChecksumHash(t *testing.T) {
+	for _, checksumType := range ChecksumTypes {
+		if _, err := ChecksumHash(checksumType); err != nil {
+			t.Fatalf("%s: %s", checksumType, err)
+		}
+	}
+
+	if _, err := ChecksumHash("crc32"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestValidateChecksum(t *testing.T) {
+	if errs := ValidateChecksum("iso_checksum", "sha256", "foo"); len(errs) > 0 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	if errs := ValidateChecksum("iso_checksum", "", "foo"); len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	if errs := ValidateChecksum("iso_checksum", "sha256", "file:%zz"); len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	checksum, err := ParseChecksums(strings.NewReader(testChecksums), "bar.iso")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if checksum != "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9" {
+		t.Fatalf("bad: %s", checksum)
+	}
+
+	if _, err := ParseChecksums(strings.NewReader(testChecksums), "baz.iso"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	checksum, err := Checksum("ACBD18DB4CC2F85CEDEF654FCCC4A4D8", "http://example.com/foo.iso")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if hex.EncodeToString(checksum) != "acbd18db4cc2f85cedef654fccc4a4d8" {
+		t.Fatalf("bad: %x", checksum)
+	}
+}
+
+func TestChecksum_File(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	if err := ioutil.WriteFile(filepath.Join(td, "SHA256SUMS"), []byte(testChecksums), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The checksums file is next to the local file
+	checksum, err := Checksum("file:SHA256SUMS", filepath.Join(td, "foo.iso"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if hex.EncodeToString(checksum) != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Fatalf("bad: %x", checksum)
+	}
+}
+
+func TestChecksum_URL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dist/SHA256SUMS" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(testChecksums))
+	}))
+	defer server.Close()
+
+	// The checksums file is next to the file at the URL
+	checksum, err := Checksum("file:SHA256SUMS", server.URL+"/dist/bar.iso")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if hex.EncodeToString(checksum) != "fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9" {
+		t.Fatalf("bad: %x", checksum)
+	}
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"strings"
+)
+
+// CommunicatorConfig contains the configuration of the communicator that
+// builders use to connect to the machine, which is one of the communicators
+// the builder has built in or a communicator plugin. It is meant to be
+// embedded into a builder's configuration with ",squash".
+type CommunicatorConfig struct {
+	// The communicator used to connect to the machine, which defaults to
+	// "ssh", or the name of a communicator plugin.
+	Communicator string `mapstructure:"communicator"`
+
+	// The configuration of the communicator plugin, which is given to
+	// the plugin as it is.
+	PluginConfig map[string]interface{} `mapstructure:"communicator_config"`
+
+	// The communicator plugins that are available, which Packer sets.
+	CommunicatorPlugins map[string]string `mapstructure:"packer_communicators"`
+}
+
+// Prepare processes the templates in, sets defaults for and validates the
+// communicator configuration, returning any errors that were found. The
+// built-in communicators are those the builder supports, such as "ssh",
+// "winrm" and "none".
+func (c *CommunicatorConfig) Prepare(t *packer.ConfigTemplate, builtin ...string) []error {
+	if c.Communicator == "" {
+		c.Communicator = "ssh"
+	}
+
+	errs := make([]error, 0)
+
+	var err error
+	c.Communicator, err = t.Process(c.Communicator, nil)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Error processing communicator: %s", err))
+	}
+
+	for _, name := range builtin {
+		if c.Communicator != name {
+			continue
+		}
+
+		if c.PluginConfig != nil {
+			errs = append(errs, errors.New(
+				"communicator_config can only be used with communicator plugins."))
+		}
+
+		return errs
+	}
+
+	if _, ok := c.CommunicatorPlugins[c.Communicator]; !ok {
+		names := make([]string, len(builtin))
+		for i, name := range builtin {
+			names[i] = fmt.Sprintf("'%s'", name)
+		}
+
+		errs = append(errs, fmt.Errorf(
+			"communicator must be %s or a communicator plugin, got: %s",
+			strings.Join(names, ", "), c.Communicator))
+	}
+
+	return errs
+}
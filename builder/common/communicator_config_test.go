@@ -0,0 +1,60 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommunicatorConfigPrepare(t *testing.T) {
+	var c CommunicatorConfig
+
+	// Test default
+	if errs := c.Prepare(testConfigTemplate(), "ssh", "winrm", "none"); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	if c.Communicator != "ssh" {
+		t.Fatalf("bad: %s", c.Communicator)
+	}
+
+	// Test winrm
+	c.Communicator = "winrm"
+	if errs := c.Prepare(testConfigTemplate(), "ssh", "winrm", "none"); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	// Test a communicator the builder doesn't have
+	errs := c.Prepare(testConfigTemplate(), "ssh", "none")
+	if len(errs) != 1 {
+		t.Fatalf("should have error: %#v", errs)
+	}
+
+	if !strings.Contains(errs[0].Error(), "'ssh', 'none' or a communicator plugin") {
+		t.Fatalf("bad: %s", errs[0])
+	}
+
+	// Test none
+	c.Communicator = "none"
+	if errs := c.Prepare(testConfigTemplate(), "ssh", "winrm", "none"); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	// Test bad
+	c.Communicator = "telnet"
+	if errs := c.Prepare(testConfigTemplate(), "ssh", "winrm", "none"); len(errs) != 1 {
+		t.Fatalf("should have error: %#v", errs)
+	}
+
+	// Test a plugin
+	c.CommunicatorPlugins = map[string]string{"telnet": "packer-communicator-telnet"}
+	c.PluginConfig = map[string]interface{}{"port": 23}
+	if errs := c.Prepare(testConfigTemplate(), "ssh", "winrm", "none"); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	// Plugin configuration only goes with plugins
+	c.Communicator = "ssh"
+	if errs := c.Prepare(testConfigTemplate(), "ssh", "winrm", "none"); len(errs) != 1 {
+		t.Fatalf("should have error: %#v", errs)
+	}
+}
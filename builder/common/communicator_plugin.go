@@ -0,0 +1,52 @@
+package common
+
+import (
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"github.com/mitchellh/packer/packer/plugin"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// StartCommunicatorPlugin starts the plugin of the communicator, which
+// must be a communicator plugin, and returns its factory prepared with
+// the communicator_config. The plugin runs until the client is killed,
+// which builders do when they clean up after the build.
+func (c *CommunicatorConfig) StartCommunicatorPlugin() (packer.CommunicatorFactory, *plugin.Client, error) {
+	path, ok := c.CommunicatorPlugins[c.Communicator]
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown communicator plugin: %s", c.Communicator)
+	}
+
+	// Use the same ports that Packer lets us use, which it sets in the
+	// environment of its plugins.
+	minPort, _ := strconv.ParseUint(os.Getenv("PACKER_PLUGIN_MIN_PORT"), 10, 32)
+	maxPort, _ := strconv.ParseUint(os.Getenv("PACKER_PLUGIN_MAX_PORT"), 10, 32)
+
+	log.Printf("Starting communicator plugin: %s", path)
+	client := plugin.NewClient(&plugin.ClientConfig{
+		Cmd:     exec.Command(path),
+		MinPort: uint(minPort),
+		MaxPort: uint(maxPort),
+	})
+
+	factory, err := client.CommunicatorFactory()
+	if err != nil {
+		client.Kill()
+		return nil, nil, fmt.Errorf("Error starting communicator plugin: %s", err)
+	}
+
+	configs := make([]interface{}, 0, 1)
+	if c.PluginConfig != nil {
+		configs = append(configs, c.PluginConfig)
+	}
+
+	if err := factory.Prepare(configs...); err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+
+	return factory, client, nil
+}
@@ -0,0 +1,21 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestStartCommunicatorPlugin(t *testing.T) {
+	c := CommunicatorConfig{Communicator: "ssh"}
+	if _, _, err := c.StartCommunicatorPlugin(); err == nil {
+		t.Fatal("should have error for a built-in communicator")
+	}
+
+	c = CommunicatorConfig{
+		Communicator:        "telnet",
+		CommunicatorPlugins: map[string]string{"telnet": "i-should-not-exist"},
+	}
+
+	if _, _, err := c.StartCommunicatorPlugin(); err == nil {
+		t.Fatal("should have error for a missing plugin")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"hash"
 	"io"
 	"log"
@@ -38,6 +39,22 @@ type DownloadConfig struct {
 	// for the downloader will be used to verify with this checksum after
 	// it is downloaded.
 	Checksum []byte
+
+	// If set, this is called with the progress of the download as it
+	// is downloaded, such as with packer.UiProgress.
+	Progress packer.ProgressFunc
+
+	// If true, a partial download that is already at the target path,
+	// such as one of a build that was interrupted, is continued instead
+	// of started over, if the downloader can resume downloads. If the
+	// resumed file doesn't match the checksum, it is downloaded again
+	// from scratch.
+	Resume bool
+
+	// The proxy that downloads go through, including those from S3 and
+	// Google Cloud Storage, usually the "packer_proxy" of the builder. If
+	// nil, the proxy environment variables are used.
+	Proxy *packer.ProxyConfig
 }
 
 // A DownloadClient helps download, verify checksums, etc.
@@ -50,8 +67,12 @@ type DownloadClient struct {
 // configuration.
 func NewDownloadClient(c *DownloadConfig) *DownloadClient {
 	if c.DownloaderMap == nil {
+		client := c.Proxy.Client()
 		c.DownloaderMap = map[string]Downloader{
-			"http": new(HTTPDownloader),
+			"gs":    &GCSDownloader{HTTPDownloader: HTTPDownloader{client: client}},
+			"http":  &HTTPDownloader{client: client},
+			"https": &HTTPDownloader{client: client},
+			"s3":    &S3Downloader{HTTPDownloader: HTTPDownloader{client: client}},
 		}
 	}
 
@@ -67,6 +88,17 @@ type Downloader interface {
 	Total() uint
 }
 
+// A ResumableDownloader is a Downloader that can continue a download
+// that is partially done.
+type ResumableDownloader interface {
+	Downloader
+
+	// Resume downloads the URL from the given offset on. If the source
+	// can't be downloaded from the offset, it returns false without
+	// writing anything, and the download must be started over.
+	Resume(dst io.Writer, src *url.URL, offset uint) (bool, error)
+}
+
 func (d *DownloadClient) Cancel() {
 	// TODO(mitchellh): Implement
 }
@@ -87,6 +119,7 @@ func (d *DownloadClient) Get() (string, error) {
 
 	// Files when we don't copy the file are special cased.
 	var finalPath string
+	resumed := false
 	if url.Scheme == "file" && !d.config.CopyFile {
 		finalPath = url.Path
 	} else {
@@ -98,20 +131,28 @@ func (d *DownloadClient) Get() (string, error) {
 			return "", fmt.Errorf("No downloader for scheme: %s", url.Scheme)
 		}
 
-		// Otherwise, download using the downloader.
-		f, err := os.Create(finalPath)
+		resumed, err = d.download(finalPath, url, d.config.Resume)
 		if err != nil {
 			return "", err
 		}
-		defer f.Close()
-
-		log.Printf("Downloading: %s", url.String())
-		err = d.downloader.Download(f, url)
 	}
 
 	if d.config.Hash != nil {
 		var verify bool
 		verify, err = d.VerifyChecksum(finalPath)
+
+		// The partial file that was resumed may not have been the start
+		// of this file, such as if the file at the URL changed, so it is
+		// downloaded again from scratch.
+		if err == nil && !verify && resumed {
+			log.Printf("[WARN] Resumed download doesn't match the checksum, starting over: %s", url.String())
+			if _, err := d.download(finalPath, url, false); err != nil {
+				return "", err
+			}
+
+			verify, err = d.VerifyChecksum(finalPath)
+		}
+
 		if err == nil && !verify {
 			err = fmt.Errorf("checksums didn't match expected: %s", hex.EncodeToString(d.config.Checksum))
 		}
@@ -120,6 +161,63 @@ func (d *DownloadClient) Get() (string, error) {
 	return finalPath, err
 }
 
+// download downloads the URL to the path with the downloader. If resume
+// is true, what is already at the path is continued if the downloader
+// can, in which case it returns true.
+func (d *DownloadClient) download(path string, url *url.URL, resume bool) (bool, error) {
+	var offset uint
+	resumable, canResume := d.downloader.(ResumableDownloader)
+	if resume && canResume {
+		if fi, err := os.Stat(path); err == nil {
+			offset = uint(fi.Size())
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	// The total is only known once the download started, so it is
+	// asked of the downloader as the progress is reported.
+	var dst io.Writer = f
+	if d.config.Progress != nil {
+		downloader := d.downloader
+		dst = &packer.ProgressWriter{
+			Writer: f,
+			Progress: func(current int64, _ int64) {
+				d.config.Progress(int64(offset)+current, int64(downloader.Total()))
+			},
+		}
+	}
+
+	if offset > 0 {
+		if _, err := f.Seek(int64(offset), os.SEEK_SET); err != nil {
+			return false, err
+		}
+
+		log.Printf("[INFO] Resuming download at byte %d: %s", offset, url.String())
+		resumed, err := resumable.Resume(dst, url, offset)
+		if err != nil || resumed {
+			return resumed, err
+		}
+
+		log.Printf("[INFO] Download can't be resumed, starting over: %s", url.String())
+		offset = 0
+		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+			return false, err
+		}
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return false, err
+	}
+
+	log.Printf("Downloading: %s", url.String())
+	return false, d.downloader.Download(dst, url)
+}
+
 // PercentProgress returns the download progress as a percentage.
 func (d *DownloadClient) PercentProgress() uint {
 	if d.downloader == nil {
@@ -151,6 +249,7 @@ func (d *DownloadClient) VerifyChecksum(path string) (bool, error) {
 // HTTPDownloader is an implementation of Downloader that downloads
 // files over HTTP.
 type HTTPDownloader struct {
+	client   *http.Client
 	progress uint
 	total    uint
 }
@@ -160,14 +259,77 @@ func (*HTTPDownloader) Cancel() {
 }
 
 func (d *HTTPDownloader) Download(dst io.Writer, src *url.URL) error {
-	resp, err := http.Get(src.String())
+	req, err := http.NewRequest("GET", src.String(), nil)
 	if err != nil {
 		return err
 	}
 
-	d.progress = 0
-	d.total = uint(resp.ContentLength)
+	_, err = d.get(dst, req, 0)
+	return err
+}
+
+// Resume continues the download with a range request, which servers that
+// don't support ranges answer with the whole file instead.
+func (d *HTTPDownloader) Resume(dst io.Writer, src *url.URL, offset uint) (bool, error) {
+	req, err := http.NewRequest("GET", src.String(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	setRange(req, offset)
+	return d.get(dst, req, offset)
+}
+
+// get sends the request and copies the file in its response to the
+// destination. If the offset isn't zero, the request is for the rest of
+// the file from there, and if the server sends the whole file instead,
+// false is returned without writing anything.
+func (d *HTTPDownloader) get(dst io.Writer, req *http.Request, offset uint) (bool, error) {
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		return false, nil
+	}
+
+	if resp.StatusCode >= 400 {
+		return false, fmt.Errorf("Error downloading %s: %s", req.URL.Host, resp.Status)
+	}
+
+	d.progress = offset
+	// The length is negative if the server didn't say what it is
+	d.total = 0
+	if resp.ContentLength > 0 {
+		d.total = offset + uint(resp.ContentLength)
+	}
+
+	return true, d.copy(dst, resp)
+}
+
+// setRange makes the request for the file from the offset on, if it
+// isn't zero.
+func setRange(req *http.Request, offset uint) {
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+}
+
+// httpClient returns the client that downloads are made with, which
+// talks to the proxy of the environment if the downloader has none.
+func (d *HTTPDownloader) httpClient() *http.Client {
+	if d.client == nil {
+		return http.DefaultClient
+	}
+
+	return d.client
+}
 
+// copy copies the body of the response to the destination, keeping
+// track of the progress.
+func (d *HTTPDownloader) copy(dst io.Writer, resp *http.Response) error {
 	var buffer [4096]byte
 	for {
 		n, err := resp.Body.Read(buffer[:])
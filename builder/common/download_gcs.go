@@ -0,0 +1,214 @@
+package common
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The scope of the access tokens that objects are downloaded with.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// GCSDownloader is an implementation of Downloader that downloads
+// "gs://bucket/object" URLs from Google Cloud Storage. The requests are
+// authorized with the access token in the GOOGLE_OAUTH_ACCESS_TOKEN
+// environment variable, or with one that is requested for the service
+// account whose key file is in GOOGLE_APPLICATION_CREDENTIALS. Without
+// credentials, only public objects can be downloaded.
+type GCSDownloader struct {
+	HTTPDownloader
+
+	// The URL that the buckets are under, instead of the endpoint of
+	// Google Cloud Storage, in tests.
+	endpoint string
+
+	// The access token of the service account, until it expires.
+	token   string
+	expires time.Time
+}
+
+// gcsServiceAccount is the key file of a service account.
+type gcsServiceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func (d *GCSDownloader) Download(dst io.Writer, src *url.URL) error {
+	req, err := d.request(src, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.get(dst, req, 0)
+	return err
+}
+
+func (d *GCSDownloader) Resume(dst io.Writer, src *url.URL, offset uint) (bool, error) {
+	req, err := d.request(src, offset)
+	if err != nil {
+		return false, err
+	}
+
+	return d.get(dst, req, offset)
+}
+
+// request returns the authorized request for the object at the URL, from
+// the offset on.
+func (d *GCSDownloader) request(src *url.URL, offset uint) (*http.Request, error) {
+	bucket := src.Host
+	object := strings.TrimPrefix(src.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("GCS URL must be gs://bucket/object: %s", src.String())
+	}
+
+	endpoint := d.endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+
+	// The object is escaped like the keys of S3, since both keep the
+	// slashes of the names of objects.
+	req, err := http.NewRequest("GET", endpoint+"/"+bucket+"/"+s3Escape(object), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	setRange(req, offset)
+	token, err := d.accessToken()
+	if err != nil {
+		return nil, fmt.Errorf("Error authorizing with Google Cloud Storage: %s", err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+// accessToken returns the access token that requests are authorized
+// with, or an empty string if there are no credentials.
+func (d *GCSDownloader) accessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		return "", nil
+	}
+
+	if d.token != "" && time.Now().Before(d.expires) {
+		return d.token, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var account gcsServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return "", fmt.Errorf("Error reading %s: %s", path, err)
+	}
+
+	if account.Type != "service_account" {
+		return "", fmt.Errorf("%s isn't the key file of a service account", path)
+	}
+
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	assertion, err := gcsAssertion(&account, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.httpClient().PostForm(account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error requesting access token: %s", resp.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("Error reading access token: %s", err)
+	}
+
+	// The token is requested again a minute before it expires, so that
+	// it doesn't expire while a request is sent.
+	d.token = result.AccessToken
+	d.expires = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return d.token, nil
+}
+
+// gcsAssertion returns the JWT, signed with the key of the service
+// account, that is exchanged for an access token.
+func gcsAssertion(account *gcsServiceAccount, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", errors.New("the private key of the service account isn't PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("Error parsing the private key of the service account: %s", err)
+		}
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the private key of the service account isn't an RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	encoding := base64.URLEncoding
+	unsigned := strings.TrimRight(encoding.EncodeToString(header), "=") + "." +
+		strings.TrimRight(encoding.EncodeToString(claims), "=")
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + strings.TrimRight(encoding.EncodeToString(signature), "="), nil
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGCSDownloader_Download(t *testing.T) {
+	defer os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"))
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tokens := 0
+	var requested *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokens++
+			assertion := strings.Split(r.FormValue("assertion"), ".")
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(assertion) != 3 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.Write([]byte(`{"access_token": "secret", "expires_in": 3600}`))
+			return
+		}
+
+		requested = r
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader("foobar"))
+	}))
+	defer server.Close()
+
+	account, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "packer@example.iam.gserviceaccount.com",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		})),
+		"token_uri": server.URL + "/token",
+	})
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write(account)
+	tf.Close()
+
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tf.Name())
+
+	src, _ := url.Parse("gs://isos/ubuntu/foo.iso")
+	d := &GCSDownloader{endpoint: server.URL}
+	buf := new(bytes.Buffer)
+	if err := d.Download(buf, src); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if buf.String() != "foobar" || requested.URL.Path != "/isos/ubuntu/foo.iso" {
+		t.Fatalf("bad: %q %s", buf.String(), requested.URL)
+	}
+
+	// The token is used until it expires
+	buf.Reset()
+	resumed, err := d.Resume(buf, src, 3)
+	if err != nil || !resumed || buf.String() != "bar" {
+		t.Fatalf("bad: %t %s %q", resumed, err, buf.String())
+	}
+
+	if tokens != 1 {
+		t.Fatalf("bad: %d", tokens)
+	}
+
+	// A token in the environment comes first
+	os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "other")
+	if err := d.Download(buf, src); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestGCSDownloader_AccessTokenInvalid(t *testing.T) {
+	defer os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"))
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte(`{"type": "authorized_user"}`))
+	tf.Close()
+
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", tf.Name())
+	if _, err := new(GCSDownloader).accessToken(); err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Without credentials, public objects are downloaded anonymously
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	token, err := new(GCSDownloader).accessToken()
+	if err != nil || token != "" {
+		t.Fatalf("bad: %s %s", token, err)
+	}
+}
@@ -0,0 +1,238 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The hash of the empty body of the requests to S3.
+const s3EmptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Downloader is an implementation of Downloader that downloads
+// "s3://bucket/key" URLs from Amazon S3. The requests are signed with the
+// credentials in the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables, or AWS_ACCESS_KEY and AWS_SECRET_KEY, and
+// AWS_SESSION_TOKEN for temporary credentials. Without credentials, only
+// public objects can be downloaded.
+//
+// The region of the bucket is the "region" in the query of the URL, such
+// as "s3://bucket/key?region=eu-west-1", or the AWS_REGION or
+// AWS_DEFAULT_REGION environment variables, or "us-east-1".
+type S3Downloader struct {
+	HTTPDownloader
+
+	// The URL that the buckets are under, instead of the endpoint of
+	// their region, in tests.
+	endpoint string
+}
+
+// s3Credentials are the AWS credentials that requests are signed with.
+type s3Credentials struct {
+	AccessKey string
+	SecretKey string
+	Token     string
+}
+
+func (d *S3Downloader) Download(dst io.Writer, src *url.URL) error {
+	req, err := d.request(src, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.get(dst, req, 0)
+	return err
+}
+
+func (d *S3Downloader) Resume(dst io.Writer, src *url.URL, offset uint) (bool, error) {
+	req, err := d.request(src, offset)
+	if err != nil {
+		return false, err
+	}
+
+	return d.get(dst, req, offset)
+}
+
+// request returns the signed request for the object at the URL, from the
+// offset on.
+func (d *S3Downloader) request(src *url.URL, offset uint) (*http.Request, error) {
+	bucket := src.Host
+	key := strings.TrimPrefix(src.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("S3 URL must be s3://bucket/key: %s", src.String())
+	}
+
+	region := src.Query().Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Buckets with dots in their names don't match the certificate of
+	// their virtual host, so their objects are under the path of the
+	// bucket instead.
+	var object *url.URL
+	switch {
+	case d.endpoint != "":
+		object, _ = url.Parse(d.endpoint)
+		object.Path = "/" + bucket + "/" + key
+		object.RawPath = "/" + bucket + "/" + s3Escape(key)
+	case strings.Contains(bucket, "."):
+		object = &url.URL{
+			Scheme:  "https",
+			Host:    fmt.Sprintf("s3.%s.amazonaws.com", region),
+			Path:    "/" + bucket + "/" + key,
+			RawPath: "/" + bucket + "/" + s3Escape(key),
+		}
+	default:
+		object = &url.URL{
+			Scheme:  "https",
+			Host:    fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, region),
+			Path:    "/" + key,
+			RawPath: "/" + s3Escape(key),
+		}
+	}
+
+	req, err := http.NewRequest("GET", object.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	setRange(req, offset)
+	if creds := s3EnvCredentials(); creds != nil {
+		signS3(req, creds, region, time.Now())
+	}
+
+	return req, nil
+}
+
+// s3EnvCredentials returns the AWS credentials in the environment, or nil
+// if there are none.
+func s3EnvCredentials() *s3Credentials {
+	getenv := func(names ...string) string {
+		for _, name := range names {
+			if value := os.Getenv(name); value != "" {
+				return value
+			}
+		}
+
+		return ""
+	}
+
+	creds := &s3Credentials{
+		AccessKey: getenv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY"),
+		SecretKey: getenv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY"),
+		Token:     getenv("AWS_SESSION_TOKEN", "AWS_SECURITY_TOKEN"),
+	}
+
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return nil
+	}
+
+	return creds
+}
+
+// signS3 signs the request to S3 with version 4 of the AWS signature,
+// which signs the host, the range, and the headers of AWS.
+func signS3(req *http.Request, creds *s3Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], region)
+
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyPayloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	// The requests for objects have no query
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		s3EmptyPayloadHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + creds.SecretKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = s3HMAC(key, part)
+	}
+
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes every byte of the key except the unreserved characters
+// and slashes, which is how AWS escapes the paths it signs.
+func s3Escape(s string) string {
+	var result []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			result = append(result, c)
+		case c == '-' || c == '_' || c == '.' || c == '~' || c == '/':
+			result = append(result, c)
+		default:
+			result = append(result, fmt.Sprintf("%%%02X", c)...)
+		}
+	}
+
+	return string(result)
+}
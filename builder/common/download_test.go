@@ -3,9 +3,14 @@ package common
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDownloadClient_VerifyChecksum(t *testing.T) {
@@ -40,3 +45,184 @@ func TestDownloadClient_VerifyChecksum(t *testing.T) {
 		t.Fatal("didn't verify")
 	}
 }
+
+func TestDownloadClient_Progress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "3")
+		w.Write([]byte("foo"))
+	}))
+	defer server.Close()
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("tempfile error: %s", err)
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	var current, total int64
+	config := &DownloadConfig{
+		Url:        server.URL,
+		TargetPath: tf.Name(),
+		Progress: func(c int64, t int64) {
+			current = c
+			total = t
+		},
+	}
+
+	if _, err := NewDownloadClient(config).Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if current != 3 || total != 3 {
+		t.Fatalf("bad: %d of %d", current, total)
+	}
+
+	data, err := ioutil.ReadFile(tf.Name())
+	if err != nil || string(data) != "foo" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+}
+
+func TestDownloadClient_Resume(t *testing.T) {
+	var ranges bool
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.Header.Get("Range")
+		if !ranges {
+			r.Header.Del("Range")
+		}
+
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader("foobar"))
+	}))
+	defer server.Close()
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("tempfile error: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	// A partial download from an earlier build is continued
+	tf.Write([]byte("foo"))
+	tf.Close()
+
+	var current, total int64
+	config := &DownloadConfig{
+		Url:        server.URL,
+		TargetPath: tf.Name(),
+		Progress: func(c int64, t int64) {
+			current = c
+			total = t
+		},
+		Resume: true,
+	}
+
+	ranges = true
+	if _, err := NewDownloadClient(config).Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if requested != "bytes=3-" {
+		t.Fatalf("bad range: %s", requested)
+	}
+
+	if current != 6 || total != 6 {
+		t.Fatalf("bad: %d of %d", current, total)
+	}
+
+	data, err := ioutil.ReadFile(tf.Name())
+	if err != nil || string(data) != "foobar" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+
+	// Servers that can't resume the download send the whole file
+	if err := ioutil.WriteFile(tf.Name(), []byte("bar"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ranges = false
+	if _, err := NewDownloadClient(config).Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err = ioutil.ReadFile(tf.Name())
+	if err != nil || string(data) != "foobar" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+}
+
+func TestDownloadClient_ResumeChecksum(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, strings.NewReader("foobar"))
+	}))
+	defer server.Close()
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("tempfile error: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	// The partial download isn't the start of the file
+	tf.Write([]byte("xyz"))
+	tf.Close()
+
+	// "foobar"
+	checksum, err := hex.DecodeString("3858f62230ac3c915f300c664312c63f")
+	if err != nil {
+		t.Fatalf("decode err: %s", err)
+	}
+
+	config := &DownloadConfig{
+		Url:        server.URL,
+		TargetPath: tf.Name(),
+		Hash:       md5.New(),
+		Checksum:   checksum,
+		Resume:     true,
+	}
+
+	if _, err := NewDownloadClient(config).Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(tf.Name())
+	if err != nil || string(data) != "foobar" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+}
+
+func TestDownloadClient_Proxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+		w.Write([]byte("foobar"))
+	}))
+	defer proxy.Close()
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("tempfile error: %s", err)
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	config := &DownloadConfig{
+		Url:        "http://releases.example.com/foo.iso",
+		TargetPath: tf.Name(),
+		Proxy:      &packer.ProxyConfig{HTTPProxy: proxy.URL},
+	}
+
+	if _, err := NewDownloadClient(config).Get(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if requested != "http://releases.example.com/foo.iso" {
+		t.Fatalf("bad: %s", requested)
+	}
+
+	data, err := ioutil.ReadFile(tf.Name())
+	if err != nil || string(data) != "foobar" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+}
@@ -23,27 +23,33 @@ func MultistepDebugFn(ui packer.Ui) multistep.DebugPauseFn {
 		}
 
 		message := fmt.Sprintf(
-			"Pausing %s step '%s'. Press any key to continue.",
+			"Pausing %s step '%s'. Press enter to continue.",
 			locationString, name)
 
-		result := make(chan string, 1)
-		go func() {
-			line, err := ui.Ask(message)
-			if err != nil {
-				log.Printf("Error asking for input: %s", err)
-			}
+		askCancellable(ui, message, state)
+	}
+}
+
+// askCancellable asks the question, and returns the answer once it is
+// given, or false if the build is cancelled first.
+func askCancellable(ui packer.Ui, message string, state map[string]interface{}) (string, bool) {
+	result := make(chan string, 1)
+	go func() {
+		line, err := ui.Ask(message)
+		if err != nil {
+			log.Printf("[ERROR] Error asking for input: %s", err)
+		}
 
-			result <- line
-		}()
+		result <- line
+	}()
 
-		for {
-			select {
-			case <-result:
-				return
-			case <-time.After(100 * time.Millisecond):
-				if _, ok := state[multistep.StateCancelled]; ok {
-					return
-				}
+	for {
+		select {
+		case line := <-result:
+			return line, true
+		case <-time.After(100 * time.Millisecond):
+			if NewStateBag(state).Cancelled() {
+				return "", false
 			}
 		}
 	}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The number of times a failed step is run before giving up when the
+// build retries failed steps.
+const onErrorRetryAttempts = 3
+
+// This is the key in the state that is set when a failed step aborts the
+// build, so that the steps aren't cleaned up.
+const stateAborted = "packer_aborted"
+
+// NewRunner returns the runner that a builder runs its steps with. In
+// debug mode it pauses after each step is run and before it is cleaned
+// up. When a step fails, the runner does what onError says, which is one
+// of packer.OnErrorValues, or packer.OnErrorCleanup if it is empty. How
+// long each step runs is reported with packer.ReportTiming.
+func NewRunner(steps []multistep.Step, debug bool, onError string, ui packer.Ui) multistep.Runner {
+	var pause multistep.DebugPauseFn
+	if debug {
+		pause = MultistepDebugFn(ui)
+	}
+
+	if onError == "" {
+		onError = packer.OnErrorCleanup
+	}
+
+	// The steps pause themselves, since the runner would only know the
+	// name of the step they are wrapped in.
+	wrapped := make([]multistep.Step, len(steps))
+	for i, step := range steps {
+		wrapped[i] = &onErrorStep{
+			Step:    step,
+			name:    stepName(step),
+			onError: onError,
+			pause:   pause,
+			ui:      ui,
+		}
+	}
+
+	return &multistep.BasicRunner{Steps: wrapped}
+}
+
+// WrappedStep is a step that wraps another one, such as to do something
+// before it runs and cleans up. The runner names it after the step it
+// wraps, which is what is shown in debug mode and in the timings.
+type WrappedStep interface {
+	multistep.Step
+
+	// Unwrap returns the step that is wrapped.
+	Unwrap() multistep.Step
+}
+
+// stepName returns the name of the type of the step, or of the step it
+// wraps.
+func stepName(step multistep.Step) string {
+	for {
+		wrapped, ok := step.(WrappedStep)
+		if !ok {
+			break
+		}
+
+		step = wrapped.Unwrap()
+	}
+
+	return reflect.Indirect(reflect.ValueOf(step)).Type().Name()
+}
+
+// onErrorStep is a step that does what the build says to do on errors
+// when the step it wraps fails, and reports how long the step ran.
+type onErrorStep struct {
+	multistep.Step
+
+	name    string
+	onError string
+	pause   multistep.DebugPauseFn
+	ui      packer.Ui
+}
+
+func (s *onErrorStep) Run(state map[string]interface{}) multistep.StepAction {
+	// Only the time the step runs counts, not the pauses of debug mode or
+	// the questions of what to do about its errors.
+	var elapsed time.Duration
+	defer func() {
+		packer.ReportTiming(s.ui, packer.Timing{
+			Kind:     packer.TimingStep,
+			Name:     s.name,
+			Duration: elapsed,
+		})
+	}()
+
+	bag := NewStateBag(state)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		action := s.Step.Run(state)
+		elapsed += time.Since(start)
+		if s.pause != nil {
+			s.pause(multistep.DebugLocationAfterRun, s.name, state)
+		}
+
+		if action != multistep.ActionHalt {
+			return action
+		}
+
+		// Steps also halt when the build is cancelled, which isn't an
+		// error to do anything about.
+		err := bag.Error()
+		if err == nil {
+			return action
+		}
+
+		if bag.Cancelled() {
+			return action
+		}
+
+		onError := s.onError
+		if onError == packer.OnErrorAsk {
+			onError = s.ask(err, state)
+		}
+
+		switch onError {
+		case packer.OnErrorAbort:
+			s.ui.Error("Aborting without cleaning up, so the build can be inspected.")
+			state[stateAborted] = true
+			return action
+		case packer.OnErrorRetry:
+			if s.onError == packer.OnErrorRetry && attempt >= onErrorRetryAttempts {
+				s.ui.Error(fmt.Sprintf(
+					"Step '%s' failed %d times, giving up.", s.name, attempt))
+				return action
+			}
+
+			s.ui.Say(fmt.Sprintf("Retrying step '%s'...", s.name))
+			delete(state, StateError)
+		default:
+			return action
+		}
+	}
+}
+
+func (s *onErrorStep) Cleanup(state map[string]interface{}) {
+	if _, ok := state[stateAborted]; ok {
+		log.Printf("[INFO] Not cleaning up step, the build was aborted: %s", s.name)
+		return
+	}
+
+	if s.pause != nil {
+		s.pause(multistep.DebugLocationBeforeCleanup, s.name, state)
+	}
+
+	s.Step.Cleanup(state)
+}
+
+// ask asks the user what to do about the error of the step, returning
+// one of packer.OnErrorValues. If the user can't be asked, the build is
+// cleaned up.
+func (s *onErrorStep) ask(err error, state map[string]interface{}) string {
+	message := fmt.Sprintf(
+		"Step '%s' failed: %s\n"+
+			"[c] Clean up and exit, [a] abort without cleaning up, [r] retry the step: ",
+		s.name, err)
+
+	for {
+		line, ok := askCancellable(s.ui, message, state)
+		if !ok {
+			return packer.OnErrorCleanup
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "", "c":
+			return packer.OnErrorCleanup
+		case "a":
+			return packer.OnErrorAbort
+		case "r":
+			return packer.OnErrorRetry
+		}
+
+		s.ui.Error("Please answer c, a or r.")
+	}
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"strings"
+	"testing"
+)
+
+// testFailingStep fails the first given number of times it is run.
+type testFailingStep struct {
+	failures  int
+	runs      int
+	cleanedUp bool
+}
+
+func (s *testFailingStep) Run(state map[string]interface{}) multistep.StepAction {
+	s.runs++
+	if s.runs <= s.failures {
+		state["error"] = errors.New("failed")
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *testFailingStep) Cleanup(map[string]interface{}) {
+	s.cleanedUp = true
+}
+
+func testRunnerUi(input string) packer.Ui {
+	return &packer.ReaderWriterUi{
+		Reader: bytes.NewBufferString(input),
+		Writer: new(bytes.Buffer),
+	}
+}
+
+func TestNewRunner_Cleanup(t *testing.T) {
+	first := new(testFailingStep)
+	step := &testFailingStep{failures: 1}
+	runner := NewRunner([]multistep.Step{first, step}, false, "", testRunnerUi(""))
+
+	state := make(map[string]interface{})
+	runner.Run(state)
+
+	if _, ok := state["error"]; !ok {
+		t.Fatal("should have error")
+	}
+
+	if !first.cleanedUp || !step.cleanedUp {
+		t.Fatal("steps should be cleaned up")
+	}
+}
+
+func TestNewRunner_Abort(t *testing.T) {
+	first := new(testFailingStep)
+	step := &testFailingStep{failures: 1}
+	runner := NewRunner([]multistep.Step{first, step}, false, packer.OnErrorAbort, testRunnerUi(""))
+
+	state := make(map[string]interface{})
+	runner.Run(state)
+
+	if _, ok := state["error"]; !ok {
+		t.Fatal("should have error")
+	}
+
+	if first.cleanedUp || step.cleanedUp {
+		t.Fatal("steps should not be cleaned up")
+	}
+}
+
+func TestNewRunner_Retry(t *testing.T) {
+	step := &testFailingStep{failures: 2}
+	runner := NewRunner([]multistep.Step{step}, false, packer.OnErrorRetry, testRunnerUi(""))
+
+	state := make(map[string]interface{})
+	runner.Run(state)
+
+	if _, ok := state["error"]; ok {
+		t.Fatalf("should not have error: %s", state["error"])
+	}
+
+	if step.runs != 3 {
+		t.Fatalf("bad runs: %d", step.runs)
+	}
+
+	// It gives up after a few attempts
+	step = &testFailingStep{failures: 5}
+	runner = NewRunner([]multistep.Step{step}, false, packer.OnErrorRetry, testRunnerUi(""))
+
+	state = make(map[string]interface{})
+	runner.Run(state)
+
+	if _, ok := state["error"]; !ok {
+		t.Fatal("should have error")
+	}
+
+	if step.runs != onErrorRetryAttempts || !step.cleanedUp {
+		t.Fatalf("bad: %#v", step)
+	}
+}
+
+func TestNewRunner_Ask(t *testing.T) {
+	step := &testFailingStep{failures: 2}
+	ui := testRunnerUi("r\nx\nr\n")
+	runner := NewRunner([]multistep.Step{step}, false, packer.OnErrorAsk, ui)
+
+	state := make(map[string]interface{})
+	runner.Run(state)
+
+	if _, ok := state["error"]; ok {
+		t.Fatalf("should not have error: %s", state["error"])
+	}
+
+	if step.runs != 3 {
+		t.Fatalf("bad runs: %d", step.runs)
+	}
+
+	step = &testFailingStep{failures: 1}
+	runner = NewRunner([]multistep.Step{step}, false, packer.OnErrorAsk, testRunnerUi("a\n"))
+
+	state = make(map[string]interface{})
+	runner.Run(state)
+
+	if _, ok := state[stateAborted]; !ok || step.cleanedUp {
+		t.Fatal("should be aborted")
+	}
+}
+
+func TestNewRunner_Timing(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &packer.MachineReadableUi{Writer: out}
+	step := &testFailingStep{failures: 1}
+	runner := NewRunner([]multistep.Step{step}, false, packer.OnErrorRetry, ui)
+
+	state := make(map[string]interface{})
+	runner.Run(state)
+
+	// The retries of a step are timed together
+	if count := strings.Count(out.String(), ",timing,step,testFailingStep,"); count != 1 {
+		t.Fatalf("bad: %d %s", count, out.String())
+	}
+}
+
+type testWrappedStep struct {
+	multistep.Step
+}
+
+func (s *testWrappedStep) Unwrap() multistep.Step {
+	return s.Step
+}
+
+func TestNewRunner_WrappedStep(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &packer.MachineReadableUi{Writer: out}
+	step := &testWrappedStep{&testWrappedStep{&testFailingStep{}}}
+	runner := NewRunner([]multistep.Step{step}, false, "", ui)
+
+	state := make(map[string]interface{})
+	runner.Run(state)
+
+	// The step is named after the step it wraps
+	if !strings.Contains(out.String(), ",timing,step,testFailingStep,") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"log"
+	"time"
+)
+
+// The defaults of Retry, which suit the APIs of cloud providers, whose
+// rate limits usually reset within seconds.
+const (
+	defaultRetryAttempts       = 5
+	defaultRetryInitialBackoff = 1 * time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// Retry calls a function until it succeeds, waiting longer after each
+// failure, so that steps get through errors that go away by themselves,
+// such as the API of a cloud provider throttling requests, or not knowing
+// yet about something that was just created.
+//
+// The zero value tries 5 times, waiting a second after the first failure
+// and doubling the wait each time, and retries every error.
+type Retry struct {
+	// How many times the function is called in all before giving up.
+	Attempts int
+
+	// How long to wait after the first failure. The wait doubles after
+	// each failure, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// ShouldRetry says whether an error is worth trying again. Any other
+	// error is returned right away. If it is nil, every error is retried.
+	ShouldRetry func(error) bool
+}
+
+// Run calls the function until it succeeds, it returns an error that
+// isn't retried, or the attempts run out, and returns its last error.
+func (r *Retry) Run(f func() error) error {
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil {
+			return nil
+		}
+
+		if r.ShouldRetry != nil && !r.ShouldRetry(err) {
+			return err
+		}
+
+		if attempt >= attempts {
+			log.Printf("[WARN] Giving up after %d attempts: %s", attempt, err)
+			return err
+		}
+
+		backoff := r.backoff(attempt)
+		log.Printf("[INFO] Retrying in %s (attempt %d of %d): %s",
+			backoff, attempt, attempts, err)
+		time.Sleep(backoff)
+	}
+}
+
+// backoff returns how long to wait after the given failed attempt.
+func (r *Retry) backoff(attempt int) time.Duration {
+	backoff := r.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryInitialBackoff
+	}
+
+	max := r.MaxBackoff
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+
+	for i := 1; i < attempt && backoff < max; i++ {
+		backoff *= 2
+	}
+
+	if backoff > max {
+		backoff = max
+	}
+
+	return backoff
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	r := &Retry{Attempts: 3, InitialBackoff: time.Millisecond}
+
+	calls := 0
+	err := r.Run(func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("throttled")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("bad: %d", calls)
+	}
+}
+
+func TestRetry_GiveUp(t *testing.T) {
+	r := &Retry{Attempts: 2, InitialBackoff: time.Millisecond}
+
+	calls := 0
+	err := r.Run(func() error {
+		calls++
+		return errors.New("throttled")
+	})
+
+	if err == nil || err.Error() != "throttled" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("bad: %d", calls)
+	}
+}
+
+func TestRetry_ShouldRetry(t *testing.T) {
+	r := &Retry{
+		InitialBackoff: time.Millisecond,
+		ShouldRetry: func(err error) bool {
+			return err.Error() == "throttled"
+		},
+	}
+
+	calls := 0
+	err := r.Run(func() error {
+		calls++
+		if calls == 1 {
+			return errors.New("throttled")
+		}
+
+		return errors.New("bad request")
+	})
+
+	if err == nil || err.Error() != "bad request" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("bad: %d", calls)
+	}
+}
+
+func TestRetry_backoff(t *testing.T) {
+	r := &Retry{InitialBackoff: 2 * time.Second, MaxBackoff: 10 * time.Second}
+
+	expected := []time.Duration{
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+
+	for i, e := range expected {
+		if actual := r.backoff(i + 1); actual != e {
+			t.Fatalf("attempt %d: %s", i+1, actual)
+		}
+	}
+
+	// The zero value has defaults
+	r = new(Retry)
+	if r.backoff(1) != defaultRetryInitialBackoff {
+		t.Fatalf("bad: %s", r.backoff(1))
+	}
+
+	if r.backoff(100) != defaultRetryMaxBackoff {
+		t.Fatalf("bad: %s", r.backoff(100))
+	}
+}
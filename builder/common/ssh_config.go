@@ -0,0 +1,450 @@
+package common
+
+import (
+	gossh "code.google.com/p/go.crypto/ssh"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/retry"
+	"github.com/mitchellh/packer/communicator/ssh"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+)
+
+// SSHConfig contains the configuration of the SSH communicator that is
+// shared by all builders that connect to a machine over SSH. It is meant
+// to be embedded into a builder's configuration with ",squash".
+type SSHConfig struct {
+	// The method used to transfer files, either "scp" or "sftp".
+	SSHFileTransferMethod string `mapstructure:"ssh_file_transfer_method"`
+
+	// The password to authenticate with, using both the password and
+	// keyboard-interactive methods.
+	SSHPassword string `mapstructure:"ssh_password"`
+
+	// If true, the keys in the running ssh-agent are used to authenticate,
+	// in addition to any other methods the builder uses.
+	SSHAgentAuth bool `mapstructure:"ssh_agent_auth"`
+
+	// A SOCKS5 or HTTP CONNECT proxy that connections are made through.
+	SSHProxyType     string `mapstructure:"ssh_proxy_type"`
+	SSHProxyHost     string `mapstructure:"ssh_proxy_host"`
+	SSHProxyPort     int    `mapstructure:"ssh_proxy_port"`
+	SSHProxyUsername string `mapstructure:"ssh_proxy_username"`
+	SSHProxyPassword string `mapstructure:"ssh_proxy_password"`
+
+	// The PTY requested for commands run on the machine, if any.
+	SSHDisablePTY bool   `mapstructure:"ssh_disable_pty"`
+	SSHPTYTerm    string `mapstructure:"ssh_pty_term"`
+	SSHPTYWidth   int    `mapstructure:"ssh_pty_width"`
+	SSHPTYHeight  int    `mapstructure:"ssh_pty_height"`
+
+	// The maximum rate in bytes per second for file transfers, so that
+	// large uploads don't saturate the network. Zero means no limit.
+	SSHTransferRateLimit int `mapstructure:"ssh_transfer_rate_limit"`
+
+	// If true, files are gzip compressed while they are transferred, which
+	// speeds up large uploads over slow links.
+	SSHCompression bool `mapstructure:"ssh_compression"`
+
+	// The maximum number of SSH sessions open at once for commands and
+	// file transfers. Zero means no limit.
+	SSHMaxSessions int `mapstructure:"ssh_max_sessions"`
+
+	// How the host key of the machine is verified. The fingerprint pins
+	// a single expected key, and the known_hosts file is checked like
+	// OpenSSH does it. A key that neither verifies is refused, unless
+	// checking is disabled, which accepts it since the machine is usually
+	// brand new and its key can't be known ahead of time.
+	SSHHostKeyFingerprint     string `mapstructure:"ssh_host_key_fingerprint"`
+	SSHKnownHostsFile         string `mapstructure:"ssh_known_hosts_file"`
+	SSHDisableHostKeyChecking bool   `mapstructure:"ssh_disable_host_key_checking"`
+
+	// A bastion host to tunnel the SSH connection through, for machines
+	// that aren't directly reachable.
+	SSHBastionHost           string `mapstructure:"ssh_bastion_host"`
+	SSHBastionPort           int    `mapstructure:"ssh_bastion_port"`
+	SSHBastionUsername       string `mapstructure:"ssh_bastion_username"`
+	SSHBastionPassword       string `mapstructure:"ssh_bastion_password"`
+	SSHBastionPrivateKeyFile string `mapstructure:"ssh_bastion_private_key_file"`
+
+	// The passphrase of the bastion private key, if it is encrypted.
+	SSHBastionPrivateKeyPassphrase string `mapstructure:"ssh_bastion_private_key_passphrase"`
+
+	// How many times a command is run again if the connection is lost
+	// before it exits.
+	SSHCommandRetries int `mapstructure:"ssh_command_retries"`
+
+	// How many times starting a command, transferring a file or
+	// forwarding a port is attempted before giving up.
+	SSHRetryAttempts int `mapstructure:"ssh_retry_attempts"`
+
+	SSHKeepAliveInterval time.Duration
+	SSHReconnectTimeout  time.Duration
+
+	RawSSHKeepAliveInterval string `mapstructure:"ssh_keep_alive_interval"`
+	RawSSHReconnectTimeout  string `mapstructure:"ssh_reconnect_timeout"`
+}
+
+// Prepare processes the templates in, sets defaults for and validates the
+// SSH configuration, returning any errors that were found.
+func (c *SSHConfig) Prepare(t *packer.ConfigTemplate) []error {
+	if c.SSHFileTransferMethod == "" {
+		c.SSHFileTransferMethod = "scp"
+	}
+
+	if c.SSHBastionHost != "" && c.SSHBastionPort == 0 {
+		c.SSHBastionPort = 22
+	}
+
+	if c.SSHProxyHost != "" {
+		if c.SSHProxyType == "" {
+			c.SSHProxyType = "socks5"
+		}
+
+		if c.SSHProxyPort == 0 {
+			switch c.SSHProxyType {
+			case "socks5":
+				c.SSHProxyPort = 1080
+			case "http":
+				c.SSHProxyPort = 8080
+			}
+		}
+	}
+
+	if c.SSHPTYTerm == "" {
+		c.SSHPTYTerm = "xterm"
+	}
+
+	if c.SSHPTYWidth == 0 {
+		c.SSHPTYWidth = 80
+	}
+
+	if c.SSHPTYHeight == 0 {
+		c.SSHPTYHeight = 40
+	}
+
+	if c.SSHRetryAttempts == 0 {
+		c.SSHRetryAttempts = 3
+	}
+
+	if c.RawSSHKeepAliveInterval == "" {
+		c.RawSSHKeepAliveInterval = "30s"
+	}
+
+	if c.RawSSHReconnectTimeout == "" {
+		c.RawSSHReconnectTimeout = "5m"
+	}
+
+	errs := make([]error, 0)
+
+	templates := map[string]*string{
+		"ssh_file_transfer_method":           &c.SSHFileTransferMethod,
+		"ssh_password":                       &c.SSHPassword,
+		"ssh_proxy_type":                     &c.SSHProxyType,
+		"ssh_proxy_host":                     &c.SSHProxyHost,
+		"ssh_proxy_username":                 &c.SSHProxyUsername,
+		"ssh_proxy_password":                 &c.SSHProxyPassword,
+		"ssh_pty_term":                       &c.SSHPTYTerm,
+		"ssh_host_key_fingerprint":           &c.SSHHostKeyFingerprint,
+		"ssh_known_hosts_file":               &c.SSHKnownHostsFile,
+		"ssh_bastion_host":                   &c.SSHBastionHost,
+		"ssh_bastion_username":               &c.SSHBastionUsername,
+		"ssh_bastion_password":               &c.SSHBastionPassword,
+		"ssh_bastion_private_key_file":       &c.SSHBastionPrivateKeyFile,
+		"ssh_bastion_private_key_passphrase": &c.SSHBastionPrivateKeyPassphrase,
+		"ssh_keep_alive_interval":            &c.RawSSHKeepAliveInterval,
+		"ssh_reconnect_timeout":              &c.RawSSHReconnectTimeout,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = t.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	if c.SSHFileTransferMethod != "scp" && c.SSHFileTransferMethod != "sftp" {
+		errs = append(errs, fmt.Errorf(
+			"ssh_file_transfer_method must be 'scp' or 'sftp', got: %s",
+			c.SSHFileTransferMethod))
+	}
+
+	if c.SSHProxyHost != "" && c.SSHProxyType != "socks5" && c.SSHProxyType != "http" {
+		errs = append(errs, fmt.Errorf(
+			"ssh_proxy_type must be 'socks5' or 'http', got: %s", c.SSHProxyType))
+	}
+
+	if c.SSHPTYWidth < 0 || c.SSHPTYHeight < 0 {
+		errs = append(errs, errors.New("ssh_pty_width and ssh_pty_height must be positive"))
+	}
+
+	if c.SSHTransferRateLimit < 0 {
+		errs = append(errs, errors.New("ssh_transfer_rate_limit must be positive"))
+	}
+
+	if c.SSHMaxSessions < 0 {
+		errs = append(errs, errors.New("ssh_max_sessions must be positive"))
+	}
+
+	if c.SSHCommandRetries < 0 {
+		errs = append(errs, errors.New("ssh_command_retries must be positive"))
+	}
+
+	if c.SSHRetryAttempts < 0 {
+		errs = append(errs, errors.New("ssh_retry_attempts must be positive"))
+	}
+
+	if c.SSHHostKeyFingerprint != "" && c.SSHKnownHostsFile != "" {
+		errs = append(errs, errors.New(
+			"Only one of ssh_host_key_fingerprint or ssh_known_hosts_file can be specified"))
+	}
+
+	// A fingerprint only verifies the machine, so checking can still be
+	// disabled for the key of a bastion host.
+	if c.SSHDisableHostKeyChecking &&
+		(c.SSHKnownHostsFile != "" || (c.SSHHostKeyFingerprint != "" && c.SSHBastionHost == "")) {
+		errs = append(errs, errors.New(
+			"ssh_disable_host_key_checking can't be used with ssh_host_key_fingerprint or ssh_known_hosts_file"))
+	}
+
+	if c.SSHKnownHostsFile != "" {
+		if _, err := os.Stat(c.SSHKnownHostsFile); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_known_hosts_file is invalid: %s", err))
+		}
+	}
+
+	if c.SSHAgentAuth && os.Getenv("SSH_AUTH_SOCK") == "" {
+		errs = append(errs, errors.New("ssh_agent_auth requires a running ssh-agent, but SSH_AUTH_SOCK is not set"))
+	}
+
+	if c.SSHBastionHost != "" {
+		if c.SSHBastionUsername == "" {
+			errs = append(errs, errors.New("ssh_bastion_username must be specified with ssh_bastion_host"))
+		}
+
+		if c.SSHBastionPassword == "" && c.SSHBastionPrivateKeyFile == "" && !c.SSHAgentAuth {
+			errs = append(errs, errors.New(
+				"ssh_bastion_password, ssh_bastion_private_key_file, or ssh_agent_auth must be specified with ssh_bastion_host"))
+		}
+
+		if c.SSHBastionPrivateKeyFile != "" {
+			if _, err := c.bastionKeychain(); err != nil {
+				errs = append(errs, fmt.Errorf("Error loading ssh_bastion_private_key_file: %s", err))
+			}
+		}
+	}
+
+	var err error
+	c.SSHKeepAliveInterval, err = time.ParseDuration(c.RawSSHKeepAliveInterval)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Failed parsing ssh_keep_alive_interval: %s", err))
+	}
+
+	c.SSHReconnectTimeout, err = time.ParseDuration(c.RawSSHReconnectTimeout)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Failed parsing ssh_reconnect_timeout: %s", err))
+	}
+
+	return errs
+}
+
+// CommConfig returns the configuration for the SSH communicator using
+// the given client configuration for the connection itself, whose host key
+// checker is set from this configuration. The address is the host:port of
+// the SSH server, used to verify its host key and to reconnect if the
+// connection is lost.
+func (c *SSHConfig) CommConfig(address string, sshConfig *gossh.ClientConfig) *ssh.Config {
+	if sshConfig != nil {
+		sshConfig.HostKeyChecker = c.HostKeyChecker(address)
+	}
+
+	return &ssh.Config{
+		SSHConfig:         sshConfig,
+		UseSFTP:           c.SSHFileTransferMethod == "sftp",
+		Compression:       c.SSHCompression,
+		Connection:        c.Connection(address),
+		KeepAliveInterval: c.SSHKeepAliveInterval,
+		ReconnectTimeout:  c.SSHReconnectTimeout,
+		CommandRetries:    c.SSHCommandRetries,
+		DisablePTY:        c.SSHDisablePTY,
+		PTYTerm:           c.SSHPTYTerm,
+		PTYWidth:          c.SSHPTYWidth,
+		PTYHeight:         c.SSHPTYHeight,
+		TransferRateLimit: c.SSHTransferRateLimit,
+		MaxSessions:       c.SSHMaxSessions,
+	}
+}
+
+// Retry wraps the SSH communicator so that the operations which fail are
+// tried again, up to SSHRetryAttempts times in all.
+func (c *SSHConfig) Retry(comm packer.Communicator) packer.Communicator {
+	return retry.New(comm, &retry.Config{Attempts: c.SSHRetryAttempts})
+}
+
+// HostKeyChecker returns the checker used to verify the host key of the
+// SSH server at the given address.
+func (c *SSHConfig) HostKeyChecker(address string) gossh.HostKeyChecker {
+	if c.SSHHostKeyFingerprint != "" {
+		return ssh.FingerprintHostKeyChecker(c.SSHHostKeyFingerprint)
+	}
+
+	if c.SSHKnownHostsFile != "" {
+		return ssh.KnownHostsHostKeyChecker(c.SSHKnownHostsFile, address)
+	}
+
+	return ssh.InsecureHostKeyChecker(c.SSHDisableHostKeyChecking)
+}
+
+// ClientAuth returns the methods of authenticating with SSH that come
+// from this configuration, such as a password or the SSH agent. Builders
+// should try these in addition to their own methods. If the SSH agent is
+// used, the connection to it is returned too, which is closed once the
+// methods are no longer used. Otherwise it is nil.
+func (c *SSHConfig) ClientAuth() ([]gossh.ClientAuth, io.Closer, error) {
+	return c.clientAuth(false)
+}
+
+// clientAuth is ClientAuth for a builder that may have a key of its own.
+// The password is always tried if nothing else is, even if it is empty,
+// since that is the default of ssh_password.
+func (c *SSHConfig) clientAuth(key bool) ([]gossh.ClientAuth, io.Closer, error) {
+	auth := make([]gossh.ClientAuth, 0, 3)
+
+	if c.SSHPassword != "" || (!key && !c.SSHAgentAuth) {
+		auth = append(auth,
+			gossh.ClientAuthPassword(ssh.Password(c.SSHPassword)),
+			gossh.ClientAuthKeyboardInteractive(
+				ssh.PasswordKeyboardInteractive(c.SSHPassword)))
+	}
+
+	var agent io.Closer
+	if c.SSHAgentAuth {
+		agentAuth, conn, err := ssh.AgentAuth()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		auth = append(auth, agentAuth)
+		agent = conn
+	}
+
+	return auth, agent, nil
+}
+
+// ClientConfig returns the configuration of an SSH client that logs in as
+// the user. If the private key, in PEM, isn't empty it is tried first,
+// before the methods of ClientAuth. The connection to the SSH agent, if
+// any, is returned as with ClientAuth.
+func (c *SSHConfig) ClientConfig(user string, privateKey string) (*gossh.ClientConfig, io.Closer, error) {
+	auth := make([]gossh.ClientAuth, 0, 4)
+	if privateKey != "" {
+		keyring := &ssh.SimpleKeychain{}
+		if err := keyring.AddPEMKey(privateKey); err != nil {
+			return nil, nil, err
+		}
+
+		auth = append(auth, gossh.ClientAuthKeyring(keyring))
+	}
+
+	configAuth, agent, err := c.clientAuth(privateKey != "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &gossh.ClientConfig{
+		User: user,
+		Auth: append(auth, configAuth...),
+	}, agent, nil
+}
+
+// Connection returns a function that connects to the SSH server at the
+// given address, tunneling through the bastion host if one is configured.
+func (c *SSHConfig) Connection(address string) func() (net.Conn, error) {
+	if c.SSHBastionHost == "" {
+		return c.dial(address)
+	}
+
+	auth := make([]gossh.ClientAuth, 0, 4)
+	if c.SSHBastionPrivateKeyFile != "" {
+		keyring, err := c.bastionKeychain()
+		if err != nil {
+			return func() (net.Conn, error) {
+				return nil, fmt.Errorf("Error loading bastion private key: %s", err)
+			}
+		}
+
+		auth = append(auth, gossh.ClientAuthKeyring(keyring))
+	}
+
+	if c.SSHBastionPassword != "" {
+		auth = append(auth,
+			gossh.ClientAuthPassword(ssh.Password(c.SSHBastionPassword)),
+			gossh.ClientAuthKeyboardInteractive(
+				ssh.PasswordKeyboardInteractive(c.SSHBastionPassword)))
+	}
+
+	bastionAddr := fmt.Sprintf("%s:%d", c.SSHBastionHost, c.SSHBastionPort)
+
+	// The bastion is checked against the known_hosts file as well, but
+	// a fingerprint only ever applies to the machine itself.
+	bastionChecker := ssh.InsecureHostKeyChecker(c.SSHDisableHostKeyChecking)
+	if c.SSHKnownHostsFile != "" {
+		bastionChecker = ssh.KnownHostsHostKeyChecker(c.SSHKnownHostsFile, bastionAddr)
+	}
+
+	bastionConfig := &gossh.ClientConfig{
+		User:           c.SSHBastionUsername,
+		Auth:           auth,
+		HostKeyChecker: bastionChecker,
+	}
+
+	dial := c.dial(bastionAddr)
+	if !c.SSHAgentAuth {
+		return ssh.BastionConnectFunc(dial, bastionConfig, "tcp", address)
+	}
+
+	// The agent is only needed to log in to the bastion, so each
+	// connection gets its own, which is closed once it has logged in.
+	return func() (net.Conn, error) {
+		agentAuth, agent, err := ssh.AgentAuth()
+		if err != nil {
+			return nil, fmt.Errorf("Error setting up bastion authentication: %s", err)
+		}
+		defer agent.Close()
+
+		config := *bastionConfig
+		config.Auth = append(append([]gossh.ClientAuth{}, auth...), agentAuth)
+		return ssh.BastionConnectFunc(dial, &config, "tcp", address)()
+	}
+}
+
+// dial returns a function that makes a TCP connection to the address,
+// going through the proxy if one is configured.
+func (c *SSHConfig) dial(address string) func() (net.Conn, error) {
+	if c.SSHProxyHost == "" {
+		return ssh.ConnectFunc("tcp", address)
+	}
+
+	proxyAddr := fmt.Sprintf("%s:%d", c.SSHProxyHost, c.SSHProxyPort)
+	return ssh.ProxyConnectFunc(
+		c.SSHProxyType, proxyAddr, c.SSHProxyUsername, c.SSHProxyPassword, address)
+}
+
+func (c *SSHConfig) bastionKeychain() (*ssh.SimpleKeychain, error) {
+	privateKey, err := ioutil.ReadFile(c.SSHBastionPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := new(ssh.SimpleKeychain)
+	if err := keyring.AddPEMKeyPassword(string(privateKey), c.SSHBastionPrivateKeyPassphrase); err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
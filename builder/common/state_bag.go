@@ -0,0 +1,242 @@
+package common
+
+import (
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"reflect"
+)
+
+// The keys of the values in the state that every builder shares with the
+// steps in this package. Builders put them with the setters of StateBag,
+// so that they always have the right type.
+const (
+	StateCache        = "cache"
+	StateCheckpoint   = "checkpoint"
+	StateCommunicator = "communicator"
+	StateError        = "error"
+	StateHook         = "hook"
+	StateUi           = "ui"
+)
+
+// StateBag gives typed access to the state that the steps of a builder
+// share, which multistep gives them as a map.
+//
+// The values every builder has, such as the UI and the error of the step
+// that failed, have their own getters and setters, so they can't be put
+// with the wrong type. Other values are put with Put and gotten with Get,
+// in the namespace of the bag, so that the values of a builder can't be
+// mixed up with those of the steps it shares. Get checks the type of the
+// value, and says which value is wrong instead of panicking in the step
+// that uses it.
+type StateBag struct {
+	state     map[string]interface{}
+	namespace string
+}
+
+// NewStateBag returns the bag of the state, whose values are in no
+// namespace.
+func NewStateBag(state map[string]interface{}) *StateBag {
+	return &StateBag{state: state}
+}
+
+// Namespace returns a bag of the same state whose values are put and
+// gotten in the namespace, such as the type of a builder.
+func (b *StateBag) Namespace(namespace string) *StateBag {
+	return &StateBag{state: b.state, namespace: namespace}
+}
+
+// Put sets the value with the name in the namespace of the bag.
+func (b *StateBag) Put(name string, value interface{}) {
+	b.state[b.key(name)] = value
+}
+
+// Get sets what the result points to to the value with the name in the
+// namespace of the bag. It is an error if the value isn't set, or isn't
+// of the type the result points to.
+func (b *StateBag) Get(name string, result interface{}) error {
+	ptr := reflect.ValueOf(result)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		panic(fmt.Sprintf("result of state '%s' must be a pointer", b.key(name)))
+	}
+
+	raw, ok := b.state[b.key(name)]
+	if !ok {
+		return fmt.Errorf("state '%s' isn't set", b.key(name))
+	}
+
+	target := ptr.Elem()
+	if raw == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	value := reflect.ValueOf(raw)
+	if !value.Type().AssignableTo(target.Type()) {
+		return fmt.Errorf("state '%s' is a %s, not a %s", b.key(name), value.Type(), target.Type())
+	}
+
+	target.Set(value)
+	return nil
+}
+
+// Has says whether the value with the name is set in the namespace of the
+// bag.
+func (b *StateBag) Has(name string) bool {
+	_, ok := b.state[b.key(name)]
+	return ok
+}
+
+// Remove removes the value with the name from the namespace of the bag.
+func (b *StateBag) Remove(name string) {
+	delete(b.state, b.key(name))
+}
+
+// Value gets the value with the name in the namespace of the bag like Get,
+// for the typed getters of builders. A value that isn't set leaves the
+// result as it is, the zero value for a getter, since steps can get values
+// that earlier steps don't always put, such as the port of an HTTP server
+// that isn't started. A value of the wrong type can only be put by a bug,
+// so Value panics with its name instead of hiding it behind a zero value.
+func (b *StateBag) Value(name string, result interface{}) {
+	if err := b.Get(name, result); err != nil && b.Has(name) {
+		panic(err.Error())
+	}
+}
+
+// HTTPPort returns the port of the HTTP server that serves http_directory
+// to the machine, or zero if there is none.
+func (b *StateBag) HTTPPort() (result uint) {
+	b.Value("http_port", &result)
+	return
+}
+
+// SetHTTPPort sets the port of the HTTP server.
+func (b *StateBag) SetHTTPPort(value uint) {
+	b.Put("http_port", value)
+}
+
+// ISOPath returns the path of the ISO that the machine boots from, once
+// it is downloaded.
+func (b *StateBag) ISOPath() (result string) {
+	b.Value("iso_path", &result)
+	return
+}
+
+// SetISOPath sets the path of the ISO.
+func (b *StateBag) SetISOPath(value string) {
+	b.Put("iso_path", value)
+}
+
+// PrivateKey returns the private key that SSH connects to the machine
+// with, such as that of a temporary key pair.
+func (b *StateBag) PrivateKey() (result string) {
+	b.Value("private_key", &result)
+	return
+}
+
+// SetPrivateKey sets the private key that SSH connects with.
+func (b *StateBag) SetPrivateKey(value string) {
+	b.Put("private_key", value)
+}
+
+// Ui returns the UI of the build.
+func (b *StateBag) Ui() packer.Ui {
+	ui, _ := b.state[StateUi].(packer.Ui)
+	return ui
+}
+
+// SetUi sets the UI of the build.
+func (b *StateBag) SetUi(ui packer.Ui) {
+	b.state[StateUi] = ui
+}
+
+// Hook returns the hook of the build, which runs the provisioners.
+func (b *StateBag) Hook() packer.Hook {
+	hook, _ := b.state[StateHook].(packer.Hook)
+	return hook
+}
+
+// SetHook sets the hook of the build.
+func (b *StateBag) SetHook(hook packer.Hook) {
+	b.state[StateHook] = hook
+}
+
+// Cache returns the cache that downloads are kept in.
+func (b *StateBag) Cache() packer.Cache {
+	cache, _ := b.state[StateCache].(packer.Cache)
+	return cache
+}
+
+// SetCache sets the cache that downloads are kept in.
+func (b *StateBag) SetCache(cache packer.Cache) {
+	b.state[StateCache] = cache
+}
+
+// Checkpoint returns the checkpoint of the build. See Checkpoint.
+func (b *StateBag) Checkpoint() *Checkpoint {
+	checkpoint, _ := b.state[StateCheckpoint].(*Checkpoint)
+	return checkpoint
+}
+
+// SetCheckpoint sets the checkpoint of the build.
+func (b *StateBag) SetCheckpoint(checkpoint *Checkpoint) {
+	b.state[StateCheckpoint] = checkpoint
+}
+
+// Communicator returns the communicator to the machine, which is nil
+// until a step connects to it.
+func (b *StateBag) Communicator() packer.Communicator {
+	comm, _ := b.state[StateCommunicator].(packer.Communicator)
+	return comm
+}
+
+// SetCommunicator sets the communicator to the machine.
+func (b *StateBag) SetCommunicator(comm packer.Communicator) {
+	b.state[StateCommunicator] = comm
+}
+
+// Error returns the error of the step that failed, if one did.
+func (b *StateBag) Error() error {
+	err, _ := b.state[StateError].(error)
+	return err
+}
+
+// SetError sets the error of the step that failed.
+func (b *StateBag) SetError(err error) {
+	b.state[StateError] = err
+}
+
+// Halt sets the error of the step that failed and shows it, and returns
+// the action that halts the build, so a step can fail with:
+//
+//	return bag.Halt(err)
+func (b *StateBag) Halt(err error) multistep.StepAction {
+	b.SetError(err)
+	if ui := b.Ui(); ui != nil {
+		ui.Error(err.Error())
+	}
+
+	return multistep.ActionHalt
+}
+
+// Cancelled says whether the build was cancelled.
+func (b *StateBag) Cancelled() bool {
+	_, ok := b.state[multistep.StateCancelled]
+	return ok
+}
+
+// Halted says whether a step halted the build.
+func (b *StateBag) Halted() bool {
+	_, ok := b.state[multistep.StateHalted]
+	return ok
+}
+
+// key returns the key of the value with the name in the state.
+func (b *StateBag) key(name string) string {
+	if b.namespace == "" {
+		return name
+	}
+
+	return b.namespace + "." + name
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"strings"
+	"testing"
+)
+
+func TestStateBag_Namespace(t *testing.T) {
+	state := make(map[string]interface{})
+	bag := NewStateBag(state)
+	ns := bag.Namespace("foo")
+
+	ns.Put("instance", "i-1234")
+	if _, ok := state["foo.instance"]; !ok {
+		t.Fatalf("bad: %#v", state)
+	}
+
+	if bag.Has("instance") || !ns.Has("instance") {
+		t.Fatal("value should only be in the namespace")
+	}
+
+	var instance string
+	if err := ns.Get("instance", &instance); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if instance != "i-1234" {
+		t.Fatalf("bad: %s", instance)
+	}
+
+	ns.Remove("instance")
+	if ns.Has("instance") {
+		t.Fatal("should be removed")
+	}
+}
+
+func TestStateBag_Get(t *testing.T) {
+	bag := NewStateBag(make(map[string]interface{}))
+	bag.Put("port", 22)
+	bag.Put("none", nil)
+	bag.Put("ui", new(packer.ReaderWriterUi))
+
+	var port int
+	if err := bag.Get("port", &port); err != nil || port != 22 {
+		t.Fatalf("bad: %d %s", port, err)
+	}
+
+	// Values of the wrong type are errors instead of panics
+	var name string
+	err := bag.Get("port", &name)
+	if err == nil || err.Error() != "state 'port' is a int, not a string" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	err = bag.Get("missing", &name)
+	if err == nil || err.Error() != "state 'missing' isn't set" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Values can be gotten as an interface they implement
+	var ui packer.Ui
+	if err := bag.Get("ui", &ui); err != nil || ui == nil {
+		t.Fatalf("bad: %#v %s", ui, err)
+	}
+
+	ui = new(packer.ReaderWriterUi)
+	if err := bag.Get("none", &ui); err != nil || ui != nil {
+		t.Fatalf("bad: %#v %s", ui, err)
+	}
+}
+
+func TestStateBag_Value(t *testing.T) {
+	bag := NewStateBag(make(map[string]interface{})).Namespace("foo")
+	if bag.HTTPPort() != 0 {
+		t.Fatal("should be the zero value")
+	}
+
+	bag.SetHTTPPort(8080)
+	if bag.HTTPPort() != 8080 {
+		t.Fatalf("bad: %d", bag.HTTPPort())
+	}
+
+	// Values of the wrong type are bugs, which panic with the name
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(r.(string), "'foo.iso_path'") {
+			t.Fatalf("bad: %#v", r)
+		}
+	}()
+
+	bag.Put("iso_path", 22)
+	bag.ISOPath()
+}
+
+func TestStateBag_Halt(t *testing.T) {
+	out := new(bytes.Buffer)
+	state := make(map[string]interface{})
+	bag := NewStateBag(state)
+	bag.SetUi(&packer.ReaderWriterUi{Reader: new(bytes.Buffer), Writer: out})
+
+	if bag.Error() != nil {
+		t.Fatalf("bad: %s", bag.Error())
+	}
+
+	if action := bag.Halt(errors.New("failed")); action != multistep.ActionHalt {
+		t.Fatalf("bad: %#v", action)
+	}
+
+	if err, ok := state[StateError].(error); !ok || err.Error() != "failed" {
+		t.Fatalf("bad: %#v", state)
+	}
+
+	if !strings.Contains(out.String(), "failed") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestStateBag_Shared(t *testing.T) {
+	state := make(map[string]interface{})
+	bag := NewStateBag(state)
+	if bag.Ui() != nil || bag.Communicator() != nil || bag.Cancelled() || bag.Halted() {
+		t.Fatal("should be empty")
+	}
+
+	// The shared values aren't in the namespace of a builder
+	ns := bag.Namespace("foo")
+	ns.SetUi(new(packer.ReaderWriterUi))
+	if _, ok := state[StateUi].(packer.Ui); !ok || bag.Ui() == nil {
+		t.Fatalf("bad: %#v", state)
+	}
+
+	state[multistep.StateCancelled] = true
+	if !ns.Cancelled() {
+		t.Fatal("should be cancelled")
+	}
+
+	state[multistep.StateHalted] = true
+	if !ns.Halted() {
+		t.Fatal("should be halted")
+	}
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"github.com/mitchellh/packer/packer/plugin"
+	"log"
+	"time"
+)
+
+// StepConnectPlugin connects to the machine with the communicator plugin
+// that the communicator names, trying again until the machine is reachable
+// or the timeout passes, and stops waiting if the build is cancelled. The
+// plugin is killed when the step cleans up. The communicator it connects
+// with is put in the state.
+type StepConnectPlugin struct {
+	// Info returns what the plugin is given to connect to the machine,
+	// such as its "host", "port" and "id".
+	Info func(map[string]interface{}) map[string]string
+
+	// The communicator configuration of the builder, and how long to
+	// wait for the machine.
+	Config  *CommunicatorConfig
+	Timeout time.Duration
+
+	plugin *plugin.Client
+}
+
+func (s *StepConnectPlugin) Run(state map[string]interface{}) multistep.StepAction {
+	bag := NewStateBag(state)
+	ui := bag.Ui()
+	name := s.Config.Communicator
+
+	factory, client, err := s.Config.StartCommunicatorPlugin()
+	if err != nil {
+		return bag.Halt(err)
+	}
+
+	s.plugin = client
+	info := s.Info(state)
+
+	// Start trying to connect with the plugin, which is retried until
+	// the machine is reachable.
+	connected := make(chan packer.Communicator, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		for attempts := 1; ; attempts++ {
+			log.Printf("Connecting with %s (attempt %d)", name, attempts)
+			comm, err := factory.New(info)
+			if err == nil {
+				connected <- comm
+				return
+			}
+
+			log.Printf("%s connection failed: %s", name, err)
+			select {
+			case <-quit:
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+
+	ui.Say(fmt.Sprintf("Connecting to the machine via %s...", name))
+	log.Printf("Waiting up to %s for %s connection", s.Timeout, name)
+	timeout := time.After(s.Timeout)
+
+	for {
+		select {
+		case comm := <-connected:
+			bag.SetCommunicator(comm)
+			return multistep.ActionContinue
+		case <-timeout:
+			return bag.Halt(fmt.Errorf("Timeout waiting for %s to become available.", name))
+		case <-time.After(1 * time.Second):
+			if bag.Cancelled() {
+				log.Printf("Interrupt detected, quitting waiting for %s.", name)
+				return multistep.ActionHalt
+			}
+		}
+	}
+}
+
+func (s *StepConnectPlugin) Cleanup(map[string]interface{}) {
+	if s.plugin != nil {
+		s.plugin.Kill()
+		s.plugin = nil
+	}
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	gossh "code.google.com/p/go.crypto/ssh"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/ssh"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// The number of times the SSH handshake is tried once the machine accepts
+// connections, since the SSH server may not be ready yet.
+const sshHandshakeAttempts = 6
+
+// StepConnectSSH connects to the machine over SSH, trying again until the
+// machine is reachable or the timeout passes, and stops waiting if the
+// build is cancelled. Builders only have to say where the machine is and
+// how to log in. The communicator it connects with, which retries the
+// operations that fail, is put in the state.
+type StepConnectSSH struct {
+	// Address returns the host:port of SSH on the machine. It is called
+	// for each attempt, so it can return an error while the address isn't
+	// known yet.
+	Address func(map[string]interface{}) (string, error)
+
+	// ClientConfig returns the configuration of the SSH client, with the
+	// user and the ways to authenticate, such as SSHConfig.ClientConfig,
+	// and the connection to the SSH agent if it is used, which is closed
+	// when the step cleans up.
+	ClientConfig func(map[string]interface{}) (*gossh.ClientConfig, io.Closer, error)
+
+	// The SSH configuration of the builder, and how long to wait for the
+	// machine.
+	Config  *SSHConfig
+	Timeout time.Duration
+
+	agent io.Closer
+	conn  net.Conn
+}
+
+func (s *StepConnectSSH) Run(state map[string]interface{}) multistep.StepAction {
+	bag := NewStateBag(state)
+	ui := bag.Ui()
+
+	sshConfig, agent, err := s.ClientConfig(state)
+	s.agent = agent
+	if err != nil {
+		return bag.Halt(fmt.Errorf("Error setting up SSH config: %s", err))
+	}
+
+	type result struct {
+		comm packer.Communicator
+		conn net.Conn
+		err  error
+	}
+
+	connected := make(chan result, 1)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	go func() {
+		comm, conn, err := s.connect(state, sshConfig, quit)
+
+		// A connection that is made once the step stopped waiting is
+		// closed, since nothing will use it.
+		select {
+		case <-quit:
+			if conn != nil {
+				conn.Close()
+			}
+		default:
+			connected <- result{comm, conn, err}
+		}
+	}()
+
+	ui.Say("Connecting to the machine via SSH...")
+	log.Printf("Waiting up to %s for SSH connection", s.Timeout)
+	timeout := time.After(s.Timeout)
+
+	for {
+		select {
+		case r := <-connected:
+			if r.err != nil {
+				return bag.Halt(fmt.Errorf("Error connecting to SSH: %s", r.err))
+			}
+
+			s.conn = r.conn
+			bag.SetCommunicator(s.Config.Retry(r.comm))
+			return multistep.ActionContinue
+		case <-timeout:
+			return bag.Halt(errors.New("Timeout waiting for SSH to become available."))
+		case <-time.After(1 * time.Second):
+			if bag.Cancelled() {
+				log.Println("Interrupt detected, quitting waiting for SSH.")
+				return multistep.ActionHalt
+			}
+		}
+	}
+}
+
+func (s *StepConnectSSH) Cleanup(map[string]interface{}) {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	if s.agent != nil {
+		s.agent.Close()
+		s.agent = nil
+	}
+}
+
+// connect tries to connect to SSH until it succeeds, the handshake keeps
+// failing, or quit is closed.
+func (s *StepConnectSSH) connect(state map[string]interface{}, sshConfig *gossh.ClientConfig, quit <-chan struct{}) (packer.Communicator, net.Conn, error) {
+	handshakeAttempts := 0
+	for attempts := 1; ; attempts++ {
+		select {
+		case <-quit:
+			return nil, nil, errors.New("SSH wait cancelled")
+		default:
+		}
+
+		// A brief sleep between attempts so we're not being overly
+		// zealous attempting to connect to the machine.
+		if attempts > 1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		address, err := s.Address(state)
+		if err != nil {
+			log.Printf("[DEBUG] SSH address isn't known yet: %s", err)
+			continue
+		}
+
+		log.Printf("Opening TCP conn for SSH to %s (attempt %d)", address, attempts)
+		conn, err := s.Config.Connection(address)()
+		if err != nil {
+			continue
+		}
+
+		log.Println("TCP connection made. Attempting SSH handshake.")
+		comm, err := ssh.New(conn, s.Config.CommConfig(address, sshConfig))
+		if err != nil {
+			conn.Close()
+
+			handshakeAttempts++
+			log.Printf("SSH handshake error: %s", err)
+			if handshakeAttempts >= sshHandshakeAttempts {
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		log.Println("Connected to SSH!")
+		return comm, conn, nil
+	}
+}
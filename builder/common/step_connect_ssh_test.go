@@ -0,0 +1,71 @@
+package common
+
+import (
+	gossh "code.google.com/p/go.crypto/ssh"
+	"errors"
+	"github.com/mitchellh/multistep"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStepConnectSSH_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(StepConnectSSH)
+	if _, ok := raw.(multistep.Step); !ok {
+		t.Fatal("should be a step")
+	}
+}
+
+func TestStepConnectSSH_ClientConfigError(t *testing.T) {
+	state := map[string]interface{}{"ui": testRunnerUi("")}
+
+	step := &StepConnectSSH{
+		Address: func(map[string]interface{}) (string, error) {
+			t.Fatal("should not get the address")
+			return "", nil
+		},
+		ClientConfig: func(map[string]interface{}) (*gossh.ClientConfig, io.Closer, error) {
+			return nil, nil, errors.New("bad key")
+		},
+		Config:  new(SSHConfig),
+		Timeout: 1 * time.Second,
+	}
+
+	if action := step.Run(state); action != multistep.ActionHalt {
+		t.Fatalf("bad: %#v", action)
+	}
+
+	if _, ok := state["error"]; !ok {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStepConnectSSH_Timeout(t *testing.T) {
+	state := map[string]interface{}{"ui": testRunnerUi("")}
+
+	step := &StepConnectSSH{
+		Address: func(map[string]interface{}) (string, error) {
+			return "", errors.New("no address yet")
+		},
+		ClientConfig: func(map[string]interface{}) (*gossh.ClientConfig, io.Closer, error) {
+			return new(gossh.ClientConfig), nil, nil
+		},
+		Config:  new(SSHConfig),
+		Timeout: 10 * time.Millisecond,
+	}
+
+	if action := step.Run(state); action != multistep.ActionHalt {
+		t.Fatalf("bad: %#v", action)
+	}
+
+	if _, ok := state["error"]; !ok {
+		t.Fatal("should have error")
+	}
+
+	if _, ok := state["communicator"]; ok {
+		t.Fatal("should not have communicator")
+	}
+
+	step.Cleanup(state)
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"log"
+)
+
+// StepProvision runs the provisioners of the build on the machine, by
+// running the provision hook, and then the provision_done hook, with the
+// communicator, hook and UI in the state.
+type StepProvision struct {
+	// The name of the build, which is given to the provision_done hook.
+	BuildName string
+}
+
+func (s *StepProvision) Run(state map[string]interface{}) multistep.StepAction {
+	bag := NewStateBag(state)
+	comm := bag.Communicator()
+	hook := bag.Hook()
+	ui := bag.Ui()
+
+	log.Println("Running the provision hook")
+	if err := hook.Run(packer.HookProvision, ui, comm, nil); err != nil {
+		bag.SetError(err)
+		return multistep.ActionHalt
+	}
+
+	hookData := map[string]string{"build_name": s.BuildName}
+	if err := hook.Run(packer.HookProvisionDone, ui, comm, hookData); err != nil {
+		bag.SetError(err)
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (*StepProvision) Cleanup(map[string]interface{}) {}
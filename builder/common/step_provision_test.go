@@ -0,0 +1,84 @@
+package common
+
+import (
+	"errors"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/none"
+	"github.com/mitchellh/packer/packer"
+	"testing"
+)
+
+// testRecordingHook records the hooks it runs, and fails the one named err.
+type testRecordingHook struct {
+	err   string
+	names []string
+	data  []interface{}
+}
+
+func (h *testRecordingHook) Run(name string, ui packer.Ui, comm packer.Communicator, data interface{}) error {
+	h.names = append(h.names, name)
+	h.data = append(h.data, data)
+	if name == h.err {
+		return errors.New("failed")
+	}
+
+	return nil
+}
+
+func testProvisionState(hook packer.Hook) map[string]interface{} {
+	return map[string]interface{}{
+		"communicator": none.New(),
+		"hook":         hook,
+		"ui":           testRunnerUi(""),
+	}
+}
+
+func TestStepProvision_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(StepProvision)
+	if _, ok := raw.(multistep.Step); !ok {
+		t.Fatal("should be a step")
+	}
+}
+
+func TestStepProvision_Run(t *testing.T) {
+	hook := new(testRecordingHook)
+	state := testProvisionState(hook)
+
+	step := &StepProvision{BuildName: "foo"}
+	if action := step.Run(state); action != multistep.ActionContinue {
+		t.Fatalf("bad: %#v", action)
+	}
+
+	if len(hook.names) != 2 {
+		t.Fatalf("bad: %#v", hook.names)
+	}
+
+	if hook.names[0] != packer.HookProvision || hook.names[1] != packer.HookProvisionDone {
+		t.Fatalf("bad: %#v", hook.names)
+	}
+
+	data := hook.data[1].(map[string]string)
+	if data["build_name"] != "foo" {
+		t.Fatalf("bad: %#v", data)
+	}
+}
+
+func TestStepProvision_RunError(t *testing.T) {
+	hook := &testRecordingHook{err: packer.HookProvision}
+	state := testProvisionState(hook)
+
+	step := new(StepProvision)
+	if action := step.Run(state); action != multistep.ActionHalt {
+		t.Fatalf("bad: %#v", action)
+	}
+
+	if _, ok := state["error"]; !ok {
+		t.Fatal("should have error")
+	}
+
+	// The provision_done hook isn't run if provisioning failed
+	if len(hook.names) != 1 {
+		t.Fatalf("bad: %#v", hook.names)
+	}
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/winrm"
+	"github.com/mitchellh/packer/packer"
+)
+
+// WinRMConfig contains the configuration of the WinRM communicator for
+// Windows machines. It is meant to be embedded into a builder's
+// configuration with ",squash", along with a CommunicatorConfig.
+type WinRMConfig struct {
+	// The credentials of the user to connect to WinRM as.
+	WinRMUsername string `mapstructure:"winrm_username"`
+	WinRMPassword string `mapstructure:"winrm_password"`
+
+	// The port of WinRM on the machine, which defaults to 5985 for HTTP
+	// and 5986 for HTTPS.
+	WinRMPort int `mapstructure:"winrm_port"`
+
+	// If true, HTTPS is used, and if insecure is also true, the
+	// certificate of the machine isn't verified.
+	WinRMUseSSL   bool `mapstructure:"winrm_use_ssl"`
+	WinRMInsecure bool `mapstructure:"winrm_insecure"`
+
+	// If true, NTLM is used to authenticate rather than basic
+	// authentication.
+	WinRMUseNTLM bool `mapstructure:"winrm_use_ntlm"`
+}
+
+// Prepare processes the templates in, sets defaults for and validates the
+// WinRM configuration, returning any errors that were found.
+func (c *WinRMConfig) Prepare(t *packer.ConfigTemplate) []error {
+	if c.WinRMPort == 0 {
+		c.WinRMPort = 5985
+		if c.WinRMUseSSL {
+			c.WinRMPort = 5986
+		}
+	}
+
+	errs := make([]error, 0)
+
+	templates := map[string]*string{
+		"winrm_username": &c.WinRMUsername,
+		"winrm_password": &c.WinRMPassword,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = t.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	if c.WinRMPort < 0 {
+		errs = append(errs, errors.New("winrm_port must be positive"))
+	}
+
+	return errs
+}
+
+// CommConfig returns the configuration for the WinRM communicator to
+// connect to the given host. The port is normally WinRMPort, but builders
+// that forward the port use the forwarded port instead.
+func (c *WinRMConfig) CommConfig(host string, port int) *winrm.Config {
+	return &winrm.Config{
+		Host:     host,
+		Port:     port,
+		Username: c.WinRMUsername,
+		Password: c.WinRMPassword,
+		HTTPS:    c.WinRMUseSSL,
+		Insecure: c.WinRMInsecure,
+		NTLM:     c.WinRMUseNTLM,
+	}
+}
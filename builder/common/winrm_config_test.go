@@ -0,0 +1,71 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestWinRMConfigPrepare_Port(t *testing.T) {
+	var c WinRMConfig
+
+	// Test default
+	if errs := c.Prepare(testConfigTemplate()); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	if c.WinRMPort != 5985 {
+		t.Fatalf("bad: %d", c.WinRMPort)
+	}
+
+	// Test the default with SSL
+	c = WinRMConfig{WinRMUseSSL: true}
+	if errs := c.Prepare(testConfigTemplate()); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	if c.WinRMPort != 5986 {
+		t.Fatalf("bad: %d", c.WinRMPort)
+	}
+
+	// Test set
+	c = WinRMConfig{WinRMPort: 8080}
+	if errs := c.Prepare(testConfigTemplate()); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	if c.WinRMPort != 8080 {
+		t.Fatalf("bad: %d", c.WinRMPort)
+	}
+
+	// Test bad
+	c = WinRMConfig{WinRMPort: -1}
+	if errs := c.Prepare(testConfigTemplate()); len(errs) != 1 {
+		t.Fatalf("should have error: %#v", errs)
+	}
+}
+
+func TestWinRMConfigCommConfig(t *testing.T) {
+	c := WinRMConfig{
+		WinRMUsername: "Administrator",
+		WinRMPassword: "secret",
+		WinRMUseSSL:   true,
+		WinRMInsecure: true,
+		WinRMUseNTLM:  true,
+	}
+
+	if errs := c.Prepare(testConfigTemplate()); len(errs) > 0 {
+		t.Fatalf("should not have error: %#v", errs)
+	}
+
+	result := c.CommConfig("127.0.0.1", 2222)
+	if result.Host != "127.0.0.1" || result.Port != 2222 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if result.Username != "Administrator" || result.Password != "secret" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if !result.HTTPS || !result.Insecure || !result.NTLM {
+		t.Fatalf("bad: %#v", result)
+	}
+}
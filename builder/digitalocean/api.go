@@ -6,9 +6,10 @@ package digitalocean
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
+	"github.com/mitchellh/packer/builder/common"
+	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -40,10 +41,10 @@ type DigitalOceanClient struct {
 	APIKey   string
 }
 
-// Creates a new client for communicating with DO
-func (d DigitalOceanClient) New(client string, key string) *DigitalOceanClient {
+// Creates a new client for communicating with DO, through the proxy
+func (d DigitalOceanClient) New(client string, key string, proxy *packer.ProxyConfig) *DigitalOceanClient {
 	c := &DigitalOceanClient{
-		client:   http.DefaultClient,
+		client:   proxy.Client(),
 		BaseURL:  DIGITALOCEAN_API_URL,
 		ClientID: client,
 		APIKey:   key,
@@ -163,9 +164,41 @@ func (d DigitalOceanClient) DropletStatus(id uint) (string, string, error) {
 	return ip, status, err
 }
 
+// apiError is an error response of the API, with its HTTP status so that
+// requests the API refused to serve right then can be tried again.
+type apiError struct {
+	statusCode int
+	message    string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// isRefused says whether the error is from a request the API didn't serve
+// because there were too many, or it was unavailable. Nothing was done for
+// those, so they're safe to send again, even ones that create droplets.
+func isRefused(err error) bool {
+	apiErr, ok := err.(*apiError)
+	return ok && (apiErr.statusCode == 429 ||
+		apiErr.statusCode == http.StatusServiceUnavailable)
+}
+
 // Sends an api request and returns a generic map[string]interface of
-// the response.
+// the response. Requests the API refuses, because of its rate limits or
+// because it is unavailable, are tried again, waiting longer each time.
 func NewRequest(d DigitalOceanClient, path string, params string) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	r := &common.Retry{ShouldRetry: isRefused}
+	err := r.Run(func() (err error) {
+		result, err = newRequest(d, path, params)
+		return
+	})
+
+	return result, err
+}
+
+func newRequest(d DigitalOceanClient, path string, params string) (map[string]interface{}, error) {
 	client := d.client
 	url := fmt.Sprintf("%s/%s?%s&client_id=%s&api_key=%s",
 		DIGITALOCEAN_API_URL, path, params, d.ClientID, d.APIKey)
@@ -195,8 +228,8 @@ func NewRequest(d DigitalOceanClient, path string, params string) (map[string]in
 
 	// Check for bad JSON
 	if err != nil {
-		err = errors.New(fmt.Sprintf("Failed to decode JSON response (HTTP %v) from DigitalOcean: %s",
-			resp.StatusCode, body))
+		err = &apiError{resp.StatusCode, fmt.Sprintf(
+			"Failed to decode JSON response (HTTP %v) from DigitalOcean: %s", resp.StatusCode, body)}
 		return decodedResponse, err
 	}
 
@@ -207,7 +240,8 @@ func NewRequest(d DigitalOceanClient, path string, params string) (map[string]in
 		if status == "ERROR" {
 			status = decodedResponse["error_message"]
 		}
-		err = errors.New(fmt.Sprintf("Received bad response (HTTP %v) from DigitalOcean: %s", resp.StatusCode, status))
+		err = &apiError{resp.StatusCode, fmt.Sprintf(
+			"Received bad response (HTTP %v) from DigitalOcean: %s", resp.StatusCode, status)}
 		return decodedResponse, err
 	}
 
@@ -4,16 +4,13 @@
 package digitalocean
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"strconv"
-	"text/template"
 	"time"
 )
 
@@ -41,12 +38,24 @@ type config struct {
 	EventDelay   time.Duration
 	StateTimeout time.Duration
 
-	PackerDebug bool `mapstructure:"packer_debug"`
+	common.SSHConfig          `mapstructure:",squash"`
+	common.CommunicatorConfig `mapstructure:",squash"`
+
+	PackerBuildName string              `mapstructure:"packer_build_name"`
+	PackerDebug     bool                `mapstructure:"packer_debug"`
+	PackerForce     bool                `mapstructure:"packer_force"`
+	PackerOnError   string              `mapstructure:"packer_on_error"`
+	PackerResume    bool                `mapstructure:"packer_resume"`
+	PackerBuildVars map[string]string   `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string   `mapstructure:"packer_user_variables"`
+	PackerProxy     *packer.ProxyConfig `mapstructure:"packer_proxy"`
 
 	RawSnapshotName string `mapstructure:"snapshot_name"`
 	RawSSHTimeout   string `mapstructure:"ssh_timeout"`
 	RawEventDelay   string `mapstructure:"event_delay"`
 	RawStateTimeout string `mapstructure:"state_timeout"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type Builder struct {
@@ -55,11 +64,13 @@ type Builder struct {
 }
 
 func (b *Builder) Prepare(raws ...interface{}) error {
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &b.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&b.config, raws...); err != nil {
+		return err
+	}
+
+	b.config.tpl = &packer.ConfigTemplate{
+		UserVars:  b.config.PackerUserVars,
+		BuildVars: b.config.PackerBuildVars,
 	}
 
 	// Optional configuration with defaults
@@ -115,6 +126,26 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 	// A list of errors on the configuration
 	errs := make([]error, 0)
 
+	templates := map[string]*string{
+		"client_id":     &b.config.ClientID,
+		"api_key":       &b.config.APIKey,
+		"ssh_username":  &b.config.SSHUsername,
+		"ssh_timeout":   &b.config.RawSSHTimeout,
+		"event_delay":   &b.config.RawEventDelay,
+		"state_timeout": &b.config.RawStateTimeout,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = b.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	errs = append(errs, b.config.SSHConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.CommunicatorConfig.Prepare(b.config.tpl, "ssh", "none")...)
+
 	// Required configurations that will display errors if not set
 	//
 	if b.config.ClientID == "" {
@@ -144,16 +175,12 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 	b.config.StateTimeout = stateTimeout
 
 	// Parse the name of the snapshot
-	snapNameBuf := new(bytes.Buffer)
 	tData := snapshotNameData{
 		strconv.FormatInt(time.Now().UTC().Unix(), 10),
 	}
-	t, err := template.New("snapshot").Parse(b.config.RawSnapshotName)
+	b.config.SnapshotName, err = b.config.tpl.Process(b.config.RawSnapshotName, tData)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("Failed parsing snapshot_name: %s", err))
-	} else {
-		t.Execute(snapNameBuf, tData)
-		b.config.SnapshotName = snapNameBuf.String()
 	}
 
 	if len(errs) > 0 {
@@ -165,52 +192,56 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 }
 
 func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
+	// This builder doesn't checkpoint its steps, so a build that didn't
+	// finish can't be picked up again
+	if b.config.PackerResume {
+		ui.Say("WARNING: The DigitalOcean builder can't resume builds, so this build\n" +
+			"starts from scratch.")
+	}
+
 	// Initialize the DO API client
-	client := DigitalOceanClient{}.New(b.config.ClientID, b.config.APIKey)
+	client := DigitalOceanClient{}.New(b.config.ClientID, b.config.APIKey, b.config.PackerProxy)
 
 	// Set up the state
 	state := make(map[string]interface{})
-	state["config"] = b.config
-	state["client"] = client
-	state["hook"] = hook
-	state["ui"] = ui
+	bag := newStepState(state)
+	bag.setConfig(b.config)
+	bag.setClient(client)
+	bag.SetHook(hook)
+	bag.SetUi(ui)
 
 	// Build the steps
 	steps := []multistep.Step{
-		new(stepCreateSSHKey),
+		&stepCreateSSHKey{
+			Debug:        b.config.PackerDebug,
+			DebugKeyPath: fmt.Sprintf("do_%s.pem", b.config.PackerBuildName),
+		},
 		new(stepCreateDroplet),
 		new(stepDropletInfo),
-		new(stepConnectSSH),
-		new(stepProvision),
+		new(stepConnect),
+		&common.StepProvision{BuildName: b.config.PackerBuildName},
 		new(stepPowerOff),
 		new(stepSnapshot),
 	}
 
 	// Run the steps
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerDebug, b.config.PackerOnError, ui)
 
 	b.runner.Run(state)
 
 	// If there was an error, return that
-	if rawErr, ok := state["error"]; ok {
-		return nil, rawErr.(error)
+	if err := bag.Error(); err != nil {
+		return nil, err
 	}
 
-	if _, ok := state["snapshot_name"]; !ok {
-		log.Println("Failed to find snapshot_name in state. Bug?")
+	if !bag.Has("snapshot_name") {
+		log.Println("[ERROR] Failed to find snapshot_name in state. Bug?")
 		return nil, nil
 	}
 
 	artifact := &Artifact{
-		snapshotName: state["snapshot_name"].(string),
-		snapshotId:   state["snapshot_image_id"].(uint),
+		snapshotName: bag.snapshotName(),
+		snapshotId:   bag.snapshotImageId(),
 		client:       client,
 	}
 
@@ -189,6 +189,35 @@ func TestBuilderPrepare_SSHUsername(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_Communicator(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test none
+	config["communicator"] = "none"
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test a plugin
+	config["communicator"] = "agent"
+	config["packer_communicators"] = map[string]string{"agent": "packer-communicator-agent"}
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Test that WinRM isn't supported
+	config["communicator"] = "winrm"
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestBuilderPrepare_SSHTimeout(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -321,3 +350,35 @@ func TestBuilderPrepare_SnapshotName(t *testing.T) {
 	}
 
 }
+
+func TestBuilderPrepare_Proxy(t *testing.T) {
+	var b Builder
+	config := testConfig()
+
+	// Test default
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.PackerProxy != nil {
+		t.Fatalf("bad: %#v", b.config.PackerProxy)
+	}
+
+	// Test set
+	config[packer.ProxyConfigKey] = map[string]interface{}{
+		"http_proxy":  "http://proxy.example.com:3128",
+		"https_proxy": "",
+		"no_proxy":    "localhost",
+	}
+	b = Builder{}
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	proxy := b.config.PackerProxy
+	if proxy == nil || proxy.HTTPProxy != "http://proxy.example.com:3128" || proxy.NoProxy != "localhost" {
+		t.Fatalf("bad: %#v", proxy)
+	}
+}
@@ -0,0 +1,34 @@
+package digitalocean
+
+import (
+	gossh "code.google.com/p/go.crypto/ssh"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+func sshAddress(state map[string]interface{}) (string, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	ipAddress := bag.dropletIp()
+	return fmt.Sprintf("%s:%d", ipAddress, config.SSHPort), nil
+}
+
+// pluginInfo describes the droplet to a communicator plugin, which
+// connects to ssh_port.
+func pluginInfo(state map[string]interface{}) map[string]string {
+	bag := newStepState(state)
+	config := bag.config()
+	return map[string]string{
+		"host": bag.dropletIp(),
+		"port": strconv.FormatUint(uint64(config.SSHPort), 10),
+		"id":   strconv.FormatUint(uint64(bag.dropletId()), 10),
+	}
+}
+
+func sshConfig(state map[string]interface{}) (*gossh.ClientConfig, io.Closer, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	privateKey := bag.PrivateKey()
+	return config.SSHConfig.ClientConfig(config.SSHUsername, privateKey)
+}
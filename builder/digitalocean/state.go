@@ -0,0 +1,87 @@
+package digitalocean
+
+import "github.com/mitchellh/packer/builder/common"
+
+// The namespace of the values of this builder in the state of its steps.
+const stateNamespace = "digitalocean"
+
+// stepState is the state of the steps of the builder, with typed
+// accessors for the values they share, on top of those of common.StateBag
+// that every builder has.
+type stepState struct {
+	*common.StateBag
+}
+
+func newStepState(state map[string]interface{}) stepState {
+	return stepState{common.NewStateBag(state).Namespace(stateNamespace)}
+}
+
+// config returns the configuration of the builder.
+func (s stepState) config() (result config) {
+	s.Value("config", &result)
+	return
+}
+
+func (s stepState) setConfig(value config) {
+	s.Put("config", value)
+}
+
+// client returns the client of the DigitalOcean API.
+func (s stepState) client() (result *DigitalOceanClient) {
+	s.Value("client", &result)
+	return
+}
+
+func (s stepState) setClient(value *DigitalOceanClient) {
+	s.Put("client", value)
+}
+
+// sshKeyId returns the ID of the temporary SSH key.
+func (s stepState) sshKeyId() (result uint) {
+	s.Value("ssh_key_id", &result)
+	return
+}
+
+func (s stepState) setSshKeyId(value uint) {
+	s.Put("ssh_key_id", value)
+}
+
+// dropletId returns the ID of the droplet once it is created.
+func (s stepState) dropletId() (result uint) {
+	s.Value("droplet_id", &result)
+	return
+}
+
+func (s stepState) setDropletId(value uint) {
+	s.Put("droplet_id", value)
+}
+
+// dropletIp returns the IP address of the droplet once it is active.
+func (s stepState) dropletIp() (result string) {
+	s.Value("droplet_ip", &result)
+	return
+}
+
+func (s stepState) setDropletIp(value string) {
+	s.Put("droplet_ip", value)
+}
+
+// snapshotImageId returns the ID of the image of the snapshot.
+func (s stepState) snapshotImageId() (result uint) {
+	s.Value("snapshot_image_id", &result)
+	return
+}
+
+func (s stepState) setSnapshotImageId(value uint) {
+	s.Put("snapshot_image_id", value)
+}
+
+// snapshotName returns the name of the snapshot.
+func (s stepState) snapshotName() (result string) {
+	s.Value("snapshot_name", &result)
+	return
+}
+
+func (s stepState) setSnapshotName(value string) {
+	s.Put("snapshot_name", value)
+}
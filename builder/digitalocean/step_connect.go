@@ -0,0 +1,47 @@
+package digitalocean
+
+import (
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/builder/common"
+	"github.com/mitchellh/packer/communicator/none"
+)
+
+// stepConnect connects to the droplet with the communicator, which is SSH
+// unless the communicator is "none" or a communicator plugin.
+type stepConnect struct {
+	step multistep.Step
+}
+
+func (s *stepConnect) Run(state map[string]interface{}) multistep.StepAction {
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+
+	switch config.Communicator {
+	case "none":
+		ui.Say("Communicator is none, not connecting to the droplet.")
+		bag.SetCommunicator(none.New())
+		return multistep.ActionContinue
+	case "ssh":
+		s.step = &common.StepConnectSSH{
+			Address:      sshAddress,
+			ClientConfig: sshConfig,
+			Config:       &config.SSHConfig,
+			Timeout:      config.SSHTimeout,
+		}
+	default:
+		s.step = &common.StepConnectPlugin{
+			Info:    pluginInfo,
+			Config:  &config.CommunicatorConfig,
+			Timeout: config.SSHTimeout,
+		}
+	}
+
+	return s.step.Run(state)
+}
+
+func (s *stepConnect) Cleanup(state map[string]interface{}) {
+	if s.step != nil {
+		s.step.Cleanup(state)
+	}
+}
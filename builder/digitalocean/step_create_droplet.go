@@ -12,13 +12,15 @@ import (
 
 type stepCreateDroplet struct {
 	dropletId uint
+	resource  *packer.TempResource
 }
 
 func (s *stepCreateDroplet) Run(state map[string]interface{}) multistep.StepAction {
-	client := state["client"].(*DigitalOceanClient)
-	ui := state["ui"].(packer.Ui)
-	c := state["config"].(config)
-	sshKeyId := state["ssh_key_id"].(uint)
+	bag := newStepState(state)
+	client := bag.client()
+	ui := bag.Ui()
+	c := bag.config()
+	sshKeyId := bag.sshKeyId()
 
 	ui.Say("Creating droplet...")
 
@@ -29,16 +31,20 @@ func (s *stepCreateDroplet) Run(state map[string]interface{}) multistep.StepActi
 	dropletId, err := client.CreateDroplet(name, c.SizeID, c.ImageID, c.RegionID, sshKeyId)
 	if err != nil {
 		err := fmt.Errorf("Error creating droplet: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// We use this in cleanup
 	s.dropletId = dropletId
+	s.resource = &packer.TempResource{
+		Kind: "droplet",
+		ID:   fmt.Sprintf("%s (%d)", name, dropletId),
+		Hint: "destroy it in the DigitalOcean control panel",
+	}
+	packer.TrackTempResource(s.resource)
 
 	// Store the droplet id for later
-	state["droplet_id"] = dropletId
+	bag.setDropletId(dropletId)
 
 	return multistep.ActionContinue
 }
@@ -49,9 +55,10 @@ func (s *stepCreateDroplet) Cleanup(state map[string]interface{}) {
 		return
 	}
 
-	client := state["client"].(*DigitalOceanClient)
-	ui := state["ui"].(packer.Ui)
-	c := state["config"].(config)
+	bag := newStepState(state)
+	client := bag.client()
+	ui := bag.Ui()
+	c := bag.config()
 
 	// Destroy the droplet we just created
 	ui.Say("Destroying droplet...")
@@ -70,5 +77,7 @@ func (s *stepCreateDroplet) Cleanup(state map[string]interface{}) {
 	if err != nil {
 		ui.Error(fmt.Sprintf(
 			"Error destroying droplet. Please destroy it manually: %v", curlstr))
+	} else {
+		packer.UntrackTempResource(s.resource)
 	}
 }
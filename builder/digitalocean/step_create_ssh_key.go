@@ -11,16 +11,25 @@ import (
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
 	"log"
+	"os"
 )
 
 type stepCreateSSHKey struct {
-	keyId uint
+	// In debug mode, the private key is saved to DebugKeyPath so that
+	// the droplet can be connected to while the build is paused.
+	Debug        bool
+	DebugKeyPath string
+
+	keyId    uint
+	resource *packer.TempResource
 }
 
 func (s *stepCreateSSHKey) Run(state map[string]interface{}) multistep.StepAction {
-	client := state["client"].(*DigitalOceanClient)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	client := bag.client()
+	ui := bag.Ui()
 
 	ui.Say("Creating temporary ssh key for droplet...")
 
@@ -35,7 +44,7 @@ func (s *stepCreateSSHKey) Run(state map[string]interface{}) multistep.StepActio
 	}
 
 	// Set the private key in the statebag for later
-	state["privateKey"] = string(pem.EncodeToMemory(&priv_blk))
+	bag.SetPrivateKey(string(pem.EncodeToMemory(&priv_blk)))
 
 	// Marshal the public key into SSH compatible format
 	pub := priv.PublicKey
@@ -48,18 +57,31 @@ func (s *stepCreateSSHKey) Run(state map[string]interface{}) multistep.StepActio
 	keyId, err := client.CreateKey(name, pub_sshformat)
 	if err != nil {
 		err := fmt.Errorf("Error creating temporary SSH key: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// We use this to check cleanup
 	s.keyId = keyId
+	s.resource = &packer.TempResource{
+		Kind: "SSH key",
+		ID:   name,
+		Hint: "delete it in the DigitalOcean control panel",
+	}
+	packer.TrackTempResource(s.resource)
 
 	log.Printf("temporary ssh key name: %s", name)
 
 	// Remember some state for the future
-	state["ssh_key_id"] = keyId
+	bag.setSshKeyId(keyId)
+
+	if s.Debug {
+		ui.Message(fmt.Sprintf("Saving key for debug purposes: %s", s.DebugKeyPath))
+		err := ioutil.WriteFile(s.DebugKeyPath, pem.EncodeToMemory(&priv_blk), 0600)
+		if err != nil {
+			err := fmt.Errorf("Error saving debug key: %s", err)
+			return bag.Halt(err)
+		}
+	}
 
 	return multistep.ActionContinue
 }
@@ -70,9 +92,10 @@ func (s *stepCreateSSHKey) Cleanup(state map[string]interface{}) {
 		return
 	}
 
-	client := state["client"].(*DigitalOceanClient)
-	ui := state["ui"].(packer.Ui)
-	c := state["config"].(config)
+	bag := newStepState(state)
+	client := bag.client()
+	ui := bag.Ui()
+	c := bag.config()
 
 	ui.Say("Deleting temporary ssh key...")
 	err := client.DestroyKey(s.keyId)
@@ -81,8 +104,17 @@ func (s *stepCreateSSHKey) Cleanup(state map[string]interface{}) {
 		DIGITALOCEAN_API_URL, s.keyId, c.ClientID, c.APIKey)
 
 	if err != nil {
-		log.Printf("Error cleaning up ssh key: %v", err.Error())
+		log.Printf("[ERROR] Error cleaning up ssh key: %v", err.Error())
 		ui.Error(fmt.Sprintf(
 			"Error cleaning up ssh key. Please delete the key manually: %v", curlstr))
+	} else {
+		packer.UntrackTempResource(s.resource)
+	}
+
+	if s.Debug {
+		if err := os.Remove(s.DebugKeyPath); err != nil && !os.IsNotExist(err) {
+			ui.Error(fmt.Sprintf(
+				"Error removing debug key '%s': %s", s.DebugKeyPath, err))
+		}
 	}
 }
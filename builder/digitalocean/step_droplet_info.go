@@ -9,31 +9,43 @@ import (
 type stepDropletInfo struct{}
 
 func (s *stepDropletInfo) Run(state map[string]interface{}) multistep.StepAction {
-	client := state["client"].(*DigitalOceanClient)
-	ui := state["ui"].(packer.Ui)
-	c := state["config"].(config)
-	dropletId := state["droplet_id"].(uint)
+	bag := newStepState(state)
+	client := bag.client()
+	ui := bag.Ui()
+	c := bag.config()
+	dropletId := bag.dropletId()
 
 	ui.Say("Waiting for droplet to become active...")
 
 	err := waitForDropletState("active", dropletId, client, c)
 	if err != nil {
 		err := fmt.Errorf("Error waiting for droplet to become active: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Set the IP on the state for later
 	ip, _, err := client.DropletStatus(dropletId)
 	if err != nil {
 		err := fmt.Errorf("Error retrieving droplet ID: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
-	state["droplet_ip"] = ip
+	bag.setDropletIp(ip)
+
+	hook := bag.Hook()
+	hookData := map[string]string{
+		"build_name": c.PackerBuildName,
+		"id":         fmt.Sprintf("%d", dropletId),
+		"address":    ip,
+	}
+
+	if err := hook.Run(packer.HookInstanceLaunched, ui, nil, hookData); err != nil {
+		return bag.Halt(err)
+	}
+
+	if c.PackerDebug {
+		ui.Message(fmt.Sprintf("Droplet IP: %s", ip))
+	}
 
 	return multistep.ActionContinue
 }
@@ -3,7 +3,6 @@ package digitalocean
 import (
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"log"
 	"time"
 )
@@ -11,10 +10,11 @@ import (
 type stepPowerOff struct{}
 
 func (s *stepPowerOff) Run(state map[string]interface{}) multistep.StepAction {
-	client := state["client"].(*DigitalOceanClient)
-	c := state["config"].(config)
-	ui := state["ui"].(packer.Ui)
-	dropletId := state["droplet_id"].(uint)
+	bag := newStepState(state)
+	client := bag.client()
+	c := bag.config()
+	ui := bag.Ui()
+	dropletId := bag.dropletId()
 
 	// Sleep arbitrarily before sending power off request
 	// Otherwise we get "pending event" errors, even though there isn't
@@ -27,9 +27,7 @@ func (s *stepPowerOff) Run(state map[string]interface{}) multistep.StepAction {
 
 	if err != nil {
 		err := fmt.Errorf("Error powering off droplet: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	ui.Say("Waiting for droplet to power off...")
@@ -37,9 +35,7 @@ func (s *stepPowerOff) Run(state map[string]interface{}) multistep.StepAction {
 	err = waitForDropletState("off", dropletId, client, c)
 	if err != nil {
 		err := fmt.Errorf("Error waiting for droplet to become 'off': %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	return multistep.ActionContinue
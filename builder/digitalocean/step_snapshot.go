@@ -11,36 +11,40 @@ import (
 type stepSnapshot struct{}
 
 func (s *stepSnapshot) Run(state map[string]interface{}) multistep.StepAction {
-	client := state["client"].(*DigitalOceanClient)
-	ui := state["ui"].(packer.Ui)
-	c := state["config"].(config)
-	dropletId := state["droplet_id"].(uint)
+	bag := newStepState(state)
+	client := bag.client()
+	ui := bag.Ui()
+	c := bag.config()
+	dropletId := bag.dropletId()
+
+	// When the build is forced, the snapshots of earlier builds with the
+	// same name are replaced, so that the new one is found by its name.
+	if c.PackerForce {
+		if err := destroySnapshots(client, c.SnapshotName, ui); err != nil {
+			err := fmt.Errorf("Error destroying existing snapshot: %s", err)
+			return bag.Halt(err)
+		}
+	}
 
 	ui.Say(fmt.Sprintf("Creating snapshot: %v", c.SnapshotName))
 	err := client.CreateSnapshot(dropletId, c.SnapshotName)
 	if err != nil {
 		err := fmt.Errorf("Error creating snapshot: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	ui.Say("Waiting for snapshot to complete...")
 	err = waitForDropletState("active", dropletId, client, c)
 	if err != nil {
 		err := fmt.Errorf("Error waiting for snapshot to complete: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	log.Printf("Looking up snapshot ID for snapshot: %s", c.SnapshotName)
 	images, err := client.Images()
 	if err != nil {
 		err := fmt.Errorf("Error looking up snapshot ID: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	var imageId uint
@@ -53,15 +57,13 @@ func (s *stepSnapshot) Run(state map[string]interface{}) multistep.StepAction {
 
 	if imageId == 0 {
 		err := errors.New("Couldn't find snapshot to get the image ID. Bug?")
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	log.Printf("Snapshot image ID: %d", imageId)
 
-	state["snapshot_image_id"] = imageId
-	state["snapshot_name"] = c.SnapshotName
+	bag.setSnapshotImageId(imageId)
+	bag.setSnapshotName(c.SnapshotName)
 
 	return multistep.ActionContinue
 }
@@ -69,3 +71,24 @@ func (s *stepSnapshot) Run(state map[string]interface{}) multistep.StepAction {
 func (s *stepSnapshot) Cleanup(state map[string]interface{}) {
 	// no cleanup
 }
+
+// destroySnapshots destroys the snapshots with the given name.
+func destroySnapshots(client *DigitalOceanClient, name string, ui packer.Ui) error {
+	images, err := client.Images()
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		if image.Name != name {
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Destroying existing snapshot: %v", image.Id))
+		if err := client.DestroyImage(image.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,7 +3,6 @@ package virtualbox
 import (
 	"errors"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
 	"github.com/mitchellh/packer/packer"
@@ -34,6 +33,8 @@ type config struct {
 	HTTPDir            string        `mapstructure:"http_directory"`
 	HTTPPortMin        uint          `mapstructure:"http_port_min"`
 	HTTPPortMax        uint          `mapstructure:"http_port_max"`
+	ISOChecksum        string        `mapstructure:"iso_checksum"`
+	ISOChecksumType    string        `mapstructure:"iso_checksum_type"`
 	ISOMD5             string        `mapstructure:"iso_md5"`
 	ISOUrl             string        `mapstructure:"iso_url"`
 	OutputDir          string        `mapstructure:"output_directory"`
@@ -41,7 +42,6 @@ type config struct {
 	ShutdownTimeout    time.Duration ``
 	SSHHostPortMin     uint          `mapstructure:"ssh_host_port_min"`
 	SSHHostPortMax     uint          `mapstructure:"ssh_host_port_max"`
-	SSHPassword        string        `mapstructure:"ssh_password"`
 	SSHPort            uint          `mapstructure:"ssh_port"`
 	SSHUser            string        `mapstructure:"ssh_username"`
 	SSHWaitTimeout     time.Duration ``
@@ -49,22 +49,36 @@ type config struct {
 	VBoxManage         [][]string    `mapstructure:"vboxmanage"`
 	VMName             string        `mapstructure:"vm_name"`
 
-	PackerBuildName string `mapstructure:"packer_build_name"`
-	PackerDebug     bool   `mapstructure:"packer_debug"`
+	common.SSHConfig          `mapstructure:",squash"`
+	common.WinRMConfig        `mapstructure:",squash"`
+	common.CommunicatorConfig `mapstructure:",squash"`
+
+	PackerBuildName string              `mapstructure:"packer_build_name"`
+	PackerDebug     bool                `mapstructure:"packer_debug"`
+	PackerForce     bool                `mapstructure:"packer_force"`
+	PackerOnError   string              `mapstructure:"packer_on_error"`
+	PackerResume    bool                `mapstructure:"packer_resume"`
+	PackerBuildVars map[string]string   `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string   `mapstructure:"packer_user_variables"`
+	PackerProxy     *packer.ProxyConfig `mapstructure:"packer_proxy"`
 
 	RawBootWait        string `mapstructure:"boot_wait"`
 	RawShutdownTimeout string `mapstructure:"shutdown_timeout"`
 	RawSSHWaitTimeout  string `mapstructure:"ssh_wait_timeout"`
+
+	tpl *packer.ConfigTemplate
 }
 
 func (b *Builder) Prepare(raws ...interface{}) error {
 	var err error
 
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &b.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&b.config, raws...); err != nil {
+		return err
+	}
+
+	b.config.tpl = &packer.ConfigTemplate{
+		UserVars:  b.config.PackerUserVars,
+		BuildVars: b.config.PackerBuildVars,
 	}
 
 	if b.config.DiskSize == 0 {
@@ -121,14 +135,73 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 
 	errs := make([]error, 0)
 
+	templates := map[string]*string{
+		"guest_os_type":           &b.config.GuestOSType,
+		"http_directory":          &b.config.HTTPDir,
+		"iso_checksum":            &b.config.ISOChecksum,
+		"iso_checksum_type":       &b.config.ISOChecksumType,
+		"iso_md5":                 &b.config.ISOMD5,
+		"iso_url":                 &b.config.ISOUrl,
+		"output_directory":        &b.config.OutputDir,
+		"shutdown_command":        &b.config.ShutdownCommand,
+		"ssh_username":            &b.config.SSHUser,
+		"virtualbox_version_file": &b.config.VBoxVersionFile,
+		"vm_name":                 &b.config.VMName,
+		"boot_wait":               &b.config.RawBootWait,
+		"shutdown_timeout":        &b.config.RawShutdownTimeout,
+		"ssh_wait_timeout":        &b.config.RawSSHWaitTimeout,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = b.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	// These are processed when they are used, since they use data that
+	// is only known then.
+	if err := b.config.tpl.Validate(b.config.GuestAdditionsPath); err != nil {
+		errs = append(errs, fmt.Errorf("Error parsing guest_additions_path: %s", err))
+	}
+
+	for i, command := range b.config.BootCommand {
+		if err := b.config.tpl.Validate(command); err != nil {
+			errs = append(errs, fmt.Errorf("Error parsing boot_command[%d]: %s", i, err))
+		}
+	}
+
+	for i, args := range b.config.VBoxManage {
+		for j, arg := range args {
+			if err := b.config.tpl.Validate(arg); err != nil {
+				errs = append(errs, fmt.Errorf("Error parsing vboxmanage[%d][%d]: %s", i, j, err))
+			}
+		}
+	}
+
+	errs = append(errs, b.config.SSHConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.WinRMConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.CommunicatorConfig.Prepare(b.config.tpl, "ssh", "winrm", "none")...)
+
 	if b.config.HTTPPortMin > b.config.HTTPPortMax {
 		errs = append(errs, errors.New("http_port_min must be less than http_port_max"))
 	}
 
-	if b.config.ISOMD5 == "" {
-		errs = append(errs, errors.New("Due to large file sizes, an iso_md5 is required"))
-	} else {
+	// iso_md5 is the old way to give an MD5 checksum
+	if b.config.ISOMD5 != "" {
 		b.config.ISOMD5 = strings.ToLower(b.config.ISOMD5)
+		if b.config.ISOChecksum == "" {
+			b.config.ISOChecksum = b.config.ISOMD5
+			b.config.ISOChecksumType = "md5"
+		}
+	}
+
+	if b.config.ISOChecksum == "" {
+		errs = append(errs, errors.New("Due to large file sizes, an iso_checksum is required"))
+	} else {
+		errs = append(errs, common.ValidateChecksum(
+			"iso_checksum", b.config.ISOChecksumType, b.config.ISOChecksum)...)
 	}
 
 	if b.config.ISOUrl == "" {
@@ -147,7 +220,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 					errs = append(errs, fmt.Errorf("iso_url points to bad file: %s", err))
 				}
 			} else {
-				supportedSchemes := []string{"file", "http", "https"}
+				supportedSchemes := []string{"file", "gs", "http", "https", "s3"}
 				scheme := strings.ToLower(url.Scheme)
 
 				found := false
@@ -170,8 +243,10 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		}
 	}
 
-	if _, err := os.Stat(b.config.OutputDir); err == nil {
-		errs = append(errs, errors.New("Output directory already exists. It must not exist."))
+	if _, err := os.Stat(b.config.OutputDir); err == nil && !b.config.PackerForce {
+		errs = append(errs, errors.New(
+			"Output directory already exists. It must not exist, "+
+				"unless the build is forced with -force."))
 	}
 
 	b.config.BootWait, err = time.ParseDuration(b.config.RawBootWait)
@@ -196,10 +271,18 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		errs = append(errs, errors.New("ssh_host_port_min must be less than ssh_host_port_max"))
 	}
 
-	if b.config.SSHUser == "" {
+	if b.config.Communicator == "ssh" && b.config.SSHUser == "" {
 		errs = append(errs, errors.New("An ssh_username must be specified."))
 	}
 
+	if b.config.Communicator == "winrm" && b.config.WinRMUsername == "" {
+		errs = append(errs, errors.New("A winrm_username must be specified."))
+	}
+
+	if b.config.Communicator == "none" && b.config.ShutdownCommand != "" {
+		errs = append(errs, errors.New("shutdown_command can't be used with the none communicator."))
+	}
+
 	b.config.SSHWaitTimeout, err = time.ParseDuration(b.config.RawSSHWaitTimeout)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("Failed parsing ssh_wait_timeout: %s", err))
@@ -218,6 +301,13 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 }
 
 func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
+	// This builder doesn't checkpoint its steps, so a build that didn't
+	// finish can't be picked up again
+	if b.config.PackerResume {
+		ui.Say("WARNING: The VirtualBox builder can't resume builds, so this build\n" +
+			"starts from scratch.")
+	}
+
 	steps := []multistep.Step{
 		new(stepDownloadGuestAdditions),
 		new(stepDownloadISO),
@@ -234,42 +324,36 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		new(stepWaitForSSH),
 		new(stepUploadVersion),
 		new(stepUploadGuestAdditions),
-		new(stepProvision),
+		&common.StepProvision{BuildName: b.config.PackerBuildName},
 		new(stepShutdown),
 		new(stepExport),
 	}
 
 	// Setup the state bag
 	state := make(map[string]interface{})
-	state["cache"] = cache
-	state["config"] = &b.config
-	state["driver"] = b.driver
-	state["hook"] = hook
-	state["ui"] = ui
+	bag := newStepState(state)
+	bag.SetCache(cache)
+	bag.setConfig(&b.config)
+	bag.setDriver(b.driver)
+	bag.SetHook(hook)
+	bag.SetUi(ui)
 
 	// Run
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerDebug, b.config.PackerOnError, ui)
 
 	b.runner.Run(state)
 
 	// If there was an error, return that
-	if rawErr, ok := state["error"]; ok {
-		return nil, rawErr.(error)
+	if err := bag.Error(); err != nil {
+		return nil, err
 	}
 
 	// If we were interrupted or cancelled, then just exit.
-	if _, ok := state[multistep.StateCancelled]; ok {
+	if bag.Cancelled() {
 		return nil, errors.New("Build was cancelled.")
 	}
 
-	if _, ok := state[multistep.StateHalted]; ok {
+	if bag.Halted() {
 		return nil, errors.New("Build was halted.")
 	}
 
@@ -169,6 +169,52 @@ func TestBuilderPrepare_HTTPPort(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_ISOChecksum(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	delete(config, "iso_md5")
+
+	// Test bad
+	config["iso_checksum"] = ""
+	config["iso_checksum_type"] = "sha256"
+	err := b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test unknown type
+	b = Builder{}
+	config["iso_checksum"] = "foo"
+	config["iso_checksum_type"] = "crc32"
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test good
+	b = Builder{}
+	config["iso_checksum"] = "file:SHA256SUMS"
+	config["iso_checksum_type"] = "sha256"
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// iso_md5 is an MD5 checksum
+	b = Builder{}
+	delete(config, "iso_checksum")
+	delete(config, "iso_checksum_type")
+	config["iso_md5"] = "foo"
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if b.config.ISOChecksum != "foo" || b.config.ISOChecksumType != "md5" {
+		t.Fatalf("bad: %s %s", b.config.ISOChecksum, b.config.ISOChecksumType)
+	}
+}
+
 func TestBuilderPrepare_ISOMD5(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -260,6 +306,14 @@ func TestBuilderPrepare_OutputDir(t *testing.T) {
 	if err != nil {
 		t.Fatalf("should not have error: %s", err)
 	}
+
+	// Test with an existing dir when the build is forced
+	config["output_directory"] = dir
+	config["packer_force"] = true
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
 }
 
 func TestBuilderPrepare_ShutdownTimeout(t *testing.T) {
@@ -309,6 +363,25 @@ func TestBuilderPrepare_SSHHostPort(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_CommunicatorNone(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["communicator"] = "none"
+	config["ssh_username"] = ""
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// Can't shut down by running a command
+	b = Builder{}
+	config["shutdown_command"] = "halt"
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestBuilderPrepare_SSHUser(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -324,6 +397,16 @@ func TestBuilderPrepare_SSHUser(t *testing.T) {
 	if err != nil {
 		t.Fatalf("should not have error: %s", err)
 	}
+
+	// Not needed with WinRM
+	b = Builder{}
+	config["ssh_username"] = ""
+	config["communicator"] = "winrm"
+	config["winrm_username"] = "Administrator"
+	err = b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
 }
 
 func TestBuilderPrepare_SSHWaitTimeout(t *testing.T) {
@@ -356,6 +439,39 @@ func TestBuilderPrepare_SSHWaitTimeout(t *testing.T) {
 	}
 }
 
+func TestBuilderPrepare_UserVariables(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["vm_name"] = `{{user "name"}}`
+	config["packer_user_variables"] = map[string]string{"name": "foo"}
+
+	err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if b.config.VMName != "foo" {
+		t.Fatalf("bad vm_name: %s", b.config.VMName)
+	}
+
+	// Test an unknown variable
+	config["vm_name"] = `{{user "bar"}}`
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test a bad boot command
+	config["vm_name"] = "foo"
+	config["boot_command"] = []string{"{{"}
+	b = Builder{}
+	err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestBuilderPrepare_VBoxManage(t *testing.T) {
 	var b Builder
 	config := testConfig()
@@ -0,0 +1,77 @@
+package virtualbox
+
+import "github.com/mitchellh/packer/builder/common"
+
+// The namespace of the values of this builder in the state of its steps.
+const stateNamespace = "virtualbox"
+
+// stepState is the state of the steps of the builder, with typed
+// accessors for the values they share, on top of those of common.StateBag
+// that every builder has.
+type stepState struct {
+	*common.StateBag
+}
+
+func newStepState(state map[string]interface{}) stepState {
+	return stepState{common.NewStateBag(state).Namespace(stateNamespace)}
+}
+
+// config returns the configuration of the builder.
+func (s stepState) config() (result *config) {
+	s.Value("config", &result)
+	return
+}
+
+func (s stepState) setConfig(value *config) {
+	s.Put("config", value)
+}
+
+// driver returns the driver of VirtualBox.
+func (s stepState) driver() (result Driver) {
+	s.Value("driver", &result)
+	return
+}
+
+func (s stepState) setDriver(value Driver) {
+	s.Put("driver", value)
+}
+
+// vmName returns the name of the virtual machine once it is created.
+func (s stepState) vmName() (result string) {
+	s.Value("vmName", &result)
+	return
+}
+
+func (s stepState) setVmName(value string) {
+	s.Put("vmName", value)
+}
+
+// sshHostPort returns the port on the host that is forwarded to SSH on the guest.
+func (s stepState) sshHostPort() (result uint) {
+	s.Value("sshHostPort", &result)
+	return
+}
+
+func (s stepState) setSSHHostPort(value uint) {
+	s.Put("sshHostPort", value)
+}
+
+// guestAdditionsPath returns the path of the downloaded guest additions.
+func (s stepState) guestAdditionsPath() (result string) {
+	s.Value("guest_additions_path", &result)
+	return
+}
+
+func (s stepState) setGuestAdditionsPath(value string) {
+	s.Put("guest_additions_path", value)
+}
+
+// exportPath returns the path of the exported virtual machine.
+func (s stepState) exportPath() (result string) {
+	s.Value("exportPath", &result)
+	return
+}
+
+func (s stepState) setExportPath(value string) {
+	s.Put("exportPath", value)
+}
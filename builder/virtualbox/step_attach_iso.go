@@ -3,7 +3,6 @@ package virtualbox
 import (
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 )
 
 // This step attaches the ISO to the virtual machine.
@@ -16,10 +15,10 @@ type stepAttachISO struct {
 }
 
 func (s *stepAttachISO) Run(state map[string]interface{}) multistep.StepAction {
-	driver := state["driver"].(Driver)
-	isoPath := state["iso_path"].(string)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	driver := bag.driver()
+	isoPath := bag.ISOPath()
+	vmName := bag.vmName()
 
 	// Attach the disk to the controller
 	command := []string{
@@ -32,9 +31,7 @@ func (s *stepAttachISO) Run(state map[string]interface{}) multistep.StepAction {
 	}
 	if err := driver.VBoxManage(command...); err != nil {
 		err := fmt.Errorf("Error attaching ISO: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Track the path so that we can unregister it from VirtualBox later
@@ -48,9 +45,10 @@ func (s *stepAttachISO) Cleanup(state map[string]interface{}) {
 		return
 	}
 
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmName := bag.vmName()
 
 	command := []string{
 		"storageattach", vmName,
@@ -3,7 +3,6 @@ package virtualbox
 import (
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -14,10 +13,11 @@ import (
 type stepCreateDisk struct{}
 
 func (s *stepCreateDisk) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmName := bag.vmName()
 
 	format := "VDI"
 	path := filepath.Join(config.OutputDir, fmt.Sprintf("%s.%s", config.VMName, strings.ToLower(format)))
@@ -34,9 +34,7 @@ func (s *stepCreateDisk) Run(state map[string]interface{}) multistep.StepAction
 	err := driver.VBoxManage(command...)
 	if err != nil {
 		err := fmt.Errorf("Error creating hard drive: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Add the IDE controller so we can later attach the disk
@@ -44,9 +42,7 @@ func (s *stepCreateDisk) Run(state map[string]interface{}) multistep.StepAction
 	err = driver.VBoxManage("storagectl", vmName, "--name", controllerName, "--add", "ide")
 	if err != nil {
 		err := fmt.Errorf("Error creating disk controller: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	// Attach the disk to the controller
@@ -60,9 +56,7 @@ func (s *stepCreateDisk) Run(state map[string]interface{}) multistep.StepAction
 	}
 	if err := driver.VBoxManage(command...); err != nil {
 		err := fmt.Errorf("Error attaching hard drive: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	return multistep.ActionContinue
@@ -11,13 +11,15 @@ import (
 // Produces:
 //   vmName string - The name of the VM
 type stepCreateVM struct {
-	vmName string
+	vmName   string
+	resource *packer.TempResource
 }
 
 func (s *stepCreateVM) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
 
 	name := config.VMName
 
@@ -35,19 +37,25 @@ func (s *stepCreateVM) Run(state map[string]interface{}) multistep.StepAction {
 		err := driver.VBoxManage(command...)
 		if err != nil {
 			err := fmt.Errorf("Error creating VM: %s", err)
-			state["error"] = err
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			return bag.Halt(err)
 		}
 
 		// Set the VM name propery on the first command
 		if s.vmName == "" {
 			s.vmName = name
+			s.resource = &packer.TempResource{
+				Kind:    "virtual machine",
+				ID:      name,
+				Command: []string{"VBoxManage", "unregistervm", name, "--delete"},
+				Hint: fmt.Sprintf(
+					"power it off with 'VBoxManage controlvm %s poweroff' and delete it", name),
+			}
+			packer.TrackTempResource(s.resource)
 		}
 	}
 
 	// Set the final name in the state bag so others can use it
-	state["vmName"] = s.vmName
+	bag.setVmName(s.vmName)
 
 	return multistep.ActionContinue
 }
@@ -57,11 +65,14 @@ func (s *stepCreateVM) Cleanup(state map[string]interface{}) {
 		return
 	}
 
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	driver := bag.driver()
+	ui := bag.Ui()
 
 	ui.Say("Unregistering and deleting virtual machine...")
 	if err := driver.VBoxManage("unregistervm", s.vmName, "--delete"); err != nil {
 		ui.Error(fmt.Sprintf("Error deleting virtual machine: %s", err))
+	} else {
+		packer.UntrackTempResource(s.resource)
 	}
 }
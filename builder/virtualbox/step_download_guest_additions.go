@@ -1,18 +1,13 @@
 package virtualbox
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
 	"github.com/mitchellh/packer/packer"
-	"io"
-	"io/ioutil"
 	"log"
-	"os"
-	"strings"
 	"time"
 )
 
@@ -24,19 +19,26 @@ var additionsVersionMap = map[string]string{
 // This step uploads a file containing the VirtualBox version, which
 // can be useful for various provisioning reasons.
 //
+// Uses:
+//   cache  packer.Cache
+//   config *config
+//   driver Driver
+//   ui     packer.Ui
+//
 // Produces:
 //   guest_additions_path string - Path to the guest additions.
 type stepDownloadGuestAdditions struct{}
 
 func (s *stepDownloadGuestAdditions) Run(state map[string]interface{}) multistep.StepAction {
-	var action multistep.StepAction
-	cache := state["cache"].(packer.Cache)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	cache := bag.Cache()
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
 
 	version, err := driver.Version()
 	if err != nil {
-		state["error"] = fmt.Errorf("Error reading version for guest additions download: %s", err)
+		bag.SetError(fmt.Errorf("Error reading version for guest additions download: %s", err))
 		return multistep.ActionHalt
 	}
 
@@ -45,102 +47,54 @@ func (s *stepDownloadGuestAdditions) Run(state map[string]interface{}) multistep
 		version = newVersion
 	}
 
-	// First things first, we get the list of checksums for the files available
-	// for this version.
-	checksumsUrl := fmt.Sprintf("http://download.virtualbox.org/virtualbox/%s/SHA256SUMS", version)
-	checksumsFile, err := ioutil.TempFile("", "packer")
-	if err != nil {
-		state["error"] = fmt.Errorf(
-			"Failed creating temporary file to store guest addition checksums: %s",
-			err)
-		return multistep.ActionHalt
-	}
-	checksumsFile.Close()
-	defer os.Remove(checksumsFile.Name())
-
-	downloadConfig := &common.DownloadConfig{
-		Url:        checksumsUrl,
-		TargetPath: checksumsFile.Name(),
-		Hash:       nil,
-	}
-
-	log.Printf("Downloading guest addition checksums: %s", checksumsUrl)
-	download := common.NewDownloadClient(downloadConfig)
-	checksumsPath, action := s.progressDownload(download, state)
-	if action != multistep.ActionContinue {
-		return action
-	}
-
 	additionsName := fmt.Sprintf("VBoxGuestAdditions_%s.iso", version)
+	url := fmt.Sprintf(
+		"http://download.virtualbox.org/virtualbox/%s/%s",
+		version, additionsName)
+	log.Printf("Guest additions URL: %s", url)
 
-	// Next, we find the checksum for the file we're looking to download.
-	// It is an error if the checksum cannot be found.
-	checksumsF, err := os.Open(checksumsPath)
-	if err != nil {
-		state["error"] = fmt.Errorf("Error opening guest addition checksums: %s", err)
-		return multistep.ActionHalt
-	}
-	defer checksumsF.Close()
-
-	// We copy the contents of the file into memory. In general this file
-	// is quite small so that is okay. In the future, we probably want to
-	// use bufio and iterate line by line.
-	var contents bytes.Buffer
-	io.Copy(&contents, checksumsF)
-
-	checksum := ""
-	for _, line := range strings.Split(contents.String(), "\n") {
-		parts := strings.Fields(line)
-		log.Printf("Checksum file parts: %#v", parts)
-		if len(parts) != 2 {
-			// Bogus line
-			continue
-		}
-
-		if strings.HasSuffix(parts[1], additionsName) {
-			checksum = parts[0]
-			log.Printf("Guest additions checksum: %s", checksum)
-			break
-		}
-	}
-
-	if checksum == "" {
-		state["error"] = fmt.Errorf("The checksum for the file '%s' could not be found.", additionsName)
-		return multistep.ActionHalt
-	}
-
-	checksumBytes, err := hex.DecodeString(checksum)
+	// The checksum is in the list of checksums of the files available
+	// for this version. It is an error if the checksum cannot be found.
+	log.Printf("Downloading guest addition checksums for: %s", url)
+	checksumBytes, err := common.Checksum("file:SHA256SUMS", url)
 	if err != nil {
-		state["error"] = fmt.Errorf("Couldn't decode checksum into bytes: %s", checksum)
+		bag.SetError(fmt.Errorf("Error reading guest additions checksum: %s", err))
 		return multistep.ActionHalt
 	}
 
-	url := fmt.Sprintf(
-		"http://download.virtualbox.org/virtualbox/%s/%s",
-		version, additionsName)
-	log.Printf("Guest additions URL: %s", url)
+	checksum := hex.EncodeToString(checksumBytes)
+	log.Printf("Guest additions checksum: %s", checksum)
 
 	log.Printf("Acquiring lock to download the guest additions ISO.")
 	cachePath := cache.Lock(url)
 	defer cache.Unlock(url)
 
-	downloadConfig = &common.DownloadConfig{
+	downloadConfig := &common.DownloadConfig{
 		Url:        url,
 		TargetPath: cachePath,
 		Hash:       sha256.New(),
 		Checksum:   checksumBytes,
+		Progress:   packer.UiProgress(ui, "Download"),
+		Resume:     true,
+		Proxy:      config.PackerProxy,
 	}
 
-	download = common.NewDownloadClient(downloadConfig)
+	download := common.NewDownloadClient(downloadConfig)
 	ui.Say("Downloading VirtualBox guest additions. Progress will be shown periodically.")
-	state["guest_additions_path"], action = s.progressDownload(download, state)
-	return action
+	path, action := s.progressDownload(download, state)
+	if action != multistep.ActionContinue {
+		return action
+	}
+
+	bag.setGuestAdditionsPath(path)
+	return multistep.ActionContinue
 }
 
 func (s *stepDownloadGuestAdditions) Cleanup(state map[string]interface{}) {}
 
 func (s *stepDownloadGuestAdditions) progressDownload(c *common.DownloadClient, state map[string]interface{}) (string, multistep.StepAction) {
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	ui := bag.Ui()
 
 	var result string
 	downloadCompleteCh := make(chan error, 1)
@@ -152,25 +106,20 @@ func (s *stepDownloadGuestAdditions) progressDownload(c *common.DownloadClient,
 		downloadCompleteCh <- err
 	}()
 
-	progressTicker := time.NewTicker(5 * time.Second)
-	defer progressTicker.Stop()
-
-	// A loop that handles showing progress as well as timing out and handling
-	// interrupts and all that.
+	// A loop that handles timing out and handling interrupts and all that.
+	// The progress is shown by the download itself.
 DownloadWaitLoop:
 	for {
 		select {
 		case err := <-downloadCompleteCh:
 			if err != nil {
-				state["error"] = fmt.Errorf("Error downloading: %s", err)
+				bag.SetError(fmt.Errorf("Error downloading: %s", err))
 				return "", multistep.ActionHalt
 			}
 
 			break DownloadWaitLoop
-		case <-progressTicker.C:
-			ui.Message(fmt.Sprintf("Download progress: %d%%", c.PercentProgress()))
 		case <-time.After(1 * time.Second):
-			if _, ok := state[multistep.StateCancelled]; ok {
+			if bag.Cancelled() {
 				ui.Say("Interrupt received. Cancelling download...")
 				return "", multistep.ActionHalt
 			}
@@ -1,8 +1,6 @@
 package virtualbox
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
@@ -26,16 +24,24 @@ import (
 //   iso_path string
 type stepDownloadISO struct {
 	isoCopyDir string
+	resource   *packer.TempResource
 }
 
 func (s *stepDownloadISO) Run(state map[string]interface{}) multistep.StepAction {
-	cache := state["cache"].(packer.Cache)
-	config := state["config"].(*config)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	cache := bag.Cache()
+	config := bag.config()
+	ui := bag.Ui()
 
-	checksum, err := hex.DecodeString(config.ISOMD5)
+	checksum, err := common.Checksum(config.ISOChecksum, config.ISOUrl)
 	if err != nil {
-		state["error"] = fmt.Errorf("Error parsing checksum: %s", err)
+		bag.SetError(fmt.Errorf("Error reading checksum: %s", err))
+		return multistep.ActionHalt
+	}
+
+	hash, err := common.ChecksumHash(config.ISOChecksumType)
+	if err != nil {
+		bag.SetError(fmt.Errorf("Error reading checksum type: %s", err))
 		return multistep.ActionHalt
 	}
 
@@ -47,38 +53,34 @@ func (s *stepDownloadISO) Run(state map[string]interface{}) multistep.StepAction
 		Url:        config.ISOUrl,
 		TargetPath: cachePath,
 		CopyFile:   false,
-		Hash:       md5.New(),
+		Hash:       hash,
 		Checksum:   checksum,
+		Progress:   packer.UiProgress(ui, "Download"),
+		Resume:     true,
+		Proxy:      config.PackerProxy,
 	}
 
 	download := common.NewDownloadClient(downloadConfig)
 
 	downloadCompleteCh := make(chan error, 1)
 	go func() {
-		ui.Say("Copying or downloading ISO. Progress will be reported periodically.")
+		ui.Say("Copying or downloading ISO. Progress will be shown periodically.")
 		cachePath, err = download.Get()
 		downloadCompleteCh <- err
 	}()
 
-	progressTicker := time.NewTicker(5 * time.Second)
-	defer progressTicker.Stop()
-
 DownloadWaitLoop:
 	for {
 		select {
 		case err := <-downloadCompleteCh:
 			if err != nil {
 				err := fmt.Errorf("Error downloading ISO: %s", err)
-				state["error"] = err
-				ui.Error(err.Error())
-				return multistep.ActionHalt
+				return bag.Halt(err)
 			}
 
 			break DownloadWaitLoop
-		case <-progressTicker.C:
-			ui.Message(fmt.Sprintf("Download progress: %d%%", download.PercentProgress()))
 		case <-time.After(1 * time.Second):
-			if _, ok := state[multistep.StateCancelled]; ok {
+			if bag.Cancelled() {
 				ui.Say("Interrupt received. Cancelling download...")
 				return multistep.ActionHalt
 			}
@@ -90,33 +92,39 @@ DownloadWaitLoop:
 	// filenames so we just do a copy.
 	tempdir, err := ioutil.TempDir("", "packer")
 	if err != nil {
-		state["error"] = fmt.Errorf("Error copying ISO: %s", err)
+		bag.SetError(fmt.Errorf("Error copying ISO: %s", err))
 		return multistep.ActionHalt
 	}
 	s.isoCopyDir = tempdir
+	s.resource = &packer.TempResource{
+		Kind: "directory",
+		ID:   tempdir,
+		Path: tempdir,
+	}
+	packer.TrackTempResource(s.resource)
 
 	f, err := os.Create(filepath.Join(tempdir, "image.iso"))
 	if err != nil {
-		state["error"] = fmt.Errorf("Error copying ISO: %s", err)
+		bag.SetError(fmt.Errorf("Error copying ISO: %s", err))
 		return multistep.ActionHalt
 	}
 	defer f.Close()
 
 	sourceF, err := os.Open(cachePath)
 	if err != nil {
-		state["error"] = fmt.Errorf("Error copying ISO: %s", err)
+		bag.SetError(fmt.Errorf("Error copying ISO: %s", err))
 		return multistep.ActionHalt
 	}
 	defer sourceF.Close()
 
 	log.Printf("Copying ISO to temp location: %s", tempdir)
 	if _, err := io.Copy(f, sourceF); err != nil {
-		state["error"] = fmt.Errorf("Error copying ISO: %s", err)
+		bag.SetError(fmt.Errorf("Error copying ISO: %s", err))
 		return multistep.ActionHalt
 	}
 
 	log.Printf("Path to ISO on disk: %s", cachePath)
-	state["iso_path"] = f.Name()
+	bag.SetISOPath(f.Name())
 
 	return multistep.ActionContinue
 }
@@ -124,5 +132,6 @@ DownloadWaitLoop:
 func (s *stepDownloadISO) Cleanup(map[string]interface{}) {
 	if s.isoCopyDir != "" {
 		os.RemoveAll(s.isoCopyDir)
+		packer.UntrackTempResource(s.resource)
 	}
 }
@@ -3,7 +3,6 @@ package virtualbox
 import (
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"path/filepath"
 )
 
@@ -17,10 +16,11 @@ import (
 type stepExport struct{}
 
 func (s *stepExport) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmName := bag.vmName()
 
 	outputPath := filepath.Join(config.OutputDir, "packer.ovf")
 
@@ -35,12 +35,10 @@ func (s *stepExport) Run(state map[string]interface{}) multistep.StepAction {
 	err := driver.VBoxManage(command...)
 	if err != nil {
 		err := fmt.Errorf("Error exporting virtual machine: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
-	state["exportPath"] = outputPath
+	bag.setExportPath(outputPath)
 
 	return multistep.ActionContinue
 }
@@ -3,14 +3,13 @@ package virtualbox
 import (
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"log"
 	"math/rand"
 	"net"
 )
 
-// This step adds a NAT port forwarding definition so that SSH is available
-// on the guest machine.
+// This step adds a NAT port forwarding definition so that SSH, or WinRM if
+// that is the communicator, is available on the guest machine.
 //
 // Uses:
 //
@@ -18,10 +17,11 @@ import (
 type stepForwardSSH struct{}
 
 func (s *stepForwardSSH) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmName := bag.vmName()
 
 	log.Printf("Looking for available SSH port between %d and %d", config.SSHHostPortMin, config.SSHHostPortMax)
 	var sshHostPort uint
@@ -36,22 +36,30 @@ func (s *stepForwardSSH) Run(state map[string]interface{}) multistep.StepAction
 		}
 	}
 
+	name, guestPort := "SSH", config.SSHPort
+	if config.Communicator == "winrm" {
+		name, guestPort = "WinRM", uint(config.WinRMPort)
+	}
+
 	// Attach the disk to the controller
-	ui.Say(fmt.Sprintf("Creating forwarded port mapping for SSH (host port %d)", sshHostPort))
+	ui.Say(fmt.Sprintf("Creating forwarded port mapping for %s (host port %d)", name, sshHostPort))
 	command := []string{
 		"modifyvm", vmName,
 		"--natpf1",
-		fmt.Sprintf("packerssh,tcp,127.0.0.1,%d,,%d", sshHostPort, config.SSHPort),
+		fmt.Sprintf("packerssh,tcp,127.0.0.1,%d,,%d", sshHostPort, guestPort),
 	}
 	if err := driver.VBoxManage(command...); err != nil {
 		err := fmt.Errorf("Error creating port forwarding rule: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
-	// Save the port we're using so that future steps can use it
-	state["sshHostPort"] = sshHostPort
+	// Save the port we're using so that future steps can use it. It is
+	// the WinRM port if WinRM is being used.
+	bag.setSSHHostPort(sshHostPort)
+
+	if config.PackerDebug {
+		ui.Message(fmt.Sprintf("%s address: 127.0.0.1:%d", name, sshHostPort))
+	}
 
 	return multistep.ActionContinue
 }
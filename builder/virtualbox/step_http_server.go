@@ -3,7 +3,6 @@ package virtualbox
 import (
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"log"
 	"math/rand"
 	"net"
@@ -24,12 +23,13 @@ type stepHTTPServer struct {
 }
 
 func (s *stepHTTPServer) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
 
 	var httpPort uint = 0
 	if config.HTTPDir == "" {
-		state["http_port"] = httpPort
+		bag.SetHTTPPort(httpPort)
 		return multistep.ActionContinue
 	}
 
@@ -62,7 +62,7 @@ func (s *stepHTTPServer) Run(state map[string]interface{}) multistep.StepAction
 	go server.Serve(s.l)
 
 	// Save the address into the state so it can be accessed in the future
-	state["http_port"] = httpPort
+	bag.SetHTTPPort(httpPort)
 
 	return multistep.ActionContinue
 }
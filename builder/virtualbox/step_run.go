@@ -17,10 +17,11 @@ type stepRun struct {
 }
 
 func (s *stepRun) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmName := bag.vmName()
 
 	ui.Say("Starting the virtual machine...")
 	guiArgument := "gui"
@@ -33,13 +34,21 @@ func (s *stepRun) Run(state map[string]interface{}) multistep.StepAction {
 	command := []string{"startvm", vmName, "--type", guiArgument}
 	if err := driver.VBoxManage(command...); err != nil {
 		err := fmt.Errorf("Error starting VM: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
 	s.vmName = vmName
 
+	hook := bag.Hook()
+	hookData := map[string]string{
+		"build_name": config.PackerBuildName,
+		"id":         vmName,
+	}
+
+	if err := hook.Run(packer.HookInstanceLaunched, ui, nil, hookData); err != nil {
+		return bag.Halt(err)
+	}
+
 	if int64(config.BootWait) > 0 {
 		ui.Say(fmt.Sprintf("Waiting %s for boot...", config.BootWait))
 		time.Sleep(config.BootWait)
@@ -53,8 +62,9 @@ func (s *stepRun) Cleanup(state map[string]interface{}) {
 		return
 	}
 
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	driver := bag.driver()
+	ui := bag.Ui()
 
 	if running, _ := driver.IsRunning(s.vmName); running {
 		if err := driver.VBoxManage("controlvm", s.vmName, "poweroff"); err != nil {
@@ -1,12 +1,11 @@
 package virtualbox
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
+	"log"
 	"os"
-	"text/template"
 )
 
 type guestAdditionsPathTemplate struct {
@@ -17,21 +16,34 @@ type guestAdditionsPathTemplate struct {
 type stepUploadGuestAdditions struct{}
 
 func (s *stepUploadGuestAdditions) Run(state map[string]interface{}) multistep.StepAction {
-	comm := state["communicator"].(packer.Communicator)
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	guestAdditionsPath := state["guest_additions_path"].(string)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	comm := bag.Communicator()
+	config := bag.config()
+	driver := bag.driver()
+	guestAdditionsPath := bag.guestAdditionsPath()
+	ui := bag.Ui()
+
+	if config.Communicator == "none" {
+		log.Println("Communicator is none. Not uploading guest additions.")
+		return multistep.ActionContinue
+	}
 
 	version, err := driver.Version()
 	if err != nil {
-		state["error"] = fmt.Errorf("Error reading version for guest additions upload: %s", err)
+		bag.SetError(fmt.Errorf("Error reading version for guest additions upload: %s", err))
 		return multistep.ActionHalt
 	}
 
 	f, err := os.Open(guestAdditionsPath)
 	if err != nil {
-		state["error"] = fmt.Errorf("Error opening guest additions ISO: %s", err)
+		bag.SetError(fmt.Errorf("Error opening guest additions ISO: %s", err))
+		return multistep.ActionHalt
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		bag.SetError(fmt.Errorf("Error reading guest additions ISO: %s", err))
 		return multistep.ActionHalt
 	}
 
@@ -39,13 +51,21 @@ func (s *stepUploadGuestAdditions) Run(state map[string]interface{}) multistep.S
 		Version: version,
 	}
 
-	var processedPath bytes.Buffer
-	t := template.Must(template.New("path").Parse(config.GuestAdditionsPath))
-	t.Execute(&processedPath, tplData)
+	processedPath, err := config.tpl.Process(config.GuestAdditionsPath, tplData)
+	if err != nil {
+		bag.SetError(fmt.Errorf("Error preparing guest additions path: %s", err))
+		return multistep.ActionHalt
+	}
 
 	ui.Say("Uploading VirtualBox guest additions ISO...")
-	if err := comm.Upload(processedPath.String(), f); err != nil {
-		state["error"] = fmt.Errorf("Error uploading guest additions: %s", err)
+	input := &packer.ProgressReader{
+		Reader:   f,
+		Total:    fi.Size(),
+		Progress: packer.UiProgress(ui, "Upload"),
+	}
+
+	if err := comm.Upload(processedPath, input, &fi); err != nil {
+		bag.SetError(fmt.Errorf("Error uploading guest additions: %s", err))
 		return multistep.ActionHalt
 	}
 
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"log"
 )
 
@@ -13,27 +12,33 @@ import (
 type stepUploadVersion struct{}
 
 func (s *stepUploadVersion) Run(state map[string]interface{}) multistep.StepAction {
-	comm := state["communicator"].(packer.Communicator)
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	comm := bag.Communicator()
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
 
 	if config.VBoxVersionFile == "" {
 		log.Println("VBoxVersionFile is empty. Not uploading.")
 		return multistep.ActionContinue
 	}
 
+	if config.Communicator == "none" {
+		log.Println("Communicator is none. Not uploading VirtualBox version.")
+		return multistep.ActionContinue
+	}
+
 	version, err := driver.Version()
 	if err != nil {
-		state["error"] = fmt.Errorf("Error reading version for metadata upload: %s", err)
+		bag.SetError(fmt.Errorf("Error reading version for metadata upload: %s", err))
 		return multistep.ActionHalt
 	}
 
 	ui.Say(fmt.Sprintf("Uploading VirtualBox version info (%s)", version))
 	var data bytes.Buffer
 	data.WriteString(version)
-	if err := comm.Upload(config.VBoxVersionFile, &data); err != nil {
-		state["error"] = fmt.Errorf("Error uploading VirtualBox version: %s", err)
+	if err := comm.Upload(config.VBoxVersionFile, &data, nil); err != nil {
+		bag.SetError(fmt.Errorf("Error uploading VirtualBox version: %s", err))
 		return multistep.ActionHalt
 	}
 
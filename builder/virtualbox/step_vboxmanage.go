@@ -1,12 +1,9 @@
 package virtualbox
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"strings"
-	"text/template"
 )
 
 type commandTemplate struct {
@@ -22,10 +19,11 @@ type commandTemplate struct {
 type stepVBoxManage struct{}
 
 func (s *stepVBoxManage) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmName := state["vmName"].(string)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmName := bag.vmName()
 
 	if len(config.VBoxManage) > 0 {
 		ui.Say("Executing custom VBoxManage commands...")
@@ -40,18 +38,18 @@ func (s *stepVBoxManage) Run(state map[string]interface{}) multistep.StepAction
 		copy(command, originalCommand)
 
 		for i, arg := range command {
-			var buf bytes.Buffer
-			t := template.Must(template.New("arg").Parse(arg))
-			t.Execute(&buf, tplData)
-			command[i] = buf.String()
+			var err error
+			command[i], err = config.tpl.Process(arg, tplData)
+			if err != nil {
+				err := fmt.Errorf("Error preparing vboxmanage command: %s", err)
+				return bag.Halt(err)
+			}
 		}
 
 		ui.Message(fmt.Sprintf("Executing: %s", strings.Join(command, " ")))
 		if err := driver.VBoxManage(command...); err != nil {
 			err := fmt.Errorf("Error executing command: %s", err)
-			state["error"] = err
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			return bag.Halt(err)
 		}
 	}
 
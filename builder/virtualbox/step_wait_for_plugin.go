@@ -0,0 +1,43 @@
+package virtualbox
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"strconv"
+	"time"
+)
+
+// This blocks until the communicator plugin connects through the
+// forwarded port, and returns the communicator.
+func (s *stepWaitForSSH) waitForPlugin(state map[string]interface{}) (packer.Communicator, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+	hostPort := bag.sshHostPort()
+
+	info := map[string]string{
+		"host": "127.0.0.1",
+		"port": strconv.Itoa(int(hostPort)),
+	}
+
+	ui.Say(fmt.Sprintf("Waiting for %s to become available...", config.Communicator))
+	for {
+		time.Sleep(5 * time.Second)
+
+		if s.cancel {
+			log.Printf("%s wait cancelled. Exiting loop.", config.Communicator)
+			return nil, errors.New("Communicator wait cancelled")
+		}
+
+		comm, err := s.factory.New(info)
+		if err != nil {
+			log.Printf("%s connection failed: %s", config.Communicator, err)
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Connected via %s!", config.Communicator))
+		return comm, nil
+	}
+}
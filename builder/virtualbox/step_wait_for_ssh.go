@@ -5,15 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/none"
 	"github.com/mitchellh/packer/communicator/ssh"
 	"github.com/mitchellh/packer/packer"
+	"github.com/mitchellh/packer/packer/plugin"
+	"io"
 	"log"
 	"net"
 	"time"
 )
 
 // This step waits for SSH to become available and establishes an SSH
-// connection.
+// connection, or does the same with WinRM or a communicator plugin if that
+// is the communicator.
 //
 // Uses:
 //   config *config
@@ -23,46 +27,69 @@ import (
 // Produces:
 //   communicator packer.Communicator
 type stepWaitForSSH struct {
-	cancel bool
-	conn   net.Conn
+	agent   io.Closer
+	cancel  bool
+	conn    net.Conn
+	factory packer.CommunicatorFactory
+	plugin  *plugin.Client
 }
 
 func (s *stepWaitForSSH) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+
+	if config.Communicator == "none" {
+		ui.Say("Communicator is none, not waiting for the machine to connect.")
+		bag.SetCommunicator(none.New())
+		return multistep.ActionContinue
+	}
 
 	var comm packer.Communicator
 	var err error
 
+	name, wait := "SSH", s.waitForSSH
+	if config.Communicator == "winrm" {
+		name, wait = "WinRM", s.waitForWinRM
+	} else if config.Communicator != "ssh" {
+		s.factory, s.plugin, err = config.CommunicatorConfig.StartCommunicatorPlugin()
+		if err != nil {
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+
+		name, wait = config.Communicator, s.waitForPlugin
+	}
+
 	waitDone := make(chan bool, 1)
 	go func() {
-		comm, err = s.waitForSSH(state)
+		comm, err = wait(state)
 		waitDone <- true
 	}()
 
-	log.Printf("Waiting for SSH, up to timeout: %s", config.SSHWaitTimeout.String())
+	log.Printf("Waiting for %s, up to timeout: %s", name, config.SSHWaitTimeout.String())
 
 	timeout := time.After(config.SSHWaitTimeout)
 WaitLoop:
 	for {
-		// Wait for either SSH to become available, a timeout to occur,
-		// or an interrupt to come through.
+		// Wait for either the machine to become available, a timeout to
+		// occur, or an interrupt to come through.
 		select {
 		case <-waitDone:
 			if err != nil {
-				ui.Error(fmt.Sprintf("Error waiting for SSH: %s", err))
+				ui.Error(fmt.Sprintf("Error waiting for %s: %s", name, err))
 				return multistep.ActionHalt
 			}
 
-			state["communicator"] = comm
+			bag.SetCommunicator(comm)
 			break WaitLoop
 		case <-timeout:
-			ui.Error("Timeout waiting for SSH.")
+			ui.Error(fmt.Sprintf("Timeout waiting for %s.", name))
 			s.cancel = true
 			return multistep.ActionHalt
 		case <-time.After(1 * time.Second):
-			if _, ok := state[multistep.StateCancelled]; ok {
-				log.Println("Interrupt detected, quitting waiting for SSH.")
+			if bag.Cancelled() {
+				log.Printf("Interrupt detected, quitting waiting for %s.", name)
 				return multistep.ActionHalt
 			}
 		}
@@ -76,14 +103,39 @@ func (s *stepWaitForSSH) Cleanup(map[string]interface{}) {
 		s.conn.Close()
 		s.conn = nil
 	}
+
+	if s.agent != nil {
+		s.agent.Close()
+		s.agent = nil
+	}
+
+	if s.plugin != nil {
+		s.plugin.Kill()
+		s.plugin = nil
+	}
 }
 
 // This blocks until SSH becomes available, and sends the communicator
 // on the given channel.
 func (s *stepWaitForSSH) waitForSSH(state map[string]interface{}) (packer.Communicator, error) {
-	config := state["config"].(*config)
-	ui := state["ui"].(packer.Ui)
-	sshHostPort := state["sshHostPort"].(uint)
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+	sshHostPort := bag.sshHostPort()
+
+	address := fmt.Sprintf("127.0.0.1:%d", sshHostPort)
+	connFunc := config.SSHConfig.Connection(address)
+
+	// The configured authentication methods, such as a password or
+	// the SSH agent.
+	auth, agent, err := config.SSHConfig.ClientAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	// The communicator uses the agent to reconnect, so the connection to
+	// it is closed along with the communicator's.
+	s.agent = agent
 
 	ui.Say("Waiting for SSH to become available...")
 	var comm packer.Communicator
@@ -101,7 +153,7 @@ func (s *stepWaitForSSH) waitForSSH(state map[string]interface{}) (packer.Commun
 		}
 
 		// Attempt to connect to SSH port
-		nc, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", sshHostPort))
+		nc, err := connFunc()
 		if err != nil {
 			log.Printf("TCP connection to SSH ip/port failed: %s", err)
 			continue
@@ -110,16 +162,12 @@ func (s *stepWaitForSSH) waitForSSH(state map[string]interface{}) (packer.Commun
 		// Then we attempt to connect via SSH
 		sshConfig := &gossh.ClientConfig{
 			User: config.SSHUser,
-			Auth: []gossh.ClientAuth{
-				gossh.ClientAuthPassword(ssh.Password(config.SSHPassword)),
-				gossh.ClientAuthKeyboardInteractive(
-					ssh.PasswordKeyboardInteractive(config.SSHPassword)),
-			},
+			Auth: auth,
 		}
 
 		sshConnectSuccess := make(chan bool, 1)
 		go func() {
-			comm, err = ssh.New(nc, sshConfig)
+			comm, err = ssh.New(nc, config.SSHConfig.CommConfig(address, sshConfig))
 			if err != nil {
 				log.Printf("SSH connection fail: %s", err)
 				sshConnectSuccess <- false
@@ -145,5 +193,5 @@ func (s *stepWaitForSSH) waitForSSH(state map[string]interface{}) (packer.Commun
 
 	// Store the connection so we can close it later
 	s.conn = nc
-	return comm, nil
+	return config.SSHConfig.Retry(comm), nil
 }
@@ -0,0 +1,39 @@
+package virtualbox
+
+import (
+	"errors"
+	"github.com/mitchellh/packer/communicator/winrm"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"time"
+)
+
+// This blocks until WinRM becomes available through the forwarded port,
+// and returns the communicator.
+func (s *stepWaitForSSH) waitForWinRM(state map[string]interface{}) (packer.Communicator, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+	hostPort := bag.sshHostPort()
+
+	commConfig := config.WinRMConfig.CommConfig("127.0.0.1", int(hostPort))
+
+	ui.Say("Waiting for WinRM to become available...")
+	for {
+		time.Sleep(5 * time.Second)
+
+		if s.cancel {
+			log.Println("WinRM wait cancelled. Exiting loop.")
+			return nil, errors.New("WinRM wait cancelled")
+		}
+
+		comm, err := winrm.New(commConfig)
+		if err != nil {
+			log.Printf("WinRM connection failed: %s", err)
+			continue
+		}
+
+		ui.Say("Connected via WinRM!")
+		return comm, nil
+	}
+}
@@ -3,7 +3,6 @@ package vmware
 import (
 	"errors"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
 	"github.com/mitchellh/packer/packer"
@@ -13,7 +12,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 	"time"
 )
 
@@ -29,6 +27,8 @@ type config struct {
 	DiskName          string            `mapstructure:"vmdk_name"`
 	DiskSize          uint              `mapstructure:"disk_size"`
 	GuestOSType       string            `mapstructure:"guest_os_type"`
+	ISOChecksum       string            `mapstructure:"iso_checksum"`
+	ISOChecksumType   string            `mapstructure:"iso_checksum_type"`
 	ISOMD5            string            `mapstructure:"iso_md5"`
 	ISOUrl            string            `mapstructure:"iso_url"`
 	VMName            string            `mapstructure:"vm_name"`
@@ -43,7 +43,6 @@ type config struct {
 	ShutdownCommand   string            `mapstructure:"shutdown_command"`
 	ShutdownTimeout   time.Duration     ``
 	SSHUser           string            `mapstructure:"ssh_username"`
-	SSHPassword       string            `mapstructure:"ssh_password"`
 	SSHPort           uint              `mapstructure:"ssh_port"`
 	SSHWaitTimeout    time.Duration     ``
 	ToolsUploadFlavor string            `mapstructure:"tools_upload_flavor"`
@@ -52,20 +51,34 @@ type config struct {
 	VNCPortMin        uint              `mapstructure:"vnc_port_min"`
 	VNCPortMax        uint              `mapstructure:"vnc_port_max"`
 
-	PackerBuildName string `mapstructure:"packer_build_name"`
-	PackerDebug     bool   `mapstructure:"packer_debug"`
+	common.SSHConfig          `mapstructure:",squash"`
+	common.WinRMConfig        `mapstructure:",squash"`
+	common.CommunicatorConfig `mapstructure:",squash"`
+
+	PackerBuildName string              `mapstructure:"packer_build_name"`
+	PackerDebug     bool                `mapstructure:"packer_debug"`
+	PackerForce     bool                `mapstructure:"packer_force"`
+	PackerOnError   string              `mapstructure:"packer_on_error"`
+	PackerResume    bool                `mapstructure:"packer_resume"`
+	PackerBuildVars map[string]string   `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string   `mapstructure:"packer_user_variables"`
+	PackerProxy     *packer.ProxyConfig `mapstructure:"packer_proxy"`
 
 	RawBootWait        string `mapstructure:"boot_wait"`
 	RawShutdownTimeout string `mapstructure:"shutdown_timeout"`
 	RawSSHWaitTimeout  string `mapstructure:"ssh_wait_timeout"`
+
+	tpl *packer.ConfigTemplate
 }
 
 func (b *Builder) Prepare(raws ...interface{}) error {
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &b.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&b.config, raws...); err != nil {
+		return err
+	}
+
+	b.config.tpl = &packer.ConfigTemplate{
+		UserVars:  b.config.PackerUserVars,
+		BuildVars: b.config.PackerBuildVars,
 	}
 
 	if b.config.DiskName == "" {
@@ -120,14 +133,68 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 	var err error
 	errs := make([]error, 0)
 
+	templates := map[string]*string{
+		"vmdk_name":           &b.config.DiskName,
+		"guest_os_type":       &b.config.GuestOSType,
+		"iso_checksum":        &b.config.ISOChecksum,
+		"iso_checksum_type":   &b.config.ISOChecksumType,
+		"iso_md5":             &b.config.ISOMD5,
+		"iso_url":             &b.config.ISOUrl,
+		"vm_name":             &b.config.VMName,
+		"output_directory":    &b.config.OutputDir,
+		"http_directory":      &b.config.HTTPDir,
+		"shutdown_command":    &b.config.ShutdownCommand,
+		"ssh_username":        &b.config.SSHUser,
+		"tools_upload_flavor": &b.config.ToolsUploadFlavor,
+		"boot_wait":           &b.config.RawBootWait,
+		"shutdown_timeout":    &b.config.RawShutdownTimeout,
+		"ssh_wait_timeout":    &b.config.RawSSHWaitTimeout,
+	}
+
+	for n, ptr := range templates {
+		*ptr, err = b.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	for k, v := range b.config.VMXData {
+		b.config.VMXData[k], err = b.config.tpl.Process(v, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing vmx_data[%s]: %s", k, err))
+		}
+	}
+
+	// The boot command is processed when it is typed, since it uses data
+	// that is only known then.
+	for i, command := range b.config.BootCommand {
+		if err := b.config.tpl.Validate(command); err != nil {
+			errs = append(errs, fmt.Errorf("Error parsing boot_command[%d]: %s", i, err))
+		}
+	}
+
+	errs = append(errs, b.config.SSHConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.WinRMConfig.Prepare(b.config.tpl)...)
+	errs = append(errs, b.config.CommunicatorConfig.Prepare(b.config.tpl, "ssh", "winrm", "none")...)
+
 	if b.config.HTTPPortMin > b.config.HTTPPortMax {
 		errs = append(errs, errors.New("http_port_min must be less than http_port_max"))
 	}
 
-	if b.config.ISOMD5 == "" {
-		errs = append(errs, errors.New("Due to large file sizes, an iso_md5 is required"))
-	} else {
+	// iso_md5 is the old way to give an MD5 checksum
+	if b.config.ISOMD5 != "" {
 		b.config.ISOMD5 = strings.ToLower(b.config.ISOMD5)
+		if b.config.ISOChecksum == "" {
+			b.config.ISOChecksum = b.config.ISOMD5
+			b.config.ISOChecksumType = "md5"
+		}
+	}
+
+	if b.config.ISOChecksum == "" {
+		errs = append(errs, errors.New("Due to large file sizes, an iso_checksum is required"))
+	} else {
+		errs = append(errs, common.ValidateChecksum(
+			"iso_checksum", b.config.ISOChecksumType, b.config.ISOChecksum)...)
 	}
 
 	if b.config.ISOUrl == "" {
@@ -146,7 +213,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 					errs = append(errs, fmt.Errorf("iso_url points to bad file: %s", err))
 				}
 			} else {
-				supportedSchemes := []string{"file", "http", "https"}
+				supportedSchemes := []string{"file", "gs", "http", "https", "s3"}
 				scheme := strings.ToLower(url.Scheme)
 
 				found := false
@@ -169,14 +236,28 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		}
 	}
 
-	if _, err := os.Stat(b.config.OutputDir); err == nil {
-		errs = append(errs, errors.New("Output directory already exists. It must not exist."))
+	if _, err := os.Stat(b.config.OutputDir); err == nil && !b.config.PackerForce {
+		errs = append(errs, errors.New(
+			"Output directory already exists. It must not exist, "+
+				"unless the build is forced with -force."))
 	}
 
-	if b.config.SSHUser == "" {
+	if b.config.Communicator == "ssh" && b.config.SSHUser == "" {
 		errs = append(errs, errors.New("An ssh_username must be specified."))
 	}
 
+	if b.config.Communicator == "winrm" && b.config.WinRMUsername == "" {
+		errs = append(errs, errors.New("A winrm_username must be specified."))
+	}
+
+	if b.config.Communicator == "none" && b.config.ShutdownCommand != "" {
+		errs = append(errs, errors.New("shutdown_command can't be used with the none communicator."))
+	}
+
+	if b.config.Communicator == "none" && b.config.ToolsUploadFlavor != "" {
+		errs = append(errs, errors.New("tools_upload_flavor can't be used with the none communicator."))
+	}
+
 	if b.config.RawBootWait != "" {
 		b.config.BootWait, err = time.ParseDuration(b.config.RawBootWait)
 		if err != nil {
@@ -202,7 +283,7 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 		errs = append(errs, fmt.Errorf("Failed parsing ssh_wait_timeout: %s", err))
 	}
 
-	if _, err := template.New("path").Parse(b.config.ToolsUploadPath); err != nil {
+	if err := b.config.tpl.Validate(b.config.ToolsUploadPath); err != nil {
 		errs = append(errs, fmt.Errorf("tools_upload_path invalid: %s", err))
 	}
 
@@ -223,6 +304,13 @@ func (b *Builder) Prepare(raws ...interface{}) error {
 }
 
 func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
+	// This builder doesn't checkpoint its steps, so a build that didn't
+	// finish can't be picked up again
+	if b.config.PackerResume {
+		ui.Say("WARNING: The VMware builder can't resume builds, so this build\n" +
+			"starts from scratch.")
+	}
+
 	// Seed the random number generator
 	rand.Seed(time.Now().UTC().UnixNano())
 
@@ -238,7 +326,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		&stepTypeBootCommand{},
 		&stepWaitForSSH{},
 		&stepUploadTools{},
-		&stepProvision{},
+		&common.StepProvision{BuildName: b.config.PackerBuildName},
 		&stepShutdown{},
 		&stepCleanFiles{},
 		&stepCompactDisk{},
@@ -246,35 +334,29 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	// Setup the state bag
 	state := make(map[string]interface{})
-	state["cache"] = cache
-	state["config"] = &b.config
-	state["driver"] = b.driver
-	state["hook"] = hook
-	state["ui"] = ui
+	bag := newStepState(state)
+	bag.SetCache(cache)
+	bag.setConfig(&b.config)
+	bag.setDriver(b.driver)
+	bag.SetHook(hook)
+	bag.SetUi(ui)
 
 	// Run!
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerDebug, b.config.PackerOnError, ui)
 
 	b.runner.Run(state)
 
 	// If there was an error, return that
-	if rawErr, ok := state["error"]; ok {
-		return nil, rawErr.(error)
+	if err := bag.Error(); err != nil {
+		return nil, err
 	}
 
 	// If we were interrupted or cancelled, then just exit.
-	if _, ok := state[multistep.StateCancelled]; ok {
+	if bag.Cancelled() {
 		return nil, errors.New("Build was cancelled.")
 	}
 
-	if _, ok := state[multistep.StateHalted]; ok {
+	if bag.Halted() {
 		return nil, errors.New("Build was halted.")
 	}
 
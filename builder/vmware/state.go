@@ -0,0 +1,77 @@
+package vmware
+
+import "github.com/mitchellh/packer/builder/common"
+
+// The namespace of the values of this builder in the state of its steps.
+const stateNamespace = "vmware"
+
+// stepState is the state of the steps of the builder, with typed
+// accessors for the values they share, on top of those of common.StateBag
+// that every builder has.
+type stepState struct {
+	*common.StateBag
+}
+
+func newStepState(state map[string]interface{}) stepState {
+	return stepState{common.NewStateBag(state).Namespace(stateNamespace)}
+}
+
+// config returns the configuration of the builder.
+func (s stepState) config() (result *config) {
+	s.Value("config", &result)
+	return
+}
+
+func (s stepState) setConfig(value *config) {
+	s.Put("config", value)
+}
+
+// driver returns the driver of VMware.
+func (s stepState) driver() (result Driver) {
+	s.Value("driver", &result)
+	return
+}
+
+func (s stepState) setDriver(value Driver) {
+	s.Put("driver", value)
+}
+
+// fullDiskPath returns the path of the disk of the virtual machine.
+func (s stepState) fullDiskPath() (result string) {
+	s.Value("full_disk_path", &result)
+	return
+}
+
+func (s stepState) setFullDiskPath(value string) {
+	s.Put("full_disk_path", value)
+}
+
+// vmxPath returns the path of the VMX file of the virtual machine.
+func (s stepState) vmxPath() (result string) {
+	s.Value("vmx_path", &result)
+	return
+}
+
+func (s stepState) setVMXPath(value string) {
+	s.Put("vmx_path", value)
+}
+
+// vncPort returns the port that VNC on the virtual machine listens on.
+func (s stepState) vncPort() (result uint) {
+	s.Value("vnc_port", &result)
+	return
+}
+
+func (s stepState) setVNCPort(value uint) {
+	s.Put("vnc_port", value)
+}
+
+// toolsUploadSource returns the path of the VMware Tools ISO that is uploaded.
+func (s stepState) toolsUploadSource() (result string) {
+	s.Value("tools_upload_source", &result)
+	return
+}
+
+func (s stepState) setToolsUploadSource(value string) {
+	s.Put("tools_upload_source", value)
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"log"
 	"path/filepath"
 	"text/template"
@@ -29,9 +28,10 @@ type vmxTemplateData struct {
 type stepCreateVMX struct{}
 
 func (stepCreateVMX) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	isoPath := state["iso_path"].(string)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	config := bag.config()
+	isoPath := bag.ISOPath()
+	ui := bag.Ui()
 
 	ui.Say("Building and writing VMX file")
 
@@ -58,12 +58,10 @@ func (stepCreateVMX) Run(state map[string]interface{}) multistep.StepAction {
 	vmxPath := filepath.Join(config.OutputDir, config.VMName+".vmx")
 	if err := WriteVMX(vmxPath, vmxData); err != nil {
 		err := fmt.Errorf("Error creating VMX file: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
 	}
 
-	state["vmx_path"] = vmxPath
+	bag.setVMXPath(vmxPath)
 
 	return multistep.ActionContinue
 }
@@ -1,8 +1,6 @@
 package vmware
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/builder/common"
@@ -23,16 +21,21 @@ import (
 type stepDownloadISO struct{}
 
 func (s stepDownloadISO) Run(state map[string]interface{}) multistep.StepAction {
-	cache := state["cache"].(packer.Cache)
-	config := state["config"].(*config)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	cache := bag.Cache()
+	config := bag.config()
+	ui := bag.Ui()
 
-	checksum, err := hex.DecodeString(config.ISOMD5)
+	checksum, err := common.Checksum(config.ISOChecksum, config.ISOUrl)
 	if err != nil {
-		err := fmt.Errorf("Error parsing checksum: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		err := fmt.Errorf("Error reading checksum: %s", err)
+		return bag.Halt(err)
+	}
+
+	hash, err := common.ChecksumHash(config.ISOChecksumType)
+	if err != nil {
+		err := fmt.Errorf("Error reading checksum type: %s", err)
+		return bag.Halt(err)
 	}
 
 	log.Printf("Acquiring lock to download the ISO.")
@@ -43,38 +46,34 @@ func (s stepDownloadISO) Run(state map[string]interface{}) multistep.StepAction
 		Url:        config.ISOUrl,
 		TargetPath: cachePath,
 		CopyFile:   false,
-		Hash:       md5.New(),
+		Hash:       hash,
 		Checksum:   checksum,
+		Progress:   packer.UiProgress(ui, "Download"),
+		Resume:     true,
+		Proxy:      config.PackerProxy,
 	}
 
 	download := common.NewDownloadClient(downloadConfig)
 
 	downloadCompleteCh := make(chan error, 1)
 	go func() {
-		ui.Say("Copying or downloading ISO. Progress will be reported periodically.")
+		ui.Say("Copying or downloading ISO. Progress will be shown periodically.")
 		cachePath, err = download.Get()
 		downloadCompleteCh <- err
 	}()
 
-	progressTicker := time.NewTicker(5 * time.Second)
-	defer progressTicker.Stop()
-
 DownloadWaitLoop:
 	for {
 		select {
 		case err := <-downloadCompleteCh:
 			if err != nil {
 				err := fmt.Errorf("Error downloading ISO: %s", err)
-				state["error"] = err
-				ui.Error(err.Error())
-				return multistep.ActionHalt
+				return bag.Halt(err)
 			}
 
 			break DownloadWaitLoop
-		case <-progressTicker.C:
-			ui.Say(fmt.Sprintf("Download progress: %d%%", download.PercentProgress()))
 		case <-time.After(1 * time.Second):
-			if _, ok := state[multistep.StateCancelled]; ok {
+			if bag.Cancelled() {
 				ui.Say("Interrupt received. Cancelling download...")
 				return multistep.ActionHalt
 			}
@@ -82,7 +81,7 @@ DownloadWaitLoop:
 	}
 
 	log.Printf("Path to ISO on disk: %s", cachePath)
-	state["iso_path"] = cachePath
+	bag.SetISOPath(cachePath)
 
 	return multistep.ActionContinue
 }
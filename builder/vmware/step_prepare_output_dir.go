@@ -2,17 +2,26 @@ package vmware
 
 import (
 	"github.com/mitchellh/multistep"
-	"github.com/mitchellh/packer/packer"
 	"os"
 )
 
 type stepPrepareOutputDir struct{}
 
 func (stepPrepareOutputDir) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+
+	if _, err := os.Stat(config.OutputDir); err == nil && config.PackerForce {
+		ui.Say("Deleting previous output directory...")
+		if err := os.RemoveAll(config.OutputDir); err != nil {
+			bag.SetError(err)
+			return multistep.ActionHalt
+		}
+	}
 
 	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
-		state["error"] = err
+		bag.SetError(err)
 		return multistep.ActionHalt
 	}
 
@@ -20,12 +29,13 @@ func (stepPrepareOutputDir) Run(state map[string]interface{}) multistep.StepActi
 }
 
 func (stepPrepareOutputDir) Cleanup(state map[string]interface{}) {
-	_, cancelled := state[multistep.StateCancelled]
-	_, halted := state[multistep.StateHalted]
+	bag := newStepState(state)
+	cancelled := bag.Cancelled()
+	halted := bag.Halted()
 
 	if cancelled || halted {
-		config := state["config"].(*config)
-		ui := state["ui"].(packer.Ui)
+		config := bag.config()
+		ui := bag.Ui()
 
 		ui.Say("Deleting output directory...")
 		os.RemoveAll(config.OutputDir)
@@ -12,6 +12,7 @@ import (
 // Uses:
 //   config *config
 //   driver Driver
+//   hook   packer.Hook
 //   ui     packer.Ui
 //   vmx_path string
 //
@@ -20,14 +21,16 @@ import (
 type stepRun struct {
 	bootTime time.Time
 	vmxPath  string
+	resource *packer.TempResource
 }
 
 func (s *stepRun) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmxPath := state["vmx_path"].(string)
-	vncPort := state["vnc_port"].(uint)
+	bag := newStepState(state)
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmxPath := bag.vmxPath()
+	vncPort := bag.vncPort()
 
 	// Set the VMX path so that we know we started the machine
 	s.bootTime = time.Now()
@@ -43,9 +46,26 @@ func (s *stepRun) Run(state map[string]interface{}) multistep.StepAction {
 
 	if err := driver.Start(vmxPath, config.Headless); err != nil {
 		err := fmt.Errorf("Error starting VM: %s", err)
-		state["error"] = err
-		ui.Error(err.Error())
-		return multistep.ActionHalt
+		return bag.Halt(err)
+	}
+
+	// Only the driver knows how to stop the machine, so if the build can't,
+	// the user is told that it is still running.
+	s.resource = &packer.TempResource{
+		Kind: "running virtual machine",
+		ID:   vmxPath,
+		Hint: "stop it with 'vmrun stop' and delete its files",
+	}
+	packer.TrackTempResource(s.resource)
+
+	hook := bag.Hook()
+	hookData := map[string]string{
+		"build_name": config.PackerBuildName,
+		"id":         config.VMName,
+	}
+
+	if err := hook.Run(packer.HookInstanceLaunched, ui, nil, hookData); err != nil {
+		return bag.Halt(err)
 	}
 
 	// Wait the wait amount
@@ -58,8 +78,9 @@ func (s *stepRun) Run(state map[string]interface{}) multistep.StepAction {
 }
 
 func (s *stepRun) Cleanup(state map[string]interface{}) {
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
+	bag := newStepState(state)
+	driver := bag.driver()
+	ui := bag.Ui()
 
 	// If we started the machine... stop it.
 	if s.vmxPath != "" {
@@ -78,7 +99,12 @@ func (s *stepRun) Cleanup(state map[string]interface{}) {
 			ui.Say("Stopping virtual machine...")
 			if err := driver.Stop(s.vmxPath); err != nil {
 				ui.Error(fmt.Sprintf("Error stopping VM: %s", err))
+				return
 			}
 		}
 	}
+
+	if s.resource != nil {
+		packer.UntrackTempResource(s.resource)
+	}
 }
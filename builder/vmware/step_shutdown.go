@@ -26,11 +26,12 @@ import (
 type stepShutdown struct{}
 
 func (s *stepShutdown) Run(state map[string]interface{}) multistep.StepAction {
-	comm := state["communicator"].(packer.Communicator)
-	config := state["config"].(*config)
-	driver := state["driver"].(Driver)
-	ui := state["ui"].(packer.Ui)
-	vmxPath := state["vmx_path"].(string)
+	bag := newStepState(state)
+	comm := bag.Communicator()
+	config := bag.config()
+	driver := bag.driver()
+	ui := bag.Ui()
+	vmxPath := bag.vmxPath()
 
 	if config.ShutdownCommand != "" {
 		ui.Say("Gracefully halting virtual machine...")
@@ -38,9 +39,7 @@ func (s *stepShutdown) Run(state map[string]interface{}) multistep.StepAction {
 		cmd := &packer.RemoteCmd{Command: config.ShutdownCommand}
 		if err := comm.Start(cmd); err != nil {
 			err := fmt.Errorf("Failed to send shutdown command: %s", err)
-			state["error"] = err
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			return bag.Halt(err)
 		}
 
 		// Wait for the command to run
@@ -58,9 +57,7 @@ func (s *stepShutdown) Run(state map[string]interface{}) multistep.StepAction {
 			select {
 			case <-shutdownTimer:
 				err := errors.New("Timeout while waiting for machine to shut down.")
-				state["error"] = err
-				ui.Error(err.Error())
-				return multistep.ActionHalt
+				return bag.Halt(err)
 			default:
 				time.Sleep(1 * time.Second)
 			}
@@ -68,9 +65,7 @@ func (s *stepShutdown) Run(state map[string]interface{}) multistep.StepAction {
 	} else {
 		if err := driver.Stop(vmxPath); err != nil {
 			err := fmt.Errorf("Error stopping VM: %s", err)
-			state["error"] = err
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			return bag.Halt(err)
 		}
 	}
 
@@ -1,12 +1,10 @@
 package vmware
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/packer"
 	"os"
-	"text/template"
 )
 
 type toolsUploadPathTemplate struct {
@@ -16,30 +14,45 @@ type toolsUploadPathTemplate struct {
 type stepUploadTools struct{}
 
 func (*stepUploadTools) Run(state map[string]interface{}) multistep.StepAction {
-	config := state["config"].(*config)
+	bag := newStepState(state)
+	config := bag.config()
 	if config.ToolsUploadFlavor == "" {
 		return multistep.ActionContinue
 	}
 
-	comm := state["communicator"].(packer.Communicator)
-	tools_source := state["tools_upload_source"].(string)
-	ui := state["ui"].(packer.Ui)
+	comm := bag.Communicator()
+	tools_source := bag.toolsUploadSource()
+	ui := bag.Ui()
 
 	ui.Say(fmt.Sprintf("Uploading the '%s' VMware Tools", config.ToolsUploadFlavor))
 	f, err := os.Open(tools_source)
 	if err != nil {
-		state["error"] = fmt.Errorf("Error opening VMware Tools ISO: %s", err)
+		bag.SetError(fmt.Errorf("Error opening VMware Tools ISO: %s", err))
 		return multistep.ActionHalt
 	}
 	defer f.Close()
 
+	fi, err := f.Stat()
+	if err != nil {
+		bag.SetError(fmt.Errorf("Error reading VMware Tools ISO: %s", err))
+		return multistep.ActionHalt
+	}
+
 	tplData := &toolsUploadPathTemplate{Flavor: config.ToolsUploadFlavor}
-	var processedPath bytes.Buffer
-	t := template.Must(template.New("path").Parse(config.ToolsUploadPath))
-	t.Execute(&processedPath, tplData)
+	processedPath, err := config.tpl.Process(config.ToolsUploadPath, tplData)
+	if err != nil {
+		bag.SetError(fmt.Errorf("Error preparing VMware Tools upload path: %s", err))
+		return multistep.ActionHalt
+	}
+
+	input := &packer.ProgressReader{
+		Reader:   f,
+		Total:    fi.Size(),
+		Progress: packer.UiProgress(ui, "Upload"),
+	}
 
-	if err := comm.Upload(processedPath.String(), f); err != nil {
-		state["error"] = fmt.Errorf("Error uploading VMware Tools: %s", err)
+	if err := comm.Upload(processedPath, input, &fi); err != nil {
+		bag.SetError(fmt.Errorf("Error uploading VMware Tools: %s", err))
 		return multistep.ActionHalt
 	}
 
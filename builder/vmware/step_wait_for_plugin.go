@@ -0,0 +1,59 @@
+package vmware
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"strconv"
+	"time"
+)
+
+// This blocks until the communicator plugin connects to the IP address of
+// the guest, and returns the communicator.
+func (s *stepWaitForSSH) waitForPlugin(state map[string]interface{}) (packer.Communicator, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+	vmxPath := bag.vmxPath()
+
+	ui.Say(fmt.Sprintf("Waiting for %s to become available...", config.Communicator))
+	for {
+		time.Sleep(5 * time.Second)
+
+		if s.cancel {
+			log.Printf("%s wait cancelled. Exiting loop.", config.Communicator)
+			return nil, errors.New("Communicator wait cancelled")
+		}
+
+		// First we wait for the IP to become available...
+		log.Println("Lookup up IP information...")
+		ipLookup, err := s.dhcpLeaseLookup(vmxPath)
+		if err != nil {
+			log.Printf("Can't lookup via DHCP lease: %s", err)
+			continue
+		}
+
+		ip, err := ipLookup.GuestIP()
+		if err != nil {
+			log.Printf("IP lookup failed: %s", err)
+			continue
+		}
+
+		log.Printf("Detected IP: %s", ip)
+
+		info := map[string]string{
+			"host": ip,
+			"port": strconv.Itoa(int(config.SSHPort)),
+		}
+
+		comm, err := s.factory.New(info)
+		if err != nil {
+			log.Printf("%s connection failed: %s", config.Communicator, err)
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Connected via %s!", config.Communicator))
+		return comm, nil
+	}
+}
@@ -0,0 +1,53 @@
+package vmware
+
+import (
+	"errors"
+	"github.com/mitchellh/packer/communicator/winrm"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"time"
+)
+
+// This blocks until WinRM becomes available on the IP address of the
+// guest, and returns the communicator.
+func (s *stepWaitForSSH) waitForWinRM(state map[string]interface{}) (packer.Communicator, error) {
+	bag := newStepState(state)
+	config := bag.config()
+	ui := bag.Ui()
+	vmxPath := bag.vmxPath()
+
+	ui.Say("Waiting for WinRM to become available...")
+	for {
+		time.Sleep(5 * time.Second)
+
+		if s.cancel {
+			log.Println("WinRM wait cancelled. Exiting loop.")
+			return nil, errors.New("WinRM wait cancelled")
+		}
+
+		// First we wait for the IP to become available...
+		log.Println("Lookup up IP information...")
+		ipLookup, err := s.dhcpLeaseLookup(vmxPath)
+		if err != nil {
+			log.Printf("Can't lookup via DHCP lease: %s", err)
+			continue
+		}
+
+		ip, err := ipLookup.GuestIP()
+		if err != nil {
+			log.Printf("IP lookup failed: %s", err)
+			continue
+		}
+
+		log.Printf("Detected IP: %s", ip)
+
+		comm, err := winrm.New(config.WinRMConfig.CommConfig(ip, config.WinRMPort))
+		if err != nil {
+			log.Printf("WinRM connection failed: %s", err)
+			continue
+		}
+
+		ui.Say("Connected via WinRM!")
+		return comm, nil
+	}
+}
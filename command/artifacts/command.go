@@ -0,0 +1,115 @@
+package artifacts
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"os"
+	"strings"
+	"time"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	if len(args) == 0 {
+		env.Ui().Say(c.Help())
+		return 1
+	}
+
+	path := os.Getenv(packer.ArtifactRegistryEnvVar)
+	if path == "" {
+		env.Ui().Error("The artifact registry of Packer couldn't be found")
+		return 1
+	}
+
+	registry := &packer.ArtifactRegistry{Path: path}
+	switch args[0] {
+	case "list":
+		return c.list(env, registry, args[1:])
+	default:
+		env.Ui().Error(fmt.Sprintf("Unknown subcommand: %s\n", args[0]))
+		env.Ui().Say(c.Help())
+		return 1
+	}
+}
+
+func (c Command) list(env packer.Environment, registry *packer.ArtifactRegistry, args []string) int {
+	var cfgBuild string
+
+	cmdFlags := flag.NewFlagSet("artifacts list", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.StringVar(&cfgBuild, "build", "", "only list the artifacts of this build")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if len(cmdFlags.Args()) != 0 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	artifacts, err := registry.Artifacts()
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Error listing artifacts: %s", err))
+		return 1
+	}
+
+	if cfgBuild != "" {
+		matching := make([]*packer.RegisteredArtifact, 0, len(artifacts))
+		for _, a := range artifacts {
+			if a.Build == cfgBuild {
+				matching = append(matching, a)
+			}
+		}
+
+		artifacts = matching
+	}
+
+	if len(artifacts) == 0 {
+		env.Ui().Say(fmt.Sprintf("No artifacts are recorded in %s", registry.Path))
+		return 0
+	}
+
+	for i, a := range artifacts {
+		if i > 0 {
+			env.Ui().Say("")
+		}
+
+		id := a.Id
+		if id == "" {
+			id = "<no id>"
+		}
+
+		env.Ui().Say(fmt.Sprintf("%s: %s", a.Build, id))
+		env.Ui().Say(fmt.Sprintf("  Builder:  %s (%s)", a.BuilderType, a.BuilderId))
+		env.Ui().Say(fmt.Sprintf("  Template: %s (%s)", a.Template, shortHash(a.TemplateHash)))
+		env.Ui().Say(fmt.Sprintf("  Built:    %s, in %s",
+			a.FinishTime.Local().Format(time.RFC1123),
+			a.FinishTime.Sub(a.StartTime)/time.Second*time.Second))
+
+		for _, file := range a.Files {
+			env.Ui().Say(fmt.Sprintf("  File:     %s", file))
+		}
+	}
+
+	return 0
+}
+
+// shortHash shortens the checksum of a template for the list, which is
+// enough to tell templates apart.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+
+	return hash
+}
+
+func (Command) Synopsis() string {
+	return "list the artifacts of earlier builds"
+}
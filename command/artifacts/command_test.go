@@ -0,0 +1,117 @@
+package artifacts
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_List(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "artifacts.json")
+	defer os.Setenv(packer.ArtifactRegistryEnvVar, os.Getenv(packer.ArtifactRegistryEnvVar))
+	os.Setenv(packer.ArtifactRegistryEnvVar, path)
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"list"}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "No artifacts are recorded") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	start := time.Date(2013, 7, 1, 12, 0, 0, 0, time.UTC)
+	registry := &packer.ArtifactRegistry{Path: path}
+	err = registry.Add(
+		&packer.RegisteredArtifact{
+			Build:        "vmware",
+			BuilderType:  "vmware",
+			BuilderId:    "mitchellh.vmware",
+			Files:        []string{"output-vmware/disk.vmdk"},
+			Template:     "/templates/ubuntu.json",
+			TemplateHash: "2b8c2f0e91f3a8b1c4d5",
+			StartTime:    start,
+			FinishTime:   start.Add(90 * time.Second),
+		},
+		&packer.RegisteredArtifact{
+			Build:       "amazon-ebs",
+			BuilderType: "amazon-ebs",
+			Id:          "us-east-1:ami-1234",
+			StartTime:   start,
+			FinishTime:  start.Add(time.Minute),
+		})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{"list"}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	for _, expected := range []string{
+		"vmware: <no id>\n",
+		"Template: /templates/ubuntu.json (2b8c2f0e91f3)\n",
+		"in 1m30s\n",
+		"File:     output-vmware/disk.vmdk\n",
+		"amazon-ebs: us-east-1:ami-1234\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("bad: %s", out.String())
+		}
+	}
+
+	out.Reset()
+	args := []string{"list", "-build", "amazon-ebs"}
+	if code := new(Command).Run(testEnvironment(out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if strings.Contains(out.String(), "vmware") || !strings.Contains(out.String(), "ami-1234") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestCommand_UnknownSubcommand(t *testing.T) {
+	defer os.Setenv(packer.ArtifactRegistryEnvVar, os.Getenv(packer.ArtifactRegistryEnvVar))
+	os.Setenv(packer.ArtifactRegistryEnvVar, "artifacts.json")
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"destroy"}); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+}
@@ -0,0 +1,16 @@
+package artifacts
+
+const helpString = `
+Usage: packer artifacts SUBCOMMAND [options]
+
+  Shows the artifacts that builds produced, which Packer records in its
+  artifact registry when builds succeed.
+
+Subcommands:
+
+  list [options]    Lists the recorded artifacts, the most recent last.
+
+List options:
+
+  -build=name       Only list the artifacts of the builds with the name.
+`
@@ -4,13 +4,30 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"github.com/mitchellh/packer/command/common"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+)
+
+// The exit codes of the build command, once the builds ran. Errors that
+// happen before any build runs, such as an invalid template, exit with 1
+// as well.
+const (
+	// Every build succeeded.
+	exitCodeSuccess = 0
+
+	// Every build failed, or Packer was interrupted.
+	exitCodeFailure = 1
+
+	// Some builds succeeded and others failed.
+	exitCodePartialFailure = 2
 )
 
 type Command byte
@@ -21,14 +38,34 @@ func (Command) Help() string {
 
 func (c Command) Run(env packer.Environment, args []string) int {
 	var cfgDebug bool
+	var cfgDryRun bool
+	var cfgForce bool
+	var cfgManifest string
+	var cfgNoColor bool
+	var cfgOnError string
+	var cfgParallelBuilds int
+	var cfgResume bool
+	var cfgTimeout time.Duration
+	var cfgTiming string
 	var cfgExcept []string
 	var cfgOnly []string
+	var cfgVars common.UserVarFlags
 
 	cmdFlags := flag.NewFlagSet("build", flag.ContinueOnError)
 	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
 	cmdFlags.BoolVar(&cfgDebug, "debug", false, "debug mode for builds")
+	cmdFlags.BoolVar(&cfgDryRun, "dry-run", false, "prepare the builds without running them")
+	cmdFlags.BoolVar(&cfgNoColor, "no-color", false, "don't color the output of builds")
 	cmdFlags.Var((*stringSliceValue)(&cfgExcept), "except", "build all builds except these")
+	cmdFlags.BoolVar(&cfgForce, "force", false, "replace the artifacts of earlier builds")
+	cmdFlags.StringVar(&cfgManifest, "manifest", "", "write a JSON manifest of the builds to a file")
 	cmdFlags.Var((*stringSliceValue)(&cfgOnly), "only", "only build the given builds by name")
+	cmdFlags.StringVar(&cfgOnError, "on-error", "", "what to do when a step of a build fails")
+	cmdFlags.IntVar(&cfgParallelBuilds, "parallel-builds", 0, "number of builds to run at once")
+	cmdFlags.BoolVar(&cfgResume, "resume", false, "resume builds that didn't finish")
+	cmdFlags.DurationVar(&cfgTimeout, "timeout", 0, "cancel builds that run for longer than this")
+	cmdFlags.StringVar(&cfgTiming, "timing", "", "write a JSON report of how long the parts of builds took")
+	cfgVars.AddFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -45,8 +82,27 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		return 1
 	}
 
+	if cfgOnError != "" && !packer.ValidOnError(cfgOnError) {
+		env.Ui().Error(fmt.Sprintf(
+			"'-on-error' must be one of: %s\n", strings.Join(packer.OnErrorValues, ", ")))
+		env.Ui().Error(c.Help())
+		return 1
+	}
+
+	if cfgParallelBuilds < 0 {
+		env.Ui().Error("'-parallel-builds' can't be negative.\n")
+		env.Ui().Error(c.Help())
+		return 1
+	}
+
+	if cfgTimeout < 0 {
+		env.Ui().Error("'-timeout' can't be negative.\n")
+		env.Ui().Error(c.Help())
+		return 1
+	}
+
 	// Read the file into a byte array so that we can parse the template
-	log.Printf("Reading template: %s", args[0])
+	log.Printf("[INFO] Reading template: %s", args[0])
 	tplData, err := ioutil.ReadFile(args[0])
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
@@ -54,56 +110,67 @@ func (c Command) Run(env packer.Environment, args []string) int {
 	}
 
 	// Parse the template into a machine-usable format
-	log.Println("Parsing template...")
-	tpl, err := packer.ParseTemplate(tplData)
+	log.Println("[DEBUG] Parsing template...")
+	tpl, err := packer.ParseTemplateFile(args[0], tplData)
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
 		return 1
 	}
 
+	// Dubious parts of the template are only warned about
+	common.ShowLintWarnings(env.Ui(), common.LintTemplate(args[0], tplData, tpl))
+
+	// Set the user variables given on the command line
+	if err := cfgVars.SetVariables(tpl); err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	// Ask for the required variables that still have no value
+	if err := common.AskMissingVariables(env.Ui(), tpl); err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	// The values of sensitive variables are masked in everything that
+	// is shown or logged from now on.
+	packer.SetSensitiveValues(tpl.SensitiveValues())
+
 	// The component finder for our builds
 	components := &packer.ComponentFinder{
 		Builder:       env.Builder,
 		Hook:          env.Hook,
 		PostProcessor: env.PostProcessor,
 		Provisioner:   env.Provisioner,
+		Communicators: env.Communicators(),
+	}
+
+	// Every name given to -except and -only must be a build or a type of
+	// builder, so that a typo doesn't silently build everything.
+	for _, name := range append(cfgExcept, cfgOnly...) {
+		if !buildMatches(tpl, "", []string{name}) {
+			env.Ui().Error(fmt.Sprintf(
+				"No build or builder type named '%s' is in the template.", name))
+			return 1
+		}
 	}
 
 	// Go through each builder and compile the builds that we care about
 	buildNames := tpl.BuildNames()
+	sort.Strings(buildNames)
 	builds := make([]packer.Build, 0, len(buildNames))
 	for _, buildName := range buildNames {
-		if len(cfgExcept) > 0 {
-			found := false
-			for _, only := range cfgExcept {
-				if buildName == only {
-					found = true
-					break
-				}
-			}
-
-			if found {
-				log.Printf("Skipping build '%s' because specified by -except.", buildName)
-				continue
-			}
+		if len(cfgExcept) > 0 && buildMatches(tpl, buildName, cfgExcept) {
+			log.Printf("[DEBUG] Skipping build '%s' because specified by -except.", buildName)
+			continue
 		}
 
-		if len(cfgOnly) > 0 {
-			found := false
-			for _, only := range cfgOnly {
-				if buildName == only {
-					found = true
-					break
-				}
-			}
-
-			if !found {
-				log.Printf("Skipping build '%s' because not specified by -only.", buildName)
-				continue
-			}
+		if len(cfgOnly) > 0 && !buildMatches(tpl, buildName, cfgOnly) {
+			log.Printf("[DEBUG] Skipping build '%s' because not specified by -only.", buildName)
+			continue
 		}
 
-		log.Printf("Creating build: %s", buildName)
+		log.Printf("[INFO] Creating build: %s", buildName)
 		build, err := tpl.Build(buildName, components)
 		if err != nil {
 			env.Ui().Error(fmt.Sprintf("Failed to create build '%s': \n\n%s", buildName, err))
@@ -126,8 +193,31 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		packer.UiColorBlue,
 	}
 
+	// When the output is machine-readable, everything from a build is
+	// targeted to it instead of colored. Terminals that can't show colors
+	// call themselves dumb.
+	machineReadable := os.Getenv(packer.MachineReadableEnvVar) != ""
+	color := !cfgNoColor && !machineReadable && os.Getenv("TERM") != "dumb" &&
+		os.Getenv(packer.NoColorEnvVar) == ""
+
 	buildUis := make(map[string]packer.Ui)
 	for i, b := range builds {
+		if machineReadable {
+			buildUis[b.Name()] = &packer.TargetedUi{
+				Target: b.Name(),
+				Ui:     env.Ui(),
+			}
+
+			continue
+		}
+
+		// Everything from a build is prefixed with its name, so the
+		// output can be told apart without colors.
+		if !color {
+			buildUis[b.Name()] = env.Ui()
+			continue
+		}
+
 		ui := &packer.ColoredUi{
 			Color: colors[i%len(colors)],
 			Ui:    env.Ui(),
@@ -137,15 +227,35 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		ui.Say(fmt.Sprintf("%s output will be in this color.", b.Name()))
 	}
 
+	// The timings that the parts of each build report are kept for the
+	// summary at the end.
+	timingUis := make(map[string]*timingUi)
+	for name, ui := range buildUis {
+		timingUis[name] = &timingUi{Ui: ui}
+		buildUis[name] = timingUis[name]
+	}
+
 	// Add a newline between the color output and the actual output
-	env.Ui().Say("")
+	if color {
+		env.Ui().Say("")
+	}
 
-	log.Printf("Build debug mode: %v", cfgDebug)
+	log.Printf("[DEBUG] Build debug mode: %v", cfgDebug)
 
 	// Set the debug mode and prepare all the builds
 	for _, b := range builds {
-		log.Printf("Preparing build: %s", b.Name())
+		log.Printf("[INFO] Preparing build: %s", b.Name())
 		b.SetDebug(cfgDebug)
+		b.SetForce(cfgForce)
+		b.SetResume(cfgResume)
+		if cfgOnError != "" {
+			b.SetOnError(cfgOnError)
+		}
+
+		if cfgTimeout > 0 {
+			b.SetTimeout(cfgTimeout)
+		}
+
 		err := b.Prepare()
 		if err != nil {
 			env.Ui().Error(err.Error())
@@ -153,70 +263,129 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		}
 	}
 
+	// A dry run stops once the builds are prepared, which is when their
+	// configurations are known to be valid, before anything is launched.
+	if cfgDryRun {
+		names := make([]string, len(builds))
+		for i, b := range builds {
+			names[i] = b.Name()
+		}
+
+		printPlan(env.Ui(), tpl, names, cfgOnError, cfgTimeout)
+		return 0
+	}
+
+	// The artifacts of the builds are recorded, so that forced builds
+	// destroy the artifacts of their earlier runs before they start.
+	registry := artifactRegistry()
+	registryTpl, err := newRegistryTemplate(args[0], tplData)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to find template file: %s", err))
+		return 1
+	}
+
+	if cfgForce && registry != nil {
+		names := make([]string, len(builds))
+		for i, b := range builds {
+			names[i] = b.Name()
+		}
+
+		if err := destroyEarlierArtifacts(env.Ui(), registry, registryTpl, names); err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to destroy the artifacts of earlier builds: %s", err))
+			return 1
+		}
+	}
+
 	// Run all the builds in parallel and wait for them to complete
-	var interruptWg, wg sync.WaitGroup
-	interrupted := false
+	var wg sync.WaitGroup
+	interrupts := newInterruptHandler(env.Ui())
 	artifacts := make(map[string][]packer.Artifact)
 	errors := make(map[string]error)
+	times := make(map[string]buildTime)
+	var resultsL sync.Mutex
+
+	// Each build takes a slot while it runs, so that no more than the
+	// given number of builds run at once. Zero means there is no limit.
+	var slots chan struct{}
+	if cfgParallelBuilds > 0 {
+		slots = make(chan struct{}, cfgParallelBuilds)
+	}
+
 	for _, b := range builds {
+		if slots != nil {
+			log.Printf("[DEBUG] Waiting for a slot to run build: %s", b.Name())
+			slots <- struct{}{}
+		}
+
+		if !interrupts.Start(b) {
+			log.Println("[WARN] Interrupted, not going to start any more builds.")
+			break
+		}
+
 		// Increment the waitgroup so we wait for this item to finish properly
 		wg.Add(1)
 
-		// Handle interrupts for this build
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, os.Interrupt)
-		defer signal.Stop(sigCh)
-		go func(b packer.Build) {
-			<-sigCh
-			interruptWg.Add(1)
-			defer interruptWg.Done()
-			interrupted = true
-
-			log.Printf("Stopping build: %s", b.Name())
-			b.Cancel()
-			log.Printf("Build cancelled: %s", b.Name())
-		}(b)
-
 		// Run the build in a goroutine
 		go func(b packer.Build) {
 			defer wg.Done()
+			defer interrupts.Finish(b)
+			if slots != nil {
+				defer func() { <-slots }()
+			}
 
 			name := b.Name()
-			log.Printf("Starting build run: %s", name)
+			log.Printf("[INFO] Starting build run: %s", name)
 			ui := buildUis[name]
+			ui.Machine("build-start")
+			start := time.Now()
 			runArtifacts, err := b.Run(ui, env.Cache())
 
+			// Builders stop without an error when they are cancelled,
+			// but the build didn't finish.
+			if err == nil && len(runArtifacts) == 0 && interrupts.Cancelled(name) {
+				err = fmt.Errorf("Build was cancelled.")
+			}
+
+			resultsL.Lock()
+			defer resultsL.Unlock()
+			times[name] = buildTime{start, time.Since(start)}
 			if err != nil {
 				ui.Error(fmt.Sprintf("Build '%s' errored: %s", name, err))
+				ui.Machine("error", err.Error())
 				errors[name] = err
 			} else {
 				ui.Say(fmt.Sprintf("Build '%s' finished.", name))
 				artifacts[name] = runArtifacts
 			}
+
+			ui.Machine("build-finish")
 		}(b)
 
 		if cfgDebug {
-			log.Printf("Debug enabled, so waiting for build to finish: %s", b.Name())
+			log.Printf("[DEBUG] Debug enabled, so waiting for build to finish: %s", b.Name())
 			wg.Wait()
 		}
-
-		if interrupted {
-			log.Println("Interrupted, not going to start any more builds.")
-			break
-		}
 	}
 
 	// Wait for both the builds to complete and the interrupt handler,
 	// if it is interrupted.
-	log.Printf("Waiting on builds to complete...")
+	log.Printf("[DEBUG] Waiting on builds to complete...")
 	wg.Wait()
 
-	log.Printf("Builds completed. Waiting on interrupt barrier...")
-	interruptWg.Wait()
+	log.Printf("[DEBUG] Builds completed. Waiting on interrupt barrier...")
+	interrupts.Stop()
 
+	// The builds that finished before the interrupt are still reported
+	// below, so their artifacts aren't forgotten.
+	interrupted := interrupts.Interrupted()
 	if interrupted {
 		env.Ui().Say("Cleanly cancelled builds after being interrupted.")
-		return 1
+	}
+
+	env.Ui().Machine("error-count", strconv.Itoa(len(errors)))
+	for _, b := range builds {
+		name := b.Name()
+		buildUis[name].Machine("build-status", buildStatus(name, artifacts, errors, interrupts))
 	}
 
 	if len(errors) > 0 {
@@ -229,7 +398,11 @@ func (c Command) Run(env packer.Environment, args []string) int {
 	if len(artifacts) > 0 {
 		env.Ui().Say("\n==> Builds finished. The artifacts of successful builds are:")
 		for name, buildArtifacts := range artifacts {
-			for _, artifact := range buildArtifacts {
+			ui := buildUis[name]
+			ui.Machine("artifact-count", strconv.Itoa(len(buildArtifacts)))
+			for i, artifact := range buildArtifacts {
+				machineArtifact(ui, i, artifact)
+
 				var message bytes.Buffer
 				fmt.Fprintf(&message, "--> %s: ", name)
 
@@ -246,7 +419,115 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		env.Ui().Say("\n==> Builds finished but no artifacts were created.")
 	}
 
-	return 0
+	timings := make(map[string][]packer.Timing)
+	for name, ui := range timingUis {
+		timings[name] = ui.Timings()
+	}
+
+	showTimings(env.Ui(), buildNames, times, timings)
+
+	if registry != nil {
+		if err := registerArtifacts(registry, tpl, registryTpl, artifacts, times); err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to record the artifacts: %s", err))
+		}
+	}
+
+	if cfgManifest != "" {
+		if err := writeManifest(cfgManifest, tpl, buildNames, artifacts, errors, times); err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to write manifest: %s", err))
+			return 1
+		}
+
+		env.Ui().Say(fmt.Sprintf("\n==> Wrote the manifest of the builds to: %s", cfgManifest))
+	}
+
+	if cfgTiming != "" {
+		if err := writeTimingReport(cfgTiming, buildNames, times, timings); err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to write timing report: %s", err))
+			return 1
+		}
+
+		env.Ui().Say(fmt.Sprintf("\n==> Wrote the timings of the builds to: %s", cfgTiming))
+	}
+
+	return exitCode(len(builds), len(errors), interrupted)
+}
+
+// buildStatus returns the status of the build once the builds ran, which
+// is "succeeded", "failed", "cancelled", or "skipped" if the build never
+// started because Packer was interrupted.
+func buildStatus(name string, artifacts map[string][]packer.Artifact,
+	errors map[string]error, interrupts *interruptHandler) string {
+	if _, ok := artifacts[name]; ok {
+		return "succeeded"
+	}
+
+	if _, ok := errors[name]; !ok {
+		return "skipped"
+	}
+
+	if interrupts.Cancelled(name) {
+		return "cancelled"
+	}
+
+	return "failed"
+}
+
+// exitCode returns the exit code of the command for the number of builds
+// that were to run and of those that errored.
+func exitCode(builds int, errors int, interrupted bool) int {
+	if interrupted {
+		return exitCodeFailure
+	}
+
+	if errors == 0 {
+		return exitCodeSuccess
+	}
+
+	if errors < builds {
+		return exitCodePartialFailure
+	}
+
+	return exitCodeFailure
+}
+
+// buildMatches says whether the build is named by one of the names, which
+// are the names of builds or the types of their builders. If the build is
+// empty, it says whether any build of the template is.
+func buildMatches(tpl *packer.Template, build string, names []string) bool {
+	for buildName, builder := range tpl.Builders {
+		if build != "" && buildName != build {
+			continue
+		}
+
+		for _, name := range names {
+			if name == buildName || name == builder.Type {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// machineArtifact reports the artifact, which is the i-th of its build,
+// as machine-readable events.
+func machineArtifact(ui packer.Ui, i int, artifact packer.Artifact) {
+	index := strconv.Itoa(i)
+	if artifact == nil {
+		ui.Machine("artifact", index, "nil")
+		return
+	}
+
+	ui.Machine("artifact", index, "builder-id", artifact.BuilderId())
+	ui.Machine("artifact", index, "id", artifact.Id())
+	ui.Machine("artifact", index, "string", artifact.String())
+
+	files := artifact.Files()
+	ui.Machine("artifact", index, "files-count", strconv.Itoa(len(files)))
+	for j, file := range files {
+		ui.Machine("artifact", index, "file", strconv.Itoa(j), file)
+	}
 }
 
 func (Command) Synopsis() string {
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"cgl.tideland.biz/asserts"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -53,3 +56,103 @@ func TestCommand_Run_MissingFile(t *testing.T) {
 	result := command.Run(testEnvironment(), args)
 	assert.Equal(result, 1, "a non-existent file should error")
 }
+
+func TestCommand_Run_NegativeParallelBuilds(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+	command := new(Command)
+
+	args := []string{"-parallel-builds=-1", "template.json"}
+	result := command.Run(testEnvironment(), args)
+	assert.Equal(result, 1, "negative parallel builds should error")
+}
+
+func TestCommand_Run_InvalidOnError(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+	command := new(Command)
+
+	args := []string{"-on-error=explode", "template.json"}
+	result := command.Run(testEnvironment(), args)
+	assert.Equal(result, 1, "invalid on error should error")
+}
+
+func TestCommand_Run_OnlyUnknown(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	tf.WriteString(`{"builders": [{"name": "foo", "type": "test"}]}`)
+	tf.Close()
+
+	env := testEnvironment()
+	command := new(Command)
+	for _, flag := range []string{"-only", "-except"} {
+		args := []string{flag + "=bar", tf.Name()}
+		if result := command.Run(env, args); result != 1 {
+			t.Fatalf("%s: unknown names should error", flag)
+		}
+	}
+
+	output := env.Ui().(*packer.ReaderWriterUi).Writer.(*bytes.Buffer).String()
+	if !strings.Contains(output, "No build or builder type named 'bar'") {
+		t.Fatalf("bad: %s", output)
+	}
+}
+
+func TestBuildMatches(t *testing.T) {
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"builders": [
+			{"name": "foo", "type": "amazon-ebs"},
+			{"name": "bar", "type": "virtualbox"},
+			{"type": "virtualbox"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		build    string
+		names    []string
+		expected bool
+	}{
+		{"foo", []string{"foo"}, true},
+		{"foo", []string{"amazon-ebs"}, true},
+		{"foo", []string{"bar", "virtualbox"}, false},
+		{"bar", []string{"virtualbox"}, true},
+		{"virtualbox", []string{"virtualbox"}, true},
+		{"", []string{"amazon-ebs"}, true},
+		{"", []string{"baz"}, false},
+	}
+
+	for _, tc := range cases {
+		if actual := buildMatches(tpl, tc.build, tc.names); actual != tc.expected {
+			t.Errorf("%s %v: expected %v", tc.build, tc.names, tc.expected)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		builds      int
+		errors      int
+		interrupted bool
+		expected    int
+	}{
+		{2, 0, false, exitCodeSuccess},
+		{0, 0, false, exitCodeSuccess},
+		{2, 1, false, exitCodePartialFailure},
+		{2, 2, false, exitCodeFailure},
+		{2, 0, true, exitCodeFailure},
+		{2, 1, true, exitCodeFailure},
+	}
+
+	for _, tc := range cases {
+		actual := exitCode(tc.builds, tc.errors, tc.interrupted)
+		if actual != tc.expected {
+			t.Errorf("%d builds, %d errors, interrupted %v: bad: %d",
+				tc.builds, tc.errors, tc.interrupted, actual)
+		}
+	}
+}
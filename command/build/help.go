@@ -3,12 +3,28 @@ package build
 const helpText = `
 Usage: packer build [options] TEMPLATE
 
-  Will execute multiple builds in parallel as defined in the template.
+  Will execute multiple builds in parallel as defined in the template,
+  at most -parallel-builds at a time if it is given.
   The various artifacts created by the template will be outputted.
+  Templates whose names end in .hcl are read as HCL, and those whose
+  names end in .yml or .yaml as YAML.
 
 Options:
 
   -debug                     Debug mode enabled for builds
-  -except=foo,bar,baz        Build all builds other than these
-  -only=foo,bar,baz          Only build the given builds by name
+  -dry-run                   Prepare the builds and show what they would do
+  -except=foo,bar,baz        Build all builds other than these names or types
+  -force                     Replace the artifacts of earlier builds
+  -manifest=path             Write a JSON manifest of the builds to a file
+  -no-color                  Disable color output (on by default)
+  -on-error=cleanup          What to do when a step fails: cleanup, abort,
+                             ask or retry (cleanup by default)
+  -only=foo,bar,baz          Only build the given builds by name or type
+  -parallel-builds=N         Run at most N builds at once (no limit by default)
+  -resume                    Resume builds from where they left off
+  -timeout=2h                Cancel builds that run for longer than this
+  -timing=path               Write a JSON report of how long the parts of
+                             builds took to a file
+  -var 'key=value'           Variable for templates, can be used multiple times.
+  -var-file=path             JSON file containing user variables.
 `
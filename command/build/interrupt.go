@@ -0,0 +1,123 @@
+package build
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// interruptHandler cancels the builds that are running when Packer is
+// interrupted, which cleans up the machines and instances they made, and
+// keeps the builds that haven't started from starting. A second interrupt
+// forcefully exits Packer, which is handled in main.
+type interruptHandler struct {
+	ui packer.Ui
+
+	interrupted bool
+	running     map[string]packer.Build
+	cancelled   map[string]bool
+	sigCh       chan os.Signal
+	stopCh      chan struct{}
+	doneCh      chan struct{}
+	wg          sync.WaitGroup
+	l           sync.Mutex
+}
+
+// newInterruptHandler returns an interruptHandler that is handling
+// interrupts until it is stopped.
+func newInterruptHandler(ui packer.Ui) *interruptHandler {
+	h := &interruptHandler{
+		ui:        ui,
+		running:   make(map[string]packer.Build),
+		cancelled: make(map[string]bool),
+		sigCh:     make(chan os.Signal, 1),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+
+	signal.Notify(h.sigCh, os.Interrupt)
+	go h.handle()
+	return h
+}
+
+// Start says that the build is about to run, so it is cancelled if Packer
+// is interrupted. If Packer was already interrupted, the build shouldn't
+// run, and false is returned.
+func (h *interruptHandler) Start(b packer.Build) bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	if h.interrupted {
+		return false
+	}
+
+	h.running[b.Name()] = b
+	return true
+}
+
+// Finish says that the build is done, so there is nothing to cancel.
+func (h *interruptHandler) Finish(b packer.Build) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	delete(h.running, b.Name())
+}
+
+// Interrupted says whether Packer was interrupted.
+func (h *interruptHandler) Interrupted() bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.interrupted
+}
+
+// Cancelled says whether the build with the name was cancelled because
+// Packer was interrupted while it was running.
+func (h *interruptHandler) Cancelled(name string) bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	return h.cancelled[name]
+}
+
+// Stop stops handling interrupts, waiting for the builds that are being
+// cancelled to be cancelled.
+func (h *interruptHandler) Stop() {
+	signal.Stop(h.sigCh)
+	close(h.stopCh)
+	<-h.doneCh
+
+	log.Println("[DEBUG] Waiting on builds to be cancelled...")
+	h.wg.Wait()
+}
+
+func (h *interruptHandler) handle() {
+	defer close(h.doneCh)
+
+	select {
+	case <-h.sigCh:
+	case <-h.stopCh:
+		return
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	h.interrupted = true
+	h.ui.Error("Interrupted, cancelling the running builds and cleaning up.\n" +
+		"Interrupt again to exit immediately, which may leave machines behind.")
+
+	for name, b := range h.running {
+		h.cancelled[name] = true
+		h.wg.Add(1)
+		go func(b packer.Build) {
+			defer h.wg.Done()
+
+			log.Printf("[INFO] Stopping build: %s", b.Name())
+			b.Cancel()
+			log.Printf("[INFO] Build cancelled: %s", b.Name())
+		}(b)
+	}
+}
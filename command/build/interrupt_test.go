@@ -0,0 +1,77 @@
+package build
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"os"
+	"testing"
+	"time"
+)
+
+type testBuild struct {
+	name         string
+	cancelCalled bool
+}
+
+func (b *testBuild) Name() string                                         { return b.name }
+func (*testBuild) Prepare() error                                         { return nil }
+func (*testBuild) Run(packer.Ui, packer.Cache) ([]packer.Artifact, error) { return nil, nil }
+func (*testBuild) SetDebug(bool)                                          {}
+func (*testBuild) SetStrict(bool)                                         {}
+func (*testBuild) SetForce(bool)                                          {}
+func (*testBuild) SetResume(bool)                                         {}
+func (*testBuild) SetOnError(string)                                      {}
+func (*testBuild) SetTimeout(time.Duration)                               {}
+func (b *testBuild) Cancel()                                              { b.cancelCalled = true }
+
+func TestInterruptHandler(t *testing.T) {
+	ui := &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	running := &testBuild{name: "running"}
+	finished := &testBuild{name: "finished"}
+
+	h := newInterruptHandler(ui)
+	if !h.Start(running) || !h.Start(finished) {
+		t.Fatal("builds should start")
+	}
+	h.Finish(finished)
+
+	h.sigCh <- os.Interrupt
+	for i := 0; !h.Interrupted(); i++ {
+		if i > 100 {
+			t.Fatal("should be interrupted")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.Stop()
+
+	if !running.cancelCalled || !h.Cancelled("running") {
+		t.Fatal("running build should be cancelled")
+	}
+
+	if finished.cancelCalled || h.Cancelled("finished") {
+		t.Fatal("finished build should not be cancelled")
+	}
+
+	if h.Start(&testBuild{name: "later"}) {
+		t.Fatal("builds should not start after an interrupt")
+	}
+}
+
+func TestInterruptHandler_NotInterrupted(t *testing.T) {
+	h := newInterruptHandler(nil)
+	b := &testBuild{name: "foo"}
+	if !h.Start(b) {
+		t.Fatal("build should start")
+	}
+	h.Finish(b)
+	h.Stop()
+
+	if h.Interrupted() || b.cancelCalled {
+		t.Fatal("should not be interrupted")
+	}
+}
@@ -0,0 +1,150 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// manifest is what is written to the file given with -manifest, so that
+// other tools can find the artifacts of the builds without reading the
+// output of Packer.
+type manifest struct {
+	Builds        []manifestBuild   `json:"builds"`
+	UserVariables map[string]string `json:"user_variables"`
+}
+
+type manifestBuild struct {
+	Name        string             `json:"name"`
+	BuilderType string             `json:"builder_type"`
+	StartTime   time.Time          `json:"start_time"`
+	Duration    float64            `json:"duration_seconds"`
+	Error       string             `json:"error,omitempty"`
+	Metadata    map[string]string  `json:"metadata,omitempty"`
+	Artifacts   []manifestArtifact `json:"artifacts"`
+}
+
+type manifestArtifact struct {
+	BuilderId string         `json:"builder_id"`
+	Id        string         `json:"id"`
+	Files     []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// buildTime is when a build started and how long it ran.
+type buildTime struct {
+	start    time.Time
+	duration time.Duration
+}
+
+// writeManifest writes the manifest of the builds with the names that ran
+// to the path. The file can only be read by the user, since user variables
+// are often secrets such as access keys, though the values of sensitive
+// variables are masked.
+func writeManifest(
+	path string,
+	tpl *packer.Template,
+	names []string,
+	artifacts map[string][]packer.Artifact,
+	errors map[string]error,
+	times map[string]buildTime) error {
+	m := &manifest{
+		Builds:        make([]manifestBuild, 0, len(names)),
+		UserVariables: make(map[string]string),
+	}
+
+	for k, v := range tpl.Variables {
+		m.UserVariables[k] = v
+	}
+
+	for _, k := range tpl.SensitiveVariables {
+		m.UserVariables[k] = packer.SensitiveMask
+	}
+
+	for _, name := range names {
+		t, ok := times[name]
+		if !ok {
+			// The build was skipped or never started
+			continue
+		}
+
+		build := manifestBuild{
+			Name:        name,
+			BuilderType: tpl.Builders[name].Type,
+			StartTime:   t.start.UTC(),
+			Duration:    t.duration.Seconds(),
+			Artifacts:   make([]manifestArtifact, 0, len(artifacts[name])),
+		}
+
+		if err, ok := errors[name]; ok {
+			build.Error = err.Error()
+		}
+
+		// The metadata was processed when the build was made, so this
+		// can't fail and has the same values.
+		if metadata, err := tpl.BuildMetadata(name); err == nil && len(metadata) > 0 {
+			build.Metadata = metadata
+		}
+
+		for _, artifact := range artifacts[name] {
+			if artifact == nil {
+				continue
+			}
+
+			build.Artifacts = append(build.Artifacts, manifestArtifact{
+				BuilderId: artifact.BuilderId(),
+				Id:        artifact.Id(),
+				Files:     manifestFiles(artifact.Files()),
+			})
+		}
+
+		m.Builds = append(m.Builds, build)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Writing manifest: %s", path)
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// manifestFiles returns the sizes and checksums of the files of an
+// artifact. Files that can't be read are listed without them.
+func manifestFiles(paths []string) []manifestFile {
+	files := make([]manifestFile, len(paths))
+	for i, path := range paths {
+		files[i].Path = path
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("[WARN] Error reading artifact file for the manifest: %s", err)
+			continue
+		}
+
+		hash := sha256.New()
+		size, err := io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			log.Printf("[WARN] Error reading artifact file for the manifest: %s", err)
+			continue
+		}
+
+		files[i].Size = size
+		files[i].SHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	return files
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testArtifact struct {
+	files []string
+}
+
+func (*testArtifact) BuilderId() string {
+	return "bid"
+}
+
+func (a *testArtifact) Files() []string {
+	return a.files
+}
+
+func (*testArtifact) Id() string {
+	return "id"
+}
+
+func (*testArtifact) String() string {
+	return "string"
+}
+
+func (*testArtifact) Destroy() error {
+	return nil
+}
+
+func TestWriteManifest(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	file := filepath.Join(td, "file")
+	if err := ioutil.WriteFile(file, []byte("foo"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"variables": {"foo": "bar", "secret": "hunter2"},
+		"sensitive-variables": ["secret"],
+		"metadata": {"version": "{{user \"foo\"}}", "build": "{{build_name}}"},
+		"builders": [
+			{"type": "amazon-ebs"},
+			{"name": "other", "type": "amazon-ebs"},
+			{"name": "skipped", "type": "amazon-ebs"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	start := time.Now()
+	artifacts := map[string][]packer.Artifact{
+		"amazon-ebs": []packer.Artifact{&testArtifact{files: []string{file}}},
+	}
+	errs := map[string]error{
+		"other": errors.New("failed"),
+	}
+	times := map[string]buildTime{
+		"amazon-ebs": buildTime{start, 2 * time.Second},
+		"other":      buildTime{start, time.Second},
+	}
+
+	path := filepath.Join(td, "manifest.json")
+	names := []string{"amazon-ebs", "other", "skipped"}
+	if err := writeManifest(path, tpl, names, artifacts, errs, times); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if m.UserVariables["foo"] != "bar" || m.UserVariables["secret"] != packer.SensitiveMask {
+		t.Fatalf("bad user variables: %#v", m.UserVariables)
+	}
+
+	if len(m.Builds) != 2 {
+		t.Fatalf("bad builds: %#v", m.Builds)
+	}
+
+	build := m.Builds[0]
+	if build.Name != "amazon-ebs" || build.BuilderType != "amazon-ebs" || build.Duration != 2 {
+		t.Fatalf("bad build: %#v", build)
+	}
+
+	if build.Metadata["version"] != "bar" || build.Metadata["build"] != "amazon-ebs" {
+		t.Fatalf("bad metadata: %#v", build.Metadata)
+	}
+
+	if len(build.Artifacts) != 1 || build.Artifacts[0].Id != "id" {
+		t.Fatalf("bad artifacts: %#v", build.Artifacts)
+	}
+
+	// sha256 of "foo"
+	expected := manifestFile{
+		Path:   file,
+		Size:   3,
+		SHA256: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+	}
+	if files := build.Artifacts[0].Files; len(files) != 1 || files[0] != expected {
+		t.Fatalf("bad files: %#v", files)
+	}
+
+	if m.Builds[1].Error != "failed" || len(m.Builds[1].Artifacts) != 0 {
+		t.Fatalf("bad build: %#v", m.Builds[1])
+	}
+}
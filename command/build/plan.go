@@ -0,0 +1,129 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"sort"
+	"strings"
+	"time"
+)
+
+// printPlan says what the builds with the names would do if they were
+// run, for -dry-run. The builds have been prepared, so their
+// configurations are valid, but nothing was launched or produced. The
+// onError and timeout are the values given on the command line, which
+// override those of the builders, if they are set.
+func printPlan(
+	ui packer.Ui,
+	tpl *packer.Template,
+	names []string,
+	onError string,
+	timeout time.Duration) {
+	var plan bytes.Buffer
+	plan.WriteString("\n==> Dry run, so nothing was built. The builds would run as follows:\n")
+
+	for _, name := range names {
+		builder := tpl.Builders[name]
+
+		buildOnError := onError
+		if buildOnError == "" {
+			buildOnError = builder.OnError
+		}
+		if buildOnError == "" {
+			buildOnError = packer.OnErrorCleanup
+		}
+
+		buildTimeout := "none"
+		if timeout > 0 {
+			buildTimeout = timeout.String()
+		} else if builder.Timeout != "" {
+			buildTimeout = builder.Timeout
+		}
+
+		provisioners := make([]string, 0, len(tpl.Provisioners))
+		for _, p := range tpl.Provisioners {
+			if !p.RunsFor(name, builder.Type) {
+				continue
+			}
+
+			provisioner := p.Type
+			if len(p.OverridesFor(name, builder.Type)) > 0 {
+				provisioner += " (overridden)"
+			}
+
+			provisioners = append(provisioners, provisioner)
+		}
+
+		// Each sequence of post-processors runs on the artifact of the
+		// builder, and each post-processor in it on the artifact of the
+		// one before it.
+		sequences := make([]string, 0, len(tpl.PostProcessors))
+		for _, sequence := range tpl.PostProcessors {
+			types := make([]string, 0, len(sequence))
+			for _, pp := range sequence {
+				if !pp.RunsFor(name, builder.Type) {
+					continue
+				}
+
+				ppType := pp.Type
+				if keep := pp.KeepInputArtifact; keep != nil {
+					if *keep {
+						ppType += " (keeps input)"
+					} else {
+						ppType += " (deletes input)"
+					}
+				}
+
+				types = append(types, ppType)
+			}
+
+			if len(types) > 0 {
+				sequences = append(sequences, strings.Join(types, " -> "))
+			}
+		}
+
+		fmt.Fprintf(&plan, "\n--> %s:\n", name)
+		fmt.Fprintf(&plan, "    Builder: %s\n", builder.Type)
+		fmt.Fprintf(&plan, "    On error: %s\n", buildOnError)
+		fmt.Fprintf(&plan, "    Timeout: %s\n", buildTimeout)
+		fmt.Fprintf(&plan, "    Provisioners: %s\n", planList(provisioners))
+		fmt.Fprintf(&plan, "    Post-processors: %s\n", planList(sequences))
+	}
+
+	if len(tpl.Hooks) > 0 {
+		hooks := make([]string, 0, len(tpl.Hooks))
+		for hook, _ := range tpl.Hooks {
+			hooks = append(hooks, hook)
+		}
+		sort.Strings(hooks)
+
+		plan.WriteString("\n--> Hooks of every build:\n")
+		for _, hook := range hooks {
+			fmt.Fprintf(&plan, "    %s: %s\n", hook, planList(tpl.Hooks[hook]))
+		}
+	}
+
+	// The values of user variables aren't shown, since they are often
+	// secrets such as access keys.
+	if len(tpl.Variables) > 0 {
+		variables := make([]string, 0, len(tpl.Variables))
+		for variable, _ := range tpl.Variables {
+			variables = append(variables, variable)
+		}
+		sort.Strings(variables)
+
+		fmt.Fprintf(&plan, "\n--> User variables: %s\n", planList(variables))
+	}
+
+	ui.Say(strings.TrimRight(plan.String(), "\n"))
+}
+
+// planList lists the values, or says there are none.
+func planList(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+
+	return strings.Join(values, ", ")
+}
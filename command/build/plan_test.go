@@ -0,0 +1,66 @@
+package build
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintPlan(t *testing.T) {
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"variables": {"secret": "hunter2"},
+		"builders": [
+			{"type": "amazon-ebs", "on_error": "abort"},
+			{"name": "other", "type": "amazon-ebs", "timeout": "2h"}
+		],
+		"provisioners": [
+			{"type": "shell", "override": {"other": {}}},
+			{"type": "file", "only": ["other"]}
+		],
+		"post-processors": [
+			["compress", {"type": "upload", "keep_input_artifact": true}],
+			{"type": "vagrant", "except": ["other"], "keep_input_artifact": false}
+		],
+		"hooks": {"packer_build_start": ["shell:echo hi"]}
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	ui := &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: &output,
+	}
+
+	printPlan(ui, tpl, []string{"amazon-ebs", "other"}, "", 0)
+
+	expected := []string{
+		"--> amazon-ebs:\n    Builder: amazon-ebs\n    On error: abort\n    Timeout: none\n    Provisioners: shell\n",
+		"--> other:\n    Builder: amazon-ebs\n    On error: cleanup\n    Timeout: 2h\n    Provisioners: shell (overridden), file\n",
+		"Post-processors: compress -> upload (keeps input), vagrant (deletes input)\n",
+		"Post-processors: compress -> upload (keeps input)\n\n",
+		"packer_build_start: shell:echo hi\n",
+		"User variables: secret\n",
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(output.String(), e) {
+			t.Fatalf("missing %q in:\n%s", e, output.String())
+		}
+	}
+
+	if strings.Contains(output.String(), "hunter2") {
+		t.Fatalf("should not show variable values:\n%s", output.String())
+	}
+
+	// The flags override the builders
+	output.Reset()
+	printPlan(ui, tpl, []string{"other"}, packer.OnErrorRetry, time.Hour)
+
+	if !strings.Contains(output.String(), "On error: retry\n    Timeout: 1h0m0s\n") {
+		t.Fatalf("bad:\n%s", output.String())
+	}
+}
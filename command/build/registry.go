@@ -0,0 +1,131 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// artifactRegistry returns the artifact registry that builds are recorded
+// in, or nil if Packer didn't set one.
+func artifactRegistry() *packer.ArtifactRegistry {
+	path := os.Getenv(packer.ArtifactRegistryEnvVar)
+	if path == "" {
+		log.Println("[DEBUG] No artifact registry, artifacts aren't recorded.")
+		return nil
+	}
+
+	return &packer.ArtifactRegistry{Path: path}
+}
+
+// registryTemplate is the template of the builds, as it is recorded with
+// their artifacts.
+type registryTemplate struct {
+	path string
+	hash string
+}
+
+// newRegistryTemplate returns the template at the path, with the contents,
+// as it is recorded.
+func newRegistryTemplate(path string, data []byte) (*registryTemplate, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+	return &registryTemplate{path, hex.EncodeToString(hash[:])}, nil
+}
+
+// registerArtifacts records the artifacts of the builds that succeeded in
+// the registry.
+func registerArtifacts(
+	r *packer.ArtifactRegistry,
+	tpl *packer.Template,
+	template *registryTemplate,
+	artifacts map[string][]packer.Artifact,
+	times map[string]buildTime) error {
+	registered := make([]*packer.RegisteredArtifact, 0)
+	for name, buildArtifacts := range artifacts {
+		t := times[name]
+		for _, artifact := range buildArtifacts {
+			if artifact == nil {
+				continue
+			}
+
+			registered = append(registered, &packer.RegisteredArtifact{
+				Build:        name,
+				BuilderType:  tpl.Builders[name].Type,
+				BuilderId:    artifact.BuilderId(),
+				Id:           artifact.Id(),
+				Files:        artifact.Files(),
+				Template:     template.path,
+				TemplateHash: template.hash,
+				StartTime:    t.start.UTC(),
+				FinishTime:   t.start.Add(t.duration).UTC(),
+			})
+		}
+	}
+
+	if len(registered) == 0 {
+		return nil
+	}
+
+	log.Printf("[INFO] Recording %d artifacts in: %s", len(registered), r.Path)
+	return r.Add(registered...)
+}
+
+// destroyEarlierArtifacts destroys the recorded artifacts of earlier runs
+// of the builds of the template with the names, when the builds are forced, and removes
+// them from the registry. Artifacts that aren't files, such as images in
+// a cloud, are left to the builders, which replace those with the same
+// name, and the user is told about them before they're forgotten. Files
+// that can't be removed stay in the registry.
+func destroyEarlierArtifacts(
+	ui packer.Ui,
+	r *packer.ArtifactRegistry,
+	template *registryTemplate,
+	names []string) error {
+	registered, err := r.Artifacts()
+	if err != nil {
+		return err
+	}
+
+	builds := make(map[string]bool)
+	for _, name := range names {
+		builds[name] = true
+	}
+
+	destroyed := make([]*packer.RegisteredArtifact, 0)
+	for _, a := range registered {
+		if a.Template != template.path || !builds[a.Build] {
+			continue
+		}
+
+		if len(a.Files) == 0 {
+			ui.Say(fmt.Sprintf(
+				"The artifact %s from an earlier run isn't destroyed unless "+
+					"the builder replaces it, and is forgotten.", a))
+			destroyed = append(destroyed, a)
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Destroying the artifact %s from an earlier run...", a))
+		if err := a.Destroy(); err != nil {
+			ui.Error(fmt.Sprintf("Error destroying the artifact %s: %s", a, err))
+			continue
+		}
+
+		destroyed = append(destroyed, a)
+	}
+
+	if len(destroyed) == 0 {
+		return nil
+	}
+
+	return r.Remove(destroyed...)
+}
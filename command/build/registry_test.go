@@ -0,0 +1,109 @@
+package build
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegisterArtifacts_DestroyEarlier(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	output := filepath.Join(td, "output")
+	file := filepath.Join(output, "disk.vmdk")
+	if err := os.Mkdir(output, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("foo"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"builders": [
+			{"type": "vmware"},
+			{"type": "amazon-ebs"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	template, err := newRegistryTemplate(filepath.Join(td, "template.json"), []byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	other, err := newRegistryTemplate(filepath.Join(td, "other.json"), []byte("foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	start := time.Now()
+	times := map[string]buildTime{
+		"vmware":     buildTime{start, time.Second},
+		"amazon-ebs": buildTime{start, time.Second},
+	}
+
+	registry := &packer.ArtifactRegistry{Path: filepath.Join(td, "artifacts.json")}
+	if err := registerArtifacts(registry, tpl, template, map[string][]packer.Artifact{
+		"vmware":     []packer.Artifact{&testArtifact{files: []string{file}}, nil},
+		"amazon-ebs": []packer.Artifact{&testArtifact{}},
+	}, times); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A build with the same name in another template isn't touched
+	if err := registerArtifacts(registry, tpl, other, map[string][]packer.Artifact{
+		"vmware": []packer.Artifact{&testArtifact{files: []string{"other.vmdk"}}},
+	}, times); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	registered, err := registry.Artifacts()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(registered) != 3 {
+		t.Fatalf("bad: %#v", registered)
+	}
+
+	for _, a := range registered {
+		if a.Build == "vmware" && a.Template == template.path {
+			if a.BuilderType != "vmware" || a.Files[0] != file || a.TemplateHash != template.hash {
+				t.Fatalf("bad: %#v", a)
+			}
+
+			if !a.StartTime.Equal(start) || !a.FinishTime.Equal(start.Add(time.Second)) {
+				t.Fatalf("bad: %#v", a)
+			}
+		}
+	}
+
+	ui := &packer.ReaderWriterUi{Reader: new(bytes.Buffer), Writer: new(bytes.Buffer)}
+	if err := destroyEarlierArtifacts(ui, registry, template, []string{"vmware", "amazon-ebs"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("artifact should be destroyed: %s", err)
+	}
+
+	registered, err = registry.Artifacts()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(registered) != 1 || registered[0].Template != other.path {
+		t.Fatalf("bad: %#v", registered)
+	}
+}
@@ -0,0 +1,143 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// timingUi is a UI that keeps the timings that are reported to it, which
+// are those of one build, and passes everything on to the UI it wraps.
+type timingUi struct {
+	packer.Ui
+
+	timings []packer.Timing
+	l       sync.Mutex
+}
+
+func (u *timingUi) Machine(t string, args ...string) {
+	if t == "timing" {
+		if timing, ok := packer.ParseTiming(args); ok {
+			u.l.Lock()
+			u.timings = append(u.timings, timing)
+			u.l.Unlock()
+		}
+	}
+
+	u.Ui.Machine(t, args...)
+}
+
+// Timings returns the timings reported so far, in the order they were.
+func (u *timingUi) Timings() []packer.Timing {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	result := make([]packer.Timing, len(u.timings))
+	copy(result, u.timings)
+	return result
+}
+
+// timingReport is what is written to the file given with -timing.
+type timingReport struct {
+	Builds []timingReportBuild `json:"builds"`
+}
+
+type timingReportBuild struct {
+	Name     string       `json:"name"`
+	Duration float64      `json:"duration_seconds"`
+	Timings  []timingPart `json:"timings"`
+}
+
+type timingPart struct {
+	Kind     string  `json:"kind"`
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// showTimings shows how long the parts of the builds with the names took,
+// as a table for each build that ran, with the share of the whole build
+// that each part took.
+func showTimings(ui packer.Ui, names []string, times map[string]buildTime,
+	timings map[string][]packer.Timing) {
+	var message bytes.Buffer
+	w := tabwriter.NewWriter(&message, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		t, ok := times[name]
+		if !ok || len(timings[name]) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "--> %s: %s\n", name, formatTiming(t.duration))
+		for _, timing := range timings[name] {
+			share := 0.0
+			if t.duration > 0 {
+				share = float64(timing.Duration) * 100 / float64(t.duration)
+			}
+
+			fmt.Fprintf(w, "    %s\t%s\t%s\t%.0f%%\n",
+				timing.Kind, timing.Name, formatTiming(timing.Duration), share)
+		}
+	}
+
+	w.Flush()
+	if message.Len() == 0 {
+		return
+	}
+
+	ui.Say("\n==> How long the parts of the builds took:")
+	ui.Say(message.String())
+}
+
+// formatTiming returns the duration rounded to seconds, or to milliseconds
+// if it is shorter than a second, which is precise enough to compare the
+// parts of builds by.
+func formatTiming(d time.Duration) string {
+	if d < time.Second {
+		return (d / time.Millisecond * time.Millisecond).String()
+	}
+
+	return (d / time.Second * time.Second).String()
+}
+
+// writeTimingReport writes how long the parts of the builds with the names
+// took to the path, as JSON, for the builds that ran.
+func writeTimingReport(path string, names []string, times map[string]buildTime,
+	timings map[string][]packer.Timing) error {
+	report := &timingReport{Builds: make([]timingReportBuild, 0, len(names))}
+	for _, name := range names {
+		t, ok := times[name]
+		if !ok {
+			continue
+		}
+
+		build := timingReportBuild{
+			Name:     name,
+			Duration: t.duration.Seconds(),
+			Timings:  make([]timingPart, len(timings[name])),
+		}
+
+		for i, timing := range timings[name] {
+			build.Timings[i] = timingPart{
+				Kind:     timing.Kind,
+				Name:     timing.Name,
+				Duration: timing.Duration.Seconds(),
+			}
+		}
+
+		report.Builds = append(report.Builds, build)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Writing timing report: %s", path)
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,124 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingUi(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &timingUi{Ui: &packer.MachineReadableUi{Writer: out}}
+
+	packer.ReportTiming(ui, packer.Timing{Kind: packer.TimingStep, Name: "stepCreateVM", Duration: time.Second})
+	ui.Machine("timing", "bad")
+	ui.Machine("artifact-count", "1")
+
+	expected := []packer.Timing{{Kind: packer.TimingStep, Name: "stepCreateVM", Duration: time.Second}}
+	if !reflect.DeepEqual(ui.Timings(), expected) {
+		t.Fatalf("bad: %#v", ui.Timings())
+	}
+
+	// The events still go through
+	if strings.Count(out.String(), "\n") != 3 {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestShowTimings(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &packer.ReaderWriterUi{Reader: new(bytes.Buffer), Writer: out}
+
+	times := map[string]buildTime{
+		"vmware": buildTime{time.Now(), 40 * time.Minute},
+		"failed": buildTime{time.Now(), time.Minute},
+	}
+	timings := map[string][]packer.Timing{
+		"vmware": {
+			{Kind: packer.TimingStep, Name: "stepDownloadISO", Duration: 10 * time.Minute},
+			{Kind: packer.TimingProvisioner, Name: "shell (provisioners[0])", Duration: 20*time.Minute + 300*time.Millisecond},
+			{Kind: packer.TimingStep, Name: "stepCleanFiles", Duration: 12500 * time.Microsecond},
+		},
+	}
+
+	showTimings(ui, []string{"failed", "skipped", "vmware"}, times, timings)
+
+	for _, expected := range []string{
+		"--> vmware: 40m0s\n",
+		"    step         stepDownloadISO          10m0s  25%\n",
+		"    provisioner  shell (provisioners[0])  20m0s  50%\n",
+		"    step         stepCleanFiles           12ms   0%\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("bad: %s", out.String())
+		}
+	}
+
+	// Builds without timings aren't shown
+	if strings.Contains(out.String(), "failed") || strings.Contains(out.String(), "skipped") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	out.Reset()
+	showTimings(ui, []string{"failed"}, times, timings)
+	if out.Len() != 0 {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestWriteTimingReport(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	times := map[string]buildTime{
+		"vmware": buildTime{time.Now(), 2 * time.Minute},
+	}
+	timings := map[string][]packer.Timing{
+		"vmware": {
+			{Kind: packer.TimingStep, Name: "stepDownloadISO", Duration: 90 * time.Second},
+			{Kind: packer.TimingPostProcessor, Name: "vagrant (post-processors[0])", Duration: 1500 * time.Millisecond},
+		},
+	}
+
+	path := filepath.Join(td, "timing.json")
+	if err := writeTimingReport(path, []string{"skipped", "vmware"}, times, timings); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var report timingReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := timingReport{
+		Builds: []timingReportBuild{
+			{
+				Name:     "vmware",
+				Duration: 120,
+				Timings: []timingPart{
+					{"step", "stepDownloadISO", 90},
+					{"post-processor", "vagrant (post-processors[0])", 1.5},
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("bad: %#v", report)
+	}
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/packer/fix"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// LintTemplate returns the warnings about the template at the path, whose
+// contents are the data: those of Template.Lint, and then the deprecated
+// syntax that "packer fix" would fix. Only JSON templates can be fixed, so
+// the syntax of other formats isn't checked.
+func LintTemplate(path string, data []byte, tpl *packer.Template) []string {
+	warnings := tpl.Lint()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hcl", ".yml", ".yaml":
+		return warnings
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		log.Printf("[WARN] Error reading template for deprecated syntax: %s", err)
+		return warnings
+	}
+
+	deprecated, err := fix.Deprecated(raw)
+	if err != nil {
+		log.Printf("[WARN] Error checking template for deprecated syntax: %s", err)
+		return warnings
+	}
+
+	for _, change := range deprecated {
+		warnings = append(warnings, fmt.Sprintf(
+			"deprecated syntax that \"packer fix\" fixes: %s", change))
+	}
+
+	return warnings
+}
+
+// ShowLintWarnings tells the user about the warnings of LintTemplate,
+// which don't stop the template from being built. Each one is also a
+// "template-warning" event of the machine-readable output.
+func ShowLintWarnings(ui packer.Ui, warnings []string) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	ui.Say("Warnings about the template:\n")
+	for _, warning := range warnings {
+		ui.Machine("template-warning", warning)
+		ui.Say(fmt.Sprintf("  * %s", warning))
+	}
+
+	ui.Say("")
+}
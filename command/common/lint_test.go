@@ -0,0 +1,41 @@
+package common
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"strings"
+	"testing"
+)
+
+func TestLintTemplate(t *testing.T) {
+	data := []byte(`{
+		"variables": {"unused": ""},
+		"builders": [{"type": "virtualbox", "iso_md5": "foo"}]
+	}`)
+
+	tpl, err := packer.ParseTemplate(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	warnings := LintTemplate("template.json", data, tpl)
+	if len(warnings) != 2 {
+		t.Fatalf("bad: %#v", warnings)
+	}
+
+	if !strings.Contains(warnings[0], "'unused' isn't used") ||
+		!strings.HasPrefix(warnings[1], "deprecated syntax that \"packer fix\" fixes: iso-md5: builders[0]:") {
+		t.Fatalf("bad: %#v", warnings)
+	}
+
+	out := new(bytes.Buffer)
+	ShowLintWarnings(&packer.ReaderWriterUi{Reader: new(bytes.Buffer), Writer: out}, warnings)
+	if !strings.Contains(out.String(), "  * "+warnings[1]+"\n") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	// Only JSON templates are checked for deprecated syntax
+	if warnings := LintTemplate("template.hcl", data, tpl); len(warnings) != 1 {
+		t.Fatalf("bad: %#v", warnings)
+	}
+}
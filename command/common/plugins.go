@@ -0,0 +1,274 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// The file in the plugins directory that records the plugins that were
+// installed with the plugins and init commands, since plugins don't report
+// their versions.
+const indexFile = "installed.json"
+
+// InstalledPlugin is what is recorded of a plugin when it is installed.
+type InstalledPlugin struct {
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	SHA256  string `json:"sha256"`
+}
+
+// PluginInfo is a plugin binary in the plugins directory.
+type PluginInfo struct {
+	Name    string
+	Version string
+	SHA256  string
+
+	// Whether the binary changed since it was installed, by its checksum.
+	Modified bool
+}
+
+// InstallPlugin installs the plugin binary at the source, a URL or a local
+// path, into the directory as the given name, or the name of the source if
+// the name is empty. If the checksum isn't empty, the binary must have it.
+func InstallPlugin(dir, source, name, checksum, version string) (*InstalledPlugin, error) {
+	u, err := url.Parse(source)
+	remote := err == nil && (u.Scheme == "http" || u.Scheme == "https")
+	if err == nil && u.Scheme == "file" {
+		source = u.Path
+	}
+
+	if remote && checksum == "" {
+		return nil, errors.New("A -checksum is required to install a plugin from a URL")
+	}
+
+	if name == "" {
+		if remote {
+			name = path.Base(u.Path)
+		} else {
+			name = filepath.Base(source)
+		}
+	}
+
+	if runtime.GOOS == "windows" && !strings.EqualFold(filepath.Ext(name), ".exe") {
+		name += ".exe"
+	}
+
+	if !packer.IsPluginName(name) || filepath.Base(name) != name {
+		return nil, fmt.Errorf(
+			"%s isn't the name of a plugin binary, which must start with one of: %s",
+			name, strings.Join(packer.PluginPrefixes, ", "))
+	}
+
+	var src io.ReadCloser
+	if remote {
+		log.Printf("[INFO] Downloading plugin: %s", source)
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Error downloading %s: %s", source, resp.Status)
+		}
+
+		src = resp.Body
+	} else {
+		log.Printf("[INFO] Copying plugin: %s", source)
+		if src, err = os.Open(source); err != nil {
+			return nil, err
+		}
+
+		if source, err = filepath.Abs(source); err != nil {
+			src.Close()
+			return nil, err
+		}
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// The binary is written to a temporary file first, whose name isn't
+	// that of a plugin, so that it isn't discovered until it is verified.
+	tmp, err := ioutil.TempFile(dir, ".install-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), src)
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && !strings.EqualFold(sum, checksum) {
+		return nil, fmt.Errorf("Checksum of %s is %s, expected %s", source, sum, checksum)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(dir, name)
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return nil, err
+	}
+
+	index, err := ReadInstalledPlugins(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plugin := &InstalledPlugin{Version: version, Source: source, SHA256: sum}
+	index[name] = plugin
+	return plugin, writeIndex(dir, index)
+}
+
+// ListPlugins returns the plugin binaries in the directory, sorted by name.
+func ListPlugins(dir string) ([]*PluginInfo, error) {
+	index, err := ReadInstalledPlugins(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	result := make([]*PluginInfo, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || !packer.IsPluginName(info.Name()) {
+			continue
+		}
+
+		sum, err := fileChecksum(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		plugin := &PluginInfo{Name: info.Name(), SHA256: sum}
+		if installed, ok := index[info.Name()]; ok {
+			plugin.Version = installed.Version
+			plugin.Modified = !strings.EqualFold(installed.SHA256, sum)
+		}
+
+		result = append(result, plugin)
+	}
+
+	sort.Sort(pluginsByName(result))
+	return result, nil
+}
+
+// RemovePlugin removes the plugin binary with the name from the directory.
+func RemovePlugin(dir, name string) error {
+	if !packer.IsPluginName(name) || filepath.Base(name) != name {
+		return fmt.Errorf("%s isn't the name of a plugin binary", name)
+	}
+
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); os.IsNotExist(err) && runtime.GOOS == "windows" {
+		name += ".exe"
+		target += ".exe"
+	}
+
+	if err := os.Remove(target); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Plugin isn't installed: %s", name)
+		}
+
+		return err
+	}
+
+	index, err := ReadInstalledPlugins(dir)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := index[name]; !ok {
+		return nil
+	}
+
+	delete(index, name)
+	return writeIndex(dir, index)
+}
+
+// ReadInstalledPlugins reads the plugins recorded in the directory, by
+// their names.
+func ReadInstalledPlugins(dir string) (map[string]*InstalledPlugin, error) {
+	result := make(map[string]*InstalledPlugin)
+	data, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("Error reading %s: %s", indexFile, err)
+	}
+
+	return result, nil
+}
+
+// writeIndex writes the plugins recorded in the directory.
+func writeIndex(dir string, index map[string]*InstalledPlugin) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, indexFile), data, 0644)
+}
+
+// fileChecksum returns the SHA256 checksum of the file, in hex.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type pluginsByName []*PluginInfo
+
+func (p pluginsByName) Len() int           { return len(p) }
+func (p pluginsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p pluginsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
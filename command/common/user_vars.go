@@ -0,0 +1,211 @@
+// Package common contains what is shared by the commands that take
+// templates.
+package common
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// UserVarFlags are the -var and -var-file flags that set the values of
+// the user variables of a template. Both can be given more than once.
+type UserVarFlags struct {
+	vars  map[string]string
+	files []string
+}
+
+// AddFlags adds the flags to the flag set.
+func (f *UserVarFlags) AddFlags(fs *flag.FlagSet) {
+	fs.Var((*userVarValue)(f), "var", "a user variable as key=value")
+	fs.Var((*varFileValue)(f), "var-file", "a JSON file of user variables")
+}
+
+// UserVars returns the user variables that were given. The files are read
+// in order, and variables given with -var override those in the files.
+func (f *UserVarFlags) UserVars() (map[string]string, error) {
+	result := make(map[string]string)
+	for _, path := range f.files {
+		vars, err := readUserVarFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range vars {
+			result[k] = v
+		}
+	}
+
+	for k, v := range f.vars {
+		result[k] = v
+	}
+
+	return result, nil
+}
+
+// SetVariables sets the user variables of the template, starting with the
+// defaults from the core configuration for the variables the template
+// declares, which the variables that were given override.
+func (f *UserVarFlags) SetVariables(tpl *packer.Template) error {
+	defaults, err := DefaultUserVars()
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]string)
+	for k, v := range defaults {
+		if _, ok := tpl.Variables[k]; ok {
+			vars[k] = v
+		}
+	}
+
+	userVars, err := f.UserVars()
+	if err != nil {
+		return err
+	}
+
+	for k, v := range userVars {
+		vars[k] = v
+	}
+
+	return tpl.SetVariables(vars)
+}
+
+// AskMissingVariables asks the user for the values of the required variables
+// of the template that weren't set, hiding what is typed for the sensitive
+// ones. If the user can't be asked, such as when the output is
+// machine-readable or there is no input, it returns an error that lists the
+// variables that must be set.
+func AskMissingVariables(ui packer.Ui, tpl *packer.Template) error {
+	missing := tpl.MissingVariables()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	missingErr := fmt.Errorf(
+		"Required user variables weren't set: %s. Set them with -var or -var-file.",
+		strings.Join(missing, ", "))
+
+	if os.Getenv(packer.MachineReadableEnvVar) != "" {
+		return missingErr
+	}
+
+	sensitive := make([]string, len(tpl.SensitiveVariables))
+	copy(sensitive, tpl.SensitiveVariables)
+	sort.Strings(sensitive)
+
+	vars := make(map[string]string)
+	for _, name := range missing {
+		query := fmt.Sprintf("Value for the user variable '%s':", name)
+		ask := ui.Ask
+		if i := sort.SearchStrings(sensitive, name); i < len(sensitive) && sensitive[i] == name {
+			ask = ui.AskSecret
+		}
+
+		value, err := ask(query)
+		if err != nil {
+			log.Printf("[INFO] Couldn't ask for variable %s: %s", name, err)
+			return missingErr
+		}
+
+		vars[name] = value
+	}
+
+	return tpl.SetVariables(vars)
+}
+
+// DefaultUserVars returns the default values of user variables that are
+// set in the core configuration of Packer.
+func DefaultUserVars() (map[string]string, error) {
+	raw := os.Getenv(packer.DefaultVariablesEnvVar)
+	if raw == "" {
+		return make(map[string]string), nil
+	}
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &vars); err != nil {
+		return nil, fmt.Errorf("Error parsing the default variables of the configuration: %s", err)
+	}
+
+	result, err := variableValues(vars)
+	if err != nil {
+		return nil, fmt.Errorf("Error in the default variables of the configuration: %s", err)
+	}
+
+	return result, nil
+}
+
+// readUserVarFile reads the user variables from a file, which is a JSON
+// object of the variables to their values. The values of list and map
+// variables are JSON lists and objects.
+func readUserVarFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading variables file: %s", err)
+	}
+	defer f.Close()
+
+	var vars map[string]interface{}
+	if err := json.NewDecoder(f).Decode(&vars); err != nil {
+		return nil, fmt.Errorf("Error parsing variables file %s: %s", path, err)
+	}
+
+	result, err := variableValues(vars)
+	if err != nil {
+		return nil, fmt.Errorf("Error in variables file %s: %s", path, err)
+	}
+
+	return result, nil
+}
+
+// variableValues turns decoded JSON values of user variables into the
+// strings they are set to.
+func variableValues(vars map[string]interface{}) (map[string]string, error) {
+	result := make(map[string]string)
+	for k, v := range vars {
+		value, _, err := packer.VariableValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable '%s': %s", k, err)
+		}
+
+		result[k] = value
+	}
+
+	return result, nil
+}
+
+type userVarValue UserVarFlags
+
+func (v *userVarValue) String() string {
+	return ""
+}
+
+func (v *userVarValue) Set(raw string) error {
+	idx := strings.Index(raw, "=")
+	if idx < 1 {
+		return fmt.Errorf("-var must be in the format key=value, got: %s", raw)
+	}
+
+	if v.vars == nil {
+		v.vars = make(map[string]string)
+	}
+
+	v.vars[raw[:idx]] = raw[idx+1:]
+	return nil
+}
+
+type varFileValue UserVarFlags
+
+func (v *varFileValue) String() string {
+	return ""
+}
+
+func (v *varFileValue) Set(raw string) error {
+	v.files = append(v.files, raw)
+	return nil
+}
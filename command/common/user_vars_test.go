@@ -0,0 +1,188 @@
+package common
+
+import (
+	"bytes"
+	"flag"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUserVarFlags(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	tf.Write([]byte(`{"foo": "file", "bar": "file", "groups": ["a", "b"], "tags": {"Name": "web"}}`))
+	tf.Close()
+
+	var f UserVarFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.AddFlags(fs)
+
+	args := []string{"-var", "foo=bar=baz", "-var-file", tf.Name(), "-var", "empty="}
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	vars, err := f.UserVars()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"foo":    "bar=baz",
+		"bar":    "file",
+		"empty":  "",
+		"groups": `["a","b"]`,
+		"tags":   `{"Name":"web"}`,
+	}
+
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("bad: %#v", vars)
+	}
+}
+
+func TestUserVarFlags_bad(t *testing.T) {
+	var f UserVarFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	f.AddFlags(fs)
+
+	if err := fs.Parse([]string{"-var", "=value"}); err == nil {
+		t.Fatal("should have error")
+	}
+
+	f = UserVarFlags{}
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	f.AddFlags(fs)
+	if err := fs.Parse([]string{"-var-file", "/i/should/not/exist"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := f.UserVars(); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestUserVarFlags_SetVariables(t *testing.T) {
+	defaults := `{"foo": "config", "bar": "config", "other": "config"}`
+	os.Setenv(packer.DefaultVariablesEnvVar, defaults)
+	defer os.Setenv(packer.DefaultVariablesEnvVar, "")
+
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"variables": {"foo": "template", "bar": "template", "baz": "template"},
+		"builders": [{"type": "foo"}]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var f UserVarFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.AddFlags(fs)
+	if err := fs.Parse([]string{"-var", "foo=flag"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Defaults for variables the template doesn't declare are ignored
+	if err := f.SetVariables(tpl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"foo": "flag",
+		"bar": "config",
+		"baz": "template",
+	}
+
+	if !reflect.DeepEqual(tpl.Variables, expected) {
+		t.Fatalf("bad: %#v", tpl.Variables)
+	}
+}
+
+func TestDefaultUserVars_bad(t *testing.T) {
+	os.Setenv(packer.DefaultVariablesEnvVar, "{")
+	defer os.Setenv(packer.DefaultVariablesEnvVar, "")
+
+	if _, err := DefaultUserVars(); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestAskMissingVariables(t *testing.T) {
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"variables": {"user": null, "password": null, "region": "us-east-1"},
+		"sensitive-variables": ["password"],
+		"builders": [{"type": "foo"}]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := tpl.SetVariables(map[string]string{"user": "root"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	ui := &packer.ReaderWriterUi{
+		Reader: bytes.NewBufferString("secret\n"),
+		Writer: out,
+	}
+
+	if err := AskMissingVariables(ui, tpl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tpl.Variables["password"] != "secret" || tpl.Variables["user"] != "root" {
+		t.Fatalf("bad: %#v", tpl.Variables)
+	}
+
+	if out.String() != "Value for the user variable 'password': " {
+		t.Fatalf("bad: %q", out.String())
+	}
+
+	// Nothing is missing anymore
+	ui.Reader = new(bytes.Buffer)
+	if err := AskMissingVariables(ui, tpl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestAskMissingVariables_noInput(t *testing.T) {
+	tpl, err := packer.ParseTemplate([]byte(`{
+		"variables": {"user": null, "password": null},
+		"builders": [{"type": "foo"}]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: ioutil.Discard,
+	}
+
+	err = AskMissingVariables(ui, tpl)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if !strings.Contains(err.Error(), "password, user") {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Machine-readable output is never asked
+	os.Setenv(packer.MachineReadableEnvVar, "1")
+	defer os.Setenv(packer.MachineReadableEnvVar, "")
+
+	ui.Reader = bytes.NewBufferString("foo\nbar\n")
+	if err := AskMissingVariables(ui, tpl); err == nil {
+		t.Fatal("should have error")
+	}
+}
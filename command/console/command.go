@@ -0,0 +1,121 @@
+package console
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/command/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	var cfgVars common.UserVarFlags
+
+	cmdFlags := flag.NewFlagSet("console", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cfgVars.AddFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) > 1 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	// Without a template, every default of the configuration is a variable
+	userVars, err := common.DefaultUserVars()
+	if err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	flagVars, err := cfgVars.UserVars()
+	if err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	for k, v := range flagVars {
+		userVars[k] = v
+	}
+
+	if len(args) == 1 {
+		// Read the file into a byte array so that we can parse the template
+		log.Printf("[INFO] Reading template: %s", args[0])
+		tplData, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
+			return 1
+		}
+
+		// Parse the template into a machine-usable format
+		log.Println("[DEBUG] Parsing template...")
+		tpl, err := packer.ParseTemplateFile(args[0], tplData)
+		if err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+			return 1
+		}
+
+		if err := cfgVars.SetVariables(tpl); err != nil {
+			env.Ui().Error(err.Error())
+			return 1
+		}
+
+		// Ask for the required variables that still have no value
+		if err := common.AskMissingVariables(env.Ui(), tpl); err != nil {
+			env.Ui().Error(err.Error())
+			return 1
+		}
+
+		// The values of sensitive variables are masked in everything that
+		// is shown or logged from now on.
+		packer.SetSensitiveValues(tpl.SensitiveValues())
+
+		userVars = tpl.Variables
+	}
+
+	repl(env.Ui(), &packer.ConfigTemplate{UserVars: userVars})
+	return 0
+}
+
+func (Command) Synopsis() string {
+	return "evaluate template expressions interactively"
+}
+
+// repl evaluates each line that the user gives as a template, until "exit"
+// or the end of the input. Errors are shown and the next line is read, so
+// that mistakes can be fixed by trying again.
+func repl(ui packer.Ui, t *packer.ConfigTemplate) {
+	for {
+		line, err := ui.Ask(">")
+		if err != nil {
+			log.Printf("[INFO] Console input ended: %s", err)
+			return
+		}
+
+		switch line {
+		case "":
+			continue
+		case "exit", "quit":
+			return
+		}
+
+		result, err := t.Process(line, nil)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error: %s", err))
+			continue
+		}
+
+		ui.Say(result)
+	}
+}
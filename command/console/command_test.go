@@ -0,0 +1,127 @@
+package console
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEnvironment(in string, out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: bytes.NewBufferString(in),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	defer packer.SetSensitiveValues(nil)
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "template.json")
+	data := `{
+		"variables": {"region": "us-east-1", "secret": "hunter2"},
+		"sensitive-variables": ["secret"],
+		"builders": [{"type": "foo"}]
+	}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	in := strings.Join([]string{
+		`{{user "region"}}`,
+		``,
+		`{{upper (user "region")}}`,
+		`{{user "nope"}}`,
+		`{{user "secret"}}`,
+		`exit`,
+		`{{user "region"}}`,
+	}, "\n")
+
+	out := new(bytes.Buffer)
+	args := []string{"-var", "region=eu-west-1", path}
+	if code := new(Command).Run(testEnvironment(in, out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("bad:\n%s", out.String())
+	}
+
+	if lines[0] != "> eu-west-1" || lines[1] != "> > EU-WEST-1" {
+		t.Fatalf("bad:\n%s", out.String())
+	}
+
+	if !strings.HasPrefix(lines[2], "> Error: ") ||
+		!strings.Contains(lines[2], "unknown user variable: nope") {
+		t.Fatalf("bad error: %s", lines[2])
+	}
+
+	// Evaluation stops at "exit"
+	if lines[3] != "> <sensitive>" || lines[4] != "> " {
+		t.Fatalf("bad:\n%s", out.String())
+	}
+}
+
+func TestCommand_Run_NoTemplate(t *testing.T) {
+	out := new(bytes.Buffer)
+	args := []string{"-var", "foo=bar"}
+	if code := new(Command).Run(testEnvironment(`{{user "foo"}}`, out), args); code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	if out.String() != "> bar\n> " {
+		t.Fatalf("bad: %q", out.String())
+	}
+}
+
+func TestCommand_Run_UnknownVariable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "template.json")
+	if err := ioutil.WriteFile(path, []byte(`{"builders": [{"type": "foo"}]}`), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	args := []string{"-var", "foo=bar", path}
+	if code := new(Command).Run(testEnvironment("", out), args); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestCommand_Run_Invalid(t *testing.T) {
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment("", out), []string{"i-better-not-exist"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
@@ -0,0 +1,19 @@
+package console
+
+const helpString = `
+Usage: packer console [options] [TEMPLATE]
+
+  Evaluates interpolation expressions, such as {{timestamp}} or
+  {{user "foo"}}, with the same functions that the configurations of
+  components are processed with. Each line that is read is evaluated
+  and the result is shown, until "exit" or the end of the input.
+
+  The user variables are those of the template, if one is given, and
+  those given with -var and -var-file. Expressions can also be piped
+  in, one per line.
+
+Options:
+
+  -var 'key=value'           Variable for the template, can be used multiple times.
+  -var-file=path             JSON file containing user variables.
+`
@@ -0,0 +1,79 @@
+package convert
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	var cfgOutput string
+
+	cmdFlags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.StringVar(&cfgOutput, "output", "", "path to write the template to")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	// Read the file into a byte array so that we can parse the template
+	log.Printf("[INFO] Reading template: %s", args[0])
+	tplData, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
+		return 1
+	}
+
+	// Parse the template first so that invalid templates are reported
+	// the same as they are by the other commands.
+	log.Println("[DEBUG] Parsing template...")
+	if _, err := packer.ParseTemplateFile(args[0], tplData); err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+		return 1
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(tplData, &raw); err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+		return 1
+	}
+
+	result, err := packer.FormatHCLTemplate(raw)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to convert template: %s", err))
+		return 1
+	}
+
+	if cfgOutput == "" {
+		env.Ui().Say(strings.TrimSpace(string(result)))
+		return 0
+	}
+
+	log.Printf("[INFO] Writing template: %s", cfgOutput)
+	if err := ioutil.WriteFile(cfgOutput, result, 0644); err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to write template: %s", err))
+		return 1
+	}
+
+	return 0
+}
+
+func (Command) Synopsis() string {
+	return "convert a JSON template to HCL"
+}
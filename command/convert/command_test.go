@@ -0,0 +1,89 @@
+package convert
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "template.json")
+	data := `{"builders": [{"type": "foo", "name": "bar"}]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	expected := "builder \"foo\" {\n  name = \"bar\"\n}\n"
+	if out.String() != expected {
+		t.Fatalf("bad: %q", out.String())
+	}
+
+	output := filepath.Join(dir, "template.hcl")
+	args := []string{"-output", output, path}
+	if code := new(Command).Run(testEnvironment(new(bytes.Buffer)), args); code != 0 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	tplData, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl, err := packer.ParseTemplateFile(output, tplData)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if names := tpl.BuildNames(); len(names) != 1 || names[0] != "bar" {
+		t.Fatalf("bad: %#v", names)
+	}
+
+	// Invalid templates are errors
+	ioutil.WriteFile(path, []byte(`{"builders": [}`), 0644)
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+
+	if !strings.Contains(out.String(), "Failed to parse template") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
@@ -0,0 +1,14 @@
+package convert
+
+const helpString = `
+Usage: packer convert [options] TEMPLATE
+
+  Converts a JSON template to HCL, which is easier to read and allows
+  comments and strings over multiple lines. The HCL template is written
+  to the standard output, or to the file given with -output. HCL
+  templates are read from files whose names end in .hcl.
+
+Options:
+
+  -output=path        Path to write the HCL template to.
+`
@@ -0,0 +1,101 @@
+package fix
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/fix"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	var fixes bytes.Buffer
+	for _, name := range fix.FixerOrder {
+		fmt.Fprintf(&fixes, "  %-14s  %s\n", name, fix.Fixers[name].Synopsis())
+	}
+
+	return strings.TrimSpace(fmt.Sprintf(helpString, strings.TrimRight(fixes.String(), "\n")))
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	var cfgOutput string
+
+	cmdFlags := flag.NewFlagSet("fix", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.StringVar(&cfgOutput, "output", "", "path to write the fixed template to")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	// Read the file into a byte array so that we can parse the template
+	log.Printf("[INFO] Reading template: %s", args[0])
+	tplData, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
+		return 1
+	}
+
+	// The template is fixed as it is decoded, since it may not be valid
+	// until it is fixed.
+	var tpl map[string]interface{}
+	if err := json.Unmarshal(tplData, &tpl); err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+		return 1
+	}
+
+	changed := false
+	for _, name := range fix.FixerOrder {
+		log.Printf("[INFO] Running fixer: %s", name)
+		changes, err := fix.Fixers[name].Fix(tpl)
+		if err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to fix template with '%s': %s", name, err))
+			return 1
+		}
+
+		for _, change := range changes {
+			changed = true
+			env.Ui().Say(fmt.Sprintf("%s: %s", name, change))
+		}
+	}
+
+	if !changed {
+		env.Ui().Say("The template doesn't need to be fixed.")
+		return 0
+	}
+
+	if cfgOutput == "" {
+		env.Ui().Say("\nGive -output to write the fixed template.")
+		return 0
+	}
+
+	result, err := packer.FormatJSONTemplate(tpl)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to write template: %s", err))
+		return 1
+	}
+
+	log.Printf("[INFO] Writing template: %s", cfgOutput)
+	if err := ioutil.WriteFile(cfgOutput, result, 0644); err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to write template: %s", err))
+		return 1
+	}
+
+	env.Ui().Say(fmt.Sprintf("\nWrote the fixed template to: %s", cfgOutput))
+	return 0
+}
+
+func (Command) Synopsis() string {
+	return "fix templates written for older versions of Packer"
+}
@@ -0,0 +1,122 @@
+package fix
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "template.json")
+	data := `{"builders": [{"type": "virtualbox", "iso_md5": "foo"}]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Without -output, the changes are only shown
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "iso-md5: builders[0]: iso_md5 is now iso_checksum") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(written) != data {
+		t.Fatalf("should not write the template: %s", written)
+	}
+
+	out.Reset()
+	args := []string{"-output", path, path}
+	if code := new(Command).Run(testEnvironment(out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	written, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var tpl map[string]interface{}
+	if err := json.Unmarshal(written, &tpl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"builders": []interface{}{
+			map[string]interface{}{
+				"type":              "virtualbox",
+				"iso_checksum":      "foo",
+				"iso_checksum_type": "md5",
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(tpl, expected) {
+		t.Fatalf("bad: %#v", tpl)
+	}
+
+	// The fixed template doesn't need fixing
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "doesn't need to be fixed") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestCommand_Run_Invalid(t *testing.T) {
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"i-better-not-exist"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestCommand_Help(t *testing.T) {
+	if !strings.Contains(new(Command).Help(), "iso-md5") {
+		t.Fatal("help should list the fixers")
+	}
+}
@@ -0,0 +1,18 @@
+package fix
+
+const helpString = `
+Usage: packer fix [options] TEMPLATE
+
+  Fixes a JSON template written for an older version of Packer, so that
+  it uses the current template syntax, and shows what was changed. The
+  fixed template is only written if -output is given, and can be written
+  over the template itself.
+
+Options:
+
+  -output=path    Write the fixed template to the file at the path.
+
+Fixes:
+
+%s
+`
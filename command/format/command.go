@@ -0,0 +1,98 @@
+package format
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	var cfgCheck bool
+
+	cmdFlags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.BoolVar(&cfgCheck, "check", false, "check that the templates are formatted")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) == 0 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	failed := false
+	for _, path := range args {
+		changed, err := format(path, !cfgCheck)
+		if err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to format template %s: %s", path, err))
+			failed = true
+			continue
+		}
+
+		if changed {
+			env.Ui().Say(path)
+
+			// Templates that aren't formatted fail the check
+			if cfgCheck {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+func (Command) Synopsis() string {
+	return "rewrite templates in the canonical style"
+}
+
+// format formats the template at the path, and returns whether it wasn't
+// formatted already. The formatted template is only written if write is
+// true.
+func format(path string, write bool) (bool, error) {
+	log.Printf("[INFO] Reading template: %s", path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := packer.FormatTemplate(path, data)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(data, result) {
+		return false, nil
+	}
+
+	if write {
+		log.Printf("[INFO] Writing template: %s", path)
+		if err := ioutil.WriteFile(path, result, info.Mode()); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,110 @@
+package format
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	formatted := filepath.Join(dir, "formatted.json")
+	data := "{\n  \"builders\": [\n    {\n      \"type\": \"foo\"\n    }\n  ]\n}\n"
+	if err := ioutil.WriteFile(formatted, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	path := filepath.Join(dir, "template.json")
+	if err := ioutil.WriteFile(path, []byte(`{"builders": [{"type": "foo"}]}`), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// With -check, nothing is written and unformatted templates fail
+	out := new(bytes.Buffer)
+	args := []string{"-check", formatted, path}
+	if code := new(Command).Run(testEnvironment(out), args); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if out.String() != path+"\n" {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(written) == data {
+		t.Fatal("should not write the template")
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{formatted, path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if out.String() != path+"\n" {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	written, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(written) != data {
+		t.Fatalf("bad: %s", written)
+	}
+
+	// Both are formatted now
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{"-check", formatted, path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+}
+
+func TestCommand_Run_NoArgs(t *testing.T) {
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestCommand_Run_Invalid(t *testing.T) {
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"i-better-not-exist"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
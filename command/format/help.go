@@ -0,0 +1,16 @@
+package format
+
+const helpString = `
+Usage: packer fmt [options] TEMPLATE...
+
+  Rewrites templates in the canonical style of their format, with sorted
+  keys and two spaces of indentation, and lists the templates that were
+  changed. Templates whose names end in .hcl are formatted as HCL, and
+  the others as JSON. HCL templates with comments aren't formatted,
+  since the comments would be lost.
+
+Options:
+
+  -check    Don't write the templates, and fail if any of them aren't
+            formatted. This is meant for continuous integration.
+`
@@ -0,0 +1,179 @@
+package initialize
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/command/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	var cfgUpgrade bool
+	var cfgVars common.UserVarFlags
+
+	cmdFlags := flag.NewFlagSet("init", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.BoolVar(&cfgUpgrade, "upgrade", false, "install the newest releases")
+	cfgVars.AddFlags(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	dir := os.Getenv(packer.PluginDirEnvVar)
+	if dir == "" {
+		env.Ui().Error("The plugins directory of Packer couldn't be found")
+		return 1
+	}
+
+	// Read the file into a byte array so that we can parse the template
+	log.Printf("[INFO] Reading template: %s", args[0])
+	tplData, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
+		return 1
+	}
+
+	// Parse the template into a machine-usable format
+	log.Println("[DEBUG] Parsing template...")
+	tpl, err := packer.ParseTemplateFile(args[0], tplData)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+		return 1
+	}
+
+	// The sources of the plugins can use user variables
+	if err := cfgVars.SetVariables(tpl); err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	if len(tpl.RequiredPlugins) == 0 {
+		env.Ui().Say("The template requires no plugins.")
+		return 0
+	}
+
+	plugins, err := common.ListPlugins(dir)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Error listing plugins: %s", err))
+		return 1
+	}
+
+	// Binaries are installed with ".exe" on Windows, which the
+	// template doesn't name.
+	installed := make(map[string]*common.PluginInfo)
+	for _, plugin := range plugins {
+		name := plugin.Name
+		if runtime.GOOS == "windows" {
+			name = strings.TrimSuffix(name, ".exe")
+		}
+
+		installed[name] = plugin
+	}
+
+	names := make([]string, 0, len(tpl.RequiredPlugins))
+	for name := range tpl.RequiredPlugins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	errors := make([]error, 0)
+	for _, name := range names {
+		if err := c.install(env, tpl, dir, name, installed[name], cfgUpgrade); err != nil {
+			errors = append(errors, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		env.Ui().Error(fmt.Sprintf("Error installing plugins:\n\n%s", &packer.MultiError{errors}))
+		return 1
+	}
+
+	return 0
+}
+
+// install installs the required plugin with the name, unless the release
+// that is installed satisfies the template.
+func (Command) install(env packer.Environment, tpl *packer.Template, dir, name string, installed *common.PluginInfo, upgrade bool) error {
+	required := tpl.RequiredPlugins[name]
+
+	// A binary that changed since it was installed, or that wasn't
+	// installed by Packer, has no version that can be trusted.
+	current := ""
+	if installed != nil && !installed.Modified {
+		current = installed.Version
+	}
+
+	satisfied := false
+	if current != "" {
+		ok, err := packer.VersionSatisfies(current, required.Version)
+		if err != nil {
+			return err
+		}
+
+		satisfied = ok
+	}
+
+	// A plugin without a source is installed by hand, so it can only be
+	// checked, and its version is only known if a constraint needs it.
+	if required.Source == "" {
+		switch {
+		case satisfied:
+			env.Ui().Say(fmt.Sprintf("%s %s is installed", name, current))
+		case installed != nil && required.Version == "":
+			env.Ui().Say(fmt.Sprintf("%s is installed", name))
+		default:
+			return fmt.Errorf(
+				"isn't installed with a version that satisfies '%s', and has no source to install from",
+				required.Version)
+		}
+
+		return nil
+	}
+
+	if satisfied && !upgrade {
+		env.Ui().Say(fmt.Sprintf("%s %s is installed", name, current))
+		return nil
+	}
+
+	version, checksum, err := required.Release(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+
+	if current == version && strings.EqualFold(installed.SHA256, checksum) {
+		env.Ui().Say(fmt.Sprintf("%s %s is installed", name, current))
+		return nil
+	}
+
+	source, err := tpl.RequiredPluginSource(name, version)
+	if err != nil {
+		return err
+	}
+
+	env.Ui().Say(fmt.Sprintf("Installing %s %s from %s", name, version, source))
+	_, err = common.InstallPlugin(dir, source, name, checksum, version)
+	return err
+}
+
+func (Command) Synopsis() string {
+	return "install the plugins a template requires"
+}
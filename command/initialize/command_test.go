@@ -0,0 +1,248 @@
+package initialize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mitchellh/packer/command/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func testChecksum(data string) string {
+	hash := sha256.New()
+	hash.Write([]byte(data))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// testTemplate creates a plugins directory, which the command uses while
+// the test runs, and a template that requires a plugin whose releases
+// are in a releases directory.
+func testTemplate(t *testing.T, constraint string) (string, string, string) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	pluginDir := filepath.Join(dir, "plugins")
+	if err := os.Setenv(packer.PluginDirEnvVar, pluginDir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	checksums := make([]string, 0)
+	for _, version := range []string{"0.1.0", "0.2.0", "0.2.1"} {
+		releaseDir := filepath.Join(dir, "releases", version)
+		if err := os.MkdirAll(releaseDir, 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		binary := "release " + version
+		path := filepath.Join(releaseDir, "packer-builder-custom-cloud")
+		if err := ioutil.WriteFile(path, []byte(binary), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		checksums = append(checksums, fmt.Sprintf("%q: %q", version, testChecksum(binary)))
+	}
+
+	tpl := fmt.Sprintf(`
+	{
+		"variables": {"releases": %q},
+		"required_plugins": {
+			"packer-builder-custom-cloud": {
+				"version": %q,
+				"source": "{{user `+"`releases`"+`}}/{{.Version}}/packer-builder-custom-cloud",
+				"checksums": {%s}
+			}
+		},
+		"builders": [{"type": "custom-cloud"}]
+	}
+	`, filepath.Join(dir, "releases"), constraint, strings.Join(checksums, ", "))
+
+	path := filepath.Join(dir, "template.json")
+	if err := ioutil.WriteFile(path, []byte(tpl), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir, pluginDir, path
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	dir, pluginDir, path := testTemplate(t, "~> 0.2.0")
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Installing packer-builder-custom-cloud 0.2.1") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(pluginDir, "packer-builder-custom-cloud"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "release 0.2.1" {
+		t.Fatalf("bad: %s", data)
+	}
+
+	// A release that satisfies the template isn't installed again
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if strings.TrimSpace(out.String()) != "packer-builder-custom-cloud 0.2.1 is installed" {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestCommand_RunUpgrade(t *testing.T) {
+	dir, pluginDir, path := testTemplate(t, ">= 0.1.0")
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	source := filepath.Join(dir, "releases", "0.1.0", "packer-builder-custom-cloud")
+	if _, err := common.InstallPlugin(pluginDir, source, "", "", "0.1.0"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The installed release satisfies the template
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "0.1.0 is installed") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{"-upgrade", path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Installing packer-builder-custom-cloud 0.2.1") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	installed, err := common.ReadInstalledPlugins(pluginDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if installed["packer-builder-custom-cloud"].Version != "0.2.1" {
+		t.Fatalf("bad: %#v", installed["packer-builder-custom-cloud"])
+	}
+}
+
+func TestCommand_RunChecksumMismatch(t *testing.T) {
+	dir, pluginDir, path := testTemplate(t, "0.2.0")
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	release := filepath.Join(dir, "releases", "0.2.0", "packer-builder-custom-cloud")
+	if err := ioutil.WriteFile(release, []byte("tampered"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Checksum of") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	if _, err := os.Stat(filepath.Join(pluginDir, "packer-builder-custom-cloud")); !os.IsNotExist(err) {
+		t.Fatalf("should not be installed: %s", err)
+	}
+}
+
+func TestCommand_RunNoRelease(t *testing.T) {
+	dir, _, path := testTemplate(t, ">= 1.0")
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "satisfies the version constraint '>= 1.0'") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestCommand_RunNoSource(t *testing.T) {
+	dir, pluginDir, _ := testTemplate(t, "")
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	path := filepath.Join(dir, "local.json")
+	tpl := `{"required_plugins": {"packer-provisioner-local": {}}, "builders": [{"type": "foo"}]}`
+	if err := ioutil.WriteFile(path, []byte(tpl), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "no source to install from") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	// A plugin that is installed by hand satisfies it
+	if err := os.MkdirAll(pluginDir, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	binary := filepath.Join(pluginDir, "packer-provisioner-local")
+	if err := ioutil.WriteFile(binary, []byte("local"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if strings.TrimSpace(out.String()) != "packer-provisioner-local is installed" {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
@@ -0,0 +1,18 @@
+package initialize
+
+const helpString = `
+Usage: packer init [options] TEMPLATE
+
+  Installs the plugins that the template requires into the plugins
+  directory of Packer, so that the template can be built. For each
+  plugin, the newest release that satisfies its version constraint and
+  has a checksum in the template is installed, unless a release that
+  satisfies it already is.
+
+Options:
+
+  -upgrade            Install the newest release of each plugin, even if
+                      one that satisfies the template is installed.
+  -var 'key=value'    Variable for templates, can be used multiple times.
+  -var-file=path      JSON file containing user variables.
+`
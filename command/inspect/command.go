@@ -0,0 +1,180 @@
+package inspect
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	cmdFlags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	// Read the file into a byte array so that we can parse the template
+	log.Printf("[INFO] Reading template: %s", args[0])
+	tplData, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
+		return 1
+	}
+
+	// Parse the template into a machine-usable format
+	log.Println("[DEBUG] Parsing template...")
+	tpl, err := packer.ParseTemplateFile(args[0], tplData)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+		return 1
+	}
+
+	// The defaults of sensitive variables are secrets as well
+	packer.SetSensitiveValues(tpl.SensitiveValues())
+
+	inspect(env.Ui(), tpl)
+	return 0
+}
+
+// inspect shows the template, both for humans and as machine-readable
+// events. Everything is sorted by name, except for the provisioners and
+// post-processors, which are in the order they run.
+func inspect(ui packer.Ui, tpl *packer.Template) {
+	var out bytes.Buffer
+
+	sensitive := make(map[string]bool)
+	for _, name := range tpl.SensitiveVariables {
+		sensitive[name] = true
+	}
+
+	variables := make([]string, 0, len(tpl.Variables))
+	for name, _ := range tpl.Variables {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+
+	out.WriteString("Variables:\n")
+	for _, name := range variables {
+		value := tpl.Variables[name]
+		shown := strconv.Quote(value)
+		if sensitive[name] {
+			value = packer.SensitiveMask
+			shown = value
+		}
+
+		fmt.Fprintf(&out, "  %s = %s\n", name, shown)
+		ui.Machine("template-variable", name, value, boolField(sensitive[name]))
+	}
+	writeNone(&out, len(variables))
+
+	builders := tpl.BuildNames()
+	sort.Strings(builders)
+
+	out.WriteString("\nBuilders:\n")
+	for _, name := range builders {
+		builderType := tpl.Builders[name].Type
+		fmt.Fprintf(&out, "  %s (type: %s)\n", name, builderType)
+		ui.Machine("template-builder", name, builderType)
+	}
+	writeNone(&out, len(builders))
+
+	out.WriteString("\nProvisioners:\n")
+	for _, p := range tpl.Provisioners {
+		overrides := make([]string, 0, len(p.Override))
+		for name, _ := range p.Override {
+			overrides = append(overrides, name)
+		}
+		sort.Strings(overrides)
+
+		fmt.Fprintf(&out, "  %s", p.Type)
+		if len(overrides) > 0 {
+			fmt.Fprintf(&out, " (overridden for: %s)", strings.Join(overrides, ", "))
+		}
+		out.WriteString(buildFilter(p.Only, p.Except))
+		out.WriteString("\n")
+
+		ui.Machine("template-provisioner", p.Type, strings.Join(overrides, ","))
+	}
+	writeNone(&out, len(tpl.Provisioners))
+
+	out.WriteString("\nPost-processors:\n")
+	for i, sequence := range tpl.PostProcessors {
+		types := make([]string, len(sequence))
+		for j, pp := range sequence {
+			types[j] = pp.Type + buildFilter(pp.Only, pp.Except)
+			ui.Machine("template-post-processor", strconv.Itoa(i), pp.Type,
+				boolField(pp.KeepInputArtifact != nil && *pp.KeepInputArtifact))
+		}
+
+		fmt.Fprintf(&out, "  %d: %s\n", i, strings.Join(types, " -> "))
+	}
+	writeNone(&out, len(tpl.PostProcessors))
+
+	hooks := make([]string, 0, len(tpl.Hooks))
+	for name, _ := range tpl.Hooks {
+		hooks = append(hooks, name)
+	}
+	sort.Strings(hooks)
+
+	out.WriteString("\nHooks:\n")
+	for _, name := range hooks {
+		fmt.Fprintf(&out, "  %s: %s\n", name, strings.Join(tpl.Hooks[name], ", "))
+		for _, hook := range tpl.Hooks[name] {
+			ui.Machine("template-hook", name, hook)
+		}
+	}
+	writeNone(&out, len(hooks))
+
+	ui.Say(strings.TrimSpace(out.String()))
+}
+
+// writeNone says that a section of the output is empty, if it is.
+func writeNone(out *bytes.Buffer, n int) {
+	if n == 0 {
+		out.WriteString("  <none>\n")
+	}
+}
+
+func boolField(b bool) string {
+	if b {
+		return "true"
+	}
+
+	return "false"
+}
+
+// buildFilter describes the builds a provisioner or post-processor runs
+// for, if it doesn't run for all of them.
+func buildFilter(only []string, except []string) string {
+	if len(only) > 0 {
+		return fmt.Sprintf(" (only: %s)", strings.Join(only, ", "))
+	}
+
+	if len(except) > 0 {
+		return fmt.Sprintf(" (except: %s)", strings.Join(except, ", "))
+	}
+
+	return ""
+}
+
+func (Command) Synopsis() string {
+	return "see the components of a template"
+}
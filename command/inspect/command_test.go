@@ -0,0 +1,108 @@
+package inspect
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	defer packer.SetSensitiveValues(nil)
+
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "template.json")
+	data := `{
+		"variables": {"region": "us-east-1", "secret": "hunter2"},
+		"sensitive-variables": ["secret"],
+		"builders": [
+			{"type": "foo", "name": "b"},
+			{"type": "foo", "name": "a"}
+		],
+		"provisioners": [
+			{"type": "shell", "override": {"b": {}}},
+			{"type": "file", "only": ["a"]}
+		],
+		"post-processors": ["vagrant", ["compress", {"type": "upload", "except": ["b"]}]]
+	}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{path}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	expected := `Variables:
+  region = "us-east-1"
+  secret = <sensitive>
+
+Builders:
+  a (type: foo)
+  b (type: foo)
+
+Provisioners:
+  shell (overridden for: b)
+  file (only: a)
+
+Post-processors:
+  0: vagrant
+  1: compress -> upload (except: b)
+
+Hooks:
+  <none>
+`
+	if out.String() != expected {
+		t.Fatalf("bad:\n%s", out.String())
+	}
+
+	if strings.Contains(out.String(), "hunter2") {
+		t.Fatal("should not show sensitive values")
+	}
+}
+
+func TestCommand_Run_NoArgs(t *testing.T) {
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestCommand_Run_Invalid(t *testing.T) {
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"i-better-not-exist"}); code != 1 {
+		t.Fatalf("bad: %d", code)
+	}
+}
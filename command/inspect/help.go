@@ -0,0 +1,14 @@
+package inspect
+
+const helpString = `
+Usage: packer inspect TEMPLATE
+
+  Shows the variables, builders, provisioners, post-processors and hooks
+  of a template, sorted so the output stays the same for the same
+  template. The configurations of the components aren't checked; use
+  "packer validate" for that.
+  Templates whose names end in .hcl are read as HCL, and those whose
+  names end in .yml or .yaml as YAML.
+
+  With -machine-readable, each of them is also a template-* event.
+`
@@ -0,0 +1,132 @@
+package plugins
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/packer/command/common"
+	"github.com/mitchellh/packer/packer"
+	"os"
+	"strings"
+)
+
+type Command byte
+
+func (Command) Help() string {
+	return strings.TrimSpace(helpString)
+}
+
+func (c Command) Run(env packer.Environment, args []string) int {
+	if len(args) == 0 {
+		env.Ui().Say(c.Help())
+		return 1
+	}
+
+	dir := os.Getenv(packer.PluginDirEnvVar)
+	if dir == "" {
+		env.Ui().Error("The plugins directory of Packer couldn't be found")
+		return 1
+	}
+
+	switch args[0] {
+	case "install":
+		return c.install(env, dir, args[1:])
+	case "list":
+		return c.list(env, dir, args[1:])
+	case "remove":
+		return c.remove(env, dir, args[1:])
+	default:
+		env.Ui().Error(fmt.Sprintf("Unknown subcommand: %s\n", args[0]))
+		env.Ui().Say(c.Help())
+		return 1
+	}
+}
+
+func (c Command) install(env packer.Environment, dir string, args []string) int {
+	var cfgChecksum string
+	var cfgName string
+	var cfgVersion string
+
+	cmdFlags := flag.NewFlagSet("plugins install", flag.ContinueOnError)
+	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.StringVar(&cfgChecksum, "checksum", "", "SHA256 checksum of the binary")
+	cmdFlags.StringVar(&cfgName, "name", "", "binary name to install as")
+	cmdFlags.StringVar(&cfgVersion, "version", "", "version of the plugin")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		cmdFlags.Usage()
+		return 1
+	}
+
+	plugin, err := common.InstallPlugin(dir, args[0], cfgName, cfgChecksum, cfgVersion)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Error installing plugin: %s", err))
+		return 1
+	}
+
+	env.Ui().Say(fmt.Sprintf("Installed %s into %s", args[0], dir))
+	env.Ui().Say(fmt.Sprintf("SHA256: %s", plugin.SHA256))
+	return 0
+}
+
+func (c Command) list(env packer.Environment, dir string, args []string) int {
+	if len(args) != 0 {
+		env.Ui().Say(c.Help())
+		return 1
+	}
+
+	plugins, err := common.ListPlugins(dir)
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Error listing plugins: %s", err))
+		return 1
+	}
+
+	if len(plugins) == 0 {
+		env.Ui().Say(fmt.Sprintf("No plugins are installed in %s", dir))
+		return 0
+	}
+
+	nameWidth := 0
+	for _, plugin := range plugins {
+		if len(plugin.Name) > nameWidth {
+			nameWidth = len(plugin.Name)
+		}
+	}
+
+	for _, plugin := range plugins {
+		version := plugin.Version
+		if version == "" {
+			version = "unknown"
+		}
+
+		if plugin.Modified {
+			version += " (changed since installed)"
+		}
+
+		env.Ui().Say(fmt.Sprintf("%-*s  %s  %s", nameWidth, plugin.Name, plugin.SHA256, version))
+	}
+
+	return 0
+}
+
+func (c Command) remove(env packer.Environment, dir string, args []string) int {
+	if len(args) != 1 {
+		env.Ui().Say(c.Help())
+		return 1
+	}
+
+	if err := common.RemovePlugin(dir, args[0]); err != nil {
+		env.Ui().Error(fmt.Sprintf("Error removing plugin: %s", err))
+		return 1
+	}
+
+	env.Ui().Say(fmt.Sprintf("Removed %s", args[0]))
+	return 0
+}
+
+func (Command) Synopsis() string {
+	return "install, list and remove plugins"
+}
@@ -0,0 +1,260 @@
+package plugins
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mitchellh/packer/command/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testBinary = "#!/bin/sh\necho plugin\n"
+
+func testEnvironment(out *bytes.Buffer) packer.Environment {
+	config := packer.DefaultEnvironmentConfig()
+	config.Ui = &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	env, err := packer.NewEnvironment(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return env
+}
+
+// testPluginDir creates a plugins directory, which the command uses
+// while the test runs, and a source directory with a plugin binary.
+func testPluginDir(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	pluginDir := filepath.Join(dir, "plugins")
+	if err := os.Setenv(packer.PluginDirEnvVar, pluginDir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	source := filepath.Join(dir, "packer-builder-custom-cloud")
+	if err := ioutil.WriteFile(source, []byte(testBinary), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir, pluginDir
+}
+
+func testChecksum(data string) string {
+	hash := sha256.New()
+	hash.Write([]byte(data))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func TestCommand_Implements(t *testing.T) {
+	var raw interface{}
+	raw = new(Command)
+	if _, ok := raw.(packer.Command); !ok {
+		t.Fatal("should be a Command")
+	}
+}
+
+func TestCommand_InstallListRemove(t *testing.T) {
+	dir, pluginDir := testPluginDir(t)
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	source := filepath.Join(dir, "packer-builder-custom-cloud")
+	checksum := testChecksum(testBinary)
+
+	out := new(bytes.Buffer)
+	args := []string{"install", "-checksum", checksum, "-version", "0.2.0", source}
+	if code := new(Command).Run(testEnvironment(out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	target := filepath.Join(pluginDir, "packer-builder-custom-cloud")
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if info.Mode()&0111 == 0 {
+		t.Fatalf("should be executable: %s", info.Mode())
+	}
+
+	// A binary that isn't installed with the command has no version
+	other := filepath.Join(pluginDir, "packer-provisioner-other")
+	if err := ioutil.WriteFile(other, []byte("other"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{"list"}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	expected := fmt.Sprintf(
+		"packer-builder-custom-cloud  %s  0.2.0\npacker-provisioner-other     %s  unknown\n",
+		checksum, testChecksum("other"))
+	if out.String() != expected {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	// A binary that changed since it was installed is shown
+	if err := ioutil.WriteFile(target, []byte("changed"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), []string{"list"}); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "0.2.0 (changed since installed)") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	out.Reset()
+	args = []string{"remove", "packer-builder-custom-cloud"}
+	if code := new(Command).Run(testEnvironment(out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("should be removed: %s", err)
+	}
+
+	index, err := common.ReadInstalledPlugins(pluginDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(index) != 0 {
+		t.Fatalf("bad: %#v", index)
+	}
+
+	out.Reset()
+	if code := new(Command).Run(testEnvironment(out), args); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Plugin isn't installed") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestCommand_InstallChecksumMismatch(t *testing.T) {
+	dir, pluginDir := testPluginDir(t)
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	source := filepath.Join(dir, "packer-builder-custom-cloud")
+	out := new(bytes.Buffer)
+	args := []string{"install", "-checksum", testChecksum("other"), source}
+	if code := new(Command).Run(testEnvironment(out), args); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Checksum of") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	infos, err := ioutil.ReadDir(pluginDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(infos) != 0 {
+		t.Fatalf("nothing should be installed: %#v", infos)
+	}
+}
+
+func TestCommand_InstallName(t *testing.T) {
+	dir, _ := testPluginDir(t)
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	source := filepath.Join(dir, "custom-cloud")
+	if err := ioutil.WriteFile(source, []byte(testBinary), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"install", source}); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "isn't the name of a plugin binary") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	out.Reset()
+	args := []string{"install", "-name", "packer-builder-custom-cloud", source}
+	if code := new(Command).Run(testEnvironment(out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+}
+
+func TestCommand_InstallURL(t *testing.T) {
+	dir, pluginDir := testPluginDir(t)
+	defer os.RemoveAll(dir)
+	defer os.Setenv(packer.PluginDirEnvVar, "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/0.2.0/packer-builder-custom-cloud" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(testBinary))
+	}))
+	defer server.Close()
+
+	source := server.URL + "/0.2.0/packer-builder-custom-cloud"
+
+	// A binary from a URL must be given a checksum
+	out := new(bytes.Buffer)
+	if code := new(Command).Run(testEnvironment(out), []string{"install", source}); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "-checksum is required") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	out.Reset()
+	args := []string{"install", "-checksum", testChecksum(testBinary), source}
+	if code := new(Command).Run(testEnvironment(out), args); code != 0 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	index, err := common.ReadInstalledPlugins(pluginDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	plugin := index["packer-builder-custom-cloud"]
+	if plugin == nil || plugin.Source != source {
+		t.Fatalf("bad: %#v", index)
+	}
+
+	out.Reset()
+	args = []string{"install", "-checksum", testChecksum(testBinary), server.URL + "/packer-builder-missing"}
+	if code := new(Command).Run(testEnvironment(out), args); code != 1 {
+		t.Fatalf("bad: %d %s", code, out.String())
+	}
+
+	if !strings.Contains(out.String(), "404") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
@@ -0,0 +1,25 @@
+package plugins
+
+const helpString = `
+Usage: packer plugins SUBCOMMAND [options] [args]
+
+  Manages the plugins in the plugins directory of Packer, which are
+  discovered by the names of their binaries, such as
+  "packer-builder-custom-cloud" for the "custom-cloud" builder.
+
+Subcommands:
+
+  install [options] SOURCE    Installs the plugin binary at the URL or
+                              local path. Binaries from a URL must be
+                              given a -checksum.
+  list                        Lists the installed plugins, with their
+                              versions and checksums.
+  remove NAME                 Removes the plugin with the binary name.
+
+Install options:
+
+  -checksum=sha256    SHA256 checksum the binary must have.
+  -name=name          Binary name to install the plugin as, if the source
+                      isn't named like a plugin.
+  -version=version    Version of the plugin, shown by list.
+`
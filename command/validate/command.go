@@ -3,6 +3,7 @@ package validate
 import (
 	"flag"
 	"fmt"
+	"github.com/mitchellh/packer/command/common"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"log"
@@ -16,11 +17,17 @@ func (Command) Help() string {
 }
 
 func (c Command) Run(env packer.Environment, args []string) int {
+	var cfgEvaluateVars bool
+	var cfgStrict bool
 	var cfgSyntaxOnly bool
+	var cfgVars common.UserVarFlags
 
 	cmdFlags := flag.NewFlagSet("validate", flag.ContinueOnError)
 	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdFlags.BoolVar(&cfgStrict, "strict", false, "unknown keys are errors")
 	cmdFlags.BoolVar(&cfgSyntaxOnly, "syntax-only", false, "check syntax only")
+	cmdFlags.BoolVar(&cfgEvaluateVars, "evaluate-vars", false, "check all uses of variables first")
+	cfgVars.AddFlags(cmdFlags)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -31,8 +38,13 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		return 1
 	}
 
+	if cfgSyntaxOnly && cfgEvaluateVars {
+		env.Ui().Error("Only one of -syntax-only and -evaluate-vars can be given")
+		return 1
+	}
+
 	// Read the file into a byte array so that we can parse the template
-	log.Printf("Reading template: %s", args[0])
+	log.Printf("[INFO] Reading template: %s", args[0])
 	tplData, err := ioutil.ReadFile(args[0])
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to read template file: %s", err))
@@ -40,18 +52,57 @@ func (c Command) Run(env packer.Environment, args []string) int {
 	}
 
 	// Parse the template into a machine-usable format
-	log.Println("Parsing template...")
-	tpl, err := packer.ParseTemplate(tplData)
+	log.Println("[DEBUG] Parsing template...")
+	tpl, err := packer.ParseTemplateFile(args[0], tplData)
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
 		return 1
 	}
 
+	if cfgStrict {
+		if err := tpl.CheckUnknownKeys(); err != nil {
+			env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
+			return 1
+		}
+	}
+
 	if cfgSyntaxOnly {
 		env.Ui().Say("Syntax-only check passed. Everything looks okay.")
 		return 0
 	}
 
+	// Dubious parts of the template are only warned about
+	common.ShowLintWarnings(env.Ui(), common.LintTemplate(args[0], tplData, tpl))
+
+	// Set the user variables given on the command line
+	if err := cfgVars.SetVariables(tpl); err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	// Ask for the required variables that still have no value, unless
+	// they are checked with everything else that uses variables.
+	if !cfgEvaluateVars {
+		if err := common.AskMissingVariables(env.Ui(), tpl); err != nil {
+			env.Ui().Error(err.Error())
+			return 1
+		}
+	}
+
+	// The values of sensitive variables are masked in everything that
+	// is shown or logged from now on.
+	packer.SetSensitiveValues(tpl.SensitiveValues())
+
+	// Every use of a variable is checked at once, before the builds,
+	// whose errors would only show the first of each setting.
+	if cfgEvaluateVars {
+		if err := tpl.CheckVariables(); err != nil {
+			env.Ui().Error(fmt.Sprintf(
+				"Template validation failed. Errors evaluating variables:\n\n%s", err))
+			return 1
+		}
+	}
+
 	errs := make([]error, 0)
 
 	// The component finder for our builds
@@ -60,13 +111,14 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		Hook:          env.Hook,
 		PostProcessor: env.PostProcessor,
 		Provisioner:   env.Provisioner,
+		Communicators: env.Communicators(),
 	}
 
 	// Otherwise, get all the builds
 	buildNames := tpl.BuildNames()
 	builds := make([]packer.Build, 0, len(buildNames))
 	for _, buildName := range buildNames {
-		log.Printf("Creating build from template for: %s", buildName)
+		log.Printf("[INFO] Creating build from template for: %s", buildName)
 		build, err := tpl.Build(buildName, components)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("Build '%s': %s", buildName, err))
@@ -78,7 +130,8 @@ func (c Command) Run(env packer.Environment, args []string) int {
 
 	// Check the configuration of all builds
 	for _, b := range builds {
-		log.Printf("Preparing build: %s", b.Name())
+		log.Printf("[INFO] Preparing build: %s", b.Name())
+		b.SetStrict(cfgStrict)
 		err := b.Prepare()
 		if err != nil {
 			errs = append(errs, fmt.Errorf("Errors validating build '%s'. %s", b.Name(), err))
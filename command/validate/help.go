@@ -5,6 +5,8 @@ Usage: packer validate [options] TEMPLATE
 
   Checks the template is valid by parsing the template and also
   checking the configuration with the various builders, provisioners, etc.
+  Templates whose names end in .hcl are read as HCL, and those whose
+  names end in .yml or .yaml as YAML.
 
   If it is not valid, the errors will be shown and the command will exit
   with a non-zero exit status. If it is valid, it will exit with a zero
@@ -12,5 +14,10 @@ Usage: packer validate [options] TEMPLATE
 
 Options:
 
+  -evaluate-vars      Process every setting that uses variables first, and
+                      show every variable that is unknown or not set at once.
+  -strict             Unknown keys in the configuration are errors.
   -syntax-only        Only check syntax. Do not verify config of the template.
+  -var 'key=value'    Variable for templates, can be used multiple times.
+  -var-file=path      JSON file containing user variables.
 `
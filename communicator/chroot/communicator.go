@@ -0,0 +1,331 @@
+// Package chroot provides a packer.Communicator for a file system that is
+// mounted on the host, such as the root volume of a machine that is never
+// booted. Commands are run in it with chroot, and files are copied on the
+// host directly.
+package chroot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// The maximum number of symlinks that are followed when resolving a path,
+// so that symlink loops are an error.
+const maxSymlinks = 255
+
+type comm struct {
+	config *Config
+}
+
+// Config is the structure used to configure the chroot communicator.
+type Config struct {
+	// The directory on the host that the file system is mounted at.
+	Chroot string
+
+	// The path of the chroot binary, which defaults to "chroot" on the
+	// PATH.
+	Executable string
+
+	// The shell in the chroot that commands are run with as
+	// `<shell> -c <command>`. It defaults to "/bin/sh".
+	Shell string
+}
+
+// Creates a new packer.Communicator implementation for the directory.
+func New(config *Config) *comm {
+	if config.Executable == "" {
+		config.Executable = "chroot"
+	}
+
+	if config.Shell == "" {
+		config.Shell = "/bin/sh"
+	}
+
+	return &comm{config}
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
+	for _, kv := range cmd.Env {
+		if strings.Index(kv, "=") < 1 {
+			return fmt.Errorf("Invalid environment variable: %s", kv)
+		}
+	}
+
+	localCmd := exec.Command(c.config.Executable, c.config.Chroot, c.config.Shell, "-c", cmd.Command)
+	localCmd.Env = append(os.Environ(), cmd.Env...)
+	localCmd.Stdin = cmd.Stdin
+	localCmd.Stdout = cmd.Stdout
+	localCmd.Stderr = cmd.Stderr
+
+	log.Printf("starting remote command: %s", cmd.Command)
+	if err = localCmd.Start(); err != nil {
+		return
+	}
+
+	// Start a goroutine to wait for the process to end and set the
+	// exit boolean and status.
+	go func() {
+		// Abort the command if it is cancelled by killing it.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-cmd.Cancelled():
+				log.Printf("cancelling remote command: %s", cmd.Command)
+				localCmd.Process.Kill()
+			case <-done:
+			}
+		}()
+
+		err := localCmd.Wait()
+		cmd.ExitStatus = 0
+		if err != nil {
+			cmd.ExitStatus = packer.CmdDisconnect
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+					if status.Exited() {
+						cmd.ExitStatus = status.ExitStatus()
+					} else if status.Signaled() {
+						cmd.ExitStatus = 128 + int(status.Signal())
+						cmd.ExitSignal = common.SignalName(status.Signal())
+					}
+				}
+			}
+
+			if cmd.ExitStatus == packer.CmdDisconnect {
+				log.Printf("[WARN] remote command did not exit cleanly: %s", err)
+				cmd.Err = err
+			}
+		}
+
+		select {
+		case <-cmd.Cancelled():
+			cmd.ExitStatus = packer.CmdCancelled
+		default:
+		}
+
+		cmd.Exited = true
+	}()
+
+	return
+}
+
+func (c *comm) Upload(dst string, input io.Reader, fi *os.FileInfo) error {
+	hostPath, err := c.hostPath(dst)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Uploading to %s", hostPath)
+	return writeFile(hostPath, input, 0644)
+}
+
+func (c *comm) UploadDir(dst string, src string, excl []string) error {
+	log.Printf("Upload dir '%s' to '%s'", src, dst)
+
+	// Like rsync, if there is no trailing slash then the source directory
+	// itself is created in the destination.
+	if !strings.HasSuffix(src, "/") {
+		log.Println("No trailing slash, creating the source directory name")
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	return filepath.Walk(src, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, localPath)
+		if err != nil {
+			return err
+		}
+
+		if relPath != "." && common.UploadExcluded(relPath, excl) {
+			log.Printf("Excluding from upload: %s", relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Printf("Skipping upload of non-regular file: %s", relPath)
+			return nil
+		}
+
+		hostPath, err := c.hostPath(path.Join(dst, filepath.ToSlash(relPath)))
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(hostPath, info.Mode().Perm()|0700)
+		}
+
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return writeFile(hostPath, f, info.Mode().Perm())
+	})
+}
+
+func (c *comm) Download(src string, output io.Writer) error {
+	hostPath, err := c.hostPath(src)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(output, f)
+	return err
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+
+	if !strings.HasSuffix(src, "/") {
+		log.Println("No trailing slash, creating the source directory name")
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	hostSrc, err := c.hostPath(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(hostSrc, func(hostPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(hostSrc, hostPath)
+		if err != nil {
+			return err
+		}
+
+		if relPath != "." && common.UploadExcluded(relPath, excl) {
+			log.Printf("Excluding from download: %s", relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		localPath := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(localPath, 0755)
+		}
+
+		// Symlinks are skipped, since they would point at the host
+		if !info.Mode().IsRegular() {
+			log.Printf("Skipping download of non-regular file: %s", relPath)
+			return nil
+		}
+
+		f, err := os.Open(hostPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return writeFile(localPath, f, info.Mode().Perm())
+	})
+}
+
+func (c *comm) ForwardLocal(local string, remote string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported in a chroot")
+}
+
+func (c *comm) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported in a chroot")
+}
+
+// hostPath returns the path on the host of the path in the chroot. Symlinks
+// are resolved as they would be in the chroot, so that absolute links in
+// the file system can't make us write to the host's own files. Paths that
+// are relative are relative to the root of the chroot.
+func (c *comm) hostPath(p string) (string, error) {
+	root := filepath.Clean(c.config.Chroot)
+	resolved := "/"
+	remaining := strings.Split(path.Clean("/"+p), "/")
+	links := 0
+
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+		if part == "" || part == "." {
+			continue
+		}
+
+		if part == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// Anything that doesn't exist yet is created as it is named
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("Too many levels of symbolic links: %s", p)
+		}
+
+		target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+
+		if strings.HasPrefix(target, "/") {
+			resolved = "/"
+		}
+
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+
+	return filepath.Join(root, filepath.FromSlash(resolved)), nil
+}
+
+// writeFile writes the data to the file on the host, creating any parent
+// directories.
+func writeFile(hostPath string, input io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(hostPath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(hostPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, input); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,296 @@
+package chroot
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testChroot is a fake chroot binary that runs the command in the root
+// directory, since chroot itself needs a whole file system.
+const testChroot = `#!/bin/sh
+cd "$1" && shift && exec "$@"
+`
+
+// testComm returns a communicator using the fake chroot, and the root
+// directory of the chroot.
+func testComm(t *testing.T) (*comm, string) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	executable := filepath.Join(dir, "chroot")
+	if err := ioutil.WriteFile(executable, []byte(testChroot), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return New(&Config{Chroot: root, Executable: executable}), root
+}
+
+func testWait(t *testing.T, cmd *packer.RemoteCmd) {
+	for i := 0; i < 500 && !cmd.Exited; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !cmd.Exited {
+		t.Fatal("command never exited")
+	}
+}
+
+func TestCommIsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = &comm{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("comm must be a communicator")
+	}
+}
+
+func TestNew_defaults(t *testing.T) {
+	c := New(&Config{Chroot: "/mnt/target"})
+	if c.config.Executable != "chroot" || c.config.Shell != "/bin/sh" {
+		t.Fatalf("bad: %#v", c.config)
+	}
+}
+
+func TestStart(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	var stdout, stderr bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: `echo "$FOO"; pwd; echo err >&2; exit 42`,
+		Env:     []string{"FOO=bar baz"},
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 42 {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if stdout.String() != "bar baz\n"+root+"\n" || stderr.String() != "err\n" {
+		t.Fatalf("bad output: %q %q", stdout.String(), stderr.String())
+	}
+
+	cmd = &packer.RemoteCmd{Command: "true", Env: []string{"FOO"}}
+	if err := c.Start(cmd); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStart_cancel(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	cmd := &packer.RemoteCmd{Command: "sleep 10"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Cancel()
+	testWait(t, cmd)
+	if cmd.ExitStatus != packer.CmdCancelled {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+}
+
+func TestStart_signal(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	cmd := &packer.RemoteCmd{Command: "kill -9 $$"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 137 || cmd.ExitSignal != "KILL" {
+		t.Fatalf("bad exit: %d %s", cmd.ExitStatus, cmd.ExitSignal)
+	}
+
+	if cmd.Err != nil {
+		t.Fatalf("err: %s", cmd.Err)
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	os.MkdirAll(filepath.Join(root, "usr", "lib"), 0755)
+	os.Symlink("/usr/lib", filepath.Join(root, "lib"))
+	os.Symlink("../lib", filepath.Join(root, "usr", "lib64"))
+	os.Symlink("/usr/etc", filepath.Join(root, "etc"))
+	os.Symlink("loop", filepath.Join(root, "loop"))
+
+	cases := map[string]string{
+		"/":                "",
+		"/tmp/foo":         "tmp/foo",
+		"tmp/foo":          "tmp/foo",
+		"/../../foo":       "foo",
+		"/lib/foo":         "usr/lib/foo",
+		"/usr/lib64/foo":   "usr/lib/foo",
+		"/etc/passwd":      "usr/etc/passwd",
+		"/usr/lib/../../x": "x",
+	}
+
+	for input, expected := range cases {
+		result, err := c.hostPath(input)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if result != filepath.Join(root, expected) {
+			t.Errorf("bad %s: %s", input, result)
+		}
+	}
+
+	if _, err := c.hostPath("/loop/foo"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	// Absolute symlinks point into the chroot, not at the host
+	outside := filepath.Join(filepath.Dir(root), "outside")
+	os.Mkdir(outside, 0755)
+	os.Symlink(outside, filepath.Join(root, "link"))
+
+	if err := c.Upload("/link/foo.txt", strings.NewReader("foo"), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, outside, "foo.txt"))
+	if err != nil || string(data) != "foo" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "foo.txt")); err == nil {
+		t.Fatal("should not write outside the chroot")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	os.MkdirAll(filepath.Join(root, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "dir", "foo.txt"), []byte("foo"), 0644)
+
+	var output bytes.Buffer
+	if err := c.Download("/dir/foo.txt", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if output.String() != "foo" {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	if err := c.Download("/missing", &output); err == nil {
+		t.Fatal("should have an error")
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	common.WriteTestTree(t, src, map[string]string{
+		"a/b/foo.txt": "foo",
+		"bar.txt":     "bar",
+		"bar.log":     "log",
+		".git/HEAD":   "head",
+	})
+	os.Mkdir(filepath.Join(src, "empty"), 0755)
+
+	excl := []string{".git", "*.log"}
+	if err := c.UploadDir("/dst", src+"/", excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"a", "a/b", "a/b/foo.txt=foo", "bar.txt=bar", "empty"}
+	if result := common.ListTestTree(filepath.Join(root, "dst")); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Without a trailing slash the directory itself is created
+	if err := c.UploadDir("/other", filepath.Join(src, "a"), excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []string{"a", "a/b", "a/b/foo.txt=foo"}
+	if result := common.ListTestTree(filepath.Join(root, "other")); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	common.WriteTestTree(t, filepath.Join(root, "src"), map[string]string{
+		"a/b/foo.txt": "foo",
+		"bar.txt":     "bar",
+		"bar.log":     "log",
+		".git/HEAD":   "head",
+	})
+	os.Symlink("/etc/passwd", filepath.Join(root, "src", "passwd"))
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	excl := []string{".git", "*.log"}
+	if err := c.DownloadDir("/src", dst, excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"src", "src/a", "src/a/b", "src/a/b/foo.txt=foo", "src/bar.txt=bar"}
+	if result := common.ListTestTree(dst); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// With a trailing slash only the contents are downloaded
+	other := filepath.Join(dst, "other")
+	if err := c.DownloadDir("/src/", other, excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []string{"a", "a/b", "a/b/foo.txt=foo", "bar.txt=bar"}
+	if result := common.ListTestTree(other); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if err := c.DownloadDir("/missing", dst, nil); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -0,0 +1,40 @@
+// Package common has the helpers that communicators share, the way
+// builder/common does for builders.
+package common
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// UploadExcluded returns true if the given path, relative to the root of
+// a directory transfer, matches any of the exclude patterns, either as a
+// whole or by its base name.
+func UploadExcluded(relPath string, excl []string) bool {
+	for _, pattern := range excl {
+		if matched, _ := filepath.Match(pattern, relPath); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.Base(relPath)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DownloadExcluded returns true if the path, or any of the directories it
+// is in, is excluded. Communicators that read a listing or an archive in
+// order rather than walk the directory skip the contents of excluded
+// directories this way.
+func DownloadExcluded(relPath string, excl []string) bool {
+	parts := strings.Split(relPath, string(filepath.Separator))
+	for i := range parts {
+		if UploadExcluded(filepath.Join(parts[:i+1]...), excl) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadExcluded(t *testing.T) {
+	cases := []struct {
+		path     string
+		excl     []string
+		excluded bool
+	}{
+		{"foo", nil, false},
+		{"foo", []string{"foo"}, true},
+		{"bar/foo.tmp", []string{"*.tmp"}, true},
+		{"bar/foo", []string{"bar/*"}, true},
+		{"bar/foo", []string{"baz"}, false},
+	}
+
+	for _, tc := range cases {
+		if UploadExcluded(tc.path, tc.excl) != tc.excluded {
+			t.Fatalf("bad: %s %#v", tc.path, tc.excl)
+		}
+	}
+}
+
+func TestDownloadExcluded(t *testing.T) {
+	cases := []struct {
+		path     string
+		excluded bool
+	}{
+		{"foo.txt", false},
+		{"foo.log", true},
+		{filepath.Join("a", "foo.log"), true},
+		{filepath.Join(".git", "HEAD"), true},
+		{filepath.Join("a", ".git", "HEAD"), true},
+		{filepath.Join("a", "b", "c"), false},
+	}
+
+	for _, tc := range cases {
+		if result := DownloadExcluded(tc.path, []string{".git", "*.log"}); result != tc.excluded {
+			t.Errorf("bad %s: %#v", tc.path, result)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// SignalName returns the name of the signal without the "SIG" prefix, the
+// way SSH servers name them, for the ExitSignal of a command that was
+// killed by it.
+func SignalName(signal syscall.Signal) string {
+	names := map[syscall.Signal]string{
+		syscall.SIGABRT: "ABRT",
+		syscall.SIGALRM: "ALRM",
+		syscall.SIGFPE:  "FPE",
+		syscall.SIGHUP:  "HUP",
+		syscall.SIGILL:  "ILL",
+		syscall.SIGINT:  "INT",
+		syscall.SIGKILL: "KILL",
+		syscall.SIGPIPE: "PIPE",
+		syscall.SIGQUIT: "QUIT",
+		syscall.SIGSEGV: "SEGV",
+		syscall.SIGTERM: "TERM",
+		syscall.SIGUSR1: "USR1",
+		syscall.SIGUSR2: "USR2",
+	}
+
+	if name, ok := names[signal]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(signal))
+}
@@ -0,0 +1,16 @@
+package common
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestSignalName(t *testing.T) {
+	if name := SignalName(syscall.SIGKILL); name != "KILL" {
+		t.Fatalf("bad: %s", name)
+	}
+
+	if name := SignalName(syscall.Signal(99)); name != "99" {
+		t.Fatalf("bad: %s", name)
+	}
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Fataler is what the tree helpers fail with, such as a *testing.T.
+type Fataler interface {
+	Fatalf(format string, args ...interface{})
+}
+
+// WriteTestTree writes the files into the directory, creating any
+// parents. The names of the files are slash-separated. It is for the
+// tests of the directory transfers of communicators.
+func WriteTestTree(t Fataler, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+}
+
+// ListTestTree returns the slash-separated paths of what is in the
+// directory, with "=" and the contents after the paths of files, to compare
+// with what WriteTestTree wrote.
+func ListTestTree(dir string) []string {
+	var result []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		relPath, _ := filepath.Rel(dir, path)
+		if relPath == "." {
+			return nil
+		}
+
+		relPath = filepath.ToSlash(relPath)
+		if !info.IsDir() {
+			data, _ := ioutil.ReadFile(path)
+			relPath += "=" + string(data)
+		}
+
+		result = append(result, relPath)
+		return nil
+	})
+
+	return result
+}
@@ -0,0 +1,426 @@
+// Package docker provides a packer.Communicator that runs commands in a
+// running Docker container with `docker exec` and transfers files with
+// `docker cp`, so containers can be provisioned without an SSH server.
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type comm struct {
+	config *Config
+}
+
+// Config is the structure used to configure the Docker communicator.
+type Config struct {
+	// The ID or name of the running container.
+	ContainerId string
+
+	// The path of the docker binary, which defaults to "docker" on the
+	// PATH.
+	Executable string
+
+	// The shell that commands are run with as `<shell> -c <command>`
+	// inside the container. It defaults to "/bin/sh".
+	Shell string
+}
+
+// Creates a new packer.Communicator implementation for the container.
+func New(config *Config) *comm {
+	if config.Executable == "" {
+		config.Executable = "docker"
+	}
+
+	if config.Shell == "" {
+		config.Shell = "/bin/sh"
+	}
+
+	return &comm{config}
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
+	args := []string{"exec"}
+	if cmd.Stdin != nil {
+		args = append(args, "-i")
+	}
+
+	for _, kv := range cmd.Env {
+		// Without a value docker would use the variable from our own
+		// environment, which isn't what was asked for.
+		if strings.Index(kv, "=") < 1 {
+			return fmt.Errorf("Invalid environment variable: %s", kv)
+		}
+
+		args = append(args, "-e", kv)
+	}
+
+	args = append(args, c.config.ContainerId, c.config.Shell, "-c", cmd.Command)
+	localCmd := exec.Command(c.config.Executable, args...)
+	localCmd.Stdin = cmd.Stdin
+	localCmd.Stdout = cmd.Stdout
+	localCmd.Stderr = cmd.Stderr
+
+	log.Printf("starting remote command: %s", cmd.Command)
+	if err = localCmd.Start(); err != nil {
+		return
+	}
+
+	// Start a goroutine to wait for the process to end and set the
+	// exit boolean and status.
+	go func() {
+		// Abort the command if it is cancelled by killing docker, which
+		// makes it stop the process in the container.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-cmd.Cancelled():
+				log.Printf("cancelling remote command: %s", cmd.Command)
+				localCmd.Process.Kill()
+			case <-done:
+			}
+		}()
+
+		err := localCmd.Wait()
+		cmd.ExitStatus = 0
+		if err != nil {
+			cmd.ExitStatus = packer.CmdDisconnect
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+					if status.Exited() {
+						cmd.ExitStatus = status.ExitStatus()
+					} else if status.Signaled() {
+						cmd.ExitStatus = 128 + int(status.Signal())
+						cmd.ExitSignal = common.SignalName(status.Signal())
+					}
+				}
+			}
+
+			if cmd.ExitStatus == packer.CmdDisconnect {
+				log.Printf("[WARN] remote command did not exit cleanly: %s", err)
+				cmd.Err = err
+			}
+		}
+
+		select {
+		case <-cmd.Cancelled():
+			cmd.ExitStatus = packer.CmdCancelled
+		default:
+		}
+
+		cmd.Exited = true
+	}()
+
+	return
+}
+
+func (c *comm) Upload(dst string, input io.Reader, fi *os.FileInfo) error {
+	// The data is sent as a tar archive, which needs to know the size up
+	// front, so if we don't know it we spool the data to disk first.
+	var size int64
+	if fi != nil {
+		size = (*fi).Size()
+	} else if f, ok := input.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		size = info.Size()
+	} else {
+		tf, err := ioutil.TempFile("", "packer-upload")
+		if err != nil {
+			return fmt.Errorf("Error creating temporary file for upload: %s", err)
+		}
+		defer os.Remove(tf.Name())
+		defer tf.Close()
+
+		if size, err = io.Copy(tf, input); err != nil {
+			return err
+		}
+
+		if _, err := tf.Seek(0, 0); err != nil {
+			return err
+		}
+
+		input = tf
+	}
+
+	return c.copyTo(path.Dir(dst), func(w *tar.Writer) error {
+		header := &tar.Header{
+			Name: path.Base(dst),
+			Mode: 0644,
+			Size: size,
+		}
+
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err := io.Copy(w, input)
+		return err
+	})
+}
+
+func (c *comm) UploadDir(dst string, src string, excl []string) error {
+	log.Printf("Upload dir '%s' to '%s'", src, dst)
+
+	// Like rsync, if there is no trailing slash then the source directory
+	// itself is created in the destination.
+	prefix := ""
+	if !strings.HasSuffix(src, "/") {
+		log.Println("No trailing slash, creating the source directory name")
+		prefix = filepath.Base(src)
+	}
+
+	// docker cp requires the destination directory to exist.
+	if err := c.run(nil, "mkdir", "-p", dst); err != nil {
+		return err
+	}
+
+	return c.copyTo(dst, func(w *tar.Writer) error {
+		return filepath.Walk(src, func(localPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relPath, err := filepath.Rel(src, localPath)
+			if err != nil {
+				return err
+			}
+
+			if relPath != "." && common.UploadExcluded(relPath, excl) {
+				log.Printf("Excluding from upload: %s", relPath)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			name := path.Join(prefix, filepath.ToSlash(relPath))
+			if name == "." {
+				return nil
+			}
+
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				log.Printf("Skipping upload of non-regular file: %s", relPath)
+				return nil
+			}
+
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+
+			header.Name = name
+			if info.IsDir() {
+				header.Name += "/"
+			}
+
+			if err := w.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			f, err := os.Open(localPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			_, err = io.Copy(w, f)
+			return err
+		})
+	})
+}
+
+func (c *comm) Download(src string, output io.Writer) error {
+	return c.copyFrom(src, func(r *tar.Reader) error {
+		for {
+			header, err := r.Next()
+			if err == io.EOF {
+				return fmt.Errorf("%s was not found in the container", src)
+			} else if err != nil {
+				return err
+			}
+
+			if header.Typeflag == tar.TypeDir {
+				return fmt.Errorf("%s is a directory", src)
+			}
+
+			if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+				_, err := io.Copy(output, r)
+				return err
+			}
+		}
+	})
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+
+	// docker cp always sends the source directory itself, so if there is
+	// a trailing slash we write its contents straight to dst.
+	createTop := !strings.HasSuffix(src, "/")
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	return c.copyFrom(src, func(r *tar.Reader) error {
+		for {
+			header, err := r.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			// The first element is the name of the source directory
+			name := path.Clean(header.Name)
+			parts := strings.SplitN(name, "/", 2)
+			relPath := ""
+			if len(parts) == 2 {
+				relPath = parts[1]
+			}
+
+			if path.IsAbs(name) || strings.HasPrefix(name, "..") || strings.HasPrefix(relPath, "..") {
+				return fmt.Errorf("Invalid path in archive from container: %s", header.Name)
+			}
+
+			if relPath != "" && common.DownloadExcluded(filepath.FromSlash(relPath), excl) {
+				log.Printf("Excluding from download: %s", relPath)
+				continue
+			}
+
+			target := filepath.Join(dst, filepath.FromSlash(relPath))
+			if createTop {
+				target = filepath.Join(dst, filepath.FromSlash(name))
+			}
+
+			mode := os.FileMode(header.Mode).Perm()
+			switch header.Typeflag {
+			case tar.TypeDir:
+				if err := os.MkdirAll(target, mode|0700); err != nil {
+					return err
+				}
+			case tar.TypeReg, tar.TypeRegA:
+				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+					return err
+				}
+
+				f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+				if err != nil {
+					return err
+				}
+
+				_, err = io.Copy(f, r)
+				f.Close()
+				if err != nil {
+					return err
+				}
+			default:
+				log.Printf("Skipping download of non-regular file: %s", header.Name)
+			}
+		}
+	})
+}
+
+func (c *comm) ForwardLocal(local string, remote string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported by the Docker communicator")
+}
+
+func (c *comm) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported by the Docker communicator")
+}
+
+// copyTo runs `docker cp` to extract the tar archive that f writes into
+// the directory in the container.
+func (c *comm) copyTo(dir string, f func(*tar.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		w := tar.NewWriter(pw)
+		err := f(w)
+		if err == nil {
+			err = w.Close()
+		}
+
+		pw.CloseWithError(err)
+	}()
+
+	err := c.docker(pr, nil, "cp", "-", c.config.ContainerId+":"+dir)
+
+	// Make sure the writer doesn't block forever if docker failed early
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+// copyFrom runs `docker cp` to get the path from the container as a tar
+// archive, which is read by f.
+func (c *comm) copyFrom(src string, f func(*tar.Reader) error) error {
+	src = strings.TrimRight(src, "/")
+	if src == "" {
+		src = "/"
+	}
+
+	pr, pw := io.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		err := f(tar.NewReader(pr))
+
+		// Drain the rest of the archive so docker can exit
+		io.Copy(ioutil.Discard, pr)
+		result <- err
+	}()
+
+	err := c.docker(nil, pw, "cp", c.config.ContainerId+":"+src, "-")
+	pw.Close()
+	if readErr := <-result; err == nil {
+		err = readErr
+	}
+
+	return err
+}
+
+// run runs a command in the container without going through the shell,
+// returning an error with its output if it fails.
+func (c *comm) run(stdin io.Reader, args ...string) error {
+	return c.docker(stdin, nil, append([]string{"exec", c.config.ContainerId}, args...)...)
+}
+
+// docker runs the docker binary with the given arguments, returning an
+// error with whatever it wrote to stderr if it fails.
+func (c *comm) docker(stdin io.Reader, stdout io.Writer, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(c.config.Executable, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	log.Printf("Executing: %s %s", c.config.Executable, strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf(
+			"Error running docker %s: %s\nStderr: %s",
+			args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
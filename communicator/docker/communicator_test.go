@@ -0,0 +1,347 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDocker is a fake docker binary that runs commands on the host
+// and copies files into and out of the root directory, which stands in
+// for the file system of the container "container".
+const testDocker = `#!/bin/sh
+root='%s'
+cmd=$1
+shift
+
+case $cmd in
+exec)
+	while [ "$1" = "-i" ] || [ "$1" = "-e" ]; do
+		[ "$1" = "-e" ] && { export "$2"; shift; }
+		shift
+	done
+	[ "$1" = "container" ] || { echo "No such container: $1" >&2; exit 1; }
+	shift
+	cd "$root" && exec "$@"
+	;;
+cp)
+	if [ "$1" = "-" ]; then
+		exec tar -x -C "$root/${2#container:}"
+	fi
+
+	p=${1#container:}
+	exec tar -c -C "$root/$(dirname "$p")" "$(basename "$p")"
+	;;
+esac
+
+exit 1
+`
+
+// testComm returns a communicator using the fake docker, and the root
+// directory of the fake container.
+func testComm(t *testing.T) (*comm, string) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	executable := filepath.Join(dir, "docker")
+	script := fmt.Sprintf(testDocker, root)
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return New(&Config{ContainerId: "container", Executable: executable}), root
+}
+
+func testWait(t *testing.T, cmd *packer.RemoteCmd) {
+	for i := 0; i < 500 && !cmd.Exited; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !cmd.Exited {
+		t.Fatal("command never exited")
+	}
+}
+
+func TestCommIsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = &comm{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("comm must be a communicator")
+	}
+}
+
+func TestNew_defaults(t *testing.T) {
+	c := New(&Config{ContainerId: "foo"})
+	if c.config.Executable != "docker" || c.config.Shell != "/bin/sh" {
+		t.Fatalf("bad: %#v", c.config)
+	}
+}
+
+func TestStart(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	var stdout, stderr bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "echo out; echo err >&2; exit 42",
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 42 {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Fatalf("bad output: %q %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestStart_stdin(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "tr a-z A-Z",
+		Stdin:   strings.NewReader("hello"),
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 0 || stdout.String() != "HELLO" {
+		t.Fatalf("bad: %d %q", cmd.ExitStatus, stdout.String())
+	}
+}
+
+func TestStart_env(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: `echo "$FOO"`,
+		Env:     []string{"FOO=bar baz"},
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 0 || stdout.String() != "bar baz\n" {
+		t.Fatalf("bad: %d %q", cmd.ExitStatus, stdout.String())
+	}
+
+	cmd = &packer.RemoteCmd{Command: "true", Env: []string{"FOO"}}
+	if err := c.Start(cmd); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStart_cancel(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	cmd := &packer.RemoteCmd{Command: "sleep 10"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Cancel()
+	testWait(t, cmd)
+	if cmd.ExitStatus != packer.CmdCancelled {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+}
+
+func TestStart_signal(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	cmd := &packer.RemoteCmd{Command: "kill -9 $$"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 137 || cmd.ExitSignal != "KILL" {
+		t.Fatalf("bad exit: %d %s", cmd.ExitStatus, cmd.ExitSignal)
+	}
+
+	if cmd.Err != nil {
+		t.Fatalf("err: %s", cmd.Err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	// Without knowing the size
+	if err := c.Upload("foo.txt", strings.NewReader("foo"), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "foo.txt"))
+	if err != nil || string(data) != "foo" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+
+	// From a file
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	tf.WriteString("bar")
+	tf.Seek(0, 0)
+	if err := c.Upload("bar.txt", tf, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(root, "bar.txt"))
+	if err != nil || string(data) != "bar" {
+		t.Fatalf("bad: %q %s", data, err)
+	}
+}
+
+func TestUpload_error(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	c.config.ContainerId = "missing"
+	err := c.Upload("foo.txt", strings.NewReader("foo"), nil)
+	if err == nil {
+		t.Fatal("should have an error")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	os.MkdirAll(filepath.Join(root, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "dir", "foo.txt"), []byte("foo"), 0644)
+
+	var output bytes.Buffer
+	if err := c.Download("dir/foo.txt", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if output.String() != "foo" {
+		t.Fatalf("bad: %q", output.String())
+	}
+
+	// Directories can't be downloaded as a file
+	if err := c.Download("dir", &output); err == nil {
+		t.Fatal("should have an error")
+	}
+
+	if err := c.Download("missing", &output); err == nil {
+		t.Fatal("should have an error")
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	common.WriteTestTree(t, src, map[string]string{
+		"a/b/foo.txt": "foo",
+		"bar.txt":     "bar",
+		"bar.log":     "log",
+		".git/HEAD":   "head",
+	})
+	os.Mkdir(filepath.Join(src, "empty"), 0755)
+
+	excl := []string{".git", "*.log"}
+	if err := c.UploadDir("dst", src+"/", excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"a", "a/b", "a/b/foo.txt=foo", "bar.txt=bar", "empty"}
+	if result := common.ListTestTree(filepath.Join(root, "dst")); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Without a trailing slash the directory itself is created
+	if err := c.UploadDir("other", filepath.Join(src, "a"), excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []string{"a", "a/b", "a/b/foo.txt=foo"}
+	if result := common.ListTestTree(filepath.Join(root, "other")); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	c, root := testComm(t)
+	defer os.RemoveAll(filepath.Dir(root))
+
+	common.WriteTestTree(t, filepath.Join(root, "src"), map[string]string{
+		"a/b/foo.txt": "foo",
+		"bar.txt":     "bar",
+		"bar.log":     "log",
+		".git/HEAD":   "head",
+	})
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	excl := []string{".git", "*.log"}
+	if err := c.DownloadDir("src", dst, excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"src", "src/a", "src/a/b", "src/a/b/foo.txt=foo", "src/bar.txt=bar"}
+	if result := common.ListTestTree(dst); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// With a trailing slash only the contents are downloaded
+	other := filepath.Join(dst, "other")
+	if err := c.DownloadDir("src/", other, excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []string{"a", "a/b", "a/b/foo.txt=foo", "bar.txt=bar"}
+	if result := common.ListTestTree(other); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
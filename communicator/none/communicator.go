@@ -0,0 +1,51 @@
+// Package none provides a packer.Communicator for builds that don't
+// connect to the machine at all, such as repacking an existing image.
+// Every operation fails, so a provisioner that is mistakenly configured
+// with it gets a clear error rather than waiting for a connection.
+package none
+
+import (
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"os"
+)
+
+// ErrNoCommunicator is returned by every method of the communicator.
+var ErrNoCommunicator = errors.New(
+	"The machine has no communicator configured (\"communicator\": \"none\")")
+
+type comm struct{}
+
+// Creates a new packer.Communicator that can't communicate.
+func New() *comm {
+	return &comm{}
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) error {
+	return ErrNoCommunicator
+}
+
+func (c *comm) Upload(string, io.Reader, *os.FileInfo) error {
+	return ErrNoCommunicator
+}
+
+func (c *comm) UploadDir(string, string, []string) error {
+	return ErrNoCommunicator
+}
+
+func (c *comm) Download(string, io.Writer) error {
+	return ErrNoCommunicator
+}
+
+func (c *comm) DownloadDir(string, string, []string) error {
+	return ErrNoCommunicator
+}
+
+func (c *comm) ForwardLocal(string, string) (io.Closer, error) {
+	return nil, ErrNoCommunicator
+}
+
+func (c *comm) ForwardRemote(string, string) (io.Closer, error) {
+	return nil, ErrNoCommunicator
+}
@@ -0,0 +1,43 @@
+package none
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"testing"
+)
+
+func TestCommIsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = New()
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("comm must be a communicator")
+	}
+}
+
+func TestComm_errors(t *testing.T) {
+	c := New()
+
+	if err := c.Start(&packer.RemoteCmd{Command: "true"}); err != ErrNoCommunicator {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if err := c.Upload("foo", new(bytes.Buffer), nil); err != ErrNoCommunicator {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if err := c.Download("foo", new(bytes.Buffer)); err != ErrNoCommunicator {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if err := c.UploadDir("foo", "bar", nil); err != ErrNoCommunicator {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if err := c.DownloadDir("foo", "bar", nil); err != ErrNoCommunicator {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	if _, err := c.ForwardLocal("foo", "bar"); err != ErrNoCommunicator {
+		t.Fatalf("bad: %#v", err)
+	}
+}
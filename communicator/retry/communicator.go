@@ -0,0 +1,255 @@
+// Package retry provides a packer.Communicator that wraps another
+// communicator and retries operations that fail, for machines where the
+// connection is flaky.
+package retry
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultAttempts = 3
+	defaultDelay    = 1 * time.Second
+	defaultMaxDelay = 30 * time.Second
+)
+
+// Config is the structure used to configure the retrying communicator.
+type Config struct {
+	// The number of times an operation is attempted before giving up.
+	// The default is 3.
+	Attempts int
+
+	// How long to wait before the first retry. The delay doubles after
+	// every attempt, up to MaxDelay. The defaults are 1 and 30 seconds.
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// Retryable, if set, is called with the error of a failed attempt
+	// and returns true if the operation should be retried. By default
+	// every error is retried.
+	Retryable func(error) bool
+}
+
+type comm struct {
+	c      packer.Communicator
+	config Config
+}
+
+// New returns a packer.Communicator that calls through to the given
+// communicator, retrying Start, the uploads and downloads, and the port
+// forwards when they fail. Uploads and downloads are only retried if the
+// data can be sent again: either nothing was transferred yet, or the
+// reader or writer can be seeked back to where it started.
+func New(c packer.Communicator, config *Config) packer.Communicator {
+	result := &comm{c: c}
+	if config != nil {
+		result.config = *config
+	}
+
+	if result.config.Attempts <= 0 {
+		result.config.Attempts = defaultAttempts
+	}
+
+	if result.config.Delay <= 0 {
+		result.config.Delay = defaultDelay
+	}
+
+	if result.config.MaxDelay <= 0 {
+		result.config.MaxDelay = defaultMaxDelay
+	}
+
+	return result
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) error {
+	return c.retry("start command", func() error {
+		return c.c.Start(cmd)
+	})
+}
+
+func (c *comm) Upload(path string, input io.Reader, fi *os.FileInfo) error {
+	// The wrapped communicator can't see that the input is a file once
+	// it is wrapped, so get its info here so it can still be streamed.
+	if fi == nil {
+		if f, ok := input.(*os.File); ok {
+			if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+				fi = &info
+			}
+		}
+	}
+
+	r := &countingReader{r: input}
+	rewind := rewinder(input)
+
+	return c.retry("upload", func() error {
+		if r.n > 0 {
+			if err := rewind(); err != nil {
+				return &permanentError{err}
+			}
+
+			r.n = 0
+		}
+
+		err := c.c.Upload(path, r, fi)
+		if err != nil && r.n > 0 && rewind == nil {
+			return &permanentError{err}
+		}
+
+		return err
+	})
+}
+
+func (c *comm) UploadDir(dst string, src string, exclude []string) error {
+	return c.retry("directory upload", func() error {
+		return c.c.UploadDir(dst, src, exclude)
+	})
+}
+
+func (c *comm) Download(path string, output io.Writer) error {
+	w := &countingWriter{w: output}
+
+	// A download can only be rewound if the partial data can also be
+	// thrown away, which is the case for files.
+	var rewind func() error
+	if f, ok := output.(*os.File); ok {
+		if start, err := f.Seek(0, os.SEEK_CUR); err == nil {
+			rewind = func() error {
+				if _, err := f.Seek(start, os.SEEK_SET); err != nil {
+					return err
+				}
+
+				return f.Truncate(start)
+			}
+		}
+	}
+
+	return c.retry("download", func() error {
+		if w.n > 0 {
+			if err := rewind(); err != nil {
+				return &permanentError{err}
+			}
+
+			w.n = 0
+		}
+
+		err := c.c.Download(path, w)
+		if err != nil && w.n > 0 && rewind == nil {
+			return &permanentError{err}
+		}
+
+		return err
+	})
+}
+
+func (c *comm) DownloadDir(src string, dst string, exclude []string) error {
+	return c.retry("directory download", func() error {
+		return c.c.DownloadDir(src, dst, exclude)
+	})
+}
+
+func (c *comm) ForwardLocal(local string, remote string) (result io.Closer, err error) {
+	err = c.retry("local forward", func() (err error) {
+		result, err = c.c.ForwardLocal(local, remote)
+		return
+	})
+
+	return
+}
+
+func (c *comm) ForwardRemote(remote string, local string) (result io.Closer, err error) {
+	err = c.retry("remote forward", func() (err error) {
+		result, err = c.c.ForwardRemote(remote, local)
+		return
+	})
+
+	return
+}
+
+// retry calls f until it succeeds, backing off exponentially between
+// attempts, and returns the last error if every attempt failed.
+func (c *comm) retry(name string, f func() error) error {
+	delay := c.config.Delay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		if p, ok := err.(*permanentError); ok {
+			return p.err
+		}
+
+		if attempt >= c.config.Attempts {
+			return err
+		}
+
+		if c.config.Retryable != nil && !c.config.Retryable(err) {
+			return err
+		}
+
+		log.Printf("[WARN] Communicator %s failed (attempt %d), retrying in %s: %s", name, attempt, delay, err)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > c.config.MaxDelay {
+			delay = c.config.MaxDelay
+		}
+	}
+}
+
+// permanentError wraps an error that must not be retried, because the
+// data that was already transferred can't be sent again.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// rewinder returns a function that seeks the reader back to where it is
+// now, or nil if it can't be seeked.
+func rewinder(r io.Reader) func() error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return nil
+	}
+
+	start, err := seeker.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return nil
+	}
+
+	return func() error {
+		_, err := seeker.Seek(start, os.SEEK_SET)
+		return err
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,224 @@
+package retry
+
+import (
+	"bytes"
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// testCommunicator fails the first failures calls to every method. When
+// uploading or downloading, failing calls first transfer partial data.
+type testCommunicator struct {
+	failures int
+	calls    int
+	uploaded string
+}
+
+func (c *testCommunicator) fail() error {
+	c.calls++
+	if c.calls <= c.failures {
+		return errors.New("connection reset")
+	}
+
+	return nil
+}
+
+func (c *testCommunicator) Start(cmd *packer.RemoteCmd) error {
+	return c.fail()
+}
+
+func (c *testCommunicator) Upload(path string, r io.Reader, fi *os.FileInfo) error {
+	if err := c.fail(); err != nil {
+		r.Read(make([]byte, 1))
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	c.uploaded = string(data)
+	return err
+}
+
+func (c *testCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	return c.fail()
+}
+
+func (c *testCommunicator) Download(path string, w io.Writer) error {
+	if err := c.fail(); err != nil {
+		w.Write([]byte("partial"))
+		return err
+	}
+
+	_, err := w.Write([]byte("download"))
+	return err
+}
+
+func (c *testCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	return c.fail()
+}
+
+func (c *testCommunicator) ForwardLocal(local string, remote string) (io.Closer, error) {
+	return nil, c.fail()
+}
+
+func (c *testCommunicator) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return nil, c.fail()
+}
+
+func testConfig() *Config {
+	return &Config{
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		MaxDelay: time.Millisecond,
+	}
+}
+
+func TestComm_ImplementsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = New(new(testCommunicator), nil)
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatal("should be a communicator")
+	}
+}
+
+func TestNew_Defaults(t *testing.T) {
+	c := New(new(testCommunicator), nil).(*comm)
+	if c.config.Attempts != defaultAttempts {
+		t.Fatalf("bad: %d", c.config.Attempts)
+	}
+
+	if c.config.Delay != defaultDelay || c.config.MaxDelay != defaultMaxDelay {
+		t.Fatalf("bad: %#v", c.config)
+	}
+}
+
+func TestCommStart(t *testing.T) {
+	tc := &testCommunicator{failures: 2}
+	c := New(tc, testConfig())
+
+	if err := c.Start(new(packer.RemoteCmd)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tc.calls != 3 {
+		t.Fatalf("bad: %d", tc.calls)
+	}
+}
+
+func TestCommStart_TooManyFailures(t *testing.T) {
+	tc := &testCommunicator{failures: 3}
+	c := New(tc, testConfig())
+
+	if err := c.Start(new(packer.RemoteCmd)); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if tc.calls != 3 {
+		t.Fatalf("bad: %d", tc.calls)
+	}
+}
+
+func TestCommStart_NotRetryable(t *testing.T) {
+	config := testConfig()
+	config.Retryable = func(error) bool { return false }
+
+	tc := &testCommunicator{failures: 1}
+	c := New(tc, config)
+
+	if err := c.Start(new(packer.RemoteCmd)); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if tc.calls != 1 {
+		t.Fatalf("bad: %d", tc.calls)
+	}
+}
+
+func TestCommUpload_Seeker(t *testing.T) {
+	tc := &testCommunicator{failures: 2}
+	c := New(tc, testConfig())
+
+	if err := c.Upload("/foo", bytes.NewReader([]byte("foobar")), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tc.uploaded != "foobar" {
+		t.Fatalf("bad: %s", tc.uploaded)
+	}
+}
+
+func TestCommUpload_NotSeeker(t *testing.T) {
+	tc := &testCommunicator{failures: 1}
+	c := New(tc, testConfig())
+
+	if err := c.Upload("/foo", bytes.NewBufferString("foobar"), nil); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if tc.calls != 1 {
+		t.Fatalf("bad: %d", tc.calls)
+	}
+}
+
+func TestCommUpload_ProgressReader(t *testing.T) {
+	tc := &testCommunicator{failures: 2}
+	c := New(tc, testConfig())
+
+	// The progress of an upload doesn't keep it from being retried
+	input := &packer.ProgressReader{
+		Reader:   bytes.NewReader([]byte("foobar")),
+		Total:    6,
+		Progress: func(int64, int64) {},
+	}
+
+	if err := c.Upload("/foo", input, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tc.uploaded != "foobar" {
+		t.Fatalf("bad: %s", tc.uploaded)
+	}
+}
+
+func TestCommDownload_File(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	defer tf.Close()
+
+	tc := &testCommunicator{failures: 2}
+	c := New(tc, testConfig())
+
+	if err := c.Download("/foo", tf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(tf.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "download" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+func TestCommDownload_NotFile(t *testing.T) {
+	tc := &testCommunicator{failures: 1}
+	c := New(tc, testConfig())
+
+	var buf bytes.Buffer
+	if err := c.Download("/foo", &buf); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if tc.calls != 1 {
+		t.Fatalf("bad: %d", tc.calls)
+	}
+}
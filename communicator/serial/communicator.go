@@ -0,0 +1,471 @@
+// Package serial provides a packer.Communicator that drives the shell on
+// a serial console of the machine, such as the virtual serial port of a
+// VirtualBox or VMware VM exposed as a pipe. It logs in by answering the
+// prompts on the console, and doesn't need the network, so it can be used
+// to bootstrap the network configuration before SSH is available.
+//
+// The console is a single stream, so commands are run one at a time and
+// their stdout and stderr are both written to the command's Stdout. Files
+// are transferred as base64 text, which is slow, so this is meant for
+// small files. The machine must have a POSIX shell and base64.
+package serial
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The number of bytes that are sent in each line of an upload. Lines on a
+// terminal are limited to 4096 bytes, so data has to be split up.
+const uploadChunkSize = 768
+
+type comm struct {
+	config  *Config
+	console *console
+
+	// The console can only run one command at a time.
+	l sync.Mutex
+}
+
+// Config is the structure used to configure the serial communicator.
+type Config struct {
+	// The credentials to log in with if the console asks for them. If the
+	// console already has a shell, they aren't needed.
+	Username string
+	Password string
+
+	// Regular expressions that match the end of the login, password and
+	// shell prompts on the console.
+	LoginPrompt    string
+	PasswordPrompt string
+	ShellPrompt    string
+
+	// The time to wait for each prompt while logging in, which defaults
+	// to one minute.
+	Timeout time.Duration
+}
+
+// Creates a new packer.Communicator implementation over the serial
+// console, logging in to the shell on it.
+func New(rw io.ReadWriter, config *Config) (result *comm, err error) {
+	if config.LoginPrompt == "" {
+		config.LoginPrompt = `[Ll]ogin: *$`
+	}
+
+	if config.PasswordPrompt == "" {
+		config.PasswordPrompt = `[Pp]assword: *$`
+	}
+
+	if config.ShellPrompt == "" {
+		config.ShellPrompt = `[$#] *$`
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 1 * time.Minute
+	}
+
+	result = &comm{
+		config:  config,
+		console: newConsole(rw),
+	}
+
+	if err = result.login(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
+	command := cmd.Command
+	if len(cmd.Env) > 0 {
+		exports := make([]string, 0, len(cmd.Env))
+		for _, kv := range cmd.Env {
+			if strings.Index(kv, "=") < 1 {
+				return fmt.Errorf("Invalid environment variable: %s", kv)
+			}
+
+			exports = append(exports, shellQuote(kv))
+		}
+
+		command = fmt.Sprintf("export %s\n%s", strings.Join(exports, " "), command)
+	}
+
+	// Start a goroutine to run the command once the console is free,
+	// and to set the exit boolean and status.
+	go func() {
+		c.l.Lock()
+		defer c.l.Unlock()
+
+		status, err := c.start(command, cmd)
+		cmd.ExitStatus = status
+		if err == errCancelled {
+			cmd.ExitStatus = packer.CmdCancelled
+		} else if err != nil {
+			log.Printf("[WARN] remote command did not finish: %s", err)
+			cmd.ExitStatus = packer.CmdDisconnect
+			cmd.Err = err
+		}
+
+		cmd.Exited = true
+	}()
+
+	return
+}
+
+func (c *comm) Upload(path string, input io.Reader, fi *os.FileInfo) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.upload(path, input)
+}
+
+func (c *comm) UploadDir(dst string, src string, excl []string) error {
+	log.Printf("Upload dir '%s' to '%s'", src, dst)
+
+	// Like rsync, if there is no trailing slash then the source directory
+	// itself is created in the destination.
+	if !strings.HasSuffix(src, "/") {
+		log.Println("No trailing slash, creating the source directory name")
+		dst = strings.TrimRight(dst, "/") + "/" + filepath.Base(src)
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if err := c.run("mkdir -p "+shellQuote(dst), nil); err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		if common.UploadExcluded(relPath, excl) {
+			log.Printf("Excluding from upload: %s", relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		target := dst + "/" + filepath.ToSlash(relPath)
+		if info.IsDir() {
+			return c.run("mkdir -p "+shellQuote(target), nil)
+		}
+
+		if !info.Mode().IsRegular() {
+			log.Printf("Skipping upload of non-regular file: %s", relPath)
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return c.upload(target, f)
+	})
+}
+
+func (c *comm) Download(path string, output io.Writer) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	return c.download(path, output)
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+
+	if !strings.HasSuffix(src, "/") {
+		log.Println("No trailing slash, creating the source directory name")
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	// List the whole directory first, with every directory before the
+	// files. Each line is "D" or "F" for directories and files, then the
+	// path relative to src.
+	var listing bytes.Buffer
+	command := fmt.Sprintf(
+		"cd %s && find . -type d | sed 's/^/D /' && find . -type f | sed 's/^/F /'",
+		shellQuote(src))
+	if err := c.run(command, &listing); err != nil {
+		return fmt.Errorf("Error listing %s: %s", src, err)
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(listing.String(), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		if len(line) < 3 || (line[0] != 'D' && line[0] != 'F') || line[1] != ' ' {
+			return fmt.Errorf("Invalid directory listing: %s", line)
+		}
+
+		relPath := filepath.Clean(filepath.FromSlash(line[2:]))
+		if relPath == "." {
+			continue
+		}
+
+		if filepath.IsAbs(relPath) || strings.HasPrefix(relPath, "..") {
+			return fmt.Errorf("Invalid path in directory listing: %s", line[2:])
+		}
+
+		if common.DownloadExcluded(relPath, excl) {
+			log.Printf("Excluding from download: %s", relPath)
+			continue
+		}
+
+		localPath := filepath.Join(dst, relPath)
+		if line[0] == 'D' {
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+
+		err = c.download(strings.TrimRight(src, "/")+"/"+filepath.ToSlash(relPath), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *comm) ForwardLocal(local string, remote string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported over a serial console")
+}
+
+func (c *comm) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported over a serial console")
+}
+
+// login answers the prompts on the console until there is a shell, then
+// sets the shell up so that the output of commands can be read.
+func (c *comm) login() error {
+	loginRe, err := regexp.Compile(c.config.LoginPrompt)
+	if err != nil {
+		return fmt.Errorf("Invalid login prompt: %s", err)
+	}
+
+	passwordRe, err := regexp.Compile(c.config.PasswordPrompt)
+	if err != nil {
+		return fmt.Errorf("Invalid password prompt: %s", err)
+	}
+
+	shellRe, err := regexp.Compile(c.config.ShellPrompt)
+	if err != nil {
+		return fmt.Errorf("Invalid shell prompt: %s", err)
+	}
+
+	// Wake the console up, since the prompt was probably printed before
+	// we connected.
+	log.Println("Waiting for a prompt on the serial console")
+	if err := c.console.write("\n"); err != nil {
+		return err
+	}
+
+	prompt, err := c.console.expect(c.config.Timeout, loginRe, shellRe)
+	if err != nil {
+		return err
+	}
+
+	if prompt == 0 {
+		if c.config.Username == "" {
+			return errors.New("The serial console asked to log in, but no username is set")
+		}
+
+		log.Printf("Logging in on the serial console as %s", c.config.Username)
+		if err := c.console.write(c.config.Username + "\n"); err != nil {
+			return err
+		}
+
+		// The login prompt isn't looked for, since it was probably printed
+		// again for the newline that woke the console up.
+		prompt, err = c.console.expect(c.config.Timeout, passwordRe, shellRe)
+		if err != nil {
+			return err
+		}
+
+		if prompt == 0 {
+			if err := c.console.write(c.config.Password + "\n"); err != nil {
+				return err
+			}
+
+			prompt, err = c.console.expect(c.config.Timeout, shellRe, loginRe)
+			if err != nil {
+				return err
+			}
+
+			prompt += 1
+		}
+
+		// Being asked to log in again means the login failed
+		if prompt != 1 {
+			return errors.New("Login on the serial console failed")
+		}
+	}
+
+	// Turn off echo and the translation of newlines, which would
+	// otherwise mangle the output, and the prompts, which are noise.
+	log.Println("Setting up the shell on the serial console")
+	setup := fmt.Sprintf(
+		"stty -echo -onlcr 2>/dev/null; PS1=''; PS2=''; printf '%%s%%s\\n' %s- READY\n",
+		c.console.marker)
+	if err := c.console.write(setup); err != nil {
+		return err
+	}
+
+	ready := regexp.MustCompile(regexp.QuoteMeta(c.console.marker+"-READY") + `\r?\n`)
+	_, err = c.console.expect(c.config.Timeout, ready)
+	return err
+}
+
+// start runs the remote command, sending its input first if it has any.
+func (c *comm) start(command string, cmd *packer.RemoteCmd) (int, error) {
+	if cmd.Stdin != nil {
+		// Input can't be sent while a command runs, since it would have
+		// to be written to the console, so it is uploaded first.
+		id := fmt.Sprintf("/tmp/packer-stdin-%s-%d", c.console.marker, c.console.nextId)
+		if err := c.upload(id, cmd.Stdin); err != nil {
+			return 0, err
+		}
+
+		defer c.run("rm -f "+id, nil)
+		command = fmt.Sprintf("(\n%s\n) <%s", command, id)
+	}
+
+	log.Printf("starting remote command: %s", cmd.Command)
+	return c.console.run(command, cmd.Stdout, cmd.Cancelled())
+}
+
+// run runs the command, returning an error with its output if it fails.
+func (c *comm) run(command string, output io.Writer) error {
+	var buf bytes.Buffer
+	if output == nil {
+		output = &buf
+	}
+
+	status, err := c.console.run(command, output, nil)
+	if err != nil {
+		return err
+	}
+
+	if status != 0 {
+		return fmt.Errorf(
+			"Command failed with exit status %d: %s\nOutput: %s",
+			status, command, strings.TrimSpace(buf.String()))
+	}
+
+	return nil
+}
+
+// upload writes the data to the path on the machine. The data is appended
+// to a temporary file in base64 a line at a time, then decoded into place.
+func (c *comm) upload(path string, input io.Reader) error {
+	log.Printf("Uploading to %s over the serial console", path)
+	tmp := fmt.Sprintf("/tmp/packer-upload-%s-%d", c.console.marker, c.console.nextId)
+	if err := c.run(": >"+tmp, nil); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, uploadChunkSize)
+	for {
+		n, err := io.ReadFull(input, chunk)
+		if n > 0 {
+			data := base64.StdEncoding.EncodeToString(chunk[:n])
+			if err := c.run(fmt.Sprintf("printf '%%s\\n' %s >>%s", data, tmp), nil); err != nil {
+				c.run("rm -f "+tmp, nil)
+				return err
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			c.run("rm -f "+tmp, nil)
+			return err
+		}
+	}
+
+	command := fmt.Sprintf("base64 -d <%s >%s; status=$?; rm -f %s; exit $status", tmp, shellQuote(path), tmp)
+	return c.run(command, nil)
+}
+
+// download writes the contents of the file on the machine to output. The
+// file is sent by the machine in base64.
+func (c *comm) download(path string, output io.Writer) error {
+	if err := c.run("test -f "+shellQuote(path), nil); err != nil {
+		return fmt.Errorf("%s is not a file on the machine", path)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(output, base64.NewDecoder(base64.StdEncoding, pr))
+
+		// Drain the rest of the data so the console isn't blocked
+		io.Copy(ioutil.Discard, pr)
+		done <- err
+	}()
+
+	err := c.run("base64 <"+shellQuote(path), pw)
+	pw.Close()
+	if decodeErr := <-done; err == nil {
+		err = decodeErr
+	}
+
+	return err
+}
+
+// shellQuote quotes a string so that the remote shell treats it as a
+// single word with no special characters, by wrapping it in single quotes.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,391 @@
+package serial
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLogin emulates getty on the console: it asks for a login until it
+// gets one, then for the password, and runs an interactive shell in the
+// directory given as the first argument if they are right.
+const testLogin = `
+cd "$1"
+while [ -z "$user" ]; do printf 'login: '; read user; done
+printf 'Password: '
+read pass
+if [ "$user" != user ] || [ "$pass" != pass ]; then
+	echo 'Login incorrect'
+	printf 'login: '
+	read user
+	exit 1
+fi
+
+echo 'Welcome!'
+exec sh -i 2>&1
+`
+
+// testConsole is the console of a fake machine, which is a shell running
+// on the host.
+type testConsole struct {
+	io.Reader
+	io.Writer
+
+	cmd *exec.Cmd
+}
+
+func newTestConsole(t *testing.T, dir string) *testConsole {
+	cmd := exec.Command("/bin/sh", "-c", testLogin, "sh", dir)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return &testConsole{stdout, stdin, cmd}
+}
+
+func (c *testConsole) Close() {
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
+
+// testComm returns a communicator logged in to a fake machine, and the
+// directory it runs commands in.
+func testComm(t *testing.T) (*comm, *testConsole, string) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	console := newTestConsole(t, dir)
+	c, err := New(console, &Config{Username: "user", Password: "pass", Timeout: 5 * time.Second})
+	if err != nil {
+		console.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("err: %s", err)
+	}
+
+	return c, console, dir
+}
+
+func testWait(t *testing.T, cmd *packer.RemoteCmd) {
+	for i := 0; i < 500 && !cmd.Exited; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !cmd.Exited {
+		t.Fatal("command never exited")
+	}
+}
+
+func TestCommIsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = &comm{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("comm must be a communicator")
+	}
+}
+
+func TestNew_badLogin(t *testing.T) {
+	console := newTestConsole(t, os.TempDir())
+	defer console.Close()
+
+	_, err := New(console, &Config{Username: "user", Password: "nope", Timeout: 5 * time.Second})
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if !strings.Contains(err.Error(), "Login") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestNew_noUsername(t *testing.T) {
+	console := newTestConsole(t, os.TempDir())
+	defer console.Close()
+
+	_, err := New(console, &Config{Timeout: 5 * time.Second})
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestNew_badPrompt(t *testing.T) {
+	_, err := New(new(bytes.Buffer), &Config{ShellPrompt: "("})
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestNew_timeout(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	console := struct {
+		io.Reader
+		io.Writer
+	}{r, ioutil.Discard}
+
+	_, err := New(console, &Config{Timeout: 10 * time.Millisecond})
+	if err != errTimeout {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestStart(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "echo out; echo err >&2; exit 42",
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 42 {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if stdout.String() != "out\nerr\n" {
+		t.Fatalf("bad output: %q", stdout.String())
+	}
+
+	// The login shell is still there
+	stdout.Reset()
+	cmd = &packer.RemoteCmd{Command: "echo again", Stdout: &stdout}
+	c.Start(cmd)
+	testWait(t, cmd)
+	if cmd.ExitStatus != 0 || stdout.String() != "again\n" {
+		t.Fatalf("bad: %d %q", cmd.ExitStatus, stdout.String())
+	}
+}
+
+func TestStart_stdinEnv(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: `tr a-z A-Z; echo "$FOO"`,
+		Env:     []string{"FOO=it's here"},
+		Stdin:   strings.NewReader("hello\n"),
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 0 || stdout.String() != "HELLO\nit's here\n" {
+		t.Fatalf("bad: %d %q", cmd.ExitStatus, stdout.String())
+	}
+
+	cmd = &packer.RemoteCmd{Command: "true", Env: []string{"FOO"}}
+	if err := c.Start(cmd); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStart_cancel(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	cmd := &packer.RemoteCmd{Command: "sleep 1; echo late"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	cmd.Cancel()
+	testWait(t, cmd)
+	if cmd.ExitStatus != packer.CmdCancelled {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	// The output of the cancelled command isn't mixed up with the next
+	var stdout bytes.Buffer
+	cmd = &packer.RemoteCmd{Command: "echo next", Stdout: &stdout}
+	c.Start(cmd)
+	testWait(t, cmd)
+	if stdout.String() != "next\n" {
+		t.Fatalf("bad output: %q", stdout.String())
+	}
+}
+
+func TestStart_disconnect(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	cmd := &packer.RemoteCmd{Command: "kill -9 $$"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != packer.CmdDisconnect {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if cmd.Err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	// Enough binary data for a few lines
+	data := make([]byte, uploadChunkSize*3+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	if err := c.Upload("foo bar.bin", bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := ioutil.ReadFile(filepath.Join(dir, "foo bar.bin"))
+	if err != nil || !bytes.Equal(result, data) {
+		t.Fatalf("bad: %d %s", len(result), err)
+	}
+
+	var output bytes.Buffer
+	if err := c.Download("foo bar.bin", &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(output.Bytes(), data) {
+		t.Fatalf("bad: %d", output.Len())
+	}
+
+	// Empty files
+	if err := c.Upload("empty", new(bytes.Buffer), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(dir, "empty")); err != nil || fi.Size() != 0 {
+		t.Fatalf("bad: %#v %s", fi, err)
+	}
+
+	if err := c.Upload("missing/foo", strings.NewReader("foo"), nil); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if err := c.Download("missing", &output); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	common.WriteTestTree(t, src, map[string]string{
+		"a/b/foo.txt": "foo",
+		"bar.txt":     "bar",
+		"bar.log":     "log",
+		".git/HEAD":   "head",
+	})
+	os.Mkdir(filepath.Join(src, "empty"), 0755)
+
+	excl := []string{".git", "*.log"}
+	if err := c.UploadDir("dst", src+"/", excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"a", "a/b", "a/b/foo.txt=foo", "bar.txt=bar", "empty"}
+	if result := common.ListTestTree(filepath.Join(dir, "dst")); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Without a trailing slash the directory itself is created
+	if err := c.UploadDir("other", filepath.Join(src, "a"), excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []string{"a", "a/b", "a/b/foo.txt=foo"}
+	if result := common.ListTestTree(filepath.Join(dir, "other")); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	c, console, dir := testComm(t)
+	defer os.RemoveAll(dir)
+	defer console.Close()
+
+	common.WriteTestTree(t, filepath.Join(dir, "src"), map[string]string{
+		"a/b/foo.txt": "foo",
+		"bar.txt":     "bar",
+		"bar.log":     "log",
+		".git/HEAD":   "head",
+	})
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	excl := []string{".git", "*.log"}
+	if err := c.DownloadDir("src", dst, excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"src", "src/a", "src/a/b", "src/a/b/foo.txt=foo", "src/bar.txt=bar"}
+	if result := common.ListTestTree(dst); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// With a trailing slash only the contents are downloaded
+	other := filepath.Join(dst, "other")
+	if err := c.DownloadDir("src/", other, excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected = []string{"a", "a/b", "a/b/foo.txt=foo", "bar.txt=bar"}
+	if result := common.ListTestTree(other); fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if err := c.DownloadDir("missing", dst, nil); err == nil {
+		t.Fatal("should have error")
+	}
+}
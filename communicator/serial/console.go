@@ -0,0 +1,175 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// console reads and writes a serial console, keeping what was read but
+// not consumed yet so that it can be matched against prompts.
+type console struct {
+	w    io.Writer
+	data chan []byte
+	err  error
+	buf  []byte
+
+	// The prefix of the markers that delimit the output of commands,
+	// which is unique to the console so that the output of commands run
+	// by others is never mistaken for ours.
+	marker string
+	nextId int
+}
+
+var errTimeout = errors.New("Timeout waiting for the serial console")
+var errCancelled = errors.New("Cancelled")
+
+func newConsole(rw io.ReadWriter) *console {
+	c := &console{
+		w:      rw,
+		data:   make(chan []byte),
+		marker: fmt.Sprintf("PACKER-%x", time.Now().UnixNano()),
+	}
+
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := rw.Read(buf)
+			if n > 0 {
+				c.data <- buf[:n]
+			}
+
+			if err != nil {
+				c.err = err
+				close(c.data)
+				return
+			}
+		}
+	}()
+
+	return c
+}
+
+// write writes the text to the console.
+func (c *console) write(s string) error {
+	_, err := io.WriteString(c.w, s)
+	return err
+}
+
+// read waits for more data from the console and appends it to the
+// buffer. A nil timeout or cancel channel is never ready.
+func (c *console) read(timeout <-chan time.Time, cancel <-chan struct{}) error {
+	select {
+	case data, ok := <-c.data:
+		if !ok {
+			if c.err == io.EOF {
+				return errors.New("The serial console was closed")
+			}
+
+			return c.err
+		}
+
+		c.buf = append(c.buf, data...)
+		return nil
+	case <-timeout:
+		return errTimeout
+	case <-cancel:
+		return errCancelled
+	}
+}
+
+// expect waits until one of the patterns matches what was read, and
+// returns the index of the pattern. Everything up to the end of the match
+// is consumed.
+func (c *console) expect(timeout time.Duration, patterns ...*regexp.Regexp) (int, error) {
+	deadline := time.After(timeout)
+	for {
+		for i, re := range patterns {
+			if loc := re.FindIndex(c.buf); loc != nil {
+				c.buf = c.buf[loc[1]:]
+				return i, nil
+			}
+		}
+
+		if err := c.read(deadline, nil); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// run runs the command in the shell on the console, writing its output to
+// output, and returns its exit status. The console only has one stream,
+// so stdout and stderr are both written to output. If the command is
+// cancelled, an interrupt is sent and errCancelled returned without
+// waiting for the command to end.
+func (c *console) run(command string, output io.Writer, cancel <-chan struct{}) (int, error) {
+	c.nextId++
+	id := strconv.Itoa(c.nextId)
+
+	// The markers are printed in two halves so that they can't be matched
+	// by the shell echoing the command back. The command is run in a
+	// subshell so that it can't exit the login shell.
+	begin := regexp.MustCompile(regexp.QuoteMeta(c.marker+"-BEGIN-"+id) + `\r?\n`)
+	end := regexp.MustCompile(regexp.QuoteMeta(c.marker+"-END-"+id+":") + `(\d+)\r?\n`)
+	line := fmt.Sprintf(
+		"printf '%%s%%s\\n' %s- BEGIN-%s; (\n%s\n); printf '%%s%%s:%%d\\n' %s- END-%s $?\n",
+		c.marker, id, command, c.marker, id)
+
+	if err := c.write(line); err != nil {
+		return 0, err
+	}
+
+	// Skip everything before the start of the output. This includes the
+	// output of any previous commands that were cancelled.
+	for {
+		if loc := begin.FindIndex(c.buf); loc != nil {
+			c.buf = c.buf[loc[1]:]
+			break
+		}
+
+		if err := c.read(nil, cancel); err != nil {
+			return 0, c.interrupt(err)
+		}
+	}
+
+	// Hold back enough of the output that the end marker can't be
+	// written out partially before it is recognized.
+	holdBack := len(c.marker) + len(id) + 16
+	for {
+		if loc := end.FindSubmatchIndex(c.buf); loc != nil {
+			if output != nil {
+				output.Write(c.buf[:loc[0]])
+			}
+
+			status, _ := strconv.Atoi(string(c.buf[loc[2]:loc[3]]))
+			c.buf = c.buf[loc[1]:]
+			return status, nil
+		}
+
+		if n := len(c.buf) - holdBack; n > 0 {
+			if output != nil {
+				output.Write(c.buf[:n])
+			}
+
+			c.buf = c.buf[n:]
+		}
+
+		if err := c.read(nil, cancel); err != nil {
+			return 0, c.interrupt(err)
+		}
+	}
+}
+
+// interrupt sends ^C to the console if the command was cancelled, and
+// returns the error. A newline follows so that the ^C is a line of its
+// own if the console doesn't interpret it.
+func (c *console) interrupt(err error) error {
+	if err == errCancelled {
+		c.write("\x03\n")
+	}
+
+	return err
+}
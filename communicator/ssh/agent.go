@@ -0,0 +1,29 @@
+package ssh
+
+import (
+	"code.google.com/p/go.crypto/ssh"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// AgentAuth returns a ClientAuth that authenticates using the keys loaded
+// in the running ssh-agent that SSH_AUTH_SOCK points to, along with the
+// connection to the agent. The connection has to stay open for as long as
+// the ClientAuth is used, including to reconnect, and should be closed
+// once it isn't.
+func AgentAuth() (ssh.ClientAuth, io.Closer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set. Is ssh-agent running?")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error connecting to ssh-agent: %s", err)
+	}
+
+	return ssh.ClientAuthAgent(ssh.NewAgentClient(conn)), conn, nil
+}
@@ -0,0 +1,49 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAgentAuth_NoSocket(t *testing.T) {
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", "")
+
+	if _, _, err := AgentAuth(); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestAgentAuth(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	socket := filepath.Join(td, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer l.Close()
+
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", socket)
+
+	auth, agent, err := AgentAuth()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if auth == nil {
+		t.Fatal("should have auth")
+	}
+
+	if err := agent.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -1,97 +1,381 @@
 package ssh
 
 import (
+	"bufio"
 	"bytes"
 	"code.google.com/p/go.crypto/ssh"
+	"errors"
 	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
 	"github.com/mitchellh/packer/packer"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The PTY that is requested for commands unless configured otherwise.
+const (
+	defaultPTYTerm   = "xterm"
+	defaultPTYWidth  = 80
+	defaultPTYHeight = 40
+)
+
+// The bounds of the delay between attempts when reconnecting.
+const (
+	reconnectMinDelay = 1 * time.Second
+	reconnectMaxDelay = 30 * time.Second
 )
 
 type comm struct {
+	config *Config
+
+	// The client is replaced whenever we reconnect, so access to it
+	// is protected by the lock.
 	client *ssh.ClientConn
+	l      sync.Mutex
+
+	// A slot is taken from sessions for every open session, if the
+	// number of sessions is limited.
+	sessions chan struct{}
+}
+
+// Config is the structure used to configure the SSH communicator.
+type Config struct {
+	// The configuration of the Go SSH connection
+	SSHConfig *ssh.ClientConfig
+
+	// If true, files are uploaded and downloaded using SFTP rather
+	// than SCP, for machines that don't have an scp binary.
+	UseSFTP bool
+
+	// If true, single files are gzip compressed while they are uploaded
+	// and downloaded, which needs gzip on the remote machine. Directories
+	// are transferred uncompressed.
+	Compression bool
+
+	// Connection, if set, returns a new connection to the remote
+	// machine. It is used to reconnect if the connection is lost.
+	// If it is nil, lost connections are never re-established.
+	Connection func() (net.Conn, error)
+
+	// How often to check that the connection is still alive. If a
+	// check fails, the connection is closed so that nothing hangs
+	// waiting on it. Zero disables these keepalives.
+	KeepAliveInterval time.Duration
+
+	// How long to keep trying to reconnect before giving up.
+	ReconnectTimeout time.Duration
+
+	// How many times a command is run again if the connection is lost
+	// before it exits. Commands that reboot the machine, or that aren't
+	// safe to run twice, shouldn't be retried, so the default is zero.
+	// Commands with stdin are never retried.
+	CommandRetries int
+
+	// If true, no PTY is requested for commands. Otherwise, a PTY with
+	// the given terminal type and dimensions in characters is requested,
+	// which default to an 80x40 "xterm".
+	DisablePTY bool
+	PTYTerm    string
+	PTYWidth   int
+	PTYHeight  int
+
+	// The maximum rate, in bytes per second, at which files are uploaded
+	// and downloaded. Zero means there is no limit.
+	TransferRateLimit int
+
+	// The maximum number of sessions, which are used for commands and file
+	// transfers, that are open at once over the connection. Opening more
+	// waits for one to close. Zero means there is no limit.
+	MaxSessions int
 }
 
 // Creates a new packer.Communicator implementation over SSH. This takes
 // an already existing TCP connection and SSH configuration.
-func New(c net.Conn, config *ssh.ClientConfig) (result *comm, err error) {
-	client, err := ssh.Client(c, config)
-	result = &comm{client}
+func New(c net.Conn, config *Config) (result *comm, err error) {
+	client, err := ssh.Client(c, config.SSHConfig)
+	result = &comm{
+		config: config,
+		client: client,
+	}
+
+	if config.MaxSessions > 0 {
+		result.sessions = make(chan struct{}, config.MaxSessions)
+	}
+
+	if err == nil {
+		result.startKeepAlive(client)
+	}
+
 	return
 }
 
 func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
-	session, err := c.client.NewSession()
+	command, err := envCommand(cmd.Env, cmd.Command)
+	if err != nil {
+		return
+	}
+
+	session, err := c.startSession(cmd, command)
 	if err != nil {
 		return
 	}
 
+	// Start a goroutine to wait for the session to end and set the
+	// exit boolean and status. If the connection is lost first, the
+	// command is run again once reconnected, if it can be.
+	go func() {
+		for retries := 0; ; retries++ {
+			err := c.waitSession(cmd, session)
+			if err == nil {
+				break
+			}
+
+			if !c.canRetry(cmd, retries) {
+				log.Printf("[WARN] remote command did not exit cleanly: %s", err)
+				cmd.ExitStatus = packer.CmdDisconnect
+				cmd.Err = err
+				break
+			}
+
+			log.Printf("[WARN] connection lost running remote command, running it again: %s", err)
+			session, err = c.startSession(cmd, command)
+			if err != nil {
+				log.Printf("[WARN] remote command could not be run again: %s", err)
+				cmd.ExitStatus = packer.CmdDisconnect
+				cmd.Err = err
+				break
+			}
+		}
+
+		select {
+		case <-cmd.Cancelled():
+			cmd.ExitStatus = packer.CmdCancelled
+		default:
+		}
+
+		cmd.Exited = true
+	}()
+
+	return
+}
+
+// canRetry reports whether the command, whose connection was lost before
+// it exited after the given number of retries, should be run again. Only
+// commands without stdin are, since what they already read is gone.
+func (c *comm) canRetry(cmd *packer.RemoteCmd, retries int) bool {
+	select {
+	case <-cmd.Cancelled():
+		return false
+	default:
+	}
+
+	return c.config.Connection != nil &&
+		retries < c.config.CommandRetries &&
+		cmd.Stdin == nil
+}
+
+// startSession opens a new session and starts the command on it, with
+// the stdin and outputs of the RemoteCmd.
+func (c *comm) startSession(cmd *packer.RemoteCmd, command string) (*pooledSession, error) {
+	session, err := c.newSession()
+	if err != nil {
+		return nil, err
+	}
+
 	// Setup our session
 	session.Stdin = cmd.Stdin
 	session.Stdout = cmd.Stdout
 	session.Stderr = cmd.Stderr
 
 	// Request a PTY
-	termModes := ssh.TerminalModes{
-		ssh.ECHO:          0,     // do not echo
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-	}
+	if !c.config.DisablePTY {
+		termModes := ssh.TerminalModes{
+			ssh.ECHO:          0,     // do not echo
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
 
-	if err = session.RequestPty("xterm", 80, 40, termModes); err != nil {
-		return
+		term, width, height := c.ptySettings()
+		log.Printf("requesting %dx%d PTY of type %s", width, height, term)
+		if err := session.RequestPty(term, height, width, termModes); err != nil {
+			session.Close()
+			return nil, err
+		}
 	}
 
 	log.Printf("starting remote command: %s", cmd.Command)
-	err = session.Start(cmd.Command + "\n")
-	if err != nil {
-		return
+	if err := session.Start(command + "\n"); err != nil {
+		session.Close()
+		return nil, err
 	}
 
-	// Start a goroutine to wait for the session to end and set the
-	// exit boolean and status.
+	return session, nil
+}
+
+// waitSession waits for the command on the session to exit and sets its
+// exit status. If the status couldn't be found out, because the connection
+// was lost, the error is returned instead.
+func (c *comm) waitSession(cmd *packer.RemoteCmd, session *pooledSession) error {
+	defer session.Close()
+
+	// Abort the command if it is cancelled. Not all servers support
+	// signals, so the session is closed as well, which hangs up on
+	// the remote process.
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		defer session.Close()
+		select {
+		case <-cmd.Cancelled():
+			log.Printf("cancelling remote command: %s", cmd.Command)
+			session.Signal(ssh.SIGTERM)
+			session.Close()
+		case <-done:
+		}
+	}()
 
-		err := session.Wait()
-		cmd.ExitStatus = 0
-		if err != nil {
-			exitErr, ok := err.(*ssh.ExitError)
-			if ok {
-				cmd.ExitStatus = exitErr.ExitStatus()
+	err := session.Wait()
+	cmd.ExitStatus = 0
+	if err == nil {
+		return nil
+	}
+
+	exitErr, ok := err.(*ssh.ExitError)
+	if !ok {
+		return err
+	}
+
+	cmd.ExitStatus = exitErr.ExitStatus()
+
+	// Servers send no exit status if the process was killed by a
+	// signal, so make one up like a shell would.
+	if signal := exitErr.Signal(); signal != "" {
+		cmd.ExitSignal = signal
+		if cmd.ExitStatus <= 0 {
+			cmd.ExitStatus = 128 + signalNumbers[signal]
+		}
+	}
+
+	return nil
+}
+
+func (c *comm) Upload(dst string, input io.Reader, fi *os.FileInfo) error {
+	if c.config.Compression {
+		return c.gzipUpload(dst, input)
+	}
+
+	if c.config.UseSFTP {
+		return c.sftpUpload(dst, input)
+	}
+
+	// The SCP protocol needs to know the size of the file up front, so
+	// figure that out before we start the remote side.
+	input, size, cleanup, err := uploadSource(input, fi)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// The target directory and file for talking the SCP protocol. The
+	// path is on the remote machine, so it is split at slashes whatever
+	// the local OS is.
+	target_dir := path.Dir(dst)
+	target_file := path.Base(dst)
+
+	scpFunc := func(w io.Writer, r *bufio.Reader) error {
+		// The remote side sends a status once it is ready to receive
+		if err := checkSCPStatus(r); err != nil {
+			return err
+		}
+
+		return scpUploadFile(target_file, input, size, 0644, w, r)
+	}
+
+	// Start the sink mode on the other side
+	return c.scpSession("scp -vt "+shellQuotePath(target_dir), scpFunc)
+}
+
+func (c *comm) UploadDir(dst string, src string, excl []string) error {
+	log.Printf("Upload dir '%s' to '%s'", src, dst)
+
+	if c.config.UseSFTP {
+		return c.sftpUploadDir(dst, src, excl)
+	}
+
+	scpFunc := func(w io.Writer, r *bufio.Reader) error {
+		// The remote side sends a status once it is ready to receive
+		if err := checkSCPStatus(r); err != nil {
+			return err
+		}
+
+		if !strings.HasSuffix(src, "/") {
+			log.Println("No trailing slash, creating the source directory name")
+			fi, err := os.Stat(src)
+			if err != nil {
+				return err
 			}
+
+			name := filepath.Base(src)
+			return scpUploadDirProtocol(name, fi.Mode(), w, r, func() error {
+				return scpUploadDir(src, name, excl, w, r)
+			})
 		}
 
-		cmd.Exited = true
-	}()
+		return scpUploadDir(src, "", excl, w, r)
+	}
 
-	return
+	return c.scpSession("scp -rvt "+shellQuotePath(dst), scpFunc)
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+
+	// The remote scp always sends the source directory itself, so if
+	// there is a trailing slash we write its contents straight to dst.
+	createTop := !strings.HasSuffix(src, "/")
+
+	if c.config.UseSFTP {
+		return c.sftpDownloadDir(src, dst, createTop, excl)
+	}
+
+	scpFunc := func(w io.Writer, r *bufio.Reader) error {
+		return scpDownloadDir(dst, createTop, excl, w, r)
+	}
+
+	return c.scpSession("scp -rvf "+shellQuotePath(src), scpFunc)
 }
 
-func (c *comm) Upload(path string, input io.Reader) error {
+func (c *comm) Download(path string, output io.Writer) error {
+	if c.config.Compression {
+		return c.gzipDownload(path, output)
+	}
+
+	if c.config.UseSFTP {
+		return c.sftpDownload(path, output)
+	}
+
 	log.Println("Opening new SSH session")
-	session, err := c.client.NewSession()
+	session, err := c.newSession()
 	if err != nil {
 		return err
 	}
 
 	defer session.Close()
 
-	// Get a pipe to stdin so that we can send data down
+	// Get a pipe to stdin so that we can send acknowledgements down
 	w, err := session.StdinPipe()
 	if err != nil {
 		return err
 	}
 
-	// Set stderr/stdout to a bytes buffer
-	stderr := new(bytes.Buffer)
-	stdout := new(bytes.Buffer)
-	session.Stderr = stderr
-	session.Stdout = stdout
-
 	// We only want to close once, so we nil w after we close it,
 	// and only close in the defer if it hasn't been closed already.
 	defer func() {
@@ -100,62 +384,978 @@ func (c *comm) Upload(path string, input io.Reader) error {
 		}
 	}()
 
-	// The target directory and file for talking the SCP protocol
-	target_dir := filepath.Dir(path)
-	target_file := filepath.Base(path)
+	// Stdout is where the actual SCP protocol data comes back from
+	// the remote side.
+	stdoutR, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(newRateLimitReader(stdoutR, c.config.TransferRateLimit))
 
-	// Start the sink mode on the other side
-	// TODO(mitchellh): There are probably issues with shell escaping the path
-	log.Println("Starting remote scp process in sink mode")
-	if err = session.Start("scp -vt " + target_dir); err != nil {
+	stderr := new(bytes.Buffer)
+	session.Stderr = stderr
+
+	// Start the source mode on the other side
+	log.Println("Starting remote scp process in source mode")
+	if err = session.Start("scp -vf " + shellQuotePath(path)); err != nil {
 		return err
 	}
 
-	// Determine the length of the upload content by copying it
-	// into an in-memory buffer. Note that this means what we upload
-	// must fit into memory.
-	log.Println("Copying input data into in-memory buffer so we can get the length")
-	input_memory := new(bytes.Buffer)
-	if _, err = io.Copy(input_memory, input); err != nil {
+	// Tell the remote side we're ready to receive the file
+	log.Println("Beginning file download...")
+	fmt.Fprint(w, "\x00")
+
+	// Read the file header, which tells us the mode and size of the
+	// file that is about to be sent.
+	mode, size, err := scpReadFileHeader(r)
+	if err != nil {
+		log.Printf("scp stderr (length %d): %s", stderr.Len(), stderr.String())
 		return err
 	}
 
-	// Start the protocol
-	log.Println("Beginning file upload...")
-	fmt.Fprintln(w, "C0644", input_memory.Len(), target_file)
-	io.Copy(w, input_memory)
+	log.Printf("Downloading file with mode %s and size %d", mode, size)
 	fmt.Fprint(w, "\x00")
 
-	// TODO(mitchellh): Each step above results in a 0/1/2 being sent by
-	// the remote side to confirm. We should check for those confirmations.
+	// Copy exactly the size of the file out of the stream, since there
+	// is a confirmation byte that follows the data.
+	if _, err = io.CopyN(output, r, size); err != nil {
+		return err
+	}
+
+	// The remote side sends a status after the data, and then waits
+	// for us to acknowledge that we received everything.
+	if err = checkSCPStatus(r); err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, "\x00")
 
 	// Close the stdin, which sends an EOF, and then set w to nil so that
 	// our defer func doesn't close it again since that is unsafe with
 	// the Go SSH package.
-	log.Println("Upload complete, closing stdin pipe")
+	log.Println("Download complete, closing stdin pipe")
 	w.Close()
 	w = nil
 
-	// Wait for the SCP connection to close, meaning it has consumed all
-	// our data and has completed. Or has errored.
 	log.Println("Waiting for SSH session to complete")
 	err = session.Wait()
 	if err != nil {
 		if exitErr, ok := err.(*ssh.ExitError); ok {
-			// Otherwise, we have an ExitErorr, meaning we can just read
-			// the exit status
 			log.Printf("non-zero exit status: %d", exitErr.ExitStatus())
 		}
 
 		return err
 	}
 
-	log.Printf("scp stdout (length %d): %#v", stdout.Len(), stdout.Bytes())
 	log.Printf("scp stderr (length %d): %s", stderr.Len(), stderr.String())
 
 	return nil
 }
 
-func (c *comm) Download(string, io.Writer) error {
-	panic("not implemented yet")
+func (c *comm) sftpUpload(path string, input io.Reader) error {
+	return c.sftpSession(func(client *sftpClient) error {
+		return sftpUploadFile(client, path, input, 0644)
+	})
+}
+
+func (c *comm) sftpUploadDir(dst string, src string, excl []string) error {
+	return c.sftpSession(func(client *sftpClient) error {
+		if !strings.HasSuffix(src, "/") {
+			fi, err := os.Stat(src)
+			if err != nil {
+				return err
+			}
+
+			name := filepath.Base(src)
+			dst = path.Join(dst, name)
+			sftpMkdir(client, dst, fi.Mode())
+			return sftpUploadDir(client, dst, src, name, excl)
+		}
+
+		return sftpUploadDir(client, dst, src, "", excl)
+	})
+}
+
+func (c *comm) sftpDownload(path string, output io.Writer) error {
+	return c.sftpSession(func(client *sftpClient) error {
+		return sftpDownloadFile(client, path, output)
+	})
+}
+
+func (c *comm) sftpDownloadDir(src string, dst string, createTop bool, excl []string) error {
+	return c.sftpSession(func(client *sftpClient) error {
+		relPath := ""
+		if createTop {
+			relPath = path.Base(src)
+			dst = filepath.Join(dst, relPath)
+		}
+
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+
+		return sftpDownloadDir(client, src, dst, relPath, excl)
+	})
+}
+
+// ptySettings returns the terminal type and dimensions of the PTY to
+// request for commands, filling in the defaults.
+func (c *comm) ptySettings() (term string, width int, height int) {
+	term = c.config.PTYTerm
+	if term == "" {
+		term = defaultPTYTerm
+	}
+
+	width = c.config.PTYWidth
+	if width <= 0 {
+		width = defaultPTYWidth
+	}
+
+	height = c.config.PTYHeight
+	if height <= 0 {
+		height = defaultPTYHeight
+	}
+
+	return
+}
+
+// newSession opens a new SSH session, waiting for a free slot first if
+// the number of sessions is limited. The slot is released once the session
+// is closed. If opening the session fails and we know how to reconnect,
+// the connection is re-established and we try once more.
+func (c *comm) newSession() (*pooledSession, error) {
+	release := func() {}
+	if c.sessions != nil {
+		c.sessions <- struct{}{}
+		release = func() { <-c.sessions }
+	}
+
+	session, err := c.openSession()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return &pooledSession{Session: session, release: release}, nil
+}
+
+func (c *comm) openSession() (*ssh.Session, error) {
+	c.l.Lock()
+	client := c.client
+	c.l.Unlock()
+
+	session, err := client.NewSession()
+	if err == nil || c.config.Connection == nil {
+		return session, err
+	}
+
+	log.Printf("[WARN] Error opening SSH session, reconnecting: %s", err)
+	client, err = c.reconnect(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewSession()
+}
+
+// reconnect replaces the given broken client with a new connection,
+// backing off exponentially between attempts until ReconnectTimeout
+// has passed.
+func (c *comm) reconnect(broken *ssh.ClientConn) (*ssh.ClientConn, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	// Someone else may have already reconnected while we waited
+	if c.client != broken {
+		return c.client, nil
+	}
+
+	broken.Close()
+
+	deadline := time.Now().Add(c.config.ReconnectTimeout)
+	delay := reconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		client, err := c.connect()
+		if err == nil {
+			log.Printf("Reconnected to SSH (attempt %d)", attempt)
+			c.client = client
+			c.startKeepAlive(client)
+			return client, nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return nil, fmt.Errorf("Error reconnecting to SSH: %s", err)
+		}
+
+		log.Printf("[WARN] SSH reconnect attempt %d failed, retrying in %s: %s", attempt, delay, err)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// connect opens a new connection and performs the SSH handshake on it.
+func (c *comm) connect() (*ssh.ClientConn, error) {
+	conn, err := c.config.Connection()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Client(conn, c.config.SSHConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// startKeepAlive starts checking the liveness of the given client in the
+// background, if keepalives are enabled.
+func (c *comm) startKeepAlive(client *ssh.ClientConn) {
+	if c.config.KeepAliveInterval <= 0 {
+		return
+	}
+
+	go c.keepAlive(client)
+}
+
+// keepAlive periodically checks that the given client is still alive. If
+// it isn't, the client is closed so that anything waiting on it fails
+// rather than hanging forever, and the next session that is opened will
+// reconnect. This returns once the client is no longer in use.
+func (c *comm) keepAlive(client *ssh.ClientConn) {
+	interval := c.config.KeepAliveInterval
+	for {
+		time.Sleep(interval)
+
+		c.l.Lock()
+		current := c.client == client
+		c.l.Unlock()
+		if !current {
+			return
+		}
+
+		if err := pingClient(client, interval); err != nil {
+			log.Printf("[WARN] SSH keepalive failed, closing connection: %s", err)
+			client.Close()
+			return
+		}
+	}
+}
+
+// scpSession starts the given scp command on a new SSH session and calls
+// the function with the stdin and stdout of the remote process so that
+// it can talk the SCP protocol. Once the function returns, stdin is closed
+// and we wait for the remote side to complete.
+func (c *comm) scpSession(scpCommand string, f func(io.Writer, *bufio.Reader) error) error {
+	log.Println("Opening new SSH session")
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	// Get a pipe to stdin so that we can send data down
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// We only want to close once, so we nil w after we close it,
+	// and only close in the defer if it hasn't been closed already.
+	defer func() {
+		if w != nil {
+			w.Close()
+		}
+	}()
+
+	// Stdout is where the remote side sends its confirmations
+	stdoutR, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(newRateLimitReader(stdoutR, c.config.TransferRateLimit))
+
+	stderr := new(bytes.Buffer)
+	session.Stderr = stderr
+
+	log.Printf("Starting remote scp process: %s", scpCommand)
+	if err = session.Start(scpCommand); err != nil {
+		return err
+	}
+
+	if err = f(newRateLimitWriter(w, c.config.TransferRateLimit), r); err != nil {
+		log.Printf("scp stderr (length %d): %s", stderr.Len(), stderr.String())
+		return err
+	}
+
+	// Close the stdin, which sends an EOF, and then set w to nil so that
+	// our defer func doesn't close it again since that is unsafe with
+	// the Go SSH package.
+	log.Println("SCP session complete, closing stdin pipe")
+	w.Close()
+	w = nil
+
+	log.Println("Waiting for SSH session to complete")
+	err = session.Wait()
+	if err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			log.Printf("non-zero exit status: %d", exitErr.ExitStatus())
+		}
+
+		return err
+	}
+
+	log.Printf("scp stderr (length %d): %s", stderr.Len(), stderr.String())
+
+	return nil
+}
+
+// sftpSession opens a new SSH session running the sftp subsystem and
+// calls the given function with a client talking to it.
+func (c *comm) sftpSession(f func(*sftpClient) error) error {
+	log.Println("Opening new SSH session for sftp")
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf("Error starting sftp subsystem: %s", err)
+	}
+
+	client, err := newSftpClient(
+		newRateLimitReader(r, c.config.TransferRateLimit),
+		newRateLimitWriter(w, c.config.TransferRateLimit))
+	if err != nil {
+		return fmt.Errorf("Error initializing sftp: %s", err)
+	}
+
+	return f(client)
+}
+
+// sftpUploadFile uploads the contents of input to the remote path using
+// an existing SFTP client.
+func sftpUploadFile(client *sftpClient, path string, input io.Reader, perm os.FileMode) error {
+	log.Printf("Opening remote file for sftp upload: %s", path)
+	handle, err := client.Open(path, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc, uint32(perm.Perm()))
+	if err != nil {
+		return err
+	}
+
+	var offset uint64
+	buf := make([]byte, sftpMaxData)
+	for {
+		n, err := input.Read(buf)
+		if n > 0 {
+			if werr := client.Write(handle, offset, buf[:n]); werr != nil {
+				client.Close(handle)
+				return werr
+			}
+
+			offset += uint64(n)
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			client.Close(handle)
+			return err
+		}
+	}
+
+	log.Printf("sftp upload complete (%d bytes)", offset)
+	return client.Close(handle)
+}
+
+// sftpUploadDir uploads the contents of the local directory src into the
+// remote directory dst, which must already exist. relPath is the path
+// relative to the root of the whole upload, for matching excludes.
+func sftpUploadDir(client *sftpClient, dst string, src string, relPath string, excl []string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		localPath := filepath.Join(src, entry.Name())
+		remotePath := path.Join(dst, entry.Name())
+		entryRelPath := filepath.Join(relPath, entry.Name())
+		if common.UploadExcluded(entryRelPath, excl) {
+			log.Printf("Excluding from upload: %s", entryRelPath)
+			continue
+		}
+
+		fi, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			sftpMkdir(client, remotePath, fi.Mode())
+			if err := sftpUploadDir(client, remotePath, localPath, entryRelPath, excl); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !fi.Mode().IsRegular() {
+			log.Printf("Skipping non-regular file: %s", localPath)
+			continue
+		}
+
+		input, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+
+		err = sftpUploadFile(client, remotePath, input, fi.Mode())
+		input.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sftpDownloadFile downloads the contents of the remote path into the
+// output using an existing SFTP client.
+func sftpDownloadFile(client *sftpClient, path string, output io.Writer) error {
+	log.Printf("Opening remote file for sftp download: %s", path)
+	handle, err := client.Open(path, sftpFlagRead, 0)
+	if err != nil {
+		return err
+	}
+
+	var offset uint64
+	for {
+		data, err := client.Read(handle, offset, sftpMaxData)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			client.Close(handle)
+			return err
+		}
+
+		if _, err := output.Write(data); err != nil {
+			client.Close(handle)
+			return err
+		}
+
+		offset += uint64(len(data))
+	}
+
+	log.Printf("sftp download complete (%d bytes)", offset)
+	return client.Close(handle)
+}
+
+// sftpDownloadDir downloads the contents of the remote directory src into
+// the local directory dst recursively, skipping excluded paths.
+func sftpDownloadDir(client *sftpClient, src string, dst string, relPath string, excl []string) error {
+	entries, err := client.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		remotePath := path.Join(src, entry.Name)
+		localPath := filepath.Join(dst, entry.Name)
+		entryRelPath := filepath.Join(relPath, entry.Name)
+		if common.UploadExcluded(entryRelPath, excl) {
+			log.Printf("Excluding from download: %s", entryRelPath)
+			continue
+		}
+
+		if entry.Mode.IsDir() {
+			if err := os.MkdirAll(localPath, entry.Mode.Perm()); err != nil {
+				return err
+			}
+
+			if err := sftpDownloadDir(client, remotePath, localPath, entryRelPath, excl); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !entry.Mode.IsRegular() {
+			log.Printf("Skipping non-regular file: %s", remotePath)
+			continue
+		}
+
+		err := func() error {
+			output, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode.Perm())
+			if err != nil {
+				return err
+			}
+			defer output.Close()
+
+			return sftpDownloadFile(client, remotePath, output)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sftpMkdir creates a remote directory. SFTP v3 has no way to tell why a
+// mkdir failed, so a failure is only logged: if the directory really
+// doesn't exist, uploading into it will fail with a clearer error.
+func sftpMkdir(client *sftpClient, path string, mode os.FileMode) {
+	log.Printf("Creating remote directory for sftp upload: %s", path)
+	if err := client.Mkdir(path, uint32(mode.Perm())); err != nil {
+		log.Printf("[WARN] Error creating directory, it may already exist: %s", err)
+	}
+}
+
+// checkSCPStatus reads a single status byte from the SCP protocol stream
+// and turns it into an error if the remote side reported a problem. A
+// zero byte means success, anything else is followed by a message line.
+func checkSCPStatus(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if code != 0 {
+		// Treat any non-zero (really 1 and 2) as fatal errors
+		message, _, err := r.ReadLine()
+		if err != nil {
+			return fmt.Errorf("Error reading error message: %s", err)
+		}
+
+		return errors.New(string(message))
+	}
+
+	return nil
+}
+
+// scpReadFileHeader reads a "C" file header from a remote SCP process
+// running in source mode, returning the octal mode and the size of the
+// file that follows.
+func scpReadFileHeader(r *bufio.Reader) (string, int64, error) {
+	code, err := r.ReadByte()
+	if err != nil {
+		return "", 0, err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", 0, fmt.Errorf("Error reading scp header: %s", err)
+	}
+
+	line = strings.TrimRight(line, "\n")
+
+	switch code {
+	case 'C':
+		// Continue below to parse the header
+	case '\x01', '\x02':
+		return "", 0, errors.New(line)
+	default:
+		return "", 0, fmt.Errorf("Unexpected scp message: %q", string(code)+line)
+	}
+
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return "", 0, fmt.Errorf("Invalid scp file header: %q", line)
+	}
+
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid size in scp file header: %s", err)
+	}
+
+	return parts[0], size, nil
+}
+
+// scpUploadFile sends a single file to a remote SCP process running in
+// sink mode, checking the confirmation after each step.
+func scpUploadFile(name string, input io.Reader, size int64, mode os.FileMode, w io.Writer, r *bufio.Reader) error {
+	log.Printf("Beginning upload of '%s' (%d bytes)...", name, size)
+	fmt.Fprintf(w, "C%04o %d %s\n", mode.Perm(), size, name)
+	if err := checkSCPStatus(r); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w, input, size); err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, "\x00")
+	return checkSCPStatus(r)
+}
+
+// scpUploadDirProtocol creates a directory on the remote side with the
+// given name and mode, calls f to upload its contents, and then moves
+// back out of the directory.
+func scpUploadDirProtocol(name string, mode os.FileMode, w io.Writer, r *bufio.Reader, f func() error) error {
+	log.Printf("SCP: starting directory upload: %s", name)
+	fmt.Fprintf(w, "D%04o 0 %s\n", mode.Perm(), name)
+	if err := checkSCPStatus(r); err != nil {
+		return err
+	}
+
+	if err := f(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "E")
+	return checkSCPStatus(r)
+}
+
+// scpUploadDir uploads the contents of the local directory at root
+// recursively. relPath is the path of the directory relative to the root
+// of the whole upload, and is what exclude patterns are matched against.
+func scpUploadDir(root string, relPath string, excl []string, w io.Writer, r *bufio.Reader) error {
+	f, err := os.Open(root)
+	if err != nil {
+		return err
+	}
+
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		entryRelPath := filepath.Join(relPath, entry.Name())
+		if common.UploadExcluded(entryRelPath, excl) {
+			log.Printf("Excluding from upload: %s", entryRelPath)
+			continue
+		}
+
+		// Readdir doesn't follow symlinks, but we want to upload
+		// whatever they point to.
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			err = scpUploadDirProtocol(fi.Name(), fi.Mode(), w, r, func() error {
+				return scpUploadDir(path, entryRelPath, excl, w, r)
+			})
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !fi.Mode().IsRegular() {
+			log.Printf("Skipping non-regular file: %s", path)
+			continue
+		}
+
+		err = func() error {
+			input, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer input.Close()
+
+			return scpUploadFile(fi.Name(), input, fi.Size(), fi.Mode(), w, r)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scpDownloadDir is the sink side of a recursive scp, which writes the
+// directories and files sent by the remote side below dst. If createTop
+// is false, the contents of the directory that was sent are written
+// directly into dst rather than into a directory of the same name.
+func scpDownloadDir(dst string, createTop bool, excl []string, w io.Writer, r *bufio.Reader) error {
+	type dirEntry struct {
+		localPath string
+		relPath   string
+		excluded  bool
+	}
+
+	dirs := make([]dirEntry, 0)
+
+	// Tell the remote side we're ready to receive
+	fmt.Fprint(w, "\x00")
+
+	for {
+		code, err := r.ReadByte()
+		if err == io.EOF {
+			if len(dirs) > 0 {
+				return errors.New("Unexpected end of scp stream")
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("Error reading scp message: %s", err)
+		}
+
+		line = strings.TrimRight(line, "\n")
+
+		switch code {
+		case 'C', 'D':
+			mode, size, name, err := scpParseHeader(line)
+			if err != nil {
+				return err
+			}
+
+			if len(dirs) == 0 {
+				if code == 'C' {
+					return errors.New("The remote source is not a directory")
+				}
+
+				localPath := dst
+				relPath := ""
+				if createTop {
+					localPath = filepath.Join(dst, name)
+					relPath = name
+				}
+
+				if err := os.MkdirAll(localPath, mode); err != nil {
+					return err
+				}
+
+				dirs = append(dirs, dirEntry{localPath, relPath, false})
+				break
+			}
+
+			parent := dirs[len(dirs)-1]
+			entry := dirEntry{
+				localPath: filepath.Join(parent.localPath, name),
+				relPath:   filepath.Join(parent.relPath, name),
+				excluded:  parent.excluded,
+			}
+
+			if !entry.excluded && common.UploadExcluded(entry.relPath, excl) {
+				log.Printf("Excluding from download: %s", entry.relPath)
+				entry.excluded = true
+			}
+
+			if code == 'D' {
+				if !entry.excluded {
+					if err := os.MkdirAll(entry.localPath, mode); err != nil {
+						return err
+					}
+				}
+
+				dirs = append(dirs, entry)
+				break
+			}
+
+			// Confirm the header, then receive the file data, which is
+			// thrown away if the file is excluded.
+			fmt.Fprint(w, "\x00")
+			if err := scpDownloadFile(entry.localPath, entry.excluded, mode, size, r); err != nil {
+				return err
+			}
+
+			if err := checkSCPStatus(r); err != nil {
+				return err
+			}
+		case 'E':
+			if len(dirs) == 0 {
+				return errors.New("Unexpected end of directory in scp stream")
+			}
+
+			dirs = dirs[:len(dirs)-1]
+		case 'T':
+			// Times are only sent if we ask for them, ignore them
+		case '\x01', '\x02':
+			return errors.New(line)
+		default:
+			return fmt.Errorf("Unexpected scp message: %q", string(code)+line)
+		}
+
+		fmt.Fprint(w, "\x00")
+	}
+}
+
+// scpDownloadFile writes size bytes of file data from the scp stream to
+// the local path, or discards them if the file is excluded.
+func scpDownloadFile(localPath string, excluded bool, mode os.FileMode, size int64, r io.Reader) error {
+	if excluded {
+		_, err := io.CopyN(ioutil.Discard, r, size)
+		return err
+	}
+
+	f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(f, r, size)
+	return err
+}
+
+// scpParseHeader parses the mode, size and name of a file or directory
+// header sent by scp, without the leading "C" or "D".
+func scpParseHeader(line string) (os.FileMode, int64, string, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 {
+		return 0, 0, "", fmt.Errorf("Invalid scp header: %q", line)
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("Invalid mode in scp header: %s", err)
+	}
+
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("Invalid size in scp header: %s", err)
+	}
+
+	// Never let the remote side write outside of the directory
+	name := parts[2]
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return 0, 0, "", fmt.Errorf("Invalid name in scp header: %q", name)
+	}
+
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+// uploadSource determines the size of the data that is going to be
+// uploaded. If file information was given or the reader is a file, the
+// reader is streamed directly. Otherwise, the data is copied into a
+// temporary file on disk (rather than into memory) so that its length is
+// known. The returned cleanup function must always be called.
+func uploadSource(input io.Reader, fi *os.FileInfo) (io.Reader, int64, func(), error) {
+	noop := func() {}
+
+	if fi != nil {
+		return input, (*fi).Size(), noop, nil
+	}
+
+	if f, ok := input.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			return input, info.Size(), noop, nil
+		}
+	}
+
+	log.Println("Copying input data into temporary file so we can get the length")
+	tf, err := ioutil.TempFile("", "packer-upload")
+	if err != nil {
+		return nil, 0, noop, fmt.Errorf("Error creating temporary file for upload: %s", err)
+	}
+
+	cleanup := func() {
+		tf.Close()
+		os.Remove(tf.Name())
+	}
+
+	size, err := io.Copy(tf, input)
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+
+	if _, err := tf.Seek(0, 0); err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+
+	return tf, size, cleanup, nil
+}
+
+// pingClient verifies that the remote side of the client is responding
+// by opening and immediately closing a session.
+func pingClient(client *ssh.ClientConn, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		session, err := client.NewSession()
+		if err == nil {
+			session.Close()
+		}
+
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(timeout):
+		return errors.New("timeout waiting for keepalive response")
+	}
+}
+
+// pooledSession is an SSH session that releases its slot in the session
+// limit when it is closed, which is safe to do more than once.
+type pooledSession struct {
+	*ssh.Session
+
+	release func()
+	once    sync.Once
+}
+
+func (s *pooledSession) Close() error {
+	err := s.Session.Close()
+	s.once.Do(s.release)
+	return err
+}
+
+// signalNumbers are the numbers of the signals that can be sent in SSH
+// exit-signal messages (RFC 4254), which are named without the "SIG".
+var signalNumbers = map[string]int{
+	"ABRT": 6,
+	"ALRM": 14,
+	"FPE":  8,
+	"HUP":  1,
+	"ILL":  4,
+	"INT":  2,
+	"KILL": 9,
+	"PIPE": 13,
+	"QUIT": 3,
+	"SEGV": 11,
+	"TERM": 15,
+	"USR1": 10,
+	"USR2": 12,
 }
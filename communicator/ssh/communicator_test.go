@@ -1,10 +1,15 @@
 package ssh
 
 import (
+	"bufio"
 	"bytes"
 	"code.google.com/p/go.crypto/ssh"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -120,7 +125,7 @@ func TestNew_Invalid(t *testing.T) {
 		t.Fatalf("unable to dial to remote side: %s", err)
 	}
 
-	_, err = New(conn, clientConfig)
+	_, err = New(conn, &Config{SSHConfig: clientConfig})
 	if err == nil {
 		t.Fatal("should have had an error connecting")
 	}
@@ -139,7 +144,7 @@ func TestStart(t *testing.T) {
 		t.Fatalf("unable to dial to remote side: %s", err)
 	}
 
-	client, err := New(conn, clientConfig)
+	client, err := New(conn, &Config{SSHConfig: clientConfig})
 	if err != nil {
 		t.Fatalf("error connecting to SSH: %s", err)
 	}
@@ -151,3 +156,306 @@ func TestStart(t *testing.T) {
 
 	client.Start(&cmd)
 }
+
+func TestCheckSCPStatus(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("\x00"))
+	if err := checkSCPStatus(r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	r = bufio.NewReader(bytes.NewBufferString("\x01scp: permission denied\n"))
+	err := checkSCPStatus(r)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if err.Error() != "scp: permission denied" {
+		t.Fatalf("bad error: %s", err)
+	}
+}
+
+func TestSCPReadFileHeader(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("C0644 42 foo.txt\n"))
+	mode, size, err := scpReadFileHeader(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if mode != "0644" {
+		t.Fatalf("bad mode: %s", mode)
+	}
+
+	if size != 42 {
+		t.Fatalf("bad size: %d", size)
+	}
+
+	// Errors from the remote side
+	r = bufio.NewReader(bytes.NewBufferString("\x01scp: /foo: No such file or directory\n"))
+	if _, _, err := scpReadFileHeader(r); err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Bad header
+	r = bufio.NewReader(bytes.NewBufferString("C0644 foo\n"))
+	if _, _, err := scpReadFileHeader(r); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestUploadSource_FileInfo(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("foobar"))
+	tf.Close()
+
+	fi, err := os.Stat(tf.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := bytes.NewBufferString("foobar")
+	r, size, cleanup, err := uploadSource(input, &fi)
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if r != input {
+		t.Fatal("should stream the original reader")
+	}
+
+	if size != 6 {
+		t.Fatalf("bad size: %d", size)
+	}
+}
+
+func TestUploadSource_Spooled(t *testing.T) {
+	r, size, cleanup, err := uploadSource(bytes.NewBufferString("foobar"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if size != 6 {
+		t.Fatalf("bad size: %d", size)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "foobar" {
+		t.Fatalf("bad data: %s", data)
+	}
+
+	tf := r.(*os.File)
+	cleanup()
+	if _, err := os.Stat(tf.Name()); !os.IsNotExist(err) {
+		t.Fatal("temporary file should be removed")
+	}
+}
+
+func TestSCPUploadFile(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader(make([]byte, 2)))
+	w := new(bytes.Buffer)
+	err := scpUploadFile("foo", bytes.NewBufferString("bar"), 3, 0644, w, r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if w.String() != "C0644 3 foo\nbar\x00" {
+		t.Fatalf("bad protocol: %q", w.String())
+	}
+}
+
+func TestSCPUploadFile_Error(t *testing.T) {
+	// The remote side accepts the header but fails to write the data
+	r := bufio.NewReader(bytes.NewBufferString("\x00\x01scp: foo: No space left on device\n"))
+	err := scpUploadFile("foo", bytes.NewBufferString("bar"), 3, 0644, new(bytes.Buffer), r)
+	if err == nil || err.Error() != "scp: foo: No space left on device" {
+		t.Fatalf("bad error: %s", err)
+	}
+}
+
+func TestSCPUploadDir(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	if err := os.Mkdir(filepath.Join(td, "sub"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(td, "sub", "foo"), []byte("bar"), 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(td, "skip.tmp"), []byte("nope"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The remote side confirms everything we send
+	r := bufio.NewReader(bytes.NewReader(make([]byte, 16)))
+	w := new(bytes.Buffer)
+	if err := scpUploadDir(td, "", []string{"*.tmp"}, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "D0755 0 sub\nC0700 3 foo\nbar\x00E\n"
+	if w.String() != expected {
+		t.Fatalf("bad protocol: %q", w.String())
+	}
+}
+
+func TestSCPUploadDir_Error(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	if err := ioutil.WriteFile(filepath.Join(td, "foo"), []byte("bar"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	r := bufio.NewReader(bytes.NewBufferString("\x01Permission denied\n"))
+	err = scpUploadDir(td, "", nil, new(bytes.Buffer), r)
+	if err == nil || err.Error() != "Permission denied" {
+		t.Fatalf("bad error: %s", err)
+	}
+}
+
+func TestSCPDownloadDir(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	stream := "D0755 0 src\n" +
+		"D0700 0 sub\n" +
+		"C0644 3 foo\nbar\x00" +
+		"C0644 4 skip.tmp\nnope\x00" +
+		"E\n" +
+		"E\n"
+
+	r := bufio.NewReader(bytes.NewBufferString(stream))
+	w := new(bytes.Buffer)
+	if err := scpDownloadDir(td, true, []string{"*.tmp"}, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// We confirm the start and every message, and every file once more
+	if w.String() != strings.Repeat("\x00", 9) {
+		t.Fatalf("bad protocol: %q", w.String())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "src", "sub", "foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "bar" {
+		t.Fatalf("bad: %s", data)
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "src", "sub", "skip.tmp")); err == nil {
+		t.Fatal("excluded file should not exist")
+	}
+}
+
+func TestSCPDownloadDir_NoTop(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	r := bufio.NewReader(bytes.NewBufferString("D0755 0 src\nC0644 3 foo\nbar\x00E\n"))
+	if err := scpDownloadDir(td, false, nil, new(bytes.Buffer), r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "foo")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSCPDownloadDir_Invalid(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	streams := []string{
+		"\x01Permission denied\n",
+		"C0644 3 foo\nbar\x00",
+		"D0755 0 src\nC0644 3 ../foo\nbar\x00E\n",
+		"D0755 0 src\n",
+	}
+
+	for _, stream := range streams {
+		r := bufio.NewReader(bytes.NewBufferString(stream))
+		if err := scpDownloadDir(td, true, nil, new(bytes.Buffer), r); err == nil {
+			t.Fatalf("should have error: %q", stream)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "foo")); err == nil {
+		t.Fatal("should not write outside of the directory")
+	}
+}
+
+func TestCommPTYSettings(t *testing.T) {
+	c := &comm{config: &Config{}}
+	term, width, height := c.ptySettings()
+	if term != "xterm" || width != 80 || height != 40 {
+		t.Fatalf("bad: %s %d %d", term, width, height)
+	}
+
+	c.config = &Config{PTYTerm: "vt100", PTYWidth: 132, PTYHeight: 24}
+	term, width, height = c.ptySettings()
+	if term != "vt100" || width != 132 || height != 24 {
+		t.Fatalf("bad: %s %d %d", term, width, height)
+	}
+}
+
+func TestCommCanRetry(t *testing.T) {
+	connection := func() (net.Conn, error) { return nil, nil }
+
+	c := &comm{config: &Config{Connection: connection}}
+	if c.canRetry(new(packer.RemoteCmd), 0) {
+		t.Fatal("should not retry by default")
+	}
+
+	c.config.CommandRetries = 2
+	if !c.canRetry(new(packer.RemoteCmd), 1) {
+		t.Fatal("should retry")
+	}
+
+	if c.canRetry(new(packer.RemoteCmd), 2) {
+		t.Fatal("should not retry more than configured")
+	}
+
+	cmd := &packer.RemoteCmd{Stdin: strings.NewReader("foo")}
+	if c.canRetry(cmd, 0) {
+		t.Fatal("should not retry a command with stdin")
+	}
+
+	cmd = new(packer.RemoteCmd)
+	cmd.Cancel()
+	if c.canRetry(cmd, 0) {
+		t.Fatal("should not retry a cancelled command")
+	}
+
+	c.config.Connection = nil
+	if c.canRetry(new(packer.RemoteCmd), 0) {
+		t.Fatal("should not retry without reconnecting")
+	}
+}
@@ -0,0 +1,111 @@
+package ssh
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+// go.crypto/ssh only negotiates the "none" compression method, so the
+// SSH transport itself can't be compressed. Instead, when compression is
+// enabled, single files are gzipped on one side and unzipped by a gzip
+// process on the other, which compresses the same data the transport
+// would have.
+
+// gzipUpload uploads the input to the given path on the remote machine,
+// compressed on the way.
+func (c *comm) gzipUpload(dst string, input io.Reader) error {
+	return c.gzipSession("gzip -dc > "+shellQuotePath(dst), func(w io.Writer, r io.Reader) error {
+		return gzipCopy(newRateLimitWriter(w, c.config.TransferRateLimit), input)
+	})
+}
+
+// gzipDownload downloads the file at the given path on the remote machine
+// into the output, compressed on the way.
+func (c *comm) gzipDownload(src string, output io.Writer) error {
+	return c.gzipSession("gzip -c < "+shellQuotePath(src), func(w io.Writer, r io.Reader) error {
+		return gunzipCopy(output, newRateLimitReader(r, c.config.TransferRateLimit))
+	})
+}
+
+// gzipSession starts the given gzip command on a new SSH session and calls
+// the function with its stdin and stdout. Once the function returns, stdin
+// is closed and we wait for the remote side to complete.
+func (c *comm) gzipSession(command string, f func(io.Writer, io.Reader) error) error {
+	log.Println("Opening new SSH session")
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// We only want to close once, so we nil w after we close it,
+	// and only close in the defer if it hasn't been closed already.
+	defer func() {
+		if w != nil {
+			w.Close()
+		}
+	}()
+
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr := new(bytes.Buffer)
+	session.Stderr = stderr
+
+	log.Printf("Starting remote gzip process: %s", command)
+	if err = session.Start(command); err != nil {
+		return err
+	}
+
+	ferr := f(w, r)
+
+	w.Close()
+	w = nil
+
+	// Drain whatever is left of stdout so the remote side can exit
+	io.Copy(ioutil.Discard, r)
+
+	// Wait even if the transfer failed, since the most useful error is
+	// usually what the remote side said, such as that gzip isn't there.
+	if err = session.Wait(); err != nil {
+		log.Printf("gzip stderr (length %d): %s", stderr.Len(), stderr.String())
+		return fmt.Errorf("Error running '%s': %s\n%s", command, err, stderr.String())
+	}
+
+	return ferr
+}
+
+// gzipCopy compresses everything read from r into w.
+func gzipCopy(w io.Writer, r io.Reader) error {
+	zw := gzip.NewWriter(w)
+	if _, err := io.Copy(zw, r); err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+// gunzipCopy decompresses everything read from r into w.
+func gunzipCopy(w io.Writer, r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	_, err = io.Copy(w, zr)
+	return err
+}
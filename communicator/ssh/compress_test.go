@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGzipCopy(t *testing.T) {
+	data := bytes.Repeat([]byte("packer"), 10000)
+
+	compressed := new(bytes.Buffer)
+	if err := gzipCopy(compressed, bytes.NewReader(data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if compressed.Len() >= len(data) {
+		t.Fatalf("should be compressed: %d", compressed.Len())
+	}
+
+	result := new(bytes.Buffer)
+	if err := gunzipCopy(result, compressed); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(result.Bytes(), data) {
+		t.Fatal("bad data")
+	}
+}
+
+func TestGunzipCopy_Invalid(t *testing.T) {
+	if err := gunzipCopy(new(bytes.Buffer), bytes.NewBufferString("packer")); err == nil {
+		t.Fatal("should have error")
+	}
+}
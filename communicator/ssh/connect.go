@@ -0,0 +1,76 @@
+package ssh
+
+import (
+	"code.google.com/p/go.crypto/ssh"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ConnectFunc is a convenience method for returning a function that just
+// uses net.Dial to connect to the remote end, which is suitable for use
+// as the Connection of the SSH communicator configuration.
+func ConnectFunc(network, addr string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		c, err := net.DialTimeout(network, addr, 15*time.Second)
+		if err != nil {
+			return nil, err
+		}
+
+		// Have the OS notice dead connections, too
+		if tcpConn, ok := c.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(true)
+		}
+
+		return c, nil
+	}
+}
+
+// BastionConnectFunc is a convenience method for returning a function
+// that connects to a host through an SSH tunnel on a bastion host. The
+// connection to the bastion itself is made with bDial, such as a function
+// returned by ConnectFunc. The bastion connection is closed when the
+// returned connection is closed.
+func BastionConnectFunc(
+	bDial func() (net.Conn, error),
+	bConf *ssh.ClientConfig,
+	proto string,
+	addr string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		// Connect to the bastion
+		bConn, err := bDial()
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to bastion: %s", err)
+		}
+
+		bastion, err := ssh.Client(bConn, bConf)
+		if err != nil {
+			bConn.Close()
+			return nil, fmt.Errorf("Error connecting to bastion: %s", err)
+		}
+
+		// Connect through to the end host
+		conn, err := bastion.Dial(proto, addr)
+		if err != nil {
+			bastion.Close()
+			return nil, fmt.Errorf("Error connecting to host through bastion: %s", err)
+		}
+
+		return &bastionConn{
+			Conn:    conn,
+			bastion: bastion,
+		}, nil
+	}
+}
+
+// bastionConn wraps a connection tunneled through a bastion so that the
+// bastion connection is closed along with it.
+type bastionConn struct {
+	net.Conn
+	bastion *ssh.ClientConn
+}
+
+func (c *bastionConn) Close() error {
+	c.Conn.Close()
+	return c.bastion.Close()
+}
@@ -0,0 +1,32 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnectFunc(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer l.Close()
+
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	f := ConnectFunc("tcp", l.Addr().String())
+
+	c, err := f()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer c.Close()
+
+	if c.RemoteAddr().String() != l.Addr().String() {
+		t.Fatalf("bad address: %s", c.RemoteAddr())
+	}
+}
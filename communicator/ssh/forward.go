@@ -0,0 +1,88 @@
+package ssh
+
+import (
+	"io"
+	"log"
+	"net"
+)
+
+func (c *comm) ForwardLocal(local string, remote string) (io.Closer, error) {
+	l, err := net.Listen("tcp", local)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Forwarding local %s to remote %s", l.Addr(), remote)
+	go forwardListener(l, func() (net.Conn, error) {
+		// Use whatever the current client is, since we may have
+		// reconnected since the forward was started.
+		c.l.Lock()
+		client := c.client
+		c.l.Unlock()
+
+		return client.Dial("tcp", remote)
+	})
+
+	return l, nil
+}
+
+func (c *comm) ForwardRemote(remote string, local string) (io.Closer, error) {
+	c.l.Lock()
+	client := c.client
+	c.l.Unlock()
+
+	// The remote listener belongs to this connection, so it stops
+	// working if we have to reconnect.
+	l, err := client.Listen("tcp", remote)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Forwarding remote %s to local %s", remote, local)
+	go forwardListener(l, func() (net.Conn, error) {
+		return net.Dial("tcp", local)
+	})
+
+	return l, nil
+}
+
+// forwardListener accepts connections on the listener until it is closed,
+// connecting each one to a new connection from dial.
+func forwardListener(l net.Listener, dial func() (net.Conn, error)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("Stopped forwarding %s: %s", l.Addr(), err)
+			return
+		}
+
+		go forwardConn(conn, dial)
+	}
+}
+
+// forwardConn copies data in both directions between the connection and
+// a new connection from dial until either side is done.
+func forwardConn(conn net.Conn, dial func() (net.Conn, error)) {
+	defer conn.Close()
+
+	target, err := dial()
+	if err != nil {
+		log.Printf("[ERROR] Error connecting forwarded connection: %s", err)
+		return
+	}
+
+	defer target.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, conn)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(conn, target)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
@@ -0,0 +1,34 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+)
+
+func TestForwardListener(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	go forwardListener(l, func() (net.Conn, error) {
+		return net.Dial("tcp", echo.Addr().String())
+	})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testProxyRoundTrip(t, conn)
+
+	// Closing the listener stops the forward
+	l.Close()
+	if conn, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("should not be able to connect")
+	}
+}
@@ -0,0 +1,287 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"code.google.com/p/go.crypto/ssh"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// Fingerprint returns the MD5 fingerprint of a host key in the familiar
+// colon separated hex format, such as "43:51:43:a1:...".
+func Fingerprint(hostKey []byte) string {
+	sum := md5.New()
+	sum.Write(hostKey)
+
+	parts := make([]string, 0, md5.Size)
+	for _, b := range sum.Sum(nil) {
+		parts = append(parts, fmt.Sprintf("%02x", b))
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// FingerprintHostKeyChecker returns a HostKeyChecker that only accepts a
+// host key with the given MD5 fingerprint.
+func FingerprintHostKeyChecker(fingerprint string) ssh.HostKeyChecker {
+	fingerprint = strings.ToLower(fingerprint)
+	fingerprint = strings.TrimPrefix(fingerprint, "md5:")
+	return &fingerprintChecker{fingerprint}
+}
+
+type fingerprintChecker struct {
+	fingerprint string
+}
+
+func (c *fingerprintChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	actual := Fingerprint(hostKey)
+	if actual != c.fingerprint {
+		return fmt.Errorf(
+			"Host key fingerprint mismatch. Expected '%s', got '%s'",
+			c.fingerprint, actual)
+	}
+
+	return nil
+}
+
+// InsecureHostKeyChecker returns a HostKeyChecker for host keys that
+// nothing is configured to verify. Unless accept is true, which has to be
+// asked for, every key is refused, since an unverified key could be that
+// of a man in the middle. Keys that are accepted are logged as a warning.
+func InsecureHostKeyChecker(accept bool) ssh.HostKeyChecker {
+	return &insecureChecker{accept}
+}
+
+type insecureChecker struct {
+	accept bool
+}
+
+func (c *insecureChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	if addr == "" && remote != nil {
+		addr = remote.String()
+	}
+
+	if !c.accept {
+		return fmt.Errorf(
+			"Can't verify the %s host key of %s: %s. Set ssh_host_key_fingerprint "+
+				"or ssh_known_hosts_file to verify it, or ssh_disable_host_key_checking "+
+				"to accept any host key",
+			algorithm, addr, Fingerprint(hostKey))
+	}
+
+	log.Printf(
+		"WARNING: Accepting unverified %s host key for %s: %s",
+		algorithm, addr, Fingerprint(hostKey))
+	return nil
+}
+
+// KnownHostsHostKeyChecker returns a HostKeyChecker that verifies host
+// keys against an OpenSSH known_hosts file. The address is the host:port
+// that was dialed, which is what the known_hosts entries are matched
+// against, along with the IP of the remote side. The file is read on
+// every check so that it is never out of date.
+func KnownHostsHostKeyChecker(knownHostsPath string, address string) ssh.HostKeyChecker {
+	return &knownHostsChecker{knownHostsPath, address}
+}
+
+type knownHostsChecker struct {
+	path    string
+	address string
+}
+
+func (c *knownHostsChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	names := []string{knownHostsName(c.address)}
+	if remote != nil {
+		names = append(names, knownHostsName(remote.String()))
+	}
+
+	f, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("Error opening known_hosts file: %s", err)
+	}
+	defer f.Close()
+
+	found := false
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			entry, ok := parseKnownHostsLine(line)
+			if ok && entry.matches(names) {
+				if entry.revoked && bytes.Equal(entry.key, hostKey) {
+					return fmt.Errorf("Host key for %s is revoked", c.address)
+				}
+
+				if !entry.revoked && entry.algorithm == algorithm && bytes.Equal(entry.key, hostKey) {
+					found = true
+				}
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf(
+			"No matching host key for %s in %s. Got %s key: %s",
+			c.address, c.path, algorithm, Fingerprint(hostKey))
+	}
+
+	return nil
+}
+
+// knownHostsEntry is a single parsed line of a known_hosts file.
+type knownHostsEntry struct {
+	patterns  []string
+	algorithm string
+	key       []byte
+	revoked   bool
+}
+
+// matches returns true if any of the given host names matches the host
+// patterns of this entry.
+func (e *knownHostsEntry) matches(names []string) bool {
+	for _, name := range names {
+		matched := false
+		for _, pattern := range e.patterns {
+			negate := strings.HasPrefix(pattern, "!")
+			if negate {
+				pattern = pattern[1:]
+			}
+
+			if !knownHostsPatternMatch(pattern, name) {
+				continue
+			}
+
+			// A negated match means this entry never applies to the name
+			if negate {
+				matched = false
+				break
+			}
+
+			matched = true
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseKnownHostsLine parses a line of a known_hosts file. Comments, blank
+// lines, certificate authorities and anything we don't understand are
+// skipped by returning false.
+func parseKnownHostsLine(line string) (*knownHostsEntry, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, false
+	}
+
+	fields := strings.Fields(line)
+	entry := new(knownHostsEntry)
+	if strings.HasPrefix(fields[0], "@") {
+		switch fields[0] {
+		case "@revoked":
+			entry.revoked = true
+		default:
+			return nil, false
+		}
+
+		fields = fields[1:]
+	}
+
+	if len(fields) < 3 {
+		return nil, false
+	}
+
+	key, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, false
+	}
+
+	entry.patterns = strings.Split(fields[0], ",")
+	entry.algorithm = fields[1]
+	entry.key = key
+	return entry, true
+}
+
+// knownHostsName returns the name of a host:port address as it appears
+// in a known_hosts file: just the host for port 22, and "[host]:port"
+// otherwise.
+func knownHostsName(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+
+	if port == "22" {
+		return host
+	}
+
+	return fmt.Sprintf("[%s]:%s", host, port)
+}
+
+// knownHostsPatternMatch matches a single host pattern, which is either
+// a hashed host name or a glob, against a host name.
+func knownHostsPatternMatch(pattern string, name string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(name))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil)) == parts[1]
+	}
+
+	return globMatch(pattern, name)
+}
+
+// globMatch matches a name against a pattern where "*" matches any number
+// of characters and "?" matches exactly one. Unlike path.Match, brackets
+// are not special since they are used for hosts with non-standard ports.
+func globMatch(pattern string, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if globMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -0,0 +1,117 @@
+package ssh
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+var testHostKey = []byte("not really a host key")
+
+func TestFingerprint(t *testing.T) {
+	expected := "5f:01:01:fd:bf:5c:ff:fa:cb:cb:23:40:6a:50:3e:a8"
+	if actual := Fingerprint(testHostKey); actual != expected {
+		t.Fatalf("bad: %s", actual)
+	}
+}
+
+func TestFingerprintHostKeyChecker(t *testing.T) {
+	fingerprint := Fingerprint(testHostKey)
+
+	c := FingerprintHostKeyChecker("MD5:" + fingerprint)
+	if err := c.Check("", nil, "ssh-rsa", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := c.Check("", nil, "ssh-rsa", []byte("other")); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestInsecureHostKeyChecker(t *testing.T) {
+	c := InsecureHostKeyChecker(true)
+	if err := c.Check("foo:22", nil, "ssh-rsa", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Unverified keys are only accepted when asked for
+	c = InsecureHostKeyChecker(false)
+	if err := c.Check("foo:22", nil, "ssh-rsa", testHostKey); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestKnownHostsHostKeyChecker(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testHostKey)
+	other := base64.StdEncoding.EncodeToString([]byte("other"))
+
+	// A hashed entry for a host on a non-standard port
+	salt := []byte("salt")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("[hashed.example.com]:2222"))
+	hashed := fmt.Sprintf("|1|%s|%s",
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	fmt.Fprintf(tf, "# A comment\n\n")
+	fmt.Fprintf(tf, "plain.example.com,10.0.0.1 ssh-rsa %s\n", key)
+	fmt.Fprintf(tf, "[port.example.com]:2222 ssh-rsa %s\n", key)
+	fmt.Fprintf(tf, "%s ssh-rsa %s\n", hashed, key)
+	fmt.Fprintf(tf, "*.wild.com,!bad.wild.com ssh-rsa %s\n", key)
+	fmt.Fprintf(tf, "other.example.com ssh-rsa %s\n", other)
+	fmt.Fprintf(tf, "@revoked revoked.example.com ssh-rsa %s\n", key)
+	fmt.Fprintf(tf, "revoked.example.com ssh-rsa %s\n", key)
+	tf.Close()
+
+	cases := []struct {
+		address string
+		remote  string
+		ok      bool
+	}{
+		{"plain.example.com:22", "", true},
+		{"unknown.example.com:22", "10.0.0.1:22", true},
+		{"plain.example.com:2222", "", false},
+		{"port.example.com:2222", "", true},
+		{"hashed.example.com:2222", "", true},
+		{"hashed.example.com:22", "", false},
+		{"good.wild.com:22", "", true},
+		{"bad.wild.com:22", "", false},
+		{"other.example.com:22", "", false},
+		{"revoked.example.com:22", "", false},
+		{"unknown.example.com:22", "", false},
+	}
+
+	for _, tc := range cases {
+		var remote net.Addr
+		if tc.remote != "" {
+			remote, err = net.ResolveTCPAddr("tcp", tc.remote)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+
+		c := KnownHostsHostKeyChecker(tf.Name(), tc.address)
+		err := c.Check("", remote, "ssh-rsa", testHostKey)
+		if (err == nil) != tc.ok {
+			t.Fatalf("bad: %s (err: %s)", tc.address, err)
+		}
+	}
+}
+
+func TestKnownHostsHostKeyChecker_Missing(t *testing.T) {
+	c := KnownHostsHostKeyChecker("/i/dont/exist", "foo:22")
+	if err := c.Check("", nil, "ssh-rsa", testHostKey); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -3,11 +3,16 @@ package ssh
 import (
 	"crypto"
 	"crypto/dsa"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 )
 
 type SimpleKeychain struct {
@@ -15,14 +20,56 @@ type SimpleKeychain struct {
 }
 
 // AddPEMKey adds a simple PEM encoded private key to the keychain.
-func (k *SimpleKeychain) AddPEMKey(key string) (err error) {
+func (k *SimpleKeychain) AddPEMKey(key string) error {
+	return k.AddPEMKeyPassword(key, "")
+}
+
+// AddPEMKeyPassword adds a PEM encoded private key to the keychain which
+// may be encrypted with the given passphrase. RSA, DSA and ECDSA keys are
+// supported, both in their traditional OpenSSL format and as PKCS#8.
+func (k *SimpleKeychain) AddPEMKeyPassword(key string, password string) (err error) {
 	block, _ := pem.Decode([]byte(key))
-	rsakey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if block == nil {
+		return errors.New("ssh: no PEM encoded key found")
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return errors.New("ssh: key is encrypted, but no passphrase was given")
+		}
+
+		der, err = x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return fmt.Errorf("ssh: error decrypting key: %s", err)
+		}
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(der)
+	case "DSA PRIVATE KEY":
+		parsed, err = parseDSAPrivateKey(der)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return fmt.Errorf("ssh: unsupported key type: %s", block.Type)
+	}
+
 	if err != nil {
 		return
 	}
 
-	k.keys = append(k.keys, rsakey)
+	switch parsed.(type) {
+	case *rsa.PrivateKey, *dsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return fmt.Errorf("ssh: unsupported key type: %T", parsed)
+	}
+
+	k.keys = append(k.keys, parsed)
 	return
 }
 
@@ -36,6 +83,8 @@ func (k *SimpleKeychain) Key(i int) (interface{}, error) {
 		return &key.PublicKey, nil
 	case *dsa.PrivateKey:
 		return &key.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey, nil
 	}
 	panic("unknown key type")
 }
@@ -43,12 +92,103 @@ func (k *SimpleKeychain) Key(i int) (interface{}, error) {
 // Sign method for ssh.ClientKeyring interface
 func (k *SimpleKeychain) Sign(i int, rand io.Reader, data []byte) (sig []byte, err error) {
 	hashFunc := crypto.SHA1
+	if key, ok := k.keys[i].(*ecdsa.PrivateKey); ok {
+		hashFunc = ecdsaHash(key)
+	}
+
 	h := hashFunc.New()
 	h.Write(data)
 	digest := h.Sum(nil)
 	switch key := k.keys[i].(type) {
 	case *rsa.PrivateKey:
 		return rsa.SignPKCS1v15(rand, key, hashFunc, digest)
+	case *dsa.PrivateKey:
+		if key.Q.BitLen() > 160 {
+			return nil, errors.New("ssh: DSA keys must have a 160-bit subprime")
+		}
+
+		r, s, err := dsa.Sign(rand, key, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// The signature is r and s as 160-bit integers (RFC 4253)
+		sig = make([]byte, 40)
+		copyRightAligned(sig[:20], r.Bytes())
+		copyRightAligned(sig[20:], s.Bytes())
+		return sig, nil
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand, key, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// The signature is r and s as SSH mpints (RFC 5656)
+		return append(marshalMPInt(r), marshalMPInt(s)...), nil
 	}
 	return nil, errors.New("ssh: unknown key type")
 }
+
+// ecdsaHash returns the hash used for signatures by the given key, which
+// depends on the size of its curve (RFC 5656).
+func ecdsaHash(key *ecdsa.PrivateKey) crypto.Hash {
+	switch bits := key.Curve.Params().BitSize; {
+	case bits <= 256:
+		return crypto.SHA256
+	case bits <= 384:
+		return crypto.SHA384
+	}
+
+	return crypto.SHA512
+}
+
+// parseDSAPrivateKey parses a DSA private key in the OpenSSL format.
+func parseDSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
+	var k struct {
+		Version int
+		P       *big.Int
+		Q       *big.Int
+		G       *big.Int
+		Pub     *big.Int
+		Priv    *big.Int
+	}
+
+	rest, err := asn1.Unmarshal(der, &k)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: failed to parse DSA key: %s", err)
+	}
+
+	if len(rest) > 0 {
+		return nil, errors.New("ssh: garbage after DSA key")
+	}
+
+	return &dsa.PrivateKey{
+		PublicKey: dsa.PublicKey{
+			Parameters: dsa.Parameters{
+				P: k.P,
+				Q: k.Q,
+				G: k.G,
+			},
+			Y: k.Pub,
+		},
+		X: k.Priv,
+	}, nil
+}
+
+// marshalMPInt encodes a non-negative integer in the SSH mpint format.
+func marshalMPInt(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	result := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(result, uint32(len(b)))
+	return append(result, b...)
+}
+
+// copyRightAligned copies src into the end of dst, leaving any leading
+// bytes of dst as zero.
+func copyRightAligned(dst []byte, src []byte) {
+	copy(dst[len(dst)-len(src):], src)
+}
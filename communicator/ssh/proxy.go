@@ -0,0 +1,202 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ProxyConnectFunc returns a function that connects to addr through the
+// proxy server at proxyAddr, which is suitable for use as the Connection
+// of the SSH communicator configuration. The proxy type is either "socks5"
+// or "http", which uses the HTTP CONNECT method. The username and password
+// are optional.
+func ProxyConnectFunc(
+	proxyType string,
+	proxyAddr string,
+	username string,
+	password string,
+	addr string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := ConnectFunc("tcp", proxyAddr)()
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to proxy: %s", err)
+		}
+
+		switch proxyType {
+		case "socks5":
+			err = socks5Connect(conn, username, password, addr)
+		case "http":
+			conn, err = httpConnect(conn, username, password, addr)
+		default:
+			err = fmt.Errorf("Unknown proxy type: %s", proxyType)
+		}
+
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error connecting through proxy: %s", err)
+		}
+
+		return conn, nil
+	}
+}
+
+// socks5Connect asks the SOCKS5 server on the other end of conn to
+// connect to addr, as described in RFC 1928 and RFC 1929.
+func socks5Connect(conn net.Conn, username string, password string, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("Invalid port: %s", portStr)
+	}
+
+	// Negotiate the authentication method
+	greeting := []byte{5, 1, 0}
+	if username != "" {
+		greeting = []byte{5, 2, 0, 2}
+	}
+
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[0] != 5 {
+		return fmt.Errorf("Unexpected SOCKS version: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case 0:
+		// No authentication required
+	case 2:
+		if username == "" {
+			return errors.New("SOCKS proxy requires a username and password")
+		}
+
+		request := []byte{1, byte(len(username))}
+		request = append(request, username...)
+		request = append(request, byte(len(password)))
+		request = append(request, password...)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+
+		if reply[1] != 0 {
+			return errors.New("SOCKS proxy authentication failed")
+		}
+	default:
+		return errors.New("SOCKS proxy doesn't support any of our authentication methods")
+	}
+
+	// Ask the proxy to connect to the destination
+	request := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(request, 1)
+			request = append(request, ip4...)
+		} else {
+			request = append(request, 4)
+			request = append(request, ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("Host name too long: %s", host)
+		}
+
+		request = append(request, 3, byte(len(host)))
+		request = append(request, host...)
+	}
+
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// The reply has the same shape as the request, with the status in
+	// place of the command.
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+
+	if header[1] != 0 {
+		return fmt.Errorf("SOCKS proxy failed to connect to %s (error %d)", addr, header[1])
+	}
+
+	var addrLen int
+	switch header[3] {
+	case 1:
+		addrLen = net.IPv4len
+	case 4:
+		addrLen = net.IPv6len
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+
+		addrLen = int(length[0])
+	default:
+		return fmt.Errorf("Unexpected SOCKS address type: %d", header[3])
+	}
+
+	// Discard the bound address and port, we don't need them
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// httpConnect asks the HTTP proxy on the other end of conn to connect to
+// addr using the CONNECT method. The returned connection must be used in
+// place of conn since the proxy may have already sent data from addr.
+func httpConnect(conn net.Conn, username string, password string, addr string) (net.Conn, error) {
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\n", addr)
+	fmt.Fprintf(conn, "Host: %s\r\n", addr)
+	if username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		fmt.Fprintf(conn, "Proxy-Authorization: Basic %s\r\n", auth)
+	}
+
+	if _, err := fmt.Fprint(conn, "\r\n"); err != nil {
+		return conn, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		return conn, err
+	}
+
+	if resp.StatusCode != 200 {
+		return conn, fmt.Errorf("HTTP proxy failed to connect to %s: %s", addr, resp.Status)
+	}
+
+	return &bufferedConn{conn, r}, nil
+}
+
+// bufferedConn is a net.Conn that reads from a bufio.Reader wrapping the
+// connection, so data that was already buffered isn't lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
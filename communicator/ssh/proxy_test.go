@@ -0,0 +1,226 @@
+package ssh
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// testEchoServer starts a TCP server that writes a banner and then echoes
+// back whatever it receives, like an SSH server sending its version first.
+func testEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+				c.Write([]byte("banner\n"))
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	return l
+}
+
+// testSocks5Server starts a minimal SOCKS5 server that requires the
+// username "foo" and password "bar".
+func testSocks5Server(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+
+				// Greeting, choosing username/password auth
+				header := make([]byte, 2)
+				io.ReadFull(c, header)
+				io.ReadFull(c, make([]byte, header[1]))
+				c.Write([]byte{5, 2})
+
+				// Authentication
+				io.ReadFull(c, header)
+				username := make([]byte, header[1])
+				io.ReadFull(c, username)
+				io.ReadFull(c, header[:1])
+				password := make([]byte, header[0])
+				io.ReadFull(c, password)
+				if string(username) != "foo" || string(password) != "bar" {
+					c.Write([]byte{1, 1})
+					return
+				}
+				c.Write([]byte{1, 0})
+
+				// Connect request with an IPv4 address
+				request := make([]byte, 10)
+				io.ReadFull(c, request)
+				addr := &net.TCPAddr{
+					IP:   net.IP(request[4:8]),
+					Port: int(request[8])<<8 | int(request[9]),
+				}
+
+				target, err := net.DialTCP("tcp", nil, addr)
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+
+				c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+				go io.Copy(target, c)
+				io.Copy(c, target)
+			}()
+		}
+	}()
+
+	return l
+}
+
+// testHTTPProxy starts a minimal HTTP proxy that only supports CONNECT.
+func testHTTPProxy(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+
+				r := bufio.NewReader(c)
+				req, err := http.ReadRequest(r)
+				if err != nil || req.Method != "CONNECT" {
+					return
+				}
+
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					c.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+
+				c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				go io.Copy(target, r)
+				io.Copy(c, target)
+			}()
+		}
+	}()
+
+	return l
+}
+
+func testProxyRoundTrip(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	banner, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if banner != "banner\n" {
+		t.Fatalf("bad banner: %q", banner)
+	}
+
+	conn.Write([]byte("hello\n"))
+	echo, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if echo != "hello\n" {
+		t.Fatalf("bad echo: %q", echo)
+	}
+}
+
+func TestProxyConnectFunc_SOCKS5(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+
+	proxy := testSocks5Server(t)
+	defer proxy.Close()
+
+	f := ProxyConnectFunc("socks5", proxy.Addr().String(), "foo", "bar", echo.Addr().String())
+	conn, err := f()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testProxyRoundTrip(t, conn)
+}
+
+func TestProxyConnectFunc_SOCKS5BadAuth(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+
+	proxy := testSocks5Server(t)
+	defer proxy.Close()
+
+	f := ProxyConnectFunc("socks5", proxy.Addr().String(), "foo", "baz", echo.Addr().String())
+	if _, err := f(); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProxyConnectFunc_HTTP(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+
+	proxy := testHTTPProxy(t)
+	defer proxy.Close()
+
+	f := ProxyConnectFunc("http", proxy.Addr().String(), "", "", echo.Addr().String())
+	conn, err := f()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testProxyRoundTrip(t, conn)
+}
+
+func TestProxyConnectFunc_HTTPFailure(t *testing.T) {
+	proxy := testHTTPProxy(t)
+	defer proxy.Close()
+
+	f := ProxyConnectFunc("http", proxy.Addr().String(), "", "", "127.0.0.1:1")
+	if _, err := f(); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProxyConnectFunc_BadType(t *testing.T) {
+	proxy := testHTTPProxy(t)
+	defer proxy.Close()
+
+	f := ProxyConnectFunc("ftp", proxy.Addr().String(), "", "", "127.0.0.1:1")
+	if _, err := f(); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -0,0 +1,496 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// This file contains a minimal client for version 3 of the SFTP protocol.
+// It implements just enough to upload and download files and to create
+// and list directories, for machines that don't have an scp binary
+// available.
+
+const sftpProtocolVersion = 3
+
+// The maximum amount of data we read or write in a single request. Servers
+// are only required to support packets up to 34000 bytes.
+const sftpMaxData = 32768
+
+// The largest packet we accept from the server, which is the most that
+// OpenSSH sends, plus room for the header. Anything larger is refused
+// rather than allocated, since the length comes from the server.
+const sftpMaxPacket = 256*1024 + 1024
+
+const (
+	sftpPacketInit    = 1
+	sftpPacketVersion = 2
+	sftpPacketOpen    = 3
+	sftpPacketClose   = 4
+	sftpPacketRead    = 5
+	sftpPacketWrite   = 6
+	sftpPacketOpendir = 11
+	sftpPacketReaddir = 12
+	sftpPacketMkdir   = 14
+	sftpPacketStatus  = 101
+	sftpPacketHandle  = 102
+	sftpPacketData    = 103
+	sftpPacketName    = 104
+)
+
+const (
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpAttrSize        = 0x01
+	sftpAttrUidGid      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrAcModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+)
+
+// The POSIX file type bits that are part of the permissions attribute.
+const (
+	sftpModeType     = 0170000
+	sftpModeFifo     = 0010000
+	sftpModeCharDev  = 0020000
+	sftpModeDir      = 0040000
+	sftpModeBlockDev = 0060000
+	sftpModeRegular  = 0100000
+	sftpModeSymlink  = 0120000
+	sftpModeSocket   = 0140000
+)
+
+const (
+	sftpStatusOK  = 0
+	sftpStatusEOF = 1
+)
+
+var sftpErrShortPacket = errors.New("sftp: packet too short")
+var sftpErrLongPacket = errors.New("sftp: packet too long")
+
+// sftpFileInfo is an entry of a remote directory.
+type sftpFileInfo struct {
+	Name string
+	Size uint64
+	Mode os.FileMode
+}
+
+// sftpClient talks the SFTP protocol over a reader and writer, which are
+// usually the stdout and stdin of an SSH session running the "sftp"
+// subsystem. It is not safe for concurrent use.
+type sftpClient struct {
+	r      io.Reader
+	w      io.Writer
+	nextId uint32
+}
+
+// newSftpClient initializes the SFTP protocol with the remote side and
+// returns a client that is ready for use.
+func newSftpClient(r io.Reader, w io.Writer) (*sftpClient, error) {
+	c := &sftpClient{r: r, w: w}
+
+	if err := c.sendPacket(sftpPacketInit, sftpUint32(nil, sftpProtocolVersion)); err != nil {
+		return nil, err
+	}
+
+	typ, data, err := c.recvPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	if typ != sftpPacketVersion {
+		return nil, fmt.Errorf("sftp: expected version packet, got %d", typ)
+	}
+
+	version, _, err := sftpParseUint32(data)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("sftp: server protocol version %d", version)
+	return c, nil
+}
+
+// Open opens the remote file at the given path with the given flags,
+// returning a handle to it.
+func (c *sftpClient) Open(path string, flags uint32, perm uint32) (string, error) {
+	payload := sftpString(nil, path)
+	payload = sftpUint32(payload, flags)
+	payload = sftpUint32(payload, sftpAttrPermissions)
+	payload = sftpUint32(payload, perm)
+
+	typ, data, err := c.request(sftpPacketOpen, payload)
+	if err != nil {
+		return "", err
+	}
+
+	switch typ {
+	case sftpPacketHandle:
+		handle, _, err := sftpParseString(data)
+		return handle, err
+	case sftpPacketStatus:
+		return "", sftpStatusError(data)
+	default:
+		return "", fmt.Errorf("sftp: unexpected packet type %d for open", typ)
+	}
+}
+
+// Close closes a handle that was opened with Open.
+func (c *sftpClient) Close(handle string) error {
+	return c.statusRequest(sftpPacketClose, sftpString(nil, handle))
+}
+
+// Write writes the data to the handle at the given offset.
+func (c *sftpClient) Write(handle string, offset uint64, data []byte) error {
+	payload := sftpString(nil, handle)
+	payload = sftpUint64(payload, offset)
+	payload = sftpString(payload, string(data))
+	return c.statusRequest(sftpPacketWrite, payload)
+}
+
+// Mkdir creates a directory at the remote path with the given
+// permissions.
+func (c *sftpClient) Mkdir(path string, perm uint32) error {
+	payload := sftpString(nil, path)
+	payload = sftpUint32(payload, sftpAttrPermissions)
+	payload = sftpUint32(payload, perm)
+	return c.statusRequest(sftpPacketMkdir, payload)
+}
+
+// ReadDir returns the entries of the remote directory at the given path,
+// which include "." and "..".
+func (c *sftpClient) ReadDir(path string) ([]sftpFileInfo, error) {
+	typ, data, err := c.request(sftpPacketOpendir, sftpString(nil, path))
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sftpPacketHandle:
+	case sftpPacketStatus:
+		return nil, sftpStatusError(data)
+	default:
+		return nil, fmt.Errorf("sftp: unexpected packet type %d for opendir", typ)
+	}
+
+	handle, _, err := sftpParseString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]sftpFileInfo, 0)
+	for {
+		typ, data, err := c.request(sftpPacketReaddir, sftpString(nil, handle))
+		if err != nil {
+			return nil, err
+		}
+
+		if typ == sftpPacketStatus {
+			code, _, err := sftpParseUint32(data)
+			if err != nil {
+				return nil, err
+			}
+
+			if code == sftpStatusEOF {
+				break
+			}
+
+			c.Close(handle)
+			return nil, sftpStatusError(data)
+		}
+
+		if typ != sftpPacketName {
+			c.Close(handle)
+			return nil, fmt.Errorf("sftp: unexpected packet type %d for readdir", typ)
+		}
+
+		entries, err := sftpParseNames(data)
+		if err != nil {
+			c.Close(handle)
+			return nil, err
+		}
+
+		result = append(result, entries...)
+	}
+
+	return result, c.Close(handle)
+}
+
+// Read reads up to length bytes from the handle at the given offset. When
+// the end of the file is reached, io.EOF is returned.
+func (c *sftpClient) Read(handle string, offset uint64, length uint32) ([]byte, error) {
+	payload := sftpString(nil, handle)
+	payload = sftpUint64(payload, offset)
+	payload = sftpUint32(payload, length)
+
+	typ, data, err := c.request(sftpPacketRead, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case sftpPacketData:
+		result, _, err := sftpParseString(data)
+		return []byte(result), err
+	case sftpPacketStatus:
+		code, _, err := sftpParseUint32(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if code == sftpStatusEOF {
+			return nil, io.EOF
+		}
+
+		return nil, sftpStatusError(data)
+	default:
+		return nil, fmt.Errorf("sftp: unexpected packet type %d for read", typ)
+	}
+}
+
+// statusRequest sends a request that expects a status response and
+// turns any non-OK status into an error.
+func (c *sftpClient) statusRequest(typ byte, payload []byte) error {
+	respTyp, data, err := c.request(typ, payload)
+	if err != nil {
+		return err
+	}
+
+	if respTyp != sftpPacketStatus {
+		return fmt.Errorf("sftp: expected status packet, got %d", respTyp)
+	}
+
+	code, _, err := sftpParseUint32(data)
+	if err != nil {
+		return err
+	}
+
+	if code != sftpStatusOK {
+		return sftpStatusError(data)
+	}
+
+	return nil
+}
+
+// request sends a single request with a new request ID and waits for
+// the response to that request.
+func (c *sftpClient) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.nextId++
+	id := c.nextId
+
+	if err := c.sendPacket(typ, append(sftpUint32(nil, id), payload...)); err != nil {
+		return 0, nil, err
+	}
+
+	respTyp, data, err := c.recvPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	respId, data, err := sftpParseUint32(data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if respId != id {
+		return 0, nil, fmt.Errorf("sftp: expected response to request %d, got %d", id, respId)
+	}
+
+	return respTyp, data, nil
+}
+
+func (c *sftpClient) sendPacket(typ byte, data []byte) error {
+	packet := sftpUint32(nil, uint32(len(data)+1))
+	packet = append(packet, typ)
+	packet = append(packet, data...)
+
+	_, err := c.w.Write(packet)
+	return err
+}
+
+func (c *sftpClient) recvPacket() (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(c.r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+
+	if length == 0 {
+		return 0, nil, sftpErrShortPacket
+	}
+
+	if length > sftpMaxPacket {
+		return 0, nil, sftpErrLongPacket
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.r, packet); err != nil {
+		return 0, nil, err
+	}
+
+	return packet[0], packet[1:], nil
+}
+
+// sftpStatusError turns the data of a status packet (after the request
+// ID) into an error.
+func sftpStatusError(data []byte) error {
+	code, data, err := sftpParseUint32(data)
+	if err != nil {
+		return err
+	}
+
+	message, _, err := sftpParseString(data)
+	if err != nil || message == "" {
+		return fmt.Errorf("sftp: request failed with status %d", code)
+	}
+
+	return fmt.Errorf("sftp: %s (status %d)", message, code)
+}
+
+// sftpParseNames parses the entries of a name packet (after the request
+// ID), which each have a file name, a long name that we ignore, and
+// attributes.
+func sftpParseNames(data []byte) ([]sftpFileInfo, error) {
+	count, data, err := sftpParseUint32(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]sftpFileInfo, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var info sftpFileInfo
+		info.Name, data, err = sftpParseString(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, data, err = sftpParseString(data); err != nil {
+			return nil, err
+		}
+
+		info.Size, info.Mode, data, err = sftpParseAttrs(data)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+// sftpParseAttrs parses file attributes, returning the size and mode of
+// the file if they are present.
+func sftpParseAttrs(data []byte) (uint64, os.FileMode, []byte, error) {
+	flags, data, err := sftpParseUint32(data)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var size uint64
+	if flags&sftpAttrSize != 0 {
+		var high, low uint32
+		if high, data, err = sftpParseUint32(data); err != nil {
+			return 0, 0, nil, err
+		}
+
+		if low, data, err = sftpParseUint32(data); err != nil {
+			return 0, 0, nil, err
+		}
+
+		size = uint64(high)<<32 | uint64(low)
+	}
+
+	if flags&sftpAttrUidGid != 0 {
+		if len(data) < 8 {
+			return 0, 0, nil, sftpErrShortPacket
+		}
+
+		data = data[8:]
+	}
+
+	var mode os.FileMode
+	if flags&sftpAttrPermissions != 0 {
+		var perm uint32
+		if perm, data, err = sftpParseUint32(data); err != nil {
+			return 0, 0, nil, err
+		}
+
+		mode = os.FileMode(perm).Perm()
+		switch perm & sftpModeType {
+		case sftpModeFifo:
+			mode |= os.ModeNamedPipe
+		case sftpModeCharDev:
+			mode |= os.ModeDevice | os.ModeCharDevice
+		case sftpModeDir:
+			mode |= os.ModeDir
+		case sftpModeBlockDev:
+			mode |= os.ModeDevice
+		case sftpModeSymlink:
+			mode |= os.ModeSymlink
+		case sftpModeSocket:
+			mode |= os.ModeSocket
+		}
+	}
+
+	if flags&sftpAttrAcModTime != 0 {
+		if len(data) < 8 {
+			return 0, 0, nil, sftpErrShortPacket
+		}
+
+		data = data[8:]
+	}
+
+	if flags&sftpAttrExtended != 0 {
+		var count uint32
+		if count, data, err = sftpParseUint32(data); err != nil {
+			return 0, 0, nil, err
+		}
+
+		for i := uint32(0); i < count*2; i++ {
+			if _, data, err = sftpParseString(data); err != nil {
+				return 0, 0, nil, err
+			}
+		}
+	}
+
+	return size, mode, data, nil
+}
+
+func sftpUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func sftpUint64(b []byte, v uint64) []byte {
+	return sftpUint32(sftpUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func sftpString(b []byte, v string) []byte {
+	return append(sftpUint32(b, uint32(len(v))), v...)
+}
+
+func sftpParseUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, sftpErrShortPacket
+	}
+
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func sftpParseString(b []byte) (string, []byte, error) {
+	length, b, err := sftpParseUint32(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if uint32(len(b)) < length {
+		return "", nil, sftpErrShortPacket
+	}
+
+	return string(b[:length]), b[length:], nil
+}
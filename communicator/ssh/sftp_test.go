@@ -0,0 +1,223 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testSftpServer is a very small in-memory SFTP server used to test
+// the client side of the protocol.
+type testSftpServer struct {
+	files   map[string]*bytes.Buffer
+	handles map[string]string
+
+	// Directory handles, which are done once they have been read
+	dirsRead map[string]bool
+}
+
+func newTestSftpClient(t *testing.T) (*sftpClient, *testSftpServer) {
+	server := &testSftpServer{
+		files:    make(map[string]*bytes.Buffer),
+		handles:  make(map[string]string),
+		dirsRead: make(map[string]bool),
+	}
+
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	go server.serve(serverR, serverW)
+
+	client, err := newSftpClient(clientR, clientW)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return client, server
+}
+
+func (s *testSftpServer) serve(r io.Reader, w io.Writer) {
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return
+		}
+
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return
+		}
+
+		typ, data := packet[0], packet[1:]
+		if typ == sftpPacketInit {
+			s.send(w, sftpPacketVersion, sftpUint32(nil, sftpProtocolVersion))
+			continue
+		}
+
+		id, data, _ := sftpParseUint32(data)
+		resp := sftpUint32(nil, id)
+
+		switch typ {
+		case sftpPacketOpen:
+			path, data, _ := sftpParseString(data)
+			flags, _, _ := sftpParseUint32(data)
+			if flags&sftpFlagCreat != 0 {
+				s.files[path] = new(bytes.Buffer)
+			}
+
+			if _, ok := s.files[path]; !ok {
+				resp = sftpUint32(resp, 2)
+				resp = sftpString(resp, "No such file")
+				s.send(w, sftpPacketStatus, resp)
+				continue
+			}
+
+			s.handles[path] = path
+			s.send(w, sftpPacketHandle, sftpString(resp, path))
+		case sftpPacketWrite:
+			handle, data, _ := sftpParseString(data)
+			data = data[8:]
+			contents, _, _ := sftpParseString(data)
+			s.files[handle].WriteString(contents)
+			s.send(w, sftpPacketStatus, sftpUint32(resp, sftpStatusOK))
+		case sftpPacketRead:
+			handle, data, _ := sftpParseString(data)
+			offset := binary.BigEndian.Uint64(data)
+			contents := s.files[handle].Bytes()
+			if offset >= uint64(len(contents)) {
+				s.send(w, sftpPacketStatus, sftpUint32(resp, sftpStatusEOF))
+				continue
+			}
+
+			s.send(w, sftpPacketData, sftpString(resp, string(contents[offset:])))
+		case sftpPacketOpendir:
+			path, _, _ := sftpParseString(data)
+			s.dirsRead[path] = false
+			s.send(w, sftpPacketHandle, sftpString(resp, path))
+		case sftpPacketReaddir:
+			handle, _, _ := sftpParseString(data)
+			if s.dirsRead[handle] {
+				s.send(w, sftpPacketStatus, sftpUint32(resp, sftpStatusEOF))
+				continue
+			}
+
+			s.dirsRead[handle] = true
+
+			// List the files directly in the directory, along with a
+			// subdirectory so that types are parsed correctly.
+			names := make([]string, 0)
+			for path := range s.files {
+				if strings.HasPrefix(path, handle+"/") {
+					names = append(names, path[len(handle)+1:])
+				}
+			}
+
+			resp = sftpUint32(resp, uint32(len(names)+1))
+			for _, name := range names {
+				resp = sftpString(resp, name)
+				resp = sftpString(resp, "-rw-r--r-- "+name)
+				resp = sftpUint32(resp, sftpAttrSize|sftpAttrPermissions)
+				resp = sftpUint64(resp, uint64(s.files[handle+"/"+name].Len()))
+				resp = sftpUint32(resp, sftpModeRegular|0644)
+			}
+
+			resp = sftpString(resp, "sub")
+			resp = sftpString(resp, "drwxr-xr-x sub")
+			resp = sftpUint32(resp, sftpAttrPermissions|sftpAttrAcModTime)
+			resp = sftpUint32(resp, sftpModeDir|0755)
+			resp = sftpUint64(resp, 0)
+			s.send(w, sftpPacketName, resp)
+		case sftpPacketClose:
+			handle, _, _ := sftpParseString(data)
+			delete(s.handles, handle)
+			s.send(w, sftpPacketStatus, sftpUint32(resp, sftpStatusOK))
+		}
+	}
+}
+
+func (s *testSftpServer) send(w io.Writer, typ byte, data []byte) {
+	packet := sftpUint32(nil, uint32(len(data)+1))
+	packet = append(packet, typ)
+	w.Write(append(packet, data...))
+}
+
+func TestSftpClient_WriteRead(t *testing.T) {
+	client, server := newTestSftpClient(t)
+
+	handle, err := client.Open("/foo", sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc, 0644)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := client.Write(handle, 0, []byte("foobar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := client.Close(handle); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if server.files["/foo"].String() != "foobar" {
+		t.Fatalf("bad contents: %s", server.files["/foo"].String())
+	}
+
+	handle, err = client.Open("/foo", sftpFlagRead, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := client.Read(handle, 0, sftpMaxData)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "foobar" {
+		t.Fatalf("bad data: %s", data)
+	}
+
+	if _, err := client.Read(handle, 6, sftpMaxData); err != io.EOF {
+		t.Fatalf("should be EOF: %s", err)
+	}
+}
+
+func TestSftpClient_OpenMissing(t *testing.T) {
+	client, _ := newTestSftpClient(t)
+
+	if _, err := client.Open("/i-dont-exist", sftpFlagRead, 0); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestSftpClient_LongPacket(t *testing.T) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(1<<31))
+
+	client := &sftpClient{r: &buf}
+	if _, _, err := client.recvPacket(); err != sftpErrLongPacket {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestSftpClient_ReadDir(t *testing.T) {
+	client, server := newTestSftpClient(t)
+	server.files["/dir/foo"] = bytes.NewBufferString("foobar")
+
+	entries, err := client.ReadDir("/dir")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	if entries[0].Name != "foo" || entries[0].Size != 6 || entries[0].Mode != 0644 {
+		t.Fatalf("bad: %#v", entries[0])
+	}
+
+	if entries[1].Name != "sub" || entries[1].Mode != os.ModeDir|0755 {
+		t.Fatalf("bad: %#v", entries[1])
+	}
+}
@@ -0,0 +1,51 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellQuote quotes a string so that the remote shell treats it as a
+// single word with no special characters, by wrapping it in single quotes.
+// Single quotes within the string are closed, escaped, and reopened.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellQuotePath quotes a remote path for the shell like shellQuote,
+// except that a leading "~" is left unquoted so that the remote shell
+// still expands it to the home directory.
+func shellQuotePath(path string) string {
+	if path == "~" {
+		return path
+	}
+
+	if strings.HasPrefix(path, "~/") {
+		return "~/" + shellQuote(path[2:])
+	}
+
+	return shellQuote(path)
+}
+
+// envCommand wraps the command so that it is run with the environment
+// variables set, using env(1) since most SSH servers only accept a few
+// variables in env requests. Each variable must be in the form KEY=value.
+func envCommand(env []string, command string) (string, error) {
+	if len(env) == 0 {
+		return command, nil
+	}
+
+	words := make([]string, 0, len(env)+4)
+	words = append(words, "env")
+	for _, kv := range env {
+		// Without a "=" env would run the variable as the command
+		if strings.Index(kv, "=") < 1 {
+			return "", fmt.Errorf("Invalid environment variable: %s", kv)
+		}
+
+		words = append(words, shellQuote(kv))
+	}
+
+	words = append(words, "/bin/sh", "-c", shellQuote(command))
+	return strings.Join(words, " "), nil
+}
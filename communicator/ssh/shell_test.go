@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"":             "''",
+		"foo":          "'foo'",
+		"foo bar":      "'foo bar'",
+		"$(rm -rf /)":  "'$(rm -rf /)'",
+		"it's":         `'it'\''s'`,
+		"a;b|c&d`e`\n": "'a;b|c&d`e`\n'",
+	}
+
+	for input, expected := range cases {
+		if actual := shellQuote(input); actual != expected {
+			t.Fatalf("bad quote of %q: %s", input, actual)
+		}
+	}
+}
+
+func TestShellQuotePath(t *testing.T) {
+	cases := map[string]string{
+		"/tmp/foo bar": "'/tmp/foo bar'",
+		"~":            "~",
+		"~/foo bar":    "~/'foo bar'",
+		"~root/foo":    "'~root/foo'",
+		"foo/~/bar":    "'foo/~/bar'",
+	}
+
+	for input, expected := range cases {
+		if actual := shellQuotePath(input); actual != expected {
+			t.Fatalf("bad quote of %q: %s", input, actual)
+		}
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	result, err := envCommand(nil, "echo $FOO")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "echo $FOO" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	result, err = envCommand([]string{"FOO=bar baz", "EMPTY="}, "echo $FOO")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `env 'FOO=bar baz' 'EMPTY=' /bin/sh -c 'echo $FOO'`
+	if result != expected {
+		t.Fatalf("bad: %s", result)
+	}
+
+	for _, kv := range []string{"FOO", "=bar"} {
+		if _, err := envCommand([]string{kv}, "true"); err == nil {
+			t.Fatalf("should have error: %s", kv)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"io"
+	"time"
+)
+
+// The most data that is read or written at once by a rate limited
+// stream, so that the transfer is smooth rather than bursty.
+const rateLimitChunkSize = 32 * 1024
+
+// rateLimiter keeps track of how many bytes were transferred since the
+// transfer started in order to slow it down to the given rate.
+type rateLimiter struct {
+	rate  int
+	start time.Time
+	count int64
+}
+
+// chunkSize returns how much data to transfer before waiting again.
+func (l *rateLimiter) chunkSize(n int) int {
+	if n > rateLimitChunkSize {
+		n = rateLimitChunkSize
+	}
+
+	if n > l.rate {
+		n = l.rate
+	}
+
+	return n
+}
+
+// wait records that n bytes were transferred and sleeps until that is
+// within the rate limit.
+func (l *rateLimiter) wait(n int) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+
+	l.count += int64(n)
+	expected := time.Duration(float64(l.count) / float64(l.rate) * float64(time.Second))
+	if d := expected - time.Since(l.start); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type rateLimitReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+// newRateLimitReader returns a reader that reads from r at no more than
+// rate bytes per second. If rate isn't positive, r is returned as-is.
+func newRateLimitReader(r io.Reader, rate int) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+
+	return &rateLimitReader{r, &rateLimiter{rate: rate}}
+}
+
+func (r *rateLimitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:r.limiter.chunkSize(len(p))])
+	r.limiter.wait(n)
+	return n, err
+}
+
+type rateLimitWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+}
+
+// newRateLimitWriter returns a writer that writes to w at no more than
+// rate bytes per second. If rate isn't positive, w is returned as-is.
+func newRateLimitWriter(w io.Writer, rate int) io.Writer {
+	if rate <= 0 {
+		return w
+	}
+
+	return &rateLimitWriter{w, &rateLimiter{rate: rate}}
+}
+
+func (w *rateLimitWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		var written int
+		written, err = w.w.Write(p[:w.limiter.chunkSize(len(p))])
+		n += written
+		w.limiter.wait(written)
+		if err != nil {
+			return
+		}
+
+		p = p[written:]
+	}
+
+	return
+}
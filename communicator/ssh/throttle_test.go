@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimitReader(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 3000)
+	r := newRateLimitReader(bytes.NewReader(data), 10000)
+
+	start := time.Now()
+	result, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(result, data) {
+		t.Fatal("bad data")
+	}
+
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Fatalf("too fast: %s", d)
+	}
+}
+
+func TestRateLimitReader_Unlimited(t *testing.T) {
+	r := bytes.NewReader(nil)
+	if newRateLimitReader(r, 0) != io.Reader(r) {
+		t.Fatal("should not wrap the reader")
+	}
+}
+
+func TestRateLimitWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 3000)
+	buf := new(bytes.Buffer)
+	w := newRateLimitWriter(buf, 10000)
+
+	start := time.Now()
+	n, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if n != len(data) || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("bad: %d", n)
+	}
+
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Fatalf("too fast: %s", d)
+	}
+}
+
+func TestRateLimitWriter_Unlimited(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if newRateLimitWriter(buf, 0) != io.Writer(buf) {
+		t.Fatal("should not wrap the writer")
+	}
+}
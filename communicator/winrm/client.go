@@ -0,0 +1,359 @@
+package winrm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// This file contains a minimal client for the parts of the WS-Management
+// protocol (MS-WSMV) that are needed to run commands in a remote shell.
+
+const (
+	soapContentType  = "application/soap+xml;charset=UTF-8"
+	resourceURICmd   = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	actionCreate     = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	actionDelete     = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	actionCommand    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	actionReceive    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	actionSend       = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	actionSignal     = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+	signalTerminate  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	commandStateDone = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"
+
+	// The WSManFault code of a Receive that timed out without any output.
+	// The command is still running, so we just ask again.
+	faultOperationTimeout = "2150858793"
+
+	// The longest a single request waits for the command to produce output.
+	operationTimeout = "PT60S"
+	maxEnvelopeSize  = 153600
+)
+
+// client sends WS-Management requests to a single endpoint.
+type client struct {
+	endpoint string
+	http     *http.Client
+	username string
+	password string
+	ntlm     bool
+}
+
+// shellCommandResult is the state of a command returned by a Receive.
+type shellCommandResult struct {
+	done     bool
+	exitCode int
+}
+
+// soapFault is the error returned by the server when a request fails.
+type soapFault struct {
+	Code   string
+	Reason string
+}
+
+func (f *soapFault) Error() string {
+	return fmt.Sprintf("WinRM fault %s: %s", f.Code, f.Reason)
+}
+
+// createShell creates a new cmd shell on the remote machine and returns
+// its ID. The environment variables, in the form KEY=value, are set for
+// every command run in the shell.
+func (c *client) createShell(env []string) (string, error) {
+	var variables bytes.Buffer
+	if len(env) > 0 {
+		variables.WriteString("<rsp:Environment>\n")
+		for _, kv := range env {
+			parts := strings.SplitN(kv, "=", 2)
+			fmt.Fprintf(&variables, "<rsp:Variable Name=\"%s\">%s</rsp:Variable>\n",
+				xmlEscape(parts[0]), xmlEscape(parts[1]))
+		}
+		variables.WriteString("</rsp:Environment>\n")
+	}
+
+	body := `<rsp:Shell>
+<rsp:InputStreams>stdin</rsp:InputStreams>
+<rsp:OutputStreams>stdout stderr</rsp:OutputStreams>
+` + variables.String() + `</rsp:Shell>`
+
+	options := `<wsman:OptionSet>
+<wsman:Option Name="WINRS_NOPROFILE">FALSE</wsman:Option>
+<wsman:Option Name="WINRS_CODEPAGE">65001</wsman:Option>
+</wsman:OptionSet>`
+
+	var resp struct {
+		ShellId         string `xml:"Body>Shell>ShellId"`
+		ResourceCreated []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Body>ResourceCreated>ReferenceParameters>SelectorSet>Selector"`
+	}
+
+	if err := c.call(actionCreate, "", options, body, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.ShellId != "" {
+		return resp.ShellId, nil
+	}
+
+	for _, selector := range resp.ResourceCreated {
+		if selector.Name == "ShellId" {
+			return selector.Value, nil
+		}
+	}
+
+	return "", errors.New("WinRM didn't return the ID of the created shell")
+}
+
+// deleteShell deletes the shell and anything still running in it.
+func (c *client) deleteShell(shellId string) error {
+	return c.call(actionDelete, shellId, "", "", nil)
+}
+
+// runCommand starts a command in the shell and returns its ID.
+func (c *client) runCommand(shellId string, command string) (string, error) {
+	options := `<wsman:OptionSet>
+<wsman:Option Name="WINRS_CONSOLEMODE_STDIN">TRUE</wsman:Option>
+<wsman:Option Name="WINRS_SKIP_CMD_SHELL">FALSE</wsman:Option>
+</wsman:OptionSet>`
+
+	body := fmt.Sprintf(
+		"<rsp:CommandLine><rsp:Command>%s</rsp:Command></rsp:CommandLine>",
+		xmlEscape(command))
+
+	var resp struct {
+		CommandId string `xml:"Body>CommandResponse>CommandId"`
+	}
+
+	if err := c.call(actionCommand, shellId, options, body, &resp); err != nil {
+		return "", err
+	}
+
+	if resp.CommandId == "" {
+		return "", errors.New("WinRM didn't return the ID of the command")
+	}
+
+	return resp.CommandId, nil
+}
+
+// receive waits for output from the command and writes it to stdout and
+// stderr, returning the state of the command.
+func (c *client) receive(shellId string, commandId string, stdout io.Writer, stderr io.Writer) (*shellCommandResult, error) {
+	body := fmt.Sprintf(
+		`<rsp:Receive><rsp:DesiredStream CommandId="%s">stdout stderr</rsp:DesiredStream></rsp:Receive>`,
+		xmlEscape(commandId))
+
+	var resp struct {
+		Streams []struct {
+			Name  string `xml:"Name,attr"`
+			End   bool   `xml:"End,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Body>ReceiveResponse>Stream"`
+		State struct {
+			State    string `xml:"State,attr"`
+			ExitCode *int   `xml:"ExitCode"`
+		} `xml:"Body>ReceiveResponse>CommandState"`
+	}
+
+	err := c.call(actionReceive, shellId, "", body, &resp)
+	if fault, ok := err.(*soapFault); ok && fault.Code == faultOperationTimeout {
+		return &shellCommandResult{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, stream := range resp.Streams {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding output of command: %s", err)
+		}
+
+		var w io.Writer
+		switch stream.Name {
+		case "stdout":
+			w = stdout
+		case "stderr":
+			w = stderr
+		}
+
+		if w != nil && len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := new(shellCommandResult)
+	if resp.State.State == commandStateDone {
+		result.done = true
+		if resp.State.ExitCode != nil {
+			result.exitCode = *resp.State.ExitCode
+		}
+	}
+
+	return result, nil
+}
+
+// send sends data to the standard input of the command. If end is true,
+// the input is closed afterwards.
+func (c *client) send(shellId string, commandId string, data []byte, end bool) error {
+	endAttr := ""
+	if end {
+		endAttr = ` End="true"`
+	}
+
+	body := fmt.Sprintf(
+		`<rsp:Send><rsp:Stream Name="stdin" CommandId="%s"%s>%s</rsp:Stream></rsp:Send>`,
+		xmlEscape(commandId), endAttr, base64.StdEncoding.EncodeToString(data))
+
+	return c.call(actionSend, shellId, "", body, nil)
+}
+
+// terminate stops the command.
+func (c *client) terminate(shellId string, commandId string) error {
+	body := fmt.Sprintf(
+		`<rsp:Signal CommandId="%s"><rsp:Code>%s</rsp:Code></rsp:Signal>`,
+		xmlEscape(commandId), signalTerminate)
+
+	return c.call(actionSignal, shellId, "", body, nil)
+}
+
+// call sends a request with the given action to the endpoint and decodes
+// the response envelope into result, if it isn't nil.
+func (c *client) call(action string, shellId string, options string, body string, result interface{}) error {
+	messageId, err := uuid()
+	if err != nil {
+		return err
+	}
+
+	selector := ""
+	if shellId != "" {
+		selector = fmt.Sprintf(
+			`<wsman:SelectorSet><wsman:Selector Name="ShellId">%s</wsman:Selector></wsman:SelectorSet>`,
+			xmlEscape(shellId))
+	}
+
+	envelope := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:wsman="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<env:Header>
+<a:To>%s</a:To>
+<a:ReplyTo><a:Address env:mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<a:Action env:mustUnderstand="true">%s</a:Action>
+<a:MessageID>uuid:%s</a:MessageID>
+<wsman:ResourceURI env:mustUnderstand="true">%s</wsman:ResourceURI>
+<wsman:MaxEnvelopeSize env:mustUnderstand="true">%d</wsman:MaxEnvelopeSize>
+<wsman:OperationTimeout>%s</wsman:OperationTimeout>
+%s%s
+</env:Header>
+<env:Body>%s</env:Body>
+</env:Envelope>`,
+		xmlEscape(c.endpoint), action, messageId, resourceURICmd,
+		maxEnvelopeSize, operationTimeout, selector, options, body)
+
+	req, err := http.NewRequest("POST", c.endpoint, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", soapContentType)
+	if !c.ntlm {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("WinRM authentication failed. Check the username and password.")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Errors are returned as faults with a 500 status
+		if fault := parseFault(data); fault != nil {
+			return fault
+		}
+
+		return fmt.Errorf("Unexpected response from WinRM: %s", resp.Status)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := xml.Unmarshal(data, result); err != nil {
+		log.Printf("Invalid WinRM response: %s", data)
+		return fmt.Errorf("Error parsing WinRM response: %s", err)
+	}
+
+	return nil
+}
+
+// parseFault returns the fault in the response envelope, or nil if there
+// is none.
+func parseFault(data []byte) *soapFault {
+	var resp struct {
+		Subcode string `xml:"Body>Fault>Code>Subcode>Value"`
+		Reason  string `xml:"Body>Fault>Reason>Text"`
+		Detail  struct {
+			Code    string `xml:"Code,attr"`
+			Message string `xml:"Message"`
+		} `xml:"Body>Fault>Detail>WSManFault"`
+	}
+
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+
+	fault := &soapFault{
+		Code:   resp.Detail.Code,
+		Reason: strings.TrimSpace(resp.Reason),
+	}
+
+	if fault.Code == "" {
+		fault.Code = resp.Subcode
+	}
+
+	if fault.Reason == "" {
+		fault.Reason = strings.TrimSpace(resp.Detail.Message)
+	}
+
+	if fault.Code == "" && fault.Reason == "" {
+		return nil
+	}
+
+	return fault
+}
+
+// uuid returns a random (version 4) UUID.
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,537 @@
+// Package winrm provides a packer.Communicator that runs commands and
+// transfers files over Windows Remote Management (WinRM), so that Windows
+// machines can be provisioned without installing an SSH server.
+package winrm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/communicator/common"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The amount of raw data that is uploaded by each command. It is base64
+// encoded onto a command line, which cmd limits to 8191 characters. This
+// is a multiple of 3 so that only the last chunk has padding.
+const uploadChunkSize = 4800
+
+// How long to wait for a connection to the WinRM endpoint.
+const dialTimeout = 30 * time.Second
+
+type comm struct {
+	config *Config
+	client *client
+}
+
+// Config is the structure used to configure the WinRM communicator.
+type Config struct {
+	// The address and port of the WinRM endpoint.
+	Host string
+	Port int
+
+	// The credentials of the user that commands are run as.
+	Username string
+	Password string
+
+	// If true, HTTPS is used to connect. If Insecure is also true, the
+	// certificate of the server isn't verified, which is often needed as
+	// Windows generates a self-signed certificate.
+	HTTPS    bool
+	Insecure bool
+
+	// If true, NTLM authentication is used rather than basic
+	// authentication. The username may include the domain, either as
+	// "DOMAIN\user" or as "user@domain".
+	NTLM bool
+}
+
+// Creates a new packer.Communicator implementation over WinRM. This makes
+// sure that the endpoint can be reached and that we are allowed to use it
+// by creating a shell.
+func New(config *Config) (result *comm, err error) {
+	scheme := "http"
+	if config.HTTPS {
+		scheme = "https"
+	}
+
+	newTransport := func() *http.Transport {
+		return &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, dialTimeout)
+			},
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: config.Insecure,
+			},
+		}
+	}
+
+	var transport http.RoundTripper = newTransport()
+	if config.NTLM {
+		transport = newNTLMTransport(newTransport, config.Username, config.Password)
+	}
+
+	result = &comm{
+		config: config,
+		client: &client{
+			endpoint: fmt.Sprintf("%s://%s:%d/wsman", scheme, config.Host, config.Port),
+			http:     &http.Client{Transport: transport},
+			username: config.Username,
+			password: config.Password,
+			ntlm:     config.NTLM,
+		},
+	}
+
+	s, err := result.newShell(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.close()
+	return
+}
+
+func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
+	for _, kv := range cmd.Env {
+		if strings.Index(kv, "=") < 1 {
+			return fmt.Errorf("Invalid environment variable: %s", kv)
+		}
+	}
+
+	// Each command gets its own shell, so the environment is set on it.
+	s, err := c.newShell(cmd.Env)
+	if err != nil {
+		return
+	}
+
+	log.Printf("starting remote command: %s", cmd.Command)
+	commandId, err := c.client.runCommand(s.id, cmd.Command)
+	if err != nil {
+		s.close()
+		return
+	}
+
+	// Start a goroutine to wait for the command to end and set the
+	// exit boolean and status.
+	go func() {
+		defer s.close()
+
+		if cmd.Stdin != nil {
+			go s.sendInput(commandId, cmd.Stdin)
+		}
+
+		// Abort the command if it is cancelled, which makes the pending
+		// receive return.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-cmd.Cancelled():
+				log.Printf("cancelling remote command: %s", cmd.Command)
+				c.client.terminate(s.id, commandId)
+			case <-done:
+			}
+		}()
+
+		exitStatus, err := s.wait(commandId, cmd.Stdout, cmd.Stderr)
+		cmd.ExitStatus = exitStatus
+		if err != nil {
+			log.Printf("[WARN] remote command did not exit cleanly: %s", err)
+			cmd.ExitStatus = packer.CmdDisconnect
+			cmd.Err = err
+		}
+
+		select {
+		case <-cmd.Cancelled():
+			cmd.ExitStatus = packer.CmdCancelled
+		default:
+		}
+
+		cmd.Exited = true
+	}()
+
+	return
+}
+
+func (c *comm) Upload(path string, input io.Reader, fi *os.FileInfo) error {
+	s, err := c.newShell(nil)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	return s.upload(path, input)
+}
+
+func (c *comm) UploadDir(dst string, src string, excl []string) error {
+	log.Printf("Upload dir '%s' to '%s'", src, dst)
+
+	// Like rsync, if there is no trailing slash then the source directory
+	// itself is created in the destination.
+	if !strings.HasSuffix(src, "/") {
+		log.Println("No trailing slash, creating the source directory name")
+		dst = winJoin(dst, filepath.Base(src))
+	}
+
+	s, err := c.newShell(nil)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	if err := s.mkdir(dst); err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		if common.UploadExcluded(relPath, excl) {
+			log.Printf("Excluding from upload: %s", relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		target := winJoin(dst, filepath.ToSlash(relPath))
+		if info.IsDir() {
+			return s.mkdir(target)
+		}
+
+		if !info.Mode().IsRegular() {
+			log.Printf("Skipping upload of non-regular file: %s", relPath)
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return s.upload(target, f)
+	})
+}
+
+func (c *comm) Download(path string, output io.Writer) error {
+	s, err := c.newShell(nil)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	return s.download(path, output)
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+
+	if !strings.HasSuffix(src, "/") && !strings.HasSuffix(src, "\\") {
+		log.Println("No trailing slash, creating the source directory name")
+		dst = filepath.Join(dst, winBase(src))
+	}
+
+	s, err := c.newShell(nil)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// List the whole directory first, with every directory before its
+	// contents. Each line is "D" or "F" for directories and files, then
+	// the path relative to src.
+	script := fmt.Sprintf(`$root = (Get-Item -LiteralPath %s -Force).FullName.TrimEnd('\')
+Get-ChildItem -LiteralPath $root -Recurse -Force | ForEach-Object {
+  $rel = $_.FullName.Substring($root.Length + 1)
+  if ($_.PSIsContainer) { "D $rel" } else { "F $rel" }
+}`, psQuote(src))
+
+	var listing bytes.Buffer
+	if err := s.powershell(script, &listing); err != nil {
+		return fmt.Errorf("Error listing %s: %s", src, err)
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(&listing)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if len(line) < 3 || (line[0] != 'D' && line[0] != 'F') || line[1] != ' ' {
+			return fmt.Errorf("Invalid directory listing: %s", line)
+		}
+
+		relPath := filepath.FromSlash(strings.Replace(line[2:], "\\", "/", -1))
+		if filepath.IsAbs(relPath) || strings.HasPrefix(filepath.Clean(relPath), "..") {
+			return fmt.Errorf("Invalid path in directory listing: %s", line[2:])
+		}
+
+		if common.DownloadExcluded(relPath, excl) {
+			log.Printf("Excluding from download: %s", relPath)
+			continue
+		}
+
+		localPath := filepath.Join(dst, relPath)
+		if line[0] == 'D' {
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.Create(localPath)
+		if err != nil {
+			return err
+		}
+
+		err = s.download(winJoin(src, filepath.ToSlash(relPath)), f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (c *comm) ForwardLocal(local string, remote string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported over WinRM")
+}
+
+func (c *comm) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return nil, errors.New("Port forwarding is not supported over WinRM")
+}
+
+// shell is a remote shell that commands are run in. Creating a shell takes
+// a while, so one is reused for all the commands of a file transfer.
+type shell struct {
+	client *client
+	id     string
+}
+
+func (c *comm) newShell(env []string) (*shell, error) {
+	id, err := c.client.createShell(env)
+	if err != nil {
+		return nil, err
+	}
+
+	return &shell{c.client, id}, nil
+}
+
+func (s *shell) close() {
+	if err := s.client.deleteShell(s.id); err != nil {
+		log.Printf("[ERROR] Error deleting WinRM shell: %s", err)
+	}
+}
+
+// wait receives the output of the command until it is done, and returns
+// its exit status.
+func (s *shell) wait(commandId string, stdout io.Writer, stderr io.Writer) (int, error) {
+	for {
+		result, err := s.client.receive(s.id, commandId, stdout, stderr)
+		if err != nil {
+			return 0, err
+		}
+
+		if result.done {
+			return result.exitCode, nil
+		}
+	}
+}
+
+// sendInput sends everything read from r to the standard input of the
+// command.
+func (s *shell) sendInput(commandId string, r io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 || err == io.EOF {
+			if sendErr := s.client.send(s.id, commandId, buf[:n], err == io.EOF); sendErr != nil {
+				log.Printf("[ERROR] Error sending input to remote command: %s", sendErr)
+				return
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("[ERROR] Error reading input for remote command: %s", err)
+			}
+
+			return
+		}
+	}
+}
+
+// run runs the command in the shell and waits for it, returning an error
+// with whatever it wrote to stderr if it doesn't succeed.
+func (s *shell) run(command string, stdout io.Writer) error {
+	commandId, err := s.client.runCommand(s.id, command)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	exitStatus, err := s.wait(commandId, stdout, &stderr)
+	if err != nil {
+		return err
+	}
+
+	if exitStatus != 0 {
+		return fmt.Errorf(
+			"Command exited with non-zero exit status %d: %s",
+			exitStatus, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// powershell runs the PowerShell script in the shell. The script is
+// encoded so that nothing in it is interpreted by cmd.
+func (s *shell) powershell(script string, stdout io.Writer) error {
+	script = "$ErrorActionPreference = 'Stop'\n" + script
+	encoded := base64.StdEncoding.EncodeToString(utf16le(script))
+	return s.run(
+		"powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "+encoded,
+		stdout)
+}
+
+// upload writes the data to the path on the remote machine. There is no
+// way to stream data to a file with WinRM, so it is appended to a
+// temporary file as base64 in chunks small enough for a command line,
+// and then decoded into place.
+func (s *shell) upload(path string, input io.Reader) error {
+	log.Printf("Uploading to %s", path)
+
+	id, err := uuid()
+	if err != nil {
+		return err
+	}
+
+	tmp := fmt.Sprintf(`%%TEMP%%\packer-upload-%s.tmp`, id)
+	if err := s.run(fmt.Sprintf(`type NUL > "%s"`, tmp), nil); err != nil {
+		return err
+	}
+
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, err := io.ReadFull(input, buf)
+		if n > 0 {
+			// The redirection goes first so that cmd can't mistake a
+			// digit at the end of the data for a handle to redirect.
+			chunk := base64.StdEncoding.EncodeToString(buf[:n])
+			if err := s.run(fmt.Sprintf(`>>"%s" echo %s`, tmp, chunk), nil); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	script := fmt.Sprintf(`$tmp = Join-Path $env:TEMP 'packer-upload-%s.tmp'
+$dst = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+$dir = [System.IO.Path]::GetDirectoryName($dst)
+if ($dir -and -not (Test-Path -LiteralPath $dir)) {
+  New-Item -ItemType Directory -Force -Path $dir | Out-Null
+}
+$data = [System.IO.File]::ReadAllText($tmp) -replace '\s', ''
+[System.IO.File]::WriteAllBytes($dst, [System.Convert]::FromBase64String($data))
+Remove-Item -LiteralPath $tmp -Force`, id, psQuote(path))
+
+	if err := s.powershell(script, nil); err != nil {
+		return fmt.Errorf("Error uploading %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// download writes the contents of the file at the path on the remote
+// machine to output. The file is sent as base64 over stdout.
+func (s *shell) download(path string, output io.Writer) error {
+	log.Printf("Downloading %s", path)
+
+	script := fmt.Sprintf(`$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+[System.Convert]::ToBase64String([System.IO.File]::ReadAllBytes($path), 'InsertLineBreaks')`,
+		psQuote(path))
+
+	var stdout bytes.Buffer
+	if err := s.powershell(script, &stdout); err != nil {
+		return fmt.Errorf("Error downloading %s: %s", path, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(stdout.String()), ""))
+	if err != nil {
+		return fmt.Errorf("Error decoding %s: %s", path, err)
+	}
+
+	_, err = output.Write(data)
+	return err
+}
+
+// mkdir creates the directory on the remote machine, and any parents.
+func (s *shell) mkdir(path string) error {
+	script := fmt.Sprintf(`New-Item -ItemType Directory -Force -Path %s | Out-Null`, psQuote(path))
+	if err := s.powershell(script, nil); err != nil {
+		return fmt.Errorf("Error creating directory %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// psQuote quotes the string for use as a literal in PowerShell.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// winJoin joins a relative path with slashes onto a remote Windows path.
+func winJoin(dir string, relPath string) string {
+	dir = strings.TrimRight(dir, "/\\")
+	return dir + "\\" + strings.Replace(relPath, "/", "\\", -1)
+}
+
+// winBase returns the last element of a remote Windows path.
+func winBase(path string) string {
+	path = strings.TrimRight(path, "/\\")
+	if idx := strings.LastIndexAny(path, "/\\"); idx >= 0 {
+		return path[idx+1:]
+	}
+
+	return path
+}
@@ -0,0 +1,785 @@
+package winrm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// testCommand is a command run on the test WinRM server.
+type testCommand struct {
+	command    string
+	env        map[string]string
+	stdin      bytes.Buffer
+	stdinDone  bool
+	terminated bool
+}
+
+// testHandler returns the output of a command on the test server. If done
+// is false the receive times out, and the handler is called again on the
+// next receive. PowerShell commands are passed the decoded script.
+type testHandler func(cmd *testCommand) (stdout string, stderr string, exitCode int, done bool)
+
+// testServer is a fake WinRM endpoint. It keeps track of the temporary
+// files that uploads write with cmd, and calls the handler for other
+// commands.
+type testServer struct {
+	*httptest.Server
+
+	l        sync.Mutex
+	handler  testHandler
+	shells   map[string]bool
+	env      map[string]map[string]string
+	commands map[string]*testCommand
+	files    map[string]string
+	ran      []string
+	nextId   int
+}
+
+var testTmpRe = regexp.MustCompile(`^type NUL > "(.+)"$`)
+var testAppendRe = regexp.MustCompile(`^>>"(.+)" echo (.+)$`)
+
+func newTestServer(t *testing.T, handler testHandler) *testServer {
+	return newTestServerAuth(t, handler, func(w http.ResponseWriter, r *http.Request) bool {
+		user, pass, ok := testBasicAuth(r)
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	})
+}
+
+// newTestServerAuth returns a test server that only handles the requests
+// that auth accepts. Otherwise auth must write the response.
+func newTestServerAuth(t *testing.T, handler testHandler, auth func(http.ResponseWriter, *http.Request) bool) *testServer {
+	s := &testServer{
+		handler:  handler,
+		shells:   make(map[string]bool),
+		env:      make(map[string]map[string]string),
+		commands: make(map[string]*testCommand),
+		files:    make(map[string]string),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth(w, r) {
+			return
+		}
+
+		if r.Header.Get("Content-Type") != soapContentType {
+			t.Errorf("bad content type: %s", r.Header.Get("Content-Type"))
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		s.serve(t, w, body)
+	}))
+
+	return s
+}
+
+func (s *testServer) config() *Config {
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return &Config{
+		Host:     host,
+		Port:     portNum,
+		Username: "user",
+		Password: "pass",
+	}
+}
+
+func (s *testServer) serve(t *testing.T, w http.ResponseWriter, body []byte) {
+	var req struct {
+		Action  string `xml:"Header>Action"`
+		ShellId string `xml:"Header>SelectorSet>Selector"`
+		Command string `xml:"Body>CommandLine>Command"`
+		Env     []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Body>Shell>Environment>Variable"`
+		Receive struct {
+			CommandId string `xml:"CommandId,attr"`
+		} `xml:"Body>Receive>DesiredStream"`
+		Send struct {
+			CommandId string `xml:"CommandId,attr"`
+			End       bool   `xml:"End,attr"`
+			Value     string `xml:",chardata"`
+		} `xml:"Body>Send>Stream"`
+		Signal struct {
+			CommandId string `xml:"CommandId,attr"`
+			Code      string `xml:"Code"`
+		} `xml:"Body>Signal"`
+	}
+
+	if err := xml.Unmarshal(body, &req); err != nil {
+		t.Errorf("bad request: %s", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if req.Action != actionCreate && !s.shells[req.ShellId] {
+		t.Errorf("unknown shell: %s", req.ShellId)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch req.Action {
+	case actionCreate:
+		s.nextId++
+		id := fmt.Sprintf("shell-%d", s.nextId)
+		s.shells[id] = true
+		s.env[id] = make(map[string]string)
+		for _, v := range req.Env {
+			s.env[id][v.Name] = v.Value
+		}
+
+		testRespond(w, "<rsp:Shell><rsp:ShellId>"+id+"</rsp:ShellId></rsp:Shell>")
+	case actionDelete:
+		delete(s.shells, req.ShellId)
+		delete(s.env, req.ShellId)
+		testRespond(w, "")
+	case actionCommand:
+		s.nextId++
+		id := fmt.Sprintf("command-%d", s.nextId)
+		s.commands[id] = &testCommand{command: req.Command, env: s.env[req.ShellId]}
+		s.ran = append(s.ran, req.Command)
+		testRespond(w, "<rsp:CommandResponse><rsp:CommandId>"+id+"</rsp:CommandId></rsp:CommandResponse>")
+	case actionSend:
+		cmd := s.commands[req.Send.CommandId]
+		data, _ := base64.StdEncoding.DecodeString(req.Send.Value)
+		cmd.stdin.Write(data)
+		cmd.stdinDone = req.Send.End
+		testRespond(w, "<rsp:SendResponse/>")
+	case actionSignal:
+		if req.Signal.Code != signalTerminate {
+			t.Errorf("bad signal: %s", req.Signal.Code)
+		}
+
+		s.commands[req.Signal.CommandId].terminated = true
+		testRespond(w, "<rsp:SignalResponse/>")
+	case actionReceive:
+		cmd := s.commands[req.Receive.CommandId]
+		stdout, stderr, exitCode, done := s.run(cmd)
+		if !done {
+			// Wait a little like a real server would, but not for long
+			// so that the tests are quick.
+			s.l.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			s.l.Lock()
+
+			w.Header().Set("Content-Type", soapContentType)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, testEnvelope, `<s:Fault><s:Code><s:Value>s:Receiver</s:Value></s:Code>
+<s:Reason><s:Text>The operation timed out.</s:Text></s:Reason>
+<s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="`+faultOperationTimeout+`"/></s:Detail></s:Fault>`)
+			return
+		}
+
+		testRespond(w, fmt.Sprintf(`<rsp:ReceiveResponse>
+<rsp:Stream Name="stdout" CommandId="%[1]s">%[2]s</rsp:Stream>
+<rsp:Stream Name="stderr" CommandId="%[1]s">%[3]s</rsp:Stream>
+<rsp:Stream Name="stdout" CommandId="%[1]s" End="true"></rsp:Stream>
+<rsp:CommandState CommandId="%[1]s" State="%[4]s"><rsp:ExitCode>%[5]d</rsp:ExitCode></rsp:CommandState>
+</rsp:ReceiveResponse>`,
+			req.Receive.CommandId,
+			base64.StdEncoding.EncodeToString([]byte(stdout)),
+			base64.StdEncoding.EncodeToString([]byte(stderr)),
+			commandStateDone,
+			exitCode))
+	default:
+		t.Errorf("unknown action: %s", req.Action)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// run handles the commands that uploads use to write temporary files, and
+// passes everything else to the handler.
+func (s *testServer) run(cmd *testCommand) (string, string, int, bool) {
+	if m := testTmpRe.FindStringSubmatch(cmd.command); m != nil {
+		s.files[m[1]] = ""
+		return "", "", 0, true
+	}
+
+	if m := testAppendRe.FindStringSubmatch(cmd.command); m != nil {
+		if _, ok := s.files[m[1]]; !ok {
+			return "", "The system cannot find the file specified.", 1, true
+		}
+
+		s.files[m[1]] += m[2] + "\r\n"
+		return "", "", 0, true
+	}
+
+	return s.handler(cmd)
+}
+
+// tmpFile returns the contents of the temporary upload file that the
+// script refers to.
+func (s *testServer) tmpFile(script string) (string, bool) {
+	m := regexp.MustCompile(`'(packer-upload-[^']+\.tmp)'`).FindStringSubmatch(script)
+	if m == nil {
+		return "", false
+	}
+
+	data, ok := s.files[`%TEMP%\`+m[1]]
+	return data, ok
+}
+
+const testEnvelope = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<s:Header/><s:Body>%s</s:Body></s:Envelope>`
+
+func testRespond(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", soapContentType)
+	fmt.Fprintf(w, testEnvelope, body)
+}
+
+func testBasicAuth(r *http.Request) (string, string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return "", "", false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(auth[6:])
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// testScript returns the PowerShell script run by the command, if it is
+// one.
+func testScript(command string) (string, bool) {
+	prefix := "powershell -NoProfile -NonInteractive -ExecutionPolicy Bypass -EncodedCommand "
+	if !strings.HasPrefix(command, prefix) {
+		return "", false
+	}
+
+	data, err := base64.StdEncoding.DecodeString(command[len(prefix):])
+	if err != nil || len(data)%2 != 0 {
+		return "", false
+	}
+
+	encoded := make([]uint16, len(data)/2)
+	for i := range encoded {
+		encoded[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+
+	return string(utf16.Decode(encoded)), true
+}
+
+// testScriptPath returns the first quoted path in the script after the
+// given text.
+func testScriptPath(script string, after string) string {
+	idx := strings.Index(script, after)
+	if idx < 0 {
+		return ""
+	}
+
+	m := regexp.MustCompile(`'((?:[^']|'')*)'`).FindStringSubmatch(script[idx:])
+	if m == nil {
+		return ""
+	}
+
+	return strings.Replace(m[1], "''", "'", -1)
+}
+
+func testWait(t *testing.T, cmd *packer.RemoteCmd) {
+	for i := 0; i < 500 && !cmd.Exited; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !cmd.Exited {
+		t.Fatal("command never exited")
+	}
+}
+
+func TestCommIsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = &comm{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("comm must be a communicator")
+	}
+}
+
+func TestNew(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+
+	if _, err := New(s.config()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(s.shells) != 0 {
+		t.Fatalf("shell wasn't deleted: %#v", s.shells)
+	}
+
+	config := s.config()
+	config.Password = "wrong"
+	if _, err := New(config); err == nil {
+		t.Fatal("should have an error")
+	}
+}
+
+func TestStart(t *testing.T) {
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		if cmd.command != "dir C:\\" {
+			t.Errorf("bad command: %s", cmd.command)
+		}
+
+		return "out", "err", 42, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "dir C:\\",
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 42 {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if stdout.String() != "out" || stderr.String() != "err" {
+		t.Fatalf("bad output: %q %q", stdout.String(), stderr.String())
+	}
+
+	if len(s.shells) != 0 {
+		t.Fatalf("shell wasn't deleted: %#v", s.shells)
+	}
+}
+
+func TestStart_env(t *testing.T) {
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		return cmd.env["FOO"] + "," + cmd.env["EMPTY"], "", 0, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "echo %FOO%",
+		Env:     []string{"FOO=<bar & baz>", "EMPTY="},
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if stdout.String() != "<bar & baz>," {
+		t.Fatalf("bad output: %q", stdout.String())
+	}
+
+	cmd = &packer.RemoteCmd{Command: "echo", Env: []string{"FOO"}}
+	if err := c.Start(cmd); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStart_stdin(t *testing.T) {
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		// Keep timing out until all the input was sent
+		if !cmd.stdinDone {
+			return "", "", 0, false
+		}
+
+		return strings.ToUpper(cmd.stdin.String()), "", 0, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "more",
+		Stdin:   strings.NewReader("hello"),
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testWait(t, cmd)
+	if cmd.ExitStatus != 0 {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if stdout.String() != "HELLO" {
+		t.Fatalf("bad output: %q", stdout.String())
+	}
+}
+
+func TestStart_cancel(t *testing.T) {
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		return "", "", 1, cmd.terminated
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd := &packer.RemoteCmd{Command: "ping -t localhost"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Cancel()
+	testWait(t, cmd)
+	if cmd.ExitStatus != packer.CmdCancelled {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+}
+
+func TestStart_disconnect(t *testing.T) {
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		return "", "", 0, false
+	})
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd := &packer.RemoteCmd{Command: "ping -t localhost"}
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s.CloseClientConnections()
+	s.Close()
+	testWait(t, cmd)
+	if cmd.ExitStatus != packer.CmdDisconnect {
+		t.Fatalf("bad exit status: %d", cmd.ExitStatus)
+	}
+
+	if cmd.Err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var uploaded map[string][]byte
+	var s *testServer
+	s = newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		script, ok := testScript(cmd.command)
+		if !ok {
+			t.Errorf("bad command: %s", cmd.command)
+			return "", "", 1, true
+		}
+
+		data, ok := s.tmpFile(script)
+		if !ok {
+			return "", "temporary file not found", 1, true
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+		if err != nil {
+			t.Errorf("bad upload: %s", err)
+		}
+
+		uploaded[testScriptPath(script, "GetUnresolvedProviderPathFromPSPath")] = decoded
+		return "", "", 0, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Enough data for multiple chunks
+	data := make([]byte, uploadChunkSize*2+100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	for _, size := range []int{len(data), uploadChunkSize, 0} {
+		uploaded = make(map[string][]byte)
+		path := `C:\Program Files\it's here.bin`
+		if err := c.Upload(path, bytes.NewReader(data[:size]), nil); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if result, ok := uploaded[path]; !ok || !bytes.Equal(result, data[:size]) {
+			t.Fatalf("bad upload of %d bytes: %#v", size, uploaded)
+		}
+	}
+
+	// Every chunk must fit on a command line
+	for _, command := range s.ran {
+		if len(command) > 8191 {
+			t.Fatalf("command is too long: %d", len(command))
+		}
+	}
+}
+
+func TestUpload_error(t *testing.T) {
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		return "", "Access is denied.", 1, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = c.Upload(`C:\Windows\foo`, strings.NewReader("foo"), nil)
+	if err == nil || !strings.Contains(err.Error(), "Access is denied.") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		script, ok := testScript(cmd.command)
+		if !ok || testScriptPath(script, "GetUnresolvedProviderPathFromPSPath") != `C:\data.bin` {
+			t.Errorf("bad command: %s", cmd.command)
+			return "", "", 1, true
+		}
+
+		// Break the lines like .NET does
+		encoded := base64.StdEncoding.EncodeToString(data)
+		var lines []string
+		for len(encoded) > 76 {
+			lines = append(lines, encoded[:76])
+			encoded = encoded[76:]
+		}
+
+		return strings.Join(append(lines, encoded), "\r\n") + "\r\n", "", 0, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	if err := c.Download(`C:\data.bin`, &output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(output.Bytes(), data) {
+		t.Fatalf("bad: %#v", output.Bytes())
+	}
+}
+
+func TestUploadDir(t *testing.T) {
+	var dirs []string
+	var files []string
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		script, ok := testScript(cmd.command)
+		if !ok {
+			t.Errorf("bad command: %s", cmd.command)
+			return "", "", 1, true
+		}
+
+		if strings.HasPrefix(script, "$ErrorActionPreference = 'Stop'\nNew-Item") {
+			dirs = append(dirs, testScriptPath(script, "-Path"))
+		} else {
+			files = append(files, testScriptPath(script, "GetUnresolvedProviderPathFromPSPath"))
+		}
+
+		return "", "", 0, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	for _, dir := range []string{"a/b", "c", ".git"} {
+		os.MkdirAll(filepath.Join(src, dir), 0755)
+	}
+
+	for _, file := range []string{"a/b/foo.txt", "bar.txt", "bar.log", ".git/HEAD"} {
+		ioutil.WriteFile(filepath.Join(src, file), []byte(file), 0644)
+	}
+
+	excl := []string{".git", "*.log"}
+	if err := c.UploadDir(`C:\dst`, src+"/", excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedDirs := []string{`C:\dst`, `C:\dst\a`, `C:\dst\a\b`, `C:\dst\c`}
+	expectedFiles := []string{`C:\dst\a\b\foo.txt`, `C:\dst\bar.txt`}
+	sort.Strings(dirs)
+	sort.Strings(files)
+	if fmt.Sprintf("%#v %#v", dirs, files) != fmt.Sprintf("%#v %#v", expectedDirs, expectedFiles) {
+		t.Fatalf("bad: %#v %#v", dirs, files)
+	}
+
+	// Without a trailing slash the directory itself is created
+	dirs, files = nil, nil
+	if err := c.UploadDir(`C:\dst`, filepath.Join(src, "a"), excl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	base := `C:\dst\` + "a"
+	expectedDirs = []string{base, base + `\b`}
+	expectedFiles = []string{base + `\b\foo.txt`}
+	if fmt.Sprintf("%#v %#v", dirs, files) != fmt.Sprintf("%#v %#v", expectedDirs, expectedFiles) {
+		t.Fatalf("bad: %#v %#v", dirs, files)
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	remote := map[string]string{
+		`C:\src\a\b\foo.txt`: "foo",
+		`C:\src\bar.txt`:     "bar",
+		`C:\src\bar.log`:     "log",
+		`C:\src\.git\HEAD`:   "head",
+	}
+
+	listing := "D a\r\nD a\\b\r\nD .git\r\nD c\r\nF a\\b\\foo.txt\r\nF bar.txt\r\nF bar.log\r\nF .git\\HEAD\r\n"
+	s := newTestServer(t, func(cmd *testCommand) (string, string, int, bool) {
+		script, ok := testScript(cmd.command)
+		if !ok {
+			t.Errorf("bad command: %s", cmd.command)
+			return "", "", 1, true
+		}
+
+		if strings.Contains(script, "Get-ChildItem") {
+			if path := testScriptPath(script, "Get-Item"); path != `C:\src` && path != `C:\src\` {
+				t.Errorf("bad listing path: %s", path)
+			}
+
+			return listing, "", 0, true
+		}
+
+		path := testScriptPath(script, "GetUnresolvedProviderPathFromPSPath")
+		data, ok := remote[path]
+		if !ok {
+			t.Errorf("bad download: %s", path)
+			return "", "not found", 1, true
+		}
+
+		return base64.StdEncoding.EncodeToString([]byte(data)), "", 0, true
+	})
+	defer s.Close()
+
+	c, err := New(s.config())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := c.DownloadDir(`C:\src`, dst, []string{".git", "*.log"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var found []string
+	filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+		relPath, _ := filepath.Rel(dst, path)
+		if !info.IsDir() {
+			data, _ := ioutil.ReadFile(path)
+			relPath += "=" + string(data)
+		}
+
+		found = append(found, filepath.ToSlash(relPath))
+		return nil
+	})
+
+	expected := []string{".", "src", "src/a", "src/a/b", "src/a/b/foo.txt=foo", "src/bar.txt=bar", "src/c"}
+	if fmt.Sprintf("%#v", found) != fmt.Sprintf("%#v", expected) {
+		t.Fatalf("bad: %#v", found)
+	}
+
+	// Paths outside of the destination are rejected
+	listing = "F ..\\evil.txt\r\n"
+	if err := c.DownloadDir(`C:\src\`, dst, nil); err == nil {
+		t.Fatal("should have an error")
+	}
+}
+
+func TestParseFault(t *testing.T) {
+	data := fmt.Sprintf(testEnvelope, `<s:Fault><s:Code><s:Value>s:Sender</s:Value>
+<s:Subcode><s:Value>w:InvalidSelectors</s:Value></s:Subcode></s:Code>
+<s:Reason><s:Text xml:lang="en-US">The shell was not found. </s:Text></s:Reason></s:Fault>`)
+
+	fault := parseFault([]byte(data))
+	if fault == nil {
+		t.Fatal("should have a fault")
+	}
+
+	if fault.Code != "w:InvalidSelectors" || fault.Reason != "The shell was not found." {
+		t.Fatalf("bad: %#v", fault)
+	}
+
+	if parseFault([]byte(fmt.Sprintf(testEnvelope, ""))) != nil {
+		t.Fatal("should not have a fault")
+	}
+}
+
+func TestPSQuote(t *testing.T) {
+	if result := psQuote(`C:\it's`); result != `'C:\it''s'` {
+		t.Fatalf("bad: %s", result)
+	}
+}
@@ -0,0 +1,301 @@
+package winrm
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/md4"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// This file contains a minimal client side implementation of NTLMv2
+// authentication over HTTP (MS-NLMP). It only authenticates, so message
+// signing and sealing are not supported.
+
+const (
+	ntlmNegotiateUnicode         = 0x00000001
+	ntlmRequestTarget            = 0x00000004
+	ntlmNegotiateNTLM            = 0x00000200
+	ntlmNegotiateAlwaysSign      = 0x00008000
+	ntlmNegotiateExtendedSession = 0x00080000
+	ntlmNegotiateTargetInfo      = 0x00800000
+	ntlmNegotiate128             = 0x20000000
+	ntlmNegotiate56              = 0x80000000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSession |
+		ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+// The ID of the timestamp in the target info of a challenge.
+const ntlmAvTimestamp = 7
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmTransport is an http.RoundTripper that authenticates every request
+// with NTLM. NTLM authenticates the connection rather than the request,
+// so every request is sent over a new transport that has only a single
+// connection, which is used for both the handshake and the request.
+type ntlmTransport struct {
+	transport func() *http.Transport
+	domain    string
+	username  string
+	password  string
+}
+
+// newNTLMTransport returns an NTLM transport for the given username, which
+// may include the domain as "DOMAIN\user" or "user@domain".
+func newNTLMTransport(transport func() *http.Transport, username string, password string) *ntlmTransport {
+	domain := ""
+	if idx := strings.Index(username, "\\"); idx >= 0 {
+		domain, username = username[:idx], username[idx+1:]
+	} else if idx := strings.LastIndex(username, "@"); idx >= 0 {
+		username, domain = username[:idx], username[idx+1:]
+	}
+
+	return &ntlmTransport{transport, domain, username, password}
+}
+
+func (t *ntlmTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	transport := t.transport()
+	defer transport.CloseIdleConnections()
+
+	// Start the handshake without the body, which will be rejected
+	negotiate, err := cloneRequest(req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	negotiate.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	resp, err := transport.RoundTrip(negotiate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the whole response so the connection can be reused
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf("Unexpected response to NTLM negotiation: %s", resp.Status)
+	}
+
+	challenge, err := ntlmChallenge(resp.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticate, err := ntlmAuthenticateMessage(
+		challenge, t.domain, t.username, t.password, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	authReq, err := cloneRequest(req, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The connection can't be used for anything else, so close it once
+	// the response has been read.
+	authReq.Close = true
+	authReq.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(authenticate))
+	return transport.RoundTrip(authReq)
+}
+
+// cloneRequest makes a copy of the request with the given body.
+func cloneRequest(req *http.Request, body []byte) (*http.Request, error) {
+	result, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range req.Header {
+		result.Header[k] = v
+	}
+
+	return result, nil
+}
+
+// ntlmChallenge finds the NTLM challenge message in the headers of a
+// response rejecting the negotiation.
+func ntlmChallenge(header http.Header) ([]byte, error) {
+	for _, value := range header["Www-Authenticate"] {
+		parts := strings.SplitN(value, " ", 2)
+		if len(parts) != 2 || (parts[0] != "Negotiate" && parts[0] != "NTLM") {
+			continue
+		}
+
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+	}
+
+	return nil, errors.New("Server did not send an NTLM challenge. Check the credentials and that NTLM authentication is enabled.")
+}
+
+// ntlmNegotiateMessage returns the first message of the handshake.
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmFlags)
+	return msg
+}
+
+// ntlmAuthenticateMessage returns the message that answers the challenge
+// sent by the server. The client challenge and timestamp are normally nil,
+// in which case a random challenge and the timestamp of the server (or the
+// current time) are used.
+func ntlmAuthenticateMessage(
+	challenge []byte,
+	domain string,
+	username string,
+	password string,
+	clientChallenge []byte,
+	timestamp []byte) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) ||
+		binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("Invalid NTLM challenge message")
+	}
+
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & ntlmFlags
+	serverChallenge := challenge[24:32]
+	targetInfo, err := ntlmField(challenge, 40)
+	if err != nil {
+		return nil, err
+	}
+
+	if clientChallenge == nil {
+		clientChallenge = make([]byte, 8)
+		if _, err := rand.Read(clientChallenge); err != nil {
+			return nil, err
+		}
+	}
+
+	if timestamp == nil {
+		timestamp = ntlmTimestamp(targetInfo)
+	}
+
+	hash := ntowfv2(password, username, domain)
+
+	// The NTLMv2 client challenge structure, or "temp" in the spec
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	ntProof := hmacMD5(hash, serverChallenge, temp)
+	ntResponse := append(ntProof, temp...)
+	lmResponse := append(hmacMD5(hash, serverChallenge, clientChallenge), clientChallenge...)
+
+	fields := [][]byte{
+		lmResponse,
+		ntResponse,
+		utf16le(domain),
+		utf16le(username),
+		nil, // Workstation
+		nil, // Encrypted random session key
+	}
+
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+
+	offset := len(msg)
+	for i, field := range fields {
+		header := msg[12+i*8:]
+		binary.LittleEndian.PutUint16(header, uint16(len(field)))
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(header[4:], uint32(offset))
+		offset += len(field)
+	}
+
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	for _, field := range fields {
+		msg = append(msg, field...)
+	}
+
+	return msg, nil
+}
+
+// ntlmField returns the payload of the field whose length and offset are
+// at the given position in the message.
+func ntlmField(msg []byte, pos int) ([]byte, error) {
+	length := int(binary.LittleEndian.Uint16(msg[pos:]))
+	offset := int(binary.LittleEndian.Uint32(msg[pos+4:]))
+	if offset+length > len(msg) {
+		return nil, errors.New("Invalid NTLM message field")
+	}
+
+	return msg[offset : offset+length], nil
+}
+
+// ntlmTimestamp returns the timestamp from the target info of the server,
+// or the current time if there is none, as a Windows FILETIME.
+func ntlmTimestamp(targetInfo []byte) []byte {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if len(targetInfo) < 4+length {
+			break
+		}
+
+		if id == ntlmAvTimestamp && length == 8 {
+			return targetInfo[4:12]
+		}
+
+		targetInfo = targetInfo[4+length:]
+	}
+
+	// 100 nanosecond intervals since January 1, 1601
+	result := make([]byte, 8)
+	ft := uint64(time.Now().UnixNano()/100) + 116444736000000000
+	binary.LittleEndian.PutUint64(result, ft)
+	return result
+}
+
+// ntowfv2 derives the NTLMv2 hash of the password.
+func ntowfv2(password string, username string, domain string) []byte {
+	h := md4.New()
+	h.Write(utf16le(password))
+	return hmacMD5(h.Sum(nil), utf16le(strings.ToUpper(username)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+func utf16le(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	result := make([]byte, len(encoded)*2)
+	for i, v := range encoded {
+		binary.LittleEndian.PutUint16(result[i*2:], v)
+	}
+
+	return result
+}
@@ -0,0 +1,181 @@
+package winrm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testChallenge returns a challenge message with the given server
+// challenge and target info.
+func testChallenge(serverChallenge []byte, targetInfo []byte) []byte {
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmFlags)
+	copy(msg[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], 48)
+	return append(msg, targetInfo...)
+}
+
+// testTargetInfo returns the target info from the examples in MS-NLMP.
+func testTargetInfo() []byte {
+	var result []byte
+	pair := func(id uint16, value []byte) {
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint16(header, id)
+		binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+		result = append(result, header...)
+		result = append(result, value...)
+	}
+
+	pair(2, utf16le("Domain"))
+	pair(1, utf16le("Server"))
+	pair(0, nil)
+	return result
+}
+
+func TestNTOWFv2(t *testing.T) {
+	// From section 4.2.4.1.1 of MS-NLMP
+	expected := "0c868a403bfd7a93a3001ef22ef02e3f"
+	if result := hex.EncodeToString(ntowfv2("Password", "User", "Domain")); result != expected {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
+func TestNTLMAuthenticateMessage(t *testing.T) {
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	challenge := testChallenge(serverChallenge, testTargetInfo())
+
+	msg, err := ntlmAuthenticateMessage(
+		challenge, "Domain", "User", "Password", clientChallenge, make([]byte, 8))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !bytes.Equal(msg[:8], ntlmSignature) || binary.LittleEndian.Uint32(msg[8:]) != 3 {
+		t.Fatalf("bad header: %#v", msg[:12])
+	}
+
+	// From section 4.2.4.2 of MS-NLMP
+	lm, _ := ntlmField(msg, 12)
+	if result := hex.EncodeToString(lm); result != "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa" {
+		t.Fatalf("bad LMv2 response: %s", result)
+	}
+
+	nt, _ := ntlmField(msg, 20)
+	if result := hex.EncodeToString(nt[:16]); result != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Fatalf("bad NTProofStr: %s", result)
+	}
+
+	domain, _ := ntlmField(msg, 28)
+	user, _ := ntlmField(msg, 36)
+	if !bytes.Equal(domain, utf16le("Domain")) || !bytes.Equal(user, utf16le("User")) {
+		t.Fatalf("bad: %#v %#v", domain, user)
+	}
+}
+
+func TestNTLMAuthenticateMessage_invalid(t *testing.T) {
+	if _, err := ntlmAuthenticateMessage([]byte("foo"), "", "", "", nil, nil); err == nil {
+		t.Fatal("should have an error")
+	}
+
+	challenge := testChallenge(make([]byte, 8), testTargetInfo())
+	binary.LittleEndian.PutUint16(challenge[40:], 1000)
+	if _, err := ntlmAuthenticateMessage(challenge, "", "", "", nil, nil); err == nil {
+		t.Fatal("should have an error")
+	}
+}
+
+func TestNTLMTimestamp(t *testing.T) {
+	targetInfo := []byte{7, 0, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0}
+	if result := ntlmTimestamp(targetInfo); !bytes.Equal(result, targetInfo[4:12]) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if result := ntlmTimestamp(testTargetInfo()); len(result) != 8 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestNewNTLMTransport(t *testing.T) {
+	cases := []struct {
+		username string
+		user     string
+		domain   string
+	}{
+		{"foo", "foo", ""},
+		{`CORP\foo`, "foo", "CORP"},
+		{"foo@corp.example.com", "foo", "corp.example.com"},
+	}
+
+	for _, tc := range cases {
+		result := newNTLMTransport(nil, tc.username, "")
+		if result.username != tc.user || result.domain != tc.domain {
+			t.Errorf("bad %s: %#v", tc.username, result)
+		}
+	}
+}
+
+func TestNTLMTransport(t *testing.T) {
+	serverChallenge := []byte("12345678")
+	var challengedAddr string
+
+	s := newTestServerAuth(t, nil, func(w http.ResponseWriter, r *http.Request) bool {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate ")
+		msg, err := base64.StdEncoding.DecodeString(auth)
+		if err != nil || len(msg) < 12 || !bytes.Equal(msg[:8], ntlmSignature) {
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+
+		switch binary.LittleEndian.Uint32(msg[8:]) {
+		case 1:
+			challengedAddr = r.RemoteAddr
+			challenge := testChallenge(serverChallenge, testTargetInfo())
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challenge))
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		case 3:
+			if r.RemoteAddr != challengedAddr {
+				t.Errorf("authenticated on a different connection")
+			}
+
+			nt, _ := ntlmField(msg, 20)
+			hash := ntowfv2("pass", "user", "CORP")
+			if bytes.Equal(nt[:16], hmacMD5(hash, serverChallenge, nt[16:])) {
+				return true
+			}
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	})
+	defer s.Close()
+
+	config := s.config()
+	config.Username = `CORP\user`
+	config.NTLM = true
+	if _, err := New(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config.Password = "wrong"
+	if _, err := New(config); err == nil {
+		t.Fatal("should have an error")
+	}
+
+	// Basic authentication isn't accepted
+	config = s.config()
+	if _, err := New(config); err == nil {
+		t.Fatal("should have an error")
+	}
+}
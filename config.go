@@ -7,8 +7,11 @@ import (
 	"github.com/mitchellh/packer/packer/plugin"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 )
 
 // This is the default, built-in configuration that ships with
@@ -26,10 +29,20 @@ const defaultConfig = `
 	},
 
 	"commands": {
+		"artifacts": "packer-command-artifacts",
 		"build": "packer-command-build",
+		"console": "packer-command-console",
+		"convert": "packer-command-convert",
+		"fix": "packer-command-fix",
+		"fmt": "packer-command-fmt",
+		"init": "packer-command-init",
+		"inspect": "packer-command-inspect",
+		"plugins": "packer-command-plugins",
 		"validate": "packer-command-validate"
 	},
 
+	"communicators": {},
+
 	"post-processors": {
 		"vagrant": "packer-post-processor-vagrant"
 	},
@@ -44,8 +57,38 @@ type config struct {
 	PluginMinPort uint
 	PluginMaxPort uint
 
+	// The SHA256 checksums that plugin binaries must have, by the path
+	// or the name of the binary.
+	PluginChecksums map[string]string `json:"plugin_checksums"`
+
+	// Whether Packer checks for newer releases of itself, and for plugins
+	// that are known to be broken. This is off unless the user enables it.
+	UpdateCheck bool `json:"update_check"`
+
+	// The directory of the download cache, unless PACKER_CACHE_DIR is set.
+	CacheDir string `json:"cache_dir"`
+
+	// More directories that plugins are discovered in, after the default
+	// ones, so that their plugins take precedence.
+	PluginDirs []string `json:"plugin_dirs"`
+
+	// The proxies that Packer and its plugins use, unless the proxy
+	// environmental variables are set.
+	HTTPProxy  string `json:"http_proxy"`
+	HTTPSProxy string `json:"https_proxy"`
+	NoProxy    string `json:"no_proxy"`
+
+	// The default values of user variables, for the templates that
+	// declare them. Variables given on the command line override them.
+	// Lists and maps are given as they are.
+	Variables map[string]interface{} `json:"variables"`
+
+	// Whether the output of builds is never colored.
+	NoColor bool `json:"no_color"`
+
 	Builders       map[string]string
 	Commands       map[string]string
+	Communicators  map[string]string
 	PostProcessors map[string]string `json:"post-processors"`
 	Provisioners   map[string]string
 }
@@ -57,6 +100,174 @@ func decodeConfig(r io.Reader, c *config) error {
 	return decoder.Decode(c)
 }
 
+// Discover finds the plugins in the directory of the executable and in
+// the plugins directory of Packer, by the names of their binaries, such
+// as "packer-builder-foo" for the builder "foo". This way plugins can be
+// installed without being listed in the configuration file. Plugins in
+// the plugins directory take precedence over those next to the executable.
+func (c *config) Discover() error {
+	exePath, err := osext.Executable()
+	if err != nil {
+		log.Printf("[WARN] Couldn't get current exe path: %s", err)
+	} else {
+		if err := c.discover(filepath.Dir(exePath)); err != nil {
+			return err
+		}
+	}
+
+	dir, err := pluginDir()
+	if err != nil {
+		log.Printf("[WARN] Couldn't find the plugins directory: %s", err)
+		return nil
+	}
+
+	return c.discover(dir)
+}
+
+// pluginDir returns the plugins directory of Packer, which is the
+// "plugins" directory in the Packer directory unless PACKER_PLUGIN_DIR
+// is set.
+func pluginDir() (string, error) {
+	if dir := os.Getenv(packer.PluginDirEnvVar); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := packerDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "plugins"), nil
+}
+
+// SetEnvironment sets the environmental variables that give the settings of
+// the configuration to the commands and plugins, which inherit them.
+func (c *config) SetEnvironment() error {
+	// Proxies that are set in the environment take precedence, and Go
+	// looks for either case of these.
+	proxies := []struct {
+		name  string
+		value string
+	}{
+		{"HTTP_PROXY", c.HTTPProxy},
+		{"HTTPS_PROXY", c.HTTPSProxy},
+		{"NO_PROXY", c.NoProxy},
+	}
+
+	for _, proxy := range proxies {
+		if proxy.value == "" {
+			continue
+		}
+
+		if os.Getenv(proxy.name) != "" || os.Getenv(strings.ToLower(proxy.name)) != "" {
+			log.Printf("[DEBUG] %s is set in the environment, not using the config", proxy.name)
+			continue
+		}
+
+		if err := os.Setenv(proxy.name, proxy.value); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Variables) > 0 {
+		data, err := json.Marshal(c.Variables)
+		if err != nil {
+			return err
+		}
+
+		if err := os.Setenv(packer.DefaultVariablesEnvVar, string(data)); err != nil {
+			return err
+		}
+	}
+
+	if c.NoColor {
+		if err := os.Setenv(packer.NoColorEnvVar, "1"); err != nil {
+			return err
+		}
+	}
+
+	// The plugins command installs plugins into the directory they are
+	// discovered in.
+	if dir, err := pluginDir(); err != nil {
+		log.Printf("[WARN] Couldn't find the plugins directory: %s", err)
+	} else if err := os.Setenv(packer.PluginDirEnvVar, dir); err != nil {
+		return err
+	}
+
+	// The artifacts of builds are recorded in the Packer directory, unless
+	// another registry is set in the environment.
+	if os.Getenv(packer.ArtifactRegistryEnvVar) == "" {
+		if dir, err := packerDir(); err != nil {
+			log.Printf("[WARN] Couldn't find the Packer directory: %s", err)
+		} else if err := os.Setenv(packer.ArtifactRegistryEnvVar, filepath.Join(dir, "artifacts.json")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discover finds the plugins in the given directory, which may not exist.
+func (c *config) discover(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Printf("[DEBUG] Discovering plugins in: %s", dir)
+	kinds := []struct {
+		prefix  string
+		plugins *map[string]string
+	}{
+		{"packer-builder-", &c.Builders},
+		{"packer-command-", &c.Commands},
+		{"packer-communicator-", &c.Communicators},
+		{"packer-post-processor-", &c.PostProcessors},
+		{"packer-provisioner-", &c.Provisioners},
+	}
+
+	for _, kind := range kinds {
+		matches, err := filepath.Glob(filepath.Join(dir, kind.prefix+"*"))
+		if err != nil {
+			return err
+		}
+
+		if *kind.plugins == nil {
+			*kind.plugins = make(map[string]string)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || !isPluginBinary(info) {
+				continue
+			}
+
+			name := strings.TrimPrefix(filepath.Base(match), kind.prefix)
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+
+			log.Printf("[DEBUG] Discovered plugin: %s = %s", name, match)
+			(*kind.plugins)[name] = match
+		}
+	}
+
+	return nil
+}
+
+// isPluginBinary says whether the file can be a plugin, which it is if it
+// can be executed.
+func isPluginBinary(info os.FileInfo) bool {
+	if info.IsDir() {
+		return false
+	}
+
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(info.Name()), ".exe")
+	}
+
+	return info.Mode()&0111 != 0
+}
+
 // Returns an array of defined command names.
 func (c *config) CommandNames() (result []string) {
 	result = make([]string, 0, len(c.Commands))
@@ -66,13 +277,39 @@ func (c *config) CommandNames() (result []string) {
 	return
 }
 
+// Returns the defined communicator plugins, mapped to the paths of their
+// binaries. The builders start these themselves, so the paths are found
+// here in the same way as for other plugins.
+func (c *config) CommunicatorPaths() map[string]string {
+	result := make(map[string]string)
+	for name, bin := range c.Communicators {
+		result[name] = c.pluginPath(bin)
+	}
+	return result
+}
+
+// Returns the paths of the binaries of all the configured plugins.
+func (c *config) PluginPaths() []string {
+	kinds := []map[string]string{
+		c.Builders, c.Commands, c.Communicators, c.PostProcessors, c.Provisioners,
+	}
+
+	result := make([]string, 0)
+	for _, plugins := range kinds {
+		for _, bin := range plugins {
+			result = append(result, c.pluginPath(bin))
+		}
+	}
+	return result
+}
+
 // This is a proper packer.BuilderFunc that can be used to load packer.Builder
 // implementations from the defined plugins.
 func (c *config) LoadBuilder(name string) (packer.Builder, error) {
-	log.Printf("Loading builder: %s\n", name)
+	log.Printf("[DEBUG] Loading builder: %s\n", name)
 	bin, ok := c.Builders[name]
 	if !ok {
-		log.Printf("Builder not found: %s\n", name)
+		log.Printf("[WARN] Builder not found: %s\n", name)
 		return nil, nil
 	}
 
@@ -82,10 +319,10 @@ func (c *config) LoadBuilder(name string) (packer.Builder, error) {
 // This is a proper packer.CommandFunc that can be used to load packer.Command
 // implementations from the defined plugins.
 func (c *config) LoadCommand(name string) (packer.Command, error) {
-	log.Printf("Loading command: %s\n", name)
+	log.Printf("[DEBUG] Loading command: %s\n", name)
 	bin, ok := c.Commands[name]
 	if !ok {
-		log.Printf("Command not found: %s\n", name)
+		log.Printf("[WARN] Command not found: %s\n", name)
 		return nil, nil
 	}
 
@@ -95,17 +332,17 @@ func (c *config) LoadCommand(name string) (packer.Command, error) {
 // This is a proper implementation of packer.HookFunc that can be used
 // to load packer.Hook implementations from the defined plugins.
 func (c *config) LoadHook(name string) (packer.Hook, error) {
-	log.Printf("Loading hook: %s\n", name)
+	log.Printf("[DEBUG] Loading hook: %s\n", name)
 	return c.pluginClient(name).Hook()
 }
 
 // This is a proper packer.PostProcessorFunc that can be used to load
 // packer.PostProcessor implementations from defined plugins.
 func (c *config) LoadPostProcessor(name string) (packer.PostProcessor, error) {
-	log.Printf("Loading post-processor: %s", name)
+	log.Printf("[DEBUG] Loading post-processor: %s", name)
 	bin, ok := c.PostProcessors[name]
 	if !ok {
-		log.Printf("Post-processor not found: %s", name)
+		log.Printf("[WARN] Post-processor not found: %s", name)
 		return nil, nil
 	}
 
@@ -115,10 +352,10 @@ func (c *config) LoadPostProcessor(name string) (packer.PostProcessor, error) {
 // This is a proper packer.ProvisionerFunc that can be used to load
 // packer.Provisioner implementations from defined plugins.
 func (c *config) LoadProvisioner(name string) (packer.Provisioner, error) {
-	log.Printf("Loading provisioner: %s\n", name)
+	log.Printf("[DEBUG] Loading provisioner: %s\n", name)
 	bin, ok := c.Provisioners[name]
 	if !ok {
-		log.Printf("Provisioner not found: %s\n", name)
+		log.Printf("[WARN] Provisioner not found: %s\n", name)
 		return nil, nil
 	}
 
@@ -126,6 +363,25 @@ func (c *config) LoadProvisioner(name string) (packer.Provisioner, error) {
 }
 
 func (c *config) pluginClient(path string) *plugin.Client {
+	checksum, ok := c.PluginChecksums[path]
+	path = c.pluginPath(path)
+	if !ok {
+		checksum = c.PluginChecksums[filepath.Base(path)]
+	}
+
+	log.Printf("[DEBUG] Creating plugin client for path: %s", path)
+	var config plugin.ClientConfig
+	config.Cmd = exec.Command(path)
+	config.Checksum = checksum
+	config.Managed = true
+	config.MinPort = c.PluginMinPort
+	config.MaxPort = c.PluginMaxPort
+	return plugin.NewClient(&config)
+}
+
+// Returns the path to the plugin binary, looking for it on the PATH and
+// then next to the `packer` executable.
+func (c *config) pluginPath(path string) string {
 	originalPath := path
 
 	// First attempt to find the executable by consulting the PATH.
@@ -133,12 +389,12 @@ func (c *config) pluginClient(path string) *plugin.Client {
 	if err != nil {
 		// If that doesn't work, look for it in the same directory
 		// as the `packer` executable (us).
-		log.Printf("Plugin could not be found. Checking same directory as executable.")
+		log.Printf("[DEBUG] Plugin could not be found. Checking same directory as executable.")
 		exePath, err := osext.Executable()
 		if err != nil {
-			log.Printf("Couldn't get current exe path: %s", err)
+			log.Printf("[WARN] Couldn't get current exe path: %s", err)
 		} else {
-			log.Printf("Current exe path: %s", exePath)
+			log.Printf("[DEBUG] Current exe path: %s", exePath)
 			path = filepath.Join(filepath.Dir(exePath), filepath.Base(originalPath))
 		}
 	}
@@ -149,11 +405,5 @@ func (c *config) pluginClient(path string) *plugin.Client {
 		path = originalPath
 	}
 
-	log.Printf("Creating plugin client for path: %s", path)
-	var config plugin.ClientConfig
-	config.Cmd = exec.Command(path)
-	config.Managed = true
-	config.MinPort = c.PluginMinPort
-	config.MaxPort = c.PluginMaxPort
-	return plugin.NewClient(&config)
+	return path
 }
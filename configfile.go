@@ -7,3 +7,11 @@ package main
 func ConfigFile() (string, error) {
 	return configFile()
 }
+
+// PackerDir returns the directory Packer keeps its files in, such as the
+// "plugins" directory that plugins are discovered in. On Unix-like systems
+// this is the ".packer.d" directory in the home directory. On Windows,
+// this is the "packer.d" directory in the application data directory.
+func PackerDir() (string, error) {
+	return packerDir()
+}
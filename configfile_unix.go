@@ -21,10 +21,19 @@ func configFile() (string, error) {
 	return filepath.Join(dir, ".packerconfig"), nil
 }
 
+func packerDir() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, ".packer.d"), nil
+}
+
 func configDir() (string, error) {
 	// First prefer the HOME environmental variable
 	if home := os.Getenv("HOME"); home != "" {
-		log.Printf("Detected home directory from env var: %s", home)
+		log.Printf("[DEBUG] Detected home directory from env var: %s", home)
 		return home, nil
 	}
 
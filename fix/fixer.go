@@ -0,0 +1,79 @@
+// The fix package rewrites templates written for older versions of Packer
+// to the current format, such as when keys are renamed.
+package fix
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// A Fixer migrates one deprecated part of the template syntax. Templates
+// are fixed as they are decoded from JSON, into maps and slices.
+type Fixer interface {
+	// Fix fixes the template in place and returns a description of each
+	// change it made, such as "builders[0]: iso_md5 is now iso_checksum".
+	// It makes no changes to templates that are already fixed.
+	Fix(tpl map[string]interface{}) ([]string, error)
+
+	// Synopsis is a short description of what the fixer fixes.
+	Synopsis() string
+}
+
+// Fixers are the fixers by name.
+var Fixers map[string]Fixer
+
+// FixerOrder is the order the fixers run in, since later fixers may
+// depend on what earlier ones fixed.
+var FixerOrder []string
+
+func init() {
+	Fixers = map[string]Fixer{
+		"iso-md5": new(FixerISOMD5),
+	}
+
+	FixerOrder = []string{
+		"iso-md5",
+	}
+}
+
+// Deprecated returns the changes that the fixers would make to the
+// template, which are its deprecated parts, each prefixed by the name of
+// the fixer. The template itself isn't changed.
+func Deprecated(tpl map[string]interface{}) ([]string, error) {
+	// The fixers change the template in place, so they run on a copy
+	data, err := json.Marshal(tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied map[string]interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, name := range FixerOrder {
+		changes, err := Fixers[name].Fix(copied)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, change := range changes {
+			result = append(result, fmt.Sprintf("%s: %s", name, change))
+		}
+	}
+
+	return result, nil
+}
+
+// builders returns the builders of the template, in order. Builders that
+// aren't objects are nil.
+func builders(tpl map[string]interface{}) []map[string]interface{} {
+	list, _ := tpl["builders"].([]interface{})
+	result := make([]map[string]interface{}, len(list))
+	for i, raw := range list {
+		result[i], _ = raw.(map[string]interface{})
+	}
+
+	return result
+}
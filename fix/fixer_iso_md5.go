@@ -0,0 +1,39 @@
+package fix
+
+import (
+	"fmt"
+)
+
+// FixerISOMD5 replaces iso_md5 in builders, which only took MD5 checksums,
+// with iso_checksum and iso_checksum_type, which take any checksum.
+type FixerISOMD5 struct{}
+
+func (FixerISOMD5) Fix(tpl map[string]interface{}) ([]string, error) {
+	changes := make([]string, 0)
+	for i, builder := range builders(tpl) {
+		md5, ok := builder["iso_md5"]
+		if !ok {
+			continue
+		}
+
+		delete(builder, "iso_md5")
+
+		// The builders ignored iso_md5 if iso_checksum was given as well
+		if _, ok := builder["iso_checksum"]; ok {
+			changes = append(changes, fmt.Sprintf(
+				"builders[%d]: removed iso_md5, which iso_checksum overrode", i))
+			continue
+		}
+
+		builder["iso_checksum"] = md5
+		builder["iso_checksum_type"] = "md5"
+		changes = append(changes, fmt.Sprintf(
+			"builders[%d]: iso_md5 is now iso_checksum with iso_checksum_type \"md5\"", i))
+	}
+
+	return changes, nil
+}
+
+func (FixerISOMD5) Synopsis() string {
+	return `Replaces "iso_md5" in builders with "iso_checksum" and "iso_checksum_type"`
+}
@@ -0,0 +1,59 @@
+package fix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFixerISOMD5_Impl(t *testing.T) {
+	var raw interface{}
+	raw = new(FixerISOMD5)
+	if _, ok := raw.(Fixer); !ok {
+		t.Fatal("should be a Fixer")
+	}
+}
+
+func TestFixerISOMD5_Fix(t *testing.T) {
+	tpl := map[string]interface{}{
+		"builders": []interface{}{
+			map[string]interface{}{"type": "virtualbox", "iso_md5": "foo"},
+			map[string]interface{}{"type": "amazon-ebs"},
+			map[string]interface{}{"type": "vmware", "iso_md5": "bar", "iso_checksum": "baz"},
+		},
+	}
+
+	changes, err := new(FixerISOMD5).Fix(tpl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(changes) != 2 {
+		t.Fatalf("bad: %#v", changes)
+	}
+
+	expected := map[string]interface{}{
+		"builders": []interface{}{
+			map[string]interface{}{
+				"type":              "virtualbox",
+				"iso_checksum":      "foo",
+				"iso_checksum_type": "md5",
+			},
+			map[string]interface{}{"type": "amazon-ebs"},
+			map[string]interface{}{"type": "vmware", "iso_checksum": "baz"},
+		},
+	}
+
+	if !reflect.DeepEqual(tpl, expected) {
+		t.Fatalf("bad: %#v", tpl)
+	}
+
+	// Fixed templates stay the same
+	changes, err = new(FixerISOMD5).Fix(tpl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(changes) != 0 || !reflect.DeepEqual(tpl, expected) {
+		t.Fatalf("bad: %#v", tpl)
+	}
+}
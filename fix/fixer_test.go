@@ -0,0 +1,30 @@
+package fix
+
+import (
+	"testing"
+)
+
+func TestDeprecated(t *testing.T) {
+	tpl := map[string]interface{}{
+		"builders": []interface{}{
+			map[string]interface{}{"type": "virtualbox", "iso_md5": "foo"},
+			map[string]interface{}{"type": "amazon-ebs"},
+		},
+	}
+
+	deprecated, err := Deprecated(tpl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `iso-md5: builders[0]: iso_md5 is now iso_checksum with iso_checksum_type "md5"`
+	if len(deprecated) != 1 || deprecated[0] != expected {
+		t.Fatalf("bad: %#v", deprecated)
+	}
+
+	// The template itself isn't fixed
+	builder := tpl["builders"].([]interface{})[0].(map[string]interface{})
+	if _, ok := builder["iso_md5"]; !ok {
+		t.Fatalf("bad: %#v", builder)
+	}
+}
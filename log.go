@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// logOutput returns where the log is written, as set by the environment.
+// Logging is enabled by setting PACKER_LOG, or any of the other variables.
+// PACKER_LOG_LEVEL is the minimum level of the lines that are written,
+// PACKER_LOG_PATH a file they are appended to instead of stderr, and
+// PACKER_LOG_FORMAT "json" to write them as JSON.
+func logOutput() (io.Writer, error) {
+	levelName := os.Getenv("PACKER_LOG_LEVEL")
+	path := os.Getenv("PACKER_LOG_PATH")
+	format := os.Getenv("PACKER_LOG_FORMAT")
+	if os.Getenv("PACKER_LOG") == "" && levelName == "" && path == "" && format == "" {
+		return ioutil.Discard, nil
+	}
+
+	writer := &packer.LogWriter{MinLevel: packer.LogLevelTrace}
+	if levelName != "" {
+		level, err := packer.ParseLogLevel(levelName)
+		if err != nil {
+			return nil, fmt.Errorf("PACKER_LOG_LEVEL: %s", err)
+		}
+
+		writer.MinLevel = level
+	}
+
+	switch format {
+	case "", "text":
+	case "json":
+		writer.JSON = true
+	default:
+		return nil, fmt.Errorf("PACKER_LOG_FORMAT: unknown format '%s', must be text or json", format)
+	}
+
+	writer.Writer = os.Stderr
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("PACKER_LOG_PATH: %s", err)
+		}
+
+		writer.Writer = f
+	}
+
+	return writer, nil
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/mitchellh/packer/packer"
 	"github.com/mitchellh/packer/packer/plugin"
@@ -14,21 +15,22 @@ import (
 )
 
 func main() {
-	if os.Getenv("PACKER_LOG") == "" {
-		// If we don't have logging explicitly enabled, then disable it
-		log.SetOutput(ioutil.Discard)
-	} else {
-		// Logging is enabled, make sure it goes to stderr
-		log.SetOutput(os.Stderr)
+	// Logging is disabled unless it is explicitly enabled
+	logWriter, err := logOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up logging: \n\n%s\n", err)
+		os.Exit(1)
 	}
 
+	log.SetOutput(logWriter)
+
 	// If there is no explicit number of Go threads to use, then set it
 	if os.Getenv("GOMAXPROCS") == "" {
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
 
-	log.Printf("Packer Version: %s %s", packer.Version, packer.VersionPrerelease)
-	log.Printf("Packer Target OS/Arch: %s %s", runtime.GOOS, runtime.GOARCH)
+	log.Printf("[INFO] Packer Version: %s %s", packer.Version, packer.VersionPrerelease)
+	log.Printf("[INFO] Packer Target OS/Arch: %s %s", runtime.GOOS, runtime.GOARCH)
 
 	config, err := loadConfig()
 	if err != nil {
@@ -36,11 +38,32 @@ func main() {
 		os.Exit(1)
 	}
 
-	log.Printf("Packer config: %+v", config)
+	log.Printf("[DEBUG] Packer config: %+v", config)
+
+	if err := config.SetEnvironment(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error applying configuration: \n\n%s\n", err)
+		os.Exit(1)
+	}
+
+	// The -machine-readable flag can be given anywhere in the arguments,
+	// since it applies to all commands.
+	args, machineReadable := extractMachineReadable(os.Args[1:])
 
+	// The cache is shared by all the builds of the user, so that files
+	// such as ISOs are only downloaded once.
 	cacheDir := os.Getenv("PACKER_CACHE_DIR")
 	if cacheDir == "" {
-		cacheDir = "packer_cache"
+		cacheDir = config.CacheDir
+	}
+
+	if cacheDir == "" {
+		dir, err := PackerDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error preparing cache directory: \n\n%s\n", err)
+			os.Exit(1)
+		}
+
+		cacheDir = filepath.Join(dir, "cache")
 	}
 
 	cacheDir, err = filepath.Abs(cacheDir)
@@ -54,9 +77,24 @@ func main() {
 		os.Exit(1)
 	}
 
-	log.Printf("Setting cache directory: %s", cacheDir)
+	log.Printf("[INFO] Setting cache directory: %s", cacheDir)
 	cache := &packer.FileCache{CacheDir: cacheDir}
 
+	resourcesDir, err := setupTempResources()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error preparing temporary resources directory: \n\n%s\n", err)
+		os.Exit(1)
+	}
+
+	// If Packer panics, the plugins are killed by the deferred cleanup
+	// below, so clean up after the builds they were running.
+	defer func() {
+		if r := recover(); r != nil {
+			cleanupTempResources(resourcesDir, nil)
+			panic(r)
+		}
+	}()
+
 	defer plugin.CleanupClients()
 
 	envConfig := packer.DefaultEnvironmentConfig()
@@ -64,9 +102,22 @@ func main() {
 	envConfig.Commands = config.CommandNames()
 	envConfig.Components.Builder = config.LoadBuilder
 	envConfig.Components.Command = config.LoadCommand
+	envConfig.Components.Communicators = config.CommunicatorPaths()
 	envConfig.Components.Hook = config.LoadHook
 	envConfig.Components.PostProcessor = config.LoadPostProcessor
 	envConfig.Components.Provisioner = config.LoadProvisioner
+	if machineReadable {
+		envConfig.Ui = &packer.MachineReadableUi{
+			Writer: os.Stdout,
+		}
+
+		// The commands and other plugins are told with the environment,
+		// which they inherit.
+		if err := os.Setenv(packer.MachineReadableEnvVar, "1"); err != nil {
+			fmt.Fprintf(os.Stderr, "Packer initialization error: \n\n%s\n", err)
+			os.Exit(1)
+		}
+	}
 
 	env, err := packer.NewEnvironment(envConfig)
 	if err != nil {
@@ -74,24 +125,88 @@ func main() {
 		os.Exit(1)
 	}
 
-	setupSignalHandlers(env)
+	setupSignalHandlers(env, resourcesDir)
+
+	updateCheck := startUpdateCheck(config)
+	exitCode, err := env.Cli(args)
+	plugin.CleanupClients()
+	finishUpdateCheck(env.Ui(), updateCheck)
+
+	// Builds that crashed in their plugins didn't clean up after themselves
+	cleanupTempResources(resourcesDir, env.Ui())
 
-	exitCode, err := env.Cli(os.Args[1:])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error executing CLI: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	plugin.CleanupClients()
 	os.Exit(exitCode)
 }
 
+// extractMachineReadable removes the -machine-readable flag from the
+// arguments, returning whether it was given.
+func extractMachineReadable(args []string) ([]string, bool) {
+	result := make([]string, 0, len(args))
+	machineReadable := false
+	for _, arg := range args {
+		if arg == "-machine-readable" {
+			machineReadable = true
+			continue
+		}
+
+		result = append(result, arg)
+	}
+
+	return result, machineReadable
+}
+
 func loadConfig() (*config, error) {
 	var config config
 	if err := decodeConfig(bytes.NewBufferString(defaultConfig), &config); err != nil {
 		return nil, err
 	}
 
+	// Plugins that are found take precedence over the defaults, but not
+	// over those in the config file.
+	if err := config.Discover(); err != nil {
+		return nil, err
+	}
+
+	data, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return &config, nil
+	}
+
+	// The plugins in the plugin directories of the config file are found
+	// first, since the plugins that are set in it take precedence.
+	var dirs struct {
+		PluginDirs []string `json:"plugin_dirs"`
+	}
+
+	if err := json.Unmarshal(data, &dirs); err != nil {
+		return nil, err
+	}
+
+	for _, dir := range dirs.PluginDirs {
+		if err := config.discover(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := decodeConfig(bytes.NewReader(data), &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// readConfigFile returns the contents of the config file, which is nil if
+// there is no config file.
+func readConfigFile() ([]byte, error) {
 	mustExist := true
 	configFilePath := os.Getenv("PACKER_CONFIG")
 	if configFilePath == "" {
@@ -100,16 +215,16 @@ func loadConfig() (*config, error) {
 		mustExist = false
 
 		if err != nil {
-			log.Printf("Error detecing default config file path: %s", err)
+			log.Printf("[WARN] Error detecing default config file path: %s", err)
 		}
 	}
 
 	if configFilePath == "" {
-		return &config, nil
+		return nil, nil
 	}
 
-	log.Printf("Attempting to open config file: %s", configFilePath)
-	f, err := os.Open(configFilePath)
+	log.Printf("[DEBUG] Attempting to open config file: %s", configFilePath)
+	data, err := ioutil.ReadFile(configFilePath)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
@@ -119,14 +234,9 @@ func loadConfig() (*config, error) {
 			return nil, err
 		}
 
-		log.Println("File doesn't exist, but doesn't need to. Ignoring.")
-		return &config, nil
-	}
-	defer f.Close()
-
-	if err := decodeConfig(f, &config); err != nil {
-		return nil, err
+		log.Println("[DEBUG] File doesn't exist, but doesn't need to. Ignoring.")
+		return nil, nil
 	}
 
-	return &config, nil
+	return data, nil
 }
@@ -0,0 +1,224 @@
+package packer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ArtifactRegistryEnvVar is the environment variable with the path of the
+// artifact registry, where the build command records the artifacts that
+// builds produce. Packer sets it for the commands it starts.
+const ArtifactRegistryEnvVar = "PACKER_ARTIFACT_REGISTRY"
+
+// A RegisteredArtifact is the record of an artifact that a build produced,
+// which is kept after Packer exits so that the artifacts of earlier runs
+// can be listed, and destroyed when a build is forced.
+type RegisteredArtifact struct {
+	// The name of the build and the type of its builder.
+	Build       string `json:"build"`
+	BuilderType string `json:"builder_type"`
+
+	// The ID of the builder, the ID of the artifact and its files, as
+	// the artifact returned them.
+	BuilderId string   `json:"builder_id"`
+	Id        string   `json:"id"`
+	Files     []string `json:"files"`
+
+	// The absolute path of the template that was built, and its SHA256
+	// checksum, so artifacts of a template that changed since can be told
+	// apart.
+	Template     string `json:"template"`
+	TemplateHash string `json:"template_hash"`
+
+	// When the build that produced the artifact started and finished.
+	StartTime  time.Time `json:"start_time"`
+	FinishTime time.Time `json:"finish_time"`
+}
+
+func (a *RegisteredArtifact) String() string {
+	result := fmt.Sprintf("'%s' of build '%s'", a.Id, a.Build)
+	if a.Id == "" {
+		result = fmt.Sprintf("artifact of build '%s'", a.Build)
+	}
+
+	return result
+}
+
+// Destroy destroys the artifact by removing its files, and the directory
+// they were in if it's empty afterwards. Artifacts that aren't files, such
+// as images in a cloud, can't be destroyed this way.
+func (a *RegisteredArtifact) Destroy() error {
+	if len(a.Files) == 0 {
+		return fmt.Errorf("%s has no files and can't be destroyed automatically", a)
+	}
+
+	dirs := make(map[string]bool)
+	for _, path := range a.Files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		dirs[filepath.Dir(path)] = true
+	}
+
+	// Directories that aren't empty are left alone
+	for dir := range dirs {
+		os.Remove(dir)
+	}
+
+	return nil
+}
+
+// same says whether both are the record of the same artifact.
+func (a *RegisteredArtifact) same(other *RegisteredArtifact) bool {
+	return a.Template == other.Template && a.Build == other.Build &&
+		a.BuilderId == other.BuilderId && a.Id == other.Id &&
+		a.FinishTime.Equal(other.FinishTime)
+}
+
+// An ArtifactRegistry is the file where the artifacts of builds are
+// recorded. The file is locked while it's read or changed, so that
+// several runs of Packer can record their artifacts at once.
+type ArtifactRegistry struct {
+	Path string
+}
+
+type artifactRegistryFile struct {
+	Artifacts []*RegisteredArtifact `json:"artifacts"`
+}
+
+// Artifacts returns the recorded artifacts, the oldest first. A registry
+// that doesn't exist yet has no artifacts.
+func (r *ArtifactRegistry) Artifacts() ([]*RegisteredArtifact, error) {
+	unlock, err := r.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return r.read()
+}
+
+// Add records the artifacts.
+func (r *ArtifactRegistry) Add(artifacts ...*RegisteredArtifact) error {
+	return r.update(func(current []*RegisteredArtifact) []*RegisteredArtifact {
+		return append(current, artifacts...)
+	})
+}
+
+// Remove removes the records of the artifacts, such as once they are
+// destroyed.
+func (r *ArtifactRegistry) Remove(artifacts ...*RegisteredArtifact) error {
+	return r.update(func(current []*RegisteredArtifact) []*RegisteredArtifact {
+		result := make([]*RegisteredArtifact, 0, len(current))
+		for _, a := range current {
+			removed := false
+			for _, other := range artifacts {
+				if a.same(other) {
+					removed = true
+					break
+				}
+			}
+
+			if !removed {
+				result = append(result, a)
+			}
+		}
+
+		return result
+	})
+}
+
+// update changes the recorded artifacts with the function while the
+// registry is locked.
+func (r *ArtifactRegistry) update(f func([]*RegisteredArtifact) []*RegisteredArtifact) error {
+	unlock, err := r.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	artifacts, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	return r.write(f(artifacts))
+}
+
+// lock locks the lock file next to the registry, exclusively or shared,
+// and returns the function that unlocks it.
+func (r *ArtifactRegistry) lock(exclusive bool) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(r.Path+".lock", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		if err := unlockFile(file); err != nil {
+			log.Printf("[WARN] Error unlocking artifact registry: %s", err)
+		}
+
+		file.Close()
+	}, nil
+}
+
+func (r *ArtifactRegistry) read() ([]*RegisteredArtifact, error) {
+	data, err := ioutil.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return make([]*RegisteredArtifact, 0), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var f artifactRegistryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Error reading artifact registry %s: %s", r.Path, err)
+	}
+
+	if f.Artifacts == nil {
+		f.Artifacts = make([]*RegisteredArtifact, 0)
+	}
+
+	return f.Artifacts, nil
+}
+
+// write writes the registry to a temporary file first, so that a process
+// killed while writing doesn't leave half of it.
+func (r *ArtifactRegistry) write(artifacts []*RegisteredArtifact) error {
+	data, err := json.MarshalIndent(&artifactRegistryFile{artifacts}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(r.Path), "artifacts")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), r.Path)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package packer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArtifactRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &ArtifactRegistry{Path: filepath.Join(dir, "packer.d", "artifacts.json")}
+	artifacts, err := r.Artifacts()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(artifacts) != 0 {
+		t.Fatalf("bad: %#v", artifacts)
+	}
+
+	now := time.Now().UTC()
+	foo := &RegisteredArtifact{Build: "foo", Id: "1", FinishTime: now}
+	bar := &RegisteredArtifact{Build: "bar", Id: "2", Files: []string{"bar.ovf"}, FinishTime: now}
+	if err := r.Add(foo, bar); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := r.Add(&RegisteredArtifact{Build: "foo", Id: "3", FinishTime: now}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := r.Remove(foo); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	artifacts, err = r.Artifacts()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(artifacts) != 2 || artifacts[0].Id != "2" || artifacts[1].Id != "3" {
+		t.Fatalf("bad: %#v", artifacts)
+	}
+
+	if artifacts[0].Files[0] != "bar.ovf" || !artifacts[0].FinishTime.Equal(now) {
+		t.Fatalf("bad: %#v", artifacts[0])
+	}
+}
+
+func TestRegisteredArtifactDestroy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "output")
+	if err := os.Mkdir(output, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files := []string{filepath.Join(output, "foo.vmx"), filepath.Join(output, "foo.vmdk")}
+	for _, file := range files {
+		if err := ioutil.WriteFile(file, []byte("foo"), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// A file that is already gone is fine
+	a := &RegisteredArtifact{Build: "foo", Files: append(files, filepath.Join(output, "gone"))}
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("output directory should be removed: %s", err)
+	}
+
+	a = &RegisteredArtifact{Build: "foo", Id: "ami-1234"}
+	if err := a.Destroy(); err == nil {
+		t.Fatal("should have error without files")
+	}
+}
@@ -3,17 +3,103 @@ package packer
 import (
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // This is the key in configurations that is set to the name of the
 // build.
 const BuildNameConfigKey = "packer_build_name"
 
+// This is the key in configurations that is set to the type of the
+// builder of the build.
+const BuilderTypeConfigKey = "packer_builder_type"
+
+// This is the key in configurations that is set to a map of the variables
+// of the build, "build_name", "build_type" and "timestamp", which components
+// give to a ConfigTemplate as its BuildVars. The timestamp is the same for
+// all the components of a build, which run in processes of their own.
+const BuildVariablesConfigKey = "packer_build_variables"
+
 // This is the key in configurations that is set to "true" when Packer
 // debugging is enabled.
 const DebugConfigKey = "packer_debug"
 
+// This is the key in configurations that is set to "true" when builds
+// are forced, so builders replace the artifacts of earlier builds, such
+// as output directories and images with the same name, instead of failing.
+const ForceConfigKey = "packer_force"
+
+// This is the key in configurations that is set to "true" when builds
+// resume from the checkpoint of an earlier build that didn't finish,
+// instead of starting from scratch.
+const ResumeConfigKey = "packer_resume"
+
+// This is the key in configurations that is set to "true" when Packer is
+// in strict mode, where keys that components don't know are errors. See
+// DecodeConfig.
+const StrictConfigKey = "packer_strict"
+
+// This is the key in configurations that is set to what builders do when
+// a step of the build fails, which is one of the OnError values. It is
+// only set if it isn't the default, OnErrorCleanup.
+const OnErrorConfigKey = "packer_on_error"
+
+// The things builders can do when a step of the build fails.
+const (
+	// Clean up everything the build made and stop, which is the default.
+	OnErrorCleanup = "cleanup"
+
+	// Stop without cleaning up, leaving the machine and everything else
+	// the build made for inspection.
+	OnErrorAbort = "abort"
+
+	// Ask the user whether to clean up, abort or retry the step.
+	OnErrorAsk = "ask"
+
+	// Retry the step a few times before cleaning up.
+	OnErrorRetry = "retry"
+)
+
+// OnErrorValues are the valid values of SetOnError, in order.
+var OnErrorValues = []string{OnErrorCleanup, OnErrorAbort, OnErrorAsk, OnErrorRetry}
+
+// ValidOnError says whether the value is one of OnErrorValues.
+func ValidOnError(value string) bool {
+	for _, v := range OnErrorValues {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// This is the key in configurations that is set to a map of the names of
+// the communicator plugins to the paths of their binaries, so builders can
+// start the plugin that the template's "communicator" names. It is only
+// set if there are communicator plugins.
+const CommunicatorsConfigKey = "packer_communicators"
+
+// This is the key in configurations that is set to a map of the user
+// variables of the template to their values, which components give to
+// a ConfigTemplate. It is only set if the template has user variables.
+const UserVariablesConfigKey = "packer_user_variables"
+
+// This is the key in configurations that is set to a map of the metadata
+// of the build, from the "metadata" of the template, which builders record
+// on what they make where they can, such as tags on cloud images, and
+// provisioners give to what they run. It is only set if there is metadata.
+const MetadataConfigKey = "packer_metadata"
+
+// This is the key in configurations that is set to the "proxy" of the
+// template, with its "http_proxy", "https_proxy" and "no_proxy" settings,
+// which builders decode into a ProxyConfig for their HTTP clients. It is
+// only set if the template has a proxy.
+const ProxyConfigKey = "packer_proxy"
+
 // A Build represents a single job within Packer that is responsible for
 // building some machine image artifact. Builds are meant to be parallelized.
 type Build interface {
@@ -42,6 +128,38 @@ type Build interface {
 	// When SetDebug is set to true, parallelism between builds is
 	// strictly prohibited.
 	SetDebug(bool)
+
+	// SetStrict will enable/disable strict mode, where keys that the
+	// components don't know are errors. It is enabled by adding the
+	// additional key "packer_strict" to boolean true in the configuration
+	// of the various components. This must be called prior to Prepare.
+	SetStrict(bool)
+
+	// SetForce will enable/disable forcing the build, where builders
+	// replace the artifacts of earlier builds instead of failing because
+	// they exist. It is enabled by adding the additional key
+	// "packer_force" to boolean true in the configuration of the various
+	// components. This must be called prior to Prepare.
+	SetForce(bool)
+
+	// SetResume will enable/disable resuming the build from where an
+	// earlier build that didn't finish left off. It is enabled by adding
+	// the additional key "packer_resume" to boolean true in the
+	// configuration of the various components. This must be called prior
+	// to Prepare.
+	SetResume(bool)
+
+	// SetOnError sets what the builder does when a step of the build
+	// fails, which must be one of OnErrorValues. It is given to the
+	// builder as the additional key "packer_on_error" in its
+	// configuration. This must be called prior to Prepare.
+	SetOnError(string)
+
+	// SetTimeout sets how long the builder of the build may run before
+	// it is cancelled, which cleans up what it made, and the build fails.
+	// Zero means the build never times out. This overrides the timeout
+	// of the builder in the template, and must be called prior to Prepare.
+	SetTimeout(time.Duration)
 }
 
 // A build struct represents a single build job, the result of which should
@@ -52,12 +170,26 @@ type coreBuild struct {
 	name           string
 	builder        Builder
 	builderConfig  interface{}
+	builderPath    string
 	builderType    string
+	communicators  map[string]string
 	hooks          map[string][]Hook
 	postProcessors [][]coreBuildPostProcessor
 	provisioners   []coreBuildProvisioner
+	variables      map[string]string
+	metadata       map[string]string
+	proxy          *ProxyConfig
+
+	// Where the configuration of the builder is in the template, to
+	// report the errors in it with.
+	locations *templateLocations
 
 	debug         bool
+	force         bool
+	onError       string
+	resume        bool
+	strict        bool
+	timeout       time.Duration
 	l             sync.Mutex
 	prepareCalled bool
 }
@@ -68,14 +200,41 @@ type coreBuildPostProcessor struct {
 	processor         PostProcessor
 	processorType     string
 	config            interface{}
-	keepInputArtifact bool
+	keepInputArtifact *bool
+	path              string
+	locations         *templateLocations
 }
 
 // Keeps track of the provisioner and the configuration of the provisioner
 // within the build.
 type coreBuildProvisioner struct {
-	provisioner Provisioner
-	config      []interface{}
+	provisioner     Provisioner
+	provisionerType string
+	config          []interface{}
+	paths           []string
+	locations       *templateLocations
+}
+
+// timingName returns the name that the post-processor is timed by: its
+// type, and where it is in the template, since a template can have
+// several post-processors of a type.
+func (p *coreBuildPostProcessor) timingName() string {
+	if p.path == "" {
+		return p.processorType
+	}
+
+	return fmt.Sprintf("%s (%s)", p.processorType, p.path)
+}
+
+// timingName returns the name that the provisioner is timed by, in the
+// same way as for post-processors. The path of the provisioner itself is
+// the last one, after those of its overrides.
+func (p *coreBuildProvisioner) timingName() string {
+	if len(p.paths) == 0 {
+		return p.provisionerType
+	}
+
+	return fmt.Sprintf("%s (%s)", p.provisionerType, p.paths[len(p.paths)-1])
 }
 
 // Returns the name of the build.
@@ -96,14 +255,72 @@ func (b *coreBuild) Prepare() (err error) {
 	b.prepareCalled = true
 
 	packerConfig := map[string]interface{}{
-		BuildNameConfigKey: b.name,
-		DebugConfigKey:     b.debug,
+		BuildNameConfigKey:   b.name,
+		BuilderTypeConfigKey: b.builderType,
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": b.name,
+			"build_type": b.builderType,
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: b.debug,
+	}
+
+	if b.strict {
+		packerConfig[StrictConfigKey] = true
+	}
+
+	if b.force {
+		packerConfig[ForceConfigKey] = true
+	}
+
+	if b.resume {
+		packerConfig[ResumeConfigKey] = true
+	}
+
+	if b.onError != "" && b.onError != OnErrorCleanup {
+		packerConfig[OnErrorConfigKey] = b.onError
+	}
+
+	if len(b.communicators) > 0 {
+		communicators := make(map[string]interface{})
+		for name, path := range b.communicators {
+			communicators[name] = path
+		}
+
+		packerConfig[CommunicatorsConfigKey] = communicators
+	}
+
+	if len(b.variables) > 0 {
+		variables := make(map[string]interface{})
+		for k, v := range b.variables {
+			variables[k] = v
+		}
+
+		packerConfig[UserVariablesConfigKey] = variables
+	}
+
+	if len(b.metadata) > 0 {
+		metadata := make(map[string]interface{})
+		for k, v := range b.metadata {
+			metadata[k] = v
+		}
+
+		packerConfig[MetadataConfigKey] = metadata
+	}
+
+	if b.proxy != nil {
+		packerConfig[ProxyConfigKey] = map[string]interface{}{
+			"http_proxy":  b.proxy.HTTPProxy,
+			"https_proxy": b.proxy.HTTPSProxy,
+			"no_proxy":    b.proxy.NoProxy,
+		}
 	}
 
 	// Prepare the builder
 	err = b.builder.Prepare(b.builderConfig, packerConfig)
 	if err != nil {
-		log.Printf("Build '%s' prepare failure: %s\n", b.name, err)
+		log.Printf("[WARN] Build '%s' prepare failure: %s\n", b.name, err)
+		err = b.locations.locateErrors([]string{b.builderPath}, err)
 		return
 	}
 
@@ -114,6 +331,7 @@ func (b *coreBuild) Prepare() (err error) {
 		configs = append(configs, packerConfig)
 
 		if err = coreProv.provisioner.Prepare(configs...); err != nil {
+			err = coreProv.locations.locateErrors(coreProv.paths, err)
 			return
 		}
 	}
@@ -123,6 +341,7 @@ func (b *coreBuild) Prepare() (err error) {
 		for _, corePP := range ppSeq {
 			err = corePP.processor.Configure(corePP.config, packerConfig)
 			if err != nil {
+				err = corePP.locations.locateErrors([]string{corePP.path}, err)
 				return
 			}
 		}
@@ -148,7 +367,7 @@ func (b *coreBuild) Run(originalUi Ui, cache Cache) ([]Artifact, error) {
 	if len(b.provisioners) > 0 {
 		provisioners := make([]Provisioner, len(b.provisioners))
 		for i, p := range b.provisioners {
-			provisioners[i] = p.provisioner
+			provisioners[i] = &TimedProvisioner{p.timingName(), p.provisioner}
 		}
 
 		if _, ok := hooks[HookProvision]; !ok {
@@ -168,8 +387,40 @@ func (b *coreBuild) Run(originalUi Ui, cache Cache) ([]Artifact, error) {
 		originalUi,
 	}
 
-	log.Printf("Running builder: %s", b.builderType)
+	startData := map[string]string{
+		"build_name":   b.name,
+		"builder_type": b.builderType,
+	}
+
+	if err := hook.Run(HookBuildStart, builderUi, nil, startData); err != nil {
+		return nil, err
+	}
+
+	// If the builder runs for longer than the timeout, it is cancelled
+	// like it is when Packer is interrupted.
+	timedOut := make(chan struct{})
+	if b.timeout > 0 {
+		timer := time.AfterFunc(b.timeout, func() {
+			close(timedOut)
+			builderUi.Error(fmt.Sprintf("Build timed out after %s, cancelling...", b.timeout))
+			b.builder.Cancel()
+		})
+		defer timer.Stop()
+	}
+
+	log.Printf("[INFO] Running builder: %s", b.builderType)
 	builderArtifact, err := b.builder.Run(builderUi, hook, cache)
+
+	select {
+	case <-timedOut:
+		// A builder that finished right as it timed out still built
+		// its artifact, so only the cancelled builds fail.
+		if err != nil || builderArtifact == nil {
+			return nil, fmt.Errorf("Build timed out after %s", b.timeout)
+		}
+	default:
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -195,25 +446,36 @@ PostProcessorRunSeqLoop:
 			}
 
 			builderUi.Say(fmt.Sprintf("Running post-processor: %s", corePP.processorType))
+			ppStart := time.Now()
 			artifact, keep, err := corePP.processor.PostProcess(ppUi, priorArtifact)
+			ReportTiming(originalUi, Timing{TimingPostProcessor, corePP.timingName(), time.Since(ppStart)})
 			if err != nil {
 				errors = append(errors, fmt.Errorf("Post-processor failed: %s", err))
+
+				// The input of the post-processor that failed is kept, so
+				// that what the sequence made up to it isn't lost.
+				if i == 0 {
+					keepOriginalArtifact = true
+				} else {
+					artifacts = append(artifacts, priorArtifact)
+				}
+
 				continue PostProcessorRunSeqLoop
 			}
 
-			if artifact == nil {
-				log.Println("Nil artifact, halting post-processor chain.")
-				continue PostProcessorRunSeqLoop
+			// The template has the last word on whether the input is
+			// kept, if it says, even over the post-processor.
+			if corePP.keepInputArtifact != nil {
+				keep = *corePP.keepInputArtifact
 			}
 
-			keep = keep || corePP.keepInputArtifact
 			if i == 0 {
 				// This is the first post-processor. We handle deleting
 				// previous artifacts a bit different because multiple
 				// post-processors may be using the original and need it.
 				if !keepOriginalArtifact && keep {
 					log.Printf(
-						"Flagging to keep original artifact from post-processor '%s'",
+						"[DEBUG] Flagging to keep original artifact from post-processor '%s'",
 						corePP.processorType)
 					keepOriginalArtifact = true
 				}
@@ -223,20 +485,23 @@ PostProcessorRunSeqLoop:
 				if keep {
 					artifacts = append(artifacts, priorArtifact)
 				} else {
-					log.Printf("Deleting prior artifact from post-processor '%s'", corePP.processorType)
+					log.Printf("[INFO] Deleting prior artifact from post-processor '%s'", corePP.processorType)
 					if err := priorArtifact.Destroy(); err != nil {
 						errors = append(errors, fmt.Errorf("Failed cleaning up prior artifact: %s", err))
 					}
 				}
 			}
 
+			if artifact == nil {
+				log.Println("[DEBUG] Nil artifact, halting post-processor chain.")
+				continue PostProcessorRunSeqLoop
+			}
+
 			priorArtifact = artifact
 		}
 
 		// Add on the last artifact to the results
-		if priorArtifact != nil {
-			artifacts = append(artifacts, priorArtifact)
-		}
+		artifacts = append(artifacts, priorArtifact)
 	}
 
 	if keepOriginalArtifact {
@@ -244,12 +509,26 @@ PostProcessorRunSeqLoop:
 		copy(artifacts[1:], artifacts)
 		artifacts[0] = builderArtifact
 	} else {
-		log.Printf("Deleting original artifact for build '%s'", b.name)
+		log.Printf("[INFO] Deleting original artifact for build '%s'", b.name)
 		if err := builderArtifact.Destroy(); err != nil {
 			errors = append(errors, fmt.Errorf("Error destroying builder artifact: %s", err))
 		}
 	}
 
+	for _, artifact := range artifacts {
+		artifactData := map[string]string{
+			"build_name": b.name,
+			"builder_id": artifact.BuilderId(),
+			"id":         artifact.Id(),
+			"string":     artifact.String(),
+			"files":      strings.Join(artifact.Files(), ","),
+		}
+
+		if err := hook.Run(HookArtifactCreated, builderUi, nil, artifactData); err != nil {
+			errors = append(errors, err)
+		}
+	}
+
 	if len(errors) > 0 {
 		err = &MultiError{errors}
 	}
@@ -265,6 +544,52 @@ func (b *coreBuild) SetDebug(val bool) {
 	b.debug = val
 }
 
+// Sets the build into strict mode, where keys that components don't know
+// are errors.
+func (b *coreBuild) SetStrict(val bool) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.strict = val
+}
+
+// Sets the build to replace the artifacts of earlier builds.
+func (b *coreBuild) SetForce(val bool) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.force = val
+}
+
+// Sets the build to resume from where an earlier build left off.
+func (b *coreBuild) SetResume(val bool) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.resume = val
+}
+
+// Sets what the builder does when a step of the build fails.
+func (b *coreBuild) SetOnError(val string) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.onError = val
+}
+
+// Sets how long the builder may run before the build is cancelled.
+func (b *coreBuild) SetTimeout(val time.Duration) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.timeout = val
+}
+
 // Cancels the build if it is running.
 func (b *coreBuild) Cancel() {
 	b.builder.Cancel()
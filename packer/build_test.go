@@ -2,24 +2,35 @@ package packer
 
 import (
 	"cgl.tideland.biz/asserts"
+	"errors"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+)
+
+// The keep_input_artifact settings of post-processors in tests.
+var (
+	keepInput   = true
+	deleteInput = false
 )
 
 func testBuild() *coreBuild {
 	return &coreBuild{
 		name:          "test",
+		builderType:   "foo",
 		builder:       &TestBuilder{artifactId: "b"},
 		builderConfig: 42,
 		hooks: map[string][]Hook{
 			"foo": []Hook{&TestHook{}},
 		},
 		provisioners: []coreBuildProvisioner{
-			coreBuildProvisioner{&TestProvisioner{}, []interface{}{42}},
+			coreBuildProvisioner{&TestProvisioner{}, "test", []interface{}{42}, nil, nil},
 		},
 		postProcessors: [][]coreBuildPostProcessor{
 			[]coreBuildPostProcessor{
-				coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp"}, "testPP", 42, true},
+				coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp"}, "testPP", 42, &keepInput, "", nil},
 			},
 		},
 	}
@@ -40,8 +51,14 @@ func TestBuild_Prepare(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	packerConfig := map[string]interface{}{
-		BuildNameConfigKey: "test",
-		DebugConfigKey:     false,
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: false,
 	}
 
 	build := testBuild()
@@ -62,6 +79,116 @@ func TestBuild_Prepare(t *testing.T) {
 	assert.Equal(pp.configVal, []interface{}{42, packerConfig}, "config should have right value")
 }
 
+func TestBuild_Prepare_Communicators(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: false,
+		CommunicatorsConfigKey: map[string]interface{}{
+			"foo": "/bin/packer-communicator-foo",
+		},
+	}
+
+	build := testBuild()
+	build.communicators = map[string]string{"foo": "/bin/packer-communicator-foo"}
+	builder := build.builder.(*TestBuilder)
+
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should have communicators")
+}
+
+func TestBuild_Prepare_Variables(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: false,
+		UserVariablesConfigKey: map[string]interface{}{
+			"foo": "bar",
+		},
+	}
+
+	build := testBuild()
+	build.variables = map[string]string{"foo": "bar"}
+	builder := build.builder.(*TestBuilder)
+
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should have variables")
+
+	coreProv := build.provisioners[0]
+	prov := coreProv.provisioner.(*TestProvisioner)
+	assert.Equal(prov.prepConfigs, []interface{}{42, packerConfig}, "prepare should be called with variables")
+}
+
+func TestBuild_Prepare_Metadata(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: false,
+		MetadataConfigKey: map[string]interface{}{
+			"version": "1.2",
+		},
+	}
+
+	build := testBuild()
+	build.metadata = map[string]string{"version": "1.2"}
+	builder := build.builder.(*TestBuilder)
+
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should have metadata")
+
+	coreProv := build.provisioners[0]
+	prov := coreProv.provisioner.(*TestProvisioner)
+	assert.Equal(prov.prepConfigs, []interface{}{42, packerConfig}, "prepare should be called with metadata")
+}
+
+func TestBuild_Prepare_Proxy(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: false,
+		ProxyConfigKey: map[string]interface{}{
+			"http_proxy":  "http://proxy.example.com:3128",
+			"https_proxy": "",
+			"no_proxy":    "localhost",
+		},
+	}
+
+	build := testBuild()
+	build.proxy = &ProxyConfig{HTTPProxy: "http://proxy.example.com:3128", NoProxy: "localhost"}
+	builder := build.builder.(*TestBuilder)
+
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should have proxy")
+}
+
 func TestBuild_Prepare_Twice(t *testing.T) {
 	build := testBuild()
 	if err := build.Prepare(); err != nil {
@@ -86,8 +213,14 @@ func TestBuild_Prepare_Debug(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	packerConfig := map[string]interface{}{
-		BuildNameConfigKey: "test",
-		DebugConfigKey:     true,
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: true,
 	}
 
 	build := testBuild()
@@ -104,6 +237,125 @@ func TestBuild_Prepare_Debug(t *testing.T) {
 	assert.Equal(prov.prepConfigs, []interface{}{42, packerConfig}, "prepare should be called with proper config")
 }
 
+func TestBuild_Prepare_Strict(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey:  false,
+		StrictConfigKey: true,
+	}
+
+	build := testBuild()
+	builder := build.builder.(*TestBuilder)
+
+	build.SetStrict(true)
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should be strict")
+
+	coreProv := build.provisioners[0]
+	prov := coreProv.provisioner.(*TestProvisioner)
+	assert.Equal(prov.prepConfigs, []interface{}{42, packerConfig}, "prepare should be called with proper config")
+}
+
+func TestBuild_Prepare_Force(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey: false,
+		ForceConfigKey: true,
+	}
+
+	build := testBuild()
+	builder := build.builder.(*TestBuilder)
+
+	build.SetForce(true)
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should be forced")
+}
+
+func TestBuild_Prepare_Resume(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey:  false,
+		ResumeConfigKey: true,
+	}
+
+	build := testBuild()
+	builder := build.builder.(*TestBuilder)
+
+	build.SetResume(true)
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should be resumed")
+}
+
+func TestBuild_Prepare_OnError(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	packerConfig := map[string]interface{}{
+		BuildNameConfigKey:   "test",
+		BuilderTypeConfigKey: "foo",
+		BuildVariablesConfigKey: map[string]interface{}{
+			"build_name": "test",
+			"build_type": "foo",
+			"timestamp":  strconv.FormatInt(initTime.Unix(), 10),
+		},
+		DebugConfigKey:   false,
+		OnErrorConfigKey: OnErrorAbort,
+	}
+
+	build := testBuild()
+	builder := build.builder.(*TestBuilder)
+
+	build.SetOnError(OnErrorAbort)
+	build.Prepare()
+	assert.Equal(builder.prepareConfig, []interface{}{42, packerConfig}, "prepare config should have on error")
+}
+
+func TestBuild_Prepare_ErrorLocations(t *testing.T) {
+	data := `{
+  "builders": [{"type": "foo", "bar": 42}]
+}`
+
+	build := testBuild()
+	build.builderPath = "builders[0]"
+	build.locations = newTemplateLocations([]byte(data))
+	build.builder.(*TestBuilder).prepareErr = &MultiError{[]error{
+		&ConfigKeyError{Key: "bar", Message: "bad bar"},
+	}}
+
+	err := build.Prepare()
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	expected := "builders[0].bar (line 2, char 32): bad bar"
+	if errs := err.(*MultiError).Errors; errs[0].Error() != expected {
+		t.Fatalf("bad: %s", errs[0])
+	}
+}
+
 func TestBuild_Run(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -138,6 +390,43 @@ func TestBuild_Run(t *testing.T) {
 	assert.True(pp.ppCalled, "post processor should be called")
 }
 
+func TestBuild_Run_LifecycleHooks(t *testing.T) {
+	cache := &TestCache{}
+	ui := testUi()
+
+	startHook := &TestHook{}
+	artifactHook := &TestHook{}
+
+	build := testBuild()
+	build.builderType = "foo"
+	build.postProcessors = [][]coreBuildPostProcessor{}
+	build.hooks[HookBuildStart] = []Hook{startHook}
+	build.hooks[HookArtifactCreated] = []Hook{artifactHook}
+
+	build.Prepare()
+	if _, err := build.Run(ui, cache); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"build_name":   "test",
+		"builder_type": "foo",
+	}
+
+	if !reflect.DeepEqual(startHook.runData, expected) {
+		t.Fatalf("bad: %#v", startHook.runData)
+	}
+
+	data, ok := artifactHook.runData.(map[string]string)
+	if !ok {
+		t.Fatalf("bad: %#v", artifactHook.runData)
+	}
+
+	if data["build_name"] != "test" || data["id"] != "b" {
+		t.Fatalf("bad: %#v", data)
+	}
+}
+
 func TestBuild_Run_Artifacts(t *testing.T) {
 	cache := &TestCache{}
 	ui := testUi()
@@ -168,7 +457,7 @@ func TestBuild_Run_Artifacts(t *testing.T) {
 	build = testBuild()
 	build.postProcessors = [][]coreBuildPostProcessor{
 		[]coreBuildPostProcessor{
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp"}, "pp", 42, false},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp"}, "pp", 42, nil, "", nil},
 		},
 	}
 
@@ -193,10 +482,10 @@ func TestBuild_Run_Artifacts(t *testing.T) {
 	build = testBuild()
 	build.postProcessors = [][]coreBuildPostProcessor{
 		[]coreBuildPostProcessor{
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp1"}, "pp", 42, false},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp1"}, "pp", 42, nil, "", nil},
 		},
 		[]coreBuildPostProcessor{
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2"}, "pp", 42, true},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2"}, "pp", 42, &keepInput, "", nil},
 		},
 	}
 
@@ -221,12 +510,12 @@ func TestBuild_Run_Artifacts(t *testing.T) {
 	build = testBuild()
 	build.postProcessors = [][]coreBuildPostProcessor{
 		[]coreBuildPostProcessor{
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp1a"}, "pp", 42, false},
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp1b"}, "pp", 42, true},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp1a"}, "pp", 42, nil, "", nil},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp1b"}, "pp", 42, &keepInput, "", nil},
 		},
 		[]coreBuildPostProcessor{
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2a"}, "pp", 42, false},
-			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2b"}, "pp", 42, false},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2a"}, "pp", 42, nil, "", nil},
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2b"}, "pp", 42, nil, "", nil},
 		},
 	}
 
@@ -252,7 +541,7 @@ func TestBuild_Run_Artifacts(t *testing.T) {
 	build.postProcessors = [][]coreBuildPostProcessor{
 		[]coreBuildPostProcessor{
 			coreBuildPostProcessor{
-				&TestPostProcessor{artifactId: "pp", keep: true}, "pp", 42, false,
+				&TestPostProcessor{artifactId: "pp", keep: true}, "pp", 42, nil, "", nil,
 			},
 		},
 	}
@@ -272,6 +561,107 @@ func TestBuild_Run_Artifacts(t *testing.T) {
 	if !reflect.DeepEqual(artifactIds, expectedIds) {
 		t.Fatalf("unexpected ids: %#v", artifactIds)
 	}
+
+	// Test case: Test that keep_input_artifact set to false deletes the
+	// input, even if the post-processor wants to keep it.
+	build = testBuild()
+	build.postProcessors = [][]coreBuildPostProcessor{
+		[]coreBuildPostProcessor{
+			coreBuildPostProcessor{
+				&TestPostProcessor{artifactId: "pp", keep: true}, "pp", 42, &deleteInput, "", nil,
+			},
+		},
+	}
+
+	build.Prepare()
+	artifacts, err = build.Run(ui, cache)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedIds = []string{"pp"}
+	artifactIds = make([]string, len(artifacts))
+	for i, artifact := range artifacts {
+		artifactIds[i] = artifact.Id()
+	}
+
+	if !reflect.DeepEqual(artifactIds, expectedIds) {
+		t.Fatalf("unexpected ids: %#v", artifactIds)
+	}
+}
+
+func TestBuild_Run_PostProcessorFailure(t *testing.T) {
+	// The input of a post-processor that fails is kept, whether it is
+	// the artifact of the builder or of an earlier post-processor.
+	build := testBuild()
+	build.postProcessors = [][]coreBuildPostProcessor{
+		[]coreBuildPostProcessor{
+			coreBuildPostProcessor{&TestPostProcessor{err: errors.New("failed")}, "pp", 42, nil, "", nil},
+		},
+		[]coreBuildPostProcessor{
+			coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp2a"}, "pp", 42, nil, "", nil},
+			coreBuildPostProcessor{&TestPostProcessor{err: errors.New("failed")}, "pp", 42, nil, "", nil},
+		},
+	}
+
+	build.Prepare()
+	artifacts, err := build.Run(testUi(), &TestCache{})
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	expectedIds := []string{"b", "pp2a"}
+	artifactIds := make([]string, len(artifacts))
+	for i, artifact := range artifacts {
+		artifactIds[i] = artifact.Id()
+		if artifact.(*TestArtifact).destroyCalled {
+			t.Fatalf("should not destroy: %s", artifact.Id())
+		}
+	}
+
+	if !reflect.DeepEqual(artifactIds, expectedIds) {
+		t.Fatalf("unexpected ids: %#v", artifactIds)
+	}
+}
+
+// testHangingBuilder is a builder whose builds run until they are
+// cancelled.
+type testHangingBuilder struct {
+	cancelCh chan struct{}
+}
+
+func (b *testHangingBuilder) Prepare(...interface{}) error {
+	return nil
+}
+
+func (b *testHangingBuilder) Run(Ui, Hook, Cache) (Artifact, error) {
+	<-b.cancelCh
+	return nil, nil
+}
+
+func (b *testHangingBuilder) Cancel() {
+	close(b.cancelCh)
+}
+
+func TestBuild_Run_Timeout(t *testing.T) {
+	build := testBuild()
+	build.builder = &testHangingBuilder{make(chan struct{})}
+	build.SetTimeout(10 * time.Millisecond)
+	build.Prepare()
+
+	_, err := build.Run(testUi(), &TestCache{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Builds that finish in time aren't affected
+	build = testBuild()
+	build.SetTimeout(time.Hour)
+	build.Prepare()
+
+	if _, err := build.Run(testUi(), &TestCache{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
 }
 
 func TestBuild_RunBeforePrepare(t *testing.T) {
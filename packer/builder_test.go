@@ -5,6 +5,7 @@ type TestBuilder struct {
 
 	prepareCalled bool
 	prepareConfig []interface{}
+	prepareErr    error
 	runCalled     bool
 	runCache      Cache
 	runHook       Hook
@@ -15,7 +16,7 @@ type TestBuilder struct {
 func (tb *TestBuilder) Prepare(config ...interface{}) error {
 	tb.prepareCalled = true
 	tb.prepareConfig = config
-	return nil
+	return tb.prepareErr
 }
 
 func (tb *TestBuilder) Run(ui Ui, h Hook, c Cache) (Artifact, error) {
@@ -3,6 +3,8 @@ package packer
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"log"
+	"os"
 	"path/filepath"
 	"sync"
 )
@@ -33,16 +35,23 @@ type Cache interface {
 
 // FileCache implements a Cache by caching the data directly to a cache
 // directory.
+//
+// Keys are locked with a lock file next to their file as well, so that
+// other Packer processes that share the cache directory, such as ones
+// running builds at the same time, wait for each other instead of
+// writing the same file.
 type FileCache struct {
 	CacheDir string
 	l        sync.Mutex
 	rw       map[string]*sync.RWMutex
+	files    map[string][]*os.File
 }
 
 func (f *FileCache) Lock(key string) string {
 	hashKey := f.hashKey(key)
 	rw := f.rwLock(hashKey)
 	rw.Lock()
+	f.lockFile(hashKey, true)
 
 	return filepath.Join(f.CacheDir, hashKey)
 }
@@ -50,6 +59,7 @@ func (f *FileCache) Lock(key string) string {
 func (f *FileCache) Unlock(key string) {
 	hashKey := f.hashKey(key)
 	rw := f.rwLock(hashKey)
+	f.unlockFile(hashKey)
 	rw.Unlock()
 }
 
@@ -57,6 +67,7 @@ func (f *FileCache) RLock(key string) (string, bool) {
 	hashKey := f.hashKey(key)
 	rw := f.rwLock(hashKey)
 	rw.RLock()
+	f.lockFile(hashKey, false)
 
 	return filepath.Join(f.CacheDir, hashKey), true
 }
@@ -64,6 +75,7 @@ func (f *FileCache) RLock(key string) (string, bool) {
 func (f *FileCache) RUnlock(key string) {
 	hashKey := f.hashKey(key)
 	rw := f.rwLock(hashKey)
+	f.unlockFile(hashKey)
 	rw.RUnlock()
 }
 
@@ -89,3 +101,52 @@ func (f *FileCache) rwLock(hashKey string) *sync.RWMutex {
 	f.rw[hashKey] = &result
 	return &result
 }
+
+// lockFile locks the lock file of the key, exclusively or shared, waiting
+// for other processes to unlock it. If the lock file can't be used, the
+// key is only locked within this process.
+func (f *FileCache) lockFile(hashKey string, exclusive bool) {
+	path := filepath.Join(f.CacheDir, hashKey+".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		log.Printf("[WARN] Error opening cache lock file: %s", err)
+		return
+	}
+
+	log.Printf("[DEBUG] Waiting on cache lock file: %s", path)
+	if err := lockFile(file, exclusive); err != nil {
+		log.Printf("[WARN] Error locking cache lock file: %s", err)
+		file.Close()
+		return
+	}
+
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if f.files == nil {
+		f.files = make(map[string][]*os.File)
+	}
+
+	f.files[hashKey] = append(f.files[hashKey], file)
+}
+
+// unlockFile unlocks a lock of the lock file of the key. Since the locks
+// of readers are all shared, it doesn't matter which one it is.
+func (f *FileCache) unlockFile(hashKey string) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	files := f.files[hashKey]
+	if len(files) == 0 {
+		return
+	}
+
+	file := files[len(files)-1]
+	f.files[hashKey] = files[:len(files)-1]
+
+	if err := unlockFile(file); err != nil {
+		log.Printf("[WARN] Error unlocking cache lock file: %s", err)
+	}
+
+	file.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 type TestCache struct{}
@@ -59,3 +60,39 @@ func TestFileCache(t *testing.T) {
 		t.Fatalf("unknown data: %s", data)
 	}
 }
+
+func TestFileCache_LockShared(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	// Two caches of the same directory are like two processes sharing it
+	cache := &FileCache{CacheDir: cacheDir}
+	other := &FileCache{CacheDir: cacheDir}
+
+	cache.Lock("foo")
+
+	locked := make(chan struct{})
+	go func() {
+		other.Lock("foo")
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the key should still be locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cache.Unlock("foo")
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the key should be unlocked")
+	}
+
+	other.Unlock("foo")
+}
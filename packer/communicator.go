@@ -2,9 +2,19 @@ package packer
 
 import (
 	"io"
+	"os"
+	"sync"
 	"time"
 )
 
+// CmdDisconnect is a sentinel value to indicate a RemoteCmd
+// exited because the remote side disconnected us.
+const CmdDisconnect int = 2300218
+
+// CmdCancelled is a sentinel value to indicate a RemoteCmd exited
+// because it was cancelled with Cancel.
+const CmdCancelled int = 2300219
+
 // RemoteCmd represents a remote command being prepared or run.
 type RemoteCmd struct {
 	// Command is the command to run remotely. This is executed as if
@@ -12,6 +22,12 @@ type RemoteCmd struct {
 	// necessary.
 	Command string
 
+	// Env is a list of environment variables to set for the command, each
+	// in the form "KEY=value". This is the way to pass values such as the
+	// build name to a command without splicing them into the command line,
+	// where they'd need to be escaped for the remote shell.
+	Env []string
+
 	// Stdin specifies the process's standard input. If Stdin is
 	// nil, the process reads from an empty bytes.Buffer.
 	Stdin io.Reader
@@ -29,7 +45,23 @@ type RemoteCmd struct {
 	Exited bool
 
 	// Once Exited is true, this will contain the exit code of the process.
+	// If the connection was lost before the process exited, this is
+	// CmdDisconnect, and if it was cancelled, this is CmdCancelled.
 	ExitStatus int
+
+	// If the process was killed by a signal, this is the name of the
+	// signal without the "SIG" prefix, such as "KILL", and ExitStatus is
+	// 128 plus the number of the signal, like in a shell.
+	ExitSignal string
+
+	// If the exit status of the process couldn't be found out, because
+	// the connection was lost for example, this is the error that
+	// happened and ExitStatus is CmdDisconnect. It lets a failed command
+	// be told apart from a failed connection.
+	Err error
+
+	cancelCh   chan struct{}
+	cancelLock sync.Mutex
 }
 
 // A Communicator is the interface used to communicate with the machine
@@ -49,12 +81,110 @@ type Communicator interface {
 	// Upload uploads a file to the machine to the given path with the
 	// contents coming from the given reader. This method will block until
 	// it completes.
-	Upload(string, io.Reader) error
+	//
+	// The FileInfo is optional and may be nil. If it is given, it describes
+	// the data in the reader, which allows implementations to stream the
+	// contents directly rather than first reading it all to learn its size.
+	Upload(string, io.Reader, *os.FileInfo) error
+
+	// UploadDir uploads the contents of a directory recursively to
+	// the remote path. It also takes an optional slice of patterns to
+	// exclude when uploading, matched against the path of each entry
+	// relative to the source directory as well as its base name.
+	//
+	// The folder name of the source folder should be created unless there
+	// is a trailing slash on the source "/". For example: "/tmp/src" as
+	// the source will create a "src" directory in the destination unless
+	// a trailing slash is added. This is identical behavior to rsync(1).
+	UploadDir(dst string, src string, exclude []string) error
 
 	// Download downloads a file from the machine from the given remote path
 	// with the contents writing to the given writer. This method will
 	// block until it completes.
 	Download(string, io.Writer) error
+
+	// DownloadDir downloads the contents of a remote directory recursively
+	// to the local path, skipping paths that match any of the optional
+	// exclude patterns in the same way as UploadDir. The name of the
+	// source directory is created in the destination unless there is a
+	// trailing slash on the source, just like UploadDir.
+	DownloadDir(src string, dst string, exclude []string) error
+
+	// ForwardLocal listens on the local address and tunnels every
+	// connection to it to the remote address, as seen from the machine.
+	// ForwardRemote does the reverse, listening on the remote address on
+	// the machine and tunneling connections to the local address. The
+	// forward runs until the returned io.Closer is closed.
+	ForwardLocal(local string, remote string) (io.Closer, error)
+	ForwardRemote(remote string, local string) (io.Closer, error)
+}
+
+// A CommunicatorFactory creates communicators of a type that isn't built
+// into Packer, and is what communicator plugins implement. Builders use
+// it when the "communicator" of a template names a communicator plugin.
+type CommunicatorFactory interface {
+	// Prepare configures the factory with the "communicator_config" of
+	// the builder, and validates it.
+	Prepare(...interface{}) error
+
+	// New returns a communicator connected to the machine. The info
+	// describes the machine with the keys that the builder knows of,
+	// such as "host" and "port", and "id" for the ID of a cloud instance.
+	// Builders call New again until it succeeds or they time out, so it
+	// should fail fast if the machine isn't reachable yet.
+	New(info map[string]string) (Communicator, error)
+}
+
+// Cancel asks the communicator to abort the command. The communicator
+// stops the remote process as well as it can and then marks the command
+// as exited with CmdCancelled. It is safe to call Cancel more than once,
+// and before or after the command is started.
+func (r *RemoteCmd) Cancel() {
+	ch := r.cancelChan()
+
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	select {
+	case <-ch:
+		// Already cancelled
+	default:
+		close(ch)
+	}
+}
+
+// CancelAfter cancels the command once the given duration has passed,
+// unless it has already exited by then. This is how timeouts for remote
+// commands are implemented.
+func (r *RemoteCmd) CancelAfter(d time.Duration) {
+	go func() {
+		timeout := time.After(d)
+		for !r.Exited {
+			select {
+			case <-timeout:
+				r.Cancel()
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}()
+}
+
+// Cancelled returns a channel that is closed once the command has been
+// cancelled. Communicators watch this to know when to abort.
+func (r *RemoteCmd) Cancelled() <-chan struct{} {
+	return r.cancelChan()
+}
+
+func (r *RemoteCmd) cancelChan() chan struct{} {
+	r.cancelLock.Lock()
+	defer r.cancelLock.Unlock()
+
+	if r.cancelCh == nil {
+		r.cancelCh = make(chan struct{})
+	}
+
+	return r.cancelCh
 }
 
 // Wait waits for the remote command to complete.
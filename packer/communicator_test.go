@@ -24,3 +24,49 @@ func TestRemoteCmd_Wait(t *testing.T) {
 		t.Fatal("never got exit notification")
 	}
 }
+
+func TestRemoteCmd_Cancel(t *testing.T) {
+	var cmd RemoteCmd
+
+	select {
+	case <-cmd.Cancelled():
+		t.Fatal("should not be cancelled")
+	default:
+	}
+
+	// Cancelling more than once is fine
+	cmd.Cancel()
+	cmd.Cancel()
+
+	select {
+	case <-cmd.Cancelled():
+		// Success
+	default:
+		t.Fatal("should be cancelled")
+	}
+}
+
+func TestRemoteCmd_CancelAfter(t *testing.T) {
+	var cmd RemoteCmd
+	cmd.CancelAfter(10 * time.Millisecond)
+
+	select {
+	case <-cmd.Cancelled():
+		// Success
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("should be cancelled")
+	}
+}
+
+func TestRemoteCmd_CancelAfterExited(t *testing.T) {
+	var cmd RemoteCmd
+	cmd.Exited = true
+	cmd.CancelAfter(10 * time.Millisecond)
+
+	select {
+	case <-cmd.Cancelled():
+		t.Fatal("should not be cancelled")
+	case <-time.After(100 * time.Millisecond):
+		// Success
+	}
+}
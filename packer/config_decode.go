@@ -0,0 +1,126 @@
+package packer
+
+import (
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The keys of the configurations of components that are used by the core
+// itself, such as the type of the component, and so are never unknown.
+var coreConfigKeys = []string{
+	"except", "keep_input_artifact", "max_retries", "name", "on_error", "only", "override",
+	"pause_before", "timeout", "type",
+}
+
+// The name of the key in a mapstructure error message, which is the first
+// quoted word in all of them.
+var decodeErrorKey = regexp.MustCompile(`'([^']+)'`)
+
+// ConfigKeyError is an error with a single key of the configuration of a
+// component, such as a value of the wrong type. The core reports it along
+// with where the key is in the template. Key is the path of the key within
+// the configuration of the component, such as "boot_command[0]".
+type ConfigKeyError struct {
+	Key     string
+	Message string
+}
+
+func (e *ConfigKeyError) Error() string {
+	return e.Message
+}
+
+// DecodeConfig decodes the raw configurations of a component into the
+// target structure, one after the other, like mapstructure.Decode does.
+// Errors in the values of keys are returned as ConfigKeyErrors. If Packer
+// is in strict mode, keys that aren't in the structure are errors as well,
+// which catches misspelled keys that would otherwise be ignored.
+func DecodeConfig(target interface{}, raws ...interface{}) error {
+	var strict struct {
+		Strict bool `mapstructure:"packer_strict"`
+	}
+
+	errs := make([]error, 0)
+	unused := make(map[string]bool)
+	for _, raw := range raws {
+		var md mapstructure.Metadata
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			Metadata: &md,
+			Result:   target,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := decoder.Decode(raw); err != nil {
+			errs = append(errs, decodeErrors(err)...)
+		}
+
+		for _, key := range md.Unused {
+			unused[key] = true
+		}
+
+		if err := mapstructure.Decode(raw, &strict); err != nil {
+			return err
+		}
+	}
+
+	if strict.Strict {
+		keys := make([]string, 0, len(unused))
+		for key := range unused {
+			if !strings.HasPrefix(key, "packer_") && !isCoreConfigKey(key) {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+		for _, key := range keys {
+			errs = append(errs, &ConfigKeyError{
+				Key:     key,
+				Message: fmt.Sprintf("unknown configuration key: '%s'", key),
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return &MultiError{errs}
+	}
+
+	return nil
+}
+
+// decodeErrors turns the error from mapstructure into an error for each
+// key that is wrong.
+func decodeErrors(err error) []error {
+	messages := []string{err.Error()}
+	if merr, ok := err.(*mapstructure.Error); ok {
+		messages = merr.Errors
+	}
+
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		match := decodeErrorKey.FindStringSubmatch(message)
+		if match == nil {
+			errs[i] = fmt.Errorf("%s", message)
+			continue
+		}
+
+		// Fields without a mapstructure tag are named by the field in the
+		// message, but are matched to the keys without regard to case.
+		errs[i] = &ConfigKeyError{Key: strings.ToLower(match[1]), Message: message}
+	}
+
+	return errs
+}
+
+func isCoreConfigKey(key string) bool {
+	for _, coreKey := range coreConfigKeys {
+		if key == coreKey {
+			return true
+		}
+	}
+
+	return false
+}
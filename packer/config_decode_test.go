@@ -0,0 +1,95 @@
+package packer
+
+import (
+	"testing"
+)
+
+type testDecodeConfig struct {
+	Name     string `mapstructure:"vm_name"`
+	Count    int
+	Commands []string `mapstructure:"boot_command"`
+}
+
+func TestDecodeConfig(t *testing.T) {
+	var c testDecodeConfig
+	raws := []interface{}{
+		map[string]interface{}{"vm_name": "foo", "bogus": true},
+		map[string]interface{}{"count": 2},
+	}
+
+	if err := DecodeConfig(&c, raws...); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.Name != "foo" || c.Count != 2 {
+		t.Fatalf("bad: %#v", c)
+	}
+}
+
+func TestDecodeConfig_keyErrors(t *testing.T) {
+	var c testDecodeConfig
+	raw := map[string]interface{}{
+		"count":        "nope",
+		"boot_command": []interface{}{"a", []interface{}{}},
+	}
+
+	err := DecodeConfig(&c, raw)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	errs := err.(*MultiError).Errors
+	if len(errs) != 2 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	keys := make(map[string]bool)
+	for _, err := range errs {
+		keyErr, ok := err.(*ConfigKeyError)
+		if !ok {
+			t.Fatalf("bad: %#v", err)
+		}
+
+		keys[keyErr.Key] = true
+	}
+
+	if !keys["count"] || !keys["boot_command[1]"] {
+		t.Fatalf("bad: %#v", keys)
+	}
+}
+
+func TestDecodeConfig_strict(t *testing.T) {
+	var c testDecodeConfig
+	raws := []interface{}{
+		map[string]interface{}{
+			"type":                  "foo",
+			"vm_name":               "foo",
+			"zebra":                 true,
+			"bogus":                 true,
+			"packer_build_name":     "foo",
+			"packer_user_variables": map[string]string{},
+		},
+		map[string]interface{}{StrictConfigKey: true},
+	}
+
+	err := DecodeConfig(&c, raws...)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	errs := err.(*MultiError).Errors
+	if len(errs) != 2 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	for i, expected := range []string{"bogus", "zebra"} {
+		keyErr := errs[i].(*ConfigKeyError)
+		if keyErr.Key != expected {
+			t.Fatalf("bad: %#v", keyErr)
+		}
+
+		if keyErr.Error() != "unknown configuration key: '"+expected+"'" {
+			t.Fatalf("bad: %s", keyErr.Error())
+		}
+	}
+}
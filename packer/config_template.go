@@ -0,0 +1,180 @@
+package packer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The time that is given by the "timestamp" function, which is the same
+// for every template processed by a process so that the names made with
+// it match each other. The core gives it to components as the timestamp
+// of their builds, since their own processes start at different times.
+var initTime = time.Now().UTC()
+
+// ConfigTemplate processes strings from the configuration of components
+// as text/templates, with the functions that are common to all of them:
+//
+//	user "name"       - the value of a user variable of the template
+//	user_list "name"  - the elements of a list variable, to range over
+//	user_map "name"   - the keys and values of a map variable, to range
+//	                    over or index
+//	join ", " list    - the elements of the list joined with ", "
+//	build_name        - the name of the build
+//	build_type        - the type of the builder of the build
+//	timestamp         - the Unix time when the build started, in UTC
+//	uuid              - a random UUID, different for each use
+//	lower, upper      - the string in lower or upper case
+//	replace "a" "b" s - the string with every "a" replaced by "b"
+//
+// Components should process as many of their string settings with it as
+// they can, and use it in place of text/template for the settings that are
+// templates of their own.
+type ConfigTemplate struct {
+	// The values of the user variables, which components are given in
+	// their configuration under UserVariablesConfigKey.
+	UserVars map[string]string
+
+	// The variables of the build, "build_name", "build_type" and
+	// "timestamp", which components are given under
+	// BuildVariablesConfigKey. They are empty outside of a build.
+	BuildVars map[string]string
+}
+
+// Process processes the string as a template with the given data, which
+// may be nil, and returns the result.
+func (t *ConfigTemplate) Process(s string, data interface{}) (string, error) {
+	tpl, err := t.parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Validate checks that the string is a valid template, without
+// processing it. This is meant for settings that are processed later
+// with data that isn't known yet.
+func (t *ConfigTemplate) Validate(s string) error {
+	_, err := t.parse(s)
+	return err
+}
+
+func (t *ConfigTemplate) parse(s string) (*template.Template, error) {
+	return template.New("config").Funcs(template.FuncMap{
+		"build_name": t.buildVar("build_name"),
+		"build_type": t.buildVar("build_type"),
+		"join":       templateJoin,
+		"lower":      strings.ToLower,
+		"replace":    templateReplace,
+		"timestamp":  t.templateTimestamp,
+		"upper":      strings.ToUpper,
+		"user":       t.templateUser,
+		"user_list":  t.templateUserList,
+		"user_map":   t.templateUserMap,
+		"uuid":       templateUuid,
+	}).Parse(s)
+}
+
+func (t *ConfigTemplate) templateUser(name string) (string, error) {
+	value, ok := t.UserVars[name]
+	if !ok {
+		return "", fmt.Errorf("unknown user variable: %s", name)
+	}
+
+	return value, nil
+}
+
+func (t *ConfigTemplate) templateUserList(name string) ([]string, error) {
+	value, err := t.templateUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ListVariable(value)
+	if err != nil {
+		return nil, fmt.Errorf("user variable %s isn't a list: %s", name, err)
+	}
+
+	return result, nil
+}
+
+func (t *ConfigTemplate) templateUserMap(name string) (map[string]string, error) {
+	value, err := t.templateUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := MapVariable(value)
+	if err != nil {
+		return nil, fmt.Errorf("user variable %s isn't a map: %s", name, err)
+	}
+
+	return result, nil
+}
+
+// buildVar returns the function that gives the build variable.
+func (t *ConfigTemplate) buildVar(name string) func() string {
+	return func() string {
+		return t.BuildVars[name]
+	}
+}
+
+func templateJoin(sep string, list []string) string {
+	return strings.Join(list, sep)
+}
+
+func templateReplace(old string, new string, s string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+// templateTimestamp returns the timestamp of the build, or outside of a
+// build the time when this process started.
+func (t *ConfigTemplate) templateTimestamp() string {
+	if timestamp := t.BuildVars["timestamp"]; timestamp != "" {
+		return timestamp
+	}
+
+	return strconv.FormatInt(initTime.Unix(), 10)
+}
+
+// templateUuid returns a random (version 4) UUID.
+func templateUuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// processVariableDefault processes the default value of a user variable in
+// a template. Defaults can use the "env" function to read the value of an
+// environment variable, which is empty if it isn't set.
+func processVariableDefault(s string) (string, error) {
+	tpl, err := template.New("variable").Funcs(template.FuncMap{
+		"env": os.Getenv,
+	}).Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tpl.Execute(buf, nil); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,169 @@
+package packer
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConfigTemplateProcess_user(t *testing.T) {
+	tpl := &ConfigTemplate{UserVars: map[string]string{"foo": "bar"}}
+
+	result, err := tpl.Process(`{{user "foo"}}-{{.Name}}`, map[string]string{"Name": "baz"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "bar-baz" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	if _, err := tpl.Process(`{{user "missing"}}`, nil); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestConfigTemplateProcess_userListMap(t *testing.T) {
+	tpl := &ConfigTemplate{UserVars: map[string]string{
+		"groups": `["sg-1","sg-2"]`,
+		"tags":   `{"Name":"web","Owner":"ops"}`,
+		"foo":    "bar",
+	}}
+
+	cases := map[string]string{
+		`{{join "," (user_list "groups")}}`:                        "sg-1,sg-2",
+		`{{range user_list "groups"}}[{{.}}]{{end}}`:               "[sg-1][sg-2]",
+		`{{index (user_map "tags") "Name"}}`:                       "web",
+		`{{range $k, $v := user_map "tags"}}{{$k}}={{$v}};{{end}}`: "Name=web;Owner=ops;",
+		`{{user "groups"}}`:                                        `["sg-1","sg-2"]`,
+	}
+
+	for input, expected := range cases {
+		result, err := tpl.Process(input, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if result != expected {
+			t.Fatalf("%s: %s", input, result)
+		}
+	}
+
+	// Strings aren't lists or maps
+	if _, err := tpl.Process(`{{user_list "foo"}}`, nil); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if _, err := tpl.Process(`{{user_map "groups"}}`, nil); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestConfigTemplateProcess_build(t *testing.T) {
+	tpl := &ConfigTemplate{
+		BuildVars: map[string]string{"build_name": "web", "build_type": "amazon-ebs"},
+	}
+
+	result, err := tpl.Process(`{{build_name}}-{{build_type}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "web-amazon-ebs" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	// Outside of a build they are empty
+	result, err = new(ConfigTemplate).Process(`{{build_name}}{{build_type}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "" {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
+func TestConfigTemplateProcess_noVars(t *testing.T) {
+	tpl := new(ConfigTemplate)
+
+	result, err := tpl.Process("plain", nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "plain" {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
+func TestConfigTemplateValidate(t *testing.T) {
+	tpl := new(ConfigTemplate)
+
+	if err := tpl.Validate(`{{user "foo"}} {{.HTTPIP}}`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := tpl.Validate(`{{user "foo"`); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if err := tpl.Validate(`{{nope "foo"}}`); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestConfigTemplateProcess_functions(t *testing.T) {
+	tpl := new(ConfigTemplate)
+
+	cases := map[string]string{
+		`{{lower "FOO"}}`:                        "foo",
+		`{{upper "foo"}}`:                        "FOO",
+		`{{replace "-" "_" "a-b-c"}}`:            "a_b_c",
+		`{{"Foo-Bar" | replace "-" "" | lower}}`: "foobar",
+		`{{timestamp}}`:                          strconv.FormatInt(initTime.Unix(), 10),
+	}
+
+	for input, expected := range cases {
+		result, err := tpl.Process(input, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if result != expected {
+			t.Errorf("bad %s: %s", input, result)
+		}
+	}
+}
+
+func TestConfigTemplateProcess_timestamp(t *testing.T) {
+	// The timestamp of the build is used, which the core gives
+	tpl := &ConfigTemplate{BuildVars: map[string]string{"timestamp": "1234"}}
+
+	result, err := tpl.Process(`{{timestamp}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "1234" {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
+func TestConfigTemplateProcess_uuid(t *testing.T) {
+	tpl := new(ConfigTemplate)
+
+	result, err := tpl.Process(`{{uuid}} {{uuid}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	parts := strings.Split(result, " ")
+	if len(parts[0]) != 36 || parts[0][14] != '4' {
+		t.Fatalf("bad: %s", result)
+	}
+
+	if parts[0] == parts[1] {
+		t.Fatalf("should be unique: %s", result)
+	}
+}
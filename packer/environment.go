@@ -10,6 +10,33 @@ import (
 	"strings"
 )
 
+// PluginDirEnvVar is the environment variable with the plugins directory
+// of Packer, which plugins are discovered in and installed into by the
+// "plugins" command.
+const PluginDirEnvVar = "PACKER_PLUGIN_DIR"
+
+// PluginPrefixes are the prefixes of the names of plugin binaries, which
+// say the kind of plugin, such as "packer-builder-" for builders.
+var PluginPrefixes = []string{
+	"packer-builder-",
+	"packer-command-",
+	"packer-communicator-",
+	"packer-post-processor-",
+	"packer-provisioner-",
+}
+
+// IsPluginName says whether the name of a binary is the name of a plugin,
+// which is one of PluginPrefixes followed by the name of the plugin.
+func IsPluginName(name string) bool {
+	for _, prefix := range PluginPrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // The function type used to lookup Builder implementations.
 type BuilderFunc func(name string) (Builder, error)
 
@@ -34,6 +61,11 @@ type ComponentFinder struct {
 	Hook          HookFunc
 	PostProcessor PostProcessorFunc
 	Provisioner   ProvisionerFunc
+
+	// Communicators maps the names of communicator plugins to the paths
+	// of their binaries. Unlike the other components, communicators are
+	// started by the builders that use them, so they are given the paths.
+	Communicators map[string]string
 }
 
 // The environment interface provides access to the configuration and
@@ -45,6 +77,7 @@ type Environment interface {
 	Builder(string) (Builder, error)
 	Cache() Cache
 	Cli([]string) (int, error)
+	Communicators() map[string]string
 	Hook(string) (Hook, error)
 	PostProcessor(string) (PostProcessor, error)
 	Provisioner(string) (Provisioner, error)
@@ -146,6 +179,12 @@ func (e *coreEnvironment) Cache() Cache {
 	return e.cache
 }
 
+// Returns the names of the communicator plugins mapped to the paths of
+// their binaries.
+func (e *coreEnvironment) Communicators() map[string]string {
+	return e.components.Communicators
+}
+
 // Returns a hook of the given name that is registered with this
 // environment.
 func (e *coreEnvironment) Hook(name string) (h Hook, err error) {
@@ -194,7 +233,7 @@ func (e *coreEnvironment) Provisioner(name string) (p Provisioner, err error) {
 // Executes a command as if it was typed on the command-line interface.
 // The return value is the exit code of the command.
 func (e *coreEnvironment) Cli(args []string) (result int, err error) {
-	log.Printf("Environment.Cli: %#v\n", args)
+	log.Printf("[TRACE] Environment.Cli: %#v\n", args)
 
 	// If we have no arguments, just short-circuit here and print the help
 	if len(args) == 0 {
@@ -220,7 +259,7 @@ func (e *coreEnvironment) Cli(args []string) (result int, err error) {
 		}
 	}
 
-	log.Printf("command + args: %#v", args)
+	log.Printf("[TRACE] command + args: %#v", args)
 
 	version := args[0] == "version"
 	if !version {
@@ -245,7 +284,7 @@ func (e *coreEnvironment) Cli(args []string) (result int, err error) {
 
 		// If we still don't have a command, show the help.
 		if command == nil {
-			log.Printf("Environment.CLI: command not found: %s\n", args[0])
+			log.Printf("[WARN] Environment.CLI: command not found: %s\n", args[0])
 			e.printHelp()
 			return 1, nil
 		}
@@ -258,7 +297,7 @@ func (e *coreEnvironment) Cli(args []string) (result int, err error) {
 		return 0, nil
 	}
 
-	log.Printf("Executing command: %s\n", args[0])
+	log.Printf("[INFO] Executing command: %s\n", args[0])
 	return command.Run(e, args[1:]), nil
 }
 
@@ -279,7 +318,7 @@ func (e *coreEnvironment) printHelp() {
 	// Sort the keys
 	sort.Strings(e.commands)
 
-	e.ui.Say("usage: packer [--version] [--help] <command> [<args>]\n")
+	e.ui.Say("usage: packer [--version] [--help] [-machine-readable] <command> [<args>]\n")
 	e.ui.Say("Available commands are:")
 	for _, key := range e.commands {
 		var synopsis string
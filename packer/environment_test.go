@@ -127,6 +127,18 @@ func TestEnvironment_Cache(t *testing.T) {
 	}
 }
 
+func TestEnvironment_Communicators(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	communicators := map[string]string{"foo": "packer-communicator-foo"}
+
+	config := DefaultEnvironmentConfig()
+	config.Components.Communicators = communicators
+
+	env, _ := NewEnvironment(config)
+	assert.Equal(env.Communicators(), communicators, "should have the communicators")
+}
+
 func TestEnvironment_Cli_Error(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -3,6 +3,33 @@ package packer
 // This is the hook that should be fired for provisioners to run.
 const HookProvision = "packer_provision"
 
+// These hooks are run at points in the lifecycle of every build, so that
+// hooks can act on them, such as to send notifications or tag resources.
+// The data of each is a map[string]string with the "build_name" of the
+// build, along with the keys described below.
+const (
+	// HookBuildStart is run when the build starts, before the builder
+	// runs. The "builder_type" is the type of its builder.
+	HookBuildStart = "packer_build_start"
+
+	// HookInstanceLaunched is run by builders once the machine of the
+	// build is launched, before Packer connects to it. The "id" is the
+	// ID of the machine, such as the ID of the instance or the name of
+	// the virtual machine, and the "address" is where it is reached, if
+	// the builder knows it.
+	HookInstanceLaunched = "packer_instance_launched"
+
+	// HookProvisionDone is run by builders once the provisioners ran,
+	// with the communicator to the machine.
+	HookProvisionDone = "packer_provision_done"
+
+	// HookArtifactCreated is run for each artifact of the build once it
+	// finished, after the post-processors. The "builder_id", "id" and
+	// "string" are those of the artifact, and "files" are its files
+	// separated by commas.
+	HookArtifactCreated = "packer_artifact_created"
+)
+
 // A Hook is used to hook into an arbitrarily named location in a build,
 // allowing custom behavior to run at certain points along a build.
 //
@@ -0,0 +1,59 @@
+package packer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// The prefix of hooks in templates that are shell commands instead of the
+// names of hook plugins, such as "shell:./notify.sh".
+const ShellHookPrefix = "shell:"
+
+// ShellHook is a Hook that runs a shell command on the machine running
+// Packer. The name of the hook is in the PACKER_HOOK environment variable
+// of the command, and if the data of the hook is a map[string]string,
+// such as for the lifecycle hooks, each value is in an environment
+// variable named PACKER_HOOK_ and the key in upper case.
+type ShellHook struct {
+	Command string
+}
+
+func (h *ShellHook) Run(name string, ui Ui, comm Communicator, data interface{}) error {
+	env := []string{fmt.Sprintf("PACKER_HOOK=%s", name)}
+	if values, ok := data.(map[string]string); ok {
+		for key, value := range values {
+			env = append(env, fmt.Sprintf("PACKER_HOOK_%s=%s", strings.ToUpper(key), value))
+		}
+	}
+	sort.Strings(env)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", h.Command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", h.Command)
+	}
+
+	var output bytes.Buffer
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	log.Printf("[INFO] Running hook '%s' command: %s", name, h.Command)
+	err := cmd.Run()
+	if out := strings.TrimSpace(output.String()); out != "" {
+		ui.Message(out)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Hook command '%s' failed: %s", h.Command, err)
+	}
+
+	return nil
+}
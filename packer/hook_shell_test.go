@@ -0,0 +1,41 @@
+package packer
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShellHook_Implements(t *testing.T) {
+	var _ Hook = new(ShellHook)
+}
+
+func TestShellHook_Run(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	hook := &ShellHook{Command: "echo $PACKER_HOOK $PACKER_HOOK_BUILD_NAME"}
+	ui := testUi()
+	data := map[string]string{"build_name": "foo"}
+	if err := hook.Run(HookBuildStart, ui, nil, data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	output := ui.Writer.(*bytes.Buffer).String()
+	if !strings.Contains(output, "packer_build_start foo") {
+		t.Fatalf("bad: %#v", output)
+	}
+}
+
+func TestShellHook_Run_Error(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	hook := &ShellHook{Command: "exit 1"}
+	if err := hook.Run(HookBuildStart, testUi(), nil, nil); err == nil {
+		t.Fatal("should error")
+	}
+}
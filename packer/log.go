@@ -0,0 +1,143 @@
+package packer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// LogLevel is the level of a line of the log. Lines are given a level by
+// starting their message with it in brackets, such as "[DEBUG]". Lines
+// without one are at LogLevelDebug.
+type LogLevel int
+
+const (
+	LogLevelTrace LogLevel = iota
+	LogLevelDebug
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// The names of the log levels, in order, as they are tagged in lines and
+// given in PACKER_LOG_LEVEL.
+var logLevelNames = []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR"}
+
+// The format of the time the standard log package starts lines with.
+const logTimeFormat = "2006/01/02 15:04:05"
+
+// ParseLogLevel returns the log level with the given name, which is case
+// insensitive.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return LogLevel(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf(
+		"unknown log level '%s', must be one of: %s",
+		name, strings.Join(logLevelNames, ", "))
+}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+
+	return logLevelNames[l]
+}
+
+// LogWriter is the output of the standard log package that only writes
+// the lines at or above the minimum level to the writer. If JSON is true,
+// each line is written as a JSON object with its time, level and message,
+// for log aggregators to read.
+//
+// The level of a line is the first level tag in it, so that the lines of
+// plugins, which the core logs with the path of the plugin in front, keep
+// their levels.
+type LogWriter struct {
+	MinLevel LogLevel
+	JSON     bool
+	Writer   io.Writer
+}
+
+// A line of the log as it is written as JSON.
+type logEntry struct {
+	Timestamp string `json:"@timestamp"`
+	Level     string `json:"@level"`
+	Message   string `json:"@message"`
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+
+		line = MaskSensitive(line)
+		level, tagIdx := logLineLevel(line)
+		if level < w.MinLevel {
+			continue
+		}
+
+		if !w.JSON {
+			buf.WriteString(line)
+			continue
+		}
+
+		// The tag isn't part of the message, since the level is its own
+		// field, and neither is the time the log package put in front.
+		message := strings.TrimRight(line, "\n")
+		if tagIdx >= 0 {
+			tag := "[" + level.String() + "]"
+			message = message[:tagIdx] + strings.TrimLeft(message[tagIdx+len(tag):], " ")
+		}
+
+		timestamp := time.Now()
+		if len(message) > len(logTimeFormat) {
+			if t, err := time.ParseInLocation(logTimeFormat, message[:len(logTimeFormat)], time.Local); err == nil {
+				timestamp = t
+				message = message[len(logTimeFormat)+1:]
+			}
+		}
+
+		entry, err := json.Marshal(&logEntry{
+			Timestamp: timestamp.UTC().Format(time.RFC3339),
+			Level:     strings.ToLower(level.String()),
+			Message:   message,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		buf.Write(entry)
+		buf.WriteByte('\n')
+	}
+
+	if _, err := w.Writer.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// logLineLevel returns the level of the line and where its tag is, which
+// is -1 if it doesn't have one.
+func logLineLevel(line string) (LogLevel, int) {
+	level := LogLevelDebug
+	tagIdx := -1
+	for i, name := range logLevelNames {
+		idx := strings.Index(line, "["+name+"]")
+		if idx >= 0 && (tagIdx < 0 || idx < tagIdx) {
+			level = LogLevel(i)
+			tagIdx = idx
+		}
+	}
+
+	return level, tagIdx
+}
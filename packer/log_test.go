@@ -0,0 +1,71 @@
+package packer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("warn")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if level != LogLevelWarn {
+		t.Fatalf("bad: %s", level)
+	}
+
+	if _, err := ParseLogLevel("loud"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &LogWriter{MinLevel: LogLevelInfo, Writer: &buf}
+
+	data := "2013/08/01 12:00:00 [TRACE] trace\n" +
+		"2013/08/01 12:00:00 untagged\n" +
+		"2013/08/01 12:00:00 [INFO] info\n" +
+		"2013/08/01 12:00:00 packer-builder-foo: 2013/08/01 12:00:00 [ERROR] plugin\n"
+
+	n, err := w.Write([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if n != len(data) {
+		t.Fatalf("bad: %d", n)
+	}
+
+	expected := "2013/08/01 12:00:00 [INFO] info\n" +
+		"2013/08/01 12:00:00 packer-builder-foo: 2013/08/01 12:00:00 [ERROR] plugin\n"
+	if buf.String() != expected {
+		t.Fatalf("bad: %q", buf.String())
+	}
+}
+
+func TestLogWriter_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	w := &LogWriter{MinLevel: LogLevelTrace, JSON: true, Writer: &buf}
+
+	w.Write([]byte("2013/08/01 12:00:00 [WARN] a \"quoted\" warning\n"))
+	w.Write([]byte("untagged\n"))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("bad: %q", buf.String())
+	}
+
+	if !bytes.Contains(lines[0], []byte(`"@level":"warn","@message":"a \"quoted\" warning"`)) {
+		t.Fatalf("bad: %s", lines[0])
+	}
+
+	if !bytes.Contains(lines[0], []byte(`"@timestamp":"2013-08-01T`)) {
+		t.Fatalf("bad: %s", lines[0])
+	}
+
+	if !bytes.Contains(lines[1], []byte(`"@level":"debug","@message":"untagged"`)) {
+		t.Fatalf("bad: %s", lines[1])
+	}
+}
@@ -2,6 +2,8 @@ package plugin
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/mitchellh/packer/packer"
@@ -11,6 +13,8 @@ import (
 	"net/rpc"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -53,6 +57,11 @@ type ClientConfig struct {
 	// StartTimeout is the timeout to wait for the plugin to say it
 	// has started successfully.
 	StartTimeout time.Duration
+
+	// Checksum is the SHA256 checksum, in hex, that the plugin binary
+	// must have. If it is set and the binary doesn't match, the plugin
+	// isn't started.
+	Checksum string
 }
 
 // This makes sure all the managed subprocesses are killed and properly
@@ -73,7 +82,7 @@ func CleanupClients() {
 		}(client)
 	}
 
-	log.Println("waiting for all plugin processes to complete...")
+	log.Println("[DEBUG] waiting for all plugin processes to complete...")
 	wg.Wait()
 }
 
@@ -129,6 +138,17 @@ func (c *Client) Command() (packer.Command, error) {
 	return &cmdCommand{packrpc.Command(client), c}, nil
 }
 
+// Returns a communicator factory implementation that is communicating
+// over this client. If the client hasn't been started, this will start it.
+func (c *Client) CommunicatorFactory() (packer.CommunicatorFactory, error) {
+	client, err := c.rpcClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmdCommunicatorFactory{packrpc.CommunicatorFactory(client), c}, nil
+}
+
 // Returns a hook implementation that is communicating over this
 // client. If the client hasn't been started, this will start it.
 func (c *Client) Hook() (packer.Hook, error) {
@@ -188,6 +208,12 @@ func (c *Client) Kill() {
 	}()
 
 	<-done
+
+	// The plugin can't remove its socket if it is killed before it is
+	// connected to, so remove it here.
+	if c.address != "" && filepath.IsAbs(c.address) {
+		os.Remove(c.address)
+	}
 }
 
 // Starts the underlying subprocess, communicating with it to negotiate
@@ -208,18 +234,25 @@ func (c *Client) Start() (address string, err error) {
 		fmt.Sprintf("%s=%s", MagicCookieKey, MagicCookieValue),
 		fmt.Sprintf("PACKER_PLUGIN_MIN_PORT=%d", c.config.MinPort),
 		fmt.Sprintf("PACKER_PLUGIN_MAX_PORT=%d", c.config.MaxPort),
+		fmt.Sprintf("%s=%s", AuthTokenKey, packrpc.AuthToken()),
 	}
 
 	stdout := new(bytes.Buffer)
 	stderr := new(bytes.Buffer)
 
 	cmd := c.config.Cmd
+	if c.config.Checksum != "" {
+		if err = verifyChecksum(cmd.Path, c.config.Checksum); err != nil {
+			return
+		}
+	}
+
 	cmd.Env = append(cmd.Env, os.Environ()...)
 	cmd.Env = append(cmd.Env, env...)
 	cmd.Stderr = stderr
 	cmd.Stdout = stdout
 
-	log.Printf("Starting plugin: %s %#v", cmd.Path, cmd.Args)
+	log.Printf("[INFO] Starting plugin: %s %#v", cmd.Path, cmd.Args)
 	err = cmd.Start()
 	if err != nil {
 		return
@@ -241,7 +274,7 @@ func (c *Client) Start() (address string, err error) {
 	// Start goroutine to wait for process to exit
 	go func() {
 		cmd.Wait()
-		log.Printf("%s: plugin process exited\n", cmd.Path)
+		log.Printf("[DEBUG] %s: plugin process exited\n", cmd.Path)
 		c.exited = true
 	}()
 
@@ -252,7 +285,7 @@ func (c *Client) Start() (address string, err error) {
 	timeout := time.After(c.config.StartTimeout)
 
 	// Start looking for the address
-	log.Printf("Waiting for RPC address for: %s", cmd.Path)
+	log.Printf("[TRACE] Waiting for RPC address for: %s", cmd.Path)
 	for done := false; !done; {
 		select {
 		case <-timeout:
@@ -267,11 +300,23 @@ func (c *Client) Start() (address string, err error) {
 		}
 
 		if line, lerr := stdout.ReadBytes('\n'); lerr == nil {
-			// Trim the address and reset the err since we were able
-			// to read some sort of address.
-			c.address = strings.TrimSpace(string(line))
-			address = c.address
-			err = nil
+			// Reset the err since we were able to read some sort of
+			// address, and check that the plugin speaks our API.
+			var version int
+			version, address, err = parseHandshake(strings.TrimSpace(string(line)))
+			if err == nil && version != APIVersion {
+				err = fmt.Errorf(
+					"plugin %s was built for API v%d, but this Packer core speaks v%d. "+
+						"The plugin must be rebuilt against this version of Packer.",
+					cmd.Path, version, APIVersion)
+			}
+
+			if err != nil {
+				address = ""
+				return
+			}
+
+			c.address = address
 			break
 		}
 
@@ -287,6 +332,24 @@ func (c *Client) Start() (address string, err error) {
 	return
 }
 
+// parseHandshake parses the line a plugin writes when it starts, which is
+// the version of the API it was built for and the address to connect to
+// it at, separated by "|". Plugins from before the version was written
+// only write the address, and are built for the first version.
+func parseHandshake(line string) (version int, address string, err error) {
+	parts := strings.SplitN(line, "|", 2)
+	if len(parts) == 1 {
+		return 1, line, nil
+	}
+
+	version, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("plugin sent an invalid API version: %q", parts[0])
+	}
+
+	return version, parts[1], nil
+}
+
 func (c *Client) logStderr(buf *bytes.Buffer) {
 	for done := false; !done; {
 		if c.Exited() {
@@ -315,10 +378,36 @@ func (c *Client) rpcClient() (*rpc.Client, error) {
 		return nil, err
 	}
 
-	client, err := rpc.Dial("tcp", address)
+	client, err := packrpc.Dial(address)
 	if err != nil {
 		return nil, err
 	}
 
 	return client, nil
 }
+
+// verifyChecksum returns an error if the SHA256 checksum of the file at
+// the path isn't the expected one.
+func verifyChecksum(path string, expected string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error checksumming plugin %s: %s", path, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("Error checksumming plugin %s: %s", path, err)
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf(
+			"plugin %s has SHA256 checksum %s, but %s is expected. "+
+				"The plugin won't be started.",
+			path, actual, expected)
+	}
+
+	log.Printf("[DEBUG] Verified checksum of plugin: %s", path)
+	return nil
+}
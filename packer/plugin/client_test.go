@@ -1,6 +1,11 @@
 package plugin
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -47,3 +52,66 @@ func TestClient_Start_Timeout(t *testing.T) {
 		t.Fatal("err should not be nil")
 	}
 }
+
+func TestClient_Start_APIVersion(t *testing.T) {
+	cases := map[string]string{
+		"mock-unversioned": "was built for API v1, but this Packer core speaks v3",
+		"mock-version":     "was built for API v4, but this Packer core speaks v3",
+	}
+
+	for mock, expected := range cases {
+		c := NewClient(&ClientConfig{Cmd: helperProcess(mock)})
+
+		_, err := c.Start()
+		c.Kill()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("bad %s: %s", mock, err)
+		}
+	}
+}
+
+func TestClient_Start_Checksum(t *testing.T) {
+	data, err := ioutil.ReadFile(os.Args[0])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	hash := sha256.New()
+	hash.Write(data)
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	c := NewClient(&ClientConfig{Cmd: helperProcess("mock"), Checksum: checksum})
+	defer c.Kill()
+
+	if _, err := c.Start(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	process := helperProcess("mock")
+	c = NewClient(&ClientConfig{Cmd: process, Checksum: strings.Repeat("0", 64)})
+	defer c.Kill()
+
+	_, err = c.Start()
+	if err == nil || !strings.Contains(err.Error(), "has SHA256 checksum "+checksum) {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if process.Process != nil {
+		t.Fatal("plugin should not be started")
+	}
+}
+
+func TestParseHandshake(t *testing.T) {
+	version, address, err := parseHandshake("2|127.0.0.1:10000")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if version != 2 || address != "127.0.0.1:10000" {
+		t.Fatalf("bad: %d %s", version, address)
+	}
+
+	if _, _, err := parseHandshake("two|127.0.0.1:10000"); err == nil {
+		t.Fatal("should have error")
+	}
+}
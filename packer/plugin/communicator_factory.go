@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"log"
+)
+
+type cmdCommunicatorFactory struct {
+	f      packer.CommunicatorFactory
+	client *Client
+}
+
+func (c *cmdCommunicatorFactory) Prepare(configs ...interface{}) error {
+	defer func() {
+		r := recover()
+		c.checkExit(r, nil)
+	}()
+
+	return c.f.Prepare(configs...)
+}
+
+func (c *cmdCommunicatorFactory) New(info map[string]string) (packer.Communicator, error) {
+	defer func() {
+		r := recover()
+		c.checkExit(r, nil)
+	}()
+
+	return c.f.New(info)
+}
+
+func (c *cmdCommunicatorFactory) checkExit(p interface{}, cb func()) {
+	if c.client.Exited() {
+		cb()
+	} else if p != nil {
+		log.Panic(p)
+	}
+}
@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"os/exec"
+	"testing"
+)
+
+type helperCommunicatorFactory byte
+
+func (helperCommunicatorFactory) Prepare(...interface{}) error {
+	return nil
+}
+
+func (helperCommunicatorFactory) New(map[string]string) (packer.Communicator, error) {
+	return nil, errors.New("not reachable")
+}
+
+func TestCommunicatorFactory_NoExist(t *testing.T) {
+	c := NewClient(&ClientConfig{Cmd: exec.Command("i-should-not-exist")})
+	defer c.Kill()
+
+	_, err := c.CommunicatorFactory()
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestCommunicatorFactory_Good(t *testing.T) {
+	c := NewClient(&ClientConfig{Cmd: helperProcess("communicator")})
+	defer c.Kill()
+
+	f, err := c.CommunicatorFactory()
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if err := f.Prepare(nil); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if _, err := f.New(nil); err == nil || err.Error() != "not reachable" {
+		t.Fatalf("bad: %s", err)
+	}
+}
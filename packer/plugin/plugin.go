@@ -13,7 +13,6 @@ import (
 	"github.com/mitchellh/packer/packer"
 	packrpc "github.com/mitchellh/packer/packer/rpc"
 	"log"
-	"net"
 	"net/rpc"
 	"os"
 	"os/signal"
@@ -24,8 +23,19 @@ import (
 const MagicCookieKey = "PACKER_PLUGIN_MAGIC_COOKIE"
 const MagicCookieValue = "d602bf8f470bc67ca7faa0386276bbdd4330efaf76d1a219cb4d6991ca9872b2"
 
+// AuthTokenKey is the environmental variable that Packer gives its plugins
+// the token that RPC connections must start with in.
+const AuthTokenKey = "PACKER_PLUGIN_AUTH_TOKEN"
+
+// APIVersion is the version of the API between Packer and its plugins.
+// Plugins say which version they were built for when they start, and
+// Packer refuses to use plugins built for another one, since the RPC
+// calls between them would fail in odd ways in the middle of a build.
+// It must be increased whenever the interfaces of the components change.
+const APIVersion = 3
+
 // This serves a single RPC connection on the given RPC server on
-// a random port.
+// a new listener.
 func serve(server *rpc.Server) (err error) {
 	if os.Getenv(MagicCookieKey) != MagicCookieValue {
 		return errors.New("Please do not execute plugins directly. Packer will execute these for you.")
@@ -46,42 +56,45 @@ func serve(server *rpc.Server) (err error) {
 		return
 	}
 
-	log.Printf("Plugin minimum port: %d\n", minPort)
-	log.Printf("Plugin maximum port: %d\n", maxPort)
+	token := os.Getenv(AuthTokenKey)
+	if token == "" {
+		return errors.New("Packer didn't give the plugin an auth token.")
+	}
+
+	packrpc.SetAuthToken(token)
+
+	log.Printf("[TRACE] Plugin minimum port: %d\n", minPort)
+	log.Printf("[TRACE] Plugin maximum port: %d\n", maxPort)
 
 	// Set the RPC port range
 	packrpc.PortRange(int(minPort), int(maxPort))
 
-	var address string
-	var listener net.Listener
-	for port := minPort; port <= maxPort; port++ {
-		address = fmt.Sprintf("127.0.0.1:%d", port)
-		listener, err = net.Listen("tcp", address)
-		if err != nil {
-			err = nil
-			continue
-		}
-
-		break
+	listener, err := packrpc.Listen()
+	if err != nil {
+		return
 	}
 
-	defer listener.Close()
+	address := listener.Addr().String()
 
-	// Output the address to stdout
-	log.Printf("Plugin address: %s\n", address)
-	fmt.Println(address)
+	// Output the API version and the address to stdout
+	log.Printf("[TRACE] Plugin address: %s\n", address)
+	fmt.Printf("%d|%s\n", APIVersion, address)
 	os.Stdout.Sync()
 
 	// Accept a connection
-	log.Println("Waiting for connection...")
+	log.Println("[TRACE] Waiting for connection...")
 	conn, err := listener.Accept()
+
+	// Only a single connection is served, so stop listening, which also
+	// removes the socket.
+	listener.Close()
 	if err != nil {
-		log.Printf("Error accepting connection: %s\n", err.Error())
+		log.Printf("[ERROR] Error accepting connection: %s\n", err.Error())
 		return
 	}
 
 	// Serve a single connection
-	log.Println("Serving a plugin connection...")
+	log.Println("[TRACE] Serving a plugin connection...")
 	server.ServeConn(conn)
 	return
 }
@@ -95,76 +108,90 @@ func swallowInterrupts() {
 
 	go func() {
 		<-ch
-		log.Println("Received interrupt signal. Ignoring.")
+		log.Println("[WARN] Received interrupt signal. Ignoring.")
 	}()
 }
 
 // Serves a builder from a plugin.
 func ServeBuilder(builder packer.Builder) {
-	log.Println("Preparing to serve a builder plugin...")
+	log.Println("[DEBUG] Preparing to serve a builder plugin...")
 
 	server := rpc.NewServer()
 	packrpc.RegisterBuilder(server, builder)
 
 	swallowInterrupts()
 	if err := serve(server); err != nil {
-		log.Printf("ERROR: %s", err)
+		log.Printf("[ERROR] %s", err)
 		os.Exit(1)
 	}
 }
 
 // Serves a command from a plugin.
 func ServeCommand(command packer.Command) {
-	log.Println("Preparing to serve a command plugin...")
+	log.Println("[DEBUG] Preparing to serve a command plugin...")
 
 	server := rpc.NewServer()
 	packrpc.RegisterCommand(server, command)
 
 	swallowInterrupts()
 	if err := serve(server); err != nil {
-		log.Printf("ERROR: %s", err)
+		log.Printf("[ERROR] %s", err)
+		os.Exit(1)
+	}
+}
+
+// Serves a communicator factory from a plugin.
+func ServeCommunicatorFactory(f packer.CommunicatorFactory) {
+	log.Println("[DEBUG] Preparing to serve a communicator plugin...")
+
+	server := rpc.NewServer()
+	packrpc.RegisterCommunicatorFactory(server, f)
+
+	swallowInterrupts()
+	if err := serve(server); err != nil {
+		log.Printf("[ERROR] %s", err)
 		os.Exit(1)
 	}
 }
 
 // Serves a hook from a plugin.
 func ServeHook(hook packer.Hook) {
-	log.Println("Preparing to serve a hook plugin...")
+	log.Println("[DEBUG] Preparing to serve a hook plugin...")
 
 	server := rpc.NewServer()
 	packrpc.RegisterHook(server, hook)
 
 	swallowInterrupts()
 	if err := serve(server); err != nil {
-		log.Printf("ERROR: %s", err)
+		log.Printf("[ERROR] %s", err)
 		os.Exit(1)
 	}
 }
 
 // Serves a post-processor from a plugin.
 func ServePostProcessor(p packer.PostProcessor) {
-	log.Println("Preparing to serve a post-processor plugin...")
+	log.Println("[DEBUG] Preparing to serve a post-processor plugin...")
 
 	server := rpc.NewServer()
 	packrpc.RegisterPostProcessor(server, p)
 
 	swallowInterrupts()
 	if err := serve(server); err != nil {
-		log.Printf("ERROR: %s", err)
+		log.Printf("[ERROR] %s", err)
 		os.Exit(1)
 	}
 }
 
 // Serves a provisioner from a plugin.
 func ServeProvisioner(p packer.Provisioner) {
-	log.Println("Preparing to serve a provisioner plugin...")
+	log.Println("[DEBUG] Preparing to serve a provisioner plugin...")
 
 	server := rpc.NewServer()
 	packrpc.RegisterProvisioner(server, p)
 
 	swallowInterrupts()
 	if err := serve(server); err != nil {
-		log.Printf("ERROR: %s", err)
+		log.Printf("[ERROR] %s", err)
 		os.Exit(1)
 	}
 }
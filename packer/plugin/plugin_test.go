@@ -52,13 +52,21 @@ func TestHelperProcess(*testing.T) {
 		ServeBuilder(new(helperBuilder))
 	case "command":
 		ServeCommand(new(helperCommand))
+	case "communicator":
+		ServeCommunicatorFactory(new(helperCommunicatorFactory))
 	case "hook":
 		ServeHook(new(helperHook))
 	case "invalid-rpc-address":
 		fmt.Println("lolinvalid")
 	case "mock":
+		fmt.Printf("%d|:1234\n", APIVersion)
+		<-make(chan int)
+	case "mock-unversioned":
 		fmt.Println(":1234")
 		<-make(chan int)
+	case "mock-version":
+		fmt.Printf("%d|:1234\n", APIVersion+1)
+		<-make(chan int)
 	case "post-processor":
 		ServePostProcessor(new(helperPostProcessor))
 	case "provisioner":
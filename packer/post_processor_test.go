@@ -3,6 +3,7 @@ package packer
 type TestPostProcessor struct {
 	artifactId   string
 	keep         bool
+	err          error
 	configCalled bool
 	configVal    []interface{}
 	ppCalled     bool
@@ -20,5 +21,9 @@ func (pp *TestPostProcessor) PostProcess(ui Ui, a Artifact) (Artifact, bool, err
 	pp.ppCalled = true
 	pp.ppArtifact = a
 	pp.ppUi = ui
+	if pp.err != nil {
+		return nil, false, pp.err
+	}
+
 	return &TestArtifact{id: pp.artifactId}, pp.keep, nil
 }
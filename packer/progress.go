@@ -0,0 +1,163 @@
+package packer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProgressFunc is called as data is transferred with the number of bytes
+// transferred so far and the total, which is zero if it isn't known.
+type ProgressFunc func(current int64, total int64)
+
+// ProgressReader wraps a reader, calling Progress as data is read from it.
+// Giving one to Communicator.Upload reports the progress of the upload.
+type ProgressReader struct {
+	Reader   io.Reader
+	Total    int64
+	Progress ProgressFunc
+
+	current int64
+}
+
+// ProgressWriter wraps a writer, calling Progress as data is written to
+// it. Giving one to Communicator.Download reports the progress of the
+// download.
+type ProgressWriter struct {
+	Writer   io.Writer
+	Total    int64
+	Progress ProgressFunc
+
+	current int64
+}
+
+func (r *ProgressReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if n > 0 {
+		r.current += int64(n)
+		r.Progress(r.current, r.Total)
+	}
+
+	return
+}
+
+// Seek seeks the wrapped reader, which must be an io.Seeker, so that an
+// upload can be sent again from where it started. The progress moves back
+// along with it.
+func (r *ProgressReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.Reader.(io.Seeker)
+	if !ok {
+		return 0, errors.New("reader can't be seeked")
+	}
+
+	before, err := seeker.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+
+	r.current += after - before
+	return after, nil
+}
+
+func (w *ProgressWriter) Write(p []byte) (n int, err error) {
+	n, err = w.Writer.Write(p)
+	if n > 0 {
+		w.current += int64(n)
+		w.Progress(w.current, w.Total)
+	}
+
+	return
+}
+
+// The interval at which UiProgress shows the progress of a transfer.
+var uiProgressInterval = 5 * time.Second
+
+// The width of the bar that UiProgress shows, in characters.
+const uiProgressWidth = 20
+
+// UiProgress returns a ProgressFunc that periodically shows the progress of
+// a transfer as a message in the UI, so that long transfers don't look
+// like they've hung. The message has a bar with the percentage transferred,
+// the throughput and the estimated time left, if the total is known. It is
+// also reported as a machine-readable "progress" event. Transfers that
+// finish before the first message is due aren't reported at all.
+func UiProgress(ui Ui, name string) ProgressFunc {
+	start := time.Now()
+	last := start
+	shown := false
+
+	return func(current int64, total int64) {
+		done := total > 0 && current >= total
+		if time.Since(last) < uiProgressInterval && !(done && shown) {
+			return
+		}
+
+		last = time.Now()
+		shown = !done
+
+		ui.Message(formatProgress(name, current, total, last.Sub(start)))
+		ui.Machine("progress", name,
+			strconv.FormatInt(current, 10), strconv.FormatInt(total, 10))
+	}
+}
+
+// formatProgress returns the message that UiProgress shows for the
+// transfer, which has taken the given time so far.
+func formatProgress(name string, current int64, total int64, elapsed time.Duration) string {
+	var rate int64
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		rate = int64(float64(current) / seconds)
+	}
+
+	if total <= 0 {
+		return fmt.Sprintf("%s: %s, %s/s", name, formatBytes(current), formatBytes(rate))
+	}
+
+	if current > total {
+		current = total
+	}
+
+	filled := int(current * uiProgressWidth / total)
+	bar := strings.Repeat("=", filled)
+	if filled < uiProgressWidth {
+		bar += ">" + strings.Repeat(" ", uiProgressWidth-filled-1)
+	}
+
+	message := fmt.Sprintf("%s: [%s] %3d%% %s of %s, %s/s",
+		name, bar, current*100/total, formatBytes(current), formatBytes(total),
+		formatBytes(rate))
+
+	if current < total && rate > 0 {
+		eta := time.Duration((total-current)/rate) * time.Second
+		message += fmt.Sprintf(", ETA %s", eta)
+	}
+
+	return message
+}
+
+// formatBytes returns the number of bytes in the largest unit it is at
+// least one of.
+func formatBytes(n int64) string {
+	units := []string{"KB", "MB", "GB", "TB"}
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
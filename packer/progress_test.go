@@ -0,0 +1,157 @@
+package packer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReader(t *testing.T) {
+	var calls [][2]int64
+	r := &ProgressReader{
+		Reader: strings.NewReader("foobar"),
+		Total:  6,
+		Progress: func(current int64, total int64) {
+			calls = append(calls, [2]int64{current, total})
+		},
+	}
+
+	buf := make([]byte, 4)
+	r.Read(buf)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "ar" {
+		t.Fatalf("bad: %s", data)
+	}
+
+	if len(calls) != 2 || calls[0] != [2]int64{4, 6} || calls[1] != [2]int64{6, 6} {
+		t.Fatalf("bad: %#v", calls)
+	}
+}
+
+func TestProgressReader_Seek(t *testing.T) {
+	var current int64
+	r := &ProgressReader{
+		Reader: strings.NewReader("foobar"),
+		Total:  6,
+		Progress: func(c int64, total int64) {
+			current = c
+		},
+	}
+
+	r.Read(make([]byte, 4))
+	if _, err := r.Seek(1, os.SEEK_SET); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "oobar" || current != 6 {
+		t.Fatalf("bad: %s %d", data, current)
+	}
+
+	// Readers that can't seek can't be seeked through it either
+	r = &ProgressReader{Reader: bytes.NewBufferString("foobar")}
+	if _, err := r.Seek(0, os.SEEK_SET); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var output bytes.Buffer
+	var current int64
+	w := &ProgressWriter{
+		Writer: &output,
+		Progress: func(c int64, total int64) {
+			if total != 0 {
+				t.Fatalf("bad total: %d", total)
+			}
+
+			current = c
+		},
+	}
+
+	w.Write([]byte("foo"))
+	w.Write([]byte("bar"))
+	if output.String() != "foobar" || current != 6 {
+		t.Fatalf("bad: %q %d", output.String(), current)
+	}
+}
+
+func TestUiProgress(t *testing.T) {
+	defer func(d time.Duration) { uiProgressInterval = d }(uiProgressInterval)
+	ui := testUi()
+
+	// Quick transfers aren't reported
+	uiProgressInterval = time.Hour
+	progress := UiProgress(ui, "Upload")
+	progress(5, 10)
+	progress(10, 10)
+	if result := readWriter(ui); result != "" {
+		t.Fatalf("bad: %q", result)
+	}
+
+	uiProgressInterval = 0
+	progress = UiProgress(ui, "Upload")
+	progress(5, 10)
+	if result := readWriter(ui); !strings.HasPrefix(result, "Upload: [==========>         ]  50% 5 B of 10 B, ") {
+		t.Fatalf("bad: %q", result)
+	}
+
+	// Once progress was shown, the end is always shown
+	uiProgressInterval = time.Hour
+	progress(10, 10)
+	if result := readWriter(ui); !strings.HasPrefix(result, "Upload: [====================] 100% 10 B of 10 B, ") {
+		t.Fatalf("bad: %q", result)
+	}
+
+	// Unknown totals
+	uiProgressInterval = 0
+	progress = UiProgress(ui, "Download")
+	progress(42, 0)
+	if result := readWriter(ui); !strings.HasPrefix(result, "Download: 42 B, ") {
+		t.Fatalf("bad: %q", result)
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	cases := []struct {
+		current  int64
+		total    int64
+		elapsed  time.Duration
+		expected string
+	}{
+		{
+			25 * 1024 * 1024, 100 * 1024 * 1024, 10 * time.Second,
+			"ISO: [=====>              ]  25% 25.0 MB of 100.0 MB, 2.5 MB/s, ETA 30s",
+		},
+		{
+			100, 100, time.Second,
+			"ISO: [====================] 100% 100 B of 100 B, 100 B/s",
+		},
+		{
+			0, 100, 0,
+			"ISO: [>                   ]   0% 0 B of 100 B, 0 B/s",
+		},
+		{
+			3 * 1024 * 1024 * 1024, 0, 2 * time.Second,
+			"ISO: 3.0 GB, 1.5 GB/s",
+		},
+	}
+
+	for _, tc := range cases {
+		result := formatProgress("ISO", tc.current, tc.total, tc.elapsed)
+		if result != tc.expected {
+			t.Errorf("bad: %q", result)
+		}
+	}
+}
@@ -1,5 +1,23 @@
 package packer
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// How long RetriedProvisioner waits before running a provisioner again,
+// so that errors such as a mirror being down have a moment to pass.
+var provisionerRetryWait = 5 * time.Second
+
+// How long TimeoutProvisioner waits for a provisioner that timed out to
+// return once its commands are cancelled. A provisioner that is stuck in
+// something other than a remote command, such as an upload, is given up on
+// after it.
+var provisionerCancelGrace = 30 * time.Second
+
 // A provisioner is responsible for installing and configuring software
 // on a machine prior to building the actual image.
 type Provisioner interface {
@@ -32,3 +50,148 @@ func (h *ProvisionHook) Run(name string, ui Ui, comm Communicator, data interfac
 
 	return nil
 }
+
+// PausedProvisioner is a provisioner that waits before running another
+// provisioner, such as for services of a machine that just booted to
+// settle. It is what the "pause_before" setting of provisioners in a
+// template makes.
+type PausedProvisioner struct {
+	PauseBefore time.Duration
+	Provisioner Provisioner
+}
+
+func (p *PausedProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *PausedProvisioner) Provision(ui Ui, comm Communicator) error {
+	ui.Say(fmt.Sprintf("Pausing %s before the next provisioner...", p.PauseBefore))
+	time.Sleep(p.PauseBefore)
+
+	return p.Provisioner.Provision(ui, comm)
+}
+
+// RetriedProvisioner is a provisioner that runs another provisioner again
+// when it fails, up to MaxRetries more times, so that errors that pass by
+// themselves, such as a mirror being down, don't fail the build. It is
+// what the "max_retries" setting of provisioners in a template makes.
+type RetriedProvisioner struct {
+	MaxRetries  int
+	Provisioner Provisioner
+}
+
+func (p *RetriedProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *RetriedProvisioner) Provision(ui Ui, comm Communicator) error {
+	for retry := 1; ; retry++ {
+		err := p.Provisioner.Provision(ui, comm)
+		if err == nil || retry > p.MaxRetries {
+			return err
+		}
+
+		log.Printf("[WARN] Provisioner failed, retrying: %s", err)
+		ui.Error(fmt.Sprintf("Provisioner failed, retrying (retry %d of %d): %s",
+			retry, p.MaxRetries, err))
+		time.Sleep(provisionerRetryWait)
+	}
+}
+
+// TimedProvisioner is a provisioner that reports how long another
+// provisioner took, including its pauses and retries, as a timing of the
+// build with the given name. Builds time every provisioner they run.
+type TimedProvisioner struct {
+	Name        string
+	Provisioner Provisioner
+}
+
+func (p *TimedProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *TimedProvisioner) Provision(ui Ui, comm Communicator) error {
+	start := time.Now()
+	err := p.Provisioner.Provision(ui, comm)
+	ReportTiming(ui, Timing{TimingProvisioner, p.Name, time.Since(start)})
+	return err
+}
+
+// TimeoutProvisioner is a provisioner that fails if another provisioner
+// runs for longer than Timeout, cancelling the remote commands it started,
+// so that a command that hangs doesn't stall the build. It is what the
+// "timeout" setting of provisioners in a template makes.
+type TimeoutProvisioner struct {
+	Timeout     time.Duration
+	Provisioner Provisioner
+}
+
+func (p *TimeoutProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *TimeoutProvisioner) Provision(ui Ui, comm Communicator) error {
+	timeoutComm := &timeoutCommunicator{Communicator: comm}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Provisioner.Provision(ui, timeoutComm)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(p.Timeout):
+	}
+
+	// The provisioner is waited for a while after its commands are
+	// cancelled, so that it is done with the communicator before the
+	// build goes on.
+	log.Printf("[WARN] Provisioner timed out after %s, cancelling its commands", p.Timeout)
+	ui.Error(fmt.Sprintf("Provisioner timed out after %s, cancelling it...", p.Timeout))
+	timeoutComm.cancel()
+
+	select {
+	case <-errCh:
+	case <-time.After(provisionerCancelGrace):
+		log.Printf("[WARN] Provisioner didn't stop within %s of being cancelled", provisionerCancelGrace)
+		ui.Error(fmt.Sprintf(
+			"Provisioner didn't stop within %s of being cancelled, going on without it.",
+			provisionerCancelGrace))
+	}
+
+	return fmt.Errorf("Provisioner timed out after %s", p.Timeout)
+}
+
+// timeoutCommunicator is a communicator that keeps the remote commands
+// that are started with it, so that they can be cancelled. Once they are,
+// no more commands can be started.
+type timeoutCommunicator struct {
+	Communicator
+
+	cmds      []*RemoteCmd
+	cancelled bool
+	l         sync.Mutex
+}
+
+func (c *timeoutCommunicator) Start(cmd *RemoteCmd) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.cancelled {
+		return errors.New("provisioner timed out")
+	}
+
+	c.cmds = append(c.cmds, cmd)
+	return c.Communicator.Start(cmd)
+}
+
+func (c *timeoutCommunicator) cancel() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.cancelled = true
+	for _, cmd := range c.cmds {
+		cmd.Cancel()
+	}
+}
@@ -1,6 +1,12 @@
 package packer
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
 
 type TestProvisioner struct {
 	prepCalled  bool
@@ -47,4 +53,212 @@ func TestProvisionHook(t *testing.T) {
 	}
 }
 
+func TestPausedProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &PausedProvisioner{}
+	if _, ok := raw.(Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestPausedProvisioner(t *testing.T) {
+	p := &TestProvisioner{}
+	paused := &PausedProvisioner{10 * time.Millisecond, p}
+
+	if err := paused.Prepare(42); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !p.prepCalled || len(p.prepConfigs) != 1 || p.prepConfigs[0] != 42 {
+		t.Fatalf("should prepare: %#v", p)
+	}
+
+	ui := testUi()
+	start := time.Now()
+	if err := paused.Provision(ui, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if time.Since(start) < 10*time.Millisecond {
+		t.Fatal("should pause")
+	}
+
+	if !p.provCalled {
+		t.Fatal("should provision")
+	}
+
+	if readWriter(ui) != "Pausing 10ms before the next provisioner...\n" {
+		t.Fatal("should say it pauses")
+	}
+}
+
+// flakyProvisioner fails until it has been run a number of times.
+type flakyProvisioner struct {
+	TestProvisioner
+	failures int
+	runs     int
+}
+
+func (p *flakyProvisioner) Provision(Ui, Communicator) error {
+	p.runs++
+	if p.runs <= p.failures {
+		return errors.New("mirror is down")
+	}
+
+	return nil
+}
+
+func TestRetriedProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &RetriedProvisioner{}
+	if _, ok := raw.(Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestRetriedProvisioner(t *testing.T) {
+	provisionerRetryWait = 0
+	defer func() { provisionerRetryWait = 5 * time.Second }()
+
+	p := &flakyProvisioner{failures: 2}
+	retried := &RetriedProvisioner{2, p}
+	if err := retried.Provision(testUi(), nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.runs != 3 {
+		t.Fatalf("bad: %d", p.runs)
+	}
+
+	// It gives up after the retries
+	p = &flakyProvisioner{failures: 3}
+	retried = &RetriedProvisioner{2, p}
+	if err := retried.Provision(testUi(), nil); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if p.runs != 3 {
+		t.Fatalf("bad: %d", p.runs)
+	}
+}
+
+// hangingProvisioner runs a command that never exits by itself.
+type hangingProvisioner struct {
+	TestProvisioner
+	cmd *RemoteCmd
+}
+
+func (p *hangingProvisioner) Provision(ui Ui, comm Communicator) error {
+	p.cmd = &RemoteCmd{Command: "sleep 3600"}
+	if err := comm.Start(p.cmd); err != nil {
+		return err
+	}
+
+	p.cmd.Wait()
+	return nil
+}
+
+// cancelCommunicator marks commands as exited once they're cancelled.
+type cancelCommunicator struct {
+	Communicator
+}
+
+func (c *cancelCommunicator) Start(cmd *RemoteCmd) error {
+	go func() {
+		<-cmd.Cancelled()
+		cmd.ExitStatus = CmdCancelled
+		cmd.Exited = true
+	}()
+
+	return nil
+}
+
+func TestTimeoutProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &TimeoutProvisioner{}
+	if _, ok := raw.(Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestTimeoutProvisioner(t *testing.T) {
+	p := new(TestProvisioner)
+	timeout := &TimeoutProvisioner{time.Minute, p}
+	if err := timeout.Provision(testUi(), new(cancelCommunicator)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !p.provCalled {
+		t.Fatal("should provision")
+	}
+}
+
+func TestTimeoutProvisioner_Timeout(t *testing.T) {
+	p := new(hangingProvisioner)
+	timeout := &TimeoutProvisioner{10 * time.Millisecond, p}
+	err := timeout.Provision(testUi(), new(cancelCommunicator))
+	if err == nil || err.Error() != "Provisioner timed out after 10ms" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if !p.cmd.Exited || p.cmd.ExitStatus != CmdCancelled {
+		t.Fatalf("command should be cancelled: %#v", p.cmd)
+	}
+}
+
+func TestTimeoutProvisioner_Stuck(t *testing.T) {
+	provisionerCancelGrace = 10 * time.Millisecond
+	defer func() { provisionerCancelGrace = 30 * time.Second }()
+
+	// The provisioner never returns, since it doesn't run a command that
+	// can be cancelled
+	stuck := make(chan struct{})
+	defer close(stuck)
+	p := &stuckProvisioner{stuck}
+
+	timeout := &TimeoutProvisioner{10 * time.Millisecond, p}
+	err := timeout.Provision(testUi(), new(cancelCommunicator))
+	if err == nil || err.Error() != "Provisioner timed out after 10ms" {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+// stuckProvisioner blocks until the channel is closed.
+type stuckProvisioner struct {
+	stuck chan struct{}
+}
+
+func (p *stuckProvisioner) Prepare(...interface{}) error {
+	return nil
+}
+
+func (p *stuckProvisioner) Provision(Ui, Communicator) error {
+	<-p.stuck
+	return nil
+}
+
 // TODO(mitchellh): Test that they're run in the proper order
+
+func TestTimedProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &TimedProvisioner{}
+	if _, ok := raw.(Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestTimedProvisioner(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &MachineReadableUi{Writer: out}
+	prov := &flakyProvisioner{failures: 1}
+	timed := &TimedProvisioner{"shell (provisioners[0])", prov}
+
+	if err := timed.Provision(ui, nil); err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Failed provisioners are timed as well
+	if !strings.Contains(out.String(), ",,timing,provisioner,shell (provisioners[0]),") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
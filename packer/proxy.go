@@ -0,0 +1,190 @@
+package packer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ProxyConfig is the HTTP proxy that downloads and the calls to the APIs
+// of clouds go through, from the "proxy" of a template. Settings that
+// aren't set are read from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, or their lowercase forms, so a nil ProxyConfig
+// uses the environment alone.
+type ProxyConfig struct {
+	// The proxy of "http" URLs, such as "http://proxy.example.com:3128".
+	HTTPProxy string `json:"http_proxy" mapstructure:"http_proxy"`
+
+	// The proxy of "https" URLs.
+	HTTPSProxy string `json:"https_proxy" mapstructure:"https_proxy"`
+
+	// The hosts that are connected to directly, separated by commas, such
+	// as "localhost,.example.com". A host matches the hosts it names and
+	// their subdomains, and "*" matches every host.
+	NoProxy string `json:"no_proxy" mapstructure:"no_proxy"`
+}
+
+// validate checks that the settings are valid templates, since they are
+// processed with the user variables of the template.
+func (c *ProxyConfig) validate() []error {
+	errors := make([]error, 0)
+	tpl := new(ConfigTemplate)
+	settings := []struct{ name, value string }{
+		{"http_proxy", c.HTTPProxy},
+		{"https_proxy", c.HTTPSProxy},
+		{"no_proxy", c.NoProxy},
+	}
+
+	for _, setting := range settings {
+		if err := tpl.Validate(setting.value); err != nil {
+			errors = append(errors, fmt.Errorf("proxy %s: %s", setting.name, err))
+		}
+	}
+
+	return errors
+}
+
+// process returns the configuration with its settings processed with the
+// template, and checks that the proxies are URLs.
+func (c *ProxyConfig) process(tpl *ConfigTemplate) (*ProxyConfig, error) {
+	result := new(ProxyConfig)
+	settings := []struct {
+		name   string
+		value  string
+		result *string
+	}{
+		{"http_proxy", c.HTTPProxy, &result.HTTPProxy},
+		{"https_proxy", c.HTTPSProxy, &result.HTTPSProxy},
+		{"no_proxy", c.NoProxy, &result.NoProxy},
+	}
+
+	for _, setting := range settings {
+		value, err := tpl.Process(setting.value, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error processing proxy %s: %s", setting.name, err)
+		}
+
+		*setting.result = value
+	}
+
+	for _, proxy := range []string{result.HTTPProxy, result.HTTPSProxy} {
+		if _, err := parseProxy(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// Proxy returns the URL of the proxy that the request goes through, or
+// nil if it is made directly. It can be the Proxy of an http.Transport.
+func (c *ProxyConfig) Proxy(req *http.Request) (*url.URL, error) {
+	var config ProxyConfig
+	if c != nil {
+		config = *c
+	}
+
+	if config.NoProxy == "" {
+		config.NoProxy = proxyEnv("NO_PROXY")
+	}
+
+	host := req.URL.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if !useProxy(strings.ToLower(host), config.NoProxy) {
+		return nil, nil
+	}
+
+	proxy := config.HTTPProxy
+	if proxy == "" {
+		proxy = proxyEnv("HTTP_PROXY")
+	}
+
+	if req.URL.Scheme == "https" {
+		proxy = config.HTTPSProxy
+		if proxy == "" {
+			proxy = proxyEnv("HTTPS_PROXY")
+		}
+	}
+
+	return parseProxy(proxy)
+}
+
+// Transport returns an HTTP transport whose requests go through the
+// proxy.
+func (c *ProxyConfig) Transport() *http.Transport {
+	return &http.Transport{Proxy: c.Proxy}
+}
+
+// Client returns an HTTP client whose requests go through the proxy.
+func (c *ProxyConfig) Client() *http.Client {
+	return &http.Client{Transport: c.Transport()}
+}
+
+// proxyEnv returns the environment variable with the name, or its
+// lowercase form.
+func proxyEnv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return os.Getenv(strings.ToLower(name))
+}
+
+// parseProxy parses the URL of a proxy, which is an "http" URL if it has
+// no scheme, such as "proxy.example.com:3128".
+func parseProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address '%s'", proxy)
+	}
+
+	return u, nil
+}
+
+// useProxy says whether requests to the host go through a proxy, which
+// they don't for the local machine or for hosts that are in noProxy.
+func useProxy(host, noProxy string) bool {
+	if host == "localhost" {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return false
+	}
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if entry == "*" {
+			return false
+		}
+
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return false
+		}
+	}
+
+	return true
+}
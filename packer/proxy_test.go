@@ -0,0 +1,103 @@
+package packer
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func testProxyRequest(t *testing.T, rawurl string) *http.Request {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return req
+}
+
+func TestProxyConfig_Proxy(t *testing.T) {
+	config := &ProxyConfig{
+		HTTPProxy:  "proxy.example.com:3128",
+		HTTPSProxy: "https://secure.example.com",
+		NoProxy:    "internal.example.com, .corp:8080",
+	}
+
+	cases := []struct {
+		url   string
+		proxy string
+	}{
+		{"http://releases.example.com/foo.iso", "http://proxy.example.com:3128"},
+		{"https://releases.example.com/foo.iso", "https://secure.example.com"},
+		{"http://internal.example.com/foo.iso", ""},
+		{"http://mirror.internal.example.com:8080/foo.iso", ""},
+		{"http://mirror.corp/foo.iso", ""},
+		{"http://notinternal.example.com/foo.iso", "http://proxy.example.com:3128"},
+		{"http://localhost:8080/foo.iso", ""},
+		{"http://127.0.0.1/foo.iso", ""},
+	}
+
+	for _, tc := range cases {
+		proxy, err := config.Proxy(testProxyRequest(t, tc.url))
+		if err != nil {
+			t.Fatalf("%s: %s", tc.url, err)
+		}
+
+		actual := ""
+		if proxy != nil {
+			actual = proxy.String()
+		}
+
+		if actual != tc.proxy {
+			t.Fatalf("%s: bad: %s", tc.url, actual)
+		}
+	}
+
+	config.NoProxy = "*"
+	proxy, err := config.Proxy(testProxyRequest(t, "http://releases.example.com"))
+	if err != nil || proxy != nil {
+		t.Fatalf("bad: %s %s", proxy, err)
+	}
+}
+
+func TestProxyConfig_ProxyEnvironment(t *testing.T) {
+	defer os.Setenv("HTTP_PROXY", os.Getenv("HTTP_PROXY"))
+	defer os.Setenv("http_proxy", os.Getenv("http_proxy"))
+	defer os.Setenv("NO_PROXY", os.Getenv("NO_PROXY"))
+	os.Setenv("HTTP_PROXY", "")
+	os.Setenv("http_proxy", "http://env.example.com:3128")
+	os.Setenv("NO_PROXY", "direct.example.com")
+
+	// A nil configuration uses the environment alone
+	var config *ProxyConfig
+	proxy, err := config.Proxy(testProxyRequest(t, "http://releases.example.com"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if proxy == nil || proxy.String() != "http://env.example.com:3128" {
+		t.Fatalf("bad: %s", proxy)
+	}
+
+	proxy, err = config.Proxy(testProxyRequest(t, "http://direct.example.com"))
+	if err != nil || proxy != nil {
+		t.Fatalf("bad: %s %s", proxy, err)
+	}
+
+	// The settings of the template come first
+	config = &ProxyConfig{HTTPProxy: "http://template.example.com", NoProxy: "other.example.com"}
+	proxy, err = config.Proxy(testProxyRequest(t, "http://direct.example.com"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if proxy == nil || proxy.String() != "http://template.example.com" {
+		t.Fatalf("bad: %s", proxy)
+	}
+}
+
+func TestProxyConfig_ProxyInvalid(t *testing.T) {
+	config := &ProxyConfig{HTTPProxy: "http://"}
+	if _, err := config.Proxy(testProxyRequest(t, "http://releases.example.com")); err == nil {
+		t.Fatal("should have error")
+	}
+}
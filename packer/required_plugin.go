@@ -0,0 +1,144 @@
+package packer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// RequiredPlugin is an external plugin that a template needs, by the name
+// of its binary in the "required_plugins" of the template, which "packer
+// init" installs into the plugins directory.
+type RequiredPlugin struct {
+	// The versions of the plugin that the template works with, such as
+	// ">= 0.2.0, < 0.3.0". See VersionSatisfies.
+	Version string
+
+	// Where the binary of a release is downloaded from, a URL or a local
+	// path, processed with ConfigTemplate and the Version, OS and Arch of
+	// the release, such as "https://example.com/{{.Version}}/{{.OS}}_{{.Arch}}".
+	Source string
+
+	// The SHA256 checksums of the releases that can be installed, by their
+	// version and the platform they are built for, such as
+	// "0.2.1_linux_amd64", or by their version alone for releases that
+	// run on every platform. Only these releases are installed, so that a
+	// template always gets the same binaries.
+	Checksums map[string]string
+}
+
+// requiredPluginData is what the source of a required plugin is
+// processed with.
+type requiredPluginData struct {
+	Version string
+	OS      string
+	Arch    string
+}
+
+// validate checks the version constraint, source and checksums of the
+// plugin.
+func (p *RequiredPlugin) validate() []error {
+	errors := make([]error, 0)
+	if _, err := parseVersionConstraint(p.Version); err != nil {
+		errors = append(errors, err)
+	}
+
+	if p.Source != "" {
+		if err := new(ConfigTemplate).Validate(p.Source); err != nil {
+			errors = append(errors, fmt.Errorf("source: %s", err))
+		}
+
+		if len(p.Checksums) == 0 {
+			errors = append(errors, fmt.Errorf("checksums are required to install from the source"))
+		}
+	}
+
+	for key, checksum := range p.Checksums {
+		parts := strings.SplitN(key, "_", 3)
+		if _, err := versionParts(parts[0]); err != nil || len(parts) == 2 {
+			errors = append(errors, fmt.Errorf(
+				"checksum '%s' isn't for a version, such as \"0.2.1\" or \"0.2.1_linux_amd64\"", key))
+			continue
+		}
+
+		if data, err := hex.DecodeString(checksum); err != nil || len(data) != 32 {
+			errors = append(errors, fmt.Errorf("checksum '%s' isn't a SHA256 checksum", key))
+		}
+	}
+
+	return errors
+}
+
+// Release returns the newest version of the plugin that satisfies its
+// version constraint and has a checksum for the platform, with the
+// checksum of its binary.
+func (p *RequiredPlugin) Release(goos, goarch string) (string, string, error) {
+	checksums := make(map[string]string)
+	for key, checksum := range p.Checksums {
+		parts := strings.SplitN(key, "_", 3)
+		switch {
+		case len(parts) == 1:
+			if _, ok := checksums[key]; !ok {
+				checksums[key] = checksum
+			}
+		case parts[1] == goos && parts[2] == goarch:
+			checksums[parts[0]] = checksum
+		}
+	}
+
+	versions := make([]string, 0, len(checksums))
+	for version := range checksums {
+		ok, err := VersionSatisfies(version, p.Version)
+		if err != nil {
+			return "", "", err
+		}
+
+		if ok {
+			versions = append(versions, version)
+		}
+	}
+
+	if len(versions) == 0 {
+		return "", "", fmt.Errorf(
+			"no release for %s_%s satisfies the version constraint '%s'", goos, goarch, p.Version)
+	}
+
+	sort.Sort(versionsByAge(versions))
+	version := versions[len(versions)-1]
+	return version, checksums[version], nil
+}
+
+// RequiredPluginSource returns the source that the given version of the
+// required plugin with the name is installed from, for the platform that
+// Packer is running on. User variables can be used in the source.
+func (t *Template) RequiredPluginSource(name, version string) (string, error) {
+	plugin, ok := t.RequiredPlugins[name]
+	if !ok {
+		return "", fmt.Errorf("No such required plugin in template: %s", name)
+	}
+
+	if plugin.Source == "" {
+		return "", fmt.Errorf("required plugin '%s' has no source to install from", name)
+	}
+
+	tpl := &ConfigTemplate{UserVars: t.Variables}
+	data := &requiredPluginData{Version: version, OS: runtime.GOOS, Arch: runtime.GOARCH}
+	source, err := tpl.Process(plugin.Source, data)
+	if err != nil {
+		return "", fmt.Errorf("Error processing source of required plugin '%s': %s", name, err)
+	}
+
+	return source, nil
+}
+
+// versionsByAge sorts valid versions from the oldest to the newest.
+type versionsByAge []string
+
+func (v versionsByAge) Len() int      { return len(v) }
+func (v versionsByAge) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v versionsByAge) Less(i, j int) bool {
+	result, _ := CompareVersions(v[i], v[j])
+	return result < 0
+}
@@ -0,0 +1,103 @@
+package packer
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRequiredPlugin_Release(t *testing.T) {
+	p := &RequiredPlugin{
+		Version: ">= 0.2.0, < 0.3.0",
+		Checksums: map[string]string{
+			"0.1.0":              "a",
+			"0.2.0":              "b",
+			"0.2.1_linux_amd64":  "c",
+			"0.2.1_darwin_amd64": "d",
+			"0.2.10":             "e",
+			"0.2.10_linux_amd64": "f",
+			"0.3.0":              "g",
+		},
+	}
+
+	cases := []struct {
+		goos, goarch string
+		version      string
+		checksum     string
+	}{
+		{"linux", "amd64", "0.2.10", "f"},
+		{"darwin", "amd64", "0.2.10", "e"},
+		{"windows", "386", "0.2.10", "e"},
+	}
+
+	for _, tc := range cases {
+		version, checksum, err := p.Release(tc.goos, tc.goarch)
+		if err != nil {
+			t.Fatalf("%s_%s: %s", tc.goos, tc.goarch, err)
+		}
+
+		if version != tc.version || checksum != tc.checksum {
+			t.Fatalf("%s_%s: bad: %s %s", tc.goos, tc.goarch, version, checksum)
+		}
+	}
+
+	p.Version = "~> 0.2.1"
+	delete(p.Checksums, "0.2.10")
+	delete(p.Checksums, "0.2.10_linux_amd64")
+	version, checksum, err := p.Release("darwin", "amd64")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if version != "0.2.1" || checksum != "d" {
+		t.Fatalf("bad: %s %s", version, checksum)
+	}
+
+	_, _, err = p.Release("windows", "386")
+	if err == nil || !strings.Contains(err.Error(), "no release for windows_386") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestTemplate_RequiredPluginSource(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"mirror": "https://example.com"
+		},
+		"required_plugins": {
+			"packer-builder-custom-cloud": {
+				"source": "{{user \"mirror\"}}/{{.Version}}/{{.OS}}_{{.Arch}}/packer-builder-custom-cloud",
+				"checksums": {
+					"0.2.1": "2b8c2e7a6e5bfc52cbd8e1b8ceb1d5b9b6f8f47e8b6e62a2a0b0a477d3fce91f"
+				}
+			},
+			"packer-provisioner-local": {}
+		},
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	tpl, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	source, err := tpl.RequiredPluginSource("packer-builder-custom-cloud", "0.2.1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "https://example.com/0.2.1/" + runtime.GOOS + "_" + runtime.GOARCH + "/packer-builder-custom-cloud"
+	if source != expected {
+		t.Fatalf("bad: %s", source)
+	}
+
+	if _, err := tpl.RequiredPluginSource("packer-provisioner-local", "0.1.0"); err == nil {
+		t.Fatal("should have error without a source")
+	}
+
+	if _, err := tpl.RequiredPluginSource("packer-builder-other", "0.1.0"); err == nil {
+		t.Fatal("should have error for an unknown plugin")
+	}
+}
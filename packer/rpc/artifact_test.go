@@ -41,7 +41,7 @@ func TestArtifactRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	assert.Nil(err, "should be able to connect")
 	aClient := Artifact(client)
 
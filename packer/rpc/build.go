@@ -3,6 +3,7 @@ package rpc
 import (
 	"github.com/mitchellh/packer/packer"
 	"net/rpc"
+	"time"
 )
 
 // An implementation of packer.Build where the build is actually executed
@@ -52,7 +53,7 @@ func (b *build) Run(ui packer.Ui, cache packer.Cache) ([]packer.Artifact, error)
 
 	artifacts := make([]packer.Artifact, len(result))
 	for i, addr := range result {
-		client, err := rpc.Dial("tcp", addr)
+		client, err := Dial(addr)
 		if err != nil {
 			return nil, err
 		}
@@ -69,6 +70,36 @@ func (b *build) SetDebug(val bool) {
 	}
 }
 
+func (b *build) SetStrict(val bool) {
+	if err := b.client.Call("Build.SetStrict", val, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
+func (b *build) SetForce(val bool) {
+	if err := b.client.Call("Build.SetForce", val, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
+func (b *build) SetResume(val bool) {
+	if err := b.client.Call("Build.SetResume", val, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
+func (b *build) SetOnError(val string) {
+	if err := b.client.Call("Build.SetOnError", val, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
+func (b *build) SetTimeout(val time.Duration) {
+	if err := b.client.Call("Build.SetTimeout", val, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
 func (b *build) Cancel() {
 	if err := b.client.Call("Build.Cancel", new(interface{}), new(interface{})); err != nil {
 		panic(err)
@@ -81,12 +112,16 @@ func (b *BuildServer) Name(args *interface{}, reply *string) error {
 }
 
 func (b *BuildServer) Prepare(args interface{}, reply *error) error {
-	*reply = b.build.Prepare()
+	err := b.build.Prepare()
+	if err != nil {
+		*reply = NewConfigError(err)
+	}
+
 	return nil
 }
 
 func (b *BuildServer) Run(args *BuildRunArgs, reply *[]string) error {
-	client, err := rpc.Dial("tcp", args.UiRPCAddress)
+	client, err := Dial(args.UiRPCAddress)
 	if err != nil {
 		return err
 	}
@@ -111,6 +146,31 @@ func (b *BuildServer) SetDebug(val *bool, reply *interface{}) error {
 	return nil
 }
 
+func (b *BuildServer) SetStrict(val *bool, reply *interface{}) error {
+	b.build.SetStrict(*val)
+	return nil
+}
+
+func (b *BuildServer) SetForce(val *bool, reply *interface{}) error {
+	b.build.SetForce(*val)
+	return nil
+}
+
+func (b *BuildServer) SetResume(val *bool, reply *interface{}) error {
+	b.build.SetResume(*val)
+	return nil
+}
+
+func (b *BuildServer) SetOnError(val *string, reply *interface{}) error {
+	b.build.SetOnError(*val)
+	return nil
+}
+
+func (b *BuildServer) SetTimeout(val *time.Duration, reply *interface{}) error {
+	b.build.SetTimeout(*val)
+	return nil
+}
+
 func (b *BuildServer) Cancel(args *interface{}, reply *interface{}) error {
 	b.build.Cancel()
 	return nil
@@ -6,18 +6,24 @@ import (
 	"github.com/mitchellh/packer/packer"
 	"net/rpc"
 	"testing"
+	"time"
 )
 
 var testBuildArtifact = &testArtifact{}
 
 type testBuild struct {
-	nameCalled     bool
-	prepareCalled  bool
-	runCalled      bool
-	runCache       packer.Cache
-	runUi          packer.Ui
-	setDebugCalled bool
-	cancelCalled   bool
+	nameCalled      bool
+	prepareCalled   bool
+	runCalled       bool
+	runCache        packer.Cache
+	runUi           packer.Ui
+	setDebugCalled  bool
+	setStrictCalled bool
+	setForceCalled  bool
+	setResumeCalled bool
+	onError         string
+	timeout         time.Duration
+	cancelCalled    bool
 
 	errRunResult bool
 }
@@ -48,6 +54,26 @@ func (b *testBuild) SetDebug(bool) {
 	b.setDebugCalled = true
 }
 
+func (b *testBuild) SetStrict(bool) {
+	b.setStrictCalled = true
+}
+
+func (b *testBuild) SetForce(bool) {
+	b.setForceCalled = true
+}
+
+func (b *testBuild) SetResume(bool) {
+	b.setResumeCalled = true
+}
+
+func (b *testBuild) SetOnError(val string) {
+	b.onError = val
+}
+
+func (b *testBuild) SetTimeout(val time.Duration) {
+	b.timeout = val
+}
+
 func (b *testBuild) Cancel() {
 	b.cancelCalled = true
 }
@@ -64,7 +90,7 @@ func TestBuildRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	assert.Nil(err, "should be able to connect")
 	bClient := Build(client)
 
@@ -104,6 +130,26 @@ func TestBuildRPC(t *testing.T) {
 	bClient.SetDebug(true)
 	assert.True(b.setDebugCalled, "should be called")
 
+	// Test SetStrict
+	bClient.SetStrict(true)
+	assert.True(b.setStrictCalled, "should be called")
+
+	// Test SetForce
+	bClient.SetForce(true)
+	assert.True(b.setForceCalled, "should be called")
+
+	// Test SetResume
+	bClient.SetResume(true)
+	assert.True(b.setResumeCalled, "should be called")
+
+	// Test SetOnError
+	bClient.SetOnError("abort")
+	assert.Equal(b.onError, "abort", "should be called")
+
+	// Test SetTimeout
+	bClient.SetTimeout(time.Hour)
+	assert.Equal(b.timeout, time.Hour, "should be called")
+
 	// Test Cancel
 	bClient.Cancel()
 	assert.True(b.cancelCalled, "cancel should be called")
@@ -4,7 +4,6 @@ import (
 	"encoding/gob"
 	"github.com/mitchellh/packer/packer"
 	"log"
-	"net"
 	"net/rpc"
 )
 
@@ -55,7 +54,7 @@ func (b *builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	RegisterUi(server, ui)
 
 	// Create a server for the response
-	responseL := netListenerInRange(portRangeMin, portRangeMax)
+	responseL := newListener()
 	runResponseCh := make(chan *BuilderRunResponse)
 	go func() {
 		defer responseL.Close()
@@ -94,7 +93,7 @@ func (b *builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		return nil, nil
 	}
 
-	client, err := rpc.Dial("tcp", response.RPCAddress)
+	client, err := Dial(response.RPCAddress)
 	if err != nil {
 		return nil, err
 	}
@@ -111,19 +110,19 @@ func (b *builder) Cancel() {
 func (b *BuilderServer) Prepare(args *BuilderPrepareArgs, reply *error) error {
 	err := b.builder.Prepare(args.Configs...)
 	if err != nil {
-		*reply = NewBasicError(err)
+		*reply = NewConfigError(err)
 	}
 
 	return nil
 }
 
 func (b *BuilderServer) Run(args *BuilderRunArgs, reply *interface{}) error {
-	client, err := rpc.Dial("tcp", args.RPCAddress)
+	client, err := Dial(args.RPCAddress)
 	if err != nil {
 		return err
 	}
 
-	responseC, err := net.Dial("tcp", args.ResponseAddress)
+	responseC, err := dial(args.ResponseAddress)
 	if err != nil {
 		return err
 	}
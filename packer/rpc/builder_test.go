@@ -19,6 +19,7 @@ type testBuilder struct {
 	runUi         packer.Ui
 	cancelCalled  bool
 
+	prepareErr   error
 	errRunResult bool
 	nilRunResult bool
 }
@@ -26,7 +27,7 @@ type testBuilder struct {
 func (b *testBuilder) Prepare(config ...interface{}) error {
 	b.prepareCalled = true
 	b.prepareConfig = config
-	return nil
+	return b.prepareErr
 }
 
 func (b *testBuilder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
@@ -60,7 +61,7 @@ func TestBuilderRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	assert.Nil(err, "should be able to connect")
 
 	// Test Prepare
@@ -110,6 +111,27 @@ func TestBuilderRPC(t *testing.T) {
 	assert.True(b.cancelCalled, "cancel should be called")
 }
 
+func TestBuilderRPC_prepareConfigError(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	keyErr := &packer.ConfigKeyError{Key: "foo", Message: "bad foo"}
+	b := new(testBuilder)
+	b.prepareErr = &packer.MultiError{[]error{keyErr, errors.New("bar")}}
+
+	server := rpc.NewServer()
+	RegisterBuilder(server, b)
+	client, err := Dial(serveSingleConn(server))
+	assert.Nil(err, "should be able to connect")
+
+	err = Builder(client).Prepare(42)
+	merr, ok := err.(*packer.MultiError)
+	assert.True(ok, "should be a MultiError")
+	if ok {
+		assert.Equal(merr.Errors[0], keyErr, "should keep the key error")
+		assert.Equal(merr.Errors[1].Error(), "bar", "should have the other error")
+	}
+}
+
 func TestBuilder_ImplementsBuilder(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
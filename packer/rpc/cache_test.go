@@ -60,7 +60,7 @@ func TestCacheRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	rpcClient, err := rpc.Dial("tcp", address)
+	rpcClient, err := Dial(address)
 	assert.Nil(err, "should be able to connect")
 	client := Cache(rpcClient)
 
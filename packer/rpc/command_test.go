@@ -41,7 +41,7 @@ func TestRPCCommand(t *testing.T) {
 
 	// Create the command client over RPC and run some methods to verify
 	// we get the proper behavior.
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	assert.Nil(err, "should be no error")
 
 	clientComm := Command(client)
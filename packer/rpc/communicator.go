@@ -5,9 +5,11 @@ import (
 	"errors"
 	"github.com/mitchellh/packer/packer"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/rpc"
+	"os"
 	"time"
 )
 
@@ -25,10 +27,17 @@ type CommunicatorServer struct {
 
 type CommandFinished struct {
 	ExitStatus int
+	ExitSignal string
+	Err        *BasicError
 }
 
+// CommandCancel is sent from the client to the server over the response
+// connection of a command to cancel it.
+type CommandCancel struct{}
+
 type CommunicatorStartArgs struct {
 	Command         string
+	Env             []string
 	StdinAddress    string
 	StdoutAddress   string
 	StderrAddress   string
@@ -43,8 +52,43 @@ type CommunicatorDownloadArgs struct {
 type CommunicatorUploadArgs struct {
 	Path          string
 	ReaderAddress string
+	FileInfo      *fileInfo
+}
+
+type CommunicatorUploadDirArgs struct {
+	Dst     string
+	Src     string
+	Exclude []string
+}
+
+type CommunicatorDownloadDirArgs struct {
+	Src     string
+	Dst     string
+	Exclude []string
 }
 
+type CommunicatorForwardArgs struct {
+	Local          string
+	Remote         string
+	ControlAddress string
+}
+
+// fileInfo is an implementation of os.FileInfo that can be gob encoded
+// so that information about an upload can be sent across the wire.
+type fileInfo struct {
+	NameValue    string
+	SizeValue    int64
+	ModeValue    os.FileMode
+	ModTimeValue time.Time
+}
+
+func (f *fileInfo) Name() string       { return f.NameValue }
+func (f *fileInfo) Size() int64        { return f.SizeValue }
+func (f *fileInfo) Mode() os.FileMode  { return f.ModeValue }
+func (f *fileInfo) ModTime() time.Time { return f.ModTimeValue }
+func (f *fileInfo) IsDir() bool        { return f.ModeValue.IsDir() }
+func (f *fileInfo) Sys() interface{}   { return nil }
+
 func Communicator(client *rpc.Client) *communicator {
 	return &communicator{client}
 }
@@ -52,26 +96,27 @@ func Communicator(client *rpc.Client) *communicator {
 func (c *communicator) Start(cmd *packer.RemoteCmd) (err error) {
 	var args CommunicatorStartArgs
 	args.Command = cmd.Command
+	args.Env = cmd.Env
 
 	if cmd.Stdin != nil {
-		stdinL := netListenerInRange(portRangeMin, portRangeMax)
+		stdinL := newListener()
 		args.StdinAddress = stdinL.Addr().String()
 		go serveSingleCopy("stdin", stdinL, nil, cmd.Stdin)
 	}
 
 	if cmd.Stdout != nil {
-		stdoutL := netListenerInRange(portRangeMin, portRangeMax)
+		stdoutL := newListener()
 		args.StdoutAddress = stdoutL.Addr().String()
 		go serveSingleCopy("stdout", stdoutL, cmd.Stdout, nil)
 	}
 
 	if cmd.Stderr != nil {
-		stderrL := netListenerInRange(portRangeMin, portRangeMax)
+		stderrL := newListener()
 		args.StderrAddress = stderrL.Addr().String()
 		go serveSingleCopy("stderr", stderrL, cmd.Stderr, nil)
 	}
 
-	responseL := netListenerInRange(portRangeMin, portRangeMax)
+	responseL := newListener()
 	args.ResponseAddress = responseL.Addr().String()
 
 	go func() {
@@ -84,6 +129,17 @@ func (c *communicator) Start(cmd *packer.RemoteCmd) (err error) {
 
 		defer conn.Close()
 
+		// Tell the server if the command is cancelled while we wait
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-cmd.Cancelled():
+				gob.NewEncoder(conn).Encode(&CommandCancel{})
+			case <-done:
+			}
+		}()
+
 		decoder := gob.NewDecoder(conn)
 
 		var finished CommandFinished
@@ -92,6 +148,11 @@ func (c *communicator) Start(cmd *packer.RemoteCmd) (err error) {
 		}
 
 		cmd.ExitStatus = finished.ExitStatus
+		cmd.ExitSignal = finished.ExitSignal
+		if finished.Err != nil {
+			cmd.Err = finished.Err
+		}
+
 		cmd.Exited = true
 	}()
 
@@ -99,10 +160,10 @@ func (c *communicator) Start(cmd *packer.RemoteCmd) (err error) {
 	return
 }
 
-func (c *communicator) Upload(path string, r io.Reader) (err error) {
+func (c *communicator) Upload(path string, r io.Reader, fi *os.FileInfo) (err error) {
 	// We need to create a server that can proxy the reader data
 	// over because we can't simply gob encode an io.Reader
-	readerL := netListenerInRange(portRangeMin, portRangeMax)
+	readerL := newListener()
 	if readerL == nil {
 		err = errors.New("couldn't allocate listener for upload reader")
 		return
@@ -115,18 +176,37 @@ func (c *communicator) Upload(path string, r io.Reader) (err error) {
 	go serveSingleCopy("uploadReader", readerL, nil, r)
 
 	args := CommunicatorUploadArgs{
-		path,
-		readerL.Addr().String(),
+		Path:          path,
+		ReaderAddress: readerL.Addr().String(),
+	}
+
+	if fi != nil {
+		args.FileInfo = &fileInfo{
+			NameValue:    (*fi).Name(),
+			SizeValue:    (*fi).Size(),
+			ModeValue:    (*fi).Mode(),
+			ModTimeValue: (*fi).ModTime(),
+		}
 	}
 
 	err = c.client.Call("Communicator.Upload", &args, new(interface{}))
 	return
 }
 
+func (c *communicator) UploadDir(dst string, src string, exclude []string) error {
+	args := &CommunicatorUploadDirArgs{
+		Dst:     dst,
+		Src:     src,
+		Exclude: exclude,
+	}
+
+	return c.client.Call("Communicator.UploadDir", args, new(interface{}))
+}
+
 func (c *communicator) Download(path string, w io.Writer) (err error) {
 	// We need to create a server that can proxy that data downloaded
 	// into the writer because we can't gob encode a writer directly.
-	writerL := netListenerInRange(portRangeMin, portRangeMax)
+	writerL := newListener()
 	if writerL == nil {
 		err = errors.New("couldn't allocate listener for download writer")
 		return
@@ -147,14 +227,58 @@ func (c *communicator) Download(path string, w io.Writer) (err error) {
 	return
 }
 
+func (c *communicator) DownloadDir(src string, dst string, exclude []string) error {
+	args := &CommunicatorDownloadDirArgs{
+		Src:     src,
+		Dst:     dst,
+		Exclude: exclude,
+	}
+
+	return c.client.Call("Communicator.DownloadDir", args, new(interface{}))
+}
+
+func (c *communicator) ForwardLocal(local string, remote string) (io.Closer, error) {
+	return c.forward("Communicator.ForwardLocal", local, remote)
+}
+
+func (c *communicator) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return c.forward("Communicator.ForwardRemote", local, remote)
+}
+
+// forward starts a forward on the server side. The server connects back
+// to a control connection and stops the forward once it is closed, so the
+// returned io.Closer is that connection.
+func (c *communicator) forward(method string, local string, remote string) (io.Closer, error) {
+	controlL := newListener()
+	if controlL == nil {
+		return nil, errors.New("couldn't allocate listener for forward")
+	}
+
+	defer controlL.Close()
+
+	args := CommunicatorForwardArgs{
+		Local:          local,
+		Remote:         remote,
+		ControlAddress: controlL.Addr().String(),
+	}
+
+	if err := c.client.Call(method, &args, new(interface{})); err != nil {
+		return nil, err
+	}
+
+	// The server has already connected by the time the call returns
+	return controlL.Accept()
+}
+
 func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface{}) (err error) {
 	// Build the RemoteCmd on this side so that it all pipes over
 	// to the remote side.
 	var cmd packer.RemoteCmd
 	cmd.Command = args.Command
+	cmd.Env = args.Env
 
 	if args.StdinAddress != "" {
-		stdinC, err := net.Dial("tcp", args.StdinAddress)
+		stdinC, err := dial(args.StdinAddress)
 		if err != nil {
 			return err
 		}
@@ -163,7 +287,7 @@ func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface
 	}
 
 	if args.StdoutAddress != "" {
-		stdoutC, err := net.Dial("tcp", args.StdoutAddress)
+		stdoutC, err := dial(args.StdoutAddress)
 		if err != nil {
 			return err
 		}
@@ -172,7 +296,7 @@ func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface
 	}
 
 	if args.StderrAddress != "" {
-		stderrC, err := net.Dial("tcp", args.StderrAddress)
+		stderrC, err := dial(args.StderrAddress)
 		if err != nil {
 			return err
 		}
@@ -182,7 +306,7 @@ func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface
 
 	// Connect to the response address so we can write our result to it
 	// when ready.
-	responseC, err := net.Dial("tcp", args.ResponseAddress)
+	responseC, err := dial(args.ResponseAddress)
 	if err != nil {
 		return err
 	}
@@ -192,6 +316,17 @@ func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface
 	// Start the actual command
 	err = c.c.Start(&cmd)
 
+	// Start a goroutine to cancel the command if the client asks us
+	// to. This ends once the connection is closed after the command
+	// has finished.
+	go func() {
+		var cancel CommandCancel
+		if err := gob.NewDecoder(responseC).Decode(&cancel); err == nil {
+			log.Printf("[DEBUG] cancelling remote command: %s", cmd.Command)
+			cmd.Cancel()
+		}
+	}()
+
 	// Start a goroutine to spin and wait for the process to actual
 	// exit. When it does, report it back to caller...
 	go func() {
@@ -201,26 +336,45 @@ func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface
 			time.Sleep(50 * time.Millisecond)
 		}
 
-		responseWriter.Encode(&CommandFinished{cmd.ExitStatus})
+		finished := &CommandFinished{
+			ExitStatus: cmd.ExitStatus,
+			ExitSignal: cmd.ExitSignal,
+		}
+
+		if cmd.Err != nil {
+			finished.Err = NewBasicError(cmd.Err)
+		}
+
+		responseWriter.Encode(finished)
 	}()
 
 	return
 }
 
 func (c *CommunicatorServer) Upload(args *CommunicatorUploadArgs, reply *interface{}) (err error) {
-	readerC, err := net.Dial("tcp", args.ReaderAddress)
+	readerC, err := dial(args.ReaderAddress)
 	if err != nil {
 		return
 	}
 
 	defer readerC.Close()
 
-	err = c.c.Upload(args.Path, readerC)
+	var fi *os.FileInfo
+	if args.FileInfo != nil {
+		var realFi os.FileInfo = args.FileInfo
+		fi = &realFi
+	}
+
+	err = c.c.Upload(args.Path, readerC, fi)
 	return
 }
 
+func (c *CommunicatorServer) UploadDir(args *CommunicatorUploadDirArgs, reply *interface{}) error {
+	return c.c.UploadDir(args.Dst, args.Src, args.Exclude)
+}
+
 func (c *CommunicatorServer) Download(args *CommunicatorDownloadArgs, reply *interface{}) (err error) {
-	writerC, err := net.Dial("tcp", args.WriterAddress)
+	writerC, err := dial(args.WriterAddress)
 	if err != nil {
 		return
 	}
@@ -231,12 +385,54 @@ func (c *CommunicatorServer) Download(args *CommunicatorDownloadArgs, reply *int
 	return
 }
 
+func (c *CommunicatorServer) DownloadDir(args *CommunicatorDownloadDirArgs, reply *interface{}) error {
+	return c.c.DownloadDir(args.Src, args.Dst, args.Exclude)
+}
+
+func (c *CommunicatorServer) ForwardLocal(args *CommunicatorForwardArgs, reply *interface{}) error {
+	forward, err := c.c.ForwardLocal(args.Local, args.Remote)
+	if err != nil {
+		return err
+	}
+
+	return c.forward(args, forward)
+}
+
+func (c *CommunicatorServer) ForwardRemote(args *CommunicatorForwardArgs, reply *interface{}) error {
+	forward, err := c.c.ForwardRemote(args.Remote, args.Local)
+	if err != nil {
+		return err
+	}
+
+	return c.forward(args, forward)
+}
+
+// forward connects to the control connection of the client, closing the
+// forward once that connection is closed.
+func (c *CommunicatorServer) forward(args *CommunicatorForwardArgs, forward io.Closer) error {
+	controlC, err := dial(args.ControlAddress)
+	if err != nil {
+		forward.Close()
+		return err
+	}
+
+	go func() {
+		defer forward.Close()
+		defer controlC.Close()
+
+		// Nothing is ever sent, so this returns once the client closes it
+		io.Copy(ioutil.Discard, controlC)
+	}()
+
+	return nil
+}
+
 func serveSingleCopy(name string, l net.Listener, dst io.Writer, src io.Reader) {
 	defer l.Close()
 
 	conn, err := l.Accept()
 	if err != nil {
-		log.Printf("'%s' accept error: %s", name, err)
+		log.Printf("[ERROR] '%s' accept error: %s", name, err)
 		return
 	}
 
@@ -252,8 +448,8 @@ func serveSingleCopy(name string, l net.Listener, dst io.Writer, src io.Reader)
 	}
 
 	written, err := io.Copy(dst, src)
-	log.Printf("%d bytes written for '%s'", written, name)
+	log.Printf("[TRACE] %d bytes written for '%s'", written, name)
 	if err != nil {
-		log.Printf("'%s' copy error: %s", name, err)
+		log.Printf("[ERROR] '%s' copy error: %s", name, err)
 	}
 }
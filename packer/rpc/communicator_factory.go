@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"github.com/mitchellh/packer/packer"
+	"net/rpc"
+)
+
+// An implementation of packer.CommunicatorFactory where the factory is
+// actually executed over an RPC connection.
+type communicatorFactory struct {
+	client *rpc.Client
+}
+
+// CommunicatorFactoryServer wraps a packer.CommunicatorFactory
+// implementation and makes it exportable as part of a Golang RPC server.
+type CommunicatorFactoryServer struct {
+	f packer.CommunicatorFactory
+}
+
+type CommunicatorFactoryPrepareArgs struct {
+	Configs []interface{}
+}
+
+func CommunicatorFactory(client *rpc.Client) *communicatorFactory {
+	return &communicatorFactory{client}
+}
+
+func (f *communicatorFactory) Prepare(configs ...interface{}) (err error) {
+	args := &CommunicatorFactoryPrepareArgs{configs}
+	if cerr := f.client.Call("CommunicatorFactory.Prepare", args, &err); cerr != nil {
+		err = cerr
+	}
+
+	return
+}
+
+func (f *communicatorFactory) New(info map[string]string) (packer.Communicator, error) {
+	var address string
+	if err := f.client.Call("CommunicatorFactory.New", info, &address); err != nil {
+		return nil, err
+	}
+
+	client, err := Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return Communicator(client), nil
+}
+
+func (f *CommunicatorFactoryServer) Prepare(args *CommunicatorFactoryPrepareArgs, reply *error) error {
+	*reply = f.f.Prepare(args.Configs...)
+	if *reply != nil {
+		*reply = NewBasicError(*reply)
+	}
+
+	return nil
+}
+
+func (f *CommunicatorFactoryServer) New(info map[string]string, reply *string) error {
+	comm, err := f.f.New(info)
+	if err != nil {
+		return NewBasicError(err)
+	}
+
+	server := rpc.NewServer()
+	RegisterCommunicator(server, comm)
+	*reply = serveSingleConn(server)
+	return nil
+}
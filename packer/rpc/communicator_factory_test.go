@@ -0,0 +1,84 @@
+package rpc
+
+import (
+	"cgl.tideland.biz/asserts"
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"net/rpc"
+	"testing"
+)
+
+type testCommunicatorFactory struct {
+	prepareCalled  bool
+	prepareConfigs []interface{}
+	newCalled      bool
+	newInfo        map[string]string
+	newErr         error
+	comm           *testCommunicator
+}
+
+func (f *testCommunicatorFactory) Prepare(configs ...interface{}) error {
+	f.prepareCalled = true
+	f.prepareConfigs = configs
+	return nil
+}
+
+func (f *testCommunicatorFactory) New(info map[string]string) (packer.Communicator, error) {
+	f.newCalled = true
+	f.newInfo = info
+	if f.newErr != nil {
+		return nil, f.newErr
+	}
+
+	return f.comm, nil
+}
+
+func TestCommunicatorFactoryRPC(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	// Create the interface to test
+	f := &testCommunicatorFactory{comm: new(testCommunicator)}
+
+	// Start the server
+	server := rpc.NewServer()
+	RegisterCommunicatorFactory(server, f)
+	address := serveSingleConn(server)
+
+	// Create the client over RPC and run some methods to verify it works
+	client, err := Dial(address)
+	assert.Nil(err, "should be able to connect")
+
+	// Test Prepare
+	fClient := CommunicatorFactory(client)
+	err = fClient.Prepare(42)
+	assert.Nil(err, "should not error")
+	assert.True(f.prepareCalled, "prepare should be called")
+	assert.Equal(f.prepareConfigs, []interface{}{42}, "prepare should be called with right arg")
+
+	// Test New, and that the communicator works
+	info := map[string]string{"host": "127.0.0.1", "port": "22"}
+	comm, err := fClient.New(info)
+	assert.Nil(err, "should not error")
+	assert.True(f.newCalled, "new should be called")
+	assert.Equal(f.newInfo, info, "new should be called with right info")
+
+	err = comm.Start(&packer.RemoteCmd{Command: "foo"})
+	assert.Nil(err, "should not error")
+	assert.True(f.comm.startCalled, "start should be called")
+	assert.Equal(f.comm.startCmd.Command, "foo", "should have the proper command")
+
+	// Test that errors from New come back
+	f.newErr = errors.New("not reachable")
+	_, err = fClient.New(info)
+	assert.NotNil(err, "should error")
+	assert.Equal(err.Error(), "not reachable", "should have the proper error")
+}
+
+func TestCommunicatorFactory_ImplementsCommunicatorFactory(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	var r packer.CommunicatorFactory
+	f := CommunicatorFactory(nil)
+
+	assert.Implementor(f, &r, "should be a CommunicatorFactory")
+}
@@ -3,9 +3,11 @@ package rpc
 import (
 	"bufio"
 	"cgl.tideland.biz/asserts"
+	"errors"
 	"github.com/mitchellh/packer/packer"
 	"io"
 	"net/rpc"
+	"os"
 	"testing"
 	"time"
 )
@@ -17,9 +19,33 @@ type testCommunicator struct {
 	uploadCalled bool
 	uploadPath   string
 	uploadData   string
+	uploadInfo   *os.FileInfo
+
+	uploadDirCalled  bool
+	uploadDirDst     string
+	uploadDirSrc     string
+	uploadDirExclude []string
 
 	downloadCalled bool
 	downloadPath   string
+
+	downloadDirCalled  bool
+	downloadDirSrc     string
+	downloadDirDst     string
+	downloadDirExclude []string
+
+	forwardLocal  string
+	forwardRemote string
+	forwardCloser *testForwardCloser
+}
+
+type testForwardCloser struct {
+	closed chan struct{}
+}
+
+func (c *testForwardCloser) Close() error {
+	close(c.closed)
+	return nil
 }
 
 func (t *testCommunicator) Start(cmd *packer.RemoteCmd) error {
@@ -28,13 +54,22 @@ func (t *testCommunicator) Start(cmd *packer.RemoteCmd) error {
 	return nil
 }
 
-func (t *testCommunicator) Upload(path string, reader io.Reader) (err error) {
+func (t *testCommunicator) Upload(path string, reader io.Reader, fi *os.FileInfo) (err error) {
 	t.uploadCalled = true
 	t.uploadPath = path
+	t.uploadInfo = fi
 	t.uploadData, err = bufio.NewReader(reader).ReadString('\n')
 	return
 }
 
+func (t *testCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	t.uploadDirCalled = true
+	t.uploadDirDst = dst
+	t.uploadDirSrc = src
+	t.uploadDirExclude = exclude
+	return nil
+}
+
 func (t *testCommunicator) Download(path string, writer io.Writer) error {
 	t.downloadCalled = true
 	t.downloadPath = path
@@ -43,6 +78,25 @@ func (t *testCommunicator) Download(path string, writer io.Writer) error {
 	return nil
 }
 
+func (t *testCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	t.downloadDirCalled = true
+	t.downloadDirSrc = src
+	t.downloadDirDst = dst
+	t.downloadDirExclude = exclude
+	return nil
+}
+
+func (t *testCommunicator) ForwardLocal(local string, remote string) (io.Closer, error) {
+	t.forwardLocal = local
+	t.forwardRemote = remote
+	t.forwardCloser = &testForwardCloser{make(chan struct{})}
+	return t.forwardCloser, nil
+}
+
+func (t *testCommunicator) ForwardRemote(remote string, local string) (io.Closer, error) {
+	return t.ForwardLocal(local, remote)
+}
+
 func TestCommunicatorRPC(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -55,7 +109,7 @@ func TestCommunicatorRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	assert.Nil(err, "should be able to connect")
 	remote := Communicator(client)
 
@@ -66,6 +120,7 @@ func TestCommunicatorRPC(t *testing.T) {
 
 	var cmd packer.RemoteCmd
 	cmd.Command = "foo"
+	cmd.Env = []string{"FOO=bar"}
 	cmd.Stdin = stdin_r
 	cmd.Stdout = stdout_w
 	cmd.Stderr = stderr_w
@@ -73,6 +128,8 @@ func TestCommunicatorRPC(t *testing.T) {
 	// Test Start
 	err = remote.Start(&cmd)
 	assert.Nil(err, "should not have an error")
+	assert.Equal(c.startCmd.Command, "foo", "should have the command")
+	assert.Equal(c.startCmd.Env, []string{"FOO=bar"}, "should have the env")
 
 	// Test that we can read from stdout
 	c.startCmd.Stdout.Write([]byte("outfoo\n"))
@@ -95,13 +152,26 @@ func TestCommunicatorRPC(t *testing.T) {
 	assert.Nil(err, "should have no problem reading stdin")
 	assert.Equal(data, "infoo\n", "should be correct stdin")
 
+	// Test that cancelling the command reaches the remote side
+	cmd.Cancel()
+	select {
+	case <-c.startCmd.Cancelled():
+		// Success
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("remote command should be cancelled")
+	}
+
 	// Test that we can get the exit status properly
 	c.startCmd.ExitStatus = 42
+	c.startCmd.ExitSignal = "TERM"
+	c.startCmd.Err = errors.New("connection lost")
 	c.startCmd.Exited = true
 
 	for i := 0; i < 5; i++ {
 		if cmd.Exited {
 			assert.Equal(cmd.ExitStatus, 42, "should have proper exit status")
+			assert.Equal(cmd.ExitSignal, "TERM", "should have proper exit signal")
+			assert.Equal(cmd.Err.Error(), "connection lost", "should have proper error")
 			break
 		}
 
@@ -113,11 +183,30 @@ func TestCommunicatorRPC(t *testing.T) {
 	// Test that we can upload things
 	uploadR, uploadW := io.Pipe()
 	go uploadW.Write([]byte("uploadfoo\n"))
-	err = remote.Upload("foo", uploadR)
+	err = remote.Upload("foo", uploadR, nil)
 	assert.Nil(err, "should not error")
 	assert.True(c.uploadCalled, "should be called")
 	assert.Equal(c.uploadPath, "foo", "should be correct path")
 	assert.Equal(c.uploadData, "uploadfoo\n", "should have the proper data")
+	assert.Nil(c.uploadInfo, "should have no file info")
+
+	// Test that file info is sent along with uploads
+	var uploadFi os.FileInfo = &fileInfo{NameValue: "foo", SizeValue: 10}
+	uploadR, uploadW = io.Pipe()
+	go uploadW.Write([]byte("uploadfoo\n"))
+	err = remote.Upload("foo", uploadR, &uploadFi)
+	assert.Nil(err, "should not error")
+	assert.NotNil(c.uploadInfo, "should have file info")
+	assert.Equal((*c.uploadInfo).Name(), "foo", "should have the proper name")
+	assert.Equal((*c.uploadInfo).Size(), int64(10), "should have the proper size")
+
+	// Test that we can upload directories
+	err = remote.UploadDir("/dst", "/src/", []string{"*.tmp"})
+	assert.Nil(err, "should not error")
+	assert.True(c.uploadDirCalled, "should be called")
+	assert.Equal(c.uploadDirDst, "/dst", "should have the proper destination")
+	assert.Equal(c.uploadDirSrc, "/src/", "should have the proper source")
+	assert.Equal(c.uploadDirExclude, []string{"*.tmp"}, "should have the proper excludes")
 
 	// Test that we can download things
 	downloadR, downloadW := io.Pipe()
@@ -139,6 +228,35 @@ func TestCommunicatorRPC(t *testing.T) {
 	<-downloadDone
 	assert.Nil(downloadErr, "should not error reading download data")
 	assert.Equal(downloadData, "download\n", "should have the proper data")
+
+	// Test that we can download directories
+	err = remote.DownloadDir("/src", "/dst/", []string{"*.tmp"})
+	assert.Nil(err, "should not error")
+	assert.True(c.downloadDirCalled, "should be called")
+	assert.Equal(c.downloadDirSrc, "/src", "should have the proper source")
+	assert.Equal(c.downloadDirDst, "/dst/", "should have the proper destination")
+	assert.Equal(c.downloadDirExclude, []string{"*.tmp"}, "should have the proper excludes")
+
+	// Test that we can forward ports, and that closing the forward
+	// closes it on the remote side.
+	forward, err := remote.ForwardLocal("127.0.0.1:8000", "127.0.0.1:80")
+	assert.Nil(err, "should not error")
+	assert.Equal(c.forwardLocal, "127.0.0.1:8000", "should have the proper local address")
+	assert.Equal(c.forwardRemote, "127.0.0.1:80", "should have the proper remote address")
+
+	forward.Close()
+	select {
+	case <-c.forwardCloser.closed:
+		// Success
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("forward should be closed")
+	}
+
+	forward, err = remote.ForwardRemote("127.0.0.1:8080", "127.0.0.1:3128")
+	assert.Nil(err, "should not error")
+	assert.Equal(c.forwardLocal, "127.0.0.1:3128", "should have the proper local address")
+	assert.Equal(c.forwardRemote, "127.0.0.1:8080", "should have the proper remote address")
+	forward.Close()
 }
 
 func TestCommunicator_ImplementsCommunicator(t *testing.T) {
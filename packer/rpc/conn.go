@@ -0,0 +1,165 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"runtime"
+	"time"
+)
+
+// The token that every connection must start with, so that other local
+// processes can't connect to the RPC servers of Packer and its plugins.
+// It is made up for each run of Packer, and given to its plugins.
+var authToken = newAuthToken()
+
+// How long a new connection has to send the auth token.
+const authTimeout = 10 * time.Second
+
+// AuthToken returns the token that connections must start with.
+func AuthToken() string {
+	return authToken
+}
+
+// SetAuthToken sets the token that connections must start with. Plugins
+// use the token of the Packer process that started them.
+func SetAuthToken(token string) {
+	authToken = token
+}
+
+func newAuthToken() string {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// The network that RPC servers listen on. Unix sockets can only be
+// connected to by the processes that can get to the file, and don't make
+// firewalls ask whether Packer may listen on a port. Windows doesn't have
+// them, so TCP on localhost within the port range is used there.
+func network() string {
+	if runtime.GOOS == "windows" {
+		return "tcp"
+	}
+
+	return "unix"
+}
+
+// Listen returns a listener for a new RPC server, which only accepts
+// connections that start with the auth token.
+func Listen() (net.Listener, error) {
+	if network() == "tcp" {
+		l := netListenerInRange(portRangeMin, portRangeMax)
+		if l == nil {
+			return nil, errors.New("no open ports in the plugin port range")
+		}
+
+		return &authListener{l}, nil
+	}
+
+	// Use the name of a new temporary file for the socket, which is
+	// removed when the listener is closed.
+	f, err := ioutil.TempFile("", "packer-rpc")
+	if err != nil {
+		return nil, err
+	}
+
+	path := f.Name()
+	f.Close()
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authListener{l}, nil
+}
+
+// Dial connects to the RPC server at the address, which is what the
+// listener of the server says its address is.
+func Dial(address string) (*rpc.Client, error) {
+	conn, err := dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpc.NewClient(conn), nil
+}
+
+// newListener returns a listener for a temporary server, or nil if one
+// can't be made.
+func newListener() net.Listener {
+	l, err := Listen()
+	if err != nil {
+		log.Printf("[ERROR] Error listening for RPC connections: %s", err)
+		return nil
+	}
+
+	return l
+}
+
+// dial connects to the address and sends the auth token.
+func dial(address string) (net.Conn, error) {
+	conn, err := net.Dial(network(), address)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(conn, authToken+"\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// authListener is a listener that closes the connections that don't
+// start with the auth token.
+type authListener struct {
+	net.Listener
+}
+
+func (l *authListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := readAuthToken(conn); err != nil {
+			log.Printf("[WARN] Rejected RPC connection: %s", err)
+			conn.Close()
+			continue
+		}
+
+		return conn, nil
+	}
+}
+
+func readAuthToken(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	expected := []byte(authToken + "\n")
+	token := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, token); err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(token, expected) != 1 {
+		return errors.New("invalid auth token")
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestListen_AuthToken(t *testing.T) {
+	l, err := Listen()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+
+		accepted <- conn
+	}()
+
+	// A connection with the wrong token is closed
+	bad, err := net.Dial(network(), l.Addr().String())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer bad.Close()
+
+	io.WriteString(bad, strings.Repeat("x", len(AuthToken()))+"\n")
+	if _, err := bad.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection with a bad token should be closed")
+	}
+
+	// A connection with the token is accepted
+	good, err := dial(l.Addr().String())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer good.Close()
+
+	conn, ok := <-accepted
+	if !ok {
+		t.Fatal("connection should be accepted")
+	}
+	conn.Close()
+}
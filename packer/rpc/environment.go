@@ -28,7 +28,7 @@ func (e *Environment) Builder(name string) (b packer.Builder, err error) {
 		return
 	}
 
-	client, err := rpc.Dial("tcp", reply)
+	client, err := Dial(reply)
 	if err != nil {
 		return
 	}
@@ -43,7 +43,7 @@ func (e *Environment) Cache() packer.Cache {
 		panic(err)
 	}
 
-	client, err := rpc.Dial("tcp", reply)
+	client, err := Dial(reply)
 	if err != nil {
 		panic(err)
 	}
@@ -57,6 +57,15 @@ func (e *Environment) Cli(args []string) (result int, err error) {
 	return
 }
 
+func (e *Environment) Communicators() map[string]string {
+	var reply map[string]string
+	if err := e.client.Call("Environment.Communicators", new(interface{}), &reply); err != nil {
+		panic(err)
+	}
+
+	return reply
+}
+
 func (e *Environment) Hook(name string) (h packer.Hook, err error) {
 	var reply string
 	err = e.client.Call("Environment.Hook", name, &reply)
@@ -64,7 +73,7 @@ func (e *Environment) Hook(name string) (h packer.Hook, err error) {
 		return
 	}
 
-	client, err := rpc.Dial("tcp", reply)
+	client, err := Dial(reply)
 	if err != nil {
 		return
 	}
@@ -80,7 +89,7 @@ func (e *Environment) PostProcessor(name string) (p packer.PostProcessor, err er
 		return
 	}
 
-	client, err := rpc.Dial("tcp", reply)
+	client, err := Dial(reply)
 	if err != nil {
 		return
 	}
@@ -96,7 +105,7 @@ func (e *Environment) Provisioner(name string) (p packer.Provisioner, err error)
 		return
 	}
 
-	client, err := rpc.Dial("tcp", reply)
+	client, err := Dial(reply)
 	if err != nil {
 		return
 	}
@@ -109,7 +118,7 @@ func (e *Environment) Ui() packer.Ui {
 	var reply string
 	e.client.Call("Environment.Ui", new(interface{}), &reply)
 
-	client, err := rpc.Dial("tcp", reply)
+	client, err := Dial(reply)
 	if err != nil {
 		panic(err)
 	}
@@ -145,6 +154,11 @@ func (e *EnvironmentServer) Cli(args *EnvironmentCliArgs, reply *int) (err error
 	return
 }
 
+func (e *EnvironmentServer) Communicators(args *interface{}, reply *map[string]string) error {
+	*reply = e.env.Communicators()
+	return nil
+}
+
 func (e *EnvironmentServer) Hook(name *string, reply *string) error {
 	hook, err := e.env.Hook(*name)
 	if err != nil {
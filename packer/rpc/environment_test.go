@@ -41,6 +41,10 @@ func (e *testEnvironment) Cli(args []string) (int, error) {
 	return 42, nil
 }
 
+func (e *testEnvironment) Communicators() map[string]string {
+	return map[string]string{"foo": "packer-communicator-foo"}
+}
+
 func (e *testEnvironment) Hook(name string) (packer.Hook, error) {
 	e.hookCalled = true
 	e.hookName = name
@@ -76,7 +80,7 @@ func TestEnvironmentRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	assert.Nil(err, "should be able to connect")
 	eClient := &Environment{client}
 
@@ -100,6 +104,10 @@ func TestEnvironmentRPC(t *testing.T) {
 	assert.Equal(e.cliArgs, cliArgs, "args should match")
 	assert.Equal(result, 42, "result shuld be 42")
 
+	// Test Communicators
+	communicators := eClient.Communicators()
+	assert.Equal(communicators, map[string]string{"foo": "packer-communicator-foo"}, "should have communicators")
+
 	// Test Provisioner
 	_, _ = eClient.Provisioner("foo")
 	assert.True(e.provCalled, "provisioner should be called")
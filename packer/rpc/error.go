@@ -1,5 +1,9 @@
 package rpc
 
+import (
+	"github.com/mitchellh/packer/packer"
+)
+
 // This is a type that wraps error types so that they can be messaged
 // across RPC channels. Since "error" is an interface, we can't always
 // gob-encode the underlying structure. This is a valid error interface
@@ -15,3 +19,23 @@ func NewBasicError(err error) *BasicError {
 func (e *BasicError) Error() string {
 	return e.Message
 }
+
+// NewConfigError wraps the error from preparing or configuring a
+// component so that it can be messaged across RPC. Unlike NewBasicError,
+// MultiErrors and ConfigKeyErrors are kept as they are, so that the core
+// can report where in the template the errors are.
+func NewConfigError(err error) error {
+	switch e := err.(type) {
+	case *packer.MultiError:
+		errs := make([]error, len(e.Errors))
+		for i, err := range e.Errors {
+			errs[i] = NewConfigError(err)
+		}
+
+		return &packer.MultiError{errs}
+	case *packer.ConfigKeyError:
+		return e
+	}
+
+	return NewBasicError(err)
+}
@@ -3,6 +3,7 @@ package rpc
 import (
 	"cgl.tideland.biz/asserts"
 	"errors"
+	"github.com/mitchellh/packer/packer"
 	"testing"
 )
 
@@ -23,3 +24,18 @@ func TestBasicError_MatchesMessage(t *testing.T) {
 
 	assert.Equal(wrapped.Error(), err.Error(), "should have the same error")
 }
+
+func TestNewConfigError(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	keyErr := &packer.ConfigKeyError{Key: "foo", Message: "bad foo"}
+	err := NewConfigError(&packer.MultiError{[]error{keyErr, errors.New("bar")}})
+
+	merr, ok := err.(*packer.MultiError)
+	assert.True(ok, "should be a MultiError")
+	assert.Equal(merr.Errors[0], keyErr, "should keep the key error")
+	assert.Equal(merr.Errors[1], &BasicError{"bar"}, "should wrap the other error")
+
+	err = NewConfigError(errors.New("foo"))
+	assert.Equal(err, &BasicError{"foo"}, "should wrap the error")
+}
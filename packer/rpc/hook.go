@@ -38,7 +38,7 @@ func (h *hook) Run(name string, ui packer.Ui, comm packer.Communicator, data int
 }
 
 func (h *HookServer) Run(args *HookRunArgs, reply *interface{}) error {
-	client, err := rpc.Dial("tcp", args.RPCAddress)
+	client, err := Dial(args.RPCAddress)
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,15 @@
 package rpc
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+	"github.com/mitchellh/packer/packer"
+)
 
 func init() {
 	gob.Register(new(map[string]interface{}))
+	gob.Register(make(map[string]string))
 	gob.Register(make([]interface{}, 0))
 	gob.Register(new(BasicError))
+	gob.Register(new(packer.ConfigKeyError))
+	gob.Register(new(packer.MultiError))
 }
@@ -57,7 +57,7 @@ func (p *postProcessor) PostProcess(ui packer.Ui, a packer.Artifact) (packer.Art
 		return nil, false, nil
 	}
 
-	client, err := rpc.Dial("tcp", response.RPCAddress)
+	client, err := Dial(response.RPCAddress)
 	if err != nil {
 		return nil, false, err
 	}
@@ -68,14 +68,14 @@ func (p *postProcessor) PostProcess(ui packer.Ui, a packer.Artifact) (packer.Art
 func (p *PostProcessorServer) Configure(args *PostProcessorConfigureArgs, reply *error) error {
 	*reply = p.p.Configure(args.Configs...)
 	if *reply != nil {
-		*reply = NewBasicError(*reply)
+		*reply = NewConfigError(*reply)
 	}
 
 	return nil
 }
 
 func (p *PostProcessorServer) PostProcess(address string, reply *PostProcessorProcessResponse) error {
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	if err != nil {
 		return err
 	}
@@ -40,7 +40,7 @@ func TestPostProcessorRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	if err != nil {
 		t.Fatalf("Error connecting to rpc: %s", err)
 	}
@@ -50,14 +50,14 @@ func (p *provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 func (p *ProvisionerServer) Prepare(args *ProvisionerPrepareArgs, reply *error) error {
 	*reply = p.p.Prepare(args.Configs...)
 	if *reply != nil {
-		*reply = NewBasicError(*reply)
+		*reply = NewConfigError(*reply)
 	}
 
 	return nil
 }
 
 func (p *ProvisionerServer) Provision(args *ProvisionerProvisionArgs, reply *interface{}) error {
-	client, err := rpc.Dial("tcp", args.RPCAddress)
+	client, err := Dial(args.RPCAddress)
 	if err != nil {
 		return err
 	}
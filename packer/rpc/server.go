@@ -41,6 +41,12 @@ func RegisterCommunicator(s *rpc.Server, c packer.Communicator) {
 	s.RegisterName("Communicator", &CommunicatorServer{c})
 }
 
+// Registers the appropriate endpoint on an RPC server to serve a
+// Packer CommunicatorFactory.
+func RegisterCommunicatorFactory(s *rpc.Server, f packer.CommunicatorFactory) {
+	s.RegisterName("CommunicatorFactory", &CommunicatorFactoryServer{f})
+}
+
 // Registers the appropriate endpoint on an RPC server to serve a
 // Packer Environment
 func RegisterEnvironment(s *rpc.Server, e packer.Environment) {
@@ -71,12 +77,12 @@ func RegisterUi(s *rpc.Server, ui packer.Ui) {
 }
 
 func serveSingleConn(s *rpc.Server) string {
-	l := netListenerInRange(portRangeMin, portRangeMax)
+	l := newListener()
 
 	// Accept a single connection in a goroutine and then exit
 	go func() {
-		defer l.Close()
 		conn, err := l.Accept()
+		l.Close()
 		if err != nil {
 			panic(err)
 		}
@@ -17,11 +17,22 @@ type UiServer struct {
 	ui packer.Ui
 }
 
+// The arguments to Ui.Machine over RPC.
+type UiMachineArgs struct {
+	Category string
+	Args     []string
+}
+
 func (u *Ui) Ask(query string) (result string, err error) {
 	err = u.client.Call("Ui.Ask", query, &result)
 	return
 }
 
+func (u *Ui) AskSecret(query string) (result string, err error) {
+	err = u.client.Call("Ui.AskSecret", query, &result)
+	return
+}
+
 func (u *Ui) Error(message string) {
 	if err := u.client.Call("Ui.Error", message, new(interface{})); err != nil {
 		panic(err)
@@ -34,6 +45,17 @@ func (u *Ui) Message(message string) {
 	}
 }
 
+func (u *Ui) Machine(t string, args ...string) {
+	rpcArgs := &UiMachineArgs{
+		Category: t,
+		Args:     args,
+	}
+
+	if err := u.client.Call("Ui.Machine", rpcArgs, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
 func (u *Ui) Say(message string) {
 	if err := u.client.Call("Ui.Say", message, new(interface{})); err != nil {
 		panic(err)
@@ -45,6 +67,11 @@ func (u *UiServer) Ask(query string, reply *string) (err error) {
 	return
 }
 
+func (u *UiServer) AskSecret(query string, reply *string) (err error) {
+	*reply, err = u.ui.AskSecret(query)
+	return
+}
+
 func (u *UiServer) Error(message *string, reply *interface{}) error {
 	u.ui.Error(*message)
 
@@ -58,6 +85,13 @@ func (u *UiServer) Message(message *string, reply *interface{}) error {
 	return nil
 }
 
+func (u *UiServer) Machine(args *UiMachineArgs, reply *interface{}) error {
+	u.ui.Machine(args.Category, args.Args...)
+
+	*reply = nil
+	return nil
+}
+
 func (u *UiServer) Say(message *string, reply *interface{}) error {
 	u.ui.Say(*message)
 
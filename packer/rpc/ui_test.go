@@ -9,8 +9,13 @@ import (
 type testUi struct {
 	askCalled      bool
 	askQuery       string
+	secretCalled   bool
+	secretQuery    string
 	errorCalled    bool
 	errorMessage   string
+	machineCalled  bool
+	machineType    string
+	machineArgs    []string
 	messageCalled  bool
 	messageMessage string
 	sayCalled      bool
@@ -23,11 +28,23 @@ func (u *testUi) Ask(query string) (string, error) {
 	return "foo", nil
 }
 
+func (u *testUi) AskSecret(query string) (string, error) {
+	u.secretCalled = true
+	u.secretQuery = query
+	return "secret", nil
+}
+
 func (u *testUi) Error(message string) {
 	u.errorCalled = true
 	u.errorMessage = message
 }
 
+func (u *testUi) Machine(t string, args ...string) {
+	u.machineCalled = true
+	u.machineType = t
+	u.machineArgs = args
+}
+
 func (u *testUi) Message(message string) {
 	u.messageCalled = true
 	u.messageMessage = message
@@ -50,7 +67,7 @@ func TestUiRPC(t *testing.T) {
 	address := serveSingleConn(server)
 
 	// Create the client over RPC and run some methods to verify it works
-	client, err := rpc.Dial("tcp", address)
+	client, err := Dial(address)
 	if err != nil {
 		panic(err)
 	}
@@ -64,9 +81,20 @@ func TestUiRPC(t *testing.T) {
 	assert.Equal(ui.askQuery, "query", "should be correct")
 	assert.Equal(result, "foo", "should have correct result")
 
+	result, err = uiClient.AskSecret("password")
+	assert.Nil(err, "should not error")
+	assert.True(ui.secretCalled, "ask secret should be called")
+	assert.Equal(ui.secretQuery, "password", "should be correct")
+	assert.Equal(result, "secret", "should have correct result")
+
 	uiClient.Error("message")
 	assert.Equal(ui.errorMessage, "message", "message should be correct")
 
+	uiClient.Machine("foo", "bar", "baz")
+	assert.True(ui.machineCalled, "machine should be called")
+	assert.Equal(ui.machineType, "foo", "type should be correct")
+	assert.Equal(ui.machineArgs, []string{"bar", "baz"}, "args should be correct")
+
 	uiClient.Message("message")
 	assert.Equal(ui.messageMessage, "message", "message should be correct")
 
@@ -0,0 +1,62 @@
+package packer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SensitiveMask is what the values of sensitive variables are replaced
+// with in the output and logs of Packer.
+const SensitiveMask = "<sensitive>"
+
+var sensitiveReplacer *strings.Replacer
+var sensitiveL sync.RWMutex
+
+// SetSensitiveValues sets the values that MaskSensitive masks, such as the
+// values of the sensitive variables of a template. UIs and the log mask
+// them in everything they write, including what plugins send them, since
+// the output of plugins goes through the UI and log of the core.
+func SetSensitiveValues(values []string) {
+	// Longer values are masked first, so that a value containing another
+	// is masked as a whole.
+	sorted := make([]string, 0, len(values))
+	for _, value := range values {
+		if value != "" {
+			sorted = append(sorted, value)
+		}
+	}
+	sort.Sort(sort.Reverse(byLength(sorted)))
+
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, value := range sorted {
+		pairs = append(pairs, value, SensitiveMask)
+	}
+
+	sensitiveL.Lock()
+	defer sensitiveL.Unlock()
+
+	sensitiveReplacer = nil
+	if len(pairs) > 0 {
+		sensitiveReplacer = strings.NewReplacer(pairs...)
+	}
+}
+
+// MaskSensitive returns the string with the sensitive values in it
+// replaced with SensitiveMask.
+func MaskSensitive(s string) string {
+	sensitiveL.RLock()
+	defer sensitiveL.RUnlock()
+
+	if sensitiveReplacer == nil {
+		return s
+	}
+
+	return sensitiveReplacer.Replace(s)
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Less(i, j int) bool { return len(s[i]) < len(s[j]) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
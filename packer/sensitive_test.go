@@ -0,0 +1,60 @@
+package packer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMaskSensitive(t *testing.T) {
+	defer SetSensitiveValues(nil)
+
+	if actual := MaskSensitive("foo bar"); actual != "foo bar" {
+		t.Fatalf("bad: %s", actual)
+	}
+
+	SetSensitiveValues([]string{"", "bar", "foobar"})
+	actual := MaskSensitive("foobar bar baz")
+	if actual != "<sensitive> <sensitive> baz" {
+		t.Fatalf("bad: %s", actual)
+	}
+
+	SetSensitiveValues(nil)
+	if actual := MaskSensitive("bar"); actual != "bar" {
+		t.Fatalf("bad: %s", actual)
+	}
+}
+
+func TestMaskSensitive_Ui(t *testing.T) {
+	defer SetSensitiveValues(nil)
+	SetSensitiveValues([]string{"hunter2"})
+
+	ui := testUi()
+	ui.Say("password is hunter2")
+	ui.Error("hunter2 failed")
+
+	output := readWriter(ui)
+	if strings.Contains(output, "hunter2") || !strings.Contains(output, "password is <sensitive>") {
+		t.Fatalf("bad: %#v", output)
+	}
+
+	var buf bytes.Buffer
+	machineUi := &MachineReadableUi{Writer: &buf}
+	machineUi.Machine("foo", "hunter2")
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("bad: %#v", buf.String())
+	}
+}
+
+func TestMaskSensitive_Log(t *testing.T) {
+	defer SetSensitiveValues(nil)
+	SetSensitiveValues([]string{"hunter2"})
+
+	var buf bytes.Buffer
+	w := &LogWriter{Writer: &buf}
+	w.Write([]byte("[INFO] key: hunter2\n"))
+
+	if buf.String() != "[INFO] key: <sensitive>\n" {
+		t.Fatalf("bad: %#v", buf.String())
+	}
+}
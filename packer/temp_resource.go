@@ -0,0 +1,206 @@
+package packer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TempResourcesEnvVar is the environment variable with the directory where
+// temporary resources are tracked. Packer sets it for the plugins it starts,
+// so that the builders running in them track resources in the same place.
+const TempResourcesEnvVar = "PACKER_TEMP_RESOURCES_DIR"
+
+// A TempResource is something a build creates only for the duration of the
+// build, such as a key pair, a security group or a local virtual machine.
+//
+// Builders track these with TrackTempResource while they exist, so that if
+// the build can't clean them up itself, because a plugin crashed or Packer
+// was forcefully stopped, Packer can clean them up or say what was left
+// behind.
+type TempResource struct {
+	// The kind of resource and what identifies it, such as "key pair"
+	// and its name.
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+
+	// A local file or directory that is removed to clean up the resource.
+	Path string `json:"path,omitempty"`
+
+	// A command that is run to clean up the resource, if it isn't a local
+	// file.
+	Command []string `json:"command,omitempty"`
+
+	// A hint for the user about how to remove the resource if it couldn't
+	// be cleaned up, such as where it is.
+	Hint string `json:"hint,omitempty"`
+
+	// When the resource was tracked, so they're cleaned up in the reverse
+	// order of creation.
+	Created int64 `json:"created"`
+}
+
+func (r *TempResource) String() string {
+	result := fmt.Sprintf("%s '%s'", r.Kind, r.ID)
+	if r.Hint != "" {
+		result += ": " + r.Hint
+	}
+
+	return result
+}
+
+// cleanup tries to clean up the resource.
+func (r *TempResource) cleanup() error {
+	if r.Path != "" {
+		return os.RemoveAll(r.Path)
+	}
+
+	if len(r.Command) > 0 {
+		cmd := exec.Command(r.Command[0], r.Command[1:]...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(output)))
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%s can't be cleaned up automatically", r.Kind)
+}
+
+func (r *TempResource) filename(dir string) string {
+	sha := sha256.New()
+	sha.Write([]byte(r.Kind + "\x00" + r.ID))
+	return filepath.Join(dir, hex.EncodeToString(sha.Sum(nil))+".json")
+}
+
+// TrackTempResource records that a temporary resource was created. The
+// resource must be untracked with UntrackTempResource once the build
+// removes it. Tracking is best effort: errors are only logged, so that
+// they don't fail the build.
+func TrackTempResource(r *TempResource) {
+	dir := os.Getenv(TempResourcesEnvVar)
+	if dir == "" {
+		log.Printf("[DEBUG] Temporary resources aren't tracked, not tracking: %s", r)
+		return
+	}
+
+	if r.Created == 0 {
+		r.Created = time.Now().UnixNano()
+	}
+
+	if err := writeTempResource(dir, r); err != nil {
+		log.Printf("[WARN] Error tracking temporary resource %s: %s", r, err)
+		return
+	}
+
+	log.Printf("[DEBUG] Tracking temporary resource: %s", r)
+}
+
+// UntrackTempResource records that a temporary resource was removed.
+func UntrackTempResource(r *TempResource) {
+	dir := os.Getenv(TempResourcesEnvVar)
+	if dir == "" {
+		return
+	}
+
+	if err := os.Remove(r.filename(dir)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Error untracking temporary resource %s: %s", r, err)
+		return
+	}
+
+	log.Printf("[DEBUG] Untracked temporary resource: %s", r)
+}
+
+// writeTempResource writes the record of the resource to a temporary file
+// first, so that a process killed while writing doesn't leave half of it.
+func writeTempResource(dir string, r *TempResource) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "resource")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), r.filename(dir))
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// TrackedTempResources returns the temporary resources tracked in the
+// directory, the most recently created first.
+func TrackedTempResources(dir string) ([]*TempResource, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*TempResource, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var r TempResource
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("Error reading temporary resource %s: %s", path, err)
+		}
+
+		result = append(result, &r)
+	}
+
+	sort.Sort(tempResourcesByCreated(result))
+	return result, nil
+}
+
+// CleanupTempResources tries to clean up the temporary resources that are
+// still tracked in the directory, and returns the ones that couldn't be
+// cleaned up, which were left behind.
+func CleanupTempResources(dir string) ([]*TempResource, error) {
+	resources, err := TrackedTempResources(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	leaked := make([]*TempResource, 0)
+	for _, r := range resources {
+		log.Printf("[INFO] Cleaning up temporary resource: %s", r)
+		if err := r.cleanup(); err != nil {
+			log.Printf("[WARN] Error cleaning up temporary resource %s: %s", r, err)
+			leaked = append(leaked, r)
+			continue
+		}
+
+		if err := os.Remove(r.filename(dir)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return leaked, nil
+}
+
+type tempResourcesByCreated []*TempResource
+
+func (t tempResourcesByCreated) Len() int           { return len(t) }
+func (t tempResourcesByCreated) Less(i, j int) bool { return t[i].Created > t[j].Created }
+func (t tempResourcesByCreated) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,135 @@
+package packer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTempResourcesDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := os.Setenv(TempResourcesEnvVar, dir); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return dir
+}
+
+func TestTrackTempResource(t *testing.T) {
+	dir := testTempResourcesDir(t)
+	defer os.Setenv(TempResourcesEnvVar, "")
+	defer os.RemoveAll(dir)
+
+	first := &TempResource{Kind: "key pair", ID: "foo", Hint: "delete it", Created: 1}
+	second := &TempResource{Kind: "instance", ID: "i-1234", Created: 2}
+	TrackTempResource(first)
+	TrackTempResource(second)
+
+	resources, err := TrackedTempResources(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The most recently created is first
+	if len(resources) != 2 {
+		t.Fatalf("bad: %#v", resources)
+	}
+
+	if resources[0].ID != "i-1234" || resources[1].ID != "foo" {
+		t.Fatalf("bad: %s, %s", resources[0], resources[1])
+	}
+
+	if resources[1].String() != "key pair 'foo': delete it" {
+		t.Fatalf("bad: %s", resources[1])
+	}
+
+	UntrackTempResource(second)
+	resources, err = TrackedTempResources(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(resources) != 1 || resources[0].ID != "foo" {
+		t.Fatalf("bad: %#v", resources)
+	}
+
+	// Untracking twice is fine
+	UntrackTempResource(second)
+}
+
+func TestTrackTempResource_NotTracked(t *testing.T) {
+	os.Setenv(TempResourcesEnvVar, "")
+
+	// Without a directory, resources just aren't tracked
+	r := &TempResource{Kind: "key pair", ID: "foo"}
+	TrackTempResource(r)
+	UntrackTempResource(r)
+}
+
+func TestCleanupTempResources(t *testing.T) {
+	dir := testTempResourcesDir(t)
+	defer os.Setenv(TempResourcesEnvVar, "")
+	defer os.RemoveAll(dir)
+
+	tempDir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "image.iso")
+	if err := ioutil.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	TrackTempResource(&TempResource{Kind: "directory", ID: tempDir, Path: tempDir})
+	TrackTempResource(&TempResource{Kind: "key pair", ID: "foo"})
+
+	leaked, err := CleanupTempResources(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(leaked) != 1 || leaked[0].ID != "foo" {
+		t.Fatalf("bad: %#v", leaked)
+	}
+
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Fatalf("should remove the directory: %s", err)
+	}
+
+	// Only the leaked resource is still tracked
+	resources, err := TrackedTempResources(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(resources) != 1 || resources[0].ID != "foo" {
+		t.Fatalf("bad: %#v", resources)
+	}
+}
+
+func TestCleanupTempResources_Command(t *testing.T) {
+	dir := testTempResourcesDir(t)
+	defer os.Setenv(TempResourcesEnvVar, "")
+	defer os.RemoveAll(dir)
+
+	// The test binary is run without any tests, which succeeds
+	command := []string{os.Args[0], "-test.run=^$"}
+	TrackTempResource(&TempResource{Kind: "machine", ID: "good", Command: command})
+	TrackTempResource(&TempResource{Kind: "machine", ID: "bad", Command: []string{"i-better-not-exist"}})
+
+	leaked, err := CleanupTempResources(dir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(leaked) != 1 || leaked[0].ID != "bad" {
+		t.Fatalf("bad: %#v", leaked)
+	}
+}
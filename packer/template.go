@@ -5,6 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
 // The rawTemplate struct represents the structure of a template read
@@ -12,10 +17,24 @@ import (
 // "interface{}" pointers since we actually don't know what their contents
 // are until we read the "type" field.
 type rawTemplate struct {
-	Builders       []map[string]interface{}
-	Hooks          map[string][]string
-	Provisioners   []map[string]interface{}
-	PostProcessors []interface{} `json:"post-processors"`
+	Builders         []map[string]interface{}
+	Hooks            map[string][]string
+	Includes         []string
+	Metadata         map[string]string
+	MinPackerVersion string `json:"min_packer_version" mapstructure:"min_packer_version"`
+	Provisioners     []map[string]interface{}
+	Proxy            *ProxyConfig
+	PostProcessors   []interface{}              `json:"post-processors" mapstructure:"post-processors"`
+	RequiredPlugins  map[string]*RequiredPlugin `json:"required_plugins" mapstructure:"required_plugins"`
+	Sensitive        []string                   `json:"sensitive-variables" mapstructure:"sensitive-variables"`
+	Variables        map[string]interface{}
+}
+
+// The keys of a template that Packer knows, which are the fields of
+// rawTemplate.
+var templateKeys = []string{
+	"builders", "hooks", "includes", "metadata", "min_packer_version", "post-processors",
+	"provisioners", "proxy", "required_plugins", "sensitive-variables", "variables",
 }
 
 // The Template struct represents a parsed template, parsed into the most
@@ -25,6 +44,46 @@ type Template struct {
 	Hooks          map[string][]string
 	PostProcessors [][]rawPostProcessorConfig
 	Provisioners   []rawProvisionerConfig
+
+	// The user variables of the template, mapped to their values. These
+	// are the defaults in the template, with any environment variables
+	// they read filled in, until SetVariables is called. The values of
+	// lists and maps are JSON.
+	Variables map[string]string
+
+	// The types of the user variables, by name, which are
+	// VariableTypeString, VariableTypeList or VariableTypeMap, as told by
+	// their defaults.
+	VariableTypes map[string]string
+
+	// The names of the user variables whose values are secrets, such as
+	// access keys, which are masked in the output and logs of Packer.
+	// See SensitiveValues.
+	SensitiveVariables []string
+
+	// The names of the user variables that have no default, which is
+	// null, so they must be set. See MissingVariables.
+	RequiredVariables []string
+
+	// The metadata of the builds, such as the version of the image and
+	// who made it, whose values are processed with ConfigTemplate. See
+	// BuildMetadata.
+	Metadata map[string]string
+
+	// The external plugins that the template needs, by the names of their
+	// binaries, which "packer init" installs.
+	RequiredPlugins map[string]*RequiredPlugin
+
+	// The HTTP proxy that the builds download and call the APIs of clouds
+	// through, whose settings are processed with ConfigTemplate, or nil
+	// if the template has none.
+	Proxy *ProxyConfig
+
+	includes    []string
+	metadata    map[string]map[string]string
+	locations   *templateLocations
+	set         map[string]struct{}
+	unknownKeys []error
 }
 
 // The rawBuilderConfig struct represents a raw, unprocessed builder
@@ -32,10 +91,14 @@ type Template struct {
 // raw configuration. If requested, this is used to compile into a full
 // builder configuration at some point.
 type rawBuilderConfig struct {
-	Name string
-	Type string
+	Name    string
+	Type    string
+	OnError string `mapstructure:"on_error"`
+	Timeout string
 
+	path      string
 	rawConfig interface{}
+	locations *templateLocations
 }
 
 // rawPostProcessorConfig represents a raw, unprocessed post-processor
@@ -43,18 +106,150 @@ type rawBuilderConfig struct {
 // raw configuration that is handed to the post-processor for it to process.
 type rawPostProcessorConfig struct {
 	Type              string
-	KeepInputArtifact bool `mapstructure:"keep_input_artifact"`
-	rawConfig         interface{}
+	KeepInputArtifact *bool `mapstructure:"keep_input_artifact"`
+	Only              []string
+	Except            []string
+
+	path      string
+	rawConfig interface{}
+	locations *templateLocations
 }
 
 // rawProvisionerConfig represents a raw, unprocessed provisioner configuration.
 // It contains the type of the provisioner as well as the raw configuration
 // that is handed to the provisioner for it to process.
 type rawProvisionerConfig struct {
-	Type     string
-	Override map[string]interface{}
+	Type        string
+	Override    map[string]interface{}
+	Only        []string
+	Except      []string
+	PauseBefore string `mapstructure:"pause_before"`
+	MaxRetries  int    `mapstructure:"max_retries"`
+	Timeout     string
 
+	path      string
 	rawConfig interface{}
+	locations *templateLocations
+}
+
+// RunsFor says whether the post-processor runs for the build with the
+// name and builder type, by its only and except lists.
+func (r *rawPostProcessorConfig) RunsFor(name string, builderType string) bool {
+	return runsForBuild(r.Only, r.Except, name, builderType)
+}
+
+// RunsFor says whether the provisioner runs for the build with the name
+// and builder type, by its only and except lists.
+func (r *rawProvisionerConfig) RunsFor(name string, builderType string) bool {
+	return runsForBuild(r.Only, r.Except, name, builderType)
+}
+
+// OverridesFor returns the keys of the overrides of the provisioner that
+// apply to the build with the name and builder type, in the order they
+// are merged: the override for the builder type, and then the one for
+// the name of the build, so that it wins.
+func (r *rawProvisionerConfig) OverridesFor(name string, builderType string) []string {
+	keys := make([]string, 0, 2)
+	for _, key := range []string{builderType, name} {
+		if _, ok := r.Override[key]; !ok {
+			continue
+		}
+
+		if len(keys) > 0 && keys[0] == key {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// runsForBuild says whether a component with the only and except lists
+// runs for a build. Like -only and -except, the lists have the names of
+// builds or the types of their builders.
+func runsForBuild(only []string, except []string, name string, builderType string) bool {
+	matches := func(names []string) bool {
+		for _, n := range names {
+			if n == name || n == builderType {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(only) > 0 {
+		return matches(only)
+	}
+
+	return !matches(except)
+}
+
+// checkBuildFilter checks the only and except lists of a component, whose
+// names must be the names of builds or the types of their builders.
+func (t *Template) checkBuildFilter(component string, only []string, except []string) []error {
+	errors := make([]error, 0)
+	if len(only) > 0 && len(except) > 0 {
+		errors = append(errors, fmt.Errorf("%s: only one of 'only' and 'except' can be given", component))
+	}
+
+	for _, names := range [][]string{only, except} {
+		for _, n := range names {
+			found := false
+			for name, builder := range t.Builders {
+				if n == name || n == builder.Type {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				errors = append(errors, fmt.Errorf("%s: '%s' isn't a build of the template", component, n))
+			}
+		}
+	}
+
+	return errors
+}
+
+// checkOverride checks the keys of the override of a provisioner, which
+// must be the names of builds or the types of their builders, so that a
+// misspelled one isn't silently ignored.
+func (t *Template) checkOverride(component string, override map[string]interface{}) []error {
+	keys := make([]string, 0, len(override))
+	for key := range override {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	errors := make([]error, 0)
+	for _, key := range keys {
+		found := false
+		for name, builder := range t.Builders {
+			if key == name || key == builder.Type {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			errors = append(errors, fmt.Errorf(
+				"%s: override '%s' isn't a build of the template", component, key))
+		}
+	}
+
+	return errors
+}
+
+// ParseTemplateFile parses a Template from the contents of the template
+// file with the given path. Files ending in ".hcl" are parsed as HCL with
+// ParseHCLTemplate, files ending in ".yml" or ".yaml" as YAML with
+// ParseYAMLTemplate, and all others as JSON. The templates it includes
+// are found relative to the directory of the file.
+func ParseTemplateFile(path string, data []byte) (*Template, error) {
+	return parseTemplateFile(path, data, templateParser(path))
 }
 
 // ParseTemplate takes a byte slice and parses a Template from it, returning
@@ -62,10 +257,273 @@ type rawProvisionerConfig struct {
 // could potentially be a MultiError, representing multiple errors. Knowing
 // and checking for this can be useful, if you wish to format it in a certain
 // way.
-func ParseTemplate(data []byte) (t *Template, err error) {
+//
+// The templates it includes are found relative to the working directory.
+func ParseTemplate(data []byte) (*Template, error) {
+	return parseTemplateFile("", data, parseJSONTemplate)
+}
+
+// templateParser returns the function that parses a template of the file
+// with the given path, by its extension, without its includes.
+func templateParser(path string) func([]byte) (*Template, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hcl":
+		return parseHCLTemplate
+	case ".yml", ".yaml":
+		return parseYAMLTemplate
+	default:
+		return parseJSONTemplate
+	}
+}
+
+// parseTemplateFile parses the template of the file with the given path
+// along with the templates it includes. The path is empty if the template
+// isn't read from a file.
+func parseTemplateFile(path string, data []byte, parse func([]byte) (*Template, error)) (*Template, error) {
+	t, err := resolveIncludes(path, data, parse, nil)
+	if t == nil {
+		return nil, err
+	}
+
+	errors := make([]error, 0)
+	if merr, ok := err.(*MultiError); ok {
+		errors = append(errors, merr.Errors...)
+	} else if err != nil {
+		errors = append(errors, err)
+	}
+
+	// Included templates don't need builders of their own, but once they
+	// are all in, there has to be one.
+	if len(t.Builders) == 0 {
+		errors = append(errors, fmt.Errorf("No builders are defined in the template."))
+	}
+
+	// Builds are selected by name or by the type of their builder, so a
+	// build can't be named for the type of a builder of another type.
+	buildNames := t.BuildNames()
+	sort.Strings(buildNames)
+	for _, name := range buildNames {
+		for _, other := range buildNames {
+			otherType := t.Builders[other].Type
+			if otherType == name && otherType != t.Builders[name].Type {
+				errors = append(errors, fmt.Errorf(
+					"builder with name '%s' is named for the type of builder '%s', which -only and -except can't tell apart",
+					name, other))
+				break
+			}
+		}
+	}
+
+	// Sensitive variables can be defined by any of the templates, so
+	// they are checked once they are all in.
+	for _, name := range t.SensitiveVariables {
+		if _, ok := t.Variables[name]; !ok {
+			errors = append(errors, fmt.Errorf(
+				"sensitive-variables: '%s' isn't a user variable of the template", name))
+		}
+	}
+
+	// Builds can be defined by any of the templates, so the builds that
+	// provisioners and post-processors run for are checked once they are
+	// all in.
+	for i, p := range t.Provisioners {
+		errors = append(errors, t.checkBuildFilter(
+			fmt.Sprintf("provisioner %d", i+1), p.Only, p.Except)...)
+		errors = append(errors, t.checkOverride(
+			fmt.Sprintf("provisioner %d", i+1), p.Override)...)
+	}
+
+	for i, sequence := range t.PostProcessors {
+		for j, pp := range sequence {
+			errors = append(errors, t.checkBuildFilter(
+				fmt.Sprintf("post-processor %d.%d", i+1, j+1), pp.Only, pp.Except)...)
+		}
+	}
+
+	if len(errors) > 0 {
+		return t, &MultiError{errors}
+	}
+
+	return t, nil
+}
+
+// resolveIncludes parses the template and merges the templates it
+// includes into it, recursively. The stack is the absolute paths of the
+// templates that include this one, to find templates that include
+// themselves with.
+func resolveIncludes(path string, data []byte, parse func([]byte) (*Template, error), stack []string) (*Template, error) {
+	t, err := parse(data)
+	if t == nil {
+		return nil, err
+	}
+
+	errors := make([]error, 0)
+	if merr, ok := err.(*MultiError); ok {
+		errors = append(errors, merr.Errors...)
+	} else if err != nil {
+		errors = append(errors, err)
+	}
+
+	// The keys and errors of an included template say which file they
+	// are in.
+	if len(stack) > 0 {
+		t.locations.name = path
+		for i, err := range t.unknownKeys {
+			t.unknownKeys[i] = fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			stack = append(stack[:len(stack):len(stack)], abs)
+		}
+	}
+
+	dir := filepath.Dir(path)
+	included := &Template{
+		Builders:        make(map[string]rawBuilderConfig),
+		Hooks:           make(map[string][]string),
+		Variables:       make(map[string]string),
+		VariableTypes:   make(map[string]string),
+		Metadata:        make(map[string]string),
+		RequiredPlugins: make(map[string]*RequiredPlugin),
+	}
+
+	for _, include := range t.includes {
+		includePath := include
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(dir, include)
+		}
+
+		fragment, err := includeTemplate(includePath, stack)
+		if fragment == nil {
+			errors = append(errors, fmt.Errorf("include '%s': %s", include, err))
+			continue
+		}
+
+		if merr, ok := err.(*MultiError); ok {
+			for _, err := range merr.Errors {
+				errors = append(errors, fmt.Errorf("include '%s': %s", include, err))
+			}
+		}
+
+		errors = append(errors, included.merge(fragment)...)
+		t.unknownKeys = append(t.unknownKeys, fragment.unknownKeys...)
+	}
+
+	// The template's own configuration comes after what it includes, so
+	// its variables override theirs.
+	if len(t.includes) > 0 {
+		errors = append(errors, included.merge(t)...)
+		t.Builders = included.Builders
+		t.Hooks = included.Hooks
+		t.PostProcessors = included.PostProcessors
+		t.Provisioners = included.Provisioners
+		t.SensitiveVariables = included.SensitiveVariables
+		t.RequiredVariables = included.RequiredVariables
+		t.Metadata = included.Metadata
+		t.RequiredPlugins = included.RequiredPlugins
+		t.Proxy = included.Proxy
+		t.Variables = included.Variables
+		t.VariableTypes = included.VariableTypes
+	}
+
+	if len(errors) > 0 {
+		return t, &MultiError{errors}
+	}
+
+	return t, nil
+}
+
+// includeTemplate reads and parses the template at the path that another
+// template includes.
+func includeTemplate(path string, stack []string) (*Template, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, including := range stack {
+		if including == abs {
+			return nil, fmt.Errorf("the template includes itself")
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveIncludes(path, data, templateParser(path), stack)
+}
+
+// merge adds the configuration of the other template to this one. The
+// provisioners and post-processors of the other template come after those
+// of this one, as do its hooks, and its variables override those of this
+// one. Builders can't have the same name in both.
+func (t *Template) merge(other *Template) []error {
+	errors := make([]error, 0)
+	for name, builder := range other.Builders {
+		if _, ok := t.Builders[name]; ok {
+			errors = append(errors, fmt.Errorf("builder with name '%s' already exists", name))
+			continue
+		}
+
+		t.Builders[name] = builder
+	}
+
+	for event, hooks := range other.Hooks {
+		t.Hooks[event] = append(t.Hooks[event], hooks...)
+	}
+
+	t.PostProcessors = append(t.PostProcessors, other.PostProcessors...)
+	t.Provisioners = append(t.Provisioners, other.Provisioners...)
+	t.SensitiveVariables = append(t.SensitiveVariables, other.SensitiveVariables...)
+
+	for k, v := range other.Variables {
+		t.Variables[k] = v
+		t.VariableTypes[k] = other.VariableTypes[k]
+	}
+
+	for k, v := range other.Metadata {
+		t.Metadata[k] = v
+	}
+
+	for name, plugin := range other.RequiredPlugins {
+		t.RequiredPlugins[name] = plugin
+	}
+
+	if other.Proxy != nil {
+		t.Proxy = other.Proxy
+	}
+
+	// A variable is required if the template that sets it last has no
+	// default for it.
+	required := make([]string, 0, len(t.RequiredVariables))
+	for _, name := range t.RequiredVariables {
+		if _, ok := other.Variables[name]; !ok {
+			required = append(required, name)
+		}
+	}
+
+	t.RequiredVariables = append(required, other.RequiredVariables...)
+	sort.Strings(t.RequiredVariables)
+
+	return errors
+}
+
+// parseJSONTemplate parses a template from its JSON, without its
+// includes.
+func parseJSONTemplate(data []byte) (t *Template, err error) {
 	var rawTpl rawTemplate
 	err = json.Unmarshal(data, &rawTpl)
 	if err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			line, char := newTemplateLocations(data).position(int(typeErr.Offset))
+			err = fmt.Errorf("Error in line %d, char %d: %s", line, char, typeErr)
+			return
+		}
+
 		syntaxErr, ok := err.(*json.SyntaxError)
 		if !ok {
 			return
@@ -92,14 +550,133 @@ func ParseTemplate(data []byte) (t *Template, err error) {
 		return
 	}
 
+	var rawKeys map[string]interface{}
+	json.Unmarshal(data, &rawKeys)
+
+	return parseTemplate(rawTpl, rawKeys, newTemplateLocations(data))
+}
+
+// parseTemplate turns the raw template into a Template. rawKeys is the
+// whole template as it was decoded, used to find the keys that Packer
+// doesn't know, and locations are where the keys are in its file.
+func parseTemplate(rawTpl rawTemplate, rawKeys map[string]interface{}, locations *templateLocations) (t *Template, err error) {
+	// A template that needs a newer Packer would likely fail with errors
+	// about keys this version doesn't know, so nothing else is checked.
+	if rawTpl.MinPackerVersion != "" {
+		if err = checkMinPackerVersion(rawTpl.MinPackerVersion); err != nil {
+			return nil, err
+		}
+	}
+
 	t = &Template{}
 	t.Builders = make(map[string]rawBuilderConfig)
 	t.Hooks = rawTpl.Hooks
 	t.PostProcessors = make([][]rawPostProcessorConfig, len(rawTpl.PostProcessors))
 	t.Provisioners = make([]rawProvisionerConfig, len(rawTpl.Provisioners))
+	t.Variables = make(map[string]string)
+	t.VariableTypes = make(map[string]string)
+	t.SensitiveVariables = rawTpl.Sensitive
+	t.Metadata = make(map[string]string)
+	t.RequiredPlugins = make(map[string]*RequiredPlugin)
+	t.includes = rawTpl.Includes
+	t.locations = locations
+
+	// Keys that Packer doesn't know are ignored, unless they are checked
+	// for with CheckUnknownKeys.
+	unknown := make([]string, 0)
+	for key := range rawKeys {
+		known := false
+		for _, templateKey := range templateKeys {
+			if key == templateKey {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+	t.unknownKeys = make([]error, len(unknown))
+	for i, key := range unknown {
+		line, char := t.locations.position(t.locations.offsets[key])
+		t.unknownKeys[i] = fmt.Errorf(
+			"%s (line %d, char %d): unknown template key: '%s'", key, line, char, key)
+	}
 
 	errors := make([]error, 0)
 
+	// The defaults of the user variables can be read from the environment,
+	// so that secrets don't have to be written into the template. A
+	// default that is a list or a map makes the variable one.
+	for k, v := range rawTpl.Variables {
+		value, typ, err := variableValue(v, processVariableDefault)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("variable '%s': %s", k, err))
+			continue
+		}
+
+		t.Variables[k] = value
+		t.VariableTypes[k] = typ
+
+		// Variables whose default is null have none, so they must be set
+		if v == nil {
+			t.RequiredVariables = append(t.RequiredVariables, k)
+		}
+	}
+
+	sort.Strings(t.RequiredVariables)
+
+	// The metadata is processed for each build, so it can only be checked
+	// to be valid here.
+	metadataTpl := new(ConfigTemplate)
+	for k, v := range rawTpl.Metadata {
+		if k == "" {
+			errors = append(errors, fmt.Errorf("metadata: keys can't be empty"))
+			continue
+		}
+
+		if err := metadataTpl.Validate(v); err != nil {
+			errors = append(errors, fmt.Errorf("metadata '%s': %s", k, err))
+			continue
+		}
+
+		t.Metadata[k] = v
+	}
+
+	for name, plugin := range rawTpl.RequiredPlugins {
+		if !IsPluginName(name) {
+			errors = append(errors, fmt.Errorf(
+				"required plugin '%s': isn't the name of a plugin binary, such as \"packer-builder-foo\"", name))
+			continue
+		}
+
+		if plugin == nil {
+			plugin = new(RequiredPlugin)
+		}
+
+		if errs := plugin.validate(); len(errs) > 0 {
+			for _, err := range errs {
+				errors = append(errors, fmt.Errorf("required plugin '%s': %s", name, err))
+			}
+
+			continue
+		}
+
+		t.RequiredPlugins[name] = plugin
+	}
+
+	// The proxy is processed for each build, like the metadata
+	if rawTpl.Proxy != nil {
+		if errs := rawTpl.Proxy.validate(); len(errs) > 0 {
+			errors = append(errors, errs...)
+		} else {
+			t.Proxy = rawTpl.Proxy
+		}
+	}
+
 	// Gather all the builders
 	for i, v := range rawTpl.Builders {
 		var raw rawBuilderConfig
@@ -120,6 +697,22 @@ func ParseTemplate(data []byte) (t *Template, err error) {
 			continue
 		}
 
+		if raw.OnError != "" && !ValidOnError(raw.OnError) {
+			errors = append(errors, fmt.Errorf(
+				"builder %d: on_error must be one of: %s",
+				i+1, strings.Join(OnErrorValues, ", ")))
+			continue
+		}
+
+		if raw.Timeout != "" {
+			if _, err := time.ParseDuration(raw.Timeout); err != nil {
+				errors = append(errors, fmt.Errorf(
+					"builder %d: timeout is not a valid duration, such as \"2h\": %s",
+					i+1, err))
+				continue
+			}
+		}
+
 		// Attempt to get the name of the builder. If the "name" key
 		// missing, use the "type" field, which is guaranteed to exist
 		// at this point.
@@ -127,13 +720,21 @@ func ParseTemplate(data []byte) (t *Template, err error) {
 			raw.Name = raw.Type
 		}
 
+		// -only and -except are lists of names separated by commas
+		if strings.Contains(raw.Name, ",") {
+			errors = append(errors, fmt.Errorf("builder %d: name '%s' can't contain commas", i+1, raw.Name))
+			continue
+		}
+
 		// Check if we already have a builder with this name and error if so
 		if _, ok := t.Builders[raw.Name]; ok {
 			errors = append(errors, fmt.Errorf("builder with name '%s' already exists", raw.Name))
 			continue
 		}
 
+		raw.path = fmt.Sprintf("builders[%d]", i)
 		raw.rawConfig = v
+		raw.locations = locations
 
 		t.Builders[raw.Name] = raw
 	}
@@ -169,7 +770,13 @@ func ParseTemplate(data []byte) (t *Template, err error) {
 				continue
 			}
 
+			config.path = fmt.Sprintf("post-processors[%d]", i)
+			if _, ok := rawV.([]interface{}); ok {
+				config.path = fmt.Sprintf("post-processors[%d][%d]", i, j)
+			}
+
 			config.rawConfig = pp
+			config.locations = locations
 		}
 	}
 
@@ -193,11 +800,32 @@ func ParseTemplate(data []byte) (t *Template, err error) {
 			continue
 		}
 
-		raw.rawConfig = v
-	}
+		if raw.PauseBefore != "" {
+			if _, err := time.ParseDuration(raw.PauseBefore); err != nil {
+				errors = append(errors, fmt.Errorf(
+					"provisioner %d: pause_before is not a valid duration, such as \"30s\": %s",
+					i+1, err))
+				continue
+			}
+		}
 
-	if len(t.Builders) == 0 {
-		errors = append(errors, fmt.Errorf("No builders are defined in the template."))
+		if raw.Timeout != "" {
+			if _, err := time.ParseDuration(raw.Timeout); err != nil {
+				errors = append(errors, fmt.Errorf(
+					"provisioner %d: timeout is not a valid duration, such as \"10m\": %s",
+					i+1, err))
+				continue
+			}
+		}
+
+		if raw.MaxRetries < 0 {
+			errors = append(errors, fmt.Errorf("provisioner %d: max_retries can't be negative", i+1))
+			continue
+		}
+
+		raw.path = fmt.Sprintf("provisioners[%d]", i)
+		raw.rawConfig = v
+		raw.locations = locations
 	}
 
 	// If there were errors, we put it into a MultiError and return
@@ -218,6 +846,11 @@ func parsePostProvisioner(i int, rawV interface{}) (result []map[string]interfac
 	case map[string]interface{}:
 		result = []map[string]interface{}{v}
 	case []interface{}:
+		if len(v) == 0 {
+			errors = []error{fmt.Errorf("Post-processor %d: sequences can't be empty", i+1)}
+			return
+		}
+
 		result = make([]map[string]interface{}, len(v))
 		errors = make([]error, 0)
 		for j, innerRawV := range v {
@@ -246,6 +879,214 @@ func parsePostProvisioner(i int, rawV interface{}) (result []map[string]interfac
 	return
 }
 
+// DefaultVariablesEnvVar is the environment variable with the default values
+// of user variables from the core configuration, as a JSON object. Packer
+// sets it for the commands it runs, which apply the values to the variables
+// that templates declare.
+const DefaultVariablesEnvVar = "PACKER_DEFAULT_VARIABLES"
+
+// SetVariables sets the values of user variables of the template, such
+// as those given on the command line, overriding their defaults. Only
+// variables that the template defines can be set, and lists and maps
+// must be set to JSON lists and objects of strings.
+func (t *Template) SetVariables(vars map[string]string) error {
+	unknown := make([]string, 0)
+	for k := range vars {
+		if _, ok := t.Variables[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown user variables: %s", strings.Join(unknown, ", "))
+	}
+
+	names := make([]string, 0, len(vars))
+	for k := range vars {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+	for _, k := range names {
+		typ := t.VariableTypes[k]
+		if err := checkVariableType(typ, vars[k]); err != nil {
+			return fmt.Errorf("User variable '%s' is a %s: %s", k, typ, err)
+		}
+	}
+
+	if t.set == nil {
+		t.set = make(map[string]struct{})
+	}
+
+	for k, v := range vars {
+		t.Variables[k] = v
+		t.set[k] = struct{}{}
+	}
+
+	// The metadata is processed again with the new values
+	t.metadata = nil
+
+	return nil
+}
+
+// MissingVariables returns the names of the required variables that
+// weren't set with SetVariables, sorted.
+func (t *Template) MissingVariables() []string {
+	missing := make([]string, 0)
+	for _, name := range t.RequiredVariables {
+		if _, ok := t.set[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// CheckVariables processes every string in the configuration of the
+// components and the metadata of the template with ConfigTemplate, as the
+// components would, and returns an error for each one that uses a user
+// variable that doesn't exist or is the wrong type, or that isn't a valid
+// template, as well as for each required variable that isn't set. This
+// finds them all at once, without preparing the builds. The data that
+// components process some settings with, such as the path of a script,
+// is made empty.
+func (t *Template) CheckVariables() error {
+	errors := make([]error, 0)
+	for _, name := range t.MissingVariables() {
+		errors = append(errors, fmt.Errorf("required user variable '%s' isn't set", name))
+	}
+
+	check := func(tpl *ConfigTemplate, locations *templateLocations, path string, raw interface{}) {
+		walkTemplateStrings(raw, "", func(key string, s string) {
+			if _, err := tpl.Process(s, nil); err != nil {
+				location, ok := locations.locate([]string{path}, key)
+				if !ok {
+					location = strings.TrimSuffix(path+"."+key, ".")
+				}
+
+				errors = append(errors, fmt.Errorf("%s: %s", location, err))
+			}
+		})
+	}
+
+	names := t.BuildNames()
+	sort.Strings(names)
+	for _, name := range names {
+		builder := t.Builders[name]
+		tpl := &ConfigTemplate{
+			UserVars: t.Variables,
+			BuildVars: map[string]string{
+				"build_name": name,
+				"build_type": builder.Type,
+			},
+		}
+
+		check(tpl, builder.locations, builder.path, builder.rawConfig)
+	}
+
+	// Provisioners and post-processors run for more than one build, so
+	// the variables of the build are empty for them.
+	tpl := &ConfigTemplate{UserVars: t.Variables}
+	for _, p := range t.Provisioners {
+		check(tpl, p.locations, p.path, p.rawConfig)
+	}
+
+	for _, sequence := range t.PostProcessors {
+		for _, pp := range sequence {
+			check(tpl, pp.locations, pp.path, pp.rawConfig)
+		}
+	}
+
+	metadata := make(map[string]interface{})
+	for k, v := range t.Metadata {
+		metadata[k] = v
+	}
+
+	check(tpl, t.locations, "metadata", metadata)
+
+	if t.Proxy != nil {
+		check(tpl, t.locations, "proxy", map[string]interface{}{
+			"http_proxy":  t.Proxy.HTTPProxy,
+			"https_proxy": t.Proxy.HTTPSProxy,
+			"no_proxy":    t.Proxy.NoProxy,
+		})
+	}
+
+	if len(errors) > 0 {
+		return &MultiError{errors}
+	}
+
+	return nil
+}
+
+// walkTemplateStrings calls the function with every string in the raw
+// configuration, and its key, such as "boot_command[1]", in order.
+func walkTemplateStrings(raw interface{}, key string, f func(string, string)) {
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+
+		return key + "." + k
+	}
+
+	switch v := raw.(type) {
+	case string:
+		f(key, v)
+	case []interface{}:
+		for i, elem := range v {
+			walkTemplateStrings(elem, fmt.Sprintf("%s[%d]", key, i), f)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkTemplateStrings(v[k], join(k), f)
+		}
+	}
+}
+
+// SensitiveValues returns the values of the sensitive variables, which
+// are to be masked with SetSensitiveValues once the variables are set.
+// The elements of lists and maps are masked on their own too, since they
+// can be used apart from each other.
+func (t *Template) SensitiveValues() []string {
+	values := make([]string, 0, len(t.SensitiveVariables))
+	for _, name := range t.SensitiveVariables {
+		value := t.Variables[name]
+		if value == "" {
+			continue
+		}
+
+		values = append(values, value)
+		if typ := t.VariableTypes[name]; typ == VariableTypeList || typ == VariableTypeMap {
+			for _, elem := range variableElements(typ, value) {
+				if elem != "" {
+					values = append(values, elem)
+				}
+			}
+		}
+	}
+
+	return values
+}
+
+// CheckUnknownKeys returns an error listing the keys at the top of the
+// template that Packer doesn't know, such as a misspelled section, along
+// with where they are. These are otherwise ignored.
+func (t *Template) CheckUnknownKeys() error {
+	if len(t.unknownKeys) > 0 {
+		return &MultiError{t.unknownKeys}
+	}
+
+	return nil
+}
+
 // BuildNames returns a slice of the available names of builds that
 // this template represents.
 func (t *Template) BuildNames() []string {
@@ -257,6 +1098,47 @@ func (t *Template) BuildNames() []string {
 	return names
 }
 
+// BuildMetadata returns the metadata of the build with the given name,
+// with its values processed with ConfigTemplate, so they can have user
+// variables and the name and type of the build. It is processed once for
+// each build, so that values made with "uuid" are the same wherever the
+// metadata is used.
+func (t *Template) BuildMetadata(name string) (map[string]string, error) {
+	builder, ok := t.Builders[name]
+	if !ok {
+		return nil, fmt.Errorf("No such build found in template: %s", name)
+	}
+
+	if metadata, ok := t.metadata[name]; ok {
+		return metadata, nil
+	}
+
+	tpl := &ConfigTemplate{
+		UserVars: t.Variables,
+		BuildVars: map[string]string{
+			"build_name": name,
+			"build_type": builder.Type,
+		},
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range t.Metadata {
+		value, err := tpl.Process(v, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error processing metadata '%s': %s", k, err)
+		}
+
+		metadata[k] = value
+	}
+
+	if t.metadata == nil {
+		t.metadata = make(map[string]map[string]string)
+	}
+
+	t.metadata[name] = metadata
+	return metadata, nil
+}
+
 // Build returns a Build for the given name.
 //
 // If the build does not exist as part of this template, an error is
@@ -297,6 +1179,12 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 		curHooks := make([]Hook, 0, len(tplHooks))
 
 		for _, hookName := range tplHooks {
+			if strings.HasPrefix(hookName, ShellHookPrefix) {
+				command := hookName[len(ShellHookPrefix):]
+				curHooks = append(curHooks, &ShellHook{Command: command})
+				continue
+			}
+
 			var hook Hook
 			hook, err = components.Hook(hookName)
 			if err != nil {
@@ -317,8 +1205,14 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 	// Prepare the post-processors
 	postProcessors := make([][]coreBuildPostProcessor, 0, len(t.PostProcessors))
 	for _, rawPPs := range t.PostProcessors {
-		current := make([]coreBuildPostProcessor, len(rawPPs))
-		for i, rawPP := range rawPPs {
+		current := make([]coreBuildPostProcessor, 0, len(rawPPs))
+		for _, rawPP := range rawPPs {
+			// A post-processor that doesn't run for this build is left out
+			// of its sequence, and the next one gets the artifact instead.
+			if !rawPP.RunsFor(name, builderConfig.Type) {
+				continue
+			}
+
 			pp, err := components.PostProcessor(rawPP.Type)
 			if err != nil {
 				return nil, err
@@ -328,20 +1222,28 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 				return nil, fmt.Errorf("PostProcessor type not found: %s", rawPP.Type)
 			}
 
-			current[i] = coreBuildPostProcessor{
+			current = append(current, coreBuildPostProcessor{
 				processor:         pp,
 				processorType:     rawPP.Type,
 				config:            rawPP.rawConfig,
 				keepInputArtifact: rawPP.KeepInputArtifact,
-			}
+				path:              rawPP.path,
+				locations:         rawPP.locations,
+			})
 		}
 
-		postProcessors = append(postProcessors, current)
+		if len(current) > 0 {
+			postProcessors = append(postProcessors, current)
+		}
 	}
 
 	// Prepare the provisioners
 	provisioners := make([]coreBuildProvisioner, 0, len(t.Provisioners))
 	for _, rawProvisioner := range t.Provisioners {
+		if !rawProvisioner.RunsFor(name, builderConfig.Type) {
+			continue
+		}
+
 		var provisioner Provisioner
 		provisioner, err = components.Provisioner(rawProvisioner.Type)
 		if err != nil {
@@ -353,27 +1255,81 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 			return
 		}
 
-		configs := make([]interface{}, 1, 2)
+		configs := make([]interface{}, 1, 3)
 		configs[0] = rawProvisioner.rawConfig
 
-		if rawProvisioner.Override != nil {
-			if override, ok := rawProvisioner.Override[name]; ok {
-				configs = append(configs, override)
+		// The overrides are looked in first for keys with errors, the last
+		// one first, since their values are the ones that are used.
+		paths := []string{rawProvisioner.path}
+
+		for _, key := range rawProvisioner.OverridesFor(name, builderConfig.Type) {
+			configs = append(configs, rawProvisioner.Override[key])
+			paths = append(
+				[]string{fmt.Sprintf("%s.override.%s", rawProvisioner.path, key)},
+				paths...)
+		}
+
+		// Each attempt has the whole timeout. It was validated when the
+		// template was parsed.
+		if rawProvisioner.Timeout != "" {
+			timeout, _ := time.ParseDuration(rawProvisioner.Timeout)
+			if timeout > 0 {
+				provisioner = &TimeoutProvisioner{timeout, provisioner}
+			}
+		}
+
+		if rawProvisioner.MaxRetries > 0 {
+			provisioner = &RetriedProvisioner{rawProvisioner.MaxRetries, provisioner}
+		}
+
+		// The pause is only before the first attempt. It was validated
+		// when the template was parsed.
+		if rawProvisioner.PauseBefore != "" {
+			pause, _ := time.ParseDuration(rawProvisioner.PauseBefore)
+			if pause > 0 {
+				provisioner = &PausedProvisioner{pause, provisioner}
 			}
 		}
 
-		coreProv := coreBuildProvisioner{provisioner, configs}
+		coreProv := coreBuildProvisioner{provisioner, rawProvisioner.Type, configs, paths, rawProvisioner.locations}
 		provisioners = append(provisioners, coreProv)
 	}
 
+	metadata, err := t.BuildMetadata(name)
+	if err != nil {
+		return
+	}
+
+	var proxy *ProxyConfig
+	if t.Proxy != nil {
+		proxy, err = t.Proxy.process(&ConfigTemplate{UserVars: t.Variables})
+		if err != nil {
+			return
+		}
+	}
+
+	// The timeout was validated when the template was parsed
+	var timeout time.Duration
+	if builderConfig.Timeout != "" {
+		timeout, _ = time.ParseDuration(builderConfig.Timeout)
+	}
+
 	b = &coreBuild{
 		name:           name,
 		builder:        builder,
 		builderConfig:  builderConfig.rawConfig,
+		builderPath:    builderConfig.path,
 		builderType:    builderConfig.Type,
+		communicators:  components.Communicators,
 		hooks:          hooks,
 		postProcessors: postProcessors,
 		provisioners:   provisioners,
+		variables:      t.Variables,
+		metadata:       metadata,
+		proxy:          proxy,
+		locations:      builderConfig.locations,
+		onError:        builderConfig.OnError,
+		timeout:        timeout,
 	}
 
 	return
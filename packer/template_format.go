@@ -0,0 +1,88 @@
+package packer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FormatTemplate rewrites the template of the file with the given path in
+// the canonical style of its format, which is used by "packer fmt": keys
+// are sorted and everything is indented by two spaces. The template isn't
+// checked beyond its syntax.
+func FormatTemplate(path string, data []byte) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hcl":
+		return formatHCL(data)
+	case ".yml", ".yaml":
+		return nil, errors.New("YAML templates can't be formatted")
+	}
+
+	var raw map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("Error parsing template: %s", err)
+	}
+
+	return FormatJSONTemplate(raw)
+}
+
+// FormatJSONTemplate writes a template, as it is decoded from JSON into
+// maps and slices, as JSON with sorted keys and two spaces of indentation,
+// ending with a newline. Unlike encoding/json, it doesn't escape "<", ">"
+// and "&", which are common in boot commands.
+func FormatJSONTemplate(raw map[string]interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(unescapeJSONHTML(data), '\n'), nil
+}
+
+// formatHCL formats an HCL template with FormatHCLTemplate. Templates with
+// comments aren't formatted, since the comments would be lost.
+func formatHCL(data []byte) (result []byte, err error) {
+	p := &hclParser{data: data, offsets: make(map[string]int)}
+
+	defer recoverSyntaxError(data, &err)
+
+	raw := p.template()
+	if p.comments {
+		return nil, errors.New("HCL templates with comments can't be formatted")
+	}
+
+	return FormatHCLTemplate(raw)
+}
+
+// unescapeJSONHTML turns the escapes that encoding/json writes for "<",
+// ">" and "&" back into the characters. Escaped backslashes are skipped
+// so that strings with a backslash before "u003c" stay as they are.
+func unescapeJSONHTML(data []byte) []byte {
+	escapes := map[string]byte{`\u003c`: '<', `\u003e`: '>', `\u0026`: '&'}
+
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' || i+1 >= len(data) {
+			result = append(result, data[i])
+			continue
+		}
+
+		if i+6 <= len(data) {
+			if c, ok := escapes[string(data[i:i+6])]; ok {
+				result = append(result, c)
+				i += 5
+				continue
+			}
+		}
+
+		result = append(result, data[i], data[i+1])
+		i++
+	}
+
+	return result
+}
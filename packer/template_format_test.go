@@ -0,0 +1,111 @@
+package packer
+
+import (
+	"testing"
+)
+
+func TestFormatTemplate_JSON(t *testing.T) {
+	data := `{"builders": [{"type": "foo", "boot_command": ["<enter>", "a&b", "\\u003c"],
+		"disk_size": 40000, "ratio": 0.50}], "variables": {"b": "", "a": "1"}}`
+
+	expected := `{
+  "builders": [
+    {
+      "boot_command": [
+        "<enter>",
+        "a&b",
+        "\\u003c"
+      ],
+      "disk_size": 40000,
+      "ratio": 0.50,
+      "type": "foo"
+    }
+  ],
+  "variables": {
+    "a": "1",
+    "b": ""
+  }
+}
+`
+
+	result, err := FormatTemplate("template.json", []byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(result) != expected {
+		t.Fatalf("bad:\n%s", result)
+	}
+
+	// Formatting is idempotent
+	again, err := FormatTemplate("template.json", result)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(again) != expected {
+		t.Fatalf("bad:\n%s", again)
+	}
+}
+
+func TestFormatTemplate_JSONInvalid(t *testing.T) {
+	if _, err := FormatTemplate("template.json", []byte(`{"builders": [}`)); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestFormatTemplate_HCL(t *testing.T) {
+	data := `
+builder "foo" { ratio = 0.5
+    disk_size = 40000
+}
+variables {b = ""
+a = "1"}
+`
+
+	expected := `variables {
+  a = "1"
+  b = ""
+}
+
+builder "foo" {
+  disk_size = 40000
+  ratio = 0.5
+}
+`
+
+	result, err := FormatTemplate("template.hcl", []byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(result) != expected {
+		t.Fatalf("bad:\n%s", result)
+	}
+}
+
+func TestFormatTemplate_HCLComments(t *testing.T) {
+	cases := []string{
+		"# foo\nbuilder \"foo\" {}",
+		"builder \"foo\" {} // foo",
+		"builder \"foo\" { /* foo */ }",
+	}
+
+	for _, tc := range cases {
+		if _, err := FormatTemplate("template.hcl", []byte(tc)); err == nil {
+			t.Fatalf("should have error: %s", tc)
+		}
+	}
+}
+
+func TestFormatTemplate_HCLInvalid(t *testing.T) {
+	if _, err := FormatTemplate("template.hcl", []byte(`builder "foo" {`)); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestFormatTemplate_YAML(t *testing.T) {
+	if _, err := FormatTemplate("template.yml", []byte("builders: []")); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -0,0 +1,488 @@
+package packer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseHCLTemplate parses a Template from HCL, which is turned into the
+// same structure as a JSON template. Builders, provisioners and
+// post-processors are blocks labeled with their type, and the other keys
+// of the template are attributes or blocks of the same name:
+//
+//	variables {
+//	  mirror = "http://example.com"
+//	}
+//
+//	builder "virtualbox" {
+//	  boot_command = ["<esc>", "<enter>"]
+//	}
+//
+//	provisioner "shell" {
+//	  inline = [
+//	    "apt-get update",
+//	  ]
+//
+//	  override "virtualbox" {
+//	    execute_command = "sudo {{.Path}}"
+//	  }
+//	}
+//
+//	post-processor "vagrant" {}
+//
+// A sequence of post-processors is a post-processors block of
+// post-processor blocks. Strings can be written over multiple lines as
+// heredocs, from "<<EOF" to a line with only "EOF" on it, and comments are
+// written with "#", "//" or "/* */".
+func ParseHCLTemplate(data []byte) (*Template, error) {
+	return parseTemplateFile("", data, parseHCLTemplate)
+}
+
+// parseHCLTemplate parses a template from its HCL, without its includes.
+func parseHCLTemplate(data []byte) (*Template, error) {
+	raw, offsets, err := parseHCL(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawTpl rawTemplate
+	if err := mapstructure.Decode(raw, &rawTpl); err != nil {
+		return nil, fmt.Errorf("Error in template: %s", err)
+	}
+
+	return parseTemplate(rawTpl, raw, &templateLocations{data: data, offsets: offsets})
+}
+
+// parseHCL parses the HCL template into the structure a JSON template has
+// when it is decoded, and returns the offsets of its keys and elements by
+// their path, like templateLocations has them.
+func parseHCL(data []byte) (result map[string]interface{}, offsets map[string]int, err error) {
+	p := &hclParser{data: data, offsets: make(map[string]int)}
+
+	defer recoverSyntaxError(data, &err)
+
+	result = p.template()
+	return result, p.offsets, nil
+}
+
+type hclTokenKind int
+
+const (
+	hclEOF hclTokenKind = iota
+	hclIdent
+	hclString
+	hclNumber
+	hclPunct
+)
+
+type hclToken struct {
+	kind   hclTokenKind
+	text   string
+	offset int
+}
+
+func (t hclToken) is(punct string) bool {
+	return t.kind == hclPunct && t.text == punct
+}
+
+type hclParser struct {
+	data    []byte
+	pos     int
+	offsets map[string]int
+
+	// The token that has been peeked at, if there is one.
+	peeked *hclToken
+
+	// Whether there are comments, which aren't kept in the result.
+	comments bool
+}
+
+func (p *hclParser) fail(offset int, message string) {
+	panic(&templateSyntaxError{offset, message})
+}
+
+// The top-level blocks that are the components of a template, and the
+// keys of the template that they are the elements of.
+var hclComponentBlocks = map[string]string{
+	"builder":        "builders",
+	"provisioner":    "provisioners",
+	"post-processor": "post-processors",
+}
+
+func (p *hclParser) template() map[string]interface{} {
+	result := make(map[string]interface{})
+	components := make(map[string][]interface{})
+
+	for {
+		key := p.next()
+		if key.kind == hclEOF {
+			break
+		}
+
+		if key.kind != hclIdent && key.kind != hclString {
+			p.fail(key.offset, fmt.Sprintf("expected a key, got '%s'", key.text))
+		}
+
+		listKey, ok := hclComponentBlocks[key.text]
+		if key.text == "post-processors" && p.peek().is("{") {
+			listKey, ok = key.text, true
+		}
+
+		if !ok {
+			p.item(result, "", key)
+			continue
+		}
+
+		if _, ok := result[listKey]; ok {
+			p.fail(key.offset, fmt.Sprintf("'%s' can't be both an attribute and blocks", listKey))
+		}
+
+		path := fmt.Sprintf("%s[%d]", listKey, len(components[listKey]))
+		if len(components[listKey]) == 0 {
+			p.offsets[listKey] = key.offset
+		}
+
+		var component interface{}
+		if key.text == "post-processors" {
+			component = p.postProcessors(path, key)
+		} else {
+			component = p.component(path, key)
+		}
+
+		components[listKey] = append(components[listKey], component)
+	}
+
+	for key, list := range components {
+		if _, ok := result[key]; ok {
+			p.fail(p.offsets[key], fmt.Sprintf("'%s' can't be both an attribute and blocks", key))
+		}
+
+		result[key] = list
+	}
+
+	return result
+}
+
+// component parses the block of a component, which is labeled with its
+// type.
+func (p *hclParser) component(path string, key hclToken) map[string]interface{} {
+	label := p.next()
+	if label.kind != hclString {
+		p.fail(label.offset, fmt.Sprintf("%s blocks must be labeled with their type", key.text))
+	}
+
+	if open := p.next(); !open.is("{") {
+		p.fail(open.offset, fmt.Sprintf("expected '{' after the %s type", key.text))
+	}
+
+	p.offsets[path] = key.offset
+	result := p.body(path)
+	if _, ok := result["type"]; ok {
+		p.fail(p.offsets[path+".type"], "the type is the label of the block")
+	}
+
+	result["type"] = label.text
+	p.offsets[path+".type"] = label.offset
+	return result
+}
+
+// postProcessors parses a block of post-processor blocks, which are run
+// in sequence.
+func (p *hclParser) postProcessors(path string, key hclToken) []interface{} {
+	p.next()
+	p.offsets[path] = key.offset
+
+	result := make([]interface{}, 0)
+	for {
+		tok := p.next()
+		if tok.is("}") {
+			return result
+		}
+
+		if tok.kind != hclIdent || tok.text != "post-processor" {
+			p.fail(tok.offset, "post-processors blocks can only have post-processor blocks")
+		}
+
+		elemPath := fmt.Sprintf("%s[%d]", path, len(result))
+		result = append(result, p.component(elemPath, tok))
+	}
+}
+
+// body parses the attributes and blocks within a block, up to the closing
+// brace.
+func (p *hclParser) body(path string) map[string]interface{} {
+	result := make(map[string]interface{})
+	for {
+		key := p.next()
+		switch {
+		case key.is("}"):
+			return result
+		case key.is(","):
+			continue
+		case key.kind == hclEOF:
+			p.fail(key.offset, "expected '}' at the end of the block")
+		case key.kind != hclIdent && key.kind != hclString:
+			p.fail(key.offset, fmt.Sprintf("expected a key, got '%s'", key.text))
+		}
+
+		p.item(result, path, key)
+	}
+}
+
+// item parses an attribute or block with the given key into the result.
+// Labeled blocks are maps from their labels to their contents, so that
+// multiple blocks with the same key can be given.
+func (p *hclParser) item(result map[string]interface{}, path string, key hclToken) {
+	keyPath := key.text
+	if path != "" {
+		keyPath = path + "." + key.text
+	}
+
+	tok := p.next()
+	switch {
+	case tok.is("="):
+		if _, ok := result[key.text]; ok {
+			p.fail(key.offset, fmt.Sprintf("duplicate key '%s'", key.text))
+		}
+
+		p.offsets[keyPath] = key.offset
+		result[key.text] = p.value(keyPath)
+	case tok.is("{"):
+		if _, ok := result[key.text]; ok {
+			p.fail(key.offset, fmt.Sprintf("duplicate key '%s'", key.text))
+		}
+
+		p.offsets[keyPath] = key.offset
+		result[key.text] = p.body(keyPath)
+	case tok.kind == hclString:
+		if open := p.next(); !open.is("{") {
+			p.fail(open.offset, "expected '{' after the label of the block")
+		}
+
+		var blocks map[string]interface{}
+		if existing, ok := result[key.text]; ok {
+			blocks, ok = existing.(map[string]interface{})
+			if !ok {
+				p.fail(key.offset, fmt.Sprintf("duplicate key '%s'", key.text))
+			}
+		} else {
+			blocks = make(map[string]interface{})
+			result[key.text] = blocks
+			p.offsets[keyPath] = key.offset
+		}
+
+		if _, ok := blocks[tok.text]; ok {
+			p.fail(tok.offset, fmt.Sprintf("duplicate block '%s \"%s\"'", key.text, tok.text))
+		}
+
+		labelPath := keyPath + "." + tok.text
+		p.offsets[labelPath] = tok.offset
+		blocks[tok.text] = p.body(labelPath)
+	default:
+		p.fail(tok.offset, fmt.Sprintf("expected '=' or a block after '%s'", key.text))
+	}
+}
+
+func (p *hclParser) value(path string) interface{} {
+	tok := p.next()
+	switch {
+	case tok.kind == hclString:
+		return tok.text
+	case tok.kind == hclNumber:
+		if i, err := strconv.ParseInt(tok.text, 10, 0); err == nil {
+			return int(i)
+		}
+
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			p.fail(tok.offset, fmt.Sprintf("invalid number '%s'", tok.text))
+		}
+
+		return f
+	case tok.kind == hclIdent && tok.text == "true":
+		return true
+	case tok.kind == hclIdent && tok.text == "false":
+		return false
+	case tok.is("["):
+		result := make([]interface{}, 0)
+		for {
+			if p.peek().is("]") {
+				p.next()
+				return result
+			}
+
+			elemPath := fmt.Sprintf("%s[%d]", path, len(result))
+			p.offsets[elemPath] = p.peek().offset
+			result = append(result, p.value(elemPath))
+
+			// A comma may be the last thing in the list
+			next := p.next()
+			if next.is("]") {
+				return result
+			}
+
+			if !next.is(",") {
+				p.fail(next.offset, "expected ',' or ']' in list")
+			}
+		}
+	case tok.is("{"):
+		return p.body(path)
+	case tok.kind == hclEOF:
+		p.fail(tok.offset, "expected a value at the end of the template")
+	}
+
+	p.fail(tok.offset, fmt.Sprintf("expected a value, got '%s'", tok.text))
+	return nil
+}
+
+func (p *hclParser) peek() hclToken {
+	if p.peeked == nil {
+		tok := p.scan()
+		p.peeked = &tok
+	}
+
+	return *p.peeked
+}
+
+func (p *hclParser) next() hclToken {
+	tok := p.peek()
+	p.peeked = nil
+	return tok
+}
+
+// scan reads the next token of the template.
+func (p *hclParser) scan() hclToken {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return hclToken{hclEOF, "end of template", p.pos}
+	}
+
+	start := p.pos
+	c := p.data[p.pos]
+	switch {
+	case c == '"':
+		return hclToken{hclString, p.quoted(), start}
+	case c == '<' && bytes.HasPrefix(p.data[p.pos:], []byte("<<")):
+		return hclToken{hclString, p.heredoc(), start}
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.pos++
+		for p.pos < len(p.data) && strings.ContainsRune("0123456789.eE+-", rune(p.data[p.pos])) {
+			p.pos++
+		}
+
+		return hclToken{hclNumber, string(p.data[start:p.pos]), start}
+	case strings.ContainsRune("{}[],=", rune(c)):
+		p.pos++
+		return hclToken{hclPunct, string(c), start}
+	case isHCLIdentRune(rune(c)):
+		for p.pos < len(p.data) && (isHCLIdentRune(rune(p.data[p.pos])) ||
+			strings.ContainsRune("0123456789-.", rune(p.data[p.pos]))) {
+			p.pos++
+		}
+
+		return hclToken{hclIdent, string(p.data[start:p.pos]), start}
+	}
+
+	p.fail(start, fmt.Sprintf("unexpected character '%c'", c))
+	return hclToken{}
+}
+
+func (p *hclParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch {
+		case unicode.IsSpace(rune(p.data[p.pos])):
+			p.pos++
+		case p.data[p.pos] == '#' || bytes.HasPrefix(p.data[p.pos:], []byte("//")):
+			p.comments = true
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case bytes.HasPrefix(p.data[p.pos:], []byte("/*")):
+			p.comments = true
+			end := bytes.Index(p.data[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				p.fail(p.pos, "unterminated comment")
+			}
+
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+// quoted reads a string in double quotes, whose escapes are those of Go.
+func (p *hclParser) quoted() string {
+	start := p.pos
+	for p.pos++; p.pos < len(p.data) && p.data[p.pos] != '"'; p.pos++ {
+		if p.data[p.pos] == '\\' {
+			p.pos++
+		}
+
+		if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+			p.fail(start, "unterminated string, use a heredoc for multiple lines")
+		}
+	}
+
+	if p.pos >= len(p.data) {
+		p.fail(start, "unterminated string")
+	}
+
+	p.pos++
+	result, err := strconv.Unquote(string(p.data[start:p.pos]))
+	if err != nil {
+		p.fail(start, "invalid escape in string")
+	}
+
+	return result
+}
+
+// heredoc reads a string from the lines after "<<MARKER" up to a line that
+// is only the marker. The string ends with a newline.
+func (p *hclParser) heredoc() string {
+	start := p.pos
+	lineEnd := bytes.IndexByte(p.data[p.pos:], '\n')
+	if lineEnd < 0 {
+		p.fail(start, "heredoc without any lines")
+	}
+
+	marker := strings.TrimSpace(string(p.data[p.pos+2 : p.pos+lineEnd]))
+	if marker == "" || strings.IndexFunc(marker, func(r rune) bool {
+		return !isHCLIdentRune(r) && !unicode.IsDigit(r)
+	}) >= 0 {
+		p.fail(start, "invalid heredoc marker")
+	}
+
+	p.pos += lineEnd + 1
+
+	var buf bytes.Buffer
+	for {
+		if p.pos >= len(p.data) {
+			p.fail(start, fmt.Sprintf("heredoc isn't ended with '%s'", marker))
+		}
+
+		end := bytes.IndexByte(p.data[p.pos:], '\n')
+		next := p.pos + end + 1
+		if end < 0 {
+			end = len(p.data) - p.pos
+			next = len(p.data)
+		}
+
+		line := strings.TrimRight(string(p.data[p.pos:p.pos+end]), "\r")
+		p.pos = next
+		if strings.TrimSpace(line) == marker {
+			return buf.String()
+		}
+
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+}
+
+func isHCLIdentRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
@@ -0,0 +1,358 @@
+package packer
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The keys that can be written in HCL without quotes.
+var hclKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// FormatHCLTemplate writes a template, as it is decoded from JSON into
+// maps and slices, as HCL that ParseHCLTemplate parses back into the same
+// template. This converts JSON templates to HCL.
+func FormatHCLTemplate(raw map[string]interface{}) ([]byte, error) {
+	w := new(hclWriter)
+
+	if variables, ok := raw["variables"]; ok {
+		w.section()
+		w.attribute("variables", variables, "variables")
+	}
+
+	for _, key := range []string{"builders", "provisioners"} {
+		raw, ok := raw[key]
+		if !ok {
+			continue
+		}
+
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'%s' must be a list", key)
+		}
+
+		for i, component := range list {
+			w.section()
+			w.component(key[:len(key)-1], component, fmt.Sprintf("%s[%d]", key, i))
+		}
+	}
+
+	if raw, ok := raw["post-processors"]; ok {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'post-processors' must be a list")
+		}
+
+		for i, pp := range list {
+			path := fmt.Sprintf("post-processors[%d]", i)
+			w.section()
+
+			sequence, ok := pp.([]interface{})
+			if !ok {
+				w.postProcessor(pp, path)
+				continue
+			}
+
+			w.line("post-processors {")
+			w.indent++
+			for j, pp := range sequence {
+				if j > 0 {
+					w.buf.WriteByte('\n')
+				}
+
+				w.postProcessor(pp, fmt.Sprintf("%s[%d]", path, j))
+			}
+			w.indent--
+			w.line("}")
+		}
+	}
+
+	// Any other keys, such as hooks, are written as they are
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		switch key {
+		case "builders", "post-processors", "provisioners", "variables":
+		default:
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		w.section()
+		w.attribute(key, raw[key], key)
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+type hclWriter struct {
+	buf    bytes.Buffer
+	indent int
+	err    error
+}
+
+func (w *hclWriter) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf(format, args...)
+	}
+}
+
+// section separates the top-level blocks of the template with an empty
+// line.
+func (w *hclWriter) section() {
+	if w.buf.Len() > 0 {
+		w.buf.WriteByte('\n')
+	}
+}
+
+func (w *hclWriter) prefix() {
+	w.buf.WriteString(strings.Repeat("  ", w.indent))
+}
+
+func (w *hclWriter) line(format string, args ...interface{}) {
+	w.prefix()
+	fmt.Fprintf(&w.buf, format, args...)
+	w.buf.WriteByte('\n')
+}
+
+// component writes the block of a builder, provisioner or post-processor,
+// which is labeled with its type.
+func (w *hclWriter) component(name string, raw interface{}, path string) {
+	config, ok := raw.(map[string]interface{})
+	if !ok {
+		w.fail("%s: must be an object", path)
+		return
+	}
+
+	componentType, ok := config["type"].(string)
+	if !ok {
+		w.fail("%s: missing 'type'", path)
+		return
+	}
+
+	w.block(name, strconv.Quote(componentType), config, path, "type")
+}
+
+func (w *hclWriter) postProcessor(raw interface{}, path string) {
+	if name, ok := raw.(string); ok {
+		w.line("post-processor %s {}", strconv.Quote(name))
+		return
+	}
+
+	w.component("post-processor", raw, path)
+}
+
+// block writes a block with the contents of the map, without the given
+// key.
+func (w *hclWriter) block(name string, label string, contents map[string]interface{}, path string, skip string) {
+	header := name
+	if label != "" {
+		header += " " + label
+	}
+
+	if len(contents) == 0 || (len(contents) == 1 && skip != "") {
+		w.line("%s {}", header)
+		return
+	}
+
+	w.line("%s {", header)
+	w.indent++
+	w.body(contents, path, skip)
+	w.indent--
+	w.line("}")
+}
+
+// body writes the keys of the map as attributes and blocks, in order.
+// Blocks are separated from what comes before them by an empty line.
+func (w *hclWriter) body(contents map[string]interface{}, path string, skip string) {
+	keys := make([]string, 0, len(contents))
+	for key := range contents {
+		if key != skip {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	for i, key := range keys {
+		if _, ok := contents[key].(map[string]interface{}); ok && i > 0 {
+			w.buf.WriteByte('\n')
+		}
+
+		w.attribute(key, contents[key], path+"."+key)
+	}
+}
+
+// attribute writes the key and its value. Maps are written as blocks, and
+// maps of maps as blocks labeled with their keys.
+func (w *hclWriter) attribute(key string, value interface{}, path string) {
+	name := hclKey(key)
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		labeled := len(v) > 0
+		for _, inner := range v {
+			if _, ok := inner.(map[string]interface{}); !ok {
+				labeled = false
+			}
+		}
+
+		if !labeled {
+			w.block(name, "", v, path, "")
+			return
+		}
+
+		labels := make([]string, 0, len(v))
+		for label := range v {
+			labels = append(labels, label)
+		}
+
+		sort.Strings(labels)
+		for i, label := range labels {
+			if i > 0 {
+				w.buf.WriteByte('\n')
+			}
+
+			contents := v[label].(map[string]interface{})
+			w.block(name, strconv.Quote(label), contents, path+"."+label, "")
+		}
+	case string:
+		if marker := heredocMarker(v); marker != "" {
+			w.line("%s = <<%s", name, marker)
+			w.buf.WriteString(v)
+			w.buf.WriteString(marker + "\n")
+			return
+		}
+
+		w.line("%s = %s", name, strconv.Quote(v))
+	default:
+		w.prefix()
+		w.buf.WriteString(name + " = ")
+		w.expr(value, path)
+		w.buf.WriteByte('\n')
+	}
+}
+
+// expr writes a value within an attribute, starting on the current line.
+func (w *hclWriter) expr(value interface{}, path string) {
+	switch v := value.(type) {
+	case string:
+		w.buf.WriteString(strconv.Quote(v))
+	case bool:
+		w.buf.WriteString(strconv.FormatBool(v))
+	case int:
+		w.buf.WriteString(strconv.Itoa(v))
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			w.buf.WriteString(strconv.FormatInt(int64(v), 10))
+		} else {
+			w.buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	case []interface{}:
+		w.list(v, path)
+	case map[string]interface{}:
+		if len(v) == 0 {
+			w.buf.WriteString("{}")
+			return
+		}
+
+		w.buf.WriteString("{\n")
+		w.indent++
+		w.body(v, path, "")
+		w.indent--
+		w.prefix()
+		w.buf.WriteString("}")
+	case nil:
+		w.fail("%s: null can't be written in HCL", path)
+	default:
+		w.fail("%s: can't write a %T in HCL", path, value)
+	}
+}
+
+// list writes a list on one line if it is short and only has scalars, and
+// with an element on each line otherwise.
+func (w *hclWriter) list(list []interface{}, path string) {
+	short := true
+	length := 0
+	for _, elem := range list {
+		switch v := elem.(type) {
+		case string:
+			length += len(v) + 4
+		case []interface{}, map[string]interface{}:
+			short = false
+		default:
+			length += 8
+		}
+	}
+
+	if short && length <= 60 {
+		w.buf.WriteString("[")
+		for i, elem := range list {
+			if i > 0 {
+				w.buf.WriteString(", ")
+			}
+
+			w.expr(elem, fmt.Sprintf("%s[%d]", path, i))
+		}
+		w.buf.WriteString("]")
+		return
+	}
+
+	w.buf.WriteString("[\n")
+	w.indent++
+	for i, elem := range list {
+		w.prefix()
+		w.expr(elem, fmt.Sprintf("%s[%d]", path, i))
+		w.buf.WriteString(",\n")
+	}
+	w.indent--
+	w.prefix()
+	w.buf.WriteString("]")
+}
+
+// hclKey returns the key as it is written in HCL, quoted if it has to be.
+func hclKey(key string) string {
+	if hclKeyPattern.MatchString(key) {
+		return key
+	}
+
+	return strconv.Quote(key)
+}
+
+// heredocMarker returns the marker to write the string as a heredoc with,
+// or "" if it isn't written as one. Only strings of lines, which end with
+// a newline, are. Strings with carriage returns aren't, since a heredoc
+// can't keep them.
+func heredocMarker(s string) string {
+	if !strings.Contains(s, "\n") || !strings.HasSuffix(s, "\n") || strings.Contains(s, "\r") {
+		return ""
+	}
+
+	lines := strings.Split(s, "\n")
+	for i := 0; ; i++ {
+		marker := "EOF"
+		if i > 0 {
+			marker = fmt.Sprintf("EOF%d", i)
+		}
+
+		used := false
+		for _, line := range lines {
+			if strings.TrimSpace(line) == marker {
+				used = true
+				break
+			}
+		}
+
+		if !used {
+			return marker
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package packer
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFormatHCLJson = `{
+  "variables": {"mirror": "http://example.com"},
+  "builders": [
+    {
+      "type": "virtualbox",
+      "name": "vbox",
+      "boot_command": ["<esc>", "<enter>"],
+      "disk_size": 40000,
+      "vboxmanage": [["modifyvm", "{{.Name}}", "--memory", "1024"]],
+      "vmx_data": {"ethernet0.present": "TRUE", "scsi0:0.present": "TRUE"}
+    },
+    {"type": "amazon-ebs", "region": "us-east-1"}
+  ],
+  "provisioners": [
+    {
+      "type": "shell",
+      "inline": ["apt-get update", "echo \"done\""],
+      "execute_command": "#!/bin/sh\nEOF\n{{.Path}}\n",
+      "override": {"vbox": {"remote_path": "/tmp/a.sh"}}
+    }
+  ],
+  "post-processors": [
+    "vagrant",
+    [{"type": "compress", "keep_input_artifact": true}, {"type": "upload", "ratio": 0.5}]
+  ],
+  "hooks": {"foo": ["bar"]}
+}`
+
+func TestFormatHCLTemplate(t *testing.T) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(testFormatHCLJson), &raw); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := FormatHCLTemplate(raw)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, expected := range []string{
+		"variables {\n  mirror = \"http://example.com\"\n}\n",
+		"builder \"amazon-ebs\" {\n  region = \"us-east-1\"\n}\n",
+		"  execute_command = <<EOF1\n#!/bin/sh\nEOF\n{{.Path}}\nEOF1\n",
+		"  override \"vbox\" {\n    remote_path = \"/tmp/a.sh\"\n  }\n",
+		"    ethernet0.present = \"TRUE\"\n    \"scsi0:0.present\" = \"TRUE\"\n",
+		"post-processor \"vagrant\" {}\n",
+		"post-processors {\n  post-processor \"compress\" {\n",
+	} {
+		if !strings.Contains(string(result), expected) {
+			t.Fatalf("should contain %q:\n%s", expected, result)
+		}
+	}
+
+	// The HCL is the same template as the JSON
+	tpl, err := ParseHCLTemplate(result)
+	if err != nil {
+		t.Fatalf("err: %s\n%s", err, result)
+	}
+
+	expected, err := ParseTemplate([]byte(testFormatHCLJson))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(tpl.Variables, expected.Variables) ||
+		!reflect.DeepEqual(tpl.Hooks, expected.Hooks) {
+		t.Fatalf("bad: %#v", tpl)
+	}
+
+	raws := func(tpl *Template) []interface{} {
+		result := []interface{}{tpl.Builders["vbox"].rawConfig, tpl.Builders["amazon-ebs"].rawConfig}
+		for _, p := range tpl.Provisioners {
+			result = append(result, p.rawConfig)
+		}
+
+		for _, pps := range tpl.PostProcessors {
+			for _, pp := range pps {
+				result = append(result, pp.Type, pp.KeepInputArtifact)
+			}
+		}
+
+		// Numbers are ints in HCL and floats in JSON, so compare them
+		// as JSON does.
+		data, _ := json.Marshal(result)
+		json.Unmarshal(data, &result)
+		return result
+	}
+
+	if !reflect.DeepEqual(raws(tpl), raws(expected)) {
+		t.Fatalf("bad:\n%#v\n%#v", raws(tpl), raws(expected))
+	}
+}
+
+func TestFormatHCLTemplate_CarriageReturn(t *testing.T) {
+	raw := map[string]interface{}{
+		"variables": map[string]interface{}{"script": "echo foo\r\necho bar\r\n"},
+		"builders":  []interface{}{map[string]interface{}{"type": "something"}},
+	}
+
+	result, err := FormatHCLTemplate(raw)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if strings.Contains(string(result), "<<") {
+		t.Fatalf("should not be a heredoc:\n%s", result)
+	}
+
+	tpl, err := ParseHCLTemplate(result)
+	if err != nil {
+		t.Fatalf("err: %s\n%s", err, result)
+	}
+
+	if tpl.Variables["script"] != "echo foo\r\necho bar\r\n" {
+		t.Fatalf("bad: %q", tpl.Variables["script"])
+	}
+}
+
+func TestFormatHCLTemplate_Invalid(t *testing.T) {
+	cases := []map[string]interface{}{
+		{"builders": "foo"},
+		{"builders": []interface{}{map[string]interface{}{"name": "foo"}}},
+		{"builders": []interface{}{map[string]interface{}{"type": "foo", "bar": nil}}},
+	}
+
+	for _, raw := range cases {
+		if _, err := FormatHCLTemplate(raw); err == nil {
+			t.Fatalf("should have error: %#v", raw)
+		}
+	}
+}
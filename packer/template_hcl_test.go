@@ -0,0 +1,173 @@
+package packer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHCLTemplate(t *testing.T) {
+	data := `
+# A comment
+variables {
+  mirror = "http://example.com"
+}
+
+builder "virtualbox" {
+  name = "foo"
+  boot_command = ["<esc>", "<enter>"]
+  disk_size = 40000
+
+  vboxmanage = [
+    ["modifyvm", "{{.Name}}", "--memory", "1024"],
+  ]
+}
+
+// Another comment
+provisioner "shell" {
+  inline = <<EOF
+apt-get update
+  apt-get install -y nginx
+EOF
+
+  override "foo" {
+    remote_path = "/tmp/script.sh"
+  }
+}
+
+post-processor "vagrant" {}
+
+/* A sequence of post-processors */
+post-processors {
+  post-processor "compress" {
+    keep_input_artifact = true
+  }
+
+  post-processor "upload" {}
+}
+`
+
+	result, err := ParseHCLTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Variables["mirror"] != "http://example.com" {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+
+	builder, ok := result.Builders["foo"]
+	if !ok || builder.Type != "virtualbox" {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	expected := map[string]interface{}{
+		"type":         "virtualbox",
+		"name":         "foo",
+		"boot_command": []interface{}{"<esc>", "<enter>"},
+		"disk_size":    40000,
+		"vboxmanage": []interface{}{
+			[]interface{}{"modifyvm", "{{.Name}}", "--memory", "1024"},
+		},
+	}
+
+	if !reflect.DeepEqual(builder.rawConfig, expected) {
+		t.Fatalf("bad: %#v", builder.rawConfig)
+	}
+
+	prov := result.Provisioners[0]
+	config := prov.rawConfig.(map[string]interface{})
+	if config["inline"] != "apt-get update\n  apt-get install -y nginx\n" {
+		t.Fatalf("bad: %#v", config["inline"])
+	}
+
+	override := prov.Override["foo"].(map[string]interface{})
+	if override["remote_path"] != "/tmp/script.sh" {
+		t.Fatalf("bad: %#v", prov.Override)
+	}
+
+	if len(result.PostProcessors) != 2 || len(result.PostProcessors[1]) != 2 {
+		t.Fatalf("bad: %#v", result.PostProcessors)
+	}
+
+	pp := result.PostProcessors[1][0]
+	if pp.Type != "compress" || pp.KeepInputArtifact == nil || !*pp.KeepInputArtifact || pp.path != "post-processors[1][0]" {
+		t.Fatalf("bad: %#v", pp)
+	}
+}
+
+func TestParseHCLTemplate_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"builder \"foo\" {\n  a = \n}\n":               "Error in line 3, char 1",
+		"builder foo {}\n":                             "Error in line 1, char 9",
+		"builder \"foo\" {\n  a = 1\n  a = 2\n}\n":     "Error in line 3, char 3",
+		"builder \"foo\" {\n  type = \"bar\"\n}\n":     "the type is the label",
+		"builder \"foo\" {\n  a = \"b\n\"}\n":          "Error in line 2, char 7",
+		"builder \"foo\" {\n  a = <<EOF\nb\n}\n":       "isn't ended with 'EOF'",
+		"builders = []\nbuilder \"foo\" {}\n":          "both an attribute and blocks",
+		"post-processors {\n  builder \"foo\" {}\n}\n": "only have post-processor blocks",
+		"builder \"foo\" {\n  /* a = 1\n}\n":           "unterminated comment",
+		"builder \"foo\" {\n  a = [1, 2\n}\n":          "Error in line 3, char 1",
+	}
+
+	for data, expected := range cases {
+		_, err := ParseHCLTemplate([]byte(data))
+		if err == nil {
+			t.Fatalf("should have error: %q", data)
+		}
+
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("bad %q: %s", data, err)
+		}
+	}
+}
+
+func TestParseHCLTemplate_Locations(t *testing.T) {
+	data := `
+builder "foo" {
+  boot_command = [
+    "a",
+  ]
+}
+
+provisioner "shell" {
+  override "foo" {
+    script = "a"
+  }
+}
+
+bogus = true
+`
+
+	result, err := ParseHCLTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	location, ok := result.locations.locate([]string{"builders[0]"}, "boot_command[0]")
+	if !ok || location != "builders[0].boot_command[0] (line 4, char 5)" {
+		t.Fatalf("bad: %s", location)
+	}
+
+	location, ok = result.locations.locate([]string{"builders[0]"}, "type")
+	if !ok || location != "builders[0].type (line 2, char 9)" {
+		t.Fatalf("bad: %s", location)
+	}
+
+	paths := []string{"provisioners[0].override.foo", "provisioners[0]"}
+	location, ok = result.locations.locate(paths, "script")
+	if !ok || location != "provisioners[0].override.foo.script (line 10, char 5)" {
+		t.Fatalf("bad: %s", location)
+	}
+
+	err = result.CheckUnknownKeys()
+	if err == nil || !strings.Contains(err.Error(), "bogus (line 14, char 1)") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplateFile_HCL(t *testing.T) {
+	if _, err := ParseTemplateFile("template.hcl", []byte(`builder "foo" {}`)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,238 @@
+package packer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+	"time"
+)
+
+// Lint returns warnings about the parts of the template that are valid
+// but likely mistakes, sorted: user variables that nothing uses,
+// provisioners and post-processors whose only or except lists leave no
+// build for them to run for, and timeouts of zero, which either expire at
+// once or are ignored. Each warning says where in the template it is.
+func (t *Template) Lint() []string {
+	warnings := make([]string, 0)
+	warn := func(locations *templateLocations, path string, key string, message string) {
+		location, ok := locations.locate([]string{path}, key)
+		if !ok {
+			location = strings.TrimSuffix(path+"."+key, ".")
+		}
+
+		warnings = append(warnings, fmt.Sprintf("%s: %s", location, message))
+	}
+
+	// The raw configurations of the components, by their paths
+	type component struct {
+		path      string
+		raw       interface{}
+		locations *templateLocations
+	}
+
+	components := make([]component, 0)
+	for _, builder := range t.Builders {
+		components = append(components, component{builder.path, builder.rawConfig, builder.locations})
+	}
+
+	for _, p := range t.Provisioners {
+		components = append(components, component{p.path, p.rawConfig, p.locations})
+	}
+
+	for _, sequence := range t.PostProcessors {
+		for _, pp := range sequence {
+			components = append(components, component{pp.path, pp.rawConfig, pp.locations})
+		}
+	}
+
+	used := make(map[string]bool)
+	markUsed := func(_ string, s string) {
+		for _, name := range templateUserVariables(s) {
+			used[name] = true
+		}
+	}
+
+	for _, c := range components {
+		walkTemplateStrings(c.raw, "", markUsed)
+		walkTimeouts(c.raw, "", func(key string) {
+			warn(c.locations, c.path, key, "the timeout is zero, so it either expires at once or is ignored")
+		})
+	}
+
+	for _, v := range t.Metadata {
+		markUsed("", v)
+	}
+
+	if t.Proxy != nil {
+		markUsed("", t.Proxy.HTTPProxy)
+		markUsed("", t.Proxy.HTTPSProxy)
+		markUsed("", t.Proxy.NoProxy)
+	}
+
+	for name := range t.Variables {
+		if !used[name] {
+			warn(t.locations, "variables", name, fmt.Sprintf("user variable '%s' isn't used", name))
+		}
+	}
+
+	// Provisioners and post-processors that run for no build, such as
+	// because except lists every build, can't do anything.
+	if len(t.Builders) > 0 {
+		runsForAny := func(only []string, except []string) bool {
+			for name, builder := range t.Builders {
+				if runsForBuild(only, except, name, builder.Type) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		filterKey := func(only []string) string {
+			if len(only) > 0 {
+				return "only"
+			}
+
+			return "except"
+		}
+
+		for _, p := range t.Provisioners {
+			if !runsForAny(p.Only, p.Except) {
+				warn(p.locations, p.path, filterKey(p.Only),
+					fmt.Sprintf("the %s provisioner doesn't run for any build", p.Type))
+			}
+		}
+
+		for _, sequence := range t.PostProcessors {
+			for _, pp := range sequence {
+				if !runsForAny(pp.Only, pp.Except) {
+					warn(pp.locations, pp.path, filterKey(pp.Only),
+						fmt.Sprintf("the %s post-processor doesn't run for any build", pp.Type))
+				}
+			}
+		}
+	}
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// templateUserVariables returns the names of the user variables that the
+// string uses, as a template processed with ConfigTemplate. Strings that
+// aren't valid templates use none.
+func templateUserVariables(s string) []string {
+	tpl, err := new(ConfigTemplate).parse(s)
+	if err != nil || tpl.Tree == nil {
+		return nil
+	}
+
+	result := make([]string, 0)
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			// The functions that read user variables take the name as
+			// their argument, such as {{user "foo"}}.
+			if len(n.Args) > 1 {
+				ident, ok := n.Args[0].(*parse.IdentifierNode)
+				name, isString := n.Args[1].(*parse.StringNode)
+				if ok && isString && strings.HasPrefix(ident.Ident, "user") {
+					result = append(result, name.Text)
+				}
+			}
+
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		}
+	}
+
+	walk(tpl.Tree.Root)
+	return result
+}
+
+// walkTimeouts calls the function with the key of every timeout in the raw
+// configuration that is zero. Timeouts are the keys named "timeout" or
+// ending in "_timeout", which are durations such as "5m", or numbers.
+func walkTimeouts(raw interface{}, key string, f func(string)) {
+	isTimeout := func(k string) bool {
+		return k == "timeout" || strings.HasSuffix(k, "_timeout")
+	}
+
+	switch v := raw.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			walkTimeouts(elem, fmt.Sprintf("%s[%d]", key, i), f)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+		for _, k := range keys {
+			full := k
+			if key != "" {
+				full = key + "." + k
+			}
+
+			if !isTimeout(k) {
+				walkTimeouts(v[k], full, f)
+				continue
+			}
+
+			zero := false
+			switch value := v[k].(type) {
+			case string:
+				d, err := time.ParseDuration(value)
+				zero = err == nil && d == 0
+			case float64:
+				zero = value == 0
+			case int:
+				zero = value == 0
+			case json.Number:
+				n, err := value.Float64()
+				zero = err == nil && n == 0
+			}
+
+			if zero {
+				f(full)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package packer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplate_Lint(t *testing.T) {
+	data := `
+	{
+		"variables": {"region": "us-east-1", "unused": "", "names": ["a"], "version": "1"},
+		"metadata": {"version": "{{user \"version\"}}"},
+		"builders": [
+			{"type": "test-builder", "region": "{{upper (user \"region\")}}", "ssh_timeout": "0s"},
+			{"name": "other", "type": "test-builder", "timeout": "2h", "ssh_wait_timeout": 0}
+		],
+		"provisioners": [
+			{
+				"type": "test-prov",
+				"inline": ["{{range user_list \"names\"}}{{.}}{{end}}"],
+				"timeout": "0m"
+			},
+			{"type": "test-prov", "except": ["test-builder", "other"]}
+		],
+		"post-processors": [{"type": "compress", "only": ["other"]}]
+	}
+	`
+
+	tpl, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{
+		"builders[0].ssh_timeout (line 6, char 70): the timeout is zero, so it either expires at once or is ignored",
+		"builders[1].ssh_wait_timeout (line 7, char 63): the timeout is zero, so it either expires at once or is ignored",
+		"provisioners[0].timeout (line 13, char 5): the timeout is zero, so it either expires at once or is ignored",
+		"provisioners[1].except (line 15, char 26): the test-prov provisioner doesn't run for any build",
+		"variables.unused (line 3, char 40): user variable 'unused' isn't used",
+	}
+
+	if warnings := tpl.Lint(); !reflect.DeepEqual(warnings, expected) {
+		t.Fatalf("bad: %#v", warnings)
+	}
+}
+
+func TestTemplate_Lint_Empty(t *testing.T) {
+	tpl, err := ParseTemplate([]byte(`{"builders": [{"type": "test-builder"}]}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if warnings := tpl.Lint(); len(warnings) != 0 {
+		t.Fatalf("bad: %#v", warnings)
+	}
+}
@@ -0,0 +1,219 @@
+package packer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// templateLocations knows where the keys of a template are within its
+// JSON, so that errors with them can say where to look. Keys are named by
+// their path, such as "builders[0].boot_command[1]".
+type templateLocations struct {
+	data    []byte
+	offsets map[string]int
+
+	// The path of the file the template is included from, which is empty
+	// for the template itself.
+	name string
+}
+
+// newTemplateLocations finds the keys in the JSON of a template, which
+// must be valid.
+func newTemplateLocations(data []byte) *templateLocations {
+	s := &jsonScanner{data: data, offsets: make(map[string]int)}
+	s.value("")
+
+	return &templateLocations{data: data, offsets: s.offsets}
+}
+
+// locate returns the path and position in the template of the key of the
+// configuration at the given path. If the key itself isn't in the
+// template, such as an element of a map, the nearest key that contains it
+// is used. The paths are tried in order for each key.
+func (l *templateLocations) locate(paths []string, key string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+
+	for key != "" {
+		for _, path := range paths {
+			full := path + "." + key
+			if offset, ok := l.offsets[full]; ok {
+				line, char := l.position(offset)
+				location := fmt.Sprintf("%s (line %d, char %d)", full, line, char)
+				if l.name != "" {
+					location = l.name + ": " + location
+				}
+
+				return location, true
+			}
+		}
+
+		idx := strings.LastIndexAny(key, ".[")
+		if idx < 0 {
+			break
+		}
+
+		key = key[:idx]
+	}
+
+	return "", false
+}
+
+// locateErrors returns the error from preparing a component, whose
+// configuration is at the given paths of the template, with each of its
+// ConfigKeyErrors saying where its key is.
+func (l *templateLocations) locateErrors(paths []string, err error) error {
+	if l == nil {
+		return err
+	}
+
+	switch e := err.(type) {
+	case *MultiError:
+		errs := make([]error, len(e.Errors))
+		for i, err := range e.Errors {
+			errs[i] = l.locateErrors(paths, err)
+		}
+
+		return &MultiError{errs}
+	case *ConfigKeyError:
+		if location, ok := l.locate(paths, e.Key); ok {
+			return fmt.Errorf("%s: %s", location, e.Message)
+		}
+	}
+
+	return err
+}
+
+// position returns the line and character, both starting at 1, of the
+// offset in the JSON.
+func (l *templateLocations) position(offset int) (int, int) {
+	line := 1
+	start := 0
+	for i := 0; i < offset; i++ {
+		if l.data[i] == '\n' {
+			line++
+			start = i + 1
+		}
+	}
+
+	return line, offset - start + 1
+}
+
+// templateSyntaxError is what the parsers of the formats of templates
+// other than JSON panic with when the template is invalid, at the offset.
+type templateSyntaxError struct {
+	offset  int
+	message string
+}
+
+// recoverSyntaxError recovers from a templateSyntaxError in the parser of
+// the data, setting the error to it with the line and character it is at.
+func recoverSyntaxError(data []byte, err *error) {
+	if r := recover(); r != nil {
+		syntaxErr, ok := r.(*templateSyntaxError)
+		if !ok {
+			panic(r)
+		}
+
+		locations := &templateLocations{data: data}
+		line, char := locations.position(syntaxErr.offset)
+		*err = fmt.Errorf("Error in line %d, char %d: %s", line, char, syntaxErr.message)
+	}
+}
+
+// jsonScanner walks a JSON document and records the offsets of the keys
+// of objects and the elements of arrays by their path.
+type jsonScanner struct {
+	data    []byte
+	pos     int
+	offsets map[string]int
+}
+
+func (s *jsonScanner) value(path string) {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return
+	}
+
+	switch s.data[s.pos] {
+	case '{':
+		s.pos++
+		for s.pos < len(s.data) {
+			s.skipSpace()
+			if s.pos >= len(s.data) || s.data[s.pos] == '}' {
+				s.pos++
+				return
+			}
+
+			if s.data[s.pos] == ',' {
+				s.pos++
+				continue
+			}
+
+			start := s.pos
+			key := s.str()
+			if path != "" {
+				key = path + "." + key
+			}
+
+			s.offsets[key] = start
+
+			// Skip the colon
+			s.skipSpace()
+			s.pos++
+			s.value(key)
+		}
+	case '[':
+		s.pos++
+		for i := 0; s.pos < len(s.data); i++ {
+			s.skipSpace()
+			if s.pos >= len(s.data) || s.data[s.pos] == ']' {
+				s.pos++
+				return
+			}
+
+			if s.data[s.pos] == ',' {
+				s.pos++
+				i--
+				continue
+			}
+
+			elem := fmt.Sprintf("%s[%d]", path, i)
+			s.offsets[elem] = s.pos
+			s.value(elem)
+		}
+	case '"':
+		s.str()
+	default:
+		for s.pos < len(s.data) && !strings.ContainsRune(",]} \t\r\n", rune(s.data[s.pos])) {
+			s.pos++
+		}
+	}
+}
+
+// str reads a string at the current position and returns its value.
+func (s *jsonScanner) str() string {
+	start := s.pos
+	for s.pos++; s.pos < len(s.data) && s.data[s.pos] != '"'; s.pos++ {
+		if s.data[s.pos] == '\\' {
+			s.pos++
+		}
+	}
+
+	s.pos++
+	if s.pos > len(s.data) {
+		return ""
+	}
+
+	var result string
+	json.Unmarshal(s.data[start:s.pos], &result)
+	return result
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) && strings.ContainsRune(" \t\r\n", rune(s.data[s.pos])) {
+		s.pos++
+	}
+}
@@ -0,0 +1,70 @@
+package packer
+
+import (
+	"errors"
+	"testing"
+)
+
+const testLocationsJson = `{
+  "builders": [
+    {
+      "type": "foo",
+      "boot_command": ["a", "b"],
+      "vmx_data": {"memsize": 1024}
+    }
+  ]
+}`
+
+func TestTemplateLocations_locate(t *testing.T) {
+	l := newTemplateLocations([]byte(testLocationsJson))
+
+	cases := map[string]string{
+		"type":            "builders[0].type (line 4, char 7)",
+		"boot_command[1]": "builders[0].boot_command[1] (line 5, char 29)",
+		"vmx_data.bar":    "builders[0].vmx_data (line 6, char 7)",
+	}
+
+	for key, expected := range cases {
+		result, ok := l.locate([]string{"builders[0]"}, key)
+		if !ok {
+			t.Fatalf("should locate: %s", key)
+		}
+
+		if result != expected {
+			t.Errorf("bad %s: %s", key, result)
+		}
+	}
+
+	if _, ok := l.locate([]string{"builders[0]"}, "missing"); ok {
+		t.Fatal("should not locate")
+	}
+
+	var nilLocations *templateLocations
+	if _, ok := nilLocations.locate([]string{"builders[0]"}, "type"); ok {
+		t.Fatal("should not locate")
+	}
+}
+
+func TestTemplateLocations_locateErrors(t *testing.T) {
+	l := newTemplateLocations([]byte(testLocationsJson))
+
+	plain := errors.New("plain")
+	err := l.locateErrors([]string{"builders[1]", "builders[0]"}, &MultiError{[]error{
+		&ConfigKeyError{Key: "type", Message: "bad type"},
+		&ConfigKeyError{Key: "missing", Message: "bad missing"},
+		plain,
+	}})
+
+	errs := err.(*MultiError).Errors
+	if errs[0].Error() != "builders[0].type (line 4, char 7): bad type" {
+		t.Fatalf("bad: %s", errs[0])
+	}
+
+	if errs[1].Error() != "bad missing" {
+		t.Fatalf("bad: %s", errs[1])
+	}
+
+	if errs[2] != plain {
+		t.Fatalf("bad: %#v", errs[2])
+	}
+}
@@ -2,8 +2,14 @@ package packer
 
 import (
 	"cgl.tideland.biz/asserts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseTemplate_Basic(t *testing.T) {
@@ -37,6 +43,184 @@ func TestParseTemplate_Invalid(t *testing.T) {
 	assert.Nil(result, "should have no result")
 }
 
+func TestParseTemplate_InvalidType(t *testing.T) {
+	data := `
+	{
+		"builders": {}
+	}
+	`
+
+	_, err := ParseTemplate([]byte(data))
+	if err == nil {
+		t.Fatal("should have an error")
+	}
+
+	if !strings.HasPrefix(err.Error(), "Error in line 3, char ") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplate_UnknownKeys(t *testing.T) {
+	data := `
+	{
+		"builders": [{"type": "something"}],
+		"provisoners": [],
+		"bar": 42
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = result.CheckUnknownKeys()
+	if err == nil {
+		t.Fatal("should have an error")
+	}
+
+	errs := err.(*MultiError).Errors
+	if len(errs) != 2 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	expected := "provisoners (line 4, char 3): unknown template key: 'provisoners'"
+	if errs[1].Error() != expected {
+		t.Fatalf("bad: %s", errs[1])
+	}
+
+	result, err = ParseTemplate([]byte(`{"builders": [{"type": "something"}]}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := result.CheckUnknownKeys(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestParseTemplate_MinPackerVersion(t *testing.T) {
+	data := `
+	{
+		"min_packer_version": "0.1.0",
+		"builders": [{"type": "foo"}]
+	}
+	`
+
+	if _, err := ParseTemplate([]byte(data)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data = `
+	{
+		"min_packer_version": "1000.0.0",
+		"builders": [{"type": "foo"}],
+		"unknown": true
+	}
+	`
+
+	_, err := ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "requires Packer >= 1000.0.0") {
+		t.Fatalf("bad: %s", err)
+	}
+
+	data = `
+	{
+		"min_packer_version": "latest",
+		"builders": [{"type": "foo"}]
+	}
+	`
+
+	_, err = ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "min_packer_version is not a valid version") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestCheckMinPackerVersion(t *testing.T) {
+	valid := []string{Version, "v" + Version, "0", "0.0.1"}
+	for _, v := range valid {
+		if err := checkMinPackerVersion(v); err != nil {
+			t.Fatalf("%s: %s", v, err)
+		}
+	}
+
+	invalid := []string{Version + ".1", "1000", "1000.0"}
+	for _, v := range invalid {
+		if err := checkMinPackerVersion(v); err == nil {
+			t.Fatalf("%s: should error", v)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"0.1.5", "0.1.5", 0},
+		{"0.1", "0.1.0", 0},
+		{"v0.2.0", "0.1.5", 1},
+		{"0.1.5", "0.1.10", -1},
+		{"1.0", "0.9.9", 1},
+	}
+
+	for _, tc := range cases {
+		result, err := CompareVersions(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("%s, %s: %s", tc.a, tc.b, err)
+		}
+
+		if result != tc.expected {
+			t.Fatalf("%s, %s: bad: %d", tc.a, tc.b, result)
+		}
+	}
+
+	if _, err := CompareVersions("0.1.5", "latest"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestVersionSatisfies(t *testing.T) {
+	cases := []struct {
+		version    string
+		constraint string
+		expected   bool
+	}{
+		{"0.2.1", "", true},
+		{"0.2.1", "0.2.1", true},
+		{"0.2.1", "= 0.2.0", false},
+		{"0.2.1", "!= 0.2.0", true},
+		{"0.2.1", ">= 0.2.0, < 0.3.0", true},
+		{"0.3.0", ">= 0.2.0, < 0.3.0", false},
+		{"0.2.1", "> 0.2.1", false},
+		{"0.2.1", "<= 0.2.1", true},
+		{"0.2.5", "~> 0.2.1", true},
+		{"0.2.0", "~> 0.2.1", false},
+		{"0.3.0", "~> 0.2.1", false},
+		{"0.9", "~> 0.2", true},
+		{"1.0", "~> 0.2", false},
+	}
+
+	for _, tc := range cases {
+		result, err := VersionSatisfies(tc.version, tc.constraint)
+		if err != nil {
+			t.Fatalf("%s, %s: %s", tc.version, tc.constraint, err)
+		}
+
+		if result != tc.expected {
+			t.Fatalf("%s, %s: bad: %t", tc.version, tc.constraint, result)
+		}
+	}
+
+	invalid := []string{">= latest", "0.2,", "=> 0.2"}
+	for _, constraint := range invalid {
+		if _, err := VersionSatisfies("0.2.1", constraint); err == nil {
+			t.Fatalf("%s: should have error", constraint)
+		}
+	}
+}
+
 func TestParseTemplate_BuilderWithoutType(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -134,6 +318,157 @@ func TestParseTemplate_BuilderWithConflictingName(t *testing.T) {
 	assert.NotNil(err, "should have error")
 }
 
+func TestParseTemplate_BuilderSameType(t *testing.T) {
+	data := `
+	{
+		"builders": [
+			{"name": "us-east", "type": "amazon-ebs"},
+			{"name": "eu-west", "type": "amazon-ebs"},
+			{"type": "amazon-ebs"}
+		]
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, name := range []string{"us-east", "eu-west", "amazon-ebs"} {
+		if result.Builders[name].Type != "amazon-ebs" {
+			t.Fatalf("bad: %#v", result.Builders)
+		}
+	}
+}
+
+func TestParseTemplate_BuilderNameComma(t *testing.T) {
+	data := `
+	{
+		"builders": [{"name": "a,b", "type": "amazon-ebs"}]
+	}
+	`
+
+	_, err := ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "can't contain commas") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplate_BuilderNameIsOtherType(t *testing.T) {
+	data := `
+	{
+		"builders": [
+			{"name": "virtualbox", "type": "amazon-ebs"},
+			{"name": "vbox", "type": "virtualbox"}
+		]
+	}
+	`
+
+	_, err := ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "builder with name 'virtualbox' is named for the type of builder 'vbox'") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplate_BuilderOnError(t *testing.T) {
+	data := `
+	{
+		"builders": [{"type": "amazon-ebs", "on_error": "abort"}]
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Builders["amazon-ebs"].OnError != OnErrorAbort {
+		t.Fatalf("bad: %#v", result.Builders["amazon-ebs"])
+	}
+
+	data = `
+	{
+		"builders": [{"type": "amazon-ebs", "on_error": "explode"}]
+	}
+	`
+
+	_, err = ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "on_error must be one of") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplate_BuilderTimeout(t *testing.T) {
+	data := `
+	{
+		"builders": [{"type": "amazon-ebs", "timeout": "2h"}]
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	build, err := result.Build("amazon-ebs", &ComponentFinder{
+		Builder: func(string) (Builder, error) { return testBuilder(), nil },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if build.(*coreBuild).timeout != 2*time.Hour {
+		t.Fatalf("bad: %s", build.(*coreBuild).timeout)
+	}
+
+	data = `
+	{
+		"builders": [{"type": "amazon-ebs", "timeout": "forever"}]
+	}
+	`
+
+	_, err = ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "timeout is not a valid duration") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplate_SensitiveVariables(t *testing.T) {
+	data := `
+	{
+		"variables": {"foo": "bar", "secret": "hunter2"},
+		"sensitive-variables": ["secret"],
+		"builders": [{"type": "foo"}]
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := result.SetVariables(map[string]string{"secret": "swordfish"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	values := result.SensitiveValues()
+	if len(values) != 1 || values[0] != "swordfish" {
+		t.Fatalf("bad: %#v", values)
+	}
+
+	data = `
+	{
+		"sensitive-variables": ["nope"],
+		"builders": [{"type": "foo"}]
+	}
+	`
+
+	_, err = ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "'nope' isn't a user variable") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
 func TestParseTemplate_Hooks(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -214,6 +549,21 @@ func TestParseTemplate_PostProcessors(t *testing.T) {
 	}
 }
 
+func TestParseTemplate_PostProcessorsBadSequence(t *testing.T) {
+	cases := map[string]string{
+		`[[]]`:               "sequences can't be empty",
+		`[["foo", ["bar"]]]`: "sequences not allowed to be nested",
+	}
+
+	for pps, expected := range cases {
+		data := `{"builders": [{"type": "foo"}], "post-processors": ` + pps + `}`
+		_, err := ParseTemplate([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("bad: %s: %s", pps, err)
+		}
+	}
+}
+
 func TestParseTemplate_ProvisionerWithoutType(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -269,103 +619,287 @@ func TestParseTemplate_Provisioners(t *testing.T) {
 	assert.NotNil(result.Provisioners[0].rawConfig, "should have raw config")
 }
 
-func TestTemplate_BuildNames(t *testing.T) {
+func TestParseTemplate_Variables(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	data := `
 	{
-		"builders": [
-			{
-				"name": "bob",
-				"type": "amazon-ebs"
-			},
-			{
-				"name": "chris",
-				"type": "another"
-			}
-		]
+		"variables": {
+			"foo": "bar",
+			"empty": ""
+		},
+
+		"builders": [{"type": "something"}]
 	}
 	`
 
 	result, err := ParseTemplate([]byte(data))
 	assert.Nil(err, "should not error")
-
-	buildNames := result.BuildNames()
-	sort.Strings(buildNames)
-	assert.Equal(buildNames, []string{"bob", "chris"}, "should have proper builds")
+	assert.Equal(result.Variables, map[string]string{"foo": "bar", "empty": ""}, "should have variables")
 }
 
-func TestTemplate_BuildUnknown(t *testing.T) {
+func TestParseTemplate_VariablesListMap(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
+	os.Setenv("PACKER_TEST_VARIABLE", "secret")
+	defer os.Setenv("PACKER_TEST_VARIABLE", "")
+
 	data := `
 	{
-		"builders": [
-			{
-				"name": "test1",
-				"type": "test-builder"
-			}
-		]
+		"variables": {
+			"groups": ["sg-1", "{{env ` + "`PACKER_TEST_VARIABLE`" + `}}"],
+			"tags": {"Name": "web", "Count": 2},
+			"port": 22
+		},
+
+		"builders": [{"type": "something"}]
 	}
 	`
 
-	template, err := ParseTemplate([]byte(data))
+	result, err := ParseTemplate([]byte(data))
 	assert.Nil(err, "should not error")
+	assert.Equal(result.Variables, map[string]string{
+		"groups": `["sg-1","secret"]`,
+		"tags":   `{"Count":"2","Name":"web"}`,
+		"port":   "22",
+	}, "should have variables")
+	assert.Equal(result.VariableTypes, map[string]string{
+		"groups": VariableTypeList,
+		"tags":   VariableTypeMap,
+		"port":   VariableTypeString,
+	}, "should have types")
+
+	// Lists and maps can only have scalars
+	data = `
+	{
+		"variables": {
+			"groups": [["sg-1"]]
+		},
 
-	build, err := template.Build("nope", nil)
-	assert.Nil(build, "build should be nil")
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	_, err = ParseTemplate([]byte(data))
 	assert.NotNil(err, "should have error")
 }
 
-func TestTemplate_BuildUnknownBuilder(t *testing.T) {
+func TestParseTemplate_VariablesEnv(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
+	os.Setenv("PACKER_TEST_VARIABLE", "secret")
+	defer os.Setenv("PACKER_TEST_VARIABLE", "")
+
 	data := `
 	{
-		"builders": [
-			{
-				"name": "test1",
-				"type": "test-builder"
-			}
-		]
+		"variables": {
+			"foo": "{{env ` + "`PACKER_TEST_VARIABLE`" + `}}",
+			"unset": "{{env ` + "`PACKER_TEST_UNSET_VARIABLE`" + `}}"
+		},
+
+		"builders": [{"type": "something"}]
 	}
 	`
 
-	template, err := ParseTemplate([]byte(data))
+	result, err := ParseTemplate([]byte(data))
 	assert.Nil(err, "should not error")
+	assert.Equal(result.Variables, map[string]string{"foo": "secret", "unset": ""}, "should have variables")
 
-	builderFactory := func(string) (Builder, error) { return nil, nil }
-	components := &ComponentFinder{Builder: builderFactory}
-	build, err := template.Build("test1", components)
-	assert.Nil(build, "build should be nil")
+	// Only env can be used in defaults
+	data = `
+	{
+		"variables": {
+			"foo": "{{user ` + "`bar`" + `}}"
+		},
+
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	_, err = ParseTemplate([]byte(data))
 	assert.NotNil(err, "should have error")
 }
 
-func TestTemplate_Build_NilBuilderFunc(t *testing.T) {
+func TestTemplate_SetVariables(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
 	data := `
 	{
-		"builders": [
-			{
-				"name": "test1",
-				"type": "test-builder"
-			}
-		],
+		"variables": {
+			"foo": "bar",
+			"baz": "default"
+		},
 
-		"provisioners": [
-			{
-				"type": "test-prov"
-			}
-		]
+		"builders": [{"type": "something"}]
 	}
 	`
 
 	template, err := ParseTemplate([]byte(data))
 	assert.Nil(err, "should not error")
 
-	defer func() {
-		p := recover()
+	err = template.SetVariables(map[string]string{"foo": "override"})
+	assert.Nil(err, "should not error")
+	assert.Equal(template.Variables, map[string]string{"foo": "override", "baz": "default"}, "should override")
+
+	err = template.SetVariables(map[string]string{"what": "yes", "foo": "no"})
+	assert.NotNil(err, "should error for unknown variables")
+	assert.Equal(template.Variables["foo"], "override", "should not set anything on error")
+}
+
+func TestTemplate_SetVariables_ListMap(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"variables": {
+			"groups": [],
+			"tags": {}
+		},
+
+		"sensitive-variables": ["groups"],
+
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+
+	err = template.SetVariables(map[string]string{"groups": `["sg-1", "sg-2"]`})
+	assert.Nil(err, "should not error")
+	assert.Equal(template.SensitiveValues(), []string{`["sg-1", "sg-2"]`, "sg-1", "sg-2"}, "should mask elements")
+
+	err = template.SetVariables(map[string]string{"groups": `["sg-3"]`, "tags": "foo"})
+	assert.NotNil(err, "should error for a map that isn't JSON")
+	assert.Equal(template.Variables["groups"], `["sg-1", "sg-2"]`, "should not set anything on error")
+
+	err = template.SetVariables(map[string]string{"tags": `["foo"]`})
+	assert.NotNil(err, "should error for a list given to a map")
+}
+
+func TestTemplate_MissingVariables(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"variables": {
+			"foo": "bar",
+			"password": null,
+			"region": null
+		},
+
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+	assert.Equal(template.RequiredVariables, []string{"password", "region"}, "should have required variables")
+	assert.Equal(template.MissingVariables(), []string{"password", "region"}, "should be missing")
+
+	// Setting to empty counts as setting
+	err = template.SetVariables(map[string]string{"region": ""})
+	assert.Nil(err, "should not error")
+	assert.Equal(template.MissingVariables(), []string{"password"}, "should be missing")
+}
+
+func TestTemplate_BuildNames(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"builders": [
+			{
+				"name": "bob",
+				"type": "amazon-ebs"
+			},
+			{
+				"name": "chris",
+				"type": "another"
+			}
+		]
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+
+	buildNames := result.BuildNames()
+	sort.Strings(buildNames)
+	assert.Equal(buildNames, []string{"bob", "chris"}, "should have proper builds")
+}
+
+func TestTemplate_BuildUnknown(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+
+	build, err := template.Build("nope", nil)
+	assert.Nil(build, "build should be nil")
+	assert.NotNil(err, "should have error")
+}
+
+func TestTemplate_BuildUnknownBuilder(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+
+	builderFactory := func(string) (Builder, error) { return nil, nil }
+	components := &ComponentFinder{Builder: builderFactory}
+	build, err := template.Build("test1", components)
+	assert.Nil(build, "build should be nil")
+	assert.NotNil(err, "should have error")
+}
+
+func TestTemplate_Build_NilBuilderFunc(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			}
+		],
+
+		"provisioners": [
+			{
+				"type": "test-prov"
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+
+	defer func() {
+		p := recover()
 		assert.NotNil(p, "should panic")
 
 		if p != nil {
@@ -495,6 +1029,7 @@ func TestTemplate_Build(t *testing.T) {
 		Builder:       builderFactory,
 		PostProcessor: ppFactory,
 		Provisioner:   provFactory,
+		Communicators: map[string]string{"foo": "packer-communicator-foo"},
 	}
 
 	// Get the build, verifying we can get it without issue, but also
@@ -506,65 +1041,919 @@ func TestTemplate_Build(t *testing.T) {
 	assert.True(ok, "should be a core build")
 	assert.Equal(coreBuild.builder, builder, "should have the same builder")
 	assert.Equal(coreBuild.builderConfig, expectedConfig, "should have proper config")
+	assert.Equal(coreBuild.communicators, components.Communicators, "should have the communicators")
+	assert.Equal(coreBuild.variables, map[string]string{}, "should have no variables")
 	assert.Equal(len(coreBuild.provisioners), 1, "should have one provisioner")
 	assert.Equal(len(coreBuild.postProcessors), 2, "should have pps")
 	assert.Equal(len(coreBuild.postProcessors[0]), 1, "should have correct number")
 	assert.Equal(len(coreBuild.postProcessors[1]), 2, "should have correct number")
-	assert.False(coreBuild.postProcessors[1][0].keepInputArtifact, "shoule be correct")
-	assert.True(coreBuild.postProcessors[1][1].keepInputArtifact, "shoule be correct")
+	assert.Nil(coreBuild.postProcessors[1][0].keepInputArtifact, "shoule be correct")
+	assert.True(*coreBuild.postProcessors[1][1].keepInputArtifact, "shoule be correct")
 }
 
-func TestTemplate_Build_ProvisionerOverride(t *testing.T) {
-	assert := asserts.NewTestingAsserts(t, true)
-
+func TestTemplate_Build_OnlyExcept(t *testing.T) {
 	data := `
 	{
 		"builders": [
-			{
-				"name": "test1",
-				"type": "test-builder"
-			}
+			{"name": "linux", "type": "test-builder"},
+			{"name": "windows", "type": "other-builder"}
 		],
 
 		"provisioners": [
-			{
-				"type": "test-prov",
+			{"type": "shell", "only": ["linux"]},
+			{"type": "powershell", "except": ["test-builder"]},
+			{"type": "file"}
+		],
 
-				"override": {
-					"test1": {}
-				}
+		"post-processors": [
+			{"type": "vagrant", "only": ["windows"]},
+			[
+				{"type": "compress", "except": ["linux"]},
+				"upload"
+			]
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	components := &ComponentFinder{
+		Builder:       func(string) (Builder, error) { return testBuilder(), nil },
+		PostProcessor: func(string) (PostProcessor, error) { return new(TestPostProcessor), nil },
+		Provisioner:   func(string) (Provisioner, error) { return new(TestProvisioner), nil },
+	}
+
+	cases := []struct {
+		name           string
+		provisioners   []string
+		postProcessors [][]string
+	}{
+		{"linux", []string{"provisioners[0]", "provisioners[2]"}, [][]string{{"upload"}}},
+		{"windows", []string{"provisioners[1]", "provisioners[2]"}, [][]string{{"vagrant"}, {"compress", "upload"}}},
+	}
+
+	for _, tc := range cases {
+		build, err := template.Build(tc.name, components)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		coreBuild := build.(*coreBuild)
+		provisioners := make([]string, len(coreBuild.provisioners))
+		for i, p := range coreBuild.provisioners {
+			provisioners[i] = p.paths[0]
+		}
+
+		if !reflect.DeepEqual(provisioners, tc.provisioners) {
+			t.Fatalf("%s: bad: %#v", tc.name, provisioners)
+		}
+
+		postProcessors := make([][]string, len(coreBuild.postProcessors))
+		for i, sequence := range coreBuild.postProcessors {
+			postProcessors[i] = make([]string, len(sequence))
+			for j, pp := range sequence {
+				postProcessors[i][j] = pp.processorType
 			}
+		}
+
+		if !reflect.DeepEqual(postProcessors, tc.postProcessors) {
+			t.Fatalf("%s: bad: %#v", tc.name, postProcessors)
+		}
+	}
+}
+
+func TestParseTemplate_OnlyExceptInvalid(t *testing.T) {
+	cases := []string{
+		`{"type": "shell", "only": ["nope"]}`,
+		`{"type": "shell", "except": ["nope"]}`,
+		`{"type": "shell", "only": ["linux"], "except": ["linux"]}`,
+	}
+
+	for _, tc := range cases {
+		data := `
+		{
+			"builders": [{"name": "linux", "type": "test-builder"}],
+			"provisioners": [` + tc + `],
+			"post-processors": [` + tc + `]
+		}
+		`
+
+		_, err := ParseTemplate([]byte(data))
+		merr, ok := err.(*MultiError)
+		if !ok || len(merr.Errors) != 2 {
+			t.Fatalf("%s: bad: %#v", tc, err)
+		}
+	}
+}
+
+func TestTemplate_Build_PauseBeforeMaxRetries(t *testing.T) {
+	data := `
+	{
+		"builders": [{"name": "test1", "type": "test-builder"}],
+
+		"provisioners": [
+			{"type": "test-prov", "pause_before": "30s"},
+			{"type": "test-prov"},
+			{"type": "test-prov", "pause_before": "1m", "max_retries": 3}
 		]
 	}
 	`
 
 	template, err := ParseTemplate([]byte(data))
-	assert.Nil(err, "should not error")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
 
-	builder := testBuilder()
-	builderMap := map[string]Builder{
-		"test-builder": builder,
+	provisioner := new(TestProvisioner)
+	components := &ComponentFinder{
+		Builder:     func(string) (Builder, error) { return testBuilder(), nil },
+		Provisioner: func(string) (Provisioner, error) { return provisioner, nil },
 	}
 
-	provisioner := &TestProvisioner{}
-	provisionerMap := map[string]Provisioner{
-		"test-prov": provisioner,
+	build, err := template.Build("test1", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
 	}
 
-	builderFactory := func(n string) (Builder, error) { return builderMap[n], nil }
-	provFactory := func(n string) (Provisioner, error) { return provisionerMap[n], nil }
+	provisioners := build.(*coreBuild).provisioners
+	paused, ok := provisioners[0].provisioner.(*PausedProvisioner)
+	if !ok || paused.PauseBefore != 30*time.Second || paused.Provisioner != provisioner {
+		t.Fatalf("bad: %#v", provisioners[0].provisioner)
+	}
+
+	// The pause is only before the first attempt
+	paused, ok = provisioners[2].provisioner.(*PausedProvisioner)
+	if !ok {
+		t.Fatalf("bad: %#v", provisioners[2].provisioner)
+	}
+
+	retried, ok := paused.Provisioner.(*RetriedProvisioner)
+	if !ok || retried.MaxRetries != 3 || retried.Provisioner != provisioner {
+		t.Fatalf("bad: %#v", paused.Provisioner)
+	}
+
+	if provisioners[1].provisioner != provisioner {
+		t.Fatalf("bad: %#v", provisioners[1].provisioner)
+	}
+
+	// The pause must be a duration
+	data = `
+	{
+		"builders": [{"type": "test-builder"}],
+		"provisioners": [{"type": "test-prov", "pause_before": "30"}]
+	}
+	`
+
+	if _, err := ParseTemplate([]byte(data)); err == nil {
+		t.Fatal("should have error")
+	}
+
+	data = `
+	{
+		"builders": [{"type": "test-builder"}],
+		"provisioners": [{"type": "test-prov", "max_retries": -1}]
+	}
+	`
+
+	if _, err := ParseTemplate([]byte(data)); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestTemplate_Build_Timeout(t *testing.T) {
+	data := `
+	{
+		"builders": [{"name": "test1", "type": "test-builder"}],
+
+		"provisioners": [
+			{"type": "test-prov", "timeout": "5m"},
+			{"type": "test-prov", "timeout": "5m", "max_retries": 2}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	provisioner := new(TestProvisioner)
 	components := &ComponentFinder{
-		Builder:     builderFactory,
-		Provisioner: provFactory,
+		Builder:     func(string) (Builder, error) { return testBuilder(), nil },
+		Provisioner: func(string) (Provisioner, error) { return provisioner, nil },
 	}
 
-	// Get the build, verifying we can get it without issue, but also
-	// that the proper builder was looked up and used for the build.
 	build, err := template.Build("test1", components)
-	assert.Nil(err, "should not error")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
 
-	coreBuild, ok := build.(*coreBuild)
-	assert.True(ok, "should be a core build")
-	assert.Equal(len(coreBuild.provisioners), 1, "should have one provisioner")
-	assert.Equal(len(coreBuild.provisioners[0].config), 2, "should have two configs on the provisioner")
+	provisioners := build.(*coreBuild).provisioners
+	timeout, ok := provisioners[0].provisioner.(*TimeoutProvisioner)
+	if !ok || timeout.Timeout != 5*time.Minute || timeout.Provisioner != provisioner {
+		t.Fatalf("bad: %#v", provisioners[0].provisioner)
+	}
+
+	// Each retry has the whole timeout
+	retried, ok := provisioners[1].provisioner.(*RetriedProvisioner)
+	if !ok {
+		t.Fatalf("bad: %#v", provisioners[1].provisioner)
+	}
+
+	if _, ok := retried.Provisioner.(*TimeoutProvisioner); !ok {
+		t.Fatalf("bad: %#v", retried.Provisioner)
+	}
+
+	// The timeout must be a duration
+	data = `
+	{
+		"builders": [{"type": "test-builder"}],
+		"provisioners": [{"type": "test-prov", "timeout": "5"}]
+	}
+	`
+
+	if _, err := ParseTemplate([]byte(data)); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestTemplate_BuildMetadata(t *testing.T) {
+	data := `
+	{
+		"variables": {"version": "1.0"},
+		"metadata": {
+			"version": "{{user \"version\"}}",
+			"built-by": "{{build_name}} ({{build_type}})",
+			"id": "{{uuid}}"
+		},
+		"builders": [{"name": "test1", "type": "test-builder"}]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	metadata, err := template.BuildMetadata("test1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if metadata["version"] != "1.0" || metadata["built-by"] != "test1 (test-builder)" {
+		t.Fatalf("bad: %#v", metadata)
+	}
+
+	// The metadata is processed once for each build
+	again, err := template.BuildMetadata("test1")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if again["id"] != metadata["id"] {
+		t.Fatalf("bad: %#v", again)
+	}
+
+	// Until the user variables change
+	if err := template.SetVariables(map[string]string{"version": "2.0"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	components := &ComponentFinder{
+		Builder: func(string) (Builder, error) { return testBuilder(), nil },
+	}
+
+	build, err := template.Build("test1", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	metadata = build.(*coreBuild).metadata
+	if metadata["version"] != "2.0" || metadata["id"] == again["id"] {
+		t.Fatalf("bad: %#v", metadata)
+	}
+
+	if _, err := template.BuildMetadata("nope"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestParseTemplate_MetadataInvalid(t *testing.T) {
+	cases := []string{
+		`{"": "foo"}`,
+		`{"version": "{{user"}`,
+	}
+
+	for _, tc := range cases {
+		data := `
+		{
+			"metadata": ` + tc + `,
+			"builders": [{"type": "test-builder"}]
+		}
+		`
+
+		if _, err := ParseTemplate([]byte(data)); err == nil {
+			t.Fatalf("%s: should have error", tc)
+		}
+	}
+}
+
+func TestParseTemplate_RequiredPlugins(t *testing.T) {
+	data := `
+	{
+		"required_plugins": {
+			"packer-builder-custom-cloud": {
+				"version": ">= 0.2.0, < 0.3.0",
+				"source": "https://example.com/{{.Version}}/packer-builder-custom-cloud",
+				"checksums": {
+					"0.2.1": "2b8c2e7a6e5bfc52cbd8e1b8ceb1d5b9b6f8f47e8b6e62a2a0b0a477d3fce91f"
+				}
+			},
+			"packer-provisioner-local": {}
+		},
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	tpl, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(tpl.RequiredPlugins) != 2 {
+		t.Fatalf("bad: %#v", tpl.RequiredPlugins)
+	}
+
+	plugin := tpl.RequiredPlugins["packer-builder-custom-cloud"]
+	if plugin.Version != ">= 0.2.0, < 0.3.0" || len(plugin.Checksums) != 1 {
+		t.Fatalf("bad: %#v", plugin)
+	}
+
+	if err := tpl.CheckUnknownKeys(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestParseTemplate_RequiredPluginsInvalid(t *testing.T) {
+	checksum := "2b8c2e7a6e5bfc52cbd8e1b8ceb1d5b9b6f8f47e8b6e62a2a0b0a477d3fce91f"
+	cases := []string{
+		`{"custom-cloud": {}}`,
+		`{"packer-builder-custom-cloud": {"version": ">= latest"}}`,
+		`{"packer-builder-custom-cloud": {"source": "https://example.com/{{.Version"}}`,
+		`{"packer-builder-custom-cloud": {"source": "https://example.com/foo"}}`,
+		`{"packer-builder-custom-cloud": {"checksums": {"latest": "` + checksum + `"}}}`,
+		`{"packer-builder-custom-cloud": {"checksums": {"0.2.1_linux": "` + checksum + `"}}}`,
+		`{"packer-builder-custom-cloud": {"checksums": {"0.2.1": "abc"}}}`,
+	}
+
+	for _, tc := range cases {
+		data := `
+		{
+			"required_plugins": ` + tc + `,
+			"builders": [{"type": "test-builder"}]
+		}
+		`
+
+		_, err := ParseTemplate([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), "required plugin") {
+			t.Fatalf("%s: bad: %s", tc, err)
+		}
+	}
+}
+
+func TestParseTemplate_RequiredPluginsIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	included := `
+	{
+		"required_plugins": {
+			"packer-builder-custom-cloud": {"version": "0.1.0"},
+			"packer-provisioner-local": {}
+		}
+	}
+	`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "plugins.json"), []byte(included), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data := `
+	{
+		"includes": ["plugins.json"],
+		"required_plugins": {
+			"packer-builder-custom-cloud": {"version": "0.2.0"}
+		},
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	path := filepath.Join(dir, "template.json")
+	tpl, err := ParseTemplateFile(path, []byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(tpl.RequiredPlugins) != 2 {
+		t.Fatalf("bad: %#v", tpl.RequiredPlugins)
+	}
+
+	// The template's own requirements override those it includes
+	if tpl.RequiredPlugins["packer-builder-custom-cloud"].Version != "0.2.0" {
+		t.Fatalf("bad: %#v", tpl.RequiredPlugins["packer-builder-custom-cloud"])
+	}
+}
+
+func TestParseTemplate_Proxy(t *testing.T) {
+	data := `
+	{
+		"variables": {"proxy": "http://proxy.example.com:3128"},
+		"proxy": {
+			"http_proxy": "{{user \"proxy\"}}",
+			"no_proxy": "internal.example.com"
+		},
+		"builders": [{"name": "test1", "type": "test-builder"}]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := template.CheckUnknownKeys(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := template.CheckVariables(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	components := &ComponentFinder{
+		Builder: func(string) (Builder, error) { return testBuilder(), nil },
+	}
+
+	build, err := template.Build("test1", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	proxy := build.(*coreBuild).proxy
+	expected := &ProxyConfig{HTTPProxy: "http://proxy.example.com:3128", NoProxy: "internal.example.com"}
+	if !reflect.DeepEqual(proxy, expected) {
+		t.Fatalf("bad: %#v", proxy)
+	}
+
+	// A proxy that isn't a URL is an error of the build
+	if err := template.SetVariables(map[string]string{"proxy": "http://"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := template.Build("test1", components); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestParseTemplate_ProxyInvalid(t *testing.T) {
+	data := `
+	{
+		"proxy": {"https_proxy": "{{user"},
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	_, err := ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "proxy https_proxy") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestTemplate_Build_ShellHook(t *testing.T) {
+	data := `
+	{
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			}
+		],
+
+		"hooks": {
+			"packer_build_start": ["shell:echo hello"]
+		}
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	components := &ComponentFinder{
+		Builder: func(string) (Builder, error) { return testBuilder(), nil },
+		Hook: func(n string) (Hook, error) {
+			t.Fatalf("should not look up hook: %s", n)
+			return nil, nil
+		},
+	}
+
+	build, err := template.Build("test1", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	hooks := build.(*coreBuild).hooks[HookBuildStart]
+	if len(hooks) != 1 {
+		t.Fatalf("bad: %#v", hooks)
+	}
+
+	hook, ok := hooks[0].(*ShellHook)
+	if !ok || hook.Command != "echo hello" {
+		t.Fatalf("bad: %#v", hooks[0])
+	}
+}
+
+func TestTemplate_Build_ProvisionerOverride(t *testing.T) {
+	assert := asserts.NewTestingAsserts(t, true)
+
+	data := `
+	{
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			}
+		],
+
+		"provisioners": [
+			{
+				"type": "test-prov",
+
+				"override": {
+					"test1": {}
+				}
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	assert.Nil(err, "should not error")
+
+	builder := testBuilder()
+	builderMap := map[string]Builder{
+		"test-builder": builder,
+	}
+
+	provisioner := &TestProvisioner{}
+	provisionerMap := map[string]Provisioner{
+		"test-prov": provisioner,
+	}
+
+	builderFactory := func(n string) (Builder, error) { return builderMap[n], nil }
+	provFactory := func(n string) (Provisioner, error) { return provisionerMap[n], nil }
+	components := &ComponentFinder{
+		Builder:     builderFactory,
+		Provisioner: provFactory,
+	}
+
+	// Get the build, verifying we can get it without issue, but also
+	// that the proper builder was looked up and used for the build.
+	build, err := template.Build("test1", components)
+	assert.Nil(err, "should not error")
+
+	coreBuild, ok := build.(*coreBuild)
+	assert.True(ok, "should be a core build")
+	assert.Equal(len(coreBuild.provisioners), 1, "should have one provisioner")
+	assert.Equal(len(coreBuild.provisioners[0].config), 2, "should have two configs on the provisioner")
+}
+
+func TestTemplate_Build_ProvisionerOverrideBuilderType(t *testing.T) {
+	data := `
+	{
+		"builders": [
+			{"name": "test1", "type": "test-builder"},
+			{"name": "test2", "type": "test-builder"},
+			{"name": "other", "type": "other-builder"}
+		],
+
+		"provisioners": [
+			{
+				"type": "test-prov",
+
+				"override": {
+					"test-builder": {"command": "type"},
+					"test2": {"command": "name"}
+				}
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	components := &ComponentFinder{
+		Builder:     func(string) (Builder, error) { return testBuilder(), nil },
+		Provisioner: func(string) (Provisioner, error) { return new(TestProvisioner), nil },
+	}
+
+	cases := []struct {
+		name  string
+		paths []string
+	}{
+		{"test1", []string{"provisioners[0].override.test-builder", "provisioners[0]"}},
+		{"test2", []string{
+			"provisioners[0].override.test2",
+			"provisioners[0].override.test-builder",
+			"provisioners[0]",
+		}},
+		{"other", []string{"provisioners[0]"}},
+	}
+
+	for _, tc := range cases {
+		build, err := template.Build(tc.name, components)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		p := build.(*coreBuild).provisioners[0]
+		if !reflect.DeepEqual(p.paths, tc.paths) {
+			t.Fatalf("%s: bad: %#v", tc.name, p.paths)
+		}
+
+		if len(p.config) != len(tc.paths) {
+			t.Fatalf("%s: bad: %#v", tc.name, p.config)
+		}
+	}
+}
+
+func TestParseTemplate_ProvisionerOverrideInvalid(t *testing.T) {
+	data := `
+	{
+		"builders": [{"name": "test1", "type": "test-builder"}],
+		"provisioners": [{"type": "shell", "override": {"tset1": {}}}]
+	}
+	`
+
+	_, err := ParseTemplate([]byte(data))
+	if err == nil || !strings.Contains(err.Error(), "override 'tset1' isn't a build") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+// testTemplateDir writes the files into a temporary directory and returns
+// the directory.
+func testTemplateDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	return dir
+}
+
+func TestParseTemplateFile_Includes(t *testing.T) {
+	dir := testTemplateDir(t, map[string]string{
+		"common/provisioners.json": `{
+			"includes": ["variables.yml"],
+			"provisioners": [{"type": "shell"}],
+			"hooks": {"foo": ["common"]}
+		}`,
+		"common/variables.yml": "variables:\n  user: common\n  port: 22\nmetadata:\n  team: ops\n  version: common\n",
+		"other.hcl":            "post-processor \"vagrant\" {}\n",
+	})
+	defer os.RemoveAll(dir)
+
+	data := `
+	{
+		"includes": ["common/provisioners.json", "other.hcl"],
+		"variables": {"user": "own"},
+		"metadata": {"version": "own"},
+		"builders": [{"type": "something"}],
+		"provisioners": [{"type": "own"}],
+		"hooks": {"foo": ["own"]}
+	}
+	`
+
+	result, err := ParseTemplateFile(filepath.Join(dir, "template.json"), []byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Variables["user"] != "own" || result.Variables["port"] != "22" {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+
+	if result.Metadata["version"] != "own" || result.Metadata["team"] != "ops" {
+		t.Fatalf("bad: %#v", result.Metadata)
+	}
+
+	if len(result.Provisioners) != 2 {
+		t.Fatalf("bad: %#v", result.Provisioners)
+	}
+
+	if result.Provisioners[0].Type != "shell" || result.Provisioners[1].Type != "own" {
+		t.Fatalf("bad: %#v", result.Provisioners)
+	}
+
+	if len(result.PostProcessors) != 1 || result.PostProcessors[0][0].Type != "vagrant" {
+		t.Fatalf("bad: %#v", result.PostProcessors)
+	}
+
+	hooks := result.Hooks["foo"]
+	if len(hooks) != 2 || hooks[0] != "common" || hooks[1] != "own" {
+		t.Fatalf("bad: %#v", result.Hooks)
+	}
+}
+
+func TestParseTemplateFile_IncludesRequiredVariables(t *testing.T) {
+	dir := testTemplateDir(t, map[string]string{
+		"variables.yml": "variables:\n  user: ~\n  password:\n  region: us-east-1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	data := `
+	{
+		"includes": ["variables.yml"],
+		"variables": {"user": "own", "region": null},
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	result, err := ParseTemplateFile(filepath.Join(dir, "template.json"), []byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The template's own default wins over the included one
+	expected := []string{"password", "region"}
+	if !reflect.DeepEqual(result.RequiredVariables, expected) {
+		t.Fatalf("bad: %#v", result.RequiredVariables)
+	}
+}
+
+func TestParseTemplateFile_IncludesBuilders(t *testing.T) {
+	dir := testTemplateDir(t, map[string]string{
+		"builders.json": `{"builders": [{"type": "something"}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	// The builders can all come from the included templates
+	path := filepath.Join(dir, "template.json")
+	result, err := ParseTemplateFile(path, []byte(`{"includes": ["builders.json"]}`))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, ok := result.Builders["something"]; !ok {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	data := `
+	{
+		"includes": ["builders.json"],
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	_, err = ParseTemplateFile(path, []byte(data))
+	if err == nil || !strings.Contains(err.Error(), "builder with name 'something' already exists") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplateFile_IncludesInvalid(t *testing.T) {
+	dir := testTemplateDir(t, map[string]string{
+		"a.json":       `{"includes": ["b.json"]}`,
+		"b.json":       `{"includes": ["a.json"]}`,
+		"invalid.json": `{"provisioners": [{}]}`,
+		"syntax.json":  `{"provisioners": [}`,
+	})
+	defer os.RemoveAll(dir)
+
+	cases := map[string]string{
+		"a.json":       "include 'a.json': include 'b.json': include 'a.json': the template includes itself",
+		"invalid.json": "include 'invalid.json': provisioner 1: missing 'type'",
+		"syntax.json":  "include 'syntax.json': Error in line 1, char ",
+		"missing.json": "include 'missing.json': open ",
+	}
+
+	for include, expected := range cases {
+		data := `{"includes": ["` + include + `"], "builders": [{"type": "foo"}]}`
+		_, err := ParseTemplateFile(filepath.Join(dir, "template.json"), []byte(data))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("bad %s: %s", include, err)
+		}
+	}
+}
+
+func TestParseTemplateFile_IncludesLocations(t *testing.T) {
+	dir := testTemplateDir(t, map[string]string{
+		"provisioners.json": "{\n  \"provisioners\": [{\"type\": \"shell\"}],\n  \"bogus\": true\n}",
+	})
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "template.json")
+	data := `{"includes": ["provisioners.json"], "builders": [{"type": "foo"}]}`
+	result, err := ParseTemplateFile(path, []byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	included := filepath.Join(dir, "provisioners.json")
+	location, ok := result.Provisioners[0].locations.locate([]string{"provisioners[0]"}, "type")
+	if !ok || location != included+": provisioners[0].type (line 2, char 21)" {
+		t.Fatalf("bad: %s", location)
+	}
+
+	err = result.CheckUnknownKeys()
+	if err == nil || !strings.Contains(err.Error(), included+": bogus (line 3, char 3)") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestTemplate_CheckVariables(t *testing.T) {
+	data := `
+	{
+		"variables": {"region": "us-east-1", "password": null, "names": ["a"]},
+		"metadata": {"version": "{{user \"version\"}}"},
+		"builders": [{
+			"type": "test-builder",
+			"region": "{{user \"region\"}}",
+			"boot_command": ["<esc>", "{{user \"nope\"}} {{.HTTPIP}}"]
+		}],
+		"provisioners": [{
+			"type": "test-prov",
+			"inline": ["echo {{build_name}}", "{{range user_map \"names\"}}{{end}}"],
+			"override": {"test-builder": {"execute_command": "{{user \"region\"}} {{.Path}}"}}
+		}],
+		"post-processors": ["compress", {"type": "upload", "bucket": "{{user"}]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = template.CheckVariables()
+	merr, ok := err.(*MultiError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	expected := []string{
+		"required user variable 'password' isn't set",
+		"builders[0].boot_command[1] (line 8, char 30): ",
+		"provisioners[0].inline[1] (line 12, char 38): ",
+		"post-processors[1].bucket (line 15, char 54): ",
+		"metadata.version (line 4, char 16): ",
+	}
+
+	if len(merr.Errors) != len(expected) {
+		t.Fatalf("bad: %s", err)
+	}
+
+	for i, e := range expected {
+		if !strings.HasPrefix(merr.Errors[i].Error(), e) {
+			t.Fatalf("bad: %s", merr)
+		}
+	}
+
+	// With everything set, there are no errors
+	data = `
+	{
+		"variables": {"region": "us-east-1"},
+		"builders": [{"type": "test-builder", "region": "{{user \"region\"}}"}]
+	}
+	`
+
+	template, err = ParseTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := template.CheckVariables(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
 }
@@ -0,0 +1,720 @@
+package packer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The plain YAML scalars that are numbers rather than strings.
+var yamlNumber = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// ParseYAMLTemplate parses a Template from YAML, which is turned into the
+// same structure as a JSON template. YAML allows comments and is less
+// strict about commas and quotes, which makes templates easier to write
+// by hand.
+//
+// Only the parts of YAML that templates need are supported: block and
+// flow mappings and sequences, plain and quoted scalars, literal and
+// folded block scalars, and comments. Anchors, aliases, tags and plain
+// scalars spanning multiple lines are not. Since flow collections are
+// supported, any JSON template is also a YAML template.
+func ParseYAMLTemplate(data []byte) (*Template, error) {
+	return parseTemplateFile("", data, parseYAMLTemplate)
+}
+
+// parseYAMLTemplate parses a template from its YAML, without its includes.
+func parseYAMLTemplate(data []byte) (*Template, error) {
+	raw, offsets, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rawKeys, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Error in template: the template must be a mapping")
+	}
+
+	// Scalars that aren't quoted are numbers and booleans in YAML, but
+	// are meant as strings for variables and hooks, so they are converted.
+	var rawTpl rawTemplate
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           &rawTpl,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decoder.Decode(rawKeys); err != nil {
+		return nil, fmt.Errorf("Error in template: %s", err)
+	}
+
+	return parseTemplate(rawTpl, rawKeys, &templateLocations{data: data, offsets: offsets})
+}
+
+// parseYAML parses the YAML document into maps, slices and scalars, like
+// encoding/json does, and returns the offsets of the keys of mappings and
+// elements of sequences by their path, like templateLocations has them.
+func parseYAML(data []byte) (result interface{}, offsets map[string]int, err error) {
+	p := &yamlParser{data: data, offsets: make(map[string]int)}
+
+	defer recoverSyntaxError(data, &err)
+
+	p.splitLines()
+	result = p.node("", -1)
+
+	p.skipBlank()
+	if p.line < len(p.lines) {
+		p.fail(p.lines[p.line].content(), "unexpected content after the document")
+	}
+
+	return result, p.offsets, nil
+}
+
+// A line of a YAML document. The offsets are into the whole document, and
+// end is where the line ends, not including the newline.
+type yamlLine struct {
+	start  int
+	end    int
+	indent int
+	blank  bool
+}
+
+// content returns the offset of the first character of the line that
+// isn't indentation.
+func (l *yamlLine) content() int {
+	return l.start + l.indent
+}
+
+type yamlParser struct {
+	data    []byte
+	lines   []yamlLine
+	offsets map[string]int
+
+	// The line that the parser is on, and the offset it is at when
+	// parsing the scalars and flow collections within lines.
+	line int
+	pos  int
+}
+
+func (p *yamlParser) fail(offset int, message string) {
+	panic(&templateSyntaxError{offset, message})
+}
+
+func (p *yamlParser) splitLines() {
+	for start := 0; start <= len(p.data); {
+		end := bytes.IndexByte(p.data[start:], '\n')
+		next := start + end + 1
+		if end < 0 {
+			end = len(p.data) - start
+			next = len(p.data) + 1
+		}
+
+		end += start
+		if end > start && p.data[end-1] == '\r' {
+			end--
+		}
+
+		l := yamlLine{start: start, end: end}
+		for l.content() < end && p.data[l.content()] == ' ' {
+			l.indent++
+		}
+
+		// Lines with only whitespace or a comment don't matter to the
+		// structure of the document, and neither do the markers of the
+		// start and end of the document.
+		text := strings.TrimLeft(string(p.data[l.content():end]), " \t")
+		l.blank = text == "" || text[0] == '#'
+		if l.indent == 0 && (text == "---" || text == "...") {
+			l.blank = true
+		}
+
+		p.lines = append(p.lines, l)
+		start = next
+	}
+}
+
+// skipBlank moves to the next line that isn't blank.
+func (p *yamlParser) skipBlank() {
+	for p.line < len(p.lines) && p.lines[p.line].blank {
+		p.line++
+	}
+
+	// Tabs are only allowed before flow collections, so that JSON that
+	// is indented with tabs can be read.
+	if p.line < len(p.lines) {
+		l := &p.lines[p.line]
+		text := bytes.TrimLeft(p.data[l.content():l.end], "\t")
+		if p.data[l.content()] == '\t' && text[0] != '{' && text[0] != '[' {
+			p.fail(l.content(), "tabs can't be used for indentation")
+		}
+	}
+}
+
+// node parses the block node that starts on the next line that isn't
+// blank, which must be indented more than its parent. If it isn't, the
+// node is empty.
+func (p *yamlParser) node(path string, parent int) interface{} {
+	p.skipBlank()
+	if p.line >= len(p.lines) || p.lines[p.line].indent <= parent {
+		return nil
+	}
+
+	l := &p.lines[p.line]
+	if p.isItem(l.content(), l.end) {
+		return p.sequence(path, l.indent)
+	}
+
+	if _, _, ok := p.mappingKey(l.content(), l.end); ok {
+		return p.mapping(path, l.indent)
+	}
+
+	p.pos = l.content()
+	for p.data[p.pos] == '\t' {
+		p.pos++
+	}
+
+	if c := p.data[p.pos]; c == '|' || c == '>' {
+		return p.blockScalar(parent)
+	}
+
+	return p.inline(path)
+}
+
+func (p *yamlParser) mapping(path string, indent int) map[string]interface{} {
+	result := make(map[string]interface{})
+	for {
+		p.skipBlank()
+		if p.line >= len(p.lines) || p.lines[p.line].indent < indent {
+			return result
+		}
+
+		l := &p.lines[p.line]
+		if l.indent > indent {
+			p.fail(l.content(), "unexpected indentation")
+		}
+
+		key, valueStart, ok := p.mappingKey(l.content(), l.end)
+		if !ok {
+			p.fail(l.content(), "expected a key of a mapping")
+		}
+
+		if _, ok := result[key]; ok {
+			p.fail(l.content(), fmt.Sprintf("duplicate key '%s'", key))
+		}
+
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		p.offsets[keyPath] = l.content()
+		result[key] = p.value(keyPath, indent, valueStart, l.end, true)
+	}
+}
+
+func (p *yamlParser) sequence(path string, indent int) []interface{} {
+	result := make([]interface{}, 0)
+	for {
+		p.skipBlank()
+		if p.line >= len(p.lines) || p.lines[p.line].indent < indent {
+			return result
+		}
+
+		l := &p.lines[p.line]
+		if l.indent > indent {
+			p.fail(l.content(), "unexpected indentation")
+		}
+
+		// A mapping can have a sequence as a value at the same
+		// indentation as its keys, which ends at the next key.
+		if !p.isItem(l.content(), l.end) {
+			return result
+		}
+
+		elemPath := fmt.Sprintf("%s[%d]", path, len(result))
+
+		// The item starts after the dash and the spaces that follow it
+		start := l.content() + 1
+		for start < l.end && p.data[start] == ' ' {
+			start++
+		}
+
+		p.offsets[elemPath] = start
+
+		var elem interface{}
+		if p.isItem(start, l.end) {
+			// A compact sequence within the sequence, which is parsed
+			// as if its line started where the item does.
+			l.indent = start - l.start
+			elem = p.sequence(elemPath, l.indent)
+		} else if _, _, ok := p.mappingKey(start, l.end); ok {
+			l.indent = start - l.start
+			elem = p.mapping(elemPath, l.indent)
+		} else {
+			elem = p.value(elemPath, indent, start, l.end, false)
+		}
+
+		result = append(result, elem)
+	}
+}
+
+// value parses the value of a key of a mapping or an item of a sequence,
+// which starts at the given offset of the current line. If there is
+// nothing there it is on the following lines.
+func (p *yamlParser) value(path string, indent int, start int, end int, inMapping bool) interface{} {
+	p.pos = start
+	for p.pos < end && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+
+	if p.pos >= end || p.data[p.pos] == '#' {
+		p.line++
+		p.skipBlank()
+		if inMapping && p.line < len(p.lines) {
+			l := &p.lines[p.line]
+			if l.indent == indent && p.isItem(l.content(), l.end) {
+				return p.sequence(path, indent)
+			}
+		}
+
+		return p.node(path, indent)
+	}
+
+	if c := p.data[p.pos]; c == '|' || c == '>' {
+		return p.blockScalar(indent)
+	}
+
+	return p.inline(path)
+}
+
+// isItem returns true if the text between the offsets starts an item of
+// a block sequence.
+func (p *yamlParser) isItem(start int, end int) bool {
+	return start < end && p.data[start] == '-' &&
+		(start+1 == end || p.data[start+1] == ' ')
+}
+
+// mappingKey reads the key of a block mapping at the start of the text
+// between the offsets, returning it and the offset after its colon. If
+// the text isn't a key and a value, ok is false.
+func (p *yamlParser) mappingKey(start int, end int) (key string, valueStart int, ok bool) {
+	if start >= end || p.isItem(start, end) {
+		return "", 0, false
+	}
+
+	text := p.data[start:end]
+	i := 0
+
+	switch text[0] {
+	case '"', '\'':
+		quote := text[0]
+		for i = 1; i < len(text) && text[i] != quote; i++ {
+			if quote == '"' && text[i] == '\\' {
+				i++
+			} else if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+			}
+		}
+
+		if i >= len(text) {
+			return "", 0, false
+		}
+
+		// The key itself is read as a scalar later, which checks it
+		p.pos = start
+		i++
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+
+		if i >= len(text) || text[i] != ':' {
+			return "", 0, false
+		}
+
+		pos := p.pos
+		if quote == '"' {
+			key = p.doubleQuoted()
+		} else {
+			key = p.singleQuoted()
+		}
+		p.pos = pos
+	case '[', '{', '#', '|', '>', '&', '*', '!', '%', '@', '`':
+		return "", 0, false
+	default:
+		for ; i < len(text); i++ {
+			if text[i] == '#' && text[i-1] == ' ' {
+				return "", 0, false
+			}
+
+			if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t') {
+				break
+			}
+		}
+
+		if i >= len(text) {
+			return "", 0, false
+		}
+
+		key = strings.TrimSpace(string(text[:i]))
+	}
+
+	if i+1 < len(text) && text[i+1] != ' ' && text[i+1] != '\t' {
+		return "", 0, false
+	}
+
+	return key, start + i + 1, true
+}
+
+// inline parses a scalar or flow collection at the current offset, which
+// is the last thing on its line other than a comment, and moves to the
+// line after it.
+func (p *yamlParser) inline(path string) interface{} {
+	result := p.flow(path, false)
+
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+
+	if p.pos < len(p.data) && !strings.ContainsRune("\r\n#", rune(p.data[p.pos])) {
+		p.fail(p.pos, "unexpected characters after the value")
+	}
+
+	// Flow collections can span lines, so the line that the value ends
+	// on is found.
+	for p.line < len(p.lines)-1 && p.lines[p.line].end < p.pos {
+		p.line++
+	}
+
+	p.line++
+	return result
+}
+
+// flow parses a scalar or a flow collection at the current offset.
+func (p *yamlParser) flow(path string, inFlow bool) interface{} {
+	if inFlow {
+		p.skipFlowSpace()
+	}
+
+	if p.pos >= len(p.data) {
+		p.fail(p.pos, "unexpected end of the document")
+	}
+
+	switch p.data[p.pos] {
+	case '[':
+		start := p.pos
+		p.pos++
+
+		result := make([]interface{}, 0)
+		for {
+			p.skipFlowSpace()
+			if p.pos >= len(p.data) {
+				p.fail(start, "unterminated sequence")
+			}
+
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return result
+			}
+
+			if len(result) > 0 {
+				if p.data[p.pos] != ',' {
+					p.fail(p.pos, "expected ',' or ']' in sequence")
+				}
+
+				// A comma may be the last thing in the sequence
+				p.pos++
+				p.skipFlowSpace()
+				if p.pos < len(p.data) && p.data[p.pos] == ']' {
+					continue
+				}
+			}
+
+			elemPath := fmt.Sprintf("%s[%d]", path, len(result))
+			p.offsets[elemPath] = p.pos
+			result = append(result, p.flow(elemPath, true))
+		}
+	case '{':
+		start := p.pos
+		p.pos++
+
+		result := make(map[string]interface{})
+		for {
+			p.skipFlowSpace()
+			if p.pos >= len(p.data) {
+				p.fail(start, "unterminated mapping")
+			}
+
+			if p.data[p.pos] == '}' {
+				p.pos++
+				return result
+			}
+
+			if len(result) > 0 {
+				if p.data[p.pos] != ',' {
+					p.fail(p.pos, "expected ',' or '}' in mapping")
+				}
+
+				p.pos++
+				p.skipFlowSpace()
+				if p.pos >= len(p.data) {
+					p.fail(start, "unterminated mapping")
+				}
+
+				if p.data[p.pos] == '}' {
+					continue
+				}
+			}
+
+			keyStart := p.pos
+			key := p.flowKey()
+			if _, ok := result[key]; ok {
+				p.fail(keyStart, fmt.Sprintf("duplicate key '%s'", key))
+			}
+
+			p.skipFlowSpace()
+			if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+				p.fail(p.pos, "expected ':' after the key of a mapping")
+			}
+			p.pos++
+
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+
+			p.offsets[keyPath] = keyStart
+			result[key] = p.flow(keyPath, true)
+		}
+	case '"':
+		return p.doubleQuoted()
+	case '\'':
+		return p.singleQuoted()
+	case '&', '*', '!':
+		p.fail(p.pos, "anchors, aliases and tags are not supported")
+	case '@', '`':
+		p.fail(p.pos, fmt.Sprintf("'%c' can't start a value", p.data[p.pos]))
+	}
+
+	return p.plain(inFlow)
+}
+
+func (p *yamlParser) flowKey() string {
+	switch p.data[p.pos] {
+	case '"':
+		return p.doubleQuoted()
+	case '\'':
+		return p.singleQuoted()
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && !strings.ContainsRune(":,{}[]\r\n", rune(p.data[p.pos])) {
+		p.pos++
+	}
+
+	return strings.TrimSpace(string(p.data[start:p.pos]))
+}
+
+// plain parses a scalar that isn't quoted, which ends at a comment or the
+// end of the line, or at the end of the element in a flow collection.
+func (p *yamlParser) plain(inFlow bool) interface{} {
+	ends := "\r\n"
+	if inFlow {
+		ends = ",]}\r\n"
+	}
+
+	start := p.pos
+	for ; p.pos < len(p.data); p.pos++ {
+		c := p.data[p.pos]
+		if strings.ContainsRune(ends, rune(c)) {
+			break
+		}
+
+		if c == '#' && p.pos > start && (p.data[p.pos-1] == ' ' || p.data[p.pos-1] == '\t') {
+			break
+		}
+	}
+
+	value := strings.TrimSpace(string(p.data[start:p.pos]))
+	switch value {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+
+	if yamlNumber.MatchString(value) {
+		if i, err := strconv.ParseInt(value, 10, 0); err == nil {
+			return int(i)
+		}
+
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+
+	return value
+}
+
+func (p *yamlParser) doubleQuoted() string {
+	start := p.pos
+	for p.pos++; p.pos < len(p.data) && p.data[p.pos] != '"'; p.pos++ {
+		if p.data[p.pos] == '\\' {
+			p.pos++
+		}
+
+		if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+			p.fail(start, "unterminated string")
+		}
+	}
+
+	if p.pos >= len(p.data) {
+		p.fail(start, "unterminated string")
+	}
+
+	p.pos++
+	quoted := string(p.data[start:p.pos])
+
+	// The escapes of YAML are mostly those of Go and JSON
+	if result, err := strconv.Unquote(quoted); err == nil {
+		return result
+	}
+
+	var result string
+	if err := json.Unmarshal([]byte(quoted), &result); err != nil {
+		p.fail(start, "invalid escape in string")
+	}
+
+	return result
+}
+
+func (p *yamlParser) singleQuoted() string {
+	start := p.pos
+	var buf bytes.Buffer
+	for p.pos++; ; p.pos++ {
+		if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+			p.fail(start, "unterminated string")
+		}
+
+		if p.data[p.pos] == '\'' {
+			// Quotes within the string are written twice
+			if p.pos+1 < len(p.data) && p.data[p.pos+1] == '\'' {
+				p.pos++
+			} else {
+				break
+			}
+		}
+
+		buf.WriteByte(p.data[p.pos])
+	}
+
+	p.pos++
+	return buf.String()
+}
+
+// blockScalar parses a literal or folded block scalar, whose header is at
+// the current offset and whose lines are indented more than their parent.
+func (p *yamlParser) blockScalar(parent int) string {
+	style := p.data[p.pos]
+	header := p.pos
+	chomp := byte(0)
+
+	p.pos++
+	if p.pos < len(p.data) && (p.data[p.pos] == '-' || p.data[p.pos] == '+') {
+		chomp = p.data[p.pos]
+		p.pos++
+	}
+
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+
+	if p.pos < len(p.data) && !strings.ContainsRune("\r\n#", rune(p.data[p.pos])) {
+		p.fail(header, "invalid header of block scalar")
+	}
+
+	// The lines of the scalar are indented as much as the first one that
+	// isn't empty. Comments within it are part of it.
+	lines := make([]string, 0)
+	indent := -1
+	for p.line++; p.line < len(p.lines); p.line++ {
+		l := &p.lines[p.line]
+		text := p.data[l.content():l.end]
+		if len(bytes.TrimLeft(text, " \t")) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+
+		if indent < 0 {
+			indent = l.indent
+		}
+
+		if l.indent <= parent || l.indent < indent {
+			break
+		}
+
+		lines = append(lines, string(p.data[l.start+indent:l.end]))
+	}
+
+	// Empty lines at the end are only kept if the scalar asks for it
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			folded := style == '>' && prev != "" && !yamlMoreIndented(prev)
+			if folded && line != "" && !yamlMoreIndented(line) {
+				buf.WriteByte(' ')
+			} else if !folded || line != "" {
+				buf.WriteByte('\n')
+			}
+		}
+
+		buf.WriteString(line)
+	}
+
+	switch {
+	case chomp == '+':
+		buf.WriteString(strings.Repeat("\n", trailing+1))
+	case chomp != '-' && len(lines) > 0:
+		buf.WriteByte('\n')
+	}
+
+	return buf.String()
+}
+
+// skipFlowSpace skips the whitespace, newlines and comments between the
+// elements of flow collections.
+func (p *yamlParser) skipFlowSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// yamlMoreIndented returns true if the line of a folded block scalar is
+// indented more than the scalar, which keeps its line breaks.
+func yamlMoreIndented(line string) bool {
+	return line[0] == ' ' || line[0] == '\t'
+}
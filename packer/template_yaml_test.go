@@ -0,0 +1,206 @@
+package packer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAMLTemplate(t *testing.T) {
+	data := `
+# A comment
+variables:
+  port: 22
+  groups: [sg-1, sg-2]
+  tags:
+    Name: web
+builders:
+- type: something
+  boot_command: ["<esc>", 'it''s']
+  name: foo
+- type: other
+provisioners:
+  - type: shell
+    inline: |
+      # install things
+      apt-get update
+post-processors:
+  - vagrant
+  - - type: compress
+      keep_input_artifact: true
+`
+
+	result, err := ParseYAMLTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result.Variables["port"] != "22" {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+
+	if result.Variables["groups"] != `["sg-1","sg-2"]` || result.VariableTypes["groups"] != VariableTypeList {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+
+	if result.Variables["tags"] != `{"Name":"web"}` || result.VariableTypes["tags"] != VariableTypeMap {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+
+	if len(result.Builders) != 2 {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	builder, ok := result.Builders["foo"]
+	if !ok || builder.Type != "something" {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	expected := map[string]interface{}{
+		"type":         "something",
+		"boot_command": []interface{}{"<esc>", "it's"},
+		"name":         "foo",
+	}
+
+	if !reflect.DeepEqual(builder.rawConfig, expected) {
+		t.Fatalf("bad: %#v", builder.rawConfig)
+	}
+
+	prov := result.Provisioners[0].rawConfig.(map[string]interface{})
+	if prov["inline"] != "# install things\napt-get update\n" {
+		t.Fatalf("bad: %#v", prov["inline"])
+	}
+
+	if len(result.PostProcessors) != 2 {
+		t.Fatalf("bad: %#v", result.PostProcessors)
+	}
+
+	pp := result.PostProcessors[1][0]
+	if pp.Type != "compress" || pp.KeepInputArtifact == nil || !*pp.KeepInputArtifact || pp.path != "post-processors[1][0]" {
+		t.Fatalf("bad: %#v", pp)
+	}
+}
+
+func TestParseYAMLTemplate_JSON(t *testing.T) {
+	data := `
+	{
+		"builders": [{"type": "something", "count": 2}],
+		"post-processors": ["vagrant"]
+	}
+	`
+
+	result, err := ParseYAMLTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	builder := result.Builders["something"].rawConfig.(map[string]interface{})
+	if builder["count"] != 2 {
+		t.Fatalf("bad: %#v", builder)
+	}
+
+	if len(result.PostProcessors) != 1 || result.PostProcessors[0][0].Type != "vagrant" {
+		t.Fatalf("bad: %#v", result.PostProcessors)
+	}
+}
+
+func TestParseYAMLTemplate_Invalid(t *testing.T) {
+	cases := map[string]string{
+		"builders:\n  - type: foo\n   name: bar\n": "Error in line 3, char 4",
+		"builders: [{type: foo}\n":                 "Error in line 1, char 11",
+		"builders:\n\t- type: foo\n":               "Error in line 2, char 1",
+		"variables:\n  a: 1\n  a: 2\n":             "Error in line 3, char 3",
+		"builders: *foo\n":                         "Error in line 1, char 11",
+		"- type: foo\n":                            "must be a mapping",
+		`{"a": 1,`:                                 "unterminated mapping",
+		"a: {b: 1,\n":                              "unterminated mapping",
+	}
+
+	for data, expected := range cases {
+		_, err := ParseYAMLTemplate([]byte(data))
+		if err == nil {
+			t.Fatalf("should have error: %q", data)
+		}
+
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("bad %q: %s", data, err)
+		}
+	}
+}
+
+func TestParseYAMLTemplate_Locations(t *testing.T) {
+	data := `
+builders:
+  - type: something
+    boot_command:
+      - a
+bogus: true
+`
+
+	result, err := ParseYAMLTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	location, ok := result.locations.locate([]string{"builders[0]"}, "boot_command[0]")
+	if !ok || location != "builders[0].boot_command[0] (line 5, char 9)" {
+		t.Fatalf("bad: %s", location)
+	}
+
+	err = result.CheckUnknownKeys()
+	if err == nil || !strings.Contains(err.Error(), "bogus (line 6, char 1)") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseYAMLTemplate_BlockScalars(t *testing.T) {
+	data := `
+variables:
+  literal: |
+    a
+      b
+
+  folded: >
+    a
+    b
+
+    c
+  stripped: |-
+    a
+  kept: |+
+    a
+
+builders: [{type: something}]
+`
+
+	result, err := ParseYAMLTemplate([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"literal":  "a\n  b\n",
+		"folded":   "a b\nc\n",
+		"stripped": "a",
+		"kept":     "a\n\n",
+	}
+
+	if !reflect.DeepEqual(result.Variables, expected) {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+}
+
+func TestParseTemplateFile(t *testing.T) {
+	yaml := []byte("builders:\n  - type: something\n")
+	if _, err := ParseTemplateFile("template.yml", yaml); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := ParseTemplateFile("template.YAML", yaml); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := ParseTemplateFile("template.json", yaml); err == nil {
+		t.Fatal("should have error")
+	}
+}
@@ -0,0 +1,60 @@
+package packer
+
+import (
+	"strconv"
+	"time"
+)
+
+// The kinds of the parts of a build that are timed.
+const (
+	TimingStep          = "step"
+	TimingProvisioner   = "provisioner"
+	TimingPostProcessor = "post-processor"
+)
+
+// Timing is how long a part of a build took: a step of the builder, a
+// provisioner or a post-processor.
+type Timing struct {
+	// The kind of the part, one of the Timing constants.
+	Kind string
+
+	// The name of the part, such as the name of the step or the type of
+	// the provisioner.
+	Name string
+
+	Duration time.Duration
+}
+
+// ReportTiming reports how long the part of the build took as a "timing"
+// event of the machine-readable output, with the kind of the part, its
+// name and the duration in seconds. Builders report the timings of their
+// steps this way, since they run in plugins, and the build command
+// collects the events of each build for the summary at the end. Nothing
+// is reported without a UI.
+func ReportTiming(ui Ui, t Timing) {
+	if ui == nil {
+		return
+	}
+
+	ui.Machine("timing", t.Kind, t.Name,
+		strconv.FormatFloat(t.Duration.Seconds(), 'f', 3, 64))
+}
+
+// ParseTiming returns the timing of the data of a "timing" event, as
+// ReportTiming reports it, or false if the data isn't a timing.
+func ParseTiming(args []string) (Timing, bool) {
+	if len(args) != 3 {
+		return Timing{}, false
+	}
+
+	seconds, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return Timing{}, false
+	}
+
+	return Timing{
+		Kind:     args[0],
+		Name:     args[1],
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}, true
+}
@@ -0,0 +1,45 @@
+package packer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportTiming(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &MachineReadableUi{Writer: out}
+	ReportTiming(ui, Timing{TimingStep, "stepDownloadISO", 1500 * time.Millisecond})
+
+	if !strings.HasSuffix(out.String(), ",,timing,step,stepDownloadISO,1.500\n") {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	// Without a UI nothing is reported
+	ReportTiming(nil, Timing{TimingStep, "stepDownloadISO", time.Second})
+}
+
+func TestParseTiming(t *testing.T) {
+	timing, ok := ParseTiming([]string{"provisioner", "shell (provisioners[0])", "62.250"})
+	if !ok {
+		t.Fatal("should be a timing")
+	}
+
+	expected := Timing{TimingProvisioner, "shell (provisioners[0])", 62250 * time.Millisecond}
+	if timing != expected {
+		t.Fatalf("bad: %#v", timing)
+	}
+
+	invalid := [][]string{
+		nil,
+		{"step", "stepFoo"},
+		{"step", "stepFoo", "soon"},
+	}
+
+	for _, args := range invalid {
+		if _, ok := ParseTiming(args); ok {
+			t.Fatalf("should not be a timing: %#v", args)
+		}
+	}
+}
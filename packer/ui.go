@@ -10,8 +10,17 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"time"
 )
 
+// MachineReadableEnvVar is the environment variable that is set when the
+// output of Packer is machine-readable, so that commands can tell.
+const MachineReadableEnvVar = "PACKER_MACHINE_READABLE"
+
+// NoColorEnvVar is the environment variable that is set when the output of
+// builds isn't to be colored, such as when the core configuration says so.
+const NoColorEnvVar = "PACKER_NO_COLOR"
+
 type UiColor uint
 
 const (
@@ -26,11 +35,21 @@ const (
 // The Ui interface handles all communication for Packer with the outside
 // world. This sort of control allows us to strictly control how output
 // is formatted and various levels of output.
+//
+// Machine reports an event, of the type given, with its data for programs
+// that read the output of Packer, when it is machine-readable. The type
+// can be prefixed with the target of the event, such as the name of a
+// build, and a comma. Other UIs ignore the events.
+//
+// AskSecret asks like Ask, for a secret such as a password, so what the
+// user types isn't shown when the input is a terminal.
 type Ui interface {
 	Ask(string) (string, error)
+	AskSecret(string) (string, error)
 	Say(string)
 	Message(string)
 	Error(string)
+	Machine(string, ...string)
 }
 
 // ColoredUi is a UI that is colored using terminal colors.
@@ -48,6 +67,24 @@ type PrefixedUi struct {
 	Ui            Ui
 }
 
+// MachineReadableUi is a UI that writes everything, including the messages
+// of the other methods, as machine-readable events to the writer. Each
+// event is a line of comma-separated fields: the time of the event as a
+// Unix timestamp, its target, which may be empty, its type and its data.
+// Commas in the data are written as "%!(PACKER_COMMA)", and newlines as
+// "\n".
+type MachineReadableUi struct {
+	Writer io.Writer
+	l      sync.Mutex
+}
+
+// TargetedUi wraps a machine-readable UI so that the events and messages
+// that go out through it are of the target, such as a build.
+type TargetedUi struct {
+	Target string
+	Ui     Ui
+}
+
 // The ReaderWriterUi is a UI that writes and reads from standard Go
 // io.Reader and io.Writer.
 type ReaderWriterUi struct {
@@ -61,6 +98,10 @@ func (u *ColoredUi) Ask(query string) (string, error) {
 	return u.Ui.Ask(u.colorize(query, u.Color, true))
 }
 
+func (u *ColoredUi) AskSecret(query string) (string, error) {
+	return u.Ui.AskSecret(u.colorize(query, u.Color, true))
+}
+
 func (u *ColoredUi) Say(message string) {
 	u.Ui.Say(u.colorize(message, u.Color, true))
 }
@@ -78,19 +119,38 @@ func (u *ColoredUi) Error(message string) {
 	u.Ui.Error(u.colorize(message, color, true))
 }
 
+func (u *ColoredUi) Machine(t string, args ...string) {
+	// Don't colorize the machine type since it needs to be read by
+	// machines.
+	u.Ui.Machine(t, args...)
+}
+
+// colorize colors each line of the message on its own, so that lines
+// keep their color when they are read apart, such as in a pager.
 func (u *ColoredUi) colorize(message string, color UiColor, bold bool) string {
 	attr := 0
 	if bold {
 		attr = 1
 	}
 
-	return fmt.Sprintf("\033[%d;%d;40m%s\033[0m", attr, color, message)
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = fmt.Sprintf("\033[%d;%d;40m%s\033[0m", attr, color, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
 }
 
 func (u *PrefixedUi) Ask(query string) (string, error) {
 	return u.Ui.Ask(u.prefixLines(u.SayPrefix, query))
 }
 
+func (u *PrefixedUi) AskSecret(query string) (string, error) {
+	return u.Ui.AskSecret(u.prefixLines(u.SayPrefix, query))
+}
+
 func (u *PrefixedUi) Say(message string) {
 	u.Ui.Say(u.prefixLines(u.SayPrefix, message))
 }
@@ -103,6 +163,10 @@ func (u *PrefixedUi) Error(message string) {
 	u.Ui.Error(u.prefixLines(u.SayPrefix, message))
 }
 
+func (u *PrefixedUi) Machine(t string, args ...string) {
+	u.Ui.Machine(t, args...)
+}
+
 func (u *PrefixedUi) prefixLines(prefix, message string) string {
 	var result bytes.Buffer
 
@@ -114,6 +178,16 @@ func (u *PrefixedUi) prefixLines(prefix, message string) string {
 }
 
 func (rw *ReaderWriterUi) Ask(query string) (string, error) {
+	return rw.ask(query, false)
+}
+
+func (rw *ReaderWriterUi) AskSecret(query string) (string, error) {
+	return rw.ask(query, true)
+}
+
+// ask asks the question and reads the answer. If the answer is secret and
+// read from a terminal, the terminal doesn't show it while it is typed.
+func (rw *ReaderWriterUi) ask(query string, secret bool) (string, error) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
@@ -125,26 +199,47 @@ func (rw *ReaderWriterUi) Ask(query string) (string, error) {
 	signal.Notify(sigCh, os.Interrupt)
 	defer signal.Stop(sigCh)
 
-	log.Printf("ui: ask: %s", query)
+	query = MaskSensitive(query)
+	log.Printf("[INFO] ui: ask: %s", query)
 	if query != "" {
 		if _, err := fmt.Fprint(rw.Writer, query+" "); err != nil {
 			return "", err
 		}
 	}
 
-	result := make(chan string, 1)
+	if f, ok := rw.Reader.(*os.File); ok && secret {
+		restore, err := disableEcho(f)
+		if err != nil {
+			log.Printf("[WARN] ui: can't hide the input: %s", err)
+		} else {
+			// The newline the user typed isn't shown either
+			defer fmt.Fprintln(rw.Writer)
+			defer restore()
+		}
+	}
+
+	type answer struct {
+		line string
+		err  error
+	}
+
+	result := make(chan answer, 1)
 	go func() {
-		var line string
-		if _, err := fmt.Fscanln(rw.Reader, &line); err != nil {
-			log.Printf("ui: scan err: %s", err)
+		line, err := readLine(rw.Reader)
+		if err != nil && err != io.EOF {
+			log.Printf("[WARN] ui: scan err: %s", err)
 		}
 
-		result <- line
+		result <- answer{line, err}
 	}()
 
 	select {
-	case line := <-result:
-		return line, nil
+	case a := <-result:
+		if a.err == io.EOF && a.line == "" {
+			return "", io.EOF
+		}
+
+		return a.line, nil
 	case <-sigCh:
 		// Print a newline so that any further output starts properly
 		// on a new line.
@@ -157,11 +252,36 @@ func (rw *ReaderWriterUi) Ask(query string) (string, error) {
 	}
 }
 
+// readLine reads a line from the reader, without the newline and the
+// whitespace around it. It reads a byte at a time so that nothing after
+// the line is taken from the reader before the next question.
+func readLine(r io.Reader) (string, error) {
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+
+			line.WriteByte(b[0])
+		}
+
+		if err != nil {
+			return strings.TrimSpace(line.String()), err
+		}
+	}
+
+	return strings.TrimSpace(line.String()), nil
+}
+
 func (rw *ReaderWriterUi) Say(message string) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
-	log.Printf("ui: %s", message)
+	message = MaskSensitive(message)
+	log.Printf("[INFO] ui: %s", message)
 	_, err := fmt.Fprint(rw.Writer, message+"\n")
 	if err != nil {
 		panic(err)
@@ -172,7 +292,8 @@ func (rw *ReaderWriterUi) Message(message string) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
-	log.Printf("ui: %s", message)
+	message = MaskSensitive(message)
+	log.Printf("[INFO] ui: %s", message)
 	_, err := fmt.Fprint(rw.Writer, message+"\n")
 	if err != nil {
 		panic(err)
@@ -183,9 +304,86 @@ func (rw *ReaderWriterUi) Error(message string) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
-	log.Printf("ui error: %s", message)
+	message = MaskSensitive(message)
+	log.Printf("[ERROR] ui error: %s", message)
 	_, err := fmt.Fprint(rw.Writer, message+"\n")
 	if err != nil {
 		panic(err)
 	}
 }
+
+func (rw *ReaderWriterUi) Machine(t string, args ...string) {
+	log.Printf("[TRACE] machine readable: %s %#v", t, args)
+}
+
+func (u *MachineReadableUi) Ask(query string) (string, error) {
+	return "", errors.New("machine-readable UI can't ask for input")
+}
+
+func (u *MachineReadableUi) AskSecret(query string) (string, error) {
+	return u.Ask(query)
+}
+
+func (u *MachineReadableUi) Say(message string) {
+	u.Machine("ui", "say", message)
+}
+
+func (u *MachineReadableUi) Message(message string) {
+	u.Machine("ui", "message", message)
+}
+
+func (u *MachineReadableUi) Error(message string) {
+	u.Machine("ui", "error", message)
+}
+
+func (u *MachineReadableUi) Machine(t string, args ...string) {
+	// The target is before the type, if there is one
+	target := ""
+	if idx := strings.Index(t, ","); idx >= 0 {
+		target = t[:idx]
+		t = t[idx+1:]
+	}
+
+	fields := make([]string, 0, len(args)+3)
+	fields = append(fields, fmt.Sprintf("%d", time.Now().UTC().Unix()), target, t)
+	for _, arg := range args {
+		arg = MaskSensitive(arg)
+		arg = strings.Replace(arg, ",", "%!(PACKER_COMMA)", -1)
+		arg = strings.Replace(arg, "\r", "\\r", -1)
+		arg = strings.Replace(arg, "\n", "\\n", -1)
+		fields = append(fields, arg)
+	}
+
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	log.Printf("[TRACE] machine readable: %s", strings.Join(fields[1:], ","))
+	_, err := fmt.Fprintln(u.Writer, strings.Join(fields, ","))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (u *TargetedUi) Ask(query string) (string, error) {
+	return u.Ui.Ask(query)
+}
+
+func (u *TargetedUi) AskSecret(query string) (string, error) {
+	return u.Ui.AskSecret(query)
+}
+
+func (u *TargetedUi) Say(message string) {
+	u.Machine("ui", "say", message)
+}
+
+func (u *TargetedUi) Message(message string) {
+	u.Machine("ui", "message", message)
+}
+
+func (u *TargetedUi) Error(message string) {
+	u.Machine("ui", "error", message)
+}
+
+func (u *TargetedUi) Machine(t string, args ...string) {
+	u.Ui.Machine(fmt.Sprintf("%s,%s", u.Target, t), args...)
+}
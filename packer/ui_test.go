@@ -3,6 +3,8 @@ package packer
 import (
 	"bytes"
 	"cgl.tideland.biz/asserts"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -34,6 +36,13 @@ func TestColoredUi(t *testing.T) {
 	if result != "\033[1;31;40mfoo\033[0m\n" {
 		t.Fatalf("invalid output: %s", result)
 	}
+
+	// Each line is colored on its own
+	ui.Say("foo\n\nbar")
+	result = readWriter(bufferUi)
+	if result != "\033[1;33;40mfoo\033[0m\n\n\033[1;33;40mbar\033[0m\n" {
+		t.Fatalf("invalid output: %q", result)
+	}
 }
 
 func TestPrefixedUi(t *testing.T) {
@@ -71,6 +80,22 @@ func TestPrefixedUi_ImplUi(t *testing.T) {
 	}
 }
 
+func TestMachineReadableUi_ImplUi(t *testing.T) {
+	var raw interface{}
+	raw = &MachineReadableUi{}
+	if _, ok := raw.(Ui); !ok {
+		t.Fatalf("MachineReadableUi must implement Ui")
+	}
+}
+
+func TestTargetedUi_ImplUi(t *testing.T) {
+	var raw interface{}
+	raw = &TargetedUi{}
+	if _, ok := raw.(Ui); !ok {
+		t.Fatalf("TargetedUi must implement Ui")
+	}
+}
+
 func TestReaderWriterUi_ImplUi(t *testing.T) {
 	var raw interface{}
 	raw = &ReaderWriterUi{}
@@ -79,6 +104,50 @@ func TestReaderWriterUi_ImplUi(t *testing.T) {
 	}
 }
 
+func TestReaderWriterUi_Ask(t *testing.T) {
+	bufferUi := testUi()
+	bufferUi.Reader = bytes.NewBufferString("{{user \"foo\"}}\n\n  bar  ")
+
+	expected := []string{"{{user \"foo\"}}", "", "bar"}
+	for _, e := range expected {
+		line, err := bufferUi.Ask("?")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if line != e {
+			t.Fatalf("bad: %q", line)
+		}
+	}
+
+	if _, err := bufferUi.Ask("?"); err != io.EOF {
+		t.Fatalf("should be EOF: %s", err)
+	}
+
+	if readWriter(bufferUi) != "? ? ? ? " {
+		t.Fatal("should ask")
+	}
+}
+
+func TestReaderWriterUi_AskSecret(t *testing.T) {
+	bufferUi := testUi()
+	bufferUi.Reader = bytes.NewBufferString("secret\n")
+
+	// Input that isn't a terminal is read as it is
+	line, err := bufferUi.AskSecret("Password:")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if line != "secret" {
+		t.Fatalf("bad: %q", line)
+	}
+
+	if readWriter(bufferUi) != "Password: " {
+		t.Fatal("should ask")
+	}
+}
+
 func TestReaderWriterUi_Error(t *testing.T) {
 	assert := asserts.NewTestingAsserts(t, true)
 
@@ -103,6 +172,56 @@ func TestReaderWriterUi_Say(t *testing.T) {
 	assert.Equal(readWriter(bufferUi), "5\n", "formatting")
 }
 
+func TestMachineReadableUi(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ui := &MachineReadableUi{Writer: buf}
+
+	cases := []struct {
+		f        func()
+		expected string
+	}{
+		{func() { ui.Machine("foo", "bar", "baz") }, ",foo,bar,baz"},
+		{func() { ui.Machine("build,foo") }, "build,foo"},
+		{func() { ui.Say("a, b\nc") }, ",ui,say,a%!(PACKER_COMMA) b\\nc"},
+		{func() { ui.Message("foo") }, ",ui,message,foo"},
+		{func() { ui.Error("foo") }, ",ui,error,foo"},
+	}
+
+	for _, tc := range cases {
+		tc.f()
+		result := buf.String()
+		buf.Reset()
+
+		parts := strings.SplitN(result, ",", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] != tc.expected+"\n" {
+			t.Errorf("bad: %q", result)
+		}
+	}
+
+	if _, err := ui.Ask("foo"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestTargetedUi(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ui := &TargetedUi{
+		Target: "build",
+		Ui:     &MachineReadableUi{Writer: buf},
+	}
+
+	ui.Say("foo")
+	if !strings.HasSuffix(buf.String(), ",build,ui,say,foo\n") {
+		t.Fatalf("bad: %q", buf.String())
+	}
+
+	buf.Reset()
+	ui.Machine("artifact", "0", "id", "foo")
+	if !strings.HasSuffix(buf.String(), ",build,artifact,0,id,foo\n") {
+		t.Fatalf("bad: %q", buf.String())
+	}
+}
+
 // This reads the output from the bytes.Buffer in our test object
 // and then resets the buffer.
 func readWriter(ui *ReaderWriterUi) (result string) {
@@ -0,0 +1,30 @@
+// +build darwin freebsd linux netbsd openbsd
+
+package packer
+
+import (
+	"log"
+	"os"
+	"os/exec"
+)
+
+// disableEcho stops the terminal of the file from showing what is typed,
+// and returns a function that restores it. It fails if the file isn't a
+// terminal.
+func disableEcho(f *os.File) (func(), error) {
+	if err := stty(f, "-echo"); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := stty(f, "echo"); err != nil {
+			log.Printf("[ERROR] ui: can't show the input again: %s", err)
+		}
+	}, nil
+}
+
+func stty(f *os.File, args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	return cmd.Run()
+}
@@ -0,0 +1,12 @@
+package packer
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho isn't supported on Windows, so secrets are shown as they
+// are typed.
+func disableEcho(f *os.File) (func(), error) {
+	return nil, errors.New("hiding input isn't supported on Windows")
+}
@@ -0,0 +1,94 @@
+package packer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileUpload is a local file that UploadFiles uploads to the machine.
+type FileUpload struct {
+	// The path of the local file.
+	Src string
+
+	// The path on the machine that the file is uploaded to.
+	Dst string
+}
+
+// UploadFiles uploads the local files to the machine with the communicator,
+// up to parallel of them at once, so that uploading many files doesn't wait
+// for the latency of the connection once for every file. A parallel of less
+// than one uploads the files one at a time. A failed upload doesn't stop
+// the others, and the errors of all the uploads that failed are returned
+// together, in the order of the files. The progress of each upload is shown
+// in the UI.
+func UploadFiles(ui Ui, comm Communicator, uploads []FileUpload, parallel int) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	if parallel > len(uploads) {
+		parallel = len(uploads)
+	}
+
+	// Each upload has its own slot for its error, so the workers don't
+	// need to coordinate to report them.
+	errs := make([]error, len(uploads))
+	indexCh := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexCh {
+				errs[i] = uploadFile(ui, comm, uploads[i])
+			}
+		}()
+	}
+
+	for i := range uploads {
+		indexCh <- i
+	}
+
+	close(indexCh)
+	wg.Wait()
+
+	errors := make([]error, 0)
+	for i, err := range errs {
+		if err != nil {
+			errors = append(errors, fmt.Errorf("Error uploading %s: %s", uploads[i].Src, err))
+		}
+	}
+
+	if len(errors) > 0 {
+		return &MultiError{errors}
+	}
+
+	return nil
+}
+
+func uploadFile(ui Ui, comm Communicator, upload FileUpload) error {
+	f, err := os.Open(upload.Src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Uploading %s => %s", upload.Src, upload.Dst)
+	input := &ProgressReader{
+		Reader:   f,
+		Total:    fi.Size(),
+		Progress: UiProgress(ui, "Upload "+filepath.Base(upload.Src)),
+	}
+
+	return comm.Upload(upload.Dst, input, &fi)
+}
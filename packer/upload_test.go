@@ -0,0 +1,152 @@
+package packer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uploadCommunicator keeps the files uploaded with it, and how many
+// uploads were running at once at most.
+type uploadCommunicator struct {
+	Communicator
+
+	files     map[string]string
+	active    int
+	maxActive int
+	l         sync.Mutex
+}
+
+func (c *uploadCommunicator) Upload(path string, r io.Reader, fi *os.FileInfo) error {
+	c.l.Lock()
+	c.active++
+	if c.active > c.maxActive {
+		c.maxActive = c.active
+	}
+	c.l.Unlock()
+
+	defer func() {
+		c.l.Lock()
+		defer c.l.Unlock()
+		c.active--
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if strings.HasSuffix(path, "bad") {
+		return errors.New("upload failed")
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.files[path] = string(data)
+	return nil
+}
+
+func testUploads(t *testing.T, dir string, names ...string) []FileUpload {
+	result := make([]FileUpload, 0, len(names))
+	for _, name := range names {
+		src := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(src, []byte(name), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		result = append(result, FileUpload{Src: src, Dst: "/remote/" + name})
+	}
+
+	return result
+}
+
+func TestUploadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploads := testUploads(t, dir, "a", "b", "c", "d", "e", "f", "g")
+	comm := &uploadCommunicator{files: make(map[string]string)}
+	if err := UploadFiles(testUi(), comm, uploads, 3); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(comm.files) != len(uploads) {
+		t.Fatalf("bad: %#v", comm.files)
+	}
+
+	for _, upload := range uploads {
+		if comm.files[upload.Dst] != filepath.Base(upload.Src) {
+			t.Fatalf("bad: %#v", comm.files)
+		}
+	}
+
+	if comm.maxActive < 2 || comm.maxActive > 3 {
+		t.Fatalf("bad: %d", comm.maxActive)
+	}
+}
+
+func TestUploadFiles_Sequential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploads := testUploads(t, dir, "a", "b", "c")
+	comm := &uploadCommunicator{files: make(map[string]string)}
+	if err := UploadFiles(testUi(), comm, uploads, 0); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(comm.files) != len(uploads) || comm.maxActive != 1 {
+		t.Fatalf("bad: %d %#v", comm.maxActive, comm.files)
+	}
+}
+
+func TestUploadFiles_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploads := testUploads(t, dir, "a", "bad", "c")
+	uploads = append(uploads, FileUpload{
+		Src: filepath.Join(dir, "missing"),
+		Dst: "/remote/missing",
+	})
+
+	comm := &uploadCommunicator{files: make(map[string]string)}
+	err = UploadFiles(testUi(), comm, uploads, 2)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	errs := err.(*MultiError).Errors
+	if len(errs) != 2 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	if !strings.Contains(errs[0].Error(), "bad: upload failed") {
+		t.Fatalf("bad: %s", errs[0])
+	}
+
+	if !strings.Contains(errs[1].Error(), "missing") {
+		t.Fatalf("bad: %s", errs[1])
+	}
+
+	// The files that could be uploaded still are
+	if len(comm.files) != 2 {
+		t.Fatalf("bad: %#v", comm.files)
+	}
+}
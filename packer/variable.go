@@ -0,0 +1,133 @@
+package packer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The types of user variables. The values of lists and maps are kept as
+// strings like every other variable, encoded as JSON, so that they can be
+// given to components and set on the command line the same way.
+const (
+	VariableTypeString = "string"
+	VariableTypeList   = "list"
+	VariableTypeMap    = "map"
+)
+
+// VariableValue turns the value of a user variable, as it is decoded from
+// JSON, into the string it is kept as, and returns its type. Strings are
+// kept as they are, other scalars are formatted, and lists and maps of
+// scalars are encoded as JSON.
+func VariableValue(raw interface{}) (string, string, error) {
+	return variableValue(raw, nil)
+}
+
+// variableValue is VariableValue, except that every string in the value is
+// processed with the function, if it isn't nil.
+func variableValue(raw interface{}, process func(string) (string, error)) (string, string, error) {
+	switch v := raw.(type) {
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, elem := range v {
+			value, err := variableScalar(elem, process)
+			if err != nil {
+				return "", "", err
+			}
+
+			list[i] = value
+		}
+
+		data, err := json.Marshal(list)
+		return string(data), VariableTypeList, err
+	case map[string]interface{}:
+		m := make(map[string]string)
+		for k, elem := range v {
+			value, err := variableScalar(elem, process)
+			if err != nil {
+				return "", "", err
+			}
+
+			m[k] = value
+		}
+
+		data, err := json.Marshal(m)
+		return string(data), VariableTypeMap, err
+	default:
+		value, err := variableScalar(v, process)
+		return value, VariableTypeString, err
+	}
+}
+
+func variableScalar(raw interface{}, process func(string) (string, error)) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if process == nil {
+			return v, nil
+		}
+
+		return process(v)
+	case bool, int, int64, float64:
+		return fmt.Sprintf("%v", v), nil
+	default:
+		return "", fmt.Errorf("lists and maps can only have strings, numbers and booleans, not %#v", raw)
+	}
+}
+
+// ListVariable decodes the value of a list variable.
+func ListVariable(value string) ([]string, error) {
+	var result []string
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil, fmt.Errorf("not a JSON list of strings: %s", value)
+	}
+
+	return result, nil
+}
+
+// MapVariable decodes the value of a map variable.
+func MapVariable(value string) (map[string]string, error) {
+	var result map[string]string
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil, fmt.Errorf("not a JSON object of strings: %s", value)
+	}
+
+	return result, nil
+}
+
+// checkVariableType checks that the value of a variable can be decoded as
+// the type of the variable.
+func checkVariableType(typ string, value string) (err error) {
+	switch typ {
+	case VariableTypeList:
+		_, err = ListVariable(value)
+	case VariableTypeMap:
+		_, err = MapVariable(value)
+	}
+
+	return
+}
+
+// variableElements returns the elements of a list or map variable, sorted,
+// or the value itself for a string.
+func variableElements(typ string, value string) []string {
+	switch typ {
+	case VariableTypeList:
+		if list, err := ListVariable(value); err == nil {
+			return list
+		}
+	case VariableTypeMap:
+		if m, err := MapVariable(value); err == nil {
+			result := make([]string, 0, len(m))
+			for _, v := range m {
+				result = append(result, v)
+			}
+
+			sort.Strings(result)
+			return result
+		}
+	}
+
+	return []string{value}
+}
@@ -3,6 +3,8 @@ package packer
 import (
 	"bytes"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // The version of packer.
@@ -13,6 +15,185 @@ const Version = "0.1.5"
 // pre-release marker.
 const VersionPrerelease = "dev"
 
+// checkMinPackerVersion returns an error if this version of Packer is
+// older than the minimum version, such as "0.5.0", that a template
+// requires. Pre-releases count as the version they are released as.
+func checkMinPackerVersion(min string) error {
+	if _, err := versionParts(min); err != nil {
+		return fmt.Errorf("min_packer_version is not a valid version: %s", err)
+	}
+
+	result, err := CompareVersions(Version, min)
+	if err != nil {
+		panic(err)
+	}
+
+	if result < 0 {
+		return fmt.Errorf(
+			"This template requires Packer >= %s, but this is Packer v%s. "+
+				"Please upgrade Packer to build it.", min, Version)
+	}
+
+	return nil
+}
+
+// CompareVersions compares two versions such as "0.5.0", and returns -1,
+// 0 or 1 if a is older than, the same as or newer than b. Missing parts
+// count as zero, so "0.5" is the same as "0.5.0".
+func CompareVersions(a, b string) (int, error) {
+	aParts, err := versionParts(a)
+	if err != nil {
+		return 0, err
+	}
+
+	bParts, err := versionParts(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+
+		if aPart > bPart {
+			return 1, nil
+		} else if aPart < bPart {
+			return -1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// VersionSatisfies says whether the version, such as "0.2.1", satisfies
+// the constraint, which is one or more comparisons separated by commas,
+// such as ">= 0.2.0, < 0.3.0". The operators are "=", "!=", ">", ">=",
+// "<", "<=" and "~>", which allows the last part of the version to go up,
+// so that "~> 0.2.1" is the same as ">= 0.2.1, < 0.3". A version without
+// an operator must be that version, and an empty constraint allows every
+// version.
+func VersionSatisfies(version, constraint string) (bool, error) {
+	comparisons, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := versionParts(version); err != nil {
+		return false, err
+	}
+
+	for _, c := range comparisons {
+		result, err := CompareVersions(version, c.version)
+		if err != nil {
+			return false, err
+		}
+
+		ok := false
+		switch c.op {
+		case "=":
+			ok = result == 0
+		case "!=":
+			ok = result != 0
+		case ">":
+			ok = result > 0
+		case ">=":
+			ok = result >= 0
+		case "<":
+			ok = result < 0
+		case "<=":
+			ok = result <= 0
+		case "~>":
+			ok = result >= 0 && pessimisticMatch(version, c.version)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// versionComparison is one of the comparisons of a version constraint.
+type versionComparison struct {
+	op      string
+	version string
+}
+
+// The operators of version constraints, with the longer ones first so
+// that they are matched before the operators they start with.
+var versionOperators = []string{"!=", ">=", "<=", "~>", "=", ">", "<"}
+
+// parseVersionConstraint returns the comparisons of the constraint.
+func parseVersionConstraint(constraint string) ([]versionComparison, error) {
+	result := make([]versionComparison, 0)
+	if strings.TrimSpace(constraint) == "" {
+		return result, nil
+	}
+
+	for _, field := range strings.Split(constraint, ",") {
+		field = strings.TrimSpace(field)
+		c := versionComparison{op: "="}
+		for _, op := range versionOperators {
+			if strings.HasPrefix(field, op) {
+				c.op = op
+				field = strings.TrimSpace(field[len(op):])
+				break
+			}
+		}
+
+		if _, err := versionParts(field); err != nil {
+			return nil, fmt.Errorf("'%s' isn't a version constraint such as \">= 0.2.0\"", constraint)
+		}
+
+		c.version = field
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+// pessimisticMatch says whether the version has all the parts of the
+// minimum but its last, so that "0.2.5" matches "0.2.1" and "0.2", but
+// "0.3.0" doesn't match "0.2.1".
+func pessimisticMatch(version, min string) bool {
+	parts, _ := versionParts(version)
+	minParts, _ := versionParts(min)
+	for i := 0; i < len(minParts)-1; i++ {
+		var part int
+		if i < len(parts) {
+			part = parts[i]
+		}
+
+		if part != minParts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// versionParts returns the numbers of a version such as "0.5.0".
+func versionParts(version string) ([]int, error) {
+	fields := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	parts := make([]int, len(fields))
+	for i, field := range fields {
+		part, err := strconv.Atoi(field)
+		if err != nil || part < 0 {
+			return nil, fmt.Errorf("'%s' isn't a version such as \"0.5.0\"", version)
+		}
+
+		parts[i] = part
+	}
+
+	return parts, nil
+}
+
 type versionCommand byte
 
 func (versionCommand) Help() string {
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/mitchellh/packer/command/initialize"
+	"github.com/mitchellh/packer/packer/plugin"
+)
+
+func main() {
+	plugin.ServeCommand(new(initialize.Command))
+}
@@ -2,20 +2,22 @@ package vagrant
 
 import (
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
-	"text/template"
 )
 
 type AWSBoxConfig struct {
 	OutputPath          string `mapstructure:"output"`
 	VagrantfileTemplate string `mapstructure:"vagrantfile_template"`
 
-	PackerBuildName string `mapstructure:"packer_build_name"`
+	PackerBuildName string            `mapstructure:"packer_build_name"`
+	PackerBuildVars map[string]string `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string `mapstructure:"packer_user_variables"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type AWSVagrantfileTemplate struct {
@@ -27,11 +29,30 @@ type AWSBoxPostProcessor struct {
 }
 
 func (p *AWSBoxPostProcessor) Configure(raws ...interface{}) error {
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &p.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&p.config, raws...); err != nil {
+		return err
+	}
+
+	p.config.tpl = &packer.ConfigTemplate{
+		UserVars:  p.config.PackerUserVars,
+		BuildVars: p.config.PackerBuildVars,
+	}
+
+	var err error
+	errs := make([]error, 0)
+	p.config.VagrantfileTemplate, err = p.config.tpl.Process(p.config.VagrantfileTemplate, nil)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Error processing vagrantfile_template: %s", err))
+	}
+
+	// The output path is processed when the box is created, since it
+	// uses data about the artifact.
+	if err := p.config.tpl.Validate(p.config.OutputPath); err != nil {
+		errs = append(errs, fmt.Errorf("output invalid template: %s", err))
+	}
+
+	if len(errs) > 0 {
+		return &packer.MultiError{errs}
 	}
 
 	return nil
@@ -53,7 +74,7 @@ func (p *AWSBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact
 	}
 
 	// Compile the output path
-	outputPath, err := ProcessOutputPath(p.config.OutputPath,
+	outputPath, err := ProcessOutputPath(p.config.tpl, p.config.OutputPath,
 		p.config.PackerBuildName, "aws", artifact)
 	if err != nil {
 		return nil, false, err
@@ -89,8 +110,12 @@ func (p *AWSBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact
 		vagrantfileContents = string(contents)
 	}
 
-	t := template.Must(template.New("vagrantfile").Parse(vagrantfileContents))
-	t.Execute(vf, tplData)
+	vagrantfileContents, err = p.config.tpl.Process(vagrantfileContents, tplData)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error writing Vagrantfile: %s", err)
+	}
+
+	vf.Write([]byte(vagrantfileContents))
 	vf.Close()
 
 	// Create the metadata
@@ -8,7 +8,6 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/packer/packer"
 	"log"
-	"text/template"
 )
 
 var builtins = map[string]string{
@@ -20,7 +19,18 @@ var builtins = map[string]string{
 type Config struct {
 	OutputPath string `mapstructure:"output"`
 
-	PackerBuildName string `mapstructure:"packer_build_name"`
+	// The configurations of the post-processors of each provider, which
+	// are only decoded here so that they are known keys.
+	AWS        map[string]interface{} `mapstructure:"aws"`
+	VirtualBox map[string]interface{} `mapstructure:"virtualbox"`
+	VMware     map[string]interface{} `mapstructure:"vmware"`
+
+	PackerBuildName string            `mapstructure:"packer_build_name"`
+	PackerStrict    bool              `mapstructure:"packer_strict"`
+	PackerBuildVars map[string]string `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string `mapstructure:"packer_user_variables"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type PostProcessor struct {
@@ -29,19 +39,19 @@ type PostProcessor struct {
 }
 
 func (p *PostProcessor) Configure(raws ...interface{}) error {
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &p.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&p.config, raws...); err != nil {
+		return err
 	}
 
 	if p.config.OutputPath == "" {
 		p.config.OutputPath = "packer_{{ .BuildName }}_{{.Provider}}.box"
 	}
 
-	_, err := template.New("output").Parse(p.config.OutputPath)
-	if err != nil {
+	p.config.tpl = &packer.ConfigTemplate{
+		UserVars:  p.config.PackerUserVars,
+		BuildVars: p.config.PackerBuildVars,
+	}
+	if err := p.config.tpl.Validate(p.config.OutputPath); err != nil {
 		return fmt.Errorf("output invalid template: %s", err)
 	}
 
@@ -51,10 +61,7 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		return err
 	}
 
-	packerConfig := map[string]interface{}{
-		packer.BuildNameConfigKey: p.config.PackerBuildName,
-	}
-
+	packerConfig := p.packerConfig()
 	p.premade = make(map[string]packer.PostProcessor)
 	errors := make([]error, 0)
 	for k, raw := range mapConfig {
@@ -64,7 +71,7 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		}
 
 		if err := pp.Configure(raw, packerConfig); err != nil {
-			errors = append(errors, err)
+			errors = append(errors, providerErrors(k, err))
 		}
 
 		p.premade[k] = pp
@@ -94,7 +101,7 @@ func (p *PostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact) (pac
 		}
 
 		config := map[string]string{"output": p.config.OutputPath}
-		if err := pp.Configure(config); err != nil {
+		if err := pp.Configure(config, p.packerConfig()); err != nil {
 			return nil, false, err
 		}
 	}
@@ -103,6 +110,39 @@ func (p *PostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact) (pac
 	return pp.PostProcess(ui, artifact)
 }
 
+// packerConfig returns the configuration set by Packer, which is passed
+// on to the post-processors of each provider.
+func (p *PostProcessor) packerConfig() map[string]interface{} {
+	return map[string]interface{}{
+		packer.BuildNameConfigKey:      p.config.PackerBuildName,
+		packer.BuildVariablesConfigKey: p.config.PackerBuildVars,
+		packer.StrictConfigKey:         p.config.PackerStrict,
+		packer.UserVariablesConfigKey:  p.config.PackerUserVars,
+	}
+}
+
+// providerErrors returns the error from configuring the post-processor of
+// a provider with the keys of its errors within the section of the
+// provider, so that the core can find them in the template.
+func providerErrors(provider string, err error) error {
+	switch e := err.(type) {
+	case *packer.MultiError:
+		errs := make([]error, len(e.Errors))
+		for i, err := range e.Errors {
+			errs[i] = providerErrors(provider, err)
+		}
+
+		return &packer.MultiError{errs}
+	case *packer.ConfigKeyError:
+		return &packer.ConfigKeyError{
+			Key:     fmt.Sprintf("%s.%s", provider, e.Key),
+			Message: e.Message,
+		}
+	}
+
+	return err
+}
+
 func keyToPostProcessor(key string) packer.PostProcessor {
 	switch key {
 	case "aws":
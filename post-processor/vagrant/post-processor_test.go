@@ -47,3 +47,53 @@ func TestBuilderPrepare_PPConfig(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 }
+
+func TestBuilderPrepare_PPConfigUserVariables(t *testing.T) {
+	var p PostProcessor
+
+	c := testConfig()
+	c["packer_user_variables"] = map[string]string{"file": "Vagrantfile.tpl"}
+	c["virtualbox"] = map[string]interface{}{
+		"vagrantfile_template": `{{user "file"}}`,
+	}
+
+	err := p.Configure(c)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	pp := p.premade["virtualbox"].(*VBoxBoxPostProcessor)
+	if pp.config.VagrantfileTemplate != "Vagrantfile.tpl" {
+		t.Fatalf("bad: %s", pp.config.VagrantfileTemplate)
+	}
+}
+
+func TestBuilderPrepare_PPConfigStrict(t *testing.T) {
+	var p PostProcessor
+
+	c := testConfig()
+	c[packer.StrictConfigKey] = true
+	c["aws"] = map[string]interface{}{"bogus": true}
+	err := p.Configure(c)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	errs := err.(*packer.MultiError).Errors
+	multiErr, ok := errs[0].(*packer.MultiError)
+	if !ok {
+		t.Fatalf("bad: %#v", errs[0])
+	}
+
+	keyErr, ok := multiErr.Errors[0].(*packer.ConfigKeyError)
+	if !ok || keyErr.Key != "aws.bogus" {
+		t.Fatalf("bad: %#v", multiErr.Errors[0])
+	}
+
+	// The sections of the providers themselves are known keys
+	delete(c, "aws")
+	c["virtualbox"] = map[string]interface{}{}
+	if err := p.Configure(c); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -2,7 +2,6 @@ package vagrant
 
 import (
 	"archive/tar"
-	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"github.com/mitchellh/packer/packer"
@@ -10,7 +9,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"text/template"
 )
 
 // OutputPathTemplate is the structure that is availalable within the
@@ -89,22 +87,14 @@ func DirToBox(dst, dir string) error {
 
 // ProcessOutputPath takes an output path template and executes it,
 // replacing variables with their respective values.
-func ProcessOutputPath(path string, buildName string, provider string, artifact packer.Artifact) (string, error) {
-	var buf bytes.Buffer
-
+func ProcessOutputPath(t *packer.ConfigTemplate, path string, buildName string, provider string, artifact packer.Artifact) (string, error) {
 	tplData := &OutputPathTemplate{
 		ArtifactId: artifact.Id(),
 		BuildName:  buildName,
 		Provider:   provider,
 	}
 
-	t, err := template.New("output").Parse(path)
-	if err != nil {
-		return "", err
-	}
-
-	err = t.Execute(&buf, tplData)
-	return buf.String(), err
+	return t.Process(path, tplData)
 }
 
 // WriteMetadata writes the "metadata.json" file for a Vagrant box.
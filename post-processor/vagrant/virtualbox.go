@@ -3,7 +3,6 @@ package vagrant
 import (
 	"errors"
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/packer/packer"
 	"io"
 	"io/ioutil"
@@ -12,14 +11,17 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
-	"text/template"
 )
 
 type VBoxBoxConfig struct {
 	OutputPath          string `mapstructure:"output"`
 	VagrantfileTemplate string `mapstructure:"vagrantfile_template"`
 
-	PackerBuildName string `mapstructure:"packer_build_name"`
+	PackerBuildName string            `mapstructure:"packer_build_name"`
+	PackerBuildVars map[string]string `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string `mapstructure:"packer_user_variables"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type VBoxVagrantfileTemplate struct {
@@ -31,11 +33,30 @@ type VBoxBoxPostProcessor struct {
 }
 
 func (p *VBoxBoxPostProcessor) Configure(raws ...interface{}) error {
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &p.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&p.config, raws...); err != nil {
+		return err
+	}
+
+	p.config.tpl = &packer.ConfigTemplate{
+		UserVars:  p.config.PackerUserVars,
+		BuildVars: p.config.PackerBuildVars,
+	}
+
+	var err error
+	errs := make([]error, 0)
+	p.config.VagrantfileTemplate, err = p.config.tpl.Process(p.config.VagrantfileTemplate, nil)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Error processing vagrantfile_template: %s", err))
+	}
+
+	// The output path is processed when the box is created, since it
+	// uses data about the artifact.
+	if err := p.config.tpl.Validate(p.config.OutputPath); err != nil {
+		errs = append(errs, fmt.Errorf("output invalid template: %s", err))
+	}
+
+	if len(errs) > 0 {
+		return &packer.MultiError{errs}
 	}
 
 	return nil
@@ -50,7 +71,7 @@ func (p *VBoxBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifac
 	}
 
 	// Compile the output path
-	outputPath, err := ProcessOutputPath(p.config.OutputPath,
+	outputPath, err := ProcessOutputPath(p.config.tpl, p.config.OutputPath,
 		p.config.PackerBuildName, "virtualbox", artifact)
 	if err != nil {
 		return nil, false, err
@@ -106,8 +127,12 @@ func (p *VBoxBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifac
 		vagrantfileContents = string(contents)
 	}
 
-	t := template.Must(template.New("vagrantfile").Parse(vagrantfileContents))
-	t.Execute(vf, tplData)
+	vagrantfileContents, err = p.config.tpl.Process(vagrantfileContents, tplData)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error writing Vagrantfile: %s", err)
+	}
+
+	vf.Write([]byte(vagrantfileContents))
 	vf.Close()
 
 	// Create the metadata
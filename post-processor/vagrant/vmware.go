@@ -2,20 +2,22 @@ package vagrant
 
 import (
 	"fmt"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/packer/packer"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"text/template"
 )
 
 type VMwareBoxConfig struct {
 	OutputPath          string `mapstructure:"output"`
 	VagrantfileTemplate string `mapstructure:"vagrantfile_template"`
 
-	PackerBuildName string `mapstructure:"packer_build_name"`
+	PackerBuildName string            `mapstructure:"packer_build_name"`
+	PackerBuildVars map[string]string `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string `mapstructure:"packer_user_variables"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type VMwareBoxPostProcessor struct {
@@ -23,11 +25,30 @@ type VMwareBoxPostProcessor struct {
 }
 
 func (p *VMwareBoxPostProcessor) Configure(raws ...interface{}) error {
-	for _, raw := range raws {
-		err := mapstructure.Decode(raw, &p.config)
-		if err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&p.config, raws...); err != nil {
+		return err
+	}
+
+	p.config.tpl = &packer.ConfigTemplate{
+		UserVars:  p.config.PackerUserVars,
+		BuildVars: p.config.PackerBuildVars,
+	}
+
+	var err error
+	errs := make([]error, 0)
+	p.config.VagrantfileTemplate, err = p.config.tpl.Process(p.config.VagrantfileTemplate, nil)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Error processing vagrantfile_template: %s", err))
+	}
+
+	// The output path is processed when the box is created, since it
+	// uses data about the artifact.
+	if err := p.config.tpl.Validate(p.config.OutputPath); err != nil {
+		errs = append(errs, fmt.Errorf("output invalid template: %s", err))
+	}
+
+	if len(errs) > 0 {
+		return &packer.MultiError{errs}
 	}
 
 	return nil
@@ -35,7 +56,7 @@ func (p *VMwareBoxPostProcessor) Configure(raws ...interface{}) error {
 
 func (p *VMwareBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artifact) (packer.Artifact, bool, error) {
 	// Compile the output path
-	outputPath, err := ProcessOutputPath(p.config.OutputPath,
+	outputPath, err := ProcessOutputPath(p.config.tpl, p.config.OutputPath,
 		p.config.PackerBuildName, "vmware", artifact)
 	if err != nil {
 		return nil, false, err
@@ -87,8 +108,12 @@ func (p *VMwareBoxPostProcessor) PostProcess(ui packer.Ui, artifact packer.Artif
 		}
 		defer vf.Close()
 
-		t := template.Must(template.New("vagrantfile").Parse(string(contents)))
-		t.Execute(vf, new(struct{}))
+		vagrantfileContents, err := p.config.tpl.Process(string(contents), new(struct{}))
+		if err != nil {
+			return nil, false, fmt.Errorf("Error writing Vagrantfile: %s", err)
+		}
+
+		vf.Write([]byte(vagrantfileContents))
 		vf.Close()
 	}
 
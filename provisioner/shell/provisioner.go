@@ -4,18 +4,17 @@ package shell
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
 	"github.com/mitchellh/iochan"
-	"github.com/mitchellh/mapstructure"
 	"github.com/mitchellh/packer/packer"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
+	"sort"
 	"strings"
-	"text/template"
 )
 
 const DefaultRemotePath = "/tmp/script.sh"
@@ -39,10 +38,24 @@ type config struct {
 	// This should be set to a writable file that is in a pre-existing directory.
 	RemotePath string `mapstructure:"remote_path"`
 
+	// The number of scripts that are uploaded at once. Unless it is more
+	// than one, each script is uploaded to the remote path just before it
+	// is run. Otherwise all the scripts are uploaded first, each to its
+	// own path next to the remote path, and then they're run in order.
+	UploadParallelism int `mapstructure:"upload_parallelism"`
+
 	// The command used to execute the script. The '{{ .Path }}' variable
 	// should be used to specify where the script goes, {{ .Vars }}
 	// can be used to inject the environment_vars into the environment.
 	ExecuteCommand string `mapstructure:"execute_command"`
+
+	// The variables of the build, the user variables of the template and
+	// the metadata of the build, which Packer sets.
+	PackerBuildVars map[string]string `mapstructure:"packer_build_variables"`
+	PackerUserVars  map[string]string `mapstructure:"packer_user_variables"`
+	PackerMetadata  map[string]string `mapstructure:"packer_metadata"`
+
+	tpl *packer.ConfigTemplate
 }
 
 type Provisioner struct {
@@ -55,10 +68,13 @@ type ExecuteCommandTemplate struct {
 }
 
 func (p *Provisioner) Prepare(raws ...interface{}) error {
-	for _, raw := range raws {
-		if err := mapstructure.Decode(raw, &p.config); err != nil {
-			return err
-		}
+	if err := packer.DecodeConfig(&p.config, raws...); err != nil {
+		return err
+	}
+
+	p.config.tpl = &packer.ConfigTemplate{
+		UserVars:  p.config.PackerUserVars,
+		BuildVars: p.config.PackerBuildVars,
 	}
 
 	if p.config.ExecuteCommand == "" {
@@ -83,6 +99,45 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 
 	errs := make([]error, 0)
 
+	templates := map[string]*string{
+		"remote_path": &p.config.RemotePath,
+		"script":      &p.config.Script,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = p.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	sliceTemplates := map[string][]string{
+		"inline":           p.config.Inline,
+		"scripts":          p.config.Scripts,
+		"environment_vars": p.config.Vars,
+	}
+
+	for n, slice := range sliceTemplates {
+		for i, elem := range slice {
+			var err error
+			slice[i], err = p.config.tpl.Process(elem, nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Error processing %s[%d]: %s", n, i, err))
+			}
+		}
+	}
+
+	// The execute command is processed for each script, since it uses
+	// the path that the script is uploaded to.
+	if err := p.config.tpl.Validate(p.config.ExecuteCommand); err != nil {
+		errs = append(errs, fmt.Errorf("Error parsing execute_command: %s", err))
+	}
+
+	if p.config.UploadParallelism < 0 {
+		errs = append(errs, errors.New("upload_parallelism can't be negative."))
+	}
+
 	if p.config.Script != "" && len(p.config.Scripts) > 0 {
 		errs = append(errs, errors.New("Only one of script or scripts can be specified."))
 	}
@@ -149,84 +204,187 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 		tf.Close()
 	}
 
-	for _, path := range scripts {
+	// Scripts that are uploaded at once each need their own remote path,
+	// so they're all uploaded before the first is run.
+	parallel := p.config.UploadParallelism > 1 && len(scripts) > 1
+	remotePaths := make([]string, len(scripts))
+	for i := range scripts {
+		remotePaths[i] = p.config.RemotePath
+		if parallel {
+			remotePaths[i] = remoteScriptPath(p.config.RemotePath, i+1)
+		}
+	}
+
+	if parallel {
+		uploads := make([]packer.FileUpload, len(scripts))
+		for i, path := range scripts {
+			uploads[i] = packer.FileUpload{Src: path, Dst: remotePaths[i]}
+		}
+
+		ui.Say(fmt.Sprintf("Uploading %d shell scripts...", len(scripts)))
+		if err := packer.UploadFiles(ui, comm, uploads, p.config.UploadParallelism); err != nil {
+			return fmt.Errorf("Error uploading shell scripts: %s", err)
+		}
+	}
+
+	for i, path := range scripts {
 		ui.Say(fmt.Sprintf("Provisioning with shell script: %s", path))
 
-		log.Printf("Opening %s for reading", path)
-		f, err := os.Open(path)
-		if err != nil {
-			return fmt.Errorf("Error opening shell script: %s", err)
+		if !parallel {
+			if err := p.uploadScript(ui, comm, path, remotePaths[i]); err != nil {
+				return err
+			}
 		}
 
-		log.Printf("Uploading %s => %s", path, p.config.RemotePath)
-		err = comm.Upload(p.config.RemotePath, f)
-		if err != nil {
-			return fmt.Errorf("Error uploading shell script: %s", err)
+		if err := p.runScript(ui, comm, remotePaths[i]); err != nil {
+			return err
 		}
+	}
 
-		// Flatten the environment variables
-		flattendVars := strings.Join(p.config.Vars, " ")
+	return nil
+}
 
-		// Compile the command
-		var command bytes.Buffer
-		t := template.Must(template.New("command").Parse(p.config.ExecuteCommand))
-		t.Execute(&command, &ExecuteCommandTemplate{flattendVars, p.config.RemotePath})
+// uploadScript uploads the local script to the remote path.
+func (p *Provisioner) uploadScript(ui packer.Ui, comm packer.Communicator, path string, remotePath string) error {
+	log.Printf("Opening %s for reading", path)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error opening shell script: %s", err)
+	}
+	defer f.Close()
 
-		// Setup the remote command
-		stdout_r, stdout_w := io.Pipe()
-		stderr_r, stderr_w := io.Pipe()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Error reading shell script info: %s", err)
+	}
 
-		var cmd packer.RemoteCmd
-		cmd.Command = command.String()
-		cmd.Stdout = stdout_w
-		cmd.Stderr = stderr_w
+	log.Printf("Uploading %s => %s", path, remotePath)
+	input := &packer.ProgressReader{
+		Reader:   f,
+		Total:    fi.Size(),
+		Progress: packer.UiProgress(ui, "Upload"),
+	}
 
-		log.Printf("Executing command: %s", cmd.Command)
-		err = comm.Start(&cmd)
-		if err != nil {
-			return fmt.Errorf("Failed executing command: %s", err)
-		}
+	if err := comm.Upload(remotePath, input, &fi); err != nil {
+		return fmt.Errorf("Error uploading shell script: %s", err)
+	}
+
+	return nil
+}
+
+// runScript runs the script at the remote path with the execute command,
+// showing its output, and fails unless it exits successfully.
+func (p *Provisioner) runScript(ui packer.Ui, comm packer.Communicator, remotePath string) error {
+	// Flatten the environment variables
+	flattendVars := strings.Join(p.config.Vars, " ")
+
+	// Compile the command
+	command, err := p.config.tpl.Process(p.config.ExecuteCommand,
+		&ExecuteCommandTemplate{flattendVars, remotePath})
+	if err != nil {
+		return fmt.Errorf("Error processing command: %s", err)
+	}
+
+	// Setup the remote command
+	stdout_r, stdout_w := io.Pipe()
+	stderr_r, stderr_w := io.Pipe()
+
+	var cmd packer.RemoteCmd
+	cmd.Command = command
+	cmd.Env = []string{
+		"PACKER_BUILD_NAME=" + p.config.PackerBuildVars["build_name"],
+		"PACKER_BUILDER_TYPE=" + p.config.PackerBuildVars["build_type"],
+	}
+	cmd.Env = append(cmd.Env, metadataEnv(p.config.PackerMetadata)...)
+	cmd.Stdout = stdout_w
+	cmd.Stderr = stderr_w
+
+	log.Printf("[INFO] Executing command: %s", cmd.Command)
+	err = comm.Start(&cmd)
+	if err != nil {
+		return fmt.Errorf("Failed executing command: %s", err)
+	}
 
-		exitChan := make(chan int, 1)
-		stdoutChan := iochan.DelimReader(stdout_r, '\n')
-		stderrChan := iochan.DelimReader(stderr_r, '\n')
-
-		go func() {
-			defer stdout_w.Close()
-			defer stderr_w.Close()
-
-			cmd.Wait()
-			exitChan <- cmd.ExitStatus
-		}()
-
-	OutputLoop:
-		for {
-			select {
-			case output := <-stderrChan:
-				ui.Message(strings.TrimSpace(output))
-			case output := <-stdoutChan:
-				ui.Message(strings.TrimSpace(output))
-			case exitStatus := <-exitChan:
-				log.Printf("shell provisioner exited with status %d", exitStatus)
-
-				if exitStatus != 0 {
-					return fmt.Errorf("Script exited with non-zero exit status: %d", exitStatus)
-				}
-
-				break OutputLoop
+	exitChan := make(chan int, 1)
+	stdoutChan := iochan.DelimReader(stdout_r, '\n')
+	stderrChan := iochan.DelimReader(stderr_r, '\n')
+
+	go func() {
+		defer stdout_w.Close()
+		defer stderr_w.Close()
+
+		cmd.Wait()
+		exitChan <- cmd.ExitStatus
+	}()
+
+OutputLoop:
+	for {
+		select {
+		case output := <-stderrChan:
+			ui.Message(strings.TrimSpace(output))
+		case output := <-stdoutChan:
+			ui.Message(strings.TrimSpace(output))
+		case exitStatus := <-exitChan:
+			log.Printf("shell provisioner exited with status %d", exitStatus)
+
+			if cmd.Err != nil {
+				return fmt.Errorf("Error waiting for script to finish: %s", cmd.Err)
 			}
-		}
 
-		// Make sure we finish off stdout/stderr because we may have gotten
-		// a message from the exit channel first.
-		for output := range stdoutChan {
-			ui.Message(output)
-		}
+			if cmd.ExitSignal != "" {
+				return fmt.Errorf("Script was killed by signal %s", cmd.ExitSignal)
+			}
+
+			if exitStatus != 0 {
+				return fmt.Errorf("Script exited with non-zero exit status: %d", exitStatus)
+			}
 
-		for output := range stderrChan {
-			ui.Message(output)
+			break OutputLoop
 		}
 	}
 
+	// Make sure we finish off stdout/stderr because we may have gotten
+	// a message from the exit channel first.
+	for output := range stdoutChan {
+		ui.Message(output)
+	}
+
+	for output := range stderrChan {
+		ui.Message(output)
+	}
+
 	return nil
 }
+
+// remoteScriptPath returns the remote path of the script with the number,
+// when scripts are uploaded at once: the number is added to the name of the
+// remote path, so "/tmp/script.sh" is "/tmp/script-2.sh" for the second.
+func remoteScriptPath(remotePath string, n int) string {
+	ext := path.Ext(remotePath)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(remotePath, ext), n, ext)
+}
+
+// metadataEnv returns the environment variables for the metadata of the
+// build, sorted. The variable of a key is PACKER_METADATA_ followed by the
+// key in upper case, with anything but letters and digits as underscores,
+// so "image-version" is PACKER_METADATA_IMAGE_VERSION.
+func metadataEnv(metadata map[string]string) []string {
+	env := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		name := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+				return r
+			default:
+				return '_'
+			}
+		}, k)
+
+		env = append(env, fmt.Sprintf("PACKER_METADATA_%s=%s", name, v))
+	}
+
+	sort.Strings(env)
+	return env
+}
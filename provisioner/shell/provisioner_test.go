@@ -1,9 +1,14 @@
 package shell
 
 import (
+	"bytes"
 	"github.com/mitchellh/packer/packer"
+	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -160,3 +165,191 @@ func TestProvisionerPrepare_EnvironmentVars(t *testing.T) {
 		t.Fatalf("should not have error: %s", err)
 	}
 }
+
+func TestProvisionerPrepare_UserVariables(t *testing.T) {
+	config := testConfig()
+	config["environment_vars"] = []string{`FOO={{user "foo"}}`}
+	config["packer_user_variables"] = map[string]string{"foo": "bar"}
+
+	p := new(Provisioner)
+	err := p.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if p.config.Vars[0] != "FOO=bar" {
+		t.Fatalf("bad: %s", p.config.Vars[0])
+	}
+
+	// Test a bad execute command
+	config["execute_command"] = "{{"
+	p = new(Provisioner)
+	err = p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_BuildVars(t *testing.T) {
+	config := testConfig()
+	config["inline"] = []interface{}{"echo {{build_name}} {{build_type}}"}
+	config[packer.BuildVariablesConfigKey] = map[string]interface{}{
+		"build_name": "web",
+		"build_type": "amazon-ebs",
+	}
+
+	var p Provisioner
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.config.Inline[0] != "echo web amazon-ebs" {
+		t.Fatalf("bad: %s", p.config.Inline[0])
+	}
+}
+
+func TestProvisionerPrepare_Metadata(t *testing.T) {
+	config := testConfig()
+	config["packer_metadata"] = map[string]interface{}{
+		"version":       "1.2",
+		"image-team.id": "ops",
+	}
+
+	var p Provisioner
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	env := metadataEnv(p.config.PackerMetadata)
+	expected := []string{
+		"PACKER_METADATA_IMAGE_TEAM_ID=ops",
+		"PACKER_METADATA_VERSION=1.2",
+	}
+
+	if !reflect.DeepEqual(env, expected) {
+		t.Fatalf("bad: %#v", env)
+	}
+}
+
+func TestProvisionerPrepare_UploadParallelism(t *testing.T) {
+	config := testConfig()
+	config["upload_parallelism"] = -1
+
+	var p Provisioner
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+
+	config["upload_parallelism"] = 4
+	p = Provisioner{}
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.config.UploadParallelism != 4 {
+		t.Fatalf("bad: %d", p.config.UploadParallelism)
+	}
+}
+
+// testCommunicator keeps the scripts uploaded with it and the commands
+// started with it, which exit successfully at once.
+type testCommunicator struct {
+	packer.Communicator
+
+	uploads  map[string]string
+	commands []string
+	l        sync.Mutex
+}
+
+func (c *testCommunicator) Upload(path string, r io.Reader, fi *os.FileInfo) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.uploads[path] = string(data)
+	return nil
+}
+
+func (c *testCommunicator) Start(cmd *packer.RemoteCmd) error {
+	c.commands = append(c.commands, strings.TrimSpace(cmd.Command))
+	cmd.Stdout.(io.Closer).Close()
+	cmd.Stderr.(io.Closer).Close()
+	cmd.ExitStatus = 0
+	cmd.Exited = true
+	return nil
+}
+
+func TestProvisionerProvision_UploadParallelism(t *testing.T) {
+	scripts := make([]string, 0, 3)
+	for _, contents := range []string{"one", "two", "three"} {
+		tf, err := ioutil.TempFile("", "packer")
+		if err != nil {
+			t.Fatalf("error tempfile: %s", err)
+		}
+		defer os.Remove(tf.Name())
+
+		tf.WriteString(contents)
+		tf.Close()
+		scripts = append(scripts, tf.Name())
+	}
+
+	config := testConfig()
+	delete(config, "inline")
+	config["scripts"] = scripts
+	config["upload_parallelism"] = 2
+
+	var p Provisioner
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &packer.ReaderWriterUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	comm := &testCommunicator{uploads: make(map[string]string)}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expectedUploads := map[string]string{
+		"/tmp/script-1.sh": "one",
+		"/tmp/script-2.sh": "two",
+		"/tmp/script-3.sh": "three",
+	}
+
+	if !reflect.DeepEqual(comm.uploads, expectedUploads) {
+		t.Fatalf("bad: %#v", comm.uploads)
+	}
+
+	expectedCommands := []string{
+		"sh /tmp/script-1.sh",
+		"sh /tmp/script-2.sh",
+		"sh /tmp/script-3.sh",
+	}
+
+	if !reflect.DeepEqual(comm.commands, expectedCommands) {
+		t.Fatalf("bad: %#v", comm.commands)
+	}
+}
+
+func TestRemoteScriptPath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected string
+	}{
+		{"/tmp/script.sh", "/tmp/script-2.sh"},
+		{"/tmp/provision", "/tmp/provision-2"},
+		{"/tmp/v1.0/script", "/tmp/v1.0/script-2"},
+	}
+
+	for _, tc := range cases {
+		if actual := remoteScriptPath(tc.path, 2); actual != tc.expected {
+			t.Fatalf("bad: %s %s", tc.path, actual)
+		}
+	}
+}
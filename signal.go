@@ -9,21 +9,25 @@ import (
 )
 
 // Prepares the signal handlers so that we handle interrupts properly.
-// The signal handler exists in a goroutine.
-func setupSignalHandlers(env packer.Environment) {
+// The signal handler exists in a goroutine. A second interrupt kills the
+// plugins without letting the builds clean up, so the temporary resources
+// tracked in resourcesDir are cleaned up then.
+func setupSignalHandlers(env packer.Environment, resourcesDir string) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
 
 	go func() {
 		<-ch
-		log.Println("First interrupt. Ignoring, will let plugins handle...")
+		log.Println("[WARN] First interrupt. Ignoring, will let plugins handle...")
 		<-ch
-		log.Println("Second interrupt. Exiting now.")
+		log.Println("[ERROR] Second interrupt. Exiting now.")
 
-		env.Ui().Error("Interrupt signal received twice. Forcefully exiting now.")
+		env.Ui().Error("Interrupt signal received twice. Forcefully exiting now.\n" +
+			"The builds weren't cleaned up, so cleaning up their temporary resources.")
 
 		// Force kill all the plugins
 		plugin.CleanupClients()
+		cleanupTempResources(resourcesDir, env.Ui())
 		os.Exit(1)
 	}()
 }
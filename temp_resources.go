@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// setupTempResources creates the directory where the builds track their
+// temporary resources, and sets it in the environment so that the plugins
+// use it too.
+func setupTempResources() (string, error) {
+	dir, err := ioutil.TempDir("", "packer-resources")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Setenv(packer.TempResourcesEnvVar, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	log.Printf("[INFO] Tracking temporary resources in: %s", dir)
+	return dir, nil
+}
+
+// cleanupTempResources cleans up the temporary resources that are still
+// tracked, which the builds didn't clean up because they crashed or were
+// stopped, and tells the user about the ones that are left behind. The
+// ui can be nil, in which case the errors are written to stderr.
+func cleanupTempResources(dir string, ui packer.Ui) {
+	defer os.RemoveAll(dir)
+
+	say := func(message string) {
+		if ui != nil {
+			ui.Error(message)
+		} else {
+			fmt.Fprintln(os.Stderr, message)
+		}
+	}
+
+	leaked, err := packer.CleanupTempResources(dir)
+	if err != nil {
+		say(fmt.Sprintf("Error cleaning up temporary resources: %s", err))
+		return
+	}
+
+	if len(leaked) == 0 {
+		return
+	}
+
+	lines := make([]string, len(leaked))
+	for i, r := range leaked {
+		lines[i] = "  * " + r.String()
+	}
+
+	say(fmt.Sprintf(
+		"These temporary resources of the builds couldn't be cleaned up "+
+			"and must be removed by hand:\n\n%s", strings.Join(lines, "\n")))
+}
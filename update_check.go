@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The update check is only done if it is enabled in the configuration, and
+// this environmental variable disables it even then, such as on a build
+// server that shares the configuration of its users.
+const updateCheckDisableEnvVar = "PACKER_NO_UPDATE_CHECK"
+
+// The address that says what the latest release of Packer is, and which
+// versions of plugins are known to be broken.
+const updateCheckURL = "https://checkpoint-api.hashicorp.com/v1/check/packer"
+
+// Packer asks for the latest release at most once per interval, and uses
+// the last answer in between.
+const updateCheckInterval = 24 * time.Hour
+
+// How long Packer waits for the check once the command is done, so that
+// a slow connection never holds it up.
+const updateCheckTimeout = 3 * time.Second
+
+// updateCheckResponse is what the update check address answers.
+type updateCheckResponse struct {
+	CurrentVersion     string `json:"current_version"`
+	CurrentDownloadURL string `json:"current_download_url"`
+
+	// Plugins that are known to be broken, by the SHA256 checksum of
+	// their binary, such as a release of a plugin that corrupts artifacts.
+	BrokenPlugins []struct {
+		SHA256  string `json:"sha256"`
+		Message string `json:"message"`
+	} `json:"broken_plugins"`
+}
+
+// updateCheckState is what is remembered of the last check, in the Packer
+// directory, so that the check is rate limited.
+type updateCheckState struct {
+	Checked  time.Time            `json:"checked"`
+	Response *updateCheckResponse `json:"response"`
+}
+
+// startUpdateCheck checks for a newer release of Packer in the background,
+// if the user enabled it. The returned channel receives the messages for
+// the user once the check is done, and is nil if the check is disabled.
+func startUpdateCheck(c *config) <-chan []string {
+	if !c.UpdateCheck || os.Getenv(updateCheckDisableEnvVar) != "" {
+		log.Println("[DEBUG] Update check is disabled")
+		return nil
+	}
+
+	result := make(chan []string, 1)
+	go func() {
+		messages, err := checkUpdate(c)
+		if err != nil {
+			log.Printf("[WARN] Error checking for updates: %s", err)
+		}
+
+		result <- messages
+	}()
+
+	return result
+}
+
+// finishUpdateCheck tells the user what an update check found, waiting
+// only until the timeout for a check that isn't done yet.
+func finishUpdateCheck(ui packer.Ui, result <-chan []string) {
+	if result == nil {
+		return
+	}
+
+	select {
+	case messages := <-result:
+		for _, message := range messages {
+			ui.Say(message)
+		}
+	case <-time.After(updateCheckTimeout):
+		log.Println("[WARN] Update check timed out")
+	}
+}
+
+// checkUpdate returns messages for the user if a newer release of Packer
+// is available, or a configured plugin is known to be broken.
+func checkUpdate(c *config) ([]string, error) {
+	dir, err := PackerDir()
+	if err != nil {
+		return nil, err
+	}
+
+	statePath := filepath.Join(dir, "update_check.json")
+	state, err := readUpdateCheckState(statePath)
+	if err != nil {
+		log.Printf("[WARN] Error reading the last update check: %s", err)
+	}
+
+	if state == nil {
+		state = new(updateCheckState)
+	}
+
+	if time.Since(state.Checked) > updateCheckInterval {
+		log.Printf("[INFO] Checking for updates: %s", updateCheckURL)
+
+		// A check that fails is rate limited too, so that Packer doesn't
+		// try every time it is run without a connection. The last answer
+		// is used until the next check.
+		response, err := fetchUpdateCheck()
+		state.Checked = time.Now()
+		if err == nil {
+			state.Response = response
+		}
+
+		if err != nil {
+			log.Printf("[WARN] Error checking for updates: %s", err)
+		}
+
+		if err := writeUpdateCheckState(statePath, state); err != nil {
+			log.Printf("[WARN] Error saving the update check: %s", err)
+		}
+	} else {
+		log.Printf("[DEBUG] Using the update check from %s", state.Checked)
+	}
+
+	return updateMessages(c, state.Response)
+}
+
+func fetchUpdateCheck() (*updateCheckResponse, error) {
+	resp, err := http.Get(fmt.Sprintf("%s?version=%s", updateCheckURL, packer.Version))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("update check returned status %d", resp.StatusCode)
+	}
+
+	var response updateCheckResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func updateMessages(c *config, response *updateCheckResponse) ([]string, error) {
+	messages := make([]string, 0)
+	if response == nil {
+		return messages, nil
+	}
+
+	if response.CurrentVersion != "" {
+		result, err := packer.CompareVersions(packer.Version, response.CurrentVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		// A pre-release is older than the release of the same version
+		if result < 0 || (result == 0 && packer.VersionPrerelease != "") {
+			messages = append(messages, fmt.Sprintf(
+				"Packer %s is available, and this is Packer v%s. Download it at: %s",
+				response.CurrentVersion, packer.Version, response.CurrentDownloadURL))
+		}
+	}
+
+	if len(response.BrokenPlugins) == 0 {
+		return messages, nil
+	}
+
+	broken := make(map[string]string)
+	for _, plugin := range response.BrokenPlugins {
+		broken[strings.ToLower(plugin.SHA256)] = plugin.Message
+	}
+
+	for _, path := range c.PluginPaths() {
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			log.Printf("[DEBUG] Not checking plugin %s: %s", path, err)
+			continue
+		}
+
+		if message, ok := broken[checksum]; ok {
+			messages = append(messages, fmt.Sprintf(
+				"The plugin %s is a version that is known to be broken: %s", path, message))
+		}
+	}
+
+	return messages, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readUpdateCheckState(path string) (*updateCheckState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state updateCheckState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func writeUpdateCheckState(path string, state *updateCheckState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}